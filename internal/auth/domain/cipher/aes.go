@@ -5,7 +5,6 @@ package cipher
 
 import (
 	"context"
-	"encoding/hex"
 
 	"github.com/gtank/cryptopasta"
 
@@ -40,8 +39,13 @@ func (a *AES) Encrypt(ctx context.Context, message []byte) (string, error) {
 		return "", errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to encrypt message because no cipher secrets were configured."))
 	}
 
-	ciphertext, err := cryptopasta.Encrypt(message, &a.c.Config().SecretsCipher(ctx)[0])
-	return hex.EncodeToString(ciphertext), errors.WithStack(err)
+	secret := a.c.Config().SecretsCipher(ctx)[0]
+	ciphertext, err := cryptopasta.Encrypt(message, &secret)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return encodeCiphertext(&secret, ciphertext), nil
 }
 
 // Decrypt returns the decrypted aes data
@@ -57,9 +61,9 @@ func (a *AES) Decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
 		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decipher the encrypted message because no AES secrets were configured."))
 	}
 
-	decode, err := hex.DecodeString(ciphertext)
+	secrets, decode, err := decodeCiphertext(secrets, ciphertext)
 	if err != nil {
-		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err))
+		return nil, err
 	}
 
 	for i := range secrets {
@@ -71,3 +75,7 @@ func (a *AES) Decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
 
 	return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decipher the encrypted message."))
 }
+
+func (a *AES) NeedsReencryption(ctx context.Context, ciphertext string) bool {
+	return needsReencryption(a.c.Config().SecretsCipher(ctx), ciphertext)
+}
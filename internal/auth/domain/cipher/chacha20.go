@@ -6,7 +6,6 @@ package cipher
 import (
 	"context"
 	"crypto/rand"
-	"encoding/hex"
 	"io"
 
 	"github.com/pkg/errors"
@@ -38,7 +37,8 @@ func (c *XChaCha20Poly1305) Encrypt(ctx context.Context, message []byte) (string
 		return "", errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to encrypt message because no cipher secrets were configured."))
 	}
 
-	aead, err := chacha20poly1305.NewX(c.c.Config().SecretsCipher(ctx)[0][:])
+	secret := c.c.Config().SecretsCipher(ctx)[0]
+	aead, err := chacha20poly1305.NewX(secret[:])
 	if err != nil {
 		return "", herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to generate key")
 	}
@@ -50,7 +50,7 @@ func (c *XChaCha20Poly1305) Encrypt(ctx context.Context, message []byte) (string
 	}
 
 	encryptedMsg := aead.Seal(nonce, nonce, message, nil)
-	return hex.EncodeToString(encryptedMsg), nil
+	return encodeCiphertext(&secret, encryptedMsg), nil
 }
 
 // Decrypt decrypts data using 256 bit key
@@ -64,9 +64,9 @@ func (c *XChaCha20Poly1305) Decrypt(ctx context.Context, ciphertext string) ([]b
 		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decipher the encrypted message because no cipher secrets were configured."))
 	}
 
-	rawCiphertext, err := hex.DecodeString(ciphertext)
+	secrets, rawCiphertext, err := decodeCiphertext(secrets, ciphertext)
 	if err != nil {
-		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to decode hex encrypted string"))
+		return nil, err
 	}
 
 	for i := range secrets {
@@ -88,3 +88,7 @@ func (c *XChaCha20Poly1305) Decrypt(ctx context.Context, ciphertext string) ([]b
 
 	return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decrypt string"))
 }
+
+func (c *XChaCha20Poly1305) NeedsReencryption(ctx context.Context, ciphertext string) bool {
+	return needsReencryption(c.c.Config().SecretsCipher(ctx), ciphertext)
+}
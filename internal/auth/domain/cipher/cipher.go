@@ -3,7 +3,16 @@
 
 package cipher
 
-import "context"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+)
 
 // Cipher provides methods for encrypt and decrypt string
 type Cipher interface {
@@ -18,8 +27,63 @@ type Cipher interface {
 	//
 	// If the ciphertext is empty a nil byte slice is returned.
 	Decrypt(ctx context.Context, encrypted string) ([]byte, error)
+
+	// NeedsReencryption returns whether the ciphertext was not encrypted with the current secret, so that it
+	// should be decrypted and encrypted again before the old secret is retired.
+	NeedsReencryption(ctx context.Context, encrypted string) bool
 }
 
 type Provider interface {
 	Cipher(ctx context.Context) Cipher
 }
+
+// secretIDSeparator separates the ID of the secret from the hex-encoded ciphertext. Ciphertexts which were
+// encrypted before secret IDs were introduced do not contain it.
+const secretIDSeparator = ":"
+
+// secretID derives a short, non-secret identifier from a cipher secret.
+func secretID(secret *[32]byte) string {
+	sum := sha256.Sum256(secret[:])
+	return hex.EncodeToString(sum[:4])
+}
+
+// encodeCiphertext prefixes the hex-encoded ciphertext with the ID of the secret used to encrypt it.
+func encodeCiphertext(secret *[32]byte, ciphertext []byte) string {
+	return secretID(secret) + secretIDSeparator + hex.EncodeToString(ciphertext)
+}
+
+// decodeCiphertext returns the binary ciphertext and the secrets which may have been used to encrypt it. If the
+// ciphertext carries a secret ID, only the matching secret is returned. Otherwise all secrets are returned.
+func decodeCiphertext(secrets [][32]byte, encrypted string) ([][32]byte, []byte, error) {
+	candidates := secrets
+	id, encoded, found := strings.Cut(encrypted, secretIDSeparator)
+	if !found {
+		encoded = encrypted
+	} else {
+		candidates = nil
+		for i := range secrets {
+			if secretID(&secrets[i]) == id {
+				candidates = append(candidates, secrets[i])
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to decipher the encrypted message because the cipher secret %q is no longer configured.", id))
+		}
+	}
+
+	raw, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to decode hex encrypted string"))
+	}
+
+	return candidates, raw, nil
+}
+
+func needsReencryption(secrets [][32]byte, encrypted string) bool {
+	if len(encrypted) == 0 || len(secrets) == 0 {
+		return false
+	}
+
+	id, _, found := strings.Cut(encrypted, secretIDSeparator)
+	return !found || id != secretID(&secrets[0])
+}
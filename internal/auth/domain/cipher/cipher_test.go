@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,6 +59,38 @@ func TestCipher(t *testing.T) {
 				require.Error(t, err)
 			})
 
+			t.Run("case=secret_rotation", func(t *testing.T) {
+				oldSecret := "old-secret-thirty-two-characters"
+				cfg.MustSet(ctx, config.ViperKeySecretsCipher, []string{oldSecret})
+
+				encrypted, err := c.Encrypt(ctx, []byte("my secret message!"))
+				require.NoError(t, err)
+				assert.False(t, c.NeedsReencryption(ctx, encrypted))
+				assert.False(t, c.NeedsReencryption(ctx, ""))
+
+				// Ciphertexts without a secret ID were encrypted before secret IDs were introduced.
+				_, legacy, found := strings.Cut(encrypted, ":")
+				require.True(t, found)
+
+				cfg.MustSet(ctx, config.ViperKeySecretsCipher, append(goodSecret, oldSecret))
+				for _, ciphertext := range []string{encrypted, legacy} {
+					assert.True(t, c.NeedsReencryption(ctx, ciphertext))
+					decrypted, err := c.Decrypt(ctx, ciphertext)
+					require.NoError(t, err)
+					assert.Equal(t, "my secret message!", string(decrypted))
+				}
+
+				reencrypted, err := c.Encrypt(ctx, []byte("my secret message!"))
+				require.NoError(t, err)
+				assert.False(t, c.NeedsReencryption(ctx, reencrypted))
+
+				cfg.MustSet(ctx, config.ViperKeySecretsCipher, goodSecret)
+				_, err = c.Decrypt(ctx, encrypted)
+				require.Error(t, err)
+				_, err = c.Decrypt(ctx, reencrypted)
+				require.NoError(t, err)
+			})
+
 			t.Run("case=decryption_failed", func(t *testing.T) {
 				// set secret
 				err := cfg.Set(ctx, config.ViperKeySecretsCipher, goodSecret)
@@ -0,0 +1,262 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cipher
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ory/herodot"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+// KeyManager holds the key encryption keys of the envelope cipher. The key encryption keys never leave the key
+// manager, it only wraps and unwraps data keys.
+type KeyManager interface {
+	// CurrentKeyID returns the ID of the key encryption key which wraps new data keys.
+	CurrentKeyID(ctx context.Context) (string, error)
+
+	// WrapKey encrypts the data key with the current key encryption key and returns the ID of that key
+	// together with the wrapped data key.
+	WrapKey(ctx context.Context, key []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts a data key which was wrapped with the key encryption key of the given ID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+type KeyManagerProvider interface {
+	KeyManager(ctx context.Context) KeyManager
+}
+
+const (
+	// envelopePrefix marks ciphertexts of the envelope cipher. They have the format
+	// envelope:<hex key id>:<hex wrapped data key>:<hex nonce and ciphertext>
+	envelopePrefix = "envelope" + secretIDSeparator
+
+	DataKeyScopeRecord  = "record"
+	DataKeyScopeNetwork = "network"
+)
+
+// NetworkIDProvider returns the ID of the network the context belongs to.
+type NetworkIDProvider interface {
+	NetworkID(ctx context.Context) uuid.UUID
+}
+
+type EnvelopeDependencies interface {
+	config.Provider
+	KeyManagerProvider
+	NetworkIDProvider
+}
+
+// Envelope encrypts every value with a data key using XChaCha20-Poly1305 and stores the data key, wrapped by
+// the KeyManager, alongside the ciphertext. Depending on the configured scope, a data key is generated for every
+// record or once per network.
+type Envelope struct {
+	d      EnvelopeDependencies
+	legacy Cipher
+
+	mu sync.Mutex
+	// networkKeys caches the data key used for encryption per network.
+	networkKeys map[string]*dataKey
+	// unwrapped caches unwrapped data keys of the network scope by their wrapped form.
+	unwrapped map[string][]byte
+}
+
+type dataKey struct {
+	keyID   string
+	key     []byte
+	wrapped []byte
+}
+
+// NewCryptEnvelope returns the envelope cipher. If legacy is not nil, it decrypts values which were encrypted
+// before the envelope cipher was enabled.
+func NewCryptEnvelope(d EnvelopeDependencies, legacy Cipher) *Envelope {
+	return &Envelope{
+		d:           d,
+		legacy:      legacy,
+		networkKeys: make(map[string]*dataKey),
+		unwrapped:   make(map[string][]byte),
+	}
+}
+
+// Encrypt encrypts the message with a data key and returns it together with the wrapped data key.
+func (e *Envelope) Encrypt(ctx context.Context, message []byte) (string, error) {
+	if len(message) == 0 {
+		return "", nil
+	}
+
+	dk, err := e.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := chacha20poly1305.NewX(dk.key)
+	if err != nil {
+		return "", errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to initialize the data key"))
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(message)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to generate nonce"))
+	}
+
+	sealed := aead.Seal(nonce, nonce, message, dk.wrapped)
+	return envelopePrefix + strings.Join([]string{
+		hex.EncodeToString([]byte(dk.keyID)),
+		hex.EncodeToString(dk.wrapped),
+		hex.EncodeToString(sealed),
+	}, secretIDSeparator), nil
+}
+
+// Decrypt unwraps the data key of the ciphertext and decrypts the message with it.
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(ciphertext, envelopePrefix) {
+		if e.legacy == nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decipher the encrypted message because it was not encrypted by the envelope cipher and no legacy cipher algorithm was configured."))
+		}
+		return e.legacy.Decrypt(ctx, ciphertext)
+	}
+
+	keyID, wrapped, sealed, err := decodeEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.unwrap(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to initialize the data key"))
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("cipher text too short"))
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, wrapped)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to decipher the encrypted message."))
+	}
+	return plaintext, nil
+}
+
+// NeedsReencryption returns true if the data key of the ciphertext was not wrapped with the current key
+// encryption key, or if the ciphertext was encrypted by the legacy cipher.
+func (e *Envelope) NeedsReencryption(ctx context.Context, ciphertext string) bool {
+	if len(ciphertext) == 0 {
+		return false
+	}
+
+	if !strings.HasPrefix(ciphertext, envelopePrefix) {
+		return true
+	}
+
+	keyID, _, _, err := decodeEnvelope(ciphertext)
+	if err != nil {
+		return false
+	}
+
+	current, err := e.d.KeyManager(ctx).CurrentKeyID(ctx)
+	return err == nil && keyID != current
+}
+
+func (e *Envelope) dataKey(ctx context.Context) (*dataKey, error) {
+	km := e.d.KeyManager(ctx)
+	if e.d.Config().CipherEnvelopeDataKeyScope(ctx) != DataKeyScopeNetwork {
+		return newDataKey(ctx, km)
+	}
+
+	current, err := km.CurrentKeyID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nid := e.d.NetworkID(ctx).String()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if dk, ok := e.networkKeys[nid]; ok && dk.keyID == current {
+		return dk, nil
+	}
+
+	dk, err := newDataKey(ctx, km)
+	if err != nil {
+		return nil, err
+	}
+
+	e.networkKeys[nid] = dk
+	e.unwrapped[string(dk.wrapped)] = dk.key
+	return dk, nil
+}
+
+func (e *Envelope) unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	scoped := e.d.Config().CipherEnvelopeDataKeyScope(ctx) == DataKeyScopeNetwork
+	if scoped {
+		e.mu.Lock()
+		key, ok := e.unwrapped[string(wrapped)]
+		e.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+
+	key, err := e.d.KeyManager(ctx).UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	if scoped {
+		e.mu.Lock()
+		e.unwrapped[string(wrapped)] = key
+		e.mu.Unlock()
+	}
+	return key, nil
+}
+
+func newDataKey(ctx context.Context, km KeyManager) (*dataKey, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to generate data key"))
+	}
+
+	keyID, wrapped, err := km.WrapKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{keyID: keyID, key: key, wrapped: wrapped}, nil
+}
+
+func decodeEnvelope(ciphertext string) (keyID string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(ciphertext, envelopePrefix), secretIDSeparator)
+	if len(parts) != 3 {
+		return "", nil, nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to decode the envelope encrypted string."))
+	}
+
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		if decoded[i], err = hex.DecodeString(part); err != nil {
+			return "", nil, nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to decode hex encrypted string"))
+		}
+	}
+
+	return string(decoded[0]), decoded[1], decoded[2], nil
+}
@@ -0,0 +1,353 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cipher_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/configx"
+	"github.com/ory/x/httpx"
+	"github.com/ory/x/logrusx"
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type envelopeDeps struct {
+	c   *config.Config
+	km  cipher.KeyManager
+	nid uuid.UUID
+}
+
+func (d *envelopeDeps) Config() *config.Config                       { return d.c }
+func (d *envelopeDeps) NetworkID(context.Context) uuid.UUID          { return d.nid }
+func (d *envelopeDeps) KeyManager(context.Context) cipher.KeyManager { return d.km }
+func (d *envelopeDeps) HTTPClient(context.Context, ...httpx.ResilientOptions) *retryablehttp.Client {
+	return retryablehttp.NewClient()
+}
+
+// countingKeyManager counts the number of wrapped and unwrapped data keys.
+type countingKeyManager struct {
+	cipher.KeyManager
+	wraps, unwraps int
+}
+
+func (k *countingKeyManager) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+	k.wraps++
+	return k.KeyManager.WrapKey(ctx, key)
+}
+
+func (k *countingKeyManager) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	k.unwraps++
+	return k.KeyManager.UnwrapKey(ctx, keyID, wrapped)
+}
+
+func newEnvelopeDeps(t *testing.T) *envelopeDeps {
+	return &envelopeDeps{
+		c:   config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation()),
+		nid: uuid.Must(uuid.NewV4()),
+	}
+}
+
+func writeKeyring(t *testing.T, path string, ids ...string) {
+	type key struct {
+		ID  string `json:"id"`
+		Key []byte `json:"key"`
+	}
+
+	var keyring struct {
+		Keys []key `json:"keys"`
+	}
+	for _, id := range ids {
+		k := key{ID: id, Key: make([]byte, chacha20poly1305.KeySize)}
+		// Derive the key from the ID so that rewriting the keyring keeps the keys.
+		copy(k.Key, id)
+		keyring.Keys = append(keyring.Keys, k)
+	}
+
+	raw, err := json.Marshal(keyring)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, raw, 0600))
+}
+
+// newFakeKMS returns a key management service which wraps data keys with in-memory keys.
+func newFakeKMS(t *testing.T, token string) *httptest.Server {
+	var mu sync.Mutex
+	keys := map[string][]byte{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body struct {
+			KeyID      string `json:"key_id"`
+			Plaintext  []byte `json:"plaintext"`
+			Ciphertext []byte `json:"ciphertext"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		key, ok := keys[body.KeyID]
+		if !ok {
+			key = make([]byte, chacha20poly1305.KeySize)
+			_, _ = rand.Read(key)
+			keys[body.KeyID] = key
+		}
+		mu.Unlock()
+
+		aead, err := chacha20poly1305.NewX(key)
+		require.NoError(t, err)
+
+		switch r.URL.Path {
+		case "/kms/wrap":
+			nonce := make([]byte, aead.NonceSize())
+			_, _ = rand.Read(nonce)
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"ciphertext": aead.Seal(nonce, nonce, body.Plaintext, nil)})
+		case "/kms/unwrap":
+			if len(body.Ciphertext) < aead.NonceSize() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			plaintext, err := aead.Open(nil, body.Ciphertext[:aead.NonceSize()], body.Ciphertext[aead.NonceSize():], nil)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"plaintext": plaintext})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	message := []byte("my secret message!")
+
+	t.Run("key_manager=keyring", func(t *testing.T) {
+		d := newEnvelopeDeps(t)
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeKeyringPath, path)
+		d.km = cipher.NewKeyringKeyManager(d)
+		c := cipher.NewCryptEnvelope(d, nil)
+
+		_, err := c.Encrypt(ctx, message)
+		require.Error(t, err, "the keyring does not exist")
+
+		writeKeyring(t, path, "old")
+		encrypted, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(encrypted, "envelope:"))
+		assert.False(t, c.NeedsReencryption(ctx, encrypted))
+
+		decrypted, err := c.Decrypt(ctx, encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, message, decrypted)
+
+		t.Run("case=rotation", func(t *testing.T) {
+			writeKeyring(t, path, "new", "old")
+			// Make sure the modification is detected even on file systems with a coarse modification time.
+			require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(1e9)))
+
+			assert.True(t, c.NeedsReencryption(ctx, encrypted))
+			decrypted, err := c.Decrypt(ctx, encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, message, decrypted)
+
+			reencrypted, err := c.Encrypt(ctx, decrypted)
+			require.NoError(t, err)
+			assert.False(t, c.NeedsReencryption(ctx, reencrypted))
+
+			writeKeyring(t, path, "new")
+			require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2e9)))
+
+			_, err = c.Decrypt(ctx, encrypted)
+			require.Error(t, err)
+			decrypted, err = c.Decrypt(ctx, reencrypted)
+			require.NoError(t, err)
+			assert.Equal(t, message, decrypted)
+		})
+
+		t.Run("case=tampered", func(t *testing.T) {
+			encrypted, err := c.Encrypt(ctx, message)
+			require.NoError(t, err)
+
+			_, err = c.Decrypt(ctx, encrypted[:len(encrypted)-2]+"00")
+			require.Error(t, err)
+			_, err = c.Decrypt(ctx, "envelope:00")
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("case=data_key_scope", func(t *testing.T) {
+		d := newEnvelopeDeps(t)
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		writeKeyring(t, path, "kek")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeKeyringPath, path)
+		km := &countingKeyManager{KeyManager: cipher.NewKeyringKeyManager(d)}
+		d.km = km
+		c := cipher.NewCryptEnvelope(d, nil)
+
+		wrappedKey := func(encrypted string) string {
+			return strings.Split(encrypted, ":")[2]
+		}
+
+		first, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		second, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		assert.NotEqual(t, wrappedKey(first), wrappedKey(second), "every record has its own data key")
+		assert.Equal(t, 2, km.wraps)
+
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeDataKeyScope, cipher.DataKeyScopeNetwork)
+		first, err = c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		second, err = c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		assert.Equal(t, wrappedKey(first), wrappedKey(second), "the network shares a data key")
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, 3, km.wraps)
+
+		d.nid = uuid.Must(uuid.NewV4())
+		other, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+		assert.NotEqual(t, wrappedKey(first), wrappedKey(other), "every network has its own data key")
+		assert.Equal(t, 4, km.wraps)
+
+		for _, encrypted := range []string{first, second, other} {
+			decrypted, err := c.Decrypt(ctx, encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, message, decrypted)
+		}
+		assert.Zero(t, km.unwraps, "the data key of the network is cached")
+	})
+
+	t.Run("case=legacy", func(t *testing.T) {
+		d := newEnvelopeDeps(t)
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		writeKeyring(t, path, "kek")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeKeyringPath, path)
+		d.c.MustSet(ctx, config.ViperKeySecretsCipher, []string{"secret-thirty-two-character-long"})
+		d.km = cipher.NewKeyringKeyManager(d)
+
+		legacy, err := cipher.NewCryptChaCha20(d).Encrypt(ctx, message)
+		require.NoError(t, err)
+
+		_, err = cipher.NewCryptEnvelope(d, nil).Decrypt(ctx, legacy)
+		require.Error(t, err)
+
+		c := cipher.NewCryptEnvelope(d, cipher.NewCryptChaCha20(d))
+		assert.True(t, c.NeedsReencryption(ctx, legacy))
+		decrypted, err := c.Decrypt(ctx, legacy)
+		require.NoError(t, err)
+		assert.Equal(t, message, decrypted)
+	})
+
+	t.Run("key_manager=pkcs11", func(t *testing.T) {
+		d := newEnvelopeDeps(t)
+		tokenPath := filepath.Join(t.TempDir(), "token.json")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopePKCS11PIN, "1234")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopePKCS11KeyLabel, "kek-1")
+		newKeyManager := func() *cipher.PKCS11 {
+			return cipher.NewPKCS11KeyManager(d, func(context.Context) cipher.PKCS11Token {
+				return cipher.NewSoftToken(tokenPath)
+			})
+		}
+		initKey := func(t *testing.T, km *cipher.PKCS11, expected string) {
+			label, created, err := km.InitKey(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, expected, label)
+			assert.True(t, created)
+
+			_, created, err = km.InitKey(ctx)
+			require.NoError(t, err)
+			assert.False(t, created, "existing keys are kept")
+		}
+
+		km := newKeyManager()
+		d.km = km
+		c := cipher.NewCryptEnvelope(d, nil)
+		_, err := c.Encrypt(ctx, message)
+		require.ErrorIs(t, err, cipher.ErrPKCS11KeyNotFound, "keys are not generated implicitly")
+		assert.Contains(t, herodot.ToDefaultError(err, "").Reason(), "kratos secrets init")
+
+		initKey(t, km, "kek-1")
+		encrypted, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopePKCS11KeyLabel, "kek-2")
+		initKey(t, km, "kek-2")
+		assert.True(t, c.NeedsReencryption(ctx, encrypted))
+		reencrypted, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+
+		// Keys generated by another process, for example "kratos secrets init", are found without logging in again.
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopePKCS11KeyLabel, "kek-3")
+		initKey(t, newKeyManager(), "kek-3")
+		_, err = c.Encrypt(ctx, message)
+		require.NoError(t, err)
+
+		// The keys are persisted in the token.
+		d.km = newKeyManager()
+		for _, ciphertext := range []string{encrypted, reencrypted} {
+			decrypted, err := c.Decrypt(ctx, ciphertext)
+			require.NoError(t, err)
+			assert.Equal(t, message, decrypted)
+		}
+
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopePKCS11PIN, "4321")
+		d.km = newKeyManager()
+		_, err = c.Decrypt(ctx, encrypted)
+		require.ErrorIs(t, err, cipher.ErrPKCS11PINIncorrect)
+	})
+
+	t.Run("key_manager=http", func(t *testing.T) {
+		d := newEnvelopeDeps(t)
+		ts := newFakeKMS(t, "kms-token")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeHTTPURL, ts.URL+"/kms")
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeHTTPKeyID, "kek-1")
+		d.km = cipher.NewHTTPKeyManager(d)
+		c := cipher.NewCryptEnvelope(d, nil)
+
+		_, err := c.Encrypt(ctx, message)
+		require.Error(t, err, "the bearer token is missing")
+
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeHTTPToken, "kms-token")
+		encrypted, err := c.Encrypt(ctx, message)
+		require.NoError(t, err)
+
+		decrypted, err := c.Decrypt(ctx, encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, message, decrypted)
+
+		d.c.MustSet(ctx, config.ViperKeyCipherEnvelopeHTTPKeyID, "kek-2")
+		assert.True(t, c.NeedsReencryption(ctx, encrypted))
+		decrypted, err = c.Decrypt(ctx, encrypted)
+		require.NoError(t, err, "the data key is unwrapped with the key it was wrapped with")
+		assert.Equal(t, message, decrypted)
+	})
+}
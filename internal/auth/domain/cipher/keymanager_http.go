@@ -0,0 +1,132 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cipher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type HTTPKeyManagerDependencies interface {
+	config.Provider
+	x.HTTPClientProvider
+}
+
+// HTTPKeyManager is a KeyManager which delegates wrapping and unwrapping of data keys to a key management
+// service. It sends
+//
+//	POST <url>/wrap   {"key_id": "...", "plaintext": "<base64>"}  and expects {"ciphertext": "<base64>"}
+//	POST <url>/unwrap {"key_id": "...", "ciphertext": "<base64>"} and expects {"plaintext": "<base64>"}
+//
+// with the configured bearer token. Versioning of the key encryption keys is left to the key management service.
+type HTTPKeyManager struct {
+	d HTTPKeyManagerDependencies
+}
+
+type httpKeyManagerRequest struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type httpKeyManagerResponse struct {
+	Plaintext  []byte `json:"plaintext"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func NewHTTPKeyManager(d HTTPKeyManagerDependencies) *HTTPKeyManager {
+	return &HTTPKeyManager{d: d}
+}
+
+func (k *HTTPKeyManager) CurrentKeyID(ctx context.Context) (string, error) {
+	keyID := k.d.Config().CipherEnvelopeHTTPKMS(ctx).KeyID
+	if keyID == "" {
+		return "", errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to wrap the data key because no key management service key ID was configured."))
+	}
+	return keyID, nil
+}
+
+func (k *HTTPKeyManager) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+	keyID, err := k.CurrentKeyID(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := k.do(ctx, "wrap", &httpKeyManagerRequest{KeyID: keyID, Plaintext: key})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(res.Ciphertext) == 0 {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("The key management service returned an empty wrapped data key."))
+	}
+	return keyID, res.Ciphertext, nil
+}
+
+func (k *HTTPKeyManager) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	res, err := k.do(ctx, "unwrap", &httpKeyManagerRequest{KeyID: keyID, Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Plaintext) == 0 {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("The key management service returned an empty data key."))
+	}
+	return res.Plaintext, nil
+}
+
+func (k *HTTPKeyManager) do(ctx context.Context, operation string, body *httpKeyManagerRequest) (*httpKeyManagerResponse, error) {
+	conf := k.d.Config().CipherEnvelopeHTTPKMS(ctx)
+	if conf.URL == nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to reach the key management service because no URL was configured."))
+	}
+
+	endpoint, err := url.JoinPath(conf.URL.String(), operation)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to build the key management service URL: %s", err))
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+conf.Token)
+	}
+
+	res, err := k.d.HTTPClient(ctx).Do(req)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to reach the key management service: %s", err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("The key management service was unable to %s the data key and responded with status code %d.", operation, res.StatusCode).WithDebug(string(detail)))
+	}
+
+	var decoded httpKeyManagerResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&decoded); err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to decode the response of the key management service: %s", err))
+	}
+	return &decoded, nil
+}
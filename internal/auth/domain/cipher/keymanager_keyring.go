@@ -0,0 +1,148 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cipher
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/ory/herodot"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type KeyringConfiguration interface {
+	config.Provider
+}
+
+// Keyring is a KeyManager which reads the key encryption keys from a local JSON file:
+//
+//	{"keys": [{"id": "2024-01", "key": "<base64 encoded 32 bytes>"}]}
+//
+// The first key wraps new data keys, all keys unwrap them. The file is read again when it was modified.
+type Keyring struct {
+	c KeyringConfiguration
+
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	keys    []keyringKey
+}
+
+type keyringKey struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+func NewKeyringKeyManager(c KeyringConfiguration) *Keyring {
+	return &Keyring{c: c}
+}
+
+func (k *Keyring) CurrentKeyID(ctx context.Context) (string, error) {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	return keys[0].ID, nil
+}
+
+func (k *Keyring) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(keys[0].Key)
+	if err != nil {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to initialize the key encryption key"))
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to generate nonce"))
+	}
+
+	return keys[0].ID, aead.Seal(nonce, nonce, key, []byte(keys[0].ID)), nil
+}
+
+func (k *Keyring) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kek := range keys {
+		if kek.ID != keyID {
+			continue
+		}
+
+		aead, err := chacha20poly1305.NewX(kek.Key)
+		if err != nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to initialize the key encryption key"))
+		}
+
+		if len(wrapped) < aead.NonceSize() {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("wrapped data key too short"))
+		}
+
+		key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(kek.ID))
+		if err != nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReason("Unable to unwrap the data key."))
+		}
+		return key, nil
+	}
+
+	return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to unwrap the data key because the key %q is not in the keyring.", keyID))
+}
+
+func (k *Keyring) load(ctx context.Context) ([]keyringKey, error) {
+	path := k.c.Config().CipherEnvelopeKeyringPath(ctx)
+	if path == "" {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to load the keyring because no keyring path was configured."))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to load the keyring: %s", err))
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.path == path && k.modTime.Equal(info.ModTime()) {
+		return k.keys, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to load the keyring: %s", err))
+	}
+
+	var keyring struct {
+		Keys []keyringKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &keyring); err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to decode the keyring: %s", err))
+	}
+
+	if len(keyring.Keys) == 0 {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to load the keyring because it contains no keys."))
+	}
+
+	for _, kek := range keyring.Keys {
+		if kek.ID == "" || len(kek.Key) != chacha20poly1305.KeySize {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Every key in the keyring needs an ID and must be exactly %d bytes long.", chacha20poly1305.KeySize))
+		}
+	}
+
+	k.path, k.modTime, k.keys = path, info.ModTime(), keyring.Keys
+	return k.keys, nil
+}
@@ -0,0 +1,366 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cipher
+
+import (
+	"context"
+	stdcipher "crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/ory/herodot"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+var (
+	ErrPKCS11KeyNotFound    = errors.New("the token does not contain a key with this label")
+	ErrPKCS11PINIncorrect   = errors.New("the PIN of the token is incorrect")
+	ErrPKCS11NotLoggedIn    = errors.New("the session is not logged in to the token")
+	ErrPKCS11TokenCorrupted = errors.New("the token could not be decoded")
+)
+
+// PKCS11Handle references a key object of a PKCS11Token.
+type PKCS11Handle uint
+
+// PKCS11Token is the subset of a PKCS#11 token which is required to wrap data keys. Its methods correspond to
+// C_Login, C_FindObjects, C_GenerateKey, C_WrapKey and C_UnwrapKey. The keys never leave the token.
+type PKCS11Token interface {
+	Login(ctx context.Context, pin string) error
+	FindKey(ctx context.Context, label string) (PKCS11Handle, error)
+	GenerateKey(ctx context.Context, label string) (PKCS11Handle, error)
+	WrapKey(ctx context.Context, h PKCS11Handle, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, h PKCS11Handle, wrapped []byte) ([]byte, error)
+}
+
+type PKCS11Configuration interface {
+	config.Provider
+}
+
+// PKCS11 is a KeyManager which wraps data keys with a key of a PKCS11Token. The label of the key is used as
+// the key ID. The key is never generated implicitly, use InitKey to generate it.
+type PKCS11 struct {
+	c     PKCS11Configuration
+	token func(ctx context.Context) PKCS11Token
+
+	mu       sync.Mutex
+	loggedIn PKCS11Token
+}
+
+// NewPKCS11KeyManager returns a KeyManager for the token returned by token, which is logged in with the
+// configured PIN on first use.
+func NewPKCS11KeyManager(c PKCS11Configuration, token func(ctx context.Context) PKCS11Token) *PKCS11 {
+	return &PKCS11{c: c, token: token}
+}
+
+func (p *PKCS11) CurrentKeyID(ctx context.Context) (string, error) {
+	label := p.c.Config().CipherEnvelopePKCS11(ctx).KeyLabel
+	if label == "" {
+		return "", errors.WithStack(herodot.ErrInternalServerError.WithReason("Unable to wrap the data key because no PKCS#11 key label was configured."))
+	}
+	return label, nil
+}
+
+func (p *PKCS11) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+	label, err := p.CurrentKeyID(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, h, err := p.findKey(ctx, label)
+	if errors.Is(err, ErrPKCS11KeyNotFound) {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to wrap the data key because the PKCS#11 token does not contain a key with the label %q. Run \"kratos secrets init\" to generate it.", label))
+	} else if err != nil {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to find the PKCS#11 key %q: %s", label, err))
+	}
+
+	wrapped, err := token.WrapKey(ctx, h, key)
+	if err != nil {
+		return "", nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to wrap the data key: %s", err))
+	}
+	return label, wrapped, nil
+}
+
+// InitKey generates the key with the configured label in the token unless the token already contains it. It
+// returns the label and whether the key was generated.
+func (p *PKCS11) InitKey(ctx context.Context) (label string, created bool, err error) {
+	label, err = p.CurrentKeyID(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	token, _, err := p.findKey(ctx, label)
+	if err == nil {
+		return label, false, nil
+	} else if !errors.Is(err, ErrPKCS11KeyNotFound) {
+		return "", false, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to find the PKCS#11 key %q: %s", label, err))
+	}
+
+	if _, err := token.GenerateKey(ctx, label); err != nil {
+		return "", false, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to generate the PKCS#11 key %q: %s", label, err))
+	}
+	return label, true, nil
+}
+
+func (p *PKCS11) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	token, h, err := p.findKey(ctx, keyID)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to find the PKCS#11 key %q: %s", keyID, err))
+	}
+
+	key, err := token.UnwrapKey(ctx, h, wrapped)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to unwrap the data key: %s", err))
+	}
+	return key, nil
+}
+
+// findKey finds the key with the label in the token. The key may have been generated after this process logged
+// in, for example by "kratos secrets init", so a key which is not found is looked up once more in a new session.
+func (p *PKCS11) findKey(ctx context.Context, label string) (PKCS11Token, PKCS11Handle, error) {
+	token, err := p.session(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h, err := token.FindKey(ctx, label)
+	if !errors.Is(err, ErrPKCS11KeyNotFound) {
+		return token, h, err
+	}
+
+	p.mu.Lock()
+	if p.loggedIn == token {
+		p.loggedIn = nil
+	}
+	p.mu.Unlock()
+
+	if token, err = p.session(ctx); err != nil {
+		return nil, 0, err
+	}
+	h, err = token.FindKey(ctx, label)
+	return token, h, err
+}
+
+func (p *PKCS11) session(ctx context.Context) (PKCS11Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.loggedIn != nil {
+		return p.loggedIn, nil
+	}
+
+	token := p.token(ctx)
+	if err := token.Login(ctx, p.c.Config().CipherEnvelopePKCS11(ctx).PIN); err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to log in to the PKCS#11 token: %s", err))
+	}
+
+	p.loggedIn = token
+	return token, nil
+}
+
+// SoftToken is a software implementation of a PKCS11Token for development and testing. Its keys are stored in a
+// file, encrypted with a key derived from the PIN. The token is initialized with the PIN of the first login.
+type SoftToken struct {
+	path string
+
+	mu     sync.Mutex
+	secret []byte
+	salt   []byte
+	labels []string
+	keys   [][]byte
+}
+
+type softTokenFile struct {
+	Salt   []byte `json:"salt"`
+	Sealed []byte `json:"sealed"`
+}
+
+type softTokenObject struct {
+	Label string `json:"label"`
+	Key   []byte `json:"key"`
+}
+
+func NewSoftToken(path string) *SoftToken {
+	return &SoftToken{path: path}
+}
+
+func (t *SoftToken) Login(_ context.Context, pin string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	raw, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Initialize the token, similar to C_InitToken.
+		t.salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, t.salt); err != nil {
+			return errors.WithStack(err)
+		}
+		if t.secret, err = softTokenSecret(pin, t.salt); err != nil {
+			return err
+		}
+		return t.persist()
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var file softTokenFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return errors.WithStack(ErrPKCS11TokenCorrupted)
+	}
+
+	secret, err := softTokenSecret(pin, file.Salt)
+	if err != nil {
+		return err
+	}
+
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(file.Sealed) < aead.NonceSize() {
+		return errors.WithStack(ErrPKCS11TokenCorrupted)
+	}
+
+	plaintext, err := aead.Open(nil, file.Sealed[:aead.NonceSize()], file.Sealed[aead.NonceSize():], file.Salt)
+	if err != nil {
+		return errors.WithStack(ErrPKCS11PINIncorrect)
+	}
+
+	var objects []softTokenObject
+	if err := json.Unmarshal(plaintext, &objects); err != nil {
+		return errors.WithStack(ErrPKCS11TokenCorrupted)
+	}
+
+	t.secret, t.salt, t.labels, t.keys = secret, file.Salt, nil, nil
+	for _, o := range objects {
+		t.labels = append(t.labels, o.Label)
+		t.keys = append(t.keys, o.Key)
+	}
+	return nil
+}
+
+func (t *SoftToken) FindKey(_ context.Context, label string) (PKCS11Handle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.secret == nil {
+		return 0, errors.WithStack(ErrPKCS11NotLoggedIn)
+	}
+
+	for i := range t.labels {
+		if t.labels[i] == label {
+			return PKCS11Handle(i + 1), nil
+		}
+	}
+	return 0, errors.WithStack(ErrPKCS11KeyNotFound)
+}
+
+func (t *SoftToken) GenerateKey(_ context.Context, label string) (PKCS11Handle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.secret == nil {
+		return 0, errors.WithStack(ErrPKCS11NotLoggedIn)
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	t.labels = append(t.labels, label)
+	t.keys = append(t.keys, key)
+	if err := t.persist(); err != nil {
+		t.labels, t.keys = t.labels[:len(t.labels)-1], t.keys[:len(t.keys)-1]
+		return 0, err
+	}
+	return PKCS11Handle(len(t.keys)), nil
+}
+
+func (t *SoftToken) WrapKey(_ context.Context, h PKCS11Handle, key []byte) ([]byte, error) {
+	aead, label, err := t.object(h)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead.Seal(nonce, nonce, key, []byte(label)), nil
+}
+
+func (t *SoftToken) UnwrapKey(_ context.Context, h PKCS11Handle, wrapped []byte) ([]byte, error) {
+	aead, label, err := t.object(h)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key too short")
+	}
+
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(label))
+	return key, errors.WithStack(err)
+}
+
+func (t *SoftToken) object(h PKCS11Handle) (stdcipher.AEAD, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.secret == nil {
+		return nil, "", errors.WithStack(ErrPKCS11NotLoggedIn)
+	}
+
+	if h == 0 || int(h) > len(t.keys) {
+		return nil, "", errors.WithStack(ErrPKCS11KeyNotFound)
+	}
+
+	aead, err := chacha20poly1305.NewX(t.keys[h-1])
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	return aead, t.labels[h-1], nil
+}
+
+// persist writes the token to its file. The caller must hold the lock.
+func (t *SoftToken) persist() error {
+	objects := make([]softTokenObject, len(t.keys))
+	for i := range t.keys {
+		objects[i] = softTokenObject{Label: t.labels[i], Key: t.keys[i]}
+	}
+
+	plaintext, err := json.Marshal(objects)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	aead, err := chacha20poly1305.NewX(t.secret)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.WithStack(err)
+	}
+
+	raw, err := json.Marshal(softTokenFile{Salt: t.salt, Sealed: aead.Seal(nonce, nonce, plaintext, t.salt)})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(t.path, raw, 0600))
+}
+
+func softTokenSecret(pin string, salt []byte) ([]byte, error) {
+	secret, err := scrypt.Key([]byte(pin), salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	return secret, errors.WithStack(err)
+}
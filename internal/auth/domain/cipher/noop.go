@@ -33,3 +33,8 @@ func (c *Noop) Encrypt(_ context.Context, message []byte) (string, error) {
 func (c *Noop) Decrypt(_ context.Context, ciphertext string) ([]byte, error) {
 	return hex.DecodeString(ciphertext)
 }
+
+// NeedsReencryption always returns false because the noop cipher does not use secrets
+func (c *Noop) NeedsReencryption(_ context.Context, _ string) bool {
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cliclient
+
+import (
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/contextx"
+	"github.com/ory/x/flagx"
+	"github.com/ory/x/servicelocatorx"
+	"my.com/secrets/internal/auth/domain/driver"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type PrivacyHandler struct{}
+
+func NewPrivacyHandler() *PrivacyHandler {
+	return &PrivacyHandler{}
+}
+
+func (h *PrivacyHandler) ExportIdentity(cmd *cobra.Command, args []string) error {
+	id, d, err := h.init(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	export, err := d.PrivacyManager().Export(cmd.Context(), id)
+	if err != nil {
+		return errors.Wrap(err, "An error occurred while exporting the identity's data")
+	}
+
+	return h.print(cmd, export)
+}
+
+func (h *PrivacyHandler) EraseIdentity(cmd *cobra.Command, args []string) error {
+	id, d, err := h.init(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	j, err := d.PrivacyManager().Erase(cmd.Context(), id)
+	if err != nil {
+		return errors.Wrap(err, "An error occurred while erasing the identity's data")
+	}
+
+	return h.print(cmd, j)
+}
+
+func (h *PrivacyHandler) init(cmd *cobra.Command, args []string) (uuid.UUID, driver.Registry, error) {
+	if len(args) == 0 {
+		return uuid.Nil, nil, errors.New("expected to get the identity ID as the first argument")
+	}
+
+	id, err := uuid.FromString(args[0])
+	if err != nil {
+		return uuid.Nil, nil, errors.Wrap(err, "the identity ID is not a valid UUID")
+	}
+
+	opts := []configx.OptionModifier{
+		configx.WithFlags(cmd.Flags()),
+		configx.SkipValidation(),
+	}
+
+	if !flagx.MustGetBool(cmd, "read-from-env") {
+		if len(args) != 2 {
+			return uuid.Nil, nil, errors.New(`expected to get the DSN as the second argument, or the "read-from-env" flag`)
+		}
+		opts = append(opts, configx.WithValue(config.ViperKeyDSN, args[1]))
+	}
+
+	d, err := driver.NewWithoutInit(
+		cmd.Context(),
+		cmd.ErrOrStderr(),
+		servicelocatorx.NewOptions(),
+		nil,
+		opts,
+	)
+	if len(d.Config().DSN(cmd.Context())) == 0 {
+		return uuid.Nil, nil, errors.New(`required config value "dsn" was not set`)
+	} else if err != nil {
+		return uuid.Nil, nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	if err := d.Init(cmd.Context(), &contextx.Default{}); err != nil {
+		return uuid.Nil, nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	return id, d, nil
+}
+
+func (h *PrivacyHandler) print(cmd *cobra.Command, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = cmd.OutOrStdout().Write(append(out, '\n'))
+	return errors.WithStack(err)
+}
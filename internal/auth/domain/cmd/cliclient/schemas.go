@@ -0,0 +1,103 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cliclient
+
+import (
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/contextx"
+	"github.com/ory/x/flagx"
+	"github.com/ory/x/servicelocatorx"
+	"my.com/secrets/internal/auth/domain/driver"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/schemamigration"
+)
+
+type SchemaMigrationHandler struct{}
+
+func NewSchemaMigrationHandler() *SchemaMigrationHandler {
+	return &SchemaMigrationHandler{}
+}
+
+func (h *SchemaMigrationHandler) Migrate(cmd *cobra.Command, args []string) error {
+	d, err := h.init(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	var j *job.Job
+	if resume := flagx.MustGetString(cmd, "resume"); resume != "" {
+		id, err := uuid.FromString(resume)
+		if err != nil {
+			return errors.Wrap(err, "the job ID is not a valid UUID")
+		}
+
+		j, err = d.SchemaMigrationManager().Resume(cmd.Context(), id)
+		if err != nil {
+			return errors.Wrap(err, "An error occurred while resuming the migration")
+		}
+	} else {
+		j, err = d.SchemaMigrationManager().Start(cmd.Context(), schemamigration.Options{
+			FromSchemaID: flagx.MustGetString(cmd, "from"),
+			FromVersion:  flagx.MustGetString(cmd, "from-version"),
+			ToSchemaID:   flagx.MustGetString(cmd, "to"),
+			DryRun:       flagx.MustGetBool(cmd, "dry-run"),
+			BatchSize:    flagx.MustGetInt(cmd, "batch-size"),
+		})
+		if err != nil {
+			return errors.Wrap(err, "An error occurred while starting the migration")
+		}
+	}
+
+	if err := d.SchemaMigrationManager().Run(cmd.Context(), j); err != nil {
+		return errors.Wrapf(err, "An error occurred while running the migration job %s", j.ID)
+	}
+
+	out, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = cmd.OutOrStdout().Write(append(out, '\n'))
+	return errors.WithStack(err)
+}
+
+func (h *SchemaMigrationHandler) init(cmd *cobra.Command, args []string) (driver.Registry, error) {
+	opts := []configx.OptionModifier{
+		configx.WithFlags(cmd.Flags()),
+		configx.SkipValidation(),
+	}
+
+	if !flagx.MustGetBool(cmd, "read-from-env") {
+		if len(args) != 1 {
+			return nil, errors.New(`expected to get the DSN as an argument, or the "read-from-env" flag`)
+		}
+		opts = append(opts, configx.WithValue(config.ViperKeyDSN, args[0]))
+	}
+
+	d, err := driver.NewWithoutInit(
+		cmd.Context(),
+		cmd.ErrOrStderr(),
+		servicelocatorx.NewOptions(),
+		nil,
+		opts,
+	)
+	if len(d.Config().DSN(cmd.Context())) == 0 {
+		return nil, errors.New(`required config value "dsn" was not set`)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	if err := d.Init(cmd.Context(), &contextx.Default{}); err != nil {
+		return nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	return d, nil
+}
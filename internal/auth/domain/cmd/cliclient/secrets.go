@@ -0,0 +1,149 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package cliclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/contextx"
+	"github.com/ory/x/flagx"
+	"github.com/ory/x/logrusx"
+	"github.com/ory/x/servicelocatorx"
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/driver"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+type SecretsHandler struct{}
+
+func NewSecretsHandler() *SecretsHandler {
+	return &SecretsHandler{}
+}
+
+func (h *SecretsHandler) Rotate(cmd *cobra.Command, args []string) error {
+	opts := identity.CipherRotationOptions{
+		BatchSize: flagx.MustGetInt(cmd, "batch-size"),
+		DryRun:    flagx.MustGetBool(cmd, "dry-run"),
+		Progress: func(report *identity.CipherRotationReport) {
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Processed %d identities, re-encrypted %d values, %d values remaining. Last identity: %s\n",
+				report.Identities, report.Reencrypted, report.Remaining, report.LastIdentityID)
+		},
+	}
+
+	if after := flagx.MustGetString(cmd, "resume-after"); after != "" {
+		id, err := uuid.FromString(after)
+		if err != nil {
+			return errors.Wrap(err, "the identity ID to resume after is not a valid UUID")
+		}
+		opts.After = id
+	}
+
+	d, err := h.init(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	reencrypted, remaining, err := d.SigningKeyManager().RotateCipherSecrets(cmd.Context(), opts.DryRun)
+	if err != nil {
+		return errors.Wrap(err, "An error occurred while re-encrypting signing keys")
+	}
+	_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Re-encrypted %d signing keys, %d signing keys remaining.\n", reencrypted, remaining)
+
+	report, err := d.IdentityManager().RotateCipherSecrets(cmd.Context(), opts)
+	if err != nil {
+		return errors.Wrap(err, "An error occurred while re-encrypting data")
+	}
+	report.Reencrypted += reencrypted
+	report.Remaining += remaining
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = cmd.OutOrStdout().Write(append(out, '\n'))
+	return errors.WithStack(err)
+}
+
+func (h *SecretsHandler) InitPKCS11(cmd *cobra.Command) error {
+	c, err := config.New(
+		cmd.Context(),
+		logrusx.New("Ory Kratos", config.Version),
+		cmd.ErrOrStderr(),
+		configx.WithFlags(cmd.Flags()),
+		configx.SkipValidation(),
+		configx.WithContext(cmd.Context()),
+	)
+	if err != nil {
+		return errors.Wrap(err, "Unable to initialize the config provider")
+	}
+
+	if km := c.CipherEnvelopeKeyManager(cmd.Context()); km != "pkcs11" {
+		return errors.Errorf(`expected "ciphers.envelope.key_manager" to be "pkcs11" but got %q`, km)
+	}
+
+	km := cipher.NewPKCS11KeyManager(configProvider{c}, func(ctx context.Context) cipher.PKCS11Token {
+		return cipher.NewSoftToken(c.CipherEnvelopePKCS11(ctx).TokenPath)
+	})
+	label, created, err := km.InitKey(cmd.Context())
+	if err != nil {
+		return errors.Wrap(err, "An error occurred while generating the key")
+	}
+
+	if created {
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "Generated the key %q.\n", label)
+	} else {
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "The token already contains the key %q.\n", label)
+	}
+	return errors.WithStack(err)
+}
+
+type configProvider struct {
+	c *config.Config
+}
+
+func (p configProvider) Config() *config.Config {
+	return p.c
+}
+
+func (h *SecretsHandler) init(cmd *cobra.Command, args []string) (driver.Registry, error) {
+	opts := []configx.OptionModifier{
+		configx.WithFlags(cmd.Flags()),
+		configx.SkipValidation(),
+	}
+
+	if !flagx.MustGetBool(cmd, "read-from-env") {
+		if len(args) != 1 {
+			return nil, errors.New(`expected to get the DSN as an argument, or the "read-from-env" flag`)
+		}
+		opts = append(opts, configx.WithValue(config.ViperKeyDSN, args[0]))
+	}
+
+	d, err := driver.NewWithoutInit(
+		cmd.Context(),
+		cmd.ErrOrStderr(),
+		servicelocatorx.NewOptions(),
+		nil,
+		opts,
+	)
+	if len(d.Config().DSN(cmd.Context())) == 0 {
+		return nil, errors.New(`required config value "dsn" was not set`)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	if err := d.Init(cmd.Context(), &contextx.Default{}); err != nil {
+		return nil, errors.Wrap(err, "An error occurred initializing the driver")
+	}
+
+	return d, nil
+}
@@ -72,6 +72,10 @@ func init() {
 		"NewInfoSelfServiceSettingsUpdateUnlinkOIDC":              text.NewInfoSelfServiceSettingsUpdateUnlinkOIDC("{provider}"),
 		"NewInfoSelfServiceRegisterWebAuthnDisplayName":           text.NewInfoSelfServiceRegisterWebAuthnDisplayName(),
 		"NewInfoSelfServiceRemoveWebAuthn":                        text.NewInfoSelfServiceRemoveWebAuthn("{display_name}", aSecondAgo),
+		"NewInfoSelfServiceSettingsDeleteAccount":                 text.NewInfoSelfServiceSettingsDeleteAccount(),
+		"NewInfoSelfServiceSettingsDeleteAccountConfirm":          text.NewInfoSelfServiceSettingsDeleteAccountConfirm(),
+		"NewInfoSelfServiceSettingsAccountDeleted":                text.NewInfoSelfServiceSettingsAccountDeleted(),
+		"NewInfoSelfServiceSettingsAccountDeletionScheduled":      text.NewInfoSelfServiceSettingsAccountDeletionScheduled(inAMinute),
 		"NewErrorValidationVerificationFlowExpired":               text.NewErrorValidationVerificationFlowExpired(aSecondAgo),
 		"NewInfoSelfServiceVerificationSuccessful":                text.NewInfoSelfServiceVerificationSuccessful(),
 		"NewVerificationEmailSent":                                text.NewVerificationEmailSent(),
@@ -102,6 +106,13 @@ func init() {
 		"NewErrorValidationPasswordMinLength":                     text.NewErrorValidationPasswordMinLength(6, 5),
 		"NewErrorValidationPasswordMaxLength":                     text.NewErrorValidationPasswordMaxLength(72, 80),
 		"NewErrorValidationPasswordTooManyBreaches":               text.NewErrorValidationPasswordTooManyBreaches(101),
+		"NewErrorValidationPasswordCharacterClassMissing":         text.NewErrorValidationPasswordCharacterClassMissing("{character_class}"),
+		"NewErrorValidationPasswordTooFewCharacterClasses":        text.NewErrorValidationPasswordTooFewCharacterClasses(3, 2),
+		"NewErrorValidationPasswordTooWeak":                       text.NewErrorValidationPasswordTooWeak(3, 1),
+		"NewErrorValidationPasswordContainsBannedWord":            text.NewErrorValidationPasswordContainsBannedWord("{word}"),
+		"NewErrorValidationPasswordContainsTrait":                 text.NewErrorValidationPasswordContainsTrait(),
+		"NewErrorValidationPasswordReused":                        text.NewErrorValidationPasswordReused(),
+		"NewErrorValidationSettingsPasswordChangeRequired":        text.NewErrorValidationSettingsPasswordChangeRequired(),
 		"NewErrorValidationInvalidCredentials":                    text.NewErrorValidationInvalidCredentials(),
 		"NewErrorValidationDuplicateCredentials":                  text.NewErrorValidationDuplicateCredentials(),
 		"NewErrorValidationDuplicateCredentialsWithHints":         text.NewErrorValidationDuplicateCredentialsWithHints([]string{"{available_credential_types_list}"}, []string{"{available_oidc_providers_list}"}, "{credential_identifier_hint}"),
@@ -169,6 +180,7 @@ func init() {
 		"NewInfoSelfServiceRegistrationRegisterCode":              text.NewInfoSelfServiceRegistrationRegisterCode(),
 		"NewErrorValidationLoginLinkedCredentialsDoNotMatch":      text.NewErrorValidationLoginLinkedCredentialsDoNotMatch(),
 		"NewErrorValidationAddressUnknown":                        text.NewErrorValidationAddressUnknown(),
+		"NewErrorValidationLoginMaxConcurrentSessions":            text.NewErrorValidationLoginMaxConcurrentSessions(5),
 		"NewInfoSelfServiceLoginCodeMFA":                          text.NewInfoSelfServiceLoginCodeMFA(),
 		"NewInfoSelfServiceLoginCodeMFAHint":                      text.NewInfoSelfServiceLoginCodeMFAHint("{maskedIdentifier}"),
 	}
@@ -97,6 +97,7 @@ func servePublic(r driver.Registry, cmd *cobra.Command, eg *errgroup.Group, slOp
 	n.UseFunc(semconv.Middleware)
 	n.Use(publicLogger)
 	n.Use(x.HTTPLoaderContextMiddleware(r))
+	n.UseFunc(session.DPoPContextMiddleware)
 	n.Use(sqa(ctx, cmd, r))
 
 	n.Use(r.PrometheusManager())
@@ -192,6 +193,7 @@ func serveAdmin(r driver.Registry, cmd *cobra.Command, eg *errgroup.Group, slOpt
 	n.Use(adminLogger)
 	n.UseFunc(x.RedirectAdminMiddleware)
 	n.Use(x.HTTPLoaderContextMiddleware(r))
+	n.UseFunc(session.DPoPContextMiddleware)
 	n.Use(sqa(ctx, cmd, r))
 	n.Use(r.PrometheusManager())
 
@@ -325,11 +327,32 @@ func bgTasks(d driver.Registry, cmd *cobra.Command, opts []Option) error {
 	modifiers := NewOptions(cmd.Context(), opts)
 	ctx := modifiers.ctx
 
+	eg, ctx := errgroup.WithContext(ctx)
 	if d.Config().IsBackgroundCourierEnabled(ctx) {
-		return courier.Watch(ctx, d)
+		eg.Go(func() error {
+			return courier.Watch(ctx, d)
+		})
+	}
+
+	eg.Go(func() error {
+		return d.PrivacyManager().WatchScheduledDeletions(ctx)
+	})
+
+	eg.Go(func() error {
+		return d.SigningKeyManager().WatchRotation(ctx)
+	})
+
+	eg.Go(func() error {
+		return d.IdentityManager().WatchPasswordExpiry(ctx)
+	})
+
+	if d.Config().HasherOutdatedReportEnabled(ctx) {
+		eg.Go(func() error {
+			return d.IdentityManager().WatchPasswordHashes(ctx)
+		})
 	}
 
-	return nil
+	return eg.Wait()
 }
 
 func ServeAll(d driver.Registry, slOpts *servicelocatorx.Options, opts []Option) func(cmd *cobra.Command, args []string) error {
@@ -0,0 +1,64 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package breachindex
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"my.com/secrets/internal/auth/domain/selfservice/strategy/password/breachindex"
+)
+
+func newBuildCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "build <source> <index>",
+		Short: "Build a breach index from the Have I Been Pwned password data",
+		Long: `Build a compact breach index from the Have I Been Pwned "pwned passwords" SHA-1 download.
+
+The source is either a directory of range files named after their five character hash
+prefix (as written by the PwnedPasswordsDownloader), or a single file containing one
+"HASH:COUNT" line per password. The hashes must be sorted, which is the case for both
+download formats.
+
+Use the index by setting "selfservice.methods.password.config.haveibeenpwned_source"
+to "local" and "selfservice.methods.password.config.haveibeenpwned_local_path" to the
+index file.`,
+		Example: `kratos hashers breach-index build ./pwnedpasswords ./pwnedpasswords.idx`,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Create(args[1])
+			if err != nil {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Could not create the breach index: %s\n", err)
+				return cmdx.FailSilently(cmd)
+			}
+			defer f.Close()
+
+			w, err := breachindex.NewWriter(f)
+			if err != nil {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Could not write the breach index: %s\n", err)
+				return cmdx.FailSilently(cmd)
+			}
+
+			if err := breachindex.ReadSource(args[0], w.Add); err != nil {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Could not read the password data: %s\n", err)
+				return cmdx.FailSilently(cmd)
+			}
+
+			if err := w.Close(); err != nil {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Could not write the breach index: %s\n", err)
+				return cmdx.FailSilently(cmd)
+			}
+			if err := f.Close(); err != nil {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Could not write the breach index: %s\n", err)
+				return cmdx.FailSilently(cmd)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d password hashes to %s\n", w.Len(), args[1])
+			return nil
+		},
+	}
+}
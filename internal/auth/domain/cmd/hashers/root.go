@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"my.com/secrets/internal/auth/domain/cmd/hashers/argon2"
+	"my.com/secrets/internal/auth/domain/cmd/hashers/breachindex"
 )
 
 func NewRootCmd() *cobra.Command {
@@ -22,4 +23,5 @@ func RegisterCommandRecursive(parent *cobra.Command) {
 	parent.AddCommand(rootCmd)
 
 	argon2.RegisterCommandRecursive(rootCmd)
+	breachindex.RegisterCommandRecursive(rootCmd)
 }
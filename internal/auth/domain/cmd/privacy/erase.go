@@ -0,0 +1,45 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"github.com/ory/x/configx"
+	"my.com/secrets/internal/auth/domain/cmd/cliclient"
+)
+
+func NewEraseCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "erase <identity-id> [<database-url>]",
+		Short: "Erase all data stored about an identity",
+		Long: `Irrecoverably deletes the identity together with its credentials, addresses, sessions, devices,
+and self-service flows. Courier messages sent to the identity keep their delivery status but lose their
+recipient and content. The erasure is tracked as a job; the command prints the job including the
+erasure receipt.
+
+You can read in the database URL using the -e flag, for example:
+	export DSN=...
+	kratos privacy erase <identity-id> -e
+
+### WARNING ###
+This operation can not be undone!
+`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cliclient.NewPrivacyHandler().EraseIdentity(cmd, args); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return cmdx.FailSilently(cmd)
+			}
+			return nil
+		},
+	}
+
+	configx.RegisterFlags(c.PersistentFlags())
+	c.Flags().BoolP("read-from-env", "e", false, "If set, reads the database connection string from the environment variable DSN or config file key dsn.")
+	return c
+}
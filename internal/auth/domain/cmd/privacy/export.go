@@ -0,0 +1,41 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"github.com/ory/x/configx"
+	"my.com/secrets/internal/auth/domain/cmd/cliclient"
+)
+
+func NewExportCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export <identity-id> [<database-url>]",
+		Short: "Export all data stored about an identity",
+		Long: `Prints a JSON bundle of all data stored about the identity: the identity and its addresses,
+the metadata of its credentials, its sessions and devices, the courier messages sent to it, and its
+self-service flow history. Credential secrets are never exported.
+
+You can read in the database URL using the -e flag, for example:
+	export DSN=...
+	kratos privacy export <identity-id> -e
+`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cliclient.NewPrivacyHandler().ExportIdentity(cmd, args); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return cmdx.FailSilently(cmd)
+			}
+			return nil
+		},
+	}
+
+	configx.RegisterFlags(c.PersistentFlags())
+	c.Flags().BoolP("read-from-env", "e", false, "If set, reads the database connection string from the environment variable DSN or config file key dsn.")
+	return c
+}
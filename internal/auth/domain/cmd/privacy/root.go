@@ -0,0 +1,26 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+)
+
+func NewPrivacyCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "privacy",
+		Short: "Export or erase all data stored about an identity",
+	}
+	configx.RegisterFlags(c.PersistentFlags())
+	return c
+}
+
+func RegisterCommandRecursive(parent *cobra.Command) {
+	c := NewPrivacyCmd()
+	parent.AddCommand(c)
+	c.AddCommand(NewExportCmd())
+	c.AddCommand(NewEraseCmd())
+}
@@ -20,7 +20,10 @@ import (
 	"my.com/secrets/internal/auth/domain/cmd/identities"
 	"my.com/secrets/internal/auth/domain/cmd/jsonnet"
 	"my.com/secrets/internal/auth/domain/cmd/migrate"
+	"my.com/secrets/internal/auth/domain/cmd/privacy"
 	"my.com/secrets/internal/auth/domain/cmd/remote"
+	"my.com/secrets/internal/auth/domain/cmd/schemas"
+	"my.com/secrets/internal/auth/domain/cmd/secrets"
 	"my.com/secrets/internal/auth/domain/cmd/serve"
 	"my.com/secrets/internal/auth/domain/driver"
 	"my.com/secrets/internal/auth/domain/driver/config"
@@ -43,7 +46,10 @@ func NewRootCmd(driverOpts ...driver.RegistryOption) (cmd *cobra.Command) {
 	migrate.RegisterCommandRecursive(cmd)
 	serve.RegisterCommandRecursive(cmd, nil, driverOpts)
 	cleanup.RegisterCommandRecursive(cmd)
+	privacy.RegisterCommandRecursive(cmd)
 	remote.RegisterCommandRecursive(cmd)
+	schemas.RegisterCommandRecursive(cmd)
+	secrets.RegisterCommandRecursive(cmd)
 	cmd.AddCommand(identities.NewValidateCmd())
 	cmd.AddCommand(cmdx.Version(&config.Version, &config.Commit, &config.Date))
 
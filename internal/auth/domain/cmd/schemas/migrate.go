@@ -0,0 +1,52 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemas
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"github.com/ory/x/configx"
+	"my.com/secrets/internal/auth/domain/cmd/cliclient"
+)
+
+func NewMigrateCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "migrate [<database-url>]",
+		Short: "Migrate identities to another identity schema or schema version",
+		Long: `Migrates the traits of all identities using a schema ID and version to another schema, or to the
+current version of the same schema. The traits are transformed by the Jsonnet migration configured on
+the target schema, if any, and validated against the target schema. Identities which fail to migrate
+remain unchanged and are reported in the job's result.
+
+The migration is tracked as a job and stores its progress after every batch. If the migration is
+interrupted or fails, continue it with the --resume flag. A migration whose process was killed can be
+resumed once it made no progress for ten minutes.
+
+You can read in the database URL using the -e flag, for example:
+	export DSN=...
+	kratos schemas migrate --from customer --to customer-v2 --dry-run -e
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cliclient.NewSchemaMigrationHandler().Migrate(cmd, args); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return cmdx.FailSilently(cmd)
+			}
+			return nil
+		},
+	}
+
+	configx.RegisterFlags(c.PersistentFlags())
+	c.Flags().BoolP("read-from-env", "e", false, "If set, reads the database connection string from the environment variable DSN or config file key dsn.")
+	c.Flags().String("from", "", "The ID of the schema to migrate from.")
+	c.Flags().String("from-version", "", "The schema version to migrate from. Leave empty to migrate identities which have no schema version.")
+	c.Flags().String("to", "", "The ID of the schema to migrate to. Defaults to the schema to migrate from.")
+	c.Flags().Bool("dry-run", false, "Only validate the migrated traits without updating any identity.")
+	c.Flags().Int("batch-size", 100, "The number of identities migrated per batch.")
+	c.Flags().String("resume", "", "The ID of an interrupted migration job to continue.")
+	return c
+}
@@ -0,0 +1,25 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemas
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+)
+
+func NewSchemasCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "schemas",
+		Short: "Manage identity schemas",
+	}
+	configx.RegisterFlags(c.PersistentFlags())
+	return c
+}
+
+func RegisterCommandRecursive(parent *cobra.Command) {
+	c := NewSchemasCmd()
+	parent.AddCommand(c)
+	c.AddCommand(NewMigrateCmd())
+}
@@ -0,0 +1,39 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"github.com/ory/x/configx"
+	"my.com/secrets/internal/auth/domain/cmd/cliclient"
+)
+
+func NewInitCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "init",
+		Short: "Generate the key encryption key of the PKCS#11 key manager",
+		Long: `Generates the key with the label "ciphers.envelope.pkcs11.key_label" in the PKCS#11 token unless the
+token already contains it. The envelope cipher does not generate keys on its own, so run this command
+before you enable a new key label.
+
+For example:
+	kratos secrets init -c config.yml
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cliclient.NewSecretsHandler().InitPKCS11(cmd); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return cmdx.FailSilently(cmd)
+			}
+			return nil
+		},
+	}
+
+	configx.RegisterFlags(c.PersistentFlags())
+	return c
+}
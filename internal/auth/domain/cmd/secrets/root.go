@@ -0,0 +1,26 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/configx"
+)
+
+func NewSecretsCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the secrets used to encrypt data",
+	}
+	configx.RegisterFlags(c.PersistentFlags())
+	return c
+}
+
+func RegisterCommandRecursive(parent *cobra.Command) {
+	c := NewSecretsCmd()
+	parent.AddCommand(c)
+	c.AddCommand(NewRotateCmd())
+	c.AddCommand(NewInitCmd())
+}
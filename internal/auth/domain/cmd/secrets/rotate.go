@@ -0,0 +1,50 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ory/x/cmdx"
+	"github.com/ory/x/configx"
+	"my.com/secrets/internal/auth/domain/cmd/cliclient"
+)
+
+func NewRotateCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "rotate [<database-url>]",
+		Short: "Re-encrypt all encrypted data with the current cipher secret",
+		Long: `Decrypts all encrypted data which was encrypted with an older cipher secret and encrypts it again
+with the first secret of "secrets.cipher". This covers the private keys of session signing key sets, the
+initial tokens of OpenID Connect credentials, and encrypted identity traits. Credential identifiers and
+addresses which equal an encrypted trait are indexed again with the first secret of "secrets.blind_index".
+
+Signing keys are processed first. Identities are then processed in batches. After every batch, the progress is printed including the ID of the
+last processed identity. Pass it to --resume-after to resume an interrupted rotation. When done, the
+command prints a report. Older secrets can be removed from "secrets.cipher" once the report shows that no
+values remain which are encrypted with them.
+
+You can read in the database URL using the -e flag, for example:
+	export DSN=...
+	kratos secrets rotate -e
+`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cliclient.NewSecretsHandler().Rotate(cmd, args); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return cmdx.FailSilently(cmd)
+			}
+			return nil
+		},
+	}
+
+	configx.RegisterFlags(c.PersistentFlags())
+	c.Flags().BoolP("read-from-env", "e", false, "If set, reads the database connection string from the environment variable DSN or config file key dsn.")
+	c.Flags().Int("batch-size", 100, "The number of identities which are processed at once.")
+	c.Flags().String("resume-after", "", "Resume an interrupted rotation after the identity with this ID.")
+	c.Flags().Bool("dry-run", false, "Only report how many values are not encrypted with the current secret.")
+	return c
+}
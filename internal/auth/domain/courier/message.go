@@ -10,6 +10,7 @@ import (
 
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
 
 	"github.com/ory/herodot"
 	"github.com/ory/x/pagination/keysetpagination"
@@ -191,6 +192,9 @@ type Message struct {
 	Channel sqlxx.NullString `json:"channel" db:"channel"`
 
 	TemplateData []byte `json:"-" db:"template_data"`
+
+	// IdentityID is the ID of the identity the message was sent to, if known. It is only used internally.
+	IdentityID uuid.NullUUID `json:"-" faker:"-" db:"identity_id"`
 	// required: true
 	SendCount int `json:"send_count" db:"send_count"`
 
@@ -206,6 +210,16 @@ type Message struct {
 	UpdatedAt time.Time `json:"updated_at" faker:"-" db:"updated_at"`
 }
 
+// templateIdentityID returns the ID of the identity in the template data, which templates for messages to
+// identities contain.
+func templateIdentityID(templateData []byte) uuid.NullUUID {
+	id, err := uuid.FromString(gjson.GetBytes(templateData, "identity.id").String())
+	if err != nil || id == uuid.Nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}
+}
+
 func (m Message) PageToken() keysetpagination.PageToken {
 	return keysetpagination.MapPageToken{
 		"id":         m.ID.String(),
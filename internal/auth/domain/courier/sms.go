@@ -33,6 +33,7 @@ func (c *courier) QueueSMS(ctx context.Context, t SMSTemplate) (uuid.UUID, error
 		Recipient:    recipient,
 		TemplateType: t.TemplateType(),
 		TemplateData: templateData,
+		IdentityID:   templateIdentityID(templateData),
 		Body:         body,
 	}
 	if err := c.deps.CourierPersister().AddMessage(ctx, message); err != nil {
@@ -122,6 +122,7 @@ func (c *courier) QueueEmail(ctx context.Context, t EmailTemplate) (uuid.UUID, e
 		Subject:      subject,
 		TemplateType: t.TemplateType(),
 		TemplateData: templateData,
+		IdentityID:   templateIdentityID(templateData),
 	}
 
 	if err := c.deps.CourierPersister().AddMessage(ctx, message); err != nil {
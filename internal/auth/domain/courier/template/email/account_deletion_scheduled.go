@@ -0,0 +1,57 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"my.com/secrets/internal/auth/domain/courier/template"
+)
+
+type (
+	AccountDeletionScheduled struct {
+		deps  template.Dependencies
+		model *AccountDeletionScheduledModel
+	}
+	AccountDeletionScheduledModel struct {
+		To          string                 `json:"to"`
+		CancelURL   string                 `json:"cancel_url"`
+		DeleteAfter time.Time              `json:"delete_after"`
+		Identity    map[string]interface{} `json:"identity"`
+	}
+)
+
+func NewAccountDeletionScheduled(d template.Dependencies, m *AccountDeletionScheduledModel) *AccountDeletionScheduled {
+	return &AccountDeletionScheduled{deps: d, model: m}
+}
+
+func (t *AccountDeletionScheduled) EmailRecipient() (string, error) {
+	return t.model.To, nil
+}
+
+func (t *AccountDeletionScheduled) EmailSubject(ctx context.Context) (string, error) {
+	subject, err := template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "account_deletion/scheduled/email.subject.gotmpl", "account_deletion/scheduled/email.subject*", t.model, t.deps.CourierConfig().CourierTemplatesAccountDeletionScheduled(ctx).Subject)
+
+	return strings.TrimSpace(subject), err
+}
+
+func (t *AccountDeletionScheduled) EmailBody(ctx context.Context) (string, error) {
+	return template.LoadHTML(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "account_deletion/scheduled/email.body.gotmpl", "account_deletion/scheduled/email.body*", t.model, t.deps.CourierConfig().CourierTemplatesAccountDeletionScheduled(ctx).Body.HTML)
+}
+
+func (t *AccountDeletionScheduled) EmailBodyPlaintext(ctx context.Context) (string, error) {
+	return template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "account_deletion/scheduled/email.body.plaintext.gotmpl", "account_deletion/scheduled/email.body.plaintext*", t.model, t.deps.CourierConfig().CourierTemplatesAccountDeletionScheduled(ctx).Body.PlainText)
+}
+
+func (t *AccountDeletionScheduled) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.model)
+}
+
+func (t *AccountDeletionScheduled) TemplateType() template.TemplateType {
+	return template.TypeAccountDeletionScheduled
+}
@@ -0,0 +1,57 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"my.com/secrets/internal/auth/domain/courier/template"
+)
+
+type (
+	PasswordExpiryReminder struct {
+		deps  template.Dependencies
+		model *PasswordExpiryReminderModel
+	}
+	PasswordExpiryReminderModel struct {
+		To          string                 `json:"to"`
+		SettingsURL string                 `json:"settings_url"`
+		ExpiresAt   time.Time              `json:"expires_at"`
+		Identity    map[string]interface{} `json:"identity"`
+	}
+)
+
+func NewPasswordExpiryReminder(d template.Dependencies, m *PasswordExpiryReminderModel) *PasswordExpiryReminder {
+	return &PasswordExpiryReminder{deps: d, model: m}
+}
+
+func (t *PasswordExpiryReminder) EmailRecipient() (string, error) {
+	return t.model.To, nil
+}
+
+func (t *PasswordExpiryReminder) EmailSubject(ctx context.Context) (string, error) {
+	subject, err := template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "password_expiry/reminder/email.subject.gotmpl", "password_expiry/reminder/email.subject*", t.model, t.deps.CourierConfig().CourierTemplatesPasswordExpiryReminder(ctx).Subject)
+
+	return strings.TrimSpace(subject), err
+}
+
+func (t *PasswordExpiryReminder) EmailBody(ctx context.Context) (string, error) {
+	return template.LoadHTML(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "password_expiry/reminder/email.body.gotmpl", "password_expiry/reminder/email.body*", t.model, t.deps.CourierConfig().CourierTemplatesPasswordExpiryReminder(ctx).Body.HTML)
+}
+
+func (t *PasswordExpiryReminder) EmailBodyPlaintext(ctx context.Context) (string, error) {
+	return template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "password_expiry/reminder/email.body.plaintext.gotmpl", "password_expiry/reminder/email.body.plaintext*", t.model, t.deps.CourierConfig().CourierTemplatesPasswordExpiryReminder(ctx).Body.PlainText)
+}
+
+func (t *PasswordExpiryReminder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.model)
+}
+
+func (t *PasswordExpiryReminder) TemplateType() template.TemplateType {
+	return template.TypePasswordExpiryReminder
+}
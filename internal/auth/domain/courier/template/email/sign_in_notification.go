@@ -0,0 +1,61 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"my.com/secrets/internal/auth/domain/courier/template"
+)
+
+type (
+	SignInNotification struct {
+		deps  template.Dependencies
+		model *SignInNotificationModel
+	}
+	SignInNotificationModel struct {
+		To          string                 `json:"to"`
+		SettingsURL string                 `json:"settings_url"`
+		SignedInAt  time.Time              `json:"signed_in_at"`
+		IPAddress   string                 `json:"ip_address"`
+		UserAgent   string                 `json:"user_agent"`
+		Location    string                 `json:"location"`
+		Reasons     []string               `json:"reasons"`
+		Identity    map[string]interface{} `json:"identity"`
+	}
+)
+
+func NewSignInNotification(d template.Dependencies, m *SignInNotificationModel) *SignInNotification {
+	return &SignInNotification{deps: d, model: m}
+}
+
+func (t *SignInNotification) EmailRecipient() (string, error) {
+	return t.model.To, nil
+}
+
+func (t *SignInNotification) EmailSubject(ctx context.Context) (string, error) {
+	subject, err := template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "new_sign_in/notification/email.subject.gotmpl", "new_sign_in/notification/email.subject*", t.model, t.deps.CourierConfig().CourierTemplatesNewSignInNotification(ctx).Subject)
+
+	return strings.TrimSpace(subject), err
+}
+
+func (t *SignInNotification) EmailBody(ctx context.Context) (string, error) {
+	return template.LoadHTML(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "new_sign_in/notification/email.body.gotmpl", "new_sign_in/notification/email.body*", t.model, t.deps.CourierConfig().CourierTemplatesNewSignInNotification(ctx).Body.HTML)
+}
+
+func (t *SignInNotification) EmailBodyPlaintext(ctx context.Context) (string, error) {
+	return template.LoadText(ctx, t.deps, os.DirFS(t.deps.CourierConfig().CourierTemplatesRoot(ctx)), "new_sign_in/notification/email.body.plaintext.gotmpl", "new_sign_in/notification/email.body.plaintext*", t.model, t.deps.CourierConfig().CourierTemplatesNewSignInNotification(ctx).Body.PlainText)
+}
+
+func (t *SignInNotification) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.model)
+}
+
+func (t *SignInNotification) TemplateType() template.TemplateType {
+	return template.TypeNewSignInNotification
+}
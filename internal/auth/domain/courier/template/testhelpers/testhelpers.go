@@ -87,6 +87,12 @@ func TestRemoteTemplates(t *testing.T, basePath string, tmplType template.Templa
 			return email.NewLoginCodeValid(d, &email.LoginCodeValidModel{})
 		case template.TypeRegistrationCodeValid:
 			return email.NewRegistrationCodeValid(d, &email.RegistrationCodeValidModel{})
+		case template.TypeAccountDeletionScheduled:
+			return email.NewAccountDeletionScheduled(d, &email.AccountDeletionScheduledModel{})
+		case template.TypePasswordExpiryReminder:
+			return email.NewPasswordExpiryReminder(d, &email.PasswordExpiryReminderModel{})
+		case template.TypeNewSignInNotification:
+			return email.NewSignInNotification(d, &email.SignInNotificationModel{})
 		default:
 			return nil
 		}
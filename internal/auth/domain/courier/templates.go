@@ -97,6 +97,24 @@ func NewEmailTemplateFromMessage(d template.Dependencies, msg Message) (EmailTem
 			return nil, err
 		}
 		return email.NewRegistrationCodeValid(d, &t), nil
+	case template.TypeAccountDeletionScheduled:
+		var t email.AccountDeletionScheduledModel
+		if err := json.Unmarshal(msg.TemplateData, &t); err != nil {
+			return nil, err
+		}
+		return email.NewAccountDeletionScheduled(d, &t), nil
+	case template.TypePasswordExpiryReminder:
+		var t email.PasswordExpiryReminderModel
+		if err := json.Unmarshal(msg.TemplateData, &t); err != nil {
+			return nil, err
+		}
+		return email.NewPasswordExpiryReminder(d, &t), nil
+	case template.TypeNewSignInNotification:
+		var t email.SignInNotificationModel
+		if err := json.Unmarshal(msg.TemplateData, &t); err != nil {
+			return nil, err
+		}
+		return email.NewSignInNotification(d, &t), nil
 	default:
 		return nil, errors.Errorf("received unexpected message template type: %s", msg.TemplateType)
 	}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -22,17 +23,20 @@ func TestNewEmailTemplateFromMessage(t *testing.T) {
 	ctx := context.Background()
 
 	for tmplType, expectedTmpl := range map[template.TemplateType]courier.EmailTemplate{
-		template.TypeRecoveryInvalid:         email.NewRecoveryInvalid(reg, &email.RecoveryInvalidModel{To: "foo"}),
-		template.TypeRecoveryValid:           email.NewRecoveryValid(reg, &email.RecoveryValidModel{To: "bar", RecoveryURL: "http://foo.bar"}),
-		template.TypeRecoveryCodeValid:       email.NewRecoveryCodeValid(reg, &email.RecoveryCodeValidModel{To: "bar", RecoveryCode: "12345678"}),
-		template.TypeRecoveryCodeInvalid:     email.NewRecoveryCodeInvalid(reg, &email.RecoveryCodeInvalidModel{To: "bar"}),
-		template.TypeVerificationInvalid:     email.NewVerificationInvalid(reg, &email.VerificationInvalidModel{To: "baz"}),
-		template.TypeVerificationValid:       email.NewVerificationValid(reg, &email.VerificationValidModel{To: "faz", VerificationURL: "http://bar.foo"}),
-		template.TypeVerificationCodeInvalid: email.NewVerificationCodeInvalid(reg, &email.VerificationCodeInvalidModel{To: "baz"}),
-		template.TypeVerificationCodeValid:   email.NewVerificationCodeValid(reg, &email.VerificationCodeValidModel{To: "faz", VerificationURL: "http://bar.foo", VerificationCode: "123456678"}),
-		template.TypeTestStub:                email.NewTestStub(reg, &email.TestStubModel{To: "far", Subject: "test subject", Body: "test body"}),
-		template.TypeLoginCodeValid:          email.NewLoginCodeValid(reg, &email.LoginCodeValidModel{To: "far", LoginCode: "123456"}),
-		template.TypeRegistrationCodeValid:   email.NewRegistrationCodeValid(reg, &email.RegistrationCodeValidModel{To: "far", RegistrationCode: "123456"}),
+		template.TypeRecoveryInvalid:          email.NewRecoveryInvalid(reg, &email.RecoveryInvalidModel{To: "foo"}),
+		template.TypeRecoveryValid:            email.NewRecoveryValid(reg, &email.RecoveryValidModel{To: "bar", RecoveryURL: "http://foo.bar"}),
+		template.TypeRecoveryCodeValid:        email.NewRecoveryCodeValid(reg, &email.RecoveryCodeValidModel{To: "bar", RecoveryCode: "12345678"}),
+		template.TypeRecoveryCodeInvalid:      email.NewRecoveryCodeInvalid(reg, &email.RecoveryCodeInvalidModel{To: "bar"}),
+		template.TypeVerificationInvalid:      email.NewVerificationInvalid(reg, &email.VerificationInvalidModel{To: "baz"}),
+		template.TypeVerificationValid:        email.NewVerificationValid(reg, &email.VerificationValidModel{To: "faz", VerificationURL: "http://bar.foo"}),
+		template.TypeVerificationCodeInvalid:  email.NewVerificationCodeInvalid(reg, &email.VerificationCodeInvalidModel{To: "baz"}),
+		template.TypeVerificationCodeValid:    email.NewVerificationCodeValid(reg, &email.VerificationCodeValidModel{To: "faz", VerificationURL: "http://bar.foo", VerificationCode: "123456678"}),
+		template.TypeTestStub:                 email.NewTestStub(reg, &email.TestStubModel{To: "far", Subject: "test subject", Body: "test body"}),
+		template.TypeLoginCodeValid:           email.NewLoginCodeValid(reg, &email.LoginCodeValidModel{To: "far", LoginCode: "123456"}),
+		template.TypeRegistrationCodeValid:    email.NewRegistrationCodeValid(reg, &email.RegistrationCodeValidModel{To: "far", RegistrationCode: "123456"}),
+		template.TypeAccountDeletionScheduled: email.NewAccountDeletionScheduled(reg, &email.AccountDeletionScheduledModel{To: "far", CancelURL: "http://bar.foo", DeleteAfter: time.Now().UTC().Round(time.Second)}),
+		template.TypePasswordExpiryReminder:   email.NewPasswordExpiryReminder(reg, &email.PasswordExpiryReminderModel{To: "far", SettingsURL: "http://bar.foo", ExpiresAt: time.Now().UTC().Round(time.Second)}),
+		template.TypeNewSignInNotification:    email.NewSignInNotification(reg, &email.SignInNotificationModel{To: "far", SettingsURL: "http://bar.foo", SignedInAt: time.Now().UTC().Round(time.Second), Reasons: []string{"new_country"}}),
 	} {
 		t.Run(fmt.Sprintf("case=%s", tmplType), func(t *testing.T) {
 			tmplData, err := json.Marshal(expectedTmpl)
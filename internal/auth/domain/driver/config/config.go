@@ -11,9 +11,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -52,6 +54,8 @@ const (
 	DefaultSQLiteMemoryDSN                                   = "sqlite://file::memory:?_fk=true&cache=shared"
 	DefaultPasswordHashingAlgorithm                          = "argon2"
 	DefaultCipherAlgorithm                                   = "noop"
+	DefaultCipherEnvelopeKeyManager                          = "keyring"
+	DefaultCipherEnvelopeDataKeyScope                        = "record"
 	UnknownVersion                                           = "unknown version"
 	ViperKeyDSN                                              = "dsn"
 	ViperKeyCourierSMTPURL                                   = "courier.smtp.connection_uri"
@@ -72,6 +76,9 @@ const (
 	ViperKeyCourierHTTPRequestConfig                         = "courier.http.request_config"
 	ViperKeyCourierTemplatesLoginCodeValidEmail              = "courier.templates.login_code.valid.email"
 	ViperKeyCourierTemplatesRegistrationCodeValidEmail       = "courier.templates.registration_code.valid.email"
+	ViperKeyCourierTemplatesAccountDeletionScheduledEmail    = "courier.templates.account_deletion.scheduled.email"
+	ViperKeyCourierTemplatesPasswordExpiryReminderEmail      = "courier.templates.password_expiry.reminder.email"
+	ViperKeyCourierTemplatesNewSignInNotificationEmail       = "courier.templates.new_sign_in.notification.email"
 	ViperKeyCourierSMTP                                      = "courier.smtp"
 	ViperKeyCourierSMTPFrom                                  = "courier.smtp.from_address"
 	ViperKeyCourierSMTPFromName                              = "courier.smtp.from_name"
@@ -84,6 +91,8 @@ const (
 	ViperKeySecretsDefault                                   = "secrets.default"
 	ViperKeySecretsCookie                                    = "secrets.cookie"
 	ViperKeySecretsCipher                                    = "secrets.cipher"
+	ViperKeySecretsPepper                                    = "secrets.pepper"
+	ViperKeySecretsBlindIndex                                = "secrets.blind_index"
 	ViperKeyDisablePublicHealthRequestLog                    = "serve.public.request_log.disable_for_health"
 	ViperKeyPublicBaseURL                                    = "serve.public.base_url"
 	ViperKeyPublicPort                                       = "serve.public.port"
@@ -113,10 +122,46 @@ const (
 	ViperKeySessionPath                                      = "session.cookie.path"
 	ViperKeySessionPersistentCookie                          = "session.cookie.persistent"
 	ViperKeySessionTokenizerTemplates                        = "session.whoami.tokenizer.templates"
+	ViperKeySessionTokenizerKeysAlgorithm                    = "session.whoami.tokenizer.keys.algorithm"
+	ViperKeySessionTokenizerKeysCacheTTL                     = "session.whoami.tokenizer.keys.cache_ttl"
+	ViperKeySessionTokenizerKeysRotationInterval             = "session.whoami.tokenizer.keys.rotation.interval"
+	ViperKeySessionTokenizerKeysRotationOverlap              = "session.whoami.tokenizer.keys.rotation.overlap"
+	ViperKeySessionTokenizerKeysRotationCheckInterval        = "session.whoami.tokenizer.keys.rotation.check_interval"
 	ViperKeySessionWhoAmIAAL                                 = "session.whoami.required_aal"
 	ViperKeySessionWhoAmICaching                             = "feature_flags.cacheable_sessions"
 	ViperKeyUseContinueWithTransitions                       = "feature_flags.use_continue_with_transitions"
 	ViperKeySessionRefreshMinTimeLeft                        = "session.earliest_possible_extend"
+	ViperKeySessionIdleTimeout                               = "session.idle_timeout.default"
+	ViperKeySessionIdleTimeoutTouchInterval                  = "session.idle_timeout.touch_interval"
+	ViperKeySessionDeviceTrustedProxies                      = "session.device.trusted_proxies"
+	ViperKeySessionDeviceGeoIPCityDatabase                   = "session.device.geoip.city_database"
+	ViperKeySessionDeviceGeoIPASNDatabase                    = "session.device.geoip.asn_database"
+	ViperKeySessionMaxConcurrent                             = "session.max_concurrent.limit"
+	ViperKeySessionMaxConcurrentPolicy                       = "session.max_concurrent.policy"
+	ViperKeySessionCacheEnabled                              = "session.cache.enabled"
+	ViperKeySessionCacheTTL                                  = "session.cache.ttl"
+	ViperKeySessionCacheMaxEntries                           = "session.cache.max_entries"
+	ViperKeySessionCacheInvalidationBus                      = "session.cache.invalidation.bus"
+	ViperKeySessionCacheInvalidationAMQPURL                  = "session.cache.invalidation.amqp.url"
+	ViperKeySessionCacheInvalidationAMQPExchange             = "session.cache.invalidation.amqp.exchange"
+	ViperKeySessionCacheInvalidationPostgresDSN              = "session.cache.invalidation.postgres.dsn"
+	ViperKeySessionCacheInvalidationPostgresChannel          = "session.cache.invalidation.postgres.channel"
+	ViperKeySessionIntrospectionClients                      = "session.introspection.clients"
+	ViperKeySessionIntrospectionExtraClaims                  = "session.introspection.extra_claims"
+	ViperKeySessionIntrospectionCacheEnabled                 = "session.introspection.cache.enabled"
+	ViperKeySessionIntrospectionCacheTTL                     = "session.introspection.cache.ttl"
+	ViperKeySessionIntrospectionCacheMaxEntries              = "session.introspection.cache.max_entries"
+	ViperKeySessionRefreshTokensEnabled                      = "session.refresh_tokens.enabled"
+	ViperKeySessionRefreshTokensAccessTokenLifespan          = "session.refresh_tokens.access_token_lifespan"
+	ViperKeySessionRefreshTokensReuseGracePeriod             = "session.refresh_tokens.reuse_grace_period"
+	ViperKeySessionForwardAuthHeaders                        = "session.forward_auth.headers"
+	ViperKeySessionForwardAuthTokenizerTemplate              = "session.forward_auth.tokenizer.template"
+	ViperKeySessionForwardAuthTokenizerHeader                = "session.forward_auth.tokenizer.header"
+	ViperKeySessionForwardAuthUnauthorizedResponse           = "session.forward_auth.unauthorized_response"
+	ViperKeySessionForwardAuthRules                          = "session.forward_auth.rules"
+	ViperKeySessionForwardAuthUnmatchedHosts                 = "session.forward_auth.unmatched_hosts"
+	ViperKeySessionDPoPFlows                                 = "session.dpop.flows"
+	ViperKeySessionDPoPProofMaxAge                           = "session.dpop.proof_max_age"
 	ViperKeyCookieSameSite                                   = "cookies.same_site"
 	ViperKeyCookieDomain                                     = "cookies.domain"
 	ViperKeyCookiePath                                       = "cookies.path"
@@ -133,6 +178,12 @@ const (
 	ViperKeySelfServiceLoginRequestLifespan                  = "selfservice.flows.login.lifespan"
 	ViperKeySelfServiceLoginAfter                            = "selfservice.flows.login.after"
 	ViperKeySelfServiceLoginBeforeHooks                      = "selfservice.flows.login.before.hooks"
+	ViperKeySelfServiceLoginRiskEnabled                      = "selfservice.flows.login.risk.enabled"
+	ViperKeySelfServiceLoginRiskThreshold                    = "selfservice.flows.login.risk.threshold"
+	ViperKeySelfServiceLoginRiskHistory                      = "selfservice.flows.login.risk.history"
+	ViperKeySelfServiceLoginRiskRules                        = "selfservice.flows.login.risk.rules"
+	ViperKeySelfServiceLoginRiskScorerURL                    = "selfservice.flows.login.risk.scorer.url"
+	ViperKeySelfServiceLoginRiskNotify                       = "selfservice.flows.login.risk.notify"
 	ViperKeySelfServiceErrorUI                               = "selfservice.flows.error.ui_url"
 	ViperKeySelfServiceLogoutBrowserDefaultReturnTo          = "selfservice.flows.logout.after." + DefaultBrowserReturnURL
 	ViperKeySelfServiceSettingsURL                           = "selfservice.flows.settings.ui_url"
@@ -159,6 +210,9 @@ const (
 	ViperKeySelfServiceVerificationNotifyUnknownRecipients   = "selfservice.flows.verification.notify_unknown_recipients"
 	ViperKeyDefaultIdentitySchemaID                          = "identity.default_schema_id"
 	ViperKeyIdentitySchemas                                  = "identity.schemas"
+	ViperKeyIdentityDeletionGracePeriod                      = "identity.deletion.grace_period"
+	ViperKeyIdentityDeletionCheckInterval                    = "identity.deletion.check_interval"
+	ViperKeyIdentityMergeMapperURL                           = "identity.merge.mapper_url"
 	ViperKeyHasherAlgorithm                                  = "hashers.algorithm"
 	ViperKeyHasherArgon2ConfigMemory                         = "hashers.argon2.memory"
 	ViperKeyHasherArgon2ConfigIterations                     = "hashers.argon2.iterations"
@@ -169,7 +223,28 @@ const (
 	ViperKeyHasherArgon2ConfigExpectedDeviation              = "hashers.argon2.expected_deviation"
 	ViperKeyHasherArgon2ConfigDedicatedMemory                = "hashers.argon2.dedicated_memory"
 	ViperKeyHasherBcryptCost                                 = "hashers.bcrypt.cost"
+	ViperKeyHasherPbkdf2Algorithm                            = "hashers.pbkdf2.algorithm"
+	ViperKeyHasherPbkdf2Iterations                           = "hashers.pbkdf2.iterations"
+	ViperKeyHasherPbkdf2SaltLength                           = "hashers.pbkdf2.salt_length"
+	ViperKeyHasherPbkdf2KeyLength                            = "hashers.pbkdf2.key_length"
+	ViperKeyHasherScryptCost                                 = "hashers.scrypt.cost"
+	ViperKeyHasherScryptBlockSize                            = "hashers.scrypt.block_size"
+	ViperKeyHasherScryptParallelization                      = "hashers.scrypt.parallelization"
+	ViperKeyHasherScryptSaltLength                           = "hashers.scrypt.salt_length"
+	ViperKeyHasherScryptKeyLength                            = "hashers.scrypt.key_length"
+	ViperKeyHasherOutdatedReportEnabled                      = "hashers.outdated_report_enabled"
+	ViperKeyHasherOutdatedReportInterval                     = "hashers.outdated_report_interval"
 	ViperKeyCipherAlgorithm                                  = "ciphers.algorithm"
+	ViperKeyCipherEnvelopeKeyManager                         = "ciphers.envelope.key_manager"
+	ViperKeyCipherEnvelopeDataKeyScope                       = "ciphers.envelope.data_key_scope"
+	ViperKeyCipherEnvelopeLegacyAlgorithm                    = "ciphers.envelope.legacy_algorithm"
+	ViperKeyCipherEnvelopeKeyringPath                        = "ciphers.envelope.keyring.path"
+	ViperKeyCipherEnvelopePKCS11TokenPath                    = "ciphers.envelope.pkcs11.token_path"
+	ViperKeyCipherEnvelopePKCS11PIN                          = "ciphers.envelope.pkcs11.pin"
+	ViperKeyCipherEnvelopePKCS11KeyLabel                     = "ciphers.envelope.pkcs11.key_label"
+	ViperKeyCipherEnvelopeHTTPURL                            = "ciphers.envelope.http.url"
+	ViperKeyCipherEnvelopeHTTPKeyID                          = "ciphers.envelope.http.key_id"
+	ViperKeyCipherEnvelopeHTTPToken                          = "ciphers.envelope.http.token"
 	ViperKeyDatabaseCleanupSleepTables                       = "database.cleanup.sleep.tables"
 	ViperKeyDatabaseCleanupBatchSize                         = "database.cleanup.batch_size"
 	ViperKeyLinkLifespan                                     = "selfservice.methods.link.config.lifespan"
@@ -177,9 +252,23 @@ const (
 	ViperKeyCodeLifespan                                     = "selfservice.methods.code.config.lifespan"
 	ViperKeyPasswordHaveIBeenPwnedHost                       = "selfservice.methods.password.config.haveibeenpwned_host"
 	ViperKeyPasswordHaveIBeenPwnedEnabled                    = "selfservice.methods.password.config.haveibeenpwned_enabled"
+	ViperKeyPasswordHaveIBeenPwnedSource                     = "selfservice.methods.password.config.haveibeenpwned_source"
+	ViperKeyPasswordHaveIBeenPwnedLocalPath                  = "selfservice.methods.password.config.haveibeenpwned_local_path"
 	ViperKeyPasswordMaxBreaches                              = "selfservice.methods.password.config.max_breaches"
 	ViperKeyPasswordMinLength                                = "selfservice.methods.password.config.min_password_length"
 	ViperKeyPasswordIdentifierSimilarityCheckEnabled         = "selfservice.methods.password.config.identifier_similarity_check_enabled"
+	ViperKeyPasswordRequiredCharacterClasses                 = "selfservice.methods.password.config.required_character_classes"
+	ViperKeyPasswordMinCharacterClasses                      = "selfservice.methods.password.config.min_character_classes"
+	ViperKeyPasswordMinStrengthScore                         = "selfservice.methods.password.config.min_strength_score"
+	ViperKeyPasswordBannedWords                              = "selfservice.methods.password.config.banned_words"
+	ViperKeyPasswordBannedWordsByOrganization                = "selfservice.methods.password.config.banned_words_by_organization"
+	ViperKeyPasswordTraitSimilarityCheckEnabled              = "selfservice.methods.password.config.trait_similarity_check_enabled"
+	ViperKeyPasswordEnforcePolicyOnImport                    = "selfservice.methods.password.config.enforce_policy_on_import"
+	ViperKeyPasswordHistorySize                              = "selfservice.methods.password.config.history_size"
+	ViperKeyPasswordMaxAge                                   = "selfservice.methods.password.config.max_age"
+	ViperKeyPasswordMaxAgeByOrganization                     = "selfservice.methods.password.config.max_age_by_organization"
+	ViperKeyPasswordExpiryReminderLeadTime                   = "selfservice.methods.password.config.expiry_reminder_lead_time"
+	ViperKeyPasswordExpiryCheckInterval                      = "selfservice.methods.password.config.expiry_check_interval"
 	ViperKeyIgnoreNetworkErrors                              = "selfservice.methods.password.config.ignore_network_errors"
 	ViperKeyTOTPIssuer                                       = "selfservice.methods.totp.config.issuer"
 	ViperKeyOIDCBaseRedirectURL                              = "selfservice.methods.oidc.config.base_redirect_uri"
@@ -207,6 +296,29 @@ const (
 	Argon2DefaultDeviation              = 500 * time.Millisecond
 	Argon2DefaultDedicatedMemory        = 1 * bytesize.GB
 	BcryptDefaultCost            uint32 = 12
+	Pbkdf2DefaultAlgorithm              = "sha256"
+	Pbkdf2DefaultIterations      uint32 = 600000
+	Pbkdf2DefaultSaltLength      uint32 = 16
+	Pbkdf2DefaultKeyLength       uint32 = 32
+	ScryptDefaultCost            uint32 = 32768
+	ScryptDefaultBlockSize       uint32 = 8
+	ScryptDefaultParallelization uint32 = 1
+	ScryptDefaultSaltLength      uint32 = 16
+	ScryptDefaultKeyLength       uint32 = 32
+)
+
+const (
+	// HaveIBeenPwnedSourceAPI checks passwords against the Have I Been Pwned range API.
+	HaveIBeenPwnedSourceAPI = "api"
+	// HaveIBeenPwnedSourceLocal checks passwords against a local copy of the Have I Been Pwned data.
+	HaveIBeenPwnedSourceLocal = "local"
+)
+
+const (
+	PasswordCharacterClassLowercase = "lowercase"
+	PasswordCharacterClassUppercase = "uppercase"
+	PasswordCharacterClassDigit     = "digit"
+	PasswordCharacterClassSymbol    = "symbol"
 )
 
 // DefaultSessionCookieName returns the default cookie name for the kratos session.
@@ -226,6 +338,51 @@ type (
 	Bcrypt struct {
 		Cost uint32 `json:"cost"`
 	}
+	Pbkdf2 struct {
+		Algorithm  string `json:"algorithm"`
+		Iterations uint32 `json:"iterations"`
+		SaltLength uint32 `json:"salt_length"`
+		KeyLength  uint32 `json:"key_length"`
+	}
+	Scrypt struct {
+		Cost            uint32 `json:"cost"`
+		BlockSize       uint32 `json:"block_size"`
+		Parallelization uint32 `json:"parallelization"`
+		SaltLength      uint32 `json:"salt_length"`
+		KeyLength       uint32 `json:"key_length"`
+	}
+	PKCS11 struct {
+		TokenPath string `json:"token_path"`
+		PIN       string `json:"pin"`
+		KeyLabel  string `json:"key_label"`
+	}
+	HTTPKMS struct {
+		URL   *url.URL `json:"url"`
+		KeyID string   `json:"key_id"`
+		Token string   `json:"token"`
+	}
+	GeoIP struct {
+		CityDatabasePath string `json:"city_database"`
+		ASNDatabasePath  string `json:"asn_database"`
+	}
+	// LoginRiskRules are the weights the rules of the risk-based login add to the score when they match.
+	LoginRiskRules struct {
+		NewUserAgentFamily float64 `json:"new_user_agent_family"`
+		NewCountry         float64 `json:"new_country"`
+		NewASN             float64 `json:"new_asn"`
+		ImpossibleTravel   float64 `json:"impossible_travel"`
+
+		// MaxTravelSpeed is the maximum plausible travel speed in kilometers per hour.
+		MaxTravelSpeed float64 `json:"max_travel_speed"`
+	}
+	SessionCacheAMQP struct {
+		URL      string `json:"url"`
+		Exchange string `json:"exchange"`
+	}
+	SessionCachePostgres struct {
+		DSN     string `json:"dsn"`
+		Channel string `json:"channel"`
+	}
 	SelfServiceHook struct {
 		Name   string          `json:"hook"`
 		Config json.RawMessage `json:"config"`
@@ -240,16 +397,41 @@ type (
 		MFAEnabled          bool `json:"mfa_enabled"`
 	}
 	Schema struct {
-		ID  string `json:"id" koanf:"id"`
-		URL string `json:"url" koanf:"url"`
+		ID         string            `json:"id" koanf:"id"`
+		URL        string            `json:"url" koanf:"url"`
+		Version    string            `json:"version" koanf:"version"`
+		Migrations []SchemaMigration `json:"migrations" koanf:"migrations"`
+
+		// PasswordMaxAge overrides the maximum password age for identities using this schema if set.
+		PasswordMaxAge *time.Duration `json:"password_max_age,omitempty" koanf:"password_max_age"`
+
+		// PasswordBannedWords overrides the banned password words for identities using this schema if set.
+		PasswordBannedWords []string `json:"password_banned_words,omitempty" koanf:"password_banned_words"`
+
+		// MaxConcurrentSessions overrides the maximum number of active sessions for identities using this schema if set.
+		MaxConcurrentSessions *int `json:"max_concurrent_sessions,omitempty" koanf:"max_concurrent_sessions"`
+	}
+	SchemaMigration struct {
+		FromID      string `json:"from_id" koanf:"from_id"`
+		FromVersion string `json:"from_version" koanf:"from_version"`
+		URL         string `json:"url" koanf:"url"`
 	}
 	PasswordPolicy struct {
-		HaveIBeenPwnedHost               string `json:"haveibeenpwned_host"`
-		HaveIBeenPwnedEnabled            bool   `json:"haveibeenpwned_enabled"`
-		MaxBreaches                      uint   `json:"max_breaches"`
-		IgnoreNetworkErrors              bool   `json:"ignore_network_errors"`
-		MinPasswordLength                uint   `json:"min_password_length"`
-		IdentifierSimilarityCheckEnabled bool   `json:"identifier_similarity_check_enabled"`
+		HaveIBeenPwnedHost               string   `json:"haveibeenpwned_host"`
+		HaveIBeenPwnedEnabled            bool     `json:"haveibeenpwned_enabled"`
+		HaveIBeenPwnedSource             string   `json:"haveibeenpwned_source"`
+		HaveIBeenPwnedLocalPath          string   `json:"haveibeenpwned_local_path"`
+		MaxBreaches                      uint     `json:"max_breaches"`
+		IgnoreNetworkErrors              bool     `json:"ignore_network_errors"`
+		MinPasswordLength                uint     `json:"min_password_length"`
+		IdentifierSimilarityCheckEnabled bool     `json:"identifier_similarity_check_enabled"`
+		RequiredCharacterClasses         []string `json:"required_character_classes"`
+		MinCharacterClasses              uint     `json:"min_character_classes"`
+		MinStrengthScore                 uint     `json:"min_strength_score"`
+		BannedWords                      []string `json:"banned_words"`
+		TraitSimilarityCheckEnabled      bool     `json:"trait_similarity_check_enabled"`
+		EnforceOnImport                  bool     `json:"enforce_policy_on_import"`
+		HistorySize                      uint     `json:"history_size"`
 	}
 	Schemas                  []Schema
 	CourierEmailBodyTemplate struct {
@@ -304,6 +486,9 @@ type (
 		CourierTemplatesVerificationCodeValid(ctx context.Context) *CourierEmailTemplate
 		CourierTemplatesLoginCodeValid(ctx context.Context) *CourierEmailTemplate
 		CourierTemplatesRegistrationCodeValid(ctx context.Context) *CourierEmailTemplate
+		CourierTemplatesAccountDeletionScheduled(ctx context.Context) *CourierEmailTemplate
+		CourierTemplatesPasswordExpiryReminder(ctx context.Context) *CourierEmailTemplate
+		CourierTemplatesNewSignInNotification(ctx context.Context) *CourierEmailTemplate
 		CourierSMSTemplatesVerificationCodeValid(ctx context.Context) *CourierSMSTemplate
 		CourierSMSTemplatesLoginCodeValid(ctx context.Context) *CourierSMSTemplate
 		CourierMessageRetries(ctx context.Context) int
@@ -370,7 +555,7 @@ func New(ctx context.Context, l *logrusx.Logger, stdOutOrErr io.Writer, opts ...
 
 	opts = append([]configx.OptionModifier{
 		configx.WithStderrValidationReporter(),
-		configx.OmitKeysFromTracing("dsn", "courier.smtp.connection_uri", "secrets.default", "secrets.cookie", "secrets.cipher", "client_secret"),
+		configx.OmitKeysFromTracing("dsn", "courier.smtp.connection_uri", "secrets.default", "secrets.cookie", "secrets.cipher", "secrets.pepper", "secrets.blind_index", "ciphers.envelope.pkcs11.pin", "ciphers.envelope.http.token", "session.cache.invalidation.amqp.url", "session.cache.invalidation.postgres.dsn", "client_secret"),
 		configx.WithImmutables("serve", "profiling", "log"),
 		configx.WithExceptImmutables("serve.public.cors.allowed_origins"),
 		configx.WithLogrusWatcher(l),
@@ -548,6 +733,37 @@ func (p *Config) HasherBcrypt(ctx context.Context) *Bcrypt {
 	return &Bcrypt{Cost: cost}
 }
 
+func (p *Config) HasherPbkdf2(ctx context.Context) *Pbkdf2 {
+	return &Pbkdf2{
+		Algorithm:  p.GetProvider(ctx).StringF(ViperKeyHasherPbkdf2Algorithm, Pbkdf2DefaultAlgorithm),
+		Iterations: uint32(p.GetProvider(ctx).IntF(ViperKeyHasherPbkdf2Iterations, int(Pbkdf2DefaultIterations))),
+		SaltLength: uint32(p.GetProvider(ctx).IntF(ViperKeyHasherPbkdf2SaltLength, int(Pbkdf2DefaultSaltLength))),
+		KeyLength:  uint32(p.GetProvider(ctx).IntF(ViperKeyHasherPbkdf2KeyLength, int(Pbkdf2DefaultKeyLength))),
+	}
+}
+
+func (p *Config) HasherScrypt(ctx context.Context) *Scrypt {
+	return &Scrypt{
+		Cost:            uint32(p.GetProvider(ctx).IntF(ViperKeyHasherScryptCost, int(ScryptDefaultCost))),
+		BlockSize:       uint32(p.GetProvider(ctx).IntF(ViperKeyHasherScryptBlockSize, int(ScryptDefaultBlockSize))),
+		Parallelization: uint32(p.GetProvider(ctx).IntF(ViperKeyHasherScryptParallelization, int(ScryptDefaultParallelization))),
+		SaltLength:      uint32(p.GetProvider(ctx).IntF(ViperKeyHasherScryptSaltLength, int(ScryptDefaultSaltLength))),
+		KeyLength:       uint32(p.GetProvider(ctx).IntF(ViperKeyHasherScryptKeyLength, int(ScryptDefaultKeyLength))),
+	}
+}
+
+// HasherOutdatedReportEnabled returns true if the number of password hashes which do not meet the configured hasher
+// parameters is periodically exported as a metric. The report loads all identities.
+func (p *Config) HasherOutdatedReportEnabled(ctx context.Context) bool {
+	return p.GetProvider(ctx).Bool(ViperKeyHasherOutdatedReportEnabled)
+}
+
+// HasherOutdatedReportInterval returns how often the number of password hashes which do not meet the configured
+// hasher parameters is computed.
+func (p *Config) HasherOutdatedReportInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeyHasherOutdatedReportInterval, time.Hour)
+}
+
 func (p *Config) listenOn(ctx context.Context, key string) string {
 	fb := 4433
 	if key == "admin" {
@@ -828,6 +1044,34 @@ func (p *Config) SecretsSession(ctx context.Context) [][]byte {
 	return result
 }
 
+// SecretsPepper returns the secrets used to pepper password hashes. The first secret peppers new hashes, all
+// other secrets are only used to verify hashes which were peppered before the secret was rotated.
+func (p *Config) SecretsPepper(ctx context.Context) [][]byte {
+	secrets := p.GetProvider(ctx).Strings(ViperKeySecretsPepper)
+	result := make([][]byte, 0, len(secrets))
+	for _, v := range secrets {
+		if len(v) > 0 {
+			result = append(result, []byte(v))
+		}
+	}
+	return result
+}
+
+// SecretsBlindIndex returns the secrets used to compute the blind indexes of encrypted identity traits. The first
+// secret indexes new values, all other secrets are only used for lookups. Falls back to the default secrets.
+func (p *Config) SecretsBlindIndex(ctx context.Context) [][]byte {
+	secrets := p.GetProvider(ctx).Strings(ViperKeySecretsBlindIndex)
+	if len(secrets) == 0 {
+		return p.SecretsDefault(ctx)
+	}
+
+	result := make([][]byte, len(secrets))
+	for k, v := range secrets {
+		result[k] = []byte(v)
+	}
+	return result
+}
+
 func (p *Config) SecretsCipher(ctx context.Context) [][32]byte {
 	secrets := p.GetProvider(ctx).Strings(ViperKeySecretsCipher)
 	var cleanSecrets []string
@@ -1007,6 +1251,37 @@ func (p *Config) SelfServiceFlowLoginRequestLifespan(ctx context.Context) time.D
 	return p.GetProvider(ctx).DurationF(ViperKeySelfServiceLoginRequestLifespan, time.Hour)
 }
 
+func (p *Config) SelfServiceFlowLoginRiskEnabled(ctx context.Context) bool {
+	return p.GetProvider(ctx).Bool(ViperKeySelfServiceLoginRiskEnabled)
+}
+
+func (p *Config) SelfServiceFlowLoginRiskThreshold(ctx context.Context) float64 {
+	return p.GetProvider(ctx).Float64F(ViperKeySelfServiceLoginRiskThreshold, 50)
+}
+
+func (p *Config) SelfServiceFlowLoginRiskHistory(ctx context.Context) int {
+	return p.GetProvider(ctx).IntF(ViperKeySelfServiceLoginRiskHistory, 20)
+}
+
+func (p *Config) SelfServiceFlowLoginRiskRules(ctx context.Context) *LoginRiskRules {
+	pp := p.GetProvider(ctx)
+	return &LoginRiskRules{
+		NewUserAgentFamily: pp.Float64F(ViperKeySelfServiceLoginRiskRules+".new_user_agent_family", 20),
+		NewCountry:         pp.Float64F(ViperKeySelfServiceLoginRiskRules+".new_country", 40),
+		NewASN:             pp.Float64F(ViperKeySelfServiceLoginRiskRules+".new_asn", 20),
+		ImpossibleTravel:   pp.Float64F(ViperKeySelfServiceLoginRiskRules+".impossible_travel", 60),
+		MaxTravelSpeed:     pp.Float64F(ViperKeySelfServiceLoginRiskRules+".max_travel_speed", 1000),
+	}
+}
+
+func (p *Config) SelfServiceFlowLoginRiskScorerURL(ctx context.Context) string {
+	return p.GetProvider(ctx).String(ViperKeySelfServiceLoginRiskScorerURL)
+}
+
+func (p *Config) SelfServiceFlowLoginRiskNotify(ctx context.Context) bool {
+	return p.GetProvider(ctx).BoolF(ViperKeySelfServiceLoginRiskNotify, true)
+}
+
 func (p *Config) SelfServiceFlowSettingsFlowLifespan(ctx context.Context) time.Duration {
 	return p.GetProvider(ctx).DurationF(ViperKeySelfServiceSettingsRequestLifespan, time.Hour)
 }
@@ -1139,6 +1414,18 @@ func (p *Config) CourierTemplatesRegistrationCodeValid(ctx context.Context) *Cou
 	return p.CourierEmailTemplatesHelper(ctx, ViperKeyCourierTemplatesRegistrationCodeValidEmail)
 }
 
+func (p *Config) CourierTemplatesAccountDeletionScheduled(ctx context.Context) *CourierEmailTemplate {
+	return p.CourierEmailTemplatesHelper(ctx, ViperKeyCourierTemplatesAccountDeletionScheduledEmail)
+}
+
+func (p *Config) CourierTemplatesPasswordExpiryReminder(ctx context.Context) *CourierEmailTemplate {
+	return p.CourierEmailTemplatesHelper(ctx, ViperKeyCourierTemplatesPasswordExpiryReminderEmail)
+}
+
+func (p *Config) CourierTemplatesNewSignInNotification(ctx context.Context) *CourierEmailTemplate {
+	return p.CourierEmailTemplatesHelper(ctx, ViperKeyCourierTemplatesNewSignInNotificationEmail)
+}
+
 func (p *Config) CourierMessageRetries(ctx context.Context) int {
 	return p.GetProvider(ctx).IntF(ViperKeyCourierMessageRetries, 5)
 }
@@ -1306,6 +1593,18 @@ func (p *Config) DatabaseCleanupSleepTables(ctx context.Context) time.Duration {
 	return p.GetProvider(ctx).Duration(ViperKeyDatabaseCleanupSleepTables)
 }
 
+func (p *Config) IdentityDeletionGracePeriod(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeyIdentityDeletionGracePeriod, 0)
+}
+
+func (p *Config) IdentityDeletionCheckInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeyIdentityDeletionCheckInterval, time.Minute)
+}
+
+func (p *Config) IdentityMergeMapperURL(ctx context.Context) string {
+	return p.GetProvider(ctx).String(ViperKeyIdentityMergeMapperURL)
+}
+
 func (p *Config) DatabaseCleanupBatchSize(ctx context.Context) int {
 	return p.GetProvider(ctx).Int(ViperKeyDatabaseCleanupBatchSize)
 }
@@ -1361,6 +1660,203 @@ func (p *Config) SessionRefreshMinTimeLeft(ctx context.Context) time.Duration {
 	return p.GetProvider(ctx).DurationF(ViperKeySessionRefreshMinTimeLeft, p.SessionLifespan(ctx))
 }
 
+// SessionIdleTimeout returns the idle timeout of sessions with the given authenticator assurance level, falling back
+// to the default idle timeout. Zero disables the idle timeout.
+func (p *Config) SessionIdleTimeout(ctx context.Context, aal string) time.Duration {
+	if aal != "" {
+		if key := "session.idle_timeout." + aal; p.GetProvider(ctx).String(key) != "" {
+			return p.GetProvider(ctx).Duration(key)
+		}
+	}
+	return p.GetProvider(ctx).Duration(ViperKeySessionIdleTimeout)
+}
+
+// SessionIdleTimeoutTouchInterval returns one minute when the value is not set.
+func (p *Config) SessionIdleTimeoutTouchInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionIdleTimeoutTouchInterval, time.Minute)
+}
+
+// SessionDeviceTrustedProxies returns the networks of the proxies whose X-Forwarded-For entries are trusted when
+// determining the IP address of a session's device. Addresses without a prefix length are single hosts and invalid
+// entries are ignored.
+func (p *Config) SessionDeviceTrustedProxies(ctx context.Context) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range p.GetProvider(ctx).Strings(ViperKeySessionDeviceTrustedProxies) {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			p.l.WithError(err).Warnf("Ignoring invalid trusted proxy %q.", entry)
+		}
+	}
+	return prefixes
+}
+
+func (p *Config) SessionDeviceGeoIP(ctx context.Context) *GeoIP {
+	return &GeoIP{
+		CityDatabasePath: p.GetProvider(ctx).String(ViperKeySessionDeviceGeoIPCityDatabase),
+		ASNDatabasePath:  p.GetProvider(ctx).String(ViperKeySessionDeviceGeoIPASNDatabase),
+	}
+}
+
+// SessionMaxConcurrent returns the maximum number of active sessions of identities with the given schema. The
+// schema's limit takes precedence over the global one. A limit of zero means that the number of sessions is not limited.
+func (p *Config) SessionMaxConcurrent(ctx context.Context, schemaID string) int {
+	if ss, err := p.IdentityTraitsSchemas(ctx); err == nil {
+		if s, err := ss.FindSchemaByID(schemaID); err == nil && s.MaxConcurrentSessions != nil {
+			return *s.MaxConcurrentSessions
+		}
+	}
+
+	return p.GetProvider(ctx).IntF(ViperKeySessionMaxConcurrent, 0)
+}
+
+// SessionMaxConcurrentPolicy returns "evict_oldest" when the value is not set.
+func (p *Config) SessionMaxConcurrentPolicy(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionMaxConcurrentPolicy, "evict_oldest")
+}
+
+func (p *Config) SessionCacheEnabled(ctx context.Context) bool {
+	return p.GetProvider(ctx).Bool(ViperKeySessionCacheEnabled)
+}
+
+// SessionCacheTTL returns 10 seconds when the value is not set.
+func (p *Config) SessionCacheTTL(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionCacheTTL, 10*time.Second)
+}
+
+// SessionCacheMaxEntries returns 10000 when the value is not set.
+func (p *Config) SessionCacheMaxEntries(ctx context.Context) int {
+	return p.GetProvider(ctx).IntF(ViperKeySessionCacheMaxEntries, 10000)
+}
+
+func (p *Config) SessionCacheInvalidationBus(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionCacheInvalidationBus, "none")
+}
+
+func (p *Config) SessionCacheInvalidationAMQP(ctx context.Context) *SessionCacheAMQP {
+	return &SessionCacheAMQP{
+		URL:      p.GetProvider(ctx).String(ViperKeySessionCacheInvalidationAMQPURL),
+		Exchange: p.GetProvider(ctx).StringF(ViperKeySessionCacheInvalidationAMQPExchange, "kratos.session_cache"),
+	}
+}
+
+// SessionCacheInvalidationPostgres falls back to the DSN of the database when no DSN is set.
+func (p *Config) SessionCacheInvalidationPostgres(ctx context.Context) *SessionCachePostgres {
+	return &SessionCachePostgres{
+		DSN:     stringsx.Coalesce(p.GetProvider(ctx).String(ViperKeySessionCacheInvalidationPostgresDSN), p.DSN(ctx)),
+		Channel: p.GetProvider(ctx).StringF(ViperKeySessionCacheInvalidationPostgresChannel, "kratos_session_cache"),
+	}
+}
+
+func (p *Config) SessionIntrospectionClients(ctx context.Context) []IntrospectionClient {
+	var clients []IntrospectionClient
+	if err := p.GetProvider(ctx).Unmarshal(ViperKeySessionIntrospectionClients, &clients); err != nil {
+		p.l.WithError(err).Warn("Unable to decode the introspection clients.")
+		return nil
+	}
+	return clients
+}
+
+// SessionIntrospectionExtraClaims maps claim names to GJSON paths into the session.
+func (p *Config) SessionIntrospectionExtraClaims(ctx context.Context) map[string]string {
+	claims := map[string]string{}
+	for name, path := range p.GetProvider(ctx).StringMap(ViperKeySessionIntrospectionExtraClaims) {
+		claims[name] = path
+	}
+	return claims
+}
+
+func (p *Config) SessionIntrospectionCacheEnabled(ctx context.Context) bool {
+	return p.GetProvider(ctx).Bool(ViperKeySessionIntrospectionCacheEnabled)
+}
+
+// SessionIntrospectionCacheTTL returns 10 seconds when the value is not set.
+func (p *Config) SessionIntrospectionCacheTTL(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionIntrospectionCacheTTL, 10*time.Second)
+}
+
+// SessionIntrospectionCacheMaxEntries returns 10000 when the value is not set.
+func (p *Config) SessionIntrospectionCacheMaxEntries(ctx context.Context) int {
+	return p.GetProvider(ctx).IntF(ViperKeySessionIntrospectionCacheMaxEntries, 10000)
+}
+
+func (p *Config) SessionRefreshTokensEnabled(ctx context.Context) bool {
+	return p.GetProvider(ctx).Bool(ViperKeySessionRefreshTokensEnabled)
+}
+
+// SessionRefreshTokensAccessTokenLifespan returns 15 minutes when the value is not set.
+func (p *Config) SessionRefreshTokensAccessTokenLifespan(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionRefreshTokensAccessTokenLifespan, 15*time.Minute)
+}
+
+// SessionRefreshTokensReuseGracePeriod returns how long the most recently used refresh token of a session can be
+// used again without revoking the session. Refresh tokens can not be reused when the value is not set.
+func (p *Config) SessionRefreshTokensReuseGracePeriod(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionRefreshTokensReuseGracePeriod, 0)
+}
+
+// SessionForwardAuthHeaders returns the headers which are set on successful forward-auth responses. The identity's
+// ID, email trait, and the session's AAL are returned when the value is not set.
+func (p *Config) SessionForwardAuthHeaders(ctx context.Context) []ForwardAuthHeader {
+	if !p.GetProvider(ctx).Exists(ViperKeySessionForwardAuthHeaders) {
+		return []ForwardAuthHeader{
+			{Name: "X-User-Id", Path: "identity.id"},
+			{Name: "X-User-Email", Path: "identity.traits.email"},
+			{Name: "X-Session-AAL", Path: "authenticator_assurance_level"},
+		}
+	}
+
+	var headers []ForwardAuthHeader
+	if err := p.GetProvider(ctx).Unmarshal(ViperKeySessionForwardAuthHeaders, &headers); err != nil {
+		p.l.WithError(err).Warn("Unable to decode the forward-auth headers.")
+		return nil
+	}
+	return headers
+}
+
+func (p *Config) SessionForwardAuthTokenizerTemplate(ctx context.Context) string {
+	return p.GetProvider(ctx).String(ViperKeySessionForwardAuthTokenizerTemplate)
+}
+
+// SessionForwardAuthTokenizerHeader returns "Authorization" when the value is not set.
+func (p *Config) SessionForwardAuthTokenizerHeader(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionForwardAuthTokenizerHeader, "Authorization")
+}
+
+// SessionForwardAuthUnauthorizedResponse returns either ForwardAuthResponseUnauthorized or
+// ForwardAuthResponseRedirect.
+func (p *Config) SessionForwardAuthUnauthorizedResponse(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionForwardAuthUnauthorizedResponse, ForwardAuthResponseUnauthorized)
+}
+
+func (p *Config) SessionForwardAuthRules(ctx context.Context) []ForwardAuthRule {
+	var rules []ForwardAuthRule
+	if err := p.GetProvider(ctx).Unmarshal(ViperKeySessionForwardAuthRules, &rules); err != nil {
+		p.l.WithError(err).Warn("Unable to decode the forward-auth rules.")
+		return nil
+	}
+	return rules
+}
+
+// SessionForwardAuthUnmatchedHosts returns either ForwardAuthUnmatchedHostsDeny, which is the default, or
+// ForwardAuthUnmatchedHostsAllow.
+func (p *Config) SessionForwardAuthUnmatchedHosts(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionForwardAuthUnmatchedHosts, ForwardAuthUnmatchedHostsDeny)
+}
+
+// SessionDPoPMode returns whether sessions issued by flows of the given type ("api" or "browser") are bound to
+// DPoP keys. It returns DPoPModeOff when the value is not set.
+func (p *Config) SessionDPoPMode(ctx context.Context, flowType string) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionDPoPFlows+"."+flowType, DPoPModeOff)
+}
+
+// SessionDPoPProofMaxAge returns one minute when the value is not set.
+func (p *Config) SessionDPoPProofMaxAge(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionDPoPProofMaxAge, time.Minute)
+}
+
 func (p *Config) SelfServiceSettingsRequiredAAL(ctx context.Context) string {
 	return p.GetProvider(ctx).String(ViperKeySelfServiceSettingsRequiredAAL)
 }
@@ -1422,13 +1918,102 @@ func (p *Config) PasswordPolicyConfig(ctx context.Context) *PasswordPolicy {
 	return &PasswordPolicy{
 		HaveIBeenPwnedHost:               p.GetProvider(ctx).StringF(ViperKeyPasswordHaveIBeenPwnedHost, "api.pwnedpasswords.com"),
 		HaveIBeenPwnedEnabled:            p.GetProvider(ctx).BoolF(ViperKeyPasswordHaveIBeenPwnedEnabled, true),
+		HaveIBeenPwnedSource:             p.GetProvider(ctx).StringF(ViperKeyPasswordHaveIBeenPwnedSource, HaveIBeenPwnedSourceAPI),
+		HaveIBeenPwnedLocalPath:          p.GetProvider(ctx).String(ViperKeyPasswordHaveIBeenPwnedLocalPath),
 		MaxBreaches:                      uint(p.GetProvider(ctx).Int(ViperKeyPasswordMaxBreaches)),
 		IgnoreNetworkErrors:              p.GetProvider(ctx).BoolF(ViperKeyIgnoreNetworkErrors, true),
 		MinPasswordLength:                uint(p.GetProvider(ctx).IntF(ViperKeyPasswordMinLength, 8)),
 		IdentifierSimilarityCheckEnabled: p.GetProvider(ctx).BoolF(ViperKeyPasswordIdentifierSimilarityCheckEnabled, true),
+		RequiredCharacterClasses:         p.GetProvider(ctx).Strings(ViperKeyPasswordRequiredCharacterClasses),
+		MinCharacterClasses:              uint(p.GetProvider(ctx).Int(ViperKeyPasswordMinCharacterClasses)),
+		MinStrengthScore:                 uint(p.GetProvider(ctx).Int(ViperKeyPasswordMinStrengthScore)),
+		BannedWords:                      p.GetProvider(ctx).Strings(ViperKeyPasswordBannedWords),
+		TraitSimilarityCheckEnabled:      p.GetProvider(ctx).Bool(ViperKeyPasswordTraitSimilarityCheckEnabled),
+		EnforceOnImport:                  p.GetProvider(ctx).Bool(ViperKeyPasswordEnforcePolicyOnImport),
+		HistorySize:                      uint(p.GetProvider(ctx).Int(ViperKeyPasswordHistorySize)),
 	}
 }
 
+// PasswordBannedWords returns the words which passwords of identities with the given schema and organization must
+// not contain. The organization's list takes precedence over the schema's, which takes precedence over the global one.
+func (p *Config) PasswordBannedWords(ctx context.Context, schemaID string, organizationID uuid.NullUUID) []string {
+	pp := p.GetProvider(ctx)
+	if organizationID.Valid && pp.Exists(ViperKeyPasswordBannedWordsByOrganization) {
+		var byOrganization map[string][]string
+		if err := pp.Unmarshal(ViperKeyPasswordBannedWordsByOrganization, &byOrganization); err != nil {
+			p.l.WithError(err).Warn("Unable to decode the banned password words per organization.")
+		} else if words, ok := byOrganization[organizationID.UUID.String()]; ok {
+			return words
+		}
+	}
+
+	if ss, err := p.IdentityTraitsSchemas(ctx); err == nil {
+		if s, err := ss.FindSchemaByID(schemaID); err == nil && s.PasswordBannedWords != nil {
+			return s.PasswordBannedWords
+		}
+	}
+
+	return pp.Strings(ViperKeyPasswordBannedWords)
+}
+
+// PasswordMaxAge returns the maximum age of passwords of identities with the given schema and organization. The
+// organization's maximum age takes precedence over the schema's, which takes precedence over the global one.
+// A maximum age of zero means that passwords do not expire.
+func (p *Config) PasswordMaxAge(ctx context.Context, schemaID string, organizationID uuid.NullUUID) time.Duration {
+	pp := p.GetProvider(ctx)
+	if organizationID.Valid {
+		if maxAge, ok := pp.StringMap(ViperKeyPasswordMaxAgeByOrganization)[organizationID.UUID.String()]; ok {
+			if d, err := time.ParseDuration(maxAge); err == nil {
+				return d
+			}
+		}
+	}
+
+	if ss, err := p.IdentityTraitsSchemas(ctx); err == nil {
+		if s, err := ss.FindSchemaByID(schemaID); err == nil && s.PasswordMaxAge != nil {
+			return *s.PasswordMaxAge
+		}
+	}
+
+	return pp.DurationF(ViperKeyPasswordMaxAge, 0)
+}
+
+// ShortestPasswordMaxAge returns the shortest maximum password age which is configured globally, for an identity
+// schema, or for an organization. It returns zero if passwords do not expire.
+func (p *Config) ShortestPasswordMaxAge(ctx context.Context) time.Duration {
+	pp := p.GetProvider(ctx)
+	shortest := pp.DurationF(ViperKeyPasswordMaxAge, 0)
+	consider := func(d time.Duration) {
+		if d > 0 && (shortest <= 0 || d < shortest) {
+			shortest = d
+		}
+	}
+
+	for _, maxAge := range pp.StringMap(ViperKeyPasswordMaxAgeByOrganization) {
+		if d, err := time.ParseDuration(maxAge); err == nil {
+			consider(d)
+		}
+	}
+
+	if ss, err := p.IdentityTraitsSchemas(ctx); err == nil {
+		for _, s := range ss {
+			if s.PasswordMaxAge != nil {
+				consider(*s.PasswordMaxAge)
+			}
+		}
+	}
+
+	return max(shortest, 0)
+}
+
+func (p *Config) PasswordExpiryReminderLeadTime(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeyPasswordExpiryReminderLeadTime, 0)
+}
+
+func (p *Config) PasswordExpiryCheckInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeyPasswordExpiryCheckInterval, time.Hour)
+}
+
 func (p *Config) WebAuthnForPasswordless(ctx context.Context) bool {
 	return p.GetProvider(ctx).BoolF(ViperKeyWebAuthnPasswordless, false)
 }
@@ -1452,7 +2037,7 @@ func (p *Config) WebAuthnConfig(ctx context.Context) *webauthn.Config {
 func (p *Config) HasherPasswordHashingAlgorithm(ctx context.Context) string {
 	configValue := p.GetProvider(ctx).StringF(ViperKeyHasherAlgorithm, DefaultPasswordHashingAlgorithm)
 	switch configValue {
-	case "bcrypt":
+	case "bcrypt", "pbkdf2", "scrypt":
 		return configValue
 	case "argon2":
 		fallthrough
@@ -1475,6 +2060,42 @@ func (p *Config) CipherAlgorithm(ctx context.Context) string {
 	}
 }
 
+// CipherEnvelopeKeyManager returns the key manager which wraps the data keys of the envelope cipher.
+func (p *Config) CipherEnvelopeKeyManager(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeyCipherEnvelopeKeyManager, DefaultCipherEnvelopeKeyManager)
+}
+
+// CipherEnvelopeDataKeyScope returns whether the envelope cipher generates a data key per record or per network.
+func (p *Config) CipherEnvelopeDataKeyScope(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeyCipherEnvelopeDataKeyScope, DefaultCipherEnvelopeDataKeyScope)
+}
+
+// CipherEnvelopeLegacyAlgorithm returns the algorithm used to decrypt data which was encrypted before the
+// envelope cipher was enabled, or an empty string.
+func (p *Config) CipherEnvelopeLegacyAlgorithm(ctx context.Context) string {
+	return p.GetProvider(ctx).String(ViperKeyCipherEnvelopeLegacyAlgorithm)
+}
+
+func (p *Config) CipherEnvelopeKeyringPath(ctx context.Context) string {
+	return p.GetProvider(ctx).String(ViperKeyCipherEnvelopeKeyringPath)
+}
+
+func (p *Config) CipherEnvelopePKCS11(ctx context.Context) *PKCS11 {
+	return &PKCS11{
+		TokenPath: p.GetProvider(ctx).String(ViperKeyCipherEnvelopePKCS11TokenPath),
+		PIN:       p.GetProvider(ctx).String(ViperKeyCipherEnvelopePKCS11PIN),
+		KeyLabel:  p.GetProvider(ctx).String(ViperKeyCipherEnvelopePKCS11KeyLabel),
+	}
+}
+
+func (p *Config) CipherEnvelopeHTTPKMS(ctx context.Context) *HTTPKMS {
+	return &HTTPKMS{
+		URL:   p.GetProvider(ctx).URIF(ViperKeyCipherEnvelopeHTTPURL, nil),
+		KeyID: p.GetProvider(ctx).String(ViperKeyCipherEnvelopeHTTPKeyID),
+		Token: p.GetProvider(ctx).String(ViperKeyCipherEnvelopeHTTPToken),
+	}
+}
+
 type CertFunc = func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
 func (p *Config) GetTLSCertificatesForPublic(ctx context.Context) CertFunc {
@@ -1532,10 +2153,57 @@ func (p *Config) GetProvider(ctx context.Context) *configx.Provider {
 	return p.c.Config(ctx, p.p)
 }
 
+type IntrospectionClient struct {
+	ID     string `koanf:"id" json:"id"`
+	Secret string `koanf:"secret" json:"secret"`
+}
+
+const (
+	ForwardAuthResponseUnauthorized = "unauthorized"
+	ForwardAuthResponseRedirect     = "redirect"
+
+	// ForwardAuthUnmatchedHostsDeny rejects requests for upstream hosts which no rule matches.
+	ForwardAuthUnmatchedHostsDeny = "deny"
+	// ForwardAuthUnmatchedHostsAllow accepts requests for upstream hosts which no rule matches if the session
+	// satisfies the AAL required by the whoami endpoint.
+	ForwardAuthUnmatchedHostsAllow = "allow"
+)
+
+const (
+	// DPoPModeOff does not bind sessions to DPoP keys.
+	DPoPModeOff = "off"
+	// DPoPModeOptional binds the session if the request which issues it carries a DPoP proof.
+	DPoPModeOptional = "optional"
+	// DPoPModeRequired rejects requests which issue a session but do not carry a DPoP proof.
+	DPoPModeRequired = "required"
+)
+
+type ForwardAuthHeader struct {
+	Name string `koanf:"name" json:"name"`
+	Path string `koanf:"path" json:"path"`
+}
+
+type ForwardAuthRule struct {
+	// Host is the upstream host. A leading "*." matches all subdomains.
+	Host        string                      `koanf:"host" json:"host"`
+	RequiredAAL string                      `koanf:"required_aal" json:"required_aal"`
+	Traits      []ForwardAuthTraitCondition `koanf:"traits" json:"traits"`
+}
+
+// ForwardAuthTraitCondition is satisfied if the trait at Path, or one of its elements if it is an array, equals one
+// of the values in In.
+type ForwardAuthTraitCondition struct {
+	Path string        `koanf:"path" json:"path"`
+	In   []interface{} `koanf:"in" json:"in"`
+}
+
 type SessionTokenizeFormat struct {
 	TTL             time.Duration `koanf:"ttl" json:"ttl"`
 	ClaimsMapperURL string        `koanf:"claims_mapper_url" json:"claims_mapper_url"`
 	JWKSURL         string        `koanf:"jwks_url" json:"jwks_url"`
+
+	// KeyAlias references a key set managed by Kratos. It is used instead of JWKSURL if set.
+	KeyAlias string `koanf:"key_alias" json:"key_alias"`
 }
 
 func (p *Config) TokenizeTemplate(ctx context.Context, key string) (_ *SessionTokenizeFormat, err error) {
@@ -1552,6 +2220,54 @@ func (p *Config) TokenizeTemplate(ctx context.Context, key string) (_ *SessionTo
 	return &result, nil
 }
 
+func (p *Config) TokenizeTemplates(ctx context.Context) map[string]SessionTokenizeFormat {
+	var templates map[string]SessionTokenizeFormat
+	if err := p.GetProvider(ctx).Unmarshal(ViperKeySessionTokenizerTemplates, &templates); err != nil {
+		p.l.WithError(err).Warn("Unable to decode tokenizer templates.")
+		return nil
+	}
+	return templates
+}
+
+// SessionTokenizerKeyAliases returns the aliases of the managed key sets referenced by tokenizer templates.
+func (p *Config) SessionTokenizerKeyAliases(ctx context.Context) []string {
+	templates := p.TokenizeTemplates(ctx)
+	aliases := make([]string, 0, len(templates))
+	for _, tpl := range templates {
+		if tpl.KeyAlias != "" && !slices.Contains(aliases, tpl.KeyAlias) {
+			aliases = append(aliases, tpl.KeyAlias)
+		}
+	}
+	slices.Sort(aliases)
+	return aliases
+}
+
+func (p *Config) SessionTokenizerKeyAlgorithm(ctx context.Context) string {
+	return p.GetProvider(ctx).StringF(ViperKeySessionTokenizerKeysAlgorithm, "ES256")
+}
+
+// SessionTokenizerKeyCacheTTL returns how long an instance signs tokens with the active key it has loaded before
+// reading the key set again. Zero disables the cache.
+func (p *Config) SessionTokenizerKeyCacheTTL(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionTokenizerKeysCacheTTL, time.Minute)
+}
+
+// SessionTokenizerKeyRotationInterval returns how long a key signs tokens before it is rotated. Zero disables
+// scheduled rotation.
+func (p *Config) SessionTokenizerKeyRotationInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionTokenizerKeysRotationInterval, 30*24*time.Hour)
+}
+
+// SessionTokenizerKeyRotationOverlap returns how long a rotated key is still published so that tokens it
+// signed can be verified.
+func (p *Config) SessionTokenizerKeyRotationOverlap(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionTokenizerKeysRotationOverlap, 24*time.Hour)
+}
+
+func (p *Config) SessionTokenizerKeyRotationCheckInterval(ctx context.Context) time.Duration {
+	return p.GetProvider(ctx).DurationF(ViperKeySessionTokenizerKeysRotationCheckInterval, time.Hour)
+}
+
 func (p *Config) DefaultConsistencyLevel(ctx context.Context) crdbx.ConsistencyLevel {
 	return crdbx.ConsistencyLevelFromString(p.GetProvider(ctx).String(ViperKeyPreviewDefaultReadConsistencyLevel))
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/ory/x/snapshotx"
 
 	"github.com/ghodss/yaml"
+	"github.com/gofrs/uuid"
 	"github.com/spf13/cobra"
 
 	"my.com/secrets/internal/auth/domain/external/testhelpers"
@@ -212,7 +213,7 @@ func TestViperProvider(t *testing.T) {
 				config  string
 				enabled bool
 			}{
-				{id: "password", enabled: true, config: `{"haveibeenpwned_host":"api.pwnedpasswords.com","haveibeenpwned_enabled":true,"ignore_network_errors":true,"max_breaches":0,"min_password_length":8,"identifier_similarity_check_enabled":true}`},
+				{id: "password", enabled: true, config: `{"haveibeenpwned_host":"api.pwnedpasswords.com","haveibeenpwned_enabled":true,"haveibeenpwned_source":"api","ignore_network_errors":true,"max_breaches":0,"min_password_length":8,"identifier_similarity_check_enabled":true,"trait_similarity_check_enabled":false,"min_character_classes":0,"required_character_classes":[],"min_strength_score":0,"banned_words":[],"enforce_policy_on_import":false,"history_size":0,"max_age":"0s","expiry_check_interval":"1h","expiry_reminder_lead_time":"0s"}`},
 				{id: "oidc", enabled: true, config: `{"providers":[{"client_id":"a","client_secret":"b","id":"github","provider":"github","mapper_url":"http://test.kratos.ory.sh/default-identity.schema.json"}]}`},
 				{id: "totp", enabled: true, config: `{"issuer":"issuer.ory.sh"}`},
 			} {
@@ -1131,6 +1132,61 @@ func TestChangeMinPasswordLength(t *testing.T) {
 	})
 }
 
+func TestPasswordMaxAge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	orgID := uuid.Must(uuid.NewV4())
+	conf, err := config.New(ctx, logrusx.New("", ""), os.Stderr,
+		configx.SkipValidation(),
+		configx.WithValues(map[string]interface{}{
+			config.ViperKeyPasswordMaxAge: "2160h",
+			config.ViperKeyPasswordMaxAgeByOrganization: map[string]interface{}{
+				orgID.String(): "720h",
+			},
+			config.ViperKeyIdentitySchemas: []map[string]interface{}{
+				{"id": "default", "url": "file://stub/identity.schema.json"},
+				{"id": "employee", "url": "file://stub/identity.schema.json", "password_max_age": "168h"},
+				{"id": "service", "url": "file://stub/identity.schema.json", "password_max_age": "0s"},
+			},
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2160*time.Hour, conf.PasswordMaxAge(ctx, "default", uuid.NullUUID{}))
+	assert.Equal(t, 168*time.Hour, conf.PasswordMaxAge(ctx, "employee", uuid.NullUUID{}))
+	assert.Equal(t, time.Duration(0), conf.PasswordMaxAge(ctx, "service", uuid.NullUUID{}))
+	assert.Equal(t, 720*time.Hour, conf.PasswordMaxAge(ctx, "employee", uuid.NullUUID{UUID: orgID, Valid: true}))
+	assert.Equal(t, 2160*time.Hour, conf.PasswordMaxAge(ctx, "default", uuid.NullUUID{UUID: uuid.Must(uuid.NewV4()), Valid: true}))
+	assert.Equal(t, 168*time.Hour, conf.ShortestPasswordMaxAge(ctx))
+}
+
+func TestPasswordBannedWords(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	orgID := uuid.Must(uuid.NewV4())
+	conf, err := config.New(ctx, logrusx.New("", ""), os.Stderr,
+		configx.SkipValidation(),
+		configx.WithValues(map[string]interface{}{
+			config.ViperKeyPasswordBannedWords: []string{"acme"},
+			config.ViperKeyPasswordBannedWordsByOrganization: map[string]interface{}{
+				orgID.String(): []string{"initech"},
+			},
+			config.ViperKeyIdentitySchemas: []map[string]interface{}{
+				{"id": "default", "url": "file://stub/identity.schema.json"},
+				{"id": "employee", "url": "file://stub/identity.schema.json", "password_banned_words": []string{"rocket"}},
+				{"id": "service", "url": "file://stub/identity.schema.json", "password_banned_words": []string{}},
+			},
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"acme"}, conf.PasswordBannedWords(ctx, "default", uuid.NullUUID{}))
+	assert.Equal(t, []string{"rocket"}, conf.PasswordBannedWords(ctx, "employee", uuid.NullUUID{}))
+	assert.Empty(t, conf.PasswordBannedWords(ctx, "service", uuid.NullUUID{}))
+	assert.Equal(t, []string{"initech"}, conf.PasswordBannedWords(ctx, "employee", uuid.NullUUID{UUID: orgID, Valid: true}))
+	assert.Equal(t, []string{"acme"}, conf.PasswordBannedWords(ctx, "default", uuid.NullUUID{UUID: uuid.Must(uuid.NewV4()), Valid: true}))
+}
+
 func TestCourierEmailHTTP(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -23,7 +23,12 @@ import (
 	"my.com/secrets/internal/auth/domain/continuity"
 	"my.com/secrets/internal/auth/domain/courier"
 	"my.com/secrets/internal/auth/domain/hash"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/jwk"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/risk"
 	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/schemamigration"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/recovery"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/settings"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/verification"
@@ -42,6 +47,7 @@ import (
 	"github.com/ory/x/dbal"
 
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/selfservice/errorx"
 	password2 "my.com/secrets/internal/auth/domain/selfservice/strategy/password"
@@ -100,11 +106,29 @@ type Registry interface {
 	identity.PoolProvider
 	identity.PrivilegedPoolProvider
 	identity.ManagementProvider
+	identity.DeletionSchedulerProvider
+	identity.PasswordPolicyValidatorProvider
+	identity.MergePersistenceProvider
 	identity.ActiveCredentialsCounterStrategyProvider
 
 	courier.HandlerProvider
 	courier.PersistenceProvider
 
+	job.HandlerProvider
+	job.PersistenceProvider
+
+	jwk.HandlerProvider
+	jwk.ManagementProvider
+	jwk.PersistenceProvider
+
+	privacy.HandlerProvider
+	privacy.ManagementProvider
+	privacy.PersistenceProvider
+
+	schemamigration.HandlerProvider
+	schemamigration.ManagementProvider
+	schemamigration.PersistenceProvider
+
 	schema.HandlerProvider
 	schema.IdentityTraitsProvider
 
@@ -113,7 +137,16 @@ type Registry interface {
 	session.HandlerProvider
 	session.ManagementProvider
 	session.PersistenceProvider
+	session.CacheProvider
 	session.TokenizerProvider
+	session.IntrospectorProvider
+	session.RefreshTokenManagerProvider
+	session.DPoPVerifierProvider
+
+	geoip.Provider
+
+	risk.AssessorProvider
+	risk.ScorerProvider
 
 	settings.HandlerProvider
 	settings.ErrorHandlerProvider
@@ -189,6 +222,7 @@ type options struct {
 	extraHooks              map[string]func(config.SelfServiceHook) any
 	disableMigrationLogging bool
 	jsonnetPool             jsonnetsecure.Pool
+	riskScorer              risk.Scorer
 }
 
 type RegistryOption func(*options)
@@ -203,6 +237,14 @@ func WithJsonnetPool(pool jsonnetsecure.Pool) RegistryOption {
 	}
 }
 
+// WithRiskScorer replaces the scorer of risk-based logins, which by default sums the configured rule weights
+// or evaluates the configured Jsonnet scorer.
+func WithRiskScorer(s risk.Scorer) RegistryOption {
+	return func(o *options) {
+		o.riskScorer = s
+	}
+}
+
 func WithConfig(config *config.Config) RegistryOption {
 	return func(o *options) {
 		o.config = config
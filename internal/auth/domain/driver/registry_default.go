@@ -15,6 +15,7 @@ import (
 	"github.com/cenkalti/backoff"
 	"github.com/dgraph-io/ristretto"
 	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
 	"github.com/gorilla/sessions"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/luna-duclos/instrumentedsql"
@@ -39,12 +40,18 @@ import (
 	"my.com/secrets/internal/auth/domain/continuity"
 	"my.com/secrets/internal/auth/domain/courier"
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
 	"my.com/secrets/internal/auth/domain/hash"
 	"my.com/secrets/internal/auth/domain/hydra"
 	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/jwk"
 	"my.com/secrets/internal/auth/domain/persistence"
 	"my.com/secrets/internal/auth/domain/persistence/sql"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/risk"
 	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/schemamigration"
 	"my.com/secrets/internal/auth/domain/selfservice/errorx"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/logout"
@@ -54,6 +61,7 @@ import (
 	"my.com/secrets/internal/auth/domain/selfservice/flow/verification"
 	"my.com/secrets/internal/auth/domain/selfservice/hook"
 	"my.com/secrets/internal/auth/domain/selfservice/strategy/code"
+	"my.com/secrets/internal/auth/domain/selfservice/strategy/deletion"
 	"my.com/secrets/internal/auth/domain/selfservice/strategy/link"
 	"my.com/secrets/internal/auth/domain/selfservice/strategy/lookup"
 	"my.com/secrets/internal/auth/domain/selfservice/strategy/oidc"
@@ -97,18 +105,39 @@ type RegistryDefault struct {
 
 	courierHandler *courier.Handler
 
+	jobHandler *job.Handler
+
+	signingKeyHandler *jwk.Handler
+	signingKeyManager *jwk.Manager
+
+	privacyHandler *privacy.Handler
+	privacyManager *privacy.Manager
+
+	schemaMigrationHandler *schemamigration.Handler
+	schemaMigrationManager *schemamigration.Manager
+
 	continuityManager continuity.Manager
 
 	schemaHandler *schema.Handler
 
-	sessionHandler   *session.Handler
-	sessionManager   session.Manager
-	sessionTokenizer *session.Tokenizer
+	sessionHandler             *session.Handler
+	sessionManager             session.Manager
+	sessionTokenizer           *session.Tokenizer
+	sessionIntrospector        *session.Introspector
+	sessionRefreshTokenManager *session.RefreshTokenManager
+	sessionDPoPVerifier        *session.DPoPVerifier
+	sessionCache               *session.Cache
+
+	geoIPResolver geoip.Resolver
+
+	riskAssessor *risk.Assessor
+	riskScorer   risk.Scorer
 
 	passwordHasher    hash.Hasher
 	passwordValidator password.Validator
 
-	crypter cipher.Cipher
+	crypter    cipher.Cipher
+	keyManager cipher.KeyManager
 
 	errorHandler *errorx.Handler
 	errorManager *errorx.Manager
@@ -174,6 +203,10 @@ func (m *RegistryDefault) RegisterPublicRoutes(ctx context.Context, router *x.Ro
 	m.SettingsHandler().RegisterPublicRoutes(router)
 	m.IdentityHandler().RegisterPublicRoutes(router)
 	m.CourierHandler().RegisterPublicRoutes(router)
+	m.JobHandler().RegisterPublicRoutes(router)
+	m.SigningKeyHandler().RegisterPublicRoutes(router)
+	m.PrivacyHandler().RegisterPublicRoutes(router)
+	m.SchemaMigrationHandler().RegisterPublicRoutes(router)
 	m.AllLoginStrategies().RegisterPublicRoutes(router)
 	m.AllSettingsStrategies().RegisterPublicRoutes(router)
 	m.AllRegistrationStrategies().RegisterPublicRoutes(router)
@@ -198,6 +231,10 @@ func (m *RegistryDefault) RegisterAdminRoutes(ctx context.Context, router *x.Rou
 	m.SettingsHandler().RegisterAdminRoutes(router)
 	m.IdentityHandler().RegisterAdminRoutes(router)
 	m.CourierHandler().RegisterAdminRoutes(router)
+	m.JobHandler().RegisterAdminRoutes(router)
+	m.SigningKeyHandler().RegisterAdminRoutes(router)
+	m.PrivacyHandler().RegisterAdminRoutes(router)
+	m.SchemaMigrationHandler().RegisterAdminRoutes(router)
 	m.SelfServiceErrorHandler().RegisterAdminRoutes(router)
 
 	m.RecoveryHandler().RegisterAdminRoutes(router)
@@ -320,6 +357,7 @@ func (m *RegistryDefault) selfServiceStrategies() []any {
 				totp.NewStrategy(m),
 				webauthn.NewStrategy(m),
 				lookup.NewStrategy(m),
+				deletion.NewStrategy(m),
 			}
 		}
 	}
@@ -450,6 +488,59 @@ func (m *RegistryDefault) CourierHandler() *courier.Handler {
 	return m.courierHandler
 }
 
+func (m *RegistryDefault) JobHandler() *job.Handler {
+	if m.jobHandler == nil {
+		m.jobHandler = job.NewHandler(m)
+	}
+	return m.jobHandler
+}
+
+func (m *RegistryDefault) SigningKeyHandler() *jwk.Handler {
+	if m.signingKeyHandler == nil {
+		m.signingKeyHandler = jwk.NewHandler(m)
+	}
+	return m.signingKeyHandler
+}
+
+func (m *RegistryDefault) SigningKeyManager() *jwk.Manager {
+	if m.signingKeyManager == nil {
+		m.signingKeyManager = jwk.NewManager(m)
+	}
+	return m.signingKeyManager
+}
+
+func (m *RegistryDefault) PrivacyHandler() *privacy.Handler {
+	if m.privacyHandler == nil {
+		m.privacyHandler = privacy.NewHandler(m)
+	}
+	return m.privacyHandler
+}
+
+func (m *RegistryDefault) PrivacyManager() *privacy.Manager {
+	if m.privacyManager == nil {
+		m.privacyManager = privacy.NewManager(m)
+	}
+	return m.privacyManager
+}
+
+func (m *RegistryDefault) SchemaMigrationHandler() *schemamigration.Handler {
+	if m.schemaMigrationHandler == nil {
+		m.schemaMigrationHandler = schemamigration.NewHandler(m)
+	}
+	return m.schemaMigrationHandler
+}
+
+func (m *RegistryDefault) SchemaMigrationManager() *schemamigration.Manager {
+	if m.schemaMigrationManager == nil {
+		m.schemaMigrationManager = schemamigration.NewManager(m)
+	}
+	return m.schemaMigrationManager
+}
+
+func (m *RegistryDefault) IdentityDeletionScheduler() identity.DeletionScheduler {
+	return m.PrivacyManager()
+}
+
 func (m *RegistryDefault) SchemaHandler() *schema.Handler {
 	if m.schemaHandler == nil {
 		m.schemaHandler = schema.NewHandler(m)
@@ -471,6 +562,15 @@ func (m *RegistryDefault) Cipher(ctx context.Context) cipher.Cipher {
 			m.crypter = cipher.NewCryptChaCha20(m)
 		case "aes":
 			m.crypter = cipher.NewCryptAES(m)
+		case "envelope":
+			var legacy cipher.Cipher
+			switch m.c.CipherEnvelopeLegacyAlgorithm(ctx) {
+			case "xchacha20-poly1305":
+				legacy = cipher.NewCryptChaCha20(m)
+			case "aes":
+				legacy = cipher.NewCryptAES(m)
+			}
+			m.crypter = cipher.NewCryptEnvelope(m, legacy)
 		default:
 			m.crypter = cipher.NewNoop(m)
 			m.l.Logger.Warning("No encryption configuration found. Default algorithm (noop) will be use that mean sensitive data will be recorded in plaintext")
@@ -479,17 +579,61 @@ func (m *RegistryDefault) Cipher(ctx context.Context) cipher.Cipher {
 	return m.crypter
 }
 
+// NetworkID returns the ID of the network the context belongs to.
+func (m *RegistryDefault) NetworkID(ctx context.Context) uuid.UUID {
+	return m.Persister().NetworkID(ctx)
+}
+
+func (m *RegistryDefault) KeyManager(ctx context.Context) cipher.KeyManager {
+	if m.keyManager == nil {
+		switch m.c.CipherEnvelopeKeyManager(ctx) {
+		case "pkcs11":
+			m.keyManager = cipher.NewPKCS11KeyManager(m, func(ctx context.Context) cipher.PKCS11Token {
+				return cipher.NewSoftToken(m.Config().CipherEnvelopePKCS11(ctx).TokenPath)
+			})
+		case "http":
+			m.keyManager = cipher.NewHTTPKeyManager(m)
+		default:
+			m.keyManager = cipher.NewKeyringKeyManager(m)
+		}
+	}
+	return m.keyManager
+}
+
 func (m *RegistryDefault) Hasher(ctx context.Context) hash.Hasher {
 	if m.passwordHasher == nil {
-		if m.c.HasherPasswordHashingAlgorithm(ctx) == "bcrypt" {
+		switch m.c.HasherPasswordHashingAlgorithm(ctx) {
+		case "bcrypt":
 			m.passwordHasher = hash.NewHasherBcrypt(m)
-		} else {
+		case "pbkdf2":
+			conf := m.c.HasherPbkdf2(ctx)
+			m.passwordHasher = &hash.Pbkdf2{
+				Algorithm:  conf.Algorithm,
+				Iterations: conf.Iterations,
+				SaltLength: conf.SaltLength,
+				KeyLength:  conf.KeyLength,
+			}
+		case "scrypt":
+			conf := m.c.HasherScrypt(ctx)
+			m.passwordHasher = &hash.Scrypt{
+				Cost:           conf.Cost,
+				Block:          conf.BlockSize,
+				Parrellization: conf.Parallelization,
+				SaltLength:     conf.SaltLength,
+				KeyLength:      conf.KeyLength,
+			}
+		default:
 			m.passwordHasher = hash.NewHasherArgon2(m)
 		}
+		m.passwordHasher = hash.NewHasherPepper(m, m.passwordHasher)
 	}
 	return m.passwordHasher
 }
 
+func (m *RegistryDefault) IdentityPasswordPolicyValidator() identity.PasswordPolicyValidator {
+	return m.PasswordValidator()
+}
+
 func (m *RegistryDefault) PasswordValidator() password.Validator {
 	if m.passwordValidator == nil {
 		var err error
@@ -566,6 +710,52 @@ func (m *RegistryDefault) SessionManager() session.Manager {
 	return m.sessionManager
 }
 
+func (m *RegistryDefault) SessionCache() *session.Cache {
+	if m.sessionCache == nil {
+		ctx := context.Background()
+
+		var bus session.CacheInvalidationBus
+		switch m.c.SessionCacheInvalidationBus(ctx) {
+		case "amqp":
+			conf := m.c.SessionCacheInvalidationAMQP(ctx)
+			bus = session.NewAMQPCacheInvalidationBus(conf.URL, conf.Exchange, m.Logger())
+		case "postgres":
+			conf := m.c.SessionCacheInvalidationPostgres(ctx)
+			bus = session.NewPostgresCacheInvalidationBus(conf.DSN, conf.Channel, m.Logger())
+		default:
+			bus = session.NewNoopCacheInvalidationBus()
+		}
+
+		cache, err := session.NewCache(ctx, m, bus)
+		if err != nil {
+			m.Logger().WithError(err).Fatal("could not initialize the session cache")
+		}
+		m.sessionCache = cache
+	}
+	return m.sessionCache
+}
+
+func (m *RegistryDefault) GeoIPResolver() geoip.Resolver {
+	if m.geoIPResolver == nil {
+		m.geoIPResolver = geoip.NewFileResolver(m)
+	}
+	return m.geoIPResolver
+}
+
+func (m *RegistryDefault) RiskAssessor() *risk.Assessor {
+	if m.riskAssessor == nil {
+		m.riskAssessor = risk.NewAssessor(m)
+	}
+	return m.riskAssessor
+}
+
+func (m *RegistryDefault) RiskScorer() risk.Scorer {
+	if m.riskScorer == nil {
+		m.riskScorer = risk.NewDefaultScorer(m)
+	}
+	return m.riskScorer
+}
+
 func (m *RegistryDefault) Hydra() hydra.Hydra {
 	if m.hydra == nil {
 		m.hydra = hydra.NewDefaultHydra(m)
@@ -623,6 +813,10 @@ func (m *RegistryDefault) Init(ctx context.Context, ctxer contextx.Contextualize
 		m.replacementSelfserviceStrategies = o.replacementStrategies
 	}
 
+	if o.riskScorer != nil {
+		m.riskScorer = o.riskScorer
+	}
+
 	if o.extraHooks != nil {
 		m.WithHooks(o.extraHooks)
 	}
@@ -757,6 +951,26 @@ func (m *RegistryDefault) CourierPersister() courier.Persister {
 	return m.persister
 }
 
+func (m *RegistryDefault) JobPersister() job.Persister {
+	return m.persister
+}
+
+func (m *RegistryDefault) SigningKeyPersister() jwk.Persister {
+	return m.persister
+}
+
+func (m *RegistryDefault) PrivacyPersister() privacy.Persister {
+	return m.persister
+}
+
+func (m *RegistryDefault) IdentityMergePersister() identity.MergePersister {
+	return m.persister
+}
+
+func (m *RegistryDefault) SchemaMigrationPersister() schemamigration.Persister {
+	return m.persister
+}
+
 func (m *RegistryDefault) RecoveryTokenPersister() link.RecoveryTokenPersister {
 	return m.Persister()
 }
@@ -873,3 +1087,24 @@ func (m *RegistryDefault) SessionTokenizer() *session.Tokenizer {
 	}
 	return m.sessionTokenizer
 }
+
+func (m *RegistryDefault) SessionIntrospector() *session.Introspector {
+	if m.sessionIntrospector == nil {
+		m.sessionIntrospector = session.NewIntrospector(m)
+	}
+	return m.sessionIntrospector
+}
+
+func (m *RegistryDefault) SessionRefreshTokenManager() *session.RefreshTokenManager {
+	if m.sessionRefreshTokenManager == nil {
+		m.sessionRefreshTokenManager = session.NewRefreshTokenManager(m)
+	}
+	return m.sessionRefreshTokenManager
+}
+
+func (m *RegistryDefault) SessionDPoPVerifier() *session.DPoPVerifier {
+	if m.sessionDPoPVerifier == nil {
+		m.sessionDPoPVerifier = session.NewDPoPVerifier(m)
+	}
+	return m.sessionDPoPVerifier
+}
@@ -25,10 +25,21 @@ func (m *RegistryDefault) IdentityTraitsSchemas(ctx context.Context) (schema.Sch
 			return nil, errors.WithStack(err)
 		}
 
+		var migrations []schema.Migration
+		for _, m := range s.Migrations {
+			migrations = append(migrations, schema.Migration{
+				FromID:      m.FromID,
+				FromVersion: m.FromVersion,
+				URL:         m.URL,
+			})
+		}
+
 		ss = append(ss, schema.Schema{
-			ID:     s.ID,
-			URL:    surl,
-			RawURL: s.URL,
+			ID:         s.ID,
+			URL:        surl,
+			RawURL:     s.URL,
+			Version:    s.Version,
+			Migrations: migrations,
 		})
 	}
 
@@ -30,7 +30,8 @@ func (m *RegistryDefault) PreSettingsHooks(ctx context.Context) (b []settings.Pr
 
 func (m *RegistryDefault) PostSettingsPostPersistHooks(ctx context.Context, settingsType string) (b []settings.PostHookPostPersistExecutor) {
 	initialHookCount := 0
-	if m.Config().SelfServiceFlowVerificationEnabled(ctx) {
+	// Deleted accounts must not receive verification messages.
+	if m.Config().SelfServiceFlowVerificationEnabled(ctx) && settingsType != settings.StrategyAccountDeletion {
 		b = append(b, m.HookVerifier())
 		initialHookCount = 1
 	}
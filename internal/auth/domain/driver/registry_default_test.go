@@ -612,6 +612,19 @@ func TestDriverDefault_Hooks(t *testing.T) {
 				assert.Equal(t, expectedExecutors, h)
 			})
 		}
+
+		t.Run("after/uc=Account deletion does not run the verify hook", func(t *testing.T) {
+			conf, reg := external.NewVeryFastRegistryWithoutDB(t)
+			conf.MustSet(ctx, config.ViperKeySelfServiceVerificationEnabled, true)
+			conf.MustSet(ctx, config.ViperKeySelfServiceSettingsAfter+".account_deletion.hooks", []map[string]interface{}{
+				{"hook": "web_hook", "config": map[string]interface{}{"url": "foo", "method": "POST"}},
+			})
+
+			h := reg.PostSettingsPostPersistHooks(ctx, settings.StrategyAccountDeletion)
+			assert.Equal(t, []settings.PostHookPostPersistExecutor{
+				hook.NewWebHook(reg, json.RawMessage(`{"method":"POST","url":"foo"}`)),
+			}, h)
+		})
 	})
 }
 
@@ -898,7 +911,7 @@ func TestDefaultRegistry_AllStrategies(t *testing.T) {
 	})
 
 	t.Run("case=all settings strategies", func(t *testing.T) {
-		expects := []string{"password", "oidc", "profile", "totp", "webauthn", "lookup_secret"}
+		expects := []string{"password", "oidc", "profile", "totp", "webauthn", "lookup_secret", "account_deletion"}
 		s := reg.AllSettingsStrategies()
 		require.Len(t, s, len(expects))
 		for k, e := range expects {
@@ -6,6 +6,7 @@ package testhelpers
 import (
 	"context"
 	"net/http"
+	"net/netip"
 	"strings"
 	"testing"
 	"time"
@@ -32,6 +33,10 @@ func (p *SessionLifespanProvider) SessionLifespan(ctx context.Context) time.Dura
 	return p.e
 }
 
+func (p *SessionLifespanProvider) SessionDeviceTrustedProxies(ctx context.Context) []netip.Prefix {
+	return nil
+}
+
 func NewSessionLifespanProvider(expiresIn time.Duration) *SessionLifespanProvider {
 	return &SessionLifespanProvider{e: expiresIn}
 }
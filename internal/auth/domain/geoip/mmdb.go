@@ -0,0 +1,339 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// metadataStartMarker precedes the metadata section at the end of a MaxMind DB file.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparatorSize is the size of the zero bytes between the search tree and the data section.
+const dataSectionSeparatorSize = 16
+
+// maxDecodeDepth limits the nesting of maps, arrays and pointers, so that corrupt files with pointer cycles or deeply
+// nested values fail to decode instead of exhausting the stack. Real databases nest only a few levels deep.
+const maxDecodeDepth = 64
+
+// database is a MaxMind DB (mmdb) file loaded into memory. See https://maxmind.github.io/MaxMind-DB/ for the format.
+type database struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	dbType     string
+}
+
+func openDatabase(path string) (*database, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newDatabase(buf)
+}
+
+func newDatabase(buf []byte) (*database, error) {
+	start := bytes.LastIndex(buf, metadataStartMarker)
+	if start < 0 {
+		return nil, errors.New("the file is not a MaxMind DB file because it has no metadata section")
+	}
+	start += len(metadataStartMarker)
+
+	raw, _, err := decode(buf[start:], 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode the MaxMind DB metadata")
+	}
+	metadata, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the MaxMind DB metadata is not a map")
+	}
+
+	db := &database{buf: buf}
+	db.nodeCount, _ = toUint(metadata["node_count"])
+	db.recordSize, _ = toUint(metadata["record_size"])
+	db.ipVersion, _ = toUint(metadata["ip_version"])
+	db.dbType, _ = metadata["database_type"].(string)
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, errors.Errorf("the MaxMind DB record size %d is not supported", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, errors.Errorf("the MaxMind DB IP version %d is not supported", db.ipVersion)
+	}
+
+	if db.nodeCount > uint(len(buf)) {
+		return nil, errors.New("the MaxMind DB search tree exceeds the file size")
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparatorSize > uint(len(buf)) {
+		return nil, errors.New("the MaxMind DB search tree exceeds the file size")
+	}
+	db.data = buf[treeSize+dataSectionSeparatorSize:]
+
+	// IPv4 addresses are stored in IPv6 databases as ::a.b.c.d, so their lookup starts after 96 zero bits.
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			if db.ipv4Start, err = db.record(db.ipv4Start, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// lookup returns the record of the network containing the IP address, or nil if the address is not in the database.
+func (db *database) lookup(ip net.IP) (interface{}, error) {
+	node, bits := uint(0), 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, node, bits = v4, db.ipv4Start, 32
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		var err error
+		if node, err = db.record(node, bit); err != nil {
+			return nil, err
+		}
+	}
+
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount+dataSectionSeparatorSize {
+		return nil, errors.New("the MaxMind DB search tree points into the data section separator")
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparatorSize
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("the MaxMind DB search tree points outside of the data section")
+	}
+
+	value, _, err := decode(db.data, offset)
+	return value, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the node.
+func (db *database) record(node, bit uint) (uint, error) {
+	size := db.recordSize / 4
+	offset := node * size
+	if offset+size > uint(len(db.buf)) {
+		return 0, errors.New("the MaxMind DB search tree is truncated")
+	}
+	b := db.buf[offset : offset+size]
+
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("the MaxMind DB data section is truncated")
+
+// decode decodes the value at the offset of the section. Pointers are relative to the start of the section.
+func decode(section []byte, offset uint) (interface{}, uint, error) {
+	return decodeValue(section, offset, 0)
+}
+
+func decodeValue(section []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.Errorf("the MaxMind DB data section nests values deeper than %d levels", maxDecodeDepth)
+	}
+	if offset >= uint(len(section)) {
+		return nil, 0, errTruncated
+	}
+
+	ctrl := section[offset]
+	offset++
+
+	kind := uint(ctrl >> 5)
+	if kind == typePointer {
+		return decodePointer(section, ctrl, offset, depth)
+	}
+
+	if kind == typeExtended {
+		if offset >= uint(len(section)) {
+			return nil, 0, errTruncated
+		}
+		kind = 7 + uint(section[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return nil, 0, errTruncated
+		}
+		extra := uintFromBytes(section[offset : offset+n])
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch kind {
+	case typeMap:
+		// Every entry takes at least two bytes, which bounds the allocation by the size of the section.
+		if size > (uint(len(section))-offset)/2 {
+			return nil, 0, errTruncated
+		}
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decodeValue(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("the MaxMind DB contains a map key which is not a string")
+			}
+			if m[k], offset, err = decodeValue(section, next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		// Every element takes at least one byte, which bounds the allocation by the size of the section.
+		if size > uint(len(section))-offset {
+			return nil, 0, errTruncated
+		}
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = decodeValue(section, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if size > uint(len(section))-offset {
+		return nil, 0, errTruncated
+	}
+	b := section[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte{}, b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.Errorf("the MaxMind DB contains a double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.Errorf("the MaxMind DB contains a float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.Errorf("the MaxMind DB contains an unsigned integer of size %d", size)
+		}
+		return uint64(uintFromBytes(b)), offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.Errorf("the MaxMind DB contains a signed integer of size %d", size)
+		}
+		return int64(int32(uint32(uintFromBytes(b)))), offset, nil
+	case typeUint128:
+		// Values this large are not used by the fields we read.
+		return append([]byte{}, b...), offset, nil
+	default:
+		return nil, 0, errors.Errorf("the MaxMind DB contains a value of the unsupported type %d", kind)
+	}
+}
+
+func decodePointer(section []byte, ctrl byte, offset uint, depth int) (interface{}, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(section)) {
+		return nil, 0, errTruncated
+	}
+	b := section[offset : offset+n]
+
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = uint(ctrl&0x7)<<8 | uintFromBytes(b)
+	case 2:
+		pointer = (uint(ctrl&0x7)<<16 | uintFromBytes(b)) + 2048
+	case 3:
+		pointer = (uint(ctrl&0x7)<<24 | uintFromBytes(b)) + 526336
+	default:
+		pointer = uintFromBytes(b)
+	}
+
+	value, _, err := decodeValue(section, pointer, depth+1)
+	return value, offset + n, err
+}
+
+func uintFromBytes(b []byte) uint {
+	var v uint
+	for _, c := range b {
+		v = v<<8 | uint(c)
+	}
+	return v
+}
+
+func toUint(v interface{}) (uint, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n), true
+	case int64:
+		if n >= 0 {
+			return uint(n), true
+		}
+	}
+	return 0, false
+}
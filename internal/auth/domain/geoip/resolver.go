@@ -0,0 +1,175 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"context"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/otelx"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type (
+	// Location is the geographic location and network of an IP address. Fields which could not be resolved are
+	// empty.
+	Location struct {
+		City string
+
+		// Country is the ISO 3166-1 alpha-2 code of the country.
+		Country string
+
+		// ASN is the autonomous system number of the network.
+		ASN uint32
+
+		// Coordinates are the approximate coordinates of the city, or nil if they are unknown.
+		Coordinates *Coordinates
+	}
+
+	Coordinates struct {
+		Latitude  float64
+		Longitude float64
+	}
+
+	Resolver interface {
+		// Resolve returns the location of the IP address.
+		Resolve(ctx context.Context, ip net.IP) (*Location, error)
+	}
+
+	Provider interface {
+		GeoIPResolver() Resolver
+	}
+
+	resolverDependencies interface {
+		config.Provider
+		x.TracingProvider
+	}
+
+	// FileResolver resolves locations using local MaxMind DB files, for example GeoLite2 City and GeoLite2 ASN.
+	// The files are reloaded when they are modified or when their configured path changes.
+	FileResolver struct {
+		d    resolverDependencies
+		city databaseFile
+		asn  databaseFile
+	}
+
+	databaseFile struct {
+		sync.RWMutex
+		path    string
+		modTime time.Time
+		db      *database
+	}
+)
+
+func NewFileResolver(d resolverDependencies) *FileResolver {
+	return &FileResolver{d: d}
+}
+
+func (r *FileResolver) Resolve(ctx context.Context, ip net.IP) (_ *Location, err error) {
+	ctx, span := r.d.Tracer(ctx).Tracer().Start(ctx, "geoip.FileResolver.Resolve")
+	defer otelx.End(span, &err)
+
+	conf := r.d.Config().SessionDeviceGeoIP(ctx)
+	location := new(Location)
+
+	city, err := r.city.lookup(conf.CityDatabasePath, ip)
+	if err != nil {
+		return nil, err
+	}
+	if city != nil {
+		location.City, _ = lookupPath(city, "city", "names", "en").(string)
+		location.Country, _ = lookupPath(city, "country", "iso_code").(string)
+
+		latitude, hasLatitude := lookupPath(city, "location", "latitude").(float64)
+		longitude, hasLongitude := lookupPath(city, "location", "longitude").(float64)
+		if hasLatitude && hasLongitude {
+			location.Coordinates = &Coordinates{Latitude: latitude, Longitude: longitude}
+		}
+	}
+
+	asn, err := r.asn.lookup(conf.ASNDatabasePath, ip)
+	if err != nil {
+		return nil, err
+	}
+	if asn != nil {
+		number, _ := toUint(lookupPath(asn, "autonomous_system_number"))
+		location.ASN = uint32(number)
+	}
+
+	return location, nil
+}
+
+// lookup returns the record of the IP address in the database at the path, or nil if no path is configured or the
+// address is not in the database.
+func (f *databaseFile) lookup(path string, ip net.IP) (interface{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	db, err := f.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return db.lookup(ip)
+}
+
+func (f *databaseFile) load(path string) (*database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	f.RLock()
+	db := f.db
+	fresh := db != nil && f.path == path && f.modTime.Equal(info.ModTime())
+	f.RUnlock()
+	if fresh {
+		return db, nil
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.db != nil && f.path == path && f.modTime.Equal(info.ModTime()) {
+		return f.db, nil
+	}
+
+	db, err = openDatabase(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load the GeoIP database %s", path)
+	}
+
+	f.db, f.path, f.modTime = db, path, info.ModTime()
+	return db, nil
+}
+
+func lookupPath(record interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
+
+// DistanceKilometers returns the great-circle distance between the coordinates.
+func (c *Coordinates) DistanceKilometers(other *Coordinates) float64 {
+	const earthRadiusKilometers = 6371.0
+
+	lat1, lat2 := c.Latitude*math.Pi/180, other.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (other.Longitude - c.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKilometers * math.Asin(math.Min(1, math.Sqrt(h)))
+}
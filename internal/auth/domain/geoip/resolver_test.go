@@ -0,0 +1,246 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/logrusx"
+	"github.com/ory/x/otelx"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type resolverDeps struct {
+	c *config.Config
+}
+
+func (d *resolverDeps) Config() *config.Config { return d.c }
+func (d *resolverDeps) Tracer(context.Context) *otelx.Tracer {
+	return otelx.NewNoop(logrusx.New("", ""), new(otelx.Config))
+}
+
+// mmdbWriter encodes the subset of the MaxMind DB format needed by the tests.
+type mmdbWriter struct{ bytes.Buffer }
+
+func (w *mmdbWriter) control(kind, size int) {
+	if kind > 7 {
+		w.WriteByte(byte(size))
+		w.WriteByte(byte(kind - 7))
+		return
+	}
+	w.WriteByte(byte(kind<<5 | size))
+}
+
+func (w *mmdbWriter) str(s string) {
+	w.control(typeString, len(s))
+	w.WriteString(s)
+}
+
+func (w *mmdbWriter) uint32(v uint32) {
+	w.control(typeUint32, 4)
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *mmdbWriter) uint16(v uint16) {
+	w.control(typeUint16, 2)
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *mmdbWriter) double(v float64) {
+	w.control(typeDouble, 8)
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *mmdbWriter) mapOf(size int) { w.control(typeMap, size) }
+
+func (w *mmdbWriter) pointer(offset int) {
+	w.WriteByte(byte(typePointer<<5 | offset>>8))
+	w.WriteByte(byte(offset))
+}
+
+// writeDatabase writes an IPv4 database with 24 bit records which maps 1.0.0.0/8 to the record written by data. The
+// record starts at offset recordOffset of the data section.
+func writeDatabase(t *testing.T, path, dbType string, recordOffset int, data func(w *mmdbWriter)) {
+	const nodeCount = 8
+
+	var tree bytes.Buffer
+	record := func(v int) { tree.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)}) }
+	for i := 0; i < nodeCount-1; i++ {
+		record(i + 1)
+		record(nodeCount)
+	}
+	record(nodeCount)
+	record(nodeCount + dataSectionSeparatorSize + recordOffset)
+
+	var out mmdbWriter
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, dataSectionSeparatorSize))
+	data(&out)
+
+	out.Write(metadataStartMarker)
+	out.mapOf(4)
+	out.str("node_count")
+	out.uint32(nodeCount)
+	out.str("record_size")
+	out.uint16(24)
+	out.str("ip_version")
+	out.uint16(4)
+	out.str("database_type")
+	out.str(dbType)
+
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0o600))
+}
+
+func writeCityDatabase(t *testing.T, path, city, country string, coordinates Coordinates) {
+	writeDatabase(t, path, "GeoLite2-City", len(city)+1, func(w *mmdbWriter) {
+		// The city name is referenced through a pointer to cover pointer decoding.
+		w.str(city)
+		w.mapOf(3)
+		w.str("city")
+		w.mapOf(1)
+		w.str("names")
+		w.mapOf(1)
+		w.str("en")
+		w.pointer(0)
+		w.str("country")
+		w.mapOf(1)
+		w.str("iso_code")
+		w.str(country)
+		w.str("location")
+		w.mapOf(2)
+		w.str("latitude")
+		w.double(coordinates.Latitude)
+		w.str("longitude")
+		w.double(coordinates.Longitude)
+	})
+}
+
+func writeASNDatabase(t *testing.T, path string, asn uint32) {
+	writeDatabase(t, path, "GeoLite2-ASN", 0, func(w *mmdbWriter) {
+		w.mapOf(1)
+		w.str("autonomous_system_number")
+		w.uint32(asn)
+	})
+}
+
+var (
+	berlin  = Coordinates{Latitude: 52.52, Longitude: 13.405}
+	hamburg = Coordinates{Latitude: 53.5511, Longitude: 9.9937}
+)
+
+func TestFileResolver(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cityPath, asnPath := filepath.Join(dir, "city.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeCityDatabase(t, cityPath, "Berlin", "DE", berlin)
+	writeASNDatabase(t, asnPath, 3320)
+
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	r := NewFileResolver(&resolverDeps{c: conf})
+
+	t.Run("case=no databases configured", func(t *testing.T) {
+		location, err := r.Resolve(ctx, net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		assert.Equal(t, &Location{}, location)
+	})
+
+	conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPCityDatabase, cityPath)
+	conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPASNDatabase, asnPath)
+
+	t.Run("case=resolves city, country and asn", func(t *testing.T) {
+		for _, ip := range []string{"1.2.3.4", "::ffff:1.255.0.1"} {
+			location, err := r.Resolve(ctx, net.ParseIP(ip))
+			require.NoError(t, err)
+			assert.Equal(t, &Location{City: "Berlin", Country: "DE", ASN: 3320, Coordinates: &berlin}, location, ip)
+		}
+	})
+
+	t.Run("case=address not in database", func(t *testing.T) {
+		for _, ip := range []string{"2.2.3.4", "127.0.0.1", "2001:db8::1"} {
+			location, err := r.Resolve(ctx, net.ParseIP(ip))
+			require.NoError(t, err)
+			assert.Equal(t, &Location{}, location, ip)
+		}
+	})
+
+	t.Run("case=reloads modified database", func(t *testing.T) {
+		writeCityDatabase(t, cityPath, "Hamburg", "DE", hamburg)
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(cityPath, later, later))
+
+		location, err := r.Resolve(ctx, net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		assert.Equal(t, "Hamburg", location.City)
+		assert.Equal(t, &hamburg, location.Coordinates)
+	})
+
+	t.Run("case=invalid database", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.mmdb")
+		require.NoError(t, os.WriteFile(invalid, []byte("not a database"), 0o600))
+		conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPCityDatabase, invalid)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPCityDatabase, cityPath) })
+
+		_, err := r.Resolve(ctx, net.ParseIP("1.2.3.4"))
+		assert.Error(t, err)
+	})
+
+	t.Run("case=missing database", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPASNDatabase, filepath.Join(dir, "missing.mmdb"))
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionDeviceGeoIPASNDatabase, asnPath) })
+
+		_, err := r.Resolve(ctx, net.ParseIP("1.2.3.4"))
+		assert.Error(t, err)
+	})
+}
+
+func TestDecodeCorruptData(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data func(w *mmdbWriter)
+	}{
+		{name: "pointer cycle", data: func(w *mmdbWriter) { w.pointer(0) }},
+		{name: "nested too deep", data: func(w *mmdbWriter) {
+			for i := 0; i < maxDecodeDepth+1; i++ {
+				w.control(typeArray, 1)
+			}
+			w.str("too deep")
+		}},
+		{name: "map larger than the section", data: func(w *mmdbWriter) {
+			w.control(typeMap, 31)
+			w.Write([]byte{0xff, 0xff, 0xff})
+		}},
+		{name: "array larger than the section", data: func(w *mmdbWriter) {
+			w.control(typeArray, 31)
+			w.Write([]byte{0xff, 0xff, 0xff})
+		}},
+		{name: "string larger than the section", data: func(w *mmdbWriter) {
+			w.control(typeString, 29)
+			w.WriteByte(0xff)
+		}},
+	} {
+		t.Run("case="+tc.name, func(t *testing.T) {
+			var w mmdbWriter
+			tc.data(&w)
+			_, _, err := decode(w.Bytes(), 0)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCoordinatesDistanceKilometers(t *testing.T) {
+	assert.InDelta(t, 255, berlin.DistanceKilometers(&hamburg), 5)
+	assert.InDelta(t, 255, hamburg.DistanceKilometers(&berlin), 5)
+	assert.Zero(t, berlin.DistanceKilometers(&berlin))
+}
@@ -15,10 +15,13 @@ import (
 	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"hash"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -104,6 +107,21 @@ func Compare(ctx context.Context, password []byte, hash []byte) error {
 	case IsHMACHash(hash):
 		span.SetAttributes(attribute.String("hash.type", "hmac"))
 		return CompareHMAC(ctx, password, hash)
+	case IsPHPassHash(hash):
+		span.SetAttributes(attribute.String("hash.type", "phpass"))
+		return ComparePHPass(ctx, password, hash)
+	case IsDrupal7Hash(hash):
+		span.SetAttributes(attribute.String("hash.type", "drupal7"))
+		return CompareDrupal7(ctx, password, hash)
+	case IsDjangoPbkdf2Hash(hash):
+		span.SetAttributes(attribute.String("hash.type", "django-pbkdf2"))
+		return CompareDjangoPbkdf2(ctx, password, hash)
+	case IsKeycloakHash(hash):
+		span.SetAttributes(attribute.String("hash.type", "keycloak"))
+		return CompareKeycloak(ctx, password, hash)
+	case IsASPNetIdentityHash(hash):
+		span.SetAttributes(attribute.String("hash.type", "aspnet-identity"))
+		return CompareASPNetIdentity(ctx, password, hash)
 	default:
 		span.SetAttributes(attribute.String("hash.type", "unknown"))
 		return errors.WithStack(ErrUnknownHashAlgorithm)
@@ -296,6 +314,67 @@ func CompareHMAC(_ context.Context, password []byte, hash []byte) error {
 	return comparePasswordHashConstantTime(hash, otherHash)
 }
 
+// ComparePHPass compares a password with a portable PHPass hash as used by WordPress and phpBB.
+// format: $P$<iterations><salt><hash> or $H$<iterations><salt><hash>
+func ComparePHPass(_ context.Context, password []byte, hash []byte) error {
+	otherHash, err := phpassCrypt(md5.New, password, string(hash), len(hash)) //#nosec G401 -- compatibility for imported passwords
+	if err != nil {
+		return err
+	}
+
+	return comparePasswordHashConstantTime(hash, otherHash)
+}
+
+// CompareDrupal7 compares a password with a Drupal 7 hash, which uses the PHPass scheme with SHA-512 and truncates
+// the result to 55 characters.
+// format: $S$<iterations><salt><hash>
+func CompareDrupal7(_ context.Context, password []byte, hash []byte) error {
+	otherHash, err := phpassCrypt(sha512.New, password, string(hash), drupal7HashLength)
+	if err != nil {
+		return err
+	}
+
+	return comparePasswordHashConstantTime(hash, otherHash)
+}
+
+// CompareDjangoPbkdf2 compares a password with a hash of Django's PBKDF2 password hashers.
+// format: pbkdf2_<digest>$<iterations>$<salt>$<hash>
+func CompareDjangoPbkdf2(_ context.Context, password []byte, hash []byte) error {
+	hasher, iterations, salt, hash, err := decodeDjangoPbkdf2Hash(string(hash))
+	if err != nil {
+		return err
+	}
+
+	otherHash := pbkdf2.Key(password, salt, iterations, len(hash), hasher)
+
+	return comparePasswordHashConstantTime(hash, otherHash)
+}
+
+// CompareKeycloak compares a password with a PBKDF2 password credential exported from Keycloak.
+// format: {"secretData": "{\"value\": <hash>, \"salt\": <salt>}", "credentialData": "{\"hashIterations\": <iterations>, \"algorithm\": <algorithm>}"}
+func CompareKeycloak(_ context.Context, password []byte, hash []byte) error {
+	hasher, iterations, salt, hash, err := decodeKeycloakHash(hash)
+	if err != nil {
+		return err
+	}
+
+	otherHash := pbkdf2.Key(password, salt, iterations, len(hash), hasher)
+
+	return comparePasswordHashConstantTime(hash, otherHash)
+}
+
+// CompareASPNetIdentity compares a password with a base64 encoded ASP.NET Identity v2 or v3 hash.
+func CompareASPNetIdentity(_ context.Context, password []byte, hash []byte) error {
+	hasher, iterations, salt, hash, err := decodeASPNetIdentityHash(string(hash))
+	if err != nil {
+		return err
+	}
+
+	otherHash := pbkdf2.Key(password, salt, iterations, len(hash), hasher)
+
+	return comparePasswordHashConstantTime(hash, otherHash)
+}
+
 var (
 	isMD5CryptHash       = regexp.MustCompile(`^\$md5-crypt\$`)
 	isBcryptHash         = regexp.MustCompile(`^\$2[abzy]?\$`)
@@ -310,6 +389,10 @@ var (
 	isFirebaseScryptHash = regexp.MustCompile(`^\$firescrypt\$`)
 	isMD5Hash            = regexp.MustCompile(`^\$md5\$`)
 	isHMACHash           = regexp.MustCompile(`^\$hmac-(md4|md5|sha1|sha224|sha256|sha384|sha512)\$`)
+	isPHPassHash         = regexp.MustCompile(`^\$[PH]\$[./0-9A-Za-z]{31}$`)
+	isDrupal7Hash        = regexp.MustCompile(`^\$S\$[./0-9A-Za-z]{52}$`)
+	isDjangoPbkdf2Hash   = regexp.MustCompile(`^pbkdf2_(sha1|sha256)\$[0-9]+\$[^$]+\$[A-Za-z0-9+/]+={0,2}$`)
+	isPepperedHash       = regexp.MustCompile(`^\$pepper\$k=[0-9a-f]+\$`)
 )
 
 func IsMD5CryptHash(hash []byte) bool       { return isMD5CryptHash.Match(hash) }
@@ -325,6 +408,25 @@ func IsSHAHash(hash []byte) bool            { return isSHAHash.Match(hash) }
 func IsFirebaseScryptHash(hash []byte) bool { return isFirebaseScryptHash.Match(hash) }
 func IsMD5Hash(hash []byte) bool            { return isMD5Hash.Match(hash) }
 func IsHMACHash(hash []byte) bool           { return isHMACHash.Match(hash) }
+func IsPHPassHash(hash []byte) bool         { return isPHPassHash.Match(hash) }
+func IsDrupal7Hash(hash []byte) bool        { return isDrupal7Hash.Match(hash) }
+func IsDjangoPbkdf2Hash(hash []byte) bool   { return isDjangoPbkdf2Hash.Match(hash) }
+func IsPepperedHash(hash []byte) bool       { return isPepperedHash.Match(hash) }
+
+// IsKeycloakHash returns whether the hash is a Keycloak password credential using a supported PBKDF2 algorithm.
+func IsKeycloakHash(hash []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(hash), []byte("{")) {
+		return false
+	}
+	_, _, _, _, err := decodeKeycloakHash(hash)
+	return err == nil
+}
+
+// IsASPNetIdentityHash returns whether the hash is a base64 encoded ASP.NET Identity v2 or v3 hash.
+func IsASPNetIdentityHash(hash []byte) bool {
+	_, _, _, _, err := decodeASPNetIdentityHash(string(hash))
+	return err == nil
+}
 
 func IsValidHashFormat(hash []byte) bool {
 	if IsMD5CryptHash(hash) ||
@@ -339,7 +441,13 @@ func IsValidHashFormat(hash []byte) bool {
 		IsSHAHash(hash) ||
 		IsFirebaseScryptHash(hash) ||
 		IsMD5Hash(hash) ||
-		IsHMACHash(hash) {
+		IsHMACHash(hash) ||
+		IsPHPassHash(hash) ||
+		IsDrupal7Hash(hash) ||
+		IsDjangoPbkdf2Hash(hash) ||
+		IsKeycloakHash(hash) ||
+		IsASPNetIdentityHash(hash) ||
+		IsPepperedHash(hash) {
 		return true
 	} else {
 		return false
@@ -689,6 +797,225 @@ func decodeHMACHash(encodedHash string) (hasher func() hash.Hash, hash, key []by
 	return hasher, hash, key, nil
 }
 
+const (
+	phpassItoa64      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	drupal7HashLength = 55
+)
+
+// phpassCrypt computes a PHPass style hash of the password using the iteration count and salt of the setting. The
+// result is truncated to length characters.
+func phpassCrypt(hasher func() hash.Hash, password []byte, setting string, length int) ([]byte, error) {
+	if len(setting) < 12 {
+		return nil, ErrInvalidHash
+	}
+
+	countLog2 := strings.IndexByte(phpassItoa64, setting[3])
+	if countLog2 < 7 || countLog2 > 30 {
+		return nil, ErrInvalidHash
+	}
+
+	salt := setting[4:12]
+	h := hasher()
+	h.Write([]byte(salt))
+	h.Write(password)
+	sum := h.Sum(nil)
+	for count := 1 << countLog2; count > 0; count-- {
+		h.Reset()
+		h.Write(sum)
+		h.Write(password)
+		sum = h.Sum(sum[:0])
+	}
+
+	out := []byte(setting[:12] + phpassEncode64(sum))
+	if len(out) > length {
+		out = out[:length]
+	}
+	return out, nil
+}
+
+// phpassEncode64 encodes the input with PHPass's little-endian base64 variant.
+func phpassEncode64(input []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(input); {
+		value := int(input[i])
+		i++
+		out.WriteByte(phpassItoa64[value&0x3f])
+		if i < len(input) {
+			value |= int(input[i]) << 8
+		}
+		out.WriteByte(phpassItoa64[(value>>6)&0x3f])
+		if i >= len(input) {
+			break
+		}
+		i++
+		if i < len(input) {
+			value |= int(input[i]) << 16
+		}
+		out.WriteByte(phpassItoa64[(value>>12)&0x3f])
+		if i >= len(input) {
+			break
+		}
+		i++
+		out.WriteByte(phpassItoa64[(value>>18)&0x3f])
+	}
+	return out.String()
+}
+
+// decodeDjangoPbkdf2Hash decodes a hash of Django's PBKDF2 password hashers.
+// format: pbkdf2_<digest>$<iterations>$<salt>$<hash>
+func decodeDjangoPbkdf2Hash(encodedHash string) (hasher func() hash.Hash, iterations int, salt, hash []byte, err error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 4 {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	switch parts[0] {
+	case "pbkdf2_sha1":
+		hasher = sha1.New //#nosec G401 -- compatibility for imported passwords
+	case "pbkdf2_sha256":
+		hasher = sha256.New
+	default:
+		return nil, 0, nil, nil, errors.WithStack(ErrUnknownHashAlgorithm)
+	}
+
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	hash, err = base64.StdEncoding.Strict().DecodeString(parts[3])
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+
+	// Django uses the salt as is, it is not encoded.
+	return hasher, iterations, []byte(parts[2]), hash, nil
+}
+
+// keycloakCredential is a password credential as contained in Keycloak realm exports. The secret and credential
+// data are JSON documents which Keycloak exports as strings.
+type keycloakCredential struct {
+	SecretData     json.RawMessage `json:"secretData"`
+	CredentialData json.RawMessage `json:"credentialData"`
+}
+
+// decodeKeycloakHash decodes a PBKDF2 password credential exported from Keycloak.
+func decodeKeycloakHash(encodedHash []byte) (hasher func() hash.Hash, iterations int, salt, hash []byte, err error) {
+	var credential keycloakCredential
+	if err := json.Unmarshal(encodedHash, &credential); err != nil {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	var secret struct {
+		Value string `json:"value"`
+		Salt  string `json:"salt"`
+	}
+	if err := decodeKeycloakData(credential.SecretData, &secret); err != nil {
+		return nil, 0, nil, nil, err
+	}
+
+	var data struct {
+		HashIterations int    `json:"hashIterations"`
+		Algorithm      string `json:"algorithm"`
+	}
+	if err := decodeKeycloakData(credential.CredentialData, &data); err != nil {
+		return nil, 0, nil, nil, err
+	}
+
+	switch data.Algorithm {
+	case "pbkdf2":
+		hasher = sha1.New //#nosec G401 -- compatibility for imported passwords
+	case "pbkdf2-sha256":
+		hasher = sha256.New
+	case "pbkdf2-sha512":
+		hasher = sha512.New
+	default:
+		return nil, 0, nil, nil, errors.WithStack(ErrUnknownHashAlgorithm)
+	}
+
+	if data.HashIterations < 1 {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	salt, err = base64.StdEncoding.Strict().DecodeString(secret.Salt)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+
+	hash, err = base64.StdEncoding.Strict().DecodeString(secret.Value)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	if len(hash) == 0 {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	return hasher, data.HashIterations, salt, hash, nil
+}
+
+// decodeKeycloakData decodes Keycloak's secret or credential data, which is either a JSON object or a string
+// containing a JSON object.
+func decodeKeycloakData(raw json.RawMessage, v interface{}) error {
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrInvalidHash
+	}
+	return nil
+}
+
+const (
+	aspNetIdentityV2 = 0x00
+	aspNetIdentityV3 = 0x01
+)
+
+// decodeASPNetIdentityHash decodes a base64 encoded ASP.NET Identity hash.
+// format v2: 0x00 | salt (16 bytes) | hash (32 bytes), using PBKDF2 with HMAC-SHA1 and 1000 iterations
+// format v3: 0x01 | prf (uint32) | iterations (uint32) | salt length (uint32) | salt | hash, all integers big-endian
+func decodeASPNetIdentityHash(encodedHash string) (hasher func() hash.Hash, iterations int, salt, hash []byte, err error) {
+	decoded, err := base64.StdEncoding.Strict().DecodeString(encodedHash)
+	if err != nil || len(decoded) == 0 {
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+
+	switch decoded[0] {
+	case aspNetIdentityV2:
+		if len(decoded) != 1+16+32 {
+			return nil, 0, nil, nil, ErrInvalidHash
+		}
+		//#nosec G401 -- compatibility for imported passwords
+		return sha1.New, 1000, decoded[1:17], decoded[17:], nil
+	case aspNetIdentityV3:
+		if len(decoded) < 13 {
+			return nil, 0, nil, nil, ErrInvalidHash
+		}
+
+		switch binary.BigEndian.Uint32(decoded[1:5]) {
+		case 0:
+			hasher = sha1.New //#nosec G401 -- compatibility for imported passwords
+		case 1:
+			hasher = sha256.New
+		case 2:
+			hasher = sha512.New
+		default:
+			return nil, 0, nil, nil, errors.WithStack(ErrUnknownHashAlgorithm)
+		}
+
+		iterations := binary.BigEndian.Uint32(decoded[5:9])
+		saltLength := binary.BigEndian.Uint32(decoded[9:13])
+		if iterations < 1 || iterations > 1<<30 || saltLength < 8 || uint64(len(decoded)) < 13+uint64(saltLength)+16 {
+			return nil, 0, nil, nil, ErrInvalidHash
+		}
+
+		return hasher, int(iterations), decoded[13 : 13+saltLength], decoded[13+saltLength:], nil
+	default:
+		return nil, 0, nil, nil, ErrInvalidHash
+	}
+}
+
 func comparePasswordHashConstantTime(hash, otherHash []byte) error {
 	// use subtle.ConstantTimeCompare() to prevent timing attacks.
 	if subtle.ConstantTimeCompare(hash, otherHash) == 1 {
@@ -14,6 +14,14 @@ type Hasher interface {
 
 	// Understands returns whether the given hash can be understood by this hasher.
 	Understands(hash []byte) bool
+
+	// Compare returns nil if the password matches the hash. Besides the hashes generated by this hasher, it
+	// supports all hash formats known to the package's Compare function.
+	Compare(ctx context.Context, password []byte, hash []byte) error
+
+	// NeedsRehash returns whether the given hash should be replaced by a new hash of the same password, because it
+	// is not understood by this hasher or because it was generated with weaker parameters than the configured ones.
+	NeedsRehash(ctx context.Context, hash []byte) bool
 }
 
 type HashProvider interface {
@@ -79,6 +79,30 @@ func (h *Argon2) Generate(ctx context.Context, password []byte) ([]byte, error)
 	return b.Bytes(), nil
 }
 
+func (h *Argon2) Compare(ctx context.Context, password []byte, hash []byte) error {
+	return Compare(ctx, password, hash)
+}
+
 func (h *Argon2) Understands(hash []byte) bool {
 	return IsArgon2idHash(hash)
 }
+
+// NeedsRehash returns true if the hash was not generated by Argon2id or if its memory, iterations, salt length, or
+// key length are below the configured values. The parallelism is not compared because it defaults to the number of
+// CPUs, which may differ between instances, and does not weaken the hash.
+func (h *Argon2) NeedsRehash(ctx context.Context, hash []byte) bool {
+	if !h.Understands(hash) {
+		return true
+	}
+
+	p, _, _, err := decodeArgon2idHash(string(hash))
+	if err != nil {
+		return true
+	}
+
+	conf := h.c.Config().HasherArgon2(ctx)
+	return uint32(p.Memory) < toKB(conf.Memory) ||
+		p.Iterations < conf.Iterations ||
+		p.SaltLength < conf.SaltLength ||
+		p.KeyLength < conf.KeyLength
+}
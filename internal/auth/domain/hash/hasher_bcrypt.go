@@ -66,6 +66,24 @@ func validateBcryptPasswordLength(password []byte) error {
 	return nil
 }
 
+func (h *Bcrypt) Compare(ctx context.Context, password []byte, hash []byte) error {
+	return Compare(ctx, password, hash)
+}
+
 func (h *Bcrypt) Understands(hash []byte) bool {
 	return IsBcryptHash(hash)
 }
+
+// NeedsRehash returns true if the hash was not generated by bcrypt or if its cost is below the configured cost.
+func (h *Bcrypt) NeedsRehash(ctx context.Context, hash []byte) bool {
+	if !h.Understands(hash) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return true
+	}
+
+	return uint32(cost) < h.c.Config().HasherBcrypt(ctx).Cost
+}
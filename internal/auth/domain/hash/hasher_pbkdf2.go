@@ -62,10 +62,32 @@ func (h *Pbkdf2) Generate(ctx context.Context, password []byte) ([]byte, error)
 	return b.Bytes(), nil
 }
 
+func (h *Pbkdf2) Compare(ctx context.Context, password []byte, hash []byte) error {
+	return Compare(ctx, password, hash)
+}
+
 func (h *Pbkdf2) Understands(hash []byte) bool {
 	return IsPbkdf2Hash(hash)
 }
 
+// NeedsRehash returns true if the hash was not generated by PBKDF2, uses a different pseudorandom function, or has
+// fewer iterations, a shorter salt, or a shorter key than this hasher.
+func (h *Pbkdf2) NeedsRehash(_ context.Context, hash []byte) bool {
+	if !h.Understands(hash) {
+		return true
+	}
+
+	p, _, _, err := decodePbkdf2Hash(string(hash))
+	if err != nil {
+		return true
+	}
+
+	return p.Algorithm != h.Algorithm ||
+		p.Iterations < h.Iterations ||
+		p.SaltLength < h.SaltLength ||
+		p.KeyLength < h.KeyLength
+}
+
 func getPseudorandomFunctionForPbkdf2(alg string) func() hash.Hash {
 	switch alg {
 	case "sha1":
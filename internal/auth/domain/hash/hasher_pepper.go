@@ -0,0 +1,119 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package hash
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+var ErrUnknownPepper = errors.New("the password hash was peppered with an unknown secret")
+
+const pepperPrefix = "$pepper$k="
+
+// Pepper is a Hasher which combines the password with a server-side secret, the pepper, using HMAC-SHA256 before
+// passing it to the wrapped Hasher. The ID of the secret is stored with the hash, so that the secret can be
+// rotated.
+//
+// format: $pepper$k=<secret id><hash of the wrapped hasher>
+type Pepper struct {
+	Hasher
+	c PepperConfiguration
+}
+
+type PepperConfiguration interface {
+	config.Provider
+}
+
+// NewHasherPepper returns a Hasher which peppers passwords before hashing them with h. If no pepper secrets are
+// configured, it behaves exactly like h.
+func NewHasherPepper(c PepperConfiguration, h Hasher) *Pepper {
+	return &Pepper{Hasher: h, c: c}
+}
+
+func (h *Pepper) Generate(ctx context.Context, password []byte) ([]byte, error) {
+	secrets := h.c.Config().SecretsPepper(ctx)
+	if len(secrets) == 0 {
+		return h.Hasher.Generate(ctx, password)
+	}
+
+	hashed, err := h.Hasher.Generate(ctx, pepper(secrets[0], password))
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if _, err := fmt.Fprintf(&b, "%s%s%s", pepperPrefix, pepperSecretID(secrets[0]), hashed); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b.Bytes(), nil
+}
+
+func (h *Pepper) Compare(ctx context.Context, password []byte, hash []byte) error {
+	id, hashed, ok := decodePepperedHash(hash)
+	if !ok {
+		// Hashes which were not peppered, for example imported ones, are compared as is.
+		return h.Hasher.Compare(ctx, password, hash)
+	}
+
+	for _, secret := range h.c.Config().SecretsPepper(ctx) {
+		if pepperSecretID(secret) == id {
+			return h.Hasher.Compare(ctx, pepper(secret, password), hashed)
+		}
+	}
+
+	return errors.WithStack(ErrUnknownPepper)
+}
+
+func (h *Pepper) Understands(hash []byte) bool {
+	if _, hashed, ok := decodePepperedHash(hash); ok {
+		return h.Hasher.Understands(hashed)
+	}
+	return h.Hasher.Understands(hash)
+}
+
+// NeedsRehash returns true if the hash needs to be rehashed by the wrapped hasher, or if it was not peppered with
+// the current secret.
+func (h *Pepper) NeedsRehash(ctx context.Context, hash []byte) bool {
+	id, hashed, ok := decodePepperedHash(hash)
+
+	secrets := h.c.Config().SecretsPepper(ctx)
+	if len(secrets) == 0 {
+		return ok || h.Hasher.NeedsRehash(ctx, hash)
+	}
+
+	return !ok || id != pepperSecretID(secrets[0]) || h.Hasher.NeedsRehash(ctx, hashed)
+}
+
+// decodePepperedHash returns the ID of the pepper secret and the hash of the peppered password.
+func decodePepperedHash(hash []byte) (id string, hashed []byte, ok bool) {
+	if !IsPepperedHash(hash) {
+		return "", nil, false
+	}
+
+	rest := hash[len(pepperPrefix):]
+	end := bytes.IndexByte(rest, '$')
+	return string(rest[:end]), rest[end:], true
+}
+
+func pepper(secret, password []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(password)
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// pepperSecretID derives a short, non-secret identifier from a pepper secret.
+func pepperSecretID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:4])
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/external"
 	"my.com/secrets/internal/auth/domain/hash"
 )
@@ -200,6 +201,166 @@ func TestPbkdf2Hasher(t *testing.T) {
 	}
 }
 
+func TestScryptHasher(t *testing.T) {
+	t.Parallel()
+	for _, pwLength := range []int{
+		8,
+		16,
+		32,
+		64,
+		128,
+	} {
+		pwLength := pwLength
+		t.Run(fmt.Sprintf("length=%dchars", pwLength), func(t *testing.T) {
+			t.Parallel()
+			hasher := &hash.Scrypt{
+				Cost:           1024,
+				Block:          8,
+				Parrellization: 1,
+				SaltLength:     16,
+				KeyLength:      32,
+			}
+			pw := mkpw(t, pwLength)
+			hs, err := hasher.Generate(context.Background(), pw)
+			require.NoError(t, err)
+			assert.NotEqual(t, pw, hs)
+
+			t.Logf("hash: %s", hs)
+			require.NoError(t, hash.CompareScrypt(context.Background(), pw, hs))
+
+			assert.True(t, hasher.Understands(hs))
+
+			mod := make([]byte, len(pw))
+			copy(mod, pw)
+			mod[len(pw)-1] = ^pw[len(pw)-1]
+			require.Error(t, hash.CompareScrypt(context.Background(), mod, hs))
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	pw := mkpw(t, 16)
+
+	t.Run("hasher=argon2", func(t *testing.T) {
+		t.Parallel()
+		conf, reg := external.NewFastRegistryWithMocks(t)
+		conf.MustSet(ctx, config.ViperKeyHasherArgon2ConfigMemory, "1MB")
+		conf.MustSet(ctx, config.ViperKeyHasherArgon2ConfigIterations, 2)
+		hasher := hash.NewHasherArgon2(reg)
+
+		hs, err := hasher.Generate(ctx, pw)
+		require.NoError(t, err)
+		assert.False(t, hasher.NeedsRehash(ctx, hs))
+
+		conf.MustSet(ctx, config.ViperKeyHasherArgon2ConfigIterations, 3)
+		assert.True(t, hasher.NeedsRehash(ctx, hs))
+
+		conf.MustSet(ctx, config.ViperKeyHasherArgon2ConfigIterations, 1)
+		assert.False(t, hasher.NeedsRehash(ctx, hs), "stronger hashes are kept")
+
+		conf.MustSet(ctx, config.ViperKeyHasherArgon2ConfigMemory, "2MB")
+		assert.True(t, hasher.NeedsRehash(ctx, hs))
+
+		assert.True(t, hasher.NeedsRehash(ctx, []byte("$2a$12$o6hx.Wog/wvFSkT/Bp/6DOxCtLRTDj7lm9on9suF/WaCGNVHbkfL6")))
+	})
+
+	t.Run("hasher=bcrypt", func(t *testing.T) {
+		t.Parallel()
+		conf, reg := external.NewFastRegistryWithMocks(t)
+		conf.MustSet(ctx, config.ViperKeyHasherBcryptCost, 4)
+		hasher := hash.NewHasherBcrypt(reg)
+
+		hs, err := hasher.Generate(ctx, pw)
+		require.NoError(t, err)
+		assert.False(t, hasher.NeedsRehash(ctx, hs))
+
+		conf.MustSet(ctx, config.ViperKeyHasherBcryptCost, 5)
+		assert.True(t, hasher.NeedsRehash(ctx, hs))
+
+		assert.True(t, hasher.NeedsRehash(ctx, []byte("$argon2id$v=19$m=32,t=2,p=4$cm94YnRVOW5jZzFzcVE4bQ$MNzk5BtR2vUhrp6qQEjRNw")))
+	})
+
+	t.Run("hasher=pbkdf2", func(t *testing.T) {
+		t.Parallel()
+		hasher := &hash.Pbkdf2{Algorithm: "sha256", Iterations: 1000, SaltLength: 16, KeyLength: 32}
+		hs, err := hasher.Generate(ctx, pw)
+		require.NoError(t, err)
+		assert.False(t, hasher.NeedsRehash(ctx, hs))
+
+		for _, h := range []*hash.Pbkdf2{
+			{Algorithm: "sha512", Iterations: 1000, SaltLength: 16, KeyLength: 32},
+			{Algorithm: "sha256", Iterations: 2000, SaltLength: 16, KeyLength: 32},
+			{Algorithm: "sha256", Iterations: 1000, SaltLength: 32, KeyLength: 32},
+			{Algorithm: "sha256", Iterations: 1000, SaltLength: 16, KeyLength: 64},
+		} {
+			assert.True(t, h.NeedsRehash(ctx, hs), "%+v", h)
+		}
+		assert.False(t, (&hash.Pbkdf2{Algorithm: "sha256", Iterations: 500, SaltLength: 16, KeyLength: 32}).NeedsRehash(ctx, hs))
+	})
+
+	t.Run("hasher=scrypt", func(t *testing.T) {
+		t.Parallel()
+		hasher := &hash.Scrypt{Cost: 1024, Block: 8, Parrellization: 1, SaltLength: 16, KeyLength: 32}
+		hs, err := hasher.Generate(ctx, pw)
+		require.NoError(t, err)
+		assert.False(t, hasher.NeedsRehash(ctx, hs))
+
+		for _, h := range []*hash.Scrypt{
+			{Cost: 2048, Block: 8, Parrellization: 1, SaltLength: 16, KeyLength: 32},
+			{Cost: 1024, Block: 16, Parrellization: 1, SaltLength: 16, KeyLength: 32},
+			{Cost: 1024, Block: 8, Parrellization: 2, SaltLength: 16, KeyLength: 32},
+			{Cost: 1024, Block: 8, Parrellization: 1, SaltLength: 32, KeyLength: 32},
+			{Cost: 1024, Block: 8, Parrellization: 1, SaltLength: 16, KeyLength: 64},
+		} {
+			assert.True(t, h.NeedsRehash(ctx, hs), "%+v", h)
+		}
+		assert.True(t, hasher.NeedsRehash(ctx, []byte("$pbkdf2-sha256$i=100000,l=32$1jP+5Zxpxgtee/iPxGgOz0RfE9/KJuDElP1ley4VxXc$QJxzfvdbHYBpydCbHoFg3GJEqMFULwskiuqiJctoYpI")))
+	})
+}
+
+func TestPepperHasher(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	conf.MustSet(ctx, config.ViperKeyHasherBcryptCost, 4)
+	hasher := hash.NewHasherPepper(reg, hash.NewHasherBcrypt(reg))
+	pw := mkpw(t, 16)
+
+	unpeppered, err := hasher.Generate(ctx, pw)
+	require.NoError(t, err)
+	assert.True(t, hash.IsBcryptHash(unpeppered))
+	assert.False(t, hasher.NeedsRehash(ctx, unpeppered))
+
+	conf.MustSet(ctx, config.ViperKeySecretsPepper, []string{"first-pepper-secret-with-32-bytes"})
+	assert.True(t, hasher.NeedsRehash(ctx, unpeppered), "hashes without pepper are re-peppered")
+	require.NoError(t, hasher.Compare(ctx, pw, unpeppered), "hashes without pepper can still be compared")
+
+	first, err := hasher.Generate(ctx, pw)
+	require.NoError(t, err)
+	t.Logf("hash: %s", first)
+	assert.True(t, hash.IsPepperedHash(first))
+	assert.True(t, hash.IsValidHashFormat(first))
+	assert.True(t, hasher.Understands(first))
+	assert.False(t, hasher.NeedsRehash(ctx, first))
+	require.NoError(t, hasher.Compare(ctx, pw, first))
+	require.Error(t, hasher.Compare(ctx, mkpw(t, 16), first))
+	require.Error(t, hash.Compare(ctx, pw, first), "peppered hashes can not be compared without the secret")
+
+	conf.MustSet(ctx, config.ViperKeySecretsPepper, []string{"second-pepper-secret-with-32-bytes", "first-pepper-secret-with-32-bytes"})
+	require.NoError(t, hasher.Compare(ctx, pw, first), "hashes peppered with a rotated secret can still be compared")
+	assert.True(t, hasher.NeedsRehash(ctx, first), "hashes peppered with a rotated secret are re-peppered")
+
+	second, err := hasher.Generate(ctx, pw)
+	require.NoError(t, err)
+	assert.False(t, hasher.NeedsRehash(ctx, second))
+	require.NoError(t, hasher.Compare(ctx, pw, second))
+
+	conf.MustSet(ctx, config.ViperKeySecretsPepper, []string{"second-pepper-secret-with-32-bytes"})
+	require.ErrorIs(t, hasher.Compare(ctx, pw, first), hash.ErrUnknownPepper)
+}
+
 func TestCompare(t *testing.T) {
 	t.Parallel()
 	t.Run("unknown", func(t *testing.T) {
@@ -534,4 +695,64 @@ func TestCompare(t *testing.T) {
 		assert.Error(t, hash.Compare(context.Background(), []byte("ory"), []byte("$hmac-sha512$OTFmODY0ZTI1NmU0ZjVhYjhiMDViZGFmNGVmNGZmMGVlNTY4ODYwNWJhYTk4MTk2OTgyMzc3NzI1YTc4MzcxMTMzNzZmY2YxYTk5MGMxM2RiZDk2MGFmMmQ1YzRmODdlMGMwYTNkYjcyNjY0NjM4NGE4YzQ2MjNhZDZkN2UxZTE=$MTIzNDU=")))
 
 	})
+
+	t.Run("phpass", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, hash.IsValidHashFormat([]byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0")))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test12345"), []byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0")))
+		assert.Nil(t, hash.ComparePHPass(context.Background(), []byte("test12345"), []byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("test12346"), []byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("test12345"), []byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L1")))
+		assert.False(t, hash.IsPHPassHash([]byte("$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r")))
+	})
+
+	t.Run("drupal7", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, hash.IsValidHashFormat([]byte("$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL9")))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte("$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL9")))
+		assert.Nil(t, hash.CompareDrupal7(context.Background(), []byte("test"), []byte("$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL9")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("tesT"), []byte("$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL9")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("test"), []byte("$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL8")))
+	})
+
+	t.Run("django-pbkdf2", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, hash.IsValidHashFormat([]byte("pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=")))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte("pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=")))
+		assert.Nil(t, hash.CompareDjangoPbkdf2(context.Background(), []byte("test"), []byte("pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("ory"), []byte("pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("test"), []byte("pbkdf2_sha256$10001$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("test"), []byte("pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK5=")))
+	})
+
+	t.Run("keycloak", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, hash.IsValidHashFormat([]byte(`{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\", \"additionalParameters\": {}}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"pbkdf2-sha256\", \"additionalParameters\": {}}"}`)))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte(`{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\", \"additionalParameters\": {}}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"pbkdf2-sha256\", \"additionalParameters\": {}}"}`)))
+		assert.Nil(t, hash.CompareKeycloak(context.Background(), []byte("test"), []byte(`{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\", \"additionalParameters\": {}}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"pbkdf2-sha256\", \"additionalParameters\": {}}"}`)))
+		assert.Error(t, hash.Compare(context.Background(), []byte("ory"), []byte(`{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\", \"additionalParameters\": {}}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"pbkdf2-sha256\", \"additionalParameters\": {}}"}`)))
+
+		// The secret and credential data may also be objects instead of encoded strings.
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte(`{"secretData": {"value": "U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==", "salt": "MDEyMzQ1Njc4OWFiY2RlZg=="}, "credentialData": {"hashIterations": 27500, "algorithm": "pbkdf2-sha256"}}`)))
+
+		assert.False(t, hash.IsValidHashFormat([]byte(`{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\", \"additionalParameters\": {}}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"argon2\", \"additionalParameters\": {}}"}`)))
+		assert.False(t, hash.IsKeycloakHash([]byte(`{"foo": "bar"}`)))
+	})
+
+	t.Run("aspnet-identity", func(t *testing.T) {
+		t.Parallel()
+		// v2
+		assert.True(t, hash.IsValidHashFormat([]byte("ADAxMjM0NTY3ODlhYmNkZWZC8QbGL3u+Rg2fp+ODe75jlfJBzoOuyDgtN2UtxMC9sA==")))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte("ADAxMjM0NTY3ODlhYmNkZWZC8QbGL3u+Rg2fp+ODe75jlfJBzoOuyDgtN2UtxMC9sA==")))
+		assert.Nil(t, hash.CompareASPNetIdentity(context.Background(), []byte("test"), []byte("ADAxMjM0NTY3ODlhYmNkZWZC8QbGL3u+Rg2fp+ODe75jlfJBzoOuyDgtN2UtxMC9sA==")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("ory"), []byte("ADAxMjM0NTY3ODlhYmNkZWZC8QbGL3u+Rg2fp+ODe75jlfJBzoOuyDgtN2UtxMC9sA==")))
+
+		// v3
+		assert.True(t, hash.IsValidHashFormat([]byte("AQAAAAEAACcQAAAAEDAxMjM0NTY3ODlhYmNkZWaA7Atf1ISgKGxp+xDpjI71xe6yw/ZAd2RGlFcQqB/ZIg==")))
+		assert.Nil(t, hash.Compare(context.Background(), []byte("test"), []byte("AQAAAAEAACcQAAAAEDAxMjM0NTY3ODlhYmNkZWaA7Atf1ISgKGxp+xDpjI71xe6yw/ZAd2RGlFcQqB/ZIg==")))
+		assert.Error(t, hash.Compare(context.Background(), []byte("ory"), []byte("AQAAAAEAACcQAAAAEDAxMjM0NTY3ODlhYmNkZWaA7Atf1ISgKGxp+xDpjI71xe6yw/ZAd2RGlFcQqB/ZIg==")))
+
+		assert.False(t, hash.IsASPNetIdentityHash([]byte("dGVzdA==")))
+		assert.False(t, hash.IsASPNetIdentityHash([]byte("not base64")))
+	})
 }
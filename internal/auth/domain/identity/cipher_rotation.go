@@ -0,0 +1,160 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/pagination/keysetpagination"
+)
+
+type (
+	// CipherRotationOptions configure the re-encryption of encrypted identity data.
+	CipherRotationOptions struct {
+		// After resumes an interrupted rotation after the identity with this ID.
+		After uuid.UUID
+
+		// BatchSize is the number of identities which are loaded at once. Defaults to identityBatchSize.
+		BatchSize int
+
+		// DryRun only counts the values which are not encrypted with the current secret.
+		DryRun bool
+
+		// Progress is called after every batch of identities with the report so far.
+		Progress func(report *CipherRotationReport)
+	}
+
+	// CipherRotationReport summarizes the re-encryption of encrypted identity data.
+	CipherRotationReport struct {
+		// Identities is the number of processed identities.
+		Identities int `json:"identities"`
+
+		// Reencrypted is the number of values which were encrypted with the current secret.
+		Reencrypted int `json:"reencrypted"`
+
+		// Remaining is the number of values which are still encrypted or blind indexed with an older secret. The
+		// older secrets can only be removed once no values remain.
+		Remaining int `json:"remaining"`
+
+		// LastIdentityID is the ID of the last processed identity. Use it to resume an interrupted rotation.
+		LastIdentityID uuid.UUID `json:"last_identity_id"`
+	}
+)
+
+// RotateCipherSecrets encrypts all encrypted identity data with the current cipher secret: the initial tokens of
+// OpenID Connect credentials and the encrypted traits. It also computes the blind indexes of credential identifiers
+// and addresses which equal an encrypted trait with the current blind index secret.
+func (m *Manager) RotateCipherSecrets(ctx context.Context, opts CipherRotationOptions) (_ *CipherRotationReport, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.RotateCipherSecrets")
+	defer otelx.End(span, &err)
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = identityBatchSize
+	}
+
+	pagination := []keysetpagination.Option{keysetpagination.WithSize(opts.BatchSize)}
+	if opts.After != uuid.Nil {
+		pagination = append(pagination, keysetpagination.WithToken(keysetpagination.StringPageToken(opts.After.String())))
+	}
+
+	report := CipherRotationReport{LastIdentityID: opts.After}
+	err = m.forEachIdentityBatch(ctx, ListIdentityParameters{KeySetPagination: pagination}, func(is []Identity) error {
+		ids := make([]uuid.UUID, len(is))
+		for k := range is {
+			ids[k] = is[k].ID
+		}
+		stale, err := m.r.PrivilegedIdentityPool().CountStaleSealedValues(ctx, ids...)
+		if err != nil {
+			return err
+		}
+
+		for k := range is {
+			if err := m.rotateCipherSecrets(ctx, &is[k], stale[is[k].ID], opts.DryRun, &report); err != nil {
+				return err
+			}
+			report.Identities++
+			report.LastIdentityID = is[k].ID
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(&report)
+		}
+		return nil
+	})
+	return &report, err
+}
+
+// rotateCipherSecrets re-encrypts the OpenID Connect tokens of the identity and updates it if they or any of the
+// stale sealed values, which the persister seals again on update, need to be encrypted with the current secrets.
+func (m *Manager) rotateCipherSecrets(ctx context.Context, i *Identity, stale int, dryRun bool, report *CipherRotationReport) error {
+	if dryRun {
+		report.Remaining += stale
+	}
+
+	changed, err := m.rotateOIDCTokens(ctx, i, dryRun, report)
+	if err != nil {
+		return err
+	} else if dryRun || (!changed && stale == 0) {
+		return nil
+	}
+
+	if err := m.r.PrivilegedIdentityPool().UpdateIdentity(ctx, i); err != nil {
+		return err
+	}
+	report.Reencrypted += stale
+	return nil
+}
+
+func (m *Manager) rotateOIDCTokens(ctx context.Context, i *Identity, dryRun bool, report *CipherRotationReport) (bool, error) {
+	c, ok := i.GetCredentials(CredentialsTypeOIDC)
+	if !ok || len(c.Config) == 0 {
+		return false, nil
+	}
+
+	var conf CredentialsOIDC
+	if err := json.Unmarshal(c.Config, &conf); err != nil {
+		return false, errors.WithStack(herodot.ErrInternalServerError.WithReason("The OpenID Connect credentials could not be decoded properly").WithDebug(err.Error()).WithWrap(err))
+	}
+
+	crypter := m.r.Cipher(ctx)
+	var changed bool
+	for k := range conf.Providers {
+		for _, token := range []*string{
+			&conf.Providers[k].InitialIDToken,
+			&conf.Providers[k].InitialAccessToken,
+			&conf.Providers[k].InitialRefreshToken,
+		} {
+			if !crypter.NeedsReencryption(ctx, *token) {
+				continue
+			} else if dryRun {
+				report.Remaining++
+				continue
+			}
+
+			plaintext, err := crypter.Decrypt(ctx, *token)
+			if err != nil {
+				m.r.Logger().WithError(err).WithField("identity_id", i.ID).Warn("Unable to decrypt an initial OpenID Connect token, skipping it.")
+				report.Remaining++
+				continue
+			}
+
+			if *token, err = crypter.Encrypt(ctx, plaintext); err != nil {
+				return false, err
+			}
+			changed = true
+			report.Reencrypted++
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, i.SetCredentialsWithConfig(CredentialsTypeOIDC, *c, conf)
+}
@@ -166,6 +166,10 @@ type Credentials struct {
 	// Version refers to the version of the credential. Useful when changing the config schema.
 	Version int `json:"version" db:"version"`
 
+	// PasswordChangedAt copies the time the password was last set from the config of password credentials when
+	// they are stored, so that expiring passwords can be queried. It is not loaded.
+	PasswordChangedAt *time.Time `json:"-" faker:"-" db:"password_changed_at"`
+
 	IdentityID uuid.UUID `json:"-" faker:"-" db:"identity_id"`
 
 	// CreatedAt is a helper struct field for gobuffalo.pop.
@@ -189,6 +193,9 @@ type (
 	CredentialIdentifier struct {
 		ID         uuid.UUID `db:"id"`
 		Identifier string    `db:"identifier"`
+		// IdentifierBlindIndex is the blind index of identifiers which equal an encrypted trait. The identifier
+		// column of such rows does not contain the identifier.
+		IdentifierBlindIndex sqlxx.NullString `json:"-" db:"identifier_blind_index"`
 		// IdentityCredentialsID is a helper struct field for gobuffalo.pop.
 		IdentityCredentialsID uuid.UUID `json:"-" db:"identity_credential_id"`
 		// IdentityCredentialsTypeID is a helper struct field for gobuffalo.pop.
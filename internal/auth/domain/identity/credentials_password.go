@@ -3,10 +3,96 @@
 
 package identity
 
+import (
+	"context"
+	"time"
+
+	"my.com/secrets/internal/auth/domain/hash"
+)
+
 // CredentialsPassword is contains the configuration for credentials of the type password.
 //
 // swagger:model identityCredentialsPassword
 type CredentialsPassword struct {
 	// HashedPassword is a hash-representation of the password.
 	HashedPassword string `json:"hashed_password"`
+
+	// PreviousHashedPasswords are the hashes of the previously used passwords, most recent first. They are kept
+	// to prevent password reuse if the password history is enabled.
+	PreviousHashedPasswords []string `json:"previous_hashed_passwords,omitempty"`
+
+	// PasswordChangedAt is the time the password was last set. It is empty for passwords which were set before
+	// password changes were tracked. Such passwords never expire.
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty"`
+
+	// ChangeRequiredAt is set if an administrator requires the password to be changed. Sessions which are
+	// authenticated with the password after this point in time must change the password first.
+	ChangeRequiredAt *time.Time `json:"change_required_at,omitempty"`
+
+	// ExpiryReminderSentAt is the time the reminder about the upcoming expiry of the password was sent.
+	ExpiryReminderSentAt *time.Time `json:"expiry_reminder_sent_at,omitempty"`
+}
+
+// IsReused returns true if the password matches the current password or one of the last historySize passwords.
+func (cp *CredentialsPassword) IsReused(ctx context.Context, hasher hash.Hasher, password []byte, historySize int) bool {
+	if historySize <= 0 {
+		return false
+	}
+
+	hashes := append([]string{cp.HashedPassword}, cp.PreviousHashedPasswords[:min(historySize, len(cp.PreviousHashedPasswords))]...)
+	for _, hashed := range hashes {
+		if len(hashed) > 0 && hasher.Compare(ctx, password, []byte(hashed)) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rotate replaces the hashed password and keeps the previous one in the history, which is limited to the last
+// historySize passwords. Unless the hash stays the same, it also resets the password's age and clears any
+// required password change.
+func (cp *CredentialsPassword) Rotate(hashedPassword string, historySize int) {
+	changed := cp.HashedPassword != hashedPassword || cp.PasswordChangedAt == nil
+	previous := cp.PreviousHashedPasswords
+	if len(cp.HashedPassword) > 0 && cp.HashedPassword != hashedPassword {
+		previous = append([]string{cp.HashedPassword}, previous...)
+	}
+
+	cp.HashedPassword = hashedPassword
+	cp.PreviousHashedPasswords = nil
+	if historySize > 0 && len(previous) > 0 {
+		cp.PreviousHashedPasswords = previous[:min(historySize, len(previous))]
+	}
+
+	if changed {
+		now := time.Now().UTC()
+		cp.PasswordChangedAt = &now
+		cp.ChangeRequiredAt = nil
+		cp.ExpiryReminderSentAt = nil
+	}
+}
+
+// ExpiresAt returns the time the password expires given its maximum age. It returns false if the password
+// does not expire.
+func (cp *CredentialsPassword) ExpiresAt(maxAge time.Duration) (time.Time, bool) {
+	if maxAge <= 0 || cp.PasswordChangedAt == nil {
+		return time.Time{}, false
+	}
+	return cp.PasswordChangedAt.Add(maxAge), true
+}
+
+// ChangeRequiredSince returns the point in time since which the password must be changed, either because it
+// expired or because an administrator required it. It returns false if no change is required at the given time.
+func (cp *CredentialsPassword) ChangeRequiredSince(maxAge time.Duration, now time.Time) (time.Time, bool) {
+	since, required := time.Time{}, false
+	if cp.ChangeRequiredAt != nil && !cp.ChangeRequiredAt.After(now) {
+		since, required = *cp.ChangeRequiredAt, true
+	}
+
+	if expiresAt, ok := cp.ExpiresAt(maxAge); ok && !expiresAt.After(now) && (!required || expiresAt.Before(since)) {
+		since, required = expiresAt, true
+	}
+
+	return since, required
 }
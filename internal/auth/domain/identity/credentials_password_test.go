@@ -0,0 +1,115 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"my.com/secrets/internal/auth/domain/hash"
+)
+
+func TestCredentialsPasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	hasher := &hash.Pbkdf2{}
+
+	hashes := map[string]string{}
+	for _, pw := range []string{"first", "second", "third", "fourth"} {
+		h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.MinCost)
+		require.NoError(t, err)
+		hashes[pw] = string(h)
+	}
+
+	var cp CredentialsPassword
+	for _, pw := range []string{"first", "second", "third", "fourth"} {
+		cp.Rotate(hashes[pw], 2)
+	}
+	assert.Equal(t, hashes["fourth"], cp.HashedPassword)
+	assert.Equal(t, []string{hashes["third"], hashes["second"]}, cp.PreviousHashedPasswords)
+
+	t.Run("case=reuse is detected within the history size", func(t *testing.T) {
+		for _, pw := range []string{"fourth", "third", "second"} {
+			assert.True(t, cp.IsReused(ctx, hasher, []byte(pw), 2), pw)
+		}
+		assert.False(t, cp.IsReused(ctx, hasher, []byte("first"), 2))
+		assert.False(t, cp.IsReused(ctx, hasher, []byte("second"), 1))
+		assert.False(t, cp.IsReused(ctx, hasher, []byte("fourth"), 0))
+	})
+
+	t.Run("case=rotating to the same hash keeps the history", func(t *testing.T) {
+		cp := cp
+		cp.Rotate(hashes["fourth"], 2)
+		assert.Equal(t, []string{hashes["third"], hashes["second"]}, cp.PreviousHashedPasswords)
+	})
+
+	t.Run("case=disabling the history drops it", func(t *testing.T) {
+		cp := cp
+		cp.Rotate(hashes["first"], 0)
+		assert.Equal(t, hashes["first"], cp.HashedPassword)
+		assert.Empty(t, cp.PreviousHashedPasswords)
+	})
+}
+
+func TestCredentialsPasswordExpiry(t *testing.T) {
+	now := time.Now().UTC()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	t.Run("case=passwords without a change timestamp never expire", func(t *testing.T) {
+		cp := CredentialsPassword{HashedPassword: "foo"}
+		_, ok := cp.ExpiresAt(time.Hour)
+		assert.False(t, ok)
+		_, required := cp.ChangeRequiredSince(time.Hour, now)
+		assert.False(t, required)
+	})
+
+	t.Run("case=passwords expire after the maximum age", func(t *testing.T) {
+		cp := CredentialsPassword{HashedPassword: "foo", PasswordChangedAt: at(-2 * time.Hour)}
+
+		since, required := cp.ChangeRequiredSince(time.Hour, now)
+		assert.True(t, required)
+		assert.Equal(t, now.Add(-time.Hour), since)
+
+		_, required = cp.ChangeRequiredSince(3*time.Hour, now)
+		assert.False(t, required)
+
+		_, required = cp.ChangeRequiredSince(0, now)
+		assert.False(t, required)
+	})
+
+	t.Run("case=the earlier of expiry and required change wins", func(t *testing.T) {
+		cp := CredentialsPassword{HashedPassword: "foo", PasswordChangedAt: at(-2 * time.Hour), ChangeRequiredAt: at(-30 * time.Minute)}
+
+		since, required := cp.ChangeRequiredSince(time.Hour, now)
+		assert.True(t, required)
+		assert.Equal(t, now.Add(-time.Hour), since)
+
+		since, required = cp.ChangeRequiredSince(0, now)
+		assert.True(t, required)
+		assert.Equal(t, now.Add(-30*time.Minute), since)
+	})
+
+	t.Run("case=rotating the password resets its age", func(t *testing.T) {
+		cp := CredentialsPassword{HashedPassword: "foo", PasswordChangedAt: at(-2 * time.Hour), ChangeRequiredAt: at(-time.Minute), ExpiryReminderSentAt: at(-time.Hour)}
+
+		cp.Rotate("foo", 0)
+		assert.Equal(t, now.Add(-2*time.Hour), *cp.PasswordChangedAt)
+		assert.NotNil(t, cp.ChangeRequiredAt)
+
+		cp.Rotate("bar", 0)
+		assert.WithinDuration(t, time.Now(), *cp.PasswordChangedAt, time.Minute)
+		assert.Nil(t, cp.ChangeRequiredAt)
+		assert.Nil(t, cp.ExpiryReminderSentAt)
+
+		_, required := cp.ChangeRequiredSince(time.Hour, time.Now())
+		assert.False(t, required)
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/randx"
+	"github.com/ory/x/sqlxx"
+)
+
+// DeletionInitiator describes who requested an identity's deletion.
+//
+// swagger:enum DeletionInitiator
+type DeletionInitiator string
+
+const (
+	DeletionInitiatorAdmin       DeletionInitiator = "admin"
+	DeletionInitiatorSelfService DeletionInitiator = "self_service"
+)
+
+// A Scheduled Identity Deletion
+//
+// While an identity's deletion is scheduled, the identity is inactive and can not sign in. Once the
+// deletion's grace period has passed, the identity and all of its data are erased.
+//
+// swagger:model scheduledIdentityDeletion
+type ScheduledDeletion struct {
+	// ID is the scheduled deletion's ID.
+	//
+	// required: true
+	ID uuid.UUID `json:"id" db:"id" faker:"-"`
+
+	// IdentityID is the ID of the identity which will be deleted.
+	//
+	// required: true
+	IdentityID uuid.UUID `json:"identity_id" db:"identity_id" faker:"-"`
+
+	// DeleteAfter is the time after which the identity will be deleted.
+	//
+	// required: true
+	DeleteAfter time.Time `json:"delete_after" db:"delete_after" faker:"-"`
+
+	// Initiator describes who requested the deletion.
+	//
+	// required: true
+	Initiator DeletionInitiator `json:"initiator" db:"initiator"`
+
+	// CancelToken allows the identity to cancel the deletion. It is only set when the deletion is scheduled,
+	// because only its hash is stored.
+	CancelToken string `json:"-" db:"-"`
+
+	// CancelTokenHash is the hash of CancelToken.
+	CancelTokenHash string `json:"-" db:"cancel_token_hash"`
+
+	// PreviousState is the identity's state before the deletion was scheduled and is restored on cancellation.
+	PreviousState State `json:"-" db:"previous_state"`
+
+	// FailedAt is the time at which erasing the identity failed the last time.
+	FailedAt *time.Time `json:"failed_at,omitempty" db:"failed_at" faker:"-"`
+
+	// Error is the reason erasing the identity failed the last time.
+	Error sqlxx.NullString `json:"error,omitempty" db:"error" faker:"-"`
+
+	// CreatedAt is a helper struct field for gobuffalo.pop.
+	CreatedAt time.Time `json:"created_at" db:"created_at" faker:"-"`
+
+	// UpdatedAt is a helper struct field for gobuffalo.pop.
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" faker:"-"`
+
+	NID uuid.UUID `json:"-" faker:"-" db:"nid"`
+}
+
+func (d ScheduledDeletion) TableName(context.Context) string {
+	return "identity_scheduled_deletions"
+}
+
+func (d *ScheduledDeletion) GetID() uuid.UUID {
+	return d.ID
+}
+
+func (d *ScheduledDeletion) GetNID() uuid.UUID {
+	return d.NID
+}
+
+// IsDue returns true if the deletion's grace period has passed.
+func (d *ScheduledDeletion) IsDue() bool {
+	return !d.DeleteAfter.After(time.Now())
+}
+
+// DeletionCancelTokenHash returns the value which is stored instead of the cancellation token.
+func DeletionCancelTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewScheduledDeletion(i *Identity, initiator DeletionInitiator, gracePeriod time.Duration) *ScheduledDeletion {
+	token := randx.MustString(32, randx.AlphaNum)
+	return &ScheduledDeletion{
+		IdentityID:      i.ID,
+		DeleteAfter:     time.Now().UTC().Add(gracePeriod),
+		Initiator:       initiator,
+		CancelToken:     token,
+		CancelTokenHash: DeletionCancelTokenHash(token),
+		PreviousState:   i.State,
+	}
+}
+
+type (
+	// DeletionScheduler schedules the deletion of identities.
+	DeletionScheduler interface {
+		// ScheduleIdentityDeletion deactivates the identity, revokes its sessions, and schedules its deletion
+		// once the configured grace period has passed. If the identity's deletion is already scheduled,
+		// the existing schedule is returned.
+		ScheduleIdentityDeletion(ctx context.Context, id uuid.UUID, initiator DeletionInitiator) (*ScheduledDeletion, error)
+
+		// ActivateIdentity calls update, which sets the identity's state to active, and discards the identity's
+		// scheduled deletion, if any, in a single transaction.
+		ActivateIdentity(ctx context.Context, id uuid.UUID, update func(ctx context.Context) error) error
+	}
+	DeletionSchedulerProvider interface {
+		IdentityDeletionScheduler() DeletionScheduler
+	}
+)
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gofrs/uuid"
+
 	"github.com/ory/x/crdbx"
 	"github.com/ory/x/pagination/keysetpagination"
 
@@ -41,6 +43,11 @@ const (
 	RouteCollection     = "/identities"
 	RouteItem           = RouteCollection + "/:id"
 	RouteCredentialItem = RouteItem + "/credentials/:type"
+	RouteMerge          = RouteItem + "/merge"
+
+	RouteRequirePasswordChange        = RouteItem + "/require-password-change"
+	RouteRequirePasswordChangeByQuery = "/require-password-change"
+	RoutePasswordHashReport           = "/password-hashes/report"
 
 	BatchPatchIdentitiesLimit = 2000
 )
@@ -50,11 +57,13 @@ type (
 		PoolProvider
 		PrivilegedPoolProvider
 		ManagementProvider
+		DeletionSchedulerProvider
 		x.WriterProvider
 		config.Provider
 		x.CSRFProvider
 		cipher.Provider
 		hash.HashProvider
+		PasswordPolicyValidatorProvider
 	}
 	HandlerProvider interface {
 		IdentityHandler() *Handler
@@ -82,6 +91,9 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 		RouteCollection+"/*/credentials/*",
 		x.AdminPrefix+RouteCollection, x.AdminPrefix+RouteCollection+"/*",
 		x.AdminPrefix+RouteCollection+"/*/credentials/*",
+		RouteCollection+"/*/merge", x.AdminPrefix+RouteCollection+"/*/merge",
+		RouteCollection+"/*/require-password-change", x.AdminPrefix+RouteCollection+"/*/require-password-change",
+		RouteRequirePasswordChangeByQuery, x.AdminPrefix+RouteRequirePasswordChangeByQuery,
 	)
 
 	public.GET(RouteCollection, x.RedirectToAdminRoute(h.r))
@@ -91,6 +103,10 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 	public.PUT(RouteItem, x.RedirectToAdminRoute(h.r))
 	public.PATCH(RouteItem, x.RedirectToAdminRoute(h.r))
 	public.DELETE(RouteCredentialItem, x.RedirectToAdminRoute(h.r))
+	public.POST(RouteMerge, x.RedirectToAdminRoute(h.r))
+	public.POST(RouteRequirePasswordChange, x.RedirectToAdminRoute(h.r))
+	public.POST(RouteRequirePasswordChangeByQuery, x.RedirectToAdminRoute(h.r))
+	public.GET(RoutePasswordHashReport, x.RedirectToAdminRoute(h.r))
 
 	public.GET(x.AdminPrefix+RouteCollection, x.RedirectToAdminRoute(h.r))
 	public.GET(x.AdminPrefix+RouteItem, x.RedirectToAdminRoute(h.r))
@@ -99,6 +115,10 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 	public.PUT(x.AdminPrefix+RouteItem, x.RedirectToAdminRoute(h.r))
 	public.PATCH(x.AdminPrefix+RouteItem, x.RedirectToAdminRoute(h.r))
 	public.DELETE(x.AdminPrefix+RouteCredentialItem, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteMerge, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteRequirePasswordChange, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteRequirePasswordChangeByQuery, x.RedirectToAdminRoute(h.r))
+	public.GET(x.AdminPrefix+RoutePasswordHashReport, x.RedirectToAdminRoute(h.r))
 }
 
 func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
@@ -112,6 +132,13 @@ func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
 	admin.PUT(RouteItem, h.update)
 
 	admin.DELETE(RouteCredentialItem, h.deleteIdentityCredentials)
+
+	admin.POST(RouteMerge, h.merge)
+
+	admin.POST(RouteRequirePasswordChange, h.requirePasswordChange)
+	admin.POST(RouteRequirePasswordChangeByQuery, h.requirePasswordChangeByQuery)
+
+	admin.GET(RoutePasswordHashReport, h.passwordHashReport)
 }
 
 // Paginated Identity List Response
@@ -392,10 +419,23 @@ type AdminIdentityImportCredentialsPassword struct {
 // swagger:model identityWithCredentialsPasswordConfig
 type AdminIdentityImportCredentialsPasswordConfig struct {
 	// The hashed password in [PHC format](https://www.ory.sh/docs/kratos/manage-identities/import-user-accounts-identities#hashed-passwords)
+	//
+	// Hashes of legacy systems are accepted as is: PHPass (`$P$`, `$H$`), Drupal 7 (`$S$`), Django PBKDF2
+	// (`pbkdf2_sha256$`), ASP.NET Identity v2 and v3 (base64), and Keycloak password credentials (the JSON object
+	// containing `secretData` and `credentialData`). They are replaced with a hash of the configured hasher after
+	// the first successful login.
 	HashedPassword string `json:"hashed_password"`
 
 	// The password in plain text if no hash is available.
 	Password string `json:"password"`
+
+	// The hashes of the previously used passwords in PHC format, most recent first. If set, they replace the
+	// password history of the identity. Otherwise the replaced password is added to the history.
+	PreviousHashedPasswords []string `json:"previous_hashed_passwords"`
+
+	// The time the password was last changed. Use this to keep the age of imported passwords if password expiry
+	// is enabled. Defaults to the time of the import.
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
 }
 
 // Create Identity and Import Social Sign In Credentials
@@ -696,11 +736,13 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request, ps httprouter.P
 		identity.SchemaID = ur.SchemaID
 	}
 
+	var activated bool
 	if ur.State != "" && identity.State != ur.State {
 		if err := ur.State.IsValid(); err != nil {
 			h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReasonf("%s", err).WithWrap(err)))
 			return
 		}
+		activated = ur.State == StateActive
 
 		stateChangedAt := sqlxx.NullTime(time.Now())
 
@@ -720,11 +762,7 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request, ps httprouter.P
 		}
 	}
 
-	if err := h.r.IdentityManager().Update(
-		r.Context(),
-		identity,
-		ManagerAllowWriteProtectedTraits,
-	); err != nil {
+	if err := h.updateIdentity(r.Context(), identity, activated); err != nil {
 		h.r.Writer().WriteError(w, r, err)
 		return
 	}
@@ -732,6 +770,18 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	h.r.Writer().Write(w, r, WithCredentialsMetadataAndAdminMetadataInJSON(*identity))
 }
 
+// updateIdentity updates the identity. If an administrator activated the identity, its scheduled deletion is
+// discarded in the same transaction.
+func (h *Handler) updateIdentity(ctx context.Context, i *Identity, activated bool) error {
+	update := func(ctx context.Context) error {
+		return h.r.IdentityManager().Update(ctx, i, ManagerAllowWriteProtectedTraits)
+	}
+	if !activated {
+		return update(ctx)
+	}
+	return h.r.IdentityDeletionScheduler().ActivateIdentity(ctx, i.ID, update)
+}
+
 // Delete Identity Parameters
 //
 // swagger:parameters deleteIdentity
@@ -754,6 +804,10 @@ type deleteIdentity struct {
 // This endpoint returns 204 when the identity was deleted or when the identity was not found, in which case it is
 // assumed that is has been deleted already.
 //
+// If a deletion grace period is configured (`identity.deletion.grace_period`), the identity is not deleted right away.
+// Instead, it is deactivated, its sessions are revoked, and its deletion is scheduled. In that case, this endpoint
+// returns 202 with the scheduled deletion. The deletion can be cancelled until the grace period has passed.
+//
 //	Produces:
 //	- application/json
 //
@@ -763,10 +817,22 @@ type deleteIdentity struct {
 //	  oryAccessToken:
 //
 //	Responses:
+//	  202: scheduledIdentityDeletion
 //	  204: emptyResponse
 //	  404: errorGeneric
 //	  default: errorGeneric
 func (h *Handler) delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h.r.Config().IdentityDeletionGracePeriod(r.Context()) > 0 {
+		d, err := h.r.IdentityDeletionScheduler().ScheduleIdentityDeletion(r.Context(), x.ParseUUID(ps.ByName("id")), DeletionInitiatorAdmin)
+		if err != nil {
+			h.r.Writer().WriteError(w, r, err)
+			return
+		}
+
+		h.r.Writer().WriteCode(w, r, http.StatusAccepted, d)
+		return
+	}
+
 	if err := h.r.PrivilegedIdentityPool().DeleteIdentity(r.Context(), x.ParseUUID(ps.ByName("id"))); err != nil {
 		h.r.Writer().WriteError(w, r, err)
 		return
@@ -870,11 +936,7 @@ func (h *Handler) patch(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 
 	updatedIdenty := Identity(patchedIdentity)
 
-	if err := h.r.IdentityManager().Update(
-		r.Context(),
-		&updatedIdenty,
-		ManagerAllowWriteProtectedTraits,
-	); err != nil {
+	if err := h.updateIdentity(r.Context(), &updatedIdenty, oldState != updatedIdenty.State && updatedIdenty.State == StateActive); err != nil {
 		h.r.Writer().WriteError(w, r, err)
 		return
 	}
@@ -1002,3 +1064,275 @@ func (h *Handler) deleteIdentityCredentials(w http.ResponseWriter, r *http.Reque
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Merge Identities Parameters
+//
+// swagger:parameters mergeIdentities
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type mergeIdentities struct {
+	// ID is the ID of the identity which the source identity is merged into.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+
+	// in: body
+	// required: true
+	Body mergeIdentitiesBody
+}
+
+// Merge Identities Request Body
+//
+// swagger:model mergeIdentitiesBody
+type mergeIdentitiesBody struct {
+	// SourceIdentityID is the ID of the identity which is merged into the target identity and then deleted.
+	//
+	// required: true
+	SourceIdentityID uuid.UUID `json:"source_identity_id"`
+
+	// DryRun previews the merged identity without changing anything.
+	DryRun bool `json:"dry_run"`
+}
+
+// swagger:route POST /admin/identities/{id}/merge identity mergeIdentities
+//
+// # Merge two identities
+//
+// Moves the credentials, verifiable and recovery addresses, and sessions of the source identity into this
+// [identity](https://www.ory.sh/docs/kratos/concepts/identity-user-model) and deletes the source identity.
+// Conflicting traits are resolved by the Jsonnet snippet configured in `identity.merge.mapper_url`; by default
+// the traits of this identity win. Identifiers and addresses of the source identity which the merged traits no
+// longer contain are listed in the response. Set `dry_run` to preview the result without changing anything.
+//
+//	Consumes:
+//	- application/json
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: identityMergeResult
+//	  400: errorGeneric
+//	  404: errorGeneric
+//	  409: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) merge(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body mergeIdentitiesBody
+	if err := jsonx.NewStrictDecoder(r.Body).Decode(&body); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithError(err.Error())))
+		return
+	}
+
+	if body.SourceIdentityID == uuid.Nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReason("The source identity ID must be set.")))
+		return
+	}
+
+	result, err := h.r.IdentityManager().Merge(r.Context(), x.ParseUUID(ps.ByName("id")), body.SourceIdentityID, body.DryRun)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, result)
+}
+
+// Require Password Change Parameters
+//
+// swagger:parameters requireIdentityPasswordChange
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type requireIdentityPasswordChange struct {
+	// ID is the ID of the identity which must change its password.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route POST /admin/identities/{id}/require-password-change identity requireIdentityPasswordChange
+//
+// # Require an Identity to Change its Password
+//
+// Requires the identity to change its password after its next login with the password. Until the password is
+// changed, the session can only be used to change the password using the settings flow. Existing sessions are
+// not affected.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  204: emptyResponse
+//	  400: errorGeneric
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) requirePasswordChange(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := h.r.IdentityManager().RequirePasswordChange(r.Context(), x.ParseUUID(ps.ByName("id"))); err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Require Password Change by Query Parameters
+//
+// swagger:parameters requirePasswordChangeByQuery
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type requirePasswordChangeByQuery struct {
+	// in: body
+	// required: true
+	Body requirePasswordChangeByQueryBody
+}
+
+// Require Password Change by Query Request Body
+//
+// swagger:model requirePasswordChangeByQueryBody
+type requirePasswordChangeByQueryBody struct {
+	// IDs limits the affected identities to the identities with these IDs.
+	IDs []string `json:"ids"`
+
+	// CredentialsIdentifier limits the affected identities to the identity with this credentials identifier,
+	// for example an email address.
+	CredentialsIdentifier string `json:"credentials_identifier"`
+
+	// All must be set to require a password change from all identities if no other filter is set.
+	All bool `json:"all"`
+}
+
+// Require Password Change by Query Response
+//
+// swagger:model requirePasswordChangeByQueryResponse
+type requirePasswordChangeByQueryResponse struct {
+	// Count is the number of identities which must change their password.
+	//
+	// required: true
+	Count int `json:"count"`
+}
+
+// swagger:route POST /admin/require-password-change identity requirePasswordChangeByQuery
+//
+// # Require Identities to Change their Password
+//
+// Requires all identities which match the query and have a password to change their password after their next
+// login with it. The query uses the same filters as listing identities. To affect all identities, set `all`
+// instead of a filter.
+//
+//	Consumes:
+//	- application/json
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: requirePasswordChangeByQueryResponse
+//	  400: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) requirePasswordChangeByQuery(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body requirePasswordChangeByQueryBody
+	if err := jsonx.NewStrictDecoder(r.Body).Decode(&body); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithError(err.Error())))
+		return
+	}
+
+	hasFilter := len(body.IDs) > 0 || body.CredentialsIdentifier != ""
+	if hasFilter == body.All {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReason("Either a filter or `all` must be set, but not both.")))
+		return
+	}
+
+	count, err := h.r.IdentityManager().RequirePasswordChangeForIdentities(r.Context(), ListIdentityParameters{
+		IdsFilter:             body.IDs,
+		CredentialsIdentifier: body.CredentialsIdentifier,
+	})
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, &requirePasswordChangeByQueryResponse{Count: count})
+}
+
+// Get Password Hash Report Parameters
+//
+// swagger:parameters getPasswordHashReport
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type getPasswordHashReportParameters struct {
+	keysetpagination.RequestParameters
+}
+
+// Get Password Hash Report Response
+//
+// swagger:response getPasswordHashReport
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type getPasswordHashReportResponse struct {
+	keysetpagination.ResponseHeaders
+
+	// in: body
+	Body PasswordHashReport
+}
+
+// swagger:route GET /admin/password-hashes/report identity getPasswordHashReport
+//
+// # Get Password Hash Report
+//
+// Reports how many identities have a password hash which does not meet the configured hasher parameters, for
+// example after the Argon2 memory or the bcrypt cost was increased. Such hashes are replaced transparently when
+// the identity signs in with its password the next time.
+//
+// The report covers one page of identities. Follow the `next` link of the `Link` header and add up the reports of
+// all pages to get the report of all identities.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: getPasswordHashReport
+//	  400: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) passwordHashReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	pagination, err := keysetpagination.Parse(r.URL.Query(), keysetpagination.NewStringPageToken)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReason(err.Error())))
+		return
+	}
+
+	report, next, err := h.r.IdentityManager().ReportPasswordHashesPage(r.Context(), pagination)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	u := *r.URL
+	keysetpagination.Header(w, &u, next)
+	h.r.Writer().Write(w, r, report)
+}
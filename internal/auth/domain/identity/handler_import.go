@@ -6,14 +6,28 @@ package identity
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/ory/herodot"
+	"github.com/ory/x/errorsx"
 	"my.com/secrets/internal/auth/domain/hash"
+	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/text"
 	"my.com/secrets/internal/auth/domain/x"
 )
 
+type (
+	// PasswordPolicyValidator validates clear text passwords against the password policy.
+	PasswordPolicyValidator interface {
+		ValidateIdentity(ctx context.Context, i *Identity, password string) error
+	}
+	PasswordPolicyValidatorProvider interface {
+		IdentityPasswordPolicyValidator() PasswordPolicyValidator
+	}
+)
+
 func (h *Handler) importCredentials(ctx context.Context, i *Identity, creds *IdentityWithCredentials) error {
 	if creds == nil {
 		return nil
@@ -35,10 +49,28 @@ func (h *Handler) importCredentials(ctx context.Context, i *Identity, creds *Ide
 }
 
 func (h *Handler) importPasswordCredentials(ctx context.Context, i *Identity, creds *AdminIdentityImportCredentialsPassword) (err error) {
-	// In here we deliberately ignore any password policies as the point here is to import passwords, even if they
+	var cp CredentialsPassword
+	if c, ok := i.GetCredentials(CredentialsTypePassword); ok && len(c.Config) > 0 {
+		if err := json.Unmarshal(c.Config, &cp); err != nil {
+			return errors.WithStack(herodot.ErrInternalServerError.WithReason("The password credentials could not be decoded properly").WithDebug(err.Error()).WithWrap(err))
+		}
+	}
+	historySize := int(h.r.Config().PasswordPolicyConfig(ctx).HistorySize)
+
+	// By default we deliberately ignore any password policies as the point here is to import passwords, even if they
 	// are not matching the policy, as the user needs to able to sign in with their old password.
 	hashed := []byte(creds.Config.HashedPassword)
 	if len(creds.Config.Password) > 0 {
+		if h.r.Config().PasswordPolicyConfig(ctx).EnforceOnImport {
+			if err := h.validateImportedPassword(ctx, i, creds.Config.Password); err != nil {
+				return err
+			}
+		}
+
+		if creds.Config.PreviousHashedPasswords == nil && cp.IsReused(ctx, h.r.Hasher(ctx), []byte(creds.Config.Password), historySize) {
+			return passwordPolicyViolationError(text.NewErrorValidationPasswordReused())
+		}
+
 		// Importing a clear text password
 		hashed, err = h.r.Hasher(ctx).Generate(ctx, []byte(creds.Config.Password))
 		if err != nil {
@@ -52,7 +84,44 @@ func (h *Handler) importPasswordCredentials(ctx context.Context, i *Identity, cr
 		return errors.WithStack(herodot.ErrBadRequest.WithReasonf("The imported password does not match any known hash format. For more information see https://www.ory.sh/dr/2"))
 	}
 
-	return i.SetCredentialsWithConfig(CredentialsTypePassword, Credentials{}, CredentialsPassword{HashedPassword: string(hashed)})
+	if creds.Config.PreviousHashedPasswords != nil {
+		for _, previous := range creds.Config.PreviousHashedPasswords {
+			if !hash.IsValidHashFormat([]byte(previous)) {
+				return errors.WithStack(herodot.ErrBadRequest.WithReasonf("The imported previous password does not match any known hash format. For more information see https://www.ory.sh/dr/2"))
+			}
+		}
+		now := time.Now().UTC()
+		cp = CredentialsPassword{HashedPassword: string(hashed), PreviousHashedPasswords: creds.Config.PreviousHashedPasswords, PasswordChangedAt: &now}
+	} else {
+		cp.Rotate(string(hashed), historySize)
+	}
+
+	if creds.Config.PasswordChangedAt != nil {
+		changedAt := creds.Config.PasswordChangedAt.UTC()
+		cp.PasswordChangedAt = &changedAt
+	}
+
+	return i.SetCredentialsWithConfig(CredentialsTypePassword, Credentials{}, cp)
+}
+
+func (h *Handler) validateImportedPassword(ctx context.Context, i *Identity, password string) error {
+	if err := h.r.IdentityPasswordPolicyValidator().ValidateIdentity(ctx, i, password); err != nil {
+		if _, ok := errorsx.Cause(err).(*herodot.DefaultError); ok {
+			return err
+		}
+		if message := new(text.Message); errors.As(err, &message) {
+			return passwordPolicyViolationError(message)
+		}
+		return passwordPolicyViolationError(text.NewErrorValidationPasswordPolicyViolationGeneric(err.Error()))
+	}
+	return nil
+}
+
+// passwordPolicyViolationError returns a bad request error, as validation errors which are not wrapped are
+// rendered as internal server errors.
+func passwordPolicyViolationError(message *text.Message) error {
+	err := schema.NewPasswordPolicyViolationError("#/credentials/password/config/password", message)
+	return errors.WithStack(herodot.ErrBadRequest.WithReasonf("%s", err).WithWrap(err))
 }
 
 func (h *Handler) importOIDCCredentials(_ context.Context, i *Identity, creds *AdminIdentityImportCredentialsOIDC) error {
@@ -34,6 +34,7 @@ import (
 	"my.com/secrets/internal/auth/domain/hash"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/text"
 	"my.com/secrets/internal/auth/domain/x"
 )
 
@@ -189,7 +190,7 @@ func TestHandler(t *testing.T) {
 	})
 
 	t.Run("case=should be able to import users", func(t *testing.T) {
-		ignoreDefault := []string{"id", "schema_url", "state_changed_at", "created_at", "updated_at"}
+		ignoreDefault := []string{"id", "schema_url", "state_changed_at", "created_at", "updated_at", "password_changed_at"}
 		t.Run("without any credentials", func(t *testing.T) {
 			res := send(t, adminTS, "POST", "/identities", http.StatusCreated, identity.CreateIdentityBody{Traits: []byte(`{"email": "import-1@ory.sh"}`)})
 			actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, uuid.FromStringOrNil(res.Get("id").String()))
@@ -284,6 +285,26 @@ func TestHandler(t *testing.T) {
 					name: "hmac",
 					hash: "$hmac-sha256$YjhhZDA4YTNhNTQ3ZTM1ODI5YjgyMWI3NTM3MDMwMWRkOGM0YjA2YmRkNzc3MWY5YjU0MWE3NTkxNDA2ODcxOA==$MTIzNDU2",
 					pass: "123456",
+				}, {
+					name: "phpass",
+					hash: "$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0",
+					pass: "test12345",
+				}, {
+					name: "drupal7",
+					hash: "$S$DabcdefghnxdsNozT5Jag.BMrVN6FKV.w6ylEAdoeAHytB4LyTL9",
+					pass: "test",
+				}, {
+					name: "django",
+					hash: "pbkdf2_sha256$10000$somesalt$aXsQiCnvqPoVwsAEcxOOX/ZpXP0w3jCEg3OYfjDjIK4=",
+					pass: "test",
+				}, {
+					name: "keycloak",
+					hash: `{"secretData": "{\"value\": \"U9tyXqETdZHkyi9zi6jcDHsHd54rod/5eK1EVcgvPXhuf4dQHn6CAOypy9grYmuTZcMpk5vfptlvEVUDRj4PVQ==\", \"salt\": \"MDEyMzQ1Njc4OWFiY2RlZg==\"}", "credentialData": "{\"hashIterations\": 27500, \"algorithm\": \"pbkdf2-sha256\"}"}`,
+					pass: "test",
+				}, {
+					name: "aspnet-identity",
+					hash: "AQAAAAEAACcQAAAAEDAxMjM0NTY3ODlhYmNkZWaA7Atf1ISgKGxp+xDpjI71xe6yw/ZAd2RGlFcQqB/ZIg==",
+					pass: "test",
 				},
 			} {
 				t.Run("hash="+tt.name, func(t *testing.T) {
@@ -685,6 +706,75 @@ func TestHandler(t *testing.T) {
 			}
 		})
 
+		t.Run("case=should keep the password history when updating an identity with credentials", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeyPasswordHistorySize, 2)
+			t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordHistorySize, 0) })
+
+			i := &identity.Identity{Traits: identity.Traits(fmt.Sprintf(`{"subject":"%s"}`, x.NewUUID().String()))}
+			require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(context.Background(), i))
+
+			update := func(t *testing.T, expectStatusCode int, config identity.AdminIdentityImportCredentialsPasswordConfig) gjson.Result {
+				return send(t, adminTS, "PUT", "/identities/"+i.ID.String(), expectStatusCode, &identity.UpdateIdentityBody{
+					Traits:      []byte(`{"bar":"baz"}`),
+					SchemaID:    i.SchemaID,
+					State:       identity.StateActive,
+					Credentials: &identity.IdentityWithCredentials{Password: &identity.AdminIdentityImportCredentialsPassword{Config: config}},
+				})
+			}
+			history := func(t *testing.T) []string {
+				actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(context.Background(), i.ID)
+				require.NoError(t, err)
+				var cp identity.CredentialsPassword
+				require.NoError(t, json.Unmarshal(actual.Credentials[identity.CredentialsTypePassword].Config, &cp))
+				return cp.PreviousHashedPasswords
+			}
+
+			update(t, http.StatusOK, identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd1234"})
+			res := update(t, http.StatusBadRequest, identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd1234"})
+			assert.Contains(t, res.Raw, text.NewErrorValidationPasswordReused().Text)
+
+			update(t, http.StatusOK, identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd5678"})
+			previous := history(t)
+			require.Len(t, previous, 1)
+			require.NoError(t, hash.Compare(ctx, []byte("pswd1234"), []byte(previous[0])))
+			update(t, http.StatusBadRequest, identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd1234"})
+
+			// Importing the history replaces it.
+			update(t, http.StatusOK, identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd1234", PreviousHashedPasswords: []string{}})
+			assert.Empty(t, history(t))
+		})
+
+		t.Run("case=should require a password change", func(t *testing.T) {
+			changeRequired := func(t *testing.T, id uuid.UUID) bool {
+				actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(context.Background(), id)
+				require.NoError(t, err)
+				var cp identity.CredentialsPassword
+				require.NoError(t, json.Unmarshal(actual.Credentials[identity.CredentialsTypePassword].Config, &cp))
+				return cp.ChangeRequiredAt != nil
+			}
+
+			var ids []uuid.UUID
+			for range 2 {
+				res := send(t, adminTS, "POST", "/identities", http.StatusCreated, identity.CreateIdentityBody{
+					Traits:      []byte(`{"bar":"baz"}`),
+					Credentials: &identity.IdentityWithCredentials{Password: &identity.AdminIdentityImportCredentialsPassword{Config: identity.AdminIdentityImportCredentialsPasswordConfig{Password: "pswd1234"}}},
+				})
+				ids = append(ids, uuid.FromStringOrNil(res.Get("id").String()))
+			}
+
+			send(t, adminTS, "POST", "/identities/"+ids[0].String()+"/require-password-change", http.StatusNoContent, json.RawMessage(`{}`))
+			assert.True(t, changeRequired(t, ids[0]))
+			assert.False(t, changeRequired(t, ids[1]))
+
+			withoutPassword := send(t, adminTS, "POST", "/identities", http.StatusCreated, json.RawMessage(`{"traits": {"bar":"baz"}}`))
+			send(t, adminTS, "POST", "/identities/"+withoutPassword.Get("id").String()+"/require-password-change", http.StatusBadRequest, json.RawMessage(`{}`))
+
+			send(t, adminTS, "POST", "/require-password-change", http.StatusBadRequest, json.RawMessage(`{}`))
+			res := send(t, adminTS, "POST", "/require-password-change", http.StatusOK, json.RawMessage(`{"ids":["`+ids[1].String()+`","`+withoutPassword.Get("id").String()+`"]}`))
+			assert.EqualValues(t, 1, res.Get("count").Int(), "%s", res.Raw)
+			assert.True(t, changeRequired(t, ids[1]))
+		})
+
 		t.Run("case=should delete a user and no longer be able to retrieve it", func(t *testing.T) {
 			for name, ts := range map[string]*httptest.Server{"public": publicTS, "admin": adminTS} {
 				t.Run("endpoint="+name, func(t *testing.T) {
@@ -1349,7 +1439,7 @@ func TestHandler(t *testing.T) {
 	})
 
 	t.Run("case=should delete credential of a specific user and no longer be able to retrieve it", func(t *testing.T) {
-		ignoreDefault := []string{"id", "schema_url", "state_changed_at", "created_at", "updated_at"}
+		ignoreDefault := []string{"id", "schema_url", "state_changed_at", "created_at", "updated_at", "password_changed_at"}
 		createIdentity := func(identities map[identity.CredentialsType]string) func(t *testing.T) *identity.Identity {
 			return func(t *testing.T) *identity.Identity {
 				i := identity.NewIdentity("")
@@ -80,6 +80,11 @@ type Identity struct {
 	// required: true
 	SchemaID string `json:"schema_id" faker:"-" db:"schema_id"`
 
+	// SchemaVersion is the version of the identity schema the traits were last validated against.
+	//
+	// It is empty if the schema has no version or if the traits were not validated since versioning was introduced.
+	SchemaVersion string `json:"schema_version,omitempty" faker:"-" db:"schema_version"`
+
 	// SchemaURL is the URL of the endpoint where the identity's traits schema can be fetched from.
 	//
 	// format: url
@@ -9,6 +9,9 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/sqlxx"
+	"my.com/secrets/internal/auth/domain/x"
 )
 
 const (
@@ -33,6 +36,10 @@ type (
 		// required: true
 		Via RecoveryAddressType `json:"via" db:"via"`
 
+		// ValueBlindIndex is the blind index of addresses which are an encrypted trait. The value column of
+		// such rows does not contain the address.
+		ValueBlindIndex sqlxx.NullString `json:"-" faker:"-" db:"value_blind_index"`
+
 		// IdentityID is a helper struct field for gobuffalo.pop.
 		IdentityID uuid.UUID `json:"-" faker:"-" db:"identity_id"`
 		// CreatedAt is a helper struct field for gobuffalo.pop.
@@ -65,7 +72,7 @@ func (a RecoveryAddress) GetID() uuid.UUID {
 
 // Hash returns a unique string representation for the recovery address.
 func (a RecoveryAddress) Hash() string {
-	return fmt.Sprintf("%v|%v|%v|%v", a.Value, a.Via, a.IdentityID, a.NID)
+	return fmt.Sprintf("%v|%v|%v|%v", x.Coalesce(string(a.ValueBlindIndex), a.Value), a.Via, a.IdentityID, a.NID)
 }
 
 func NewRecoveryEmailAddress(
@@ -11,6 +11,7 @@ import (
 	"github.com/gofrs/uuid"
 
 	"github.com/ory/x/sqlxx"
+	"my.com/secrets/internal/auth/domain/x"
 )
 
 const (
@@ -81,6 +82,10 @@ type VerifiableAddress struct {
 	// example: 2014-01-01T23:28:56.782Z
 	UpdatedAt time.Time `json:"updated_at" faker:"-" db:"updated_at"`
 
+	// ValueBlindIndex is the blind index of addresses which are an encrypted trait. The value column of such
+	// rows does not contain the address.
+	ValueBlindIndex sqlxx.NullString `json:"-" faker:"-" db:"value_blind_index"`
+
 	// IdentityID is a helper struct field for gobuffalo.pop.
 	IdentityID uuid.UUID `json:"-" faker:"-" db:"identity_id"`
 	NID        uuid.UUID `json:"-"  faker:"-" db:"nid"`
@@ -118,5 +123,5 @@ func (a VerifiableAddress) ValidateNID() error {
 
 // Hash returns a unique string representation for the recovery address.
 func (a VerifiableAddress) Hash() string {
-	return fmt.Sprintf("%v|%v|%v|%v|%v|%v", a.Value, a.Verified, a.Via, a.Status, a.IdentityID, a.NID)
+	return fmt.Sprintf("%v|%v|%v|%v|%v|%v", x.Coalesce(string(a.ValueBlindIndex), a.Value), a.Verified, a.Via, a.Status, a.IdentityID, a.NID)
 }
@@ -38,6 +38,8 @@ var tagsIgnoredForHashing = map[string]struct{}{
 	"created_at":  {},
 	"updated_at":  {},
 	"verified_at": {},
+	// The blind index replaces the value in the hash of sealed addresses, see TestSealedAddress_Hash.
+	"value_blind_index": {},
 }
 
 func reflectiveHash(record any) string {
@@ -104,3 +106,20 @@ func TestVerifiableAddress_Hash(t *testing.T) {
 	}
 
 }
+
+// TestSealedAddress_Hash tests that sealed addresses are compared by their blind index, because the value of
+// sealed addresses is a placeholder containing the ID of the row.
+func TestSealedAddress_Hash(t *testing.T) {
+	a := VerifiableAddress{Value: "sealed:" + x.NewUUID().String(), ValueBlindIndex: "index", Via: AddressTypeEmail}
+	b := a
+	b.Value = "sealed:" + x.NewUUID().String()
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	b.ValueBlindIndex = "other-index"
+	assert.NotEqual(t, a.Hash(), b.Hash())
+
+	r := RecoveryAddress{Value: "sealed:" + x.NewUUID().String(), ValueBlindIndex: "index", Via: AddressTypeEmail}
+	s := r
+	s.Value = "sealed:" + x.NewUUID().String()
+	assert.Equal(t, r.Hash(), s.Hash())
+}
@@ -29,8 +29,12 @@ import (
 	"github.com/ory/herodot"
 	"github.com/ory/jsonschema/v3"
 	"github.com/ory/x/errorsx"
+	"github.com/ory/x/jsonnetsecure"
 
+	"my.com/secrets/internal/auth/domain/cipher"
 	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/courier/template"
+	"my.com/secrets/internal/auth/domain/hash"
 )
 
 var ErrProtectedFieldModified = herodot.ErrForbidden.
@@ -43,15 +47,22 @@ type (
 		PrivilegedPoolProvider
 		x.TracingProvider
 		courier.Provider
+		template.Dependencies
+		hash.HashProvider
+		cipher.Provider
 		ValidationProvider
 		ActiveCredentialsCounterStrategyProvider
+		MergePersistenceProvider
 		x.LoggingProvider
+		x.HTTPClientProvider
+		jsonnetsecure.VMProvider
 	}
 	ManagementProvider interface {
 		IdentityManager() *Manager
 	}
 	Manager struct {
-		r managerDependencies
+		r           managerDependencies
+		hashMetrics *passwordHashMetrics
 	}
 
 	ManagerOptions struct {
@@ -63,7 +74,7 @@ type (
 )
 
 func NewManager(r managerDependencies) *Manager {
-	return &Manager{r: r}
+	return &Manager{r: r, hashMetrics: newPasswordHashMetrics()}
 }
 
 func ManagerExposeValidationErrorsForInternalTypeAssertion(options *ManagerOptions) {
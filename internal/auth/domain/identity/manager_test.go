@@ -21,6 +21,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/external"
@@ -619,6 +620,79 @@ func TestManager(t *testing.T) {
 			assert.Equal(t, "conflict-on-ra@example.com", foundConflictAddress)
 		})
 	})
+	t.Run("method=RotateCipherSecrets", func(t *testing.T) {
+		oldSecret := "old-secret-thirty-two-characters"
+		newSecret := "new-secret-thirty-two-characters"
+		conf.MustSet(ctx, config.ViperKeyCipherAlgorithm, "xchacha20-poly1305")
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{oldSecret})
+
+		token, err := reg.Cipher(ctx).Encrypt(ctx, []byte("initial-access-token"))
+		require.NoError(t, err)
+		creds, err := identity.NewCredentialsOIDC("", token, "", "google", x.NewUUID().String(), "")
+		require.NoError(t, err)
+
+		original := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		original.Traits = newTraits("rotate-cipher-secrets@ory.sh", "")
+		original.SetCredentials(identity.CredentialsTypeOIDC, *creds)
+		require.NoError(t, reg.IdentityManager().Create(ctx, original))
+
+		conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"old-blind-index-secret"})
+		encrypted := identity.NewIdentity(testhelpers.UseIdentitySchema(t, conf, "file://./stub/encrypted.schema.json"))
+		encrypted.Traits = identity.Traits(`{"email":"rotate-encrypted-traits@ory.sh"}`)
+		encrypted.SetCredentials(identity.CredentialsTypePassword, identity.Credentials{
+			Type:        identity.CredentialsTypePassword,
+			Identifiers: []string{"rotate-encrypted-traits@ory.sh"},
+			Config:      sqlxx.JSONRawMessage(`{"hashed_password":"$2a$08$.cOYmAd.vCpDOoiVJrO5B.hjTLKQQ6cAK40u8uB.FnZDyPvVvQ9Q."}`),
+		})
+		require.NoError(t, reg.IdentityManager().Create(ctx, encrypted))
+
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{newSecret, oldSecret})
+		conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"new-blind-index-secret", "old-blind-index-secret"})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, nil) })
+
+		// One OpenID Connect token, the encrypted email, and the blind indexes of the password identifier and of
+		// the verifiable and recovery address.
+		report, err := reg.IdentityManager().RotateCipherSecrets(ctx, identity.CipherRotationOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 5, report.Remaining)
+		assert.Zero(t, report.Reencrypted)
+
+		var batches int
+		report, err = reg.IdentityManager().RotateCipherSecrets(ctx, identity.CipherRotationOptions{
+			BatchSize: 1,
+			Progress:  func(*identity.CipherRotationReport) { batches++ },
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5, report.Reencrypted)
+		assert.Zero(t, report.Remaining)
+		assert.Equal(t, report.Identities, batches)
+
+		report, err = reg.IdentityManager().RotateCipherSecrets(ctx, identity.CipherRotationOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Zero(t, report.Remaining)
+
+		report, err = reg.IdentityManager().RotateCipherSecrets(ctx, identity.CipherRotationOptions{After: report.LastIdentityID})
+		require.NoError(t, err)
+		assert.Zero(t, report.Identities, "resuming after the last identity processes nothing")
+
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{newSecret})
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, original.ID)
+		require.NoError(t, err)
+		plaintext, err := reg.Cipher(ctx).Decrypt(ctx, gjson.GetBytes(actual.Credentials[identity.CredentialsTypeOIDC].Config, "providers.0.initial_access_token").String())
+		require.NoError(t, err)
+		assert.Equal(t, "initial-access-token", string(plaintext))
+
+		conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"new-blind-index-secret"})
+		actual, _, err = reg.PrivilegedIdentityPool().FindByCredentialsIdentifier(ctx, identity.CredentialsTypePassword, "rotate-encrypted-traits@ory.sh")
+		require.NoError(t, err)
+		assert.Equal(t, encrypted.ID, actual.ID)
+		assert.JSONEq(t, `{"email":"rotate-encrypted-traits@ory.sh"}`, string(actual.Traits))
+		address, err := reg.PrivilegedIdentityPool().FindVerifiableAddressByValue(ctx, identity.VerifiableAddressTypeEmail, "rotate-encrypted-traits@ory.sh")
+		require.NoError(t, err)
+		assert.Equal(t, encrypted.ID, address.IdentityID)
+		_, err = reg.PrivilegedIdentityPool().FindRecoveryAddressByValue(ctx, identity.RecoveryAddressTypeEmail, "rotate-encrypted-traits@ory.sh")
+		require.NoError(t, err)
+	})
 }
 
 func TestManagerNoDefaultNamedSchema(t *testing.T) {
@@ -0,0 +1,389 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/fetcher"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/sqlxx"
+
+	"my.com/secrets/internal/auth/domain/x/events"
+)
+
+// defaultMergeMapper keeps the target's traits and adds the source's traits which the target does not have.
+const defaultMergeMapper = `local target = std.extVar('target');
+local source = std.extVar('source');
+{
+  traits: std.mergePatch(source.traits, target.traits),
+}`
+
+type (
+	// MergePersister moves the data of one identity into another.
+	MergePersister interface {
+		// MergeIdentities locks and reads the target and source identities including their credentials, and
+		// passes them to merge, which returns the merged identity. It then moves the source identity's sessions to
+		// the merged identity, deletes the source identity, and stores the merged identity in the same
+		// transaction. If dryRun is true, the transaction is rolled back. It returns the number of sessions which
+		// were moved.
+		MergeIdentities(ctx context.Context, targetID, sourceID uuid.UUID, dryRun bool, merge func(ctx context.Context, target, source *Identity) (*Identity, error)) (int, error)
+	}
+	MergePersistenceProvider interface {
+		IdentityMergePersister() MergePersister
+	}
+)
+
+// Identity Merge Result
+//
+// swagger:model identityMergeResult
+type MergeResult struct {
+	// Identity is the target identity after the merge.
+	//
+	// required: true
+	Identity *WithCredentialsMetadataAndAdminMetadataInJSON `json:"identity"`
+
+	// SourceIdentityID is the ID of the identity which was merged into the target identity and deleted.
+	//
+	// required: true
+	SourceIdentityID uuid.UUID `json:"source_identity_id"`
+
+	// MovedCredentials lists the credential types which were moved from the source identity.
+	//
+	// required: true
+	MovedCredentials []CredentialsType `json:"moved_credentials"`
+
+	// DroppedCredentials lists the credential types which both identities had and for which the target's
+	// configuration, for example its password hash, was kept. The source's secrets of these types were discarded.
+	//
+	// required: true
+	DroppedCredentials []CredentialsType `json:"dropped_credentials"`
+
+	// DroppedIdentifiers lists the source's credential identifiers which the merged identity does not have,
+	// because the identity schema derives them from traits which the merged traits do not contain. Signing in
+	// with them is no longer possible.
+	//
+	// required: true
+	DroppedIdentifiers []string `json:"dropped_identifiers"`
+
+	// DroppedAddresses lists the source's verifiable and recovery addresses which the merged identity does not
+	// have, because the identity schema derives them from traits which the merged traits do not contain.
+	//
+	// required: true
+	DroppedAddresses []string `json:"dropped_addresses"`
+
+	// MovedSessions is the number of sessions which were moved from the source identity.
+	//
+	// required: true
+	MovedSessions int `json:"moved_sessions"`
+
+	// DryRun is true if the merge was only previewed and nothing was changed.
+	//
+	// required: true
+	DryRun bool `json:"dry_run"`
+}
+
+// Merge moves the credentials, verifiable and recovery addresses, and sessions of the source identity into the
+// target identity and deletes the source identity. Trait conflicts are resolved by the configured Jsonnet mapper.
+// Identifiers and addresses which the identity schema derives from traits follow the merged traits; those of the
+// source which are lost this way are reported in the result.
+// If dryRun is true, the result is computed and checked against the database but nothing is changed.
+func (m *Manager) Merge(ctx context.Context, targetID, sourceID uuid.UUID, dryRun bool) (_ *MergeResult, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.Merge")
+	defer otelx.End(span, &err)
+
+	if targetID == sourceID {
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReason("An identity can not be merged into itself."))
+	}
+
+	// The mapper is fetched before the identities are locked.
+	snippet, err := m.mergeMapper(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{SourceIdentityID: sourceID, DryRun: dryRun}
+	var merged *Identity
+	sessions, err := m.r.IdentityMergePersister().MergeIdentities(ctx, targetID, sourceID, dryRun, func(ctx context.Context, target, source *Identity) (*Identity, error) {
+		merged = deepcopy.Copy(target).(*Identity)
+		if err := m.mergeTraits(ctx, snippet, merged, target, source); err != nil {
+			return nil, err
+		}
+
+		var err error
+		result.MovedCredentials, result.DroppedCredentials, err = mergeCredentials(merged, source)
+		if err != nil {
+			return nil, err
+		}
+		mergeAddresses(merged, source)
+
+		if err := m.ValidateIdentity(ctx, merged, new(ManagerOptions)); err != nil {
+			return nil, err
+		}
+
+		if err := merged.SetAvailableAAL(ctx, m); err != nil {
+			return nil, err
+		}
+
+		result.DroppedIdentifiers, result.DroppedAddresses = droppedIdentifiersAndAddresses(merged, source)
+		return merged, nil
+	})
+	if errors.Is(err, sqlcon.ErrUniqueViolation) {
+		return nil, errors.WithStack(herodot.ErrConflict.WithReason("The merged identity conflicts with another identity that already exists.").WithWrap(err))
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		trace.SpanFromContext(ctx).AddEvent(events.NewIdentityUpdated(ctx, merged.ID))
+		m.r.Audit().
+			WithField("identity_id", merged.ID).
+			WithField("source_identity_id", sourceID).
+			WithField("moved_credentials", result.MovedCredentials).
+			WithField("dropped_credentials", result.DroppedCredentials).
+			WithField("dropped_identifiers", len(result.DroppedIdentifiers)).
+			WithField("dropped_addresses", len(result.DroppedAddresses)).
+			WithField("moved_sessions", sessions).
+			Info("An identity was merged into another identity.")
+	}
+
+	withMetadata := WithCredentialsMetadataAndAdminMetadataInJSON(*merged)
+	result.Identity = &withMetadata
+	result.MovedSessions = sessions
+	return result, nil
+}
+
+// mergeMapper returns the configured Jsonnet snippet which merges the traits, or the default one.
+func (m *Manager) mergeMapper(ctx context.Context) ([]byte, error) {
+	mapper := m.r.Config().IdentityMergeMapperURL(ctx)
+	if mapper == "" {
+		return []byte(defaultMergeMapper), nil
+	}
+
+	buf, err := fetcher.NewFetcher(fetcher.WithClient(m.r.HTTPClient(ctx))).FetchContext(ctx, mapper)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Manager) mergeTraits(ctx context.Context, snippet []byte, merged, target, source *Identity) error {
+	vm, err := m.r.JsonnetVM(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name, i := range map[string]*Identity{"target": target, "source": source} {
+		raw, err := json.Marshal(i.CopyWithoutCredentials())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		vm.ExtCode(name, string(raw))
+	}
+
+	evaluated, err := vm.EvaluateAnonymousSnippet("merge.jsonnet", string(snippet))
+	if err != nil {
+		return errors.WithStack(herodot.ErrBadRequest.WithReasonf("Unable to evaluate the identity merge mapper: %s", err))
+	}
+
+	traits := gjson.Get(evaluated, "traits")
+	if !traits.IsObject() {
+		return errors.WithStack(herodot.ErrBadRequest.WithReason("The identity merge mapper must return an object containing the merged traits."))
+	}
+	merged.Traits = Traits(traits.Raw)
+
+	if metadata := gjson.Get(evaluated, "metadata_public"); metadata.Exists() {
+		merged.MetadataPublic = sqlxx.NullJSONRawMessage(metadata.Raw)
+	}
+	if metadata := gjson.Get(evaluated, "metadata_admin"); metadata.Exists() {
+		merged.MetadataAdmin = sqlxx.NullJSONRawMessage(metadata.Raw)
+	}
+
+	return nil
+}
+
+// mergeCredentials adds the source's credentials to the merged identity. Credentials of a type the target does not
+// have are moved as they are. If both identities have credentials of the same type, their identifiers are combined
+// and OpenID Connect providers and WebAuthn keys are merged; for all other types the target's configuration is kept
+// and the type is returned as dropped.
+func mergeCredentials(merged, source *Identity) (moved, dropped []CredentialsType, err error) {
+	moved, dropped = []CredentialsType{}, []CredentialsType{}
+	for _, t := range AllCredentialTypes {
+		sc, ok := source.GetCredentials(t)
+		if !ok {
+			continue
+		}
+
+		tc, ok := merged.GetCredentials(t)
+		if !ok {
+			sc.ID = uuid.Nil
+			sc.IdentityID = merged.ID
+			merged.SetCredentials(t, *sc)
+			moved = append(moved, t)
+			continue
+		}
+
+		tc.Identifiers = mergeIdentifiers(tc.Identifiers, sc.Identifiers)
+		switch t {
+		case CredentialsTypeOIDC:
+			var tConf, sConf CredentialsOIDC
+			if err := json.Unmarshal(tc.Config, &tConf); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			if err := json.Unmarshal(sc.Config, &sConf); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			for _, p := range sConf.Providers {
+				if !containsOIDCProvider(tConf.Providers, p) {
+					tConf.Providers = append(tConf.Providers, p)
+				}
+			}
+			if err := merged.SetCredentialsWithConfig(t, *tc, tConf); err != nil {
+				return nil, nil, err
+			}
+			moved = append(moved, t)
+		case CredentialsTypeWebAuthn:
+			var tConf, sConf CredentialsWebAuthnConfig
+			if err := json.Unmarshal(tc.Config, &tConf); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			if err := json.Unmarshal(sc.Config, &sConf); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			tConf.Credentials = append(tConf.Credentials, sConf.Credentials...)
+			if err := merged.SetCredentialsWithConfig(t, *tc, tConf); err != nil {
+				return nil, nil, err
+			}
+			moved = append(moved, t)
+		default:
+			merged.SetCredentials(t, *tc)
+			dropped = append(dropped, t)
+		}
+	}
+
+	return moved, dropped, nil
+}
+
+func mergeIdentifiers(target, source []string) []string {
+	merged := append([]string{}, target...)
+	for _, s := range source {
+		var found bool
+		for _, t := range merged {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+func containsOIDCProvider(providers []CredentialsOIDCProvider, p CredentialsOIDCProvider) bool {
+	for _, pp := range providers {
+		if pp.Provider == p.Provider && pp.Subject == p.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAddresses adds the source's verifiable and recovery addresses to the merged identity. If both identities
+// have the same verifiable address, it is verified if either of them verified it.
+func mergeAddresses(merged, source *Identity) {
+	for _, sa := range source.VerifiableAddresses {
+		var found bool
+		for k, ta := range merged.VerifiableAddresses {
+			if ta.Via == sa.Via && ta.Value == sa.Value {
+				found = true
+				if !ta.Verified && sa.Verified {
+					merged.VerifiableAddresses[k].Verified = true
+					merged.VerifiableAddresses[k].VerifiedAt = sa.VerifiedAt
+					merged.VerifiableAddresses[k].Status = sa.Status
+				}
+				break
+			}
+		}
+		if !found {
+			sa.ID = uuid.Nil
+			sa.IdentityID = merged.ID
+			merged.VerifiableAddresses = append(merged.VerifiableAddresses, sa)
+		}
+	}
+
+	for _, sa := range source.RecoveryAddresses {
+		var found bool
+		for _, ta := range merged.RecoveryAddresses {
+			if ta.Via == sa.Via && ta.Value == sa.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			sa.ID = uuid.Nil
+			sa.IdentityID = merged.ID
+			merged.RecoveryAddresses = append(merged.RecoveryAddresses, sa)
+		}
+	}
+}
+
+// droppedIdentifiersAndAddresses returns the source's credential identifiers and addresses which the merged
+// identity does not have.
+func droppedIdentifiersAndAddresses(merged, source *Identity) (identifiers, addresses []string) {
+	identifiers, addresses = []string{}, []string{}
+	for t, sc := range source.Credentials {
+		var kept []string
+		if mc, ok := merged.GetCredentials(t); ok {
+			kept = mc.Identifiers
+		}
+		for _, identifier := range sc.Identifiers {
+			if !containsFold(kept, identifier) {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+	}
+
+	var verifiable, recovery []string
+	for _, a := range merged.VerifiableAddresses {
+		verifiable = append(verifiable, a.Value)
+	}
+	for _, a := range merged.RecoveryAddresses {
+		recovery = append(recovery, a.Value)
+	}
+	for _, a := range source.VerifiableAddresses {
+		if !containsFold(verifiable, a.Value) && !containsFold(addresses, a.Value) {
+			addresses = append(addresses, a.Value)
+		}
+	}
+	for _, a := range source.RecoveryAddresses {
+		if !containsFold(recovery, a.Value) && !containsFold(addresses, a.Value) {
+			addresses = append(addresses, a.Value)
+		}
+	}
+
+	sort.Strings(identifiers)
+	return identifiers, addresses
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/x/sqlcon"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestMerge(t *testing.T) {
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/merge.schema.json")
+	publicTS, adminTS := testhelpers.NewKratosServerWithCSRF(t, reg)
+	conf.MustSet(ctx, config.ViperKeyAdminBaseURL, adminTS.URL)
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, publicTS.URL)
+
+	createIdentity := func(t *testing.T, traits string, verified bool, creds ...identity.Credentials) (*identity.Identity, *session.Session) {
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		i.Traits = identity.Traits(traits)
+		for _, c := range creds {
+			i.SetCredentials(c.Type, c)
+		}
+		require.NoError(t, reg.IdentityManager().Create(ctx, i))
+		if verified {
+			i.VerifiableAddresses[0].Verified = true
+			i.VerifiableAddresses[0].Status = identity.VerifiableAddressStatusCompleted
+			require.NoError(t, reg.PrivilegedIdentityPool().UpdateVerifiableAddress(ctx, &i.VerifiableAddresses[0]))
+		}
+
+		s, err := session.NewActiveSession(&http.Request{}, i, conf, time.Now().UTC(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		return i, s
+	}
+
+	password := func(email string) identity.Credentials {
+		return identity.Credentials{
+			Type:        identity.CredentialsTypePassword,
+			Identifiers: []string{email},
+			Config:      []byte(`{"hashed_password":"foo"}`),
+		}
+	}
+
+	oidc := func(subject string) identity.Credentials {
+		return identity.Credentials{
+			Type:        identity.CredentialsTypeOIDC,
+			Identifiers: []string{"google:" + subject},
+			Config:      []byte(`{"providers":[{"provider":"google","subject":"` + subject + `"}]}`),
+		}
+	}
+
+	newPair := func(t *testing.T) (target, source *identity.Identity, sourceSession *session.Session) {
+		targetEmail := x.NewUUID().String() + "@ory.sh"
+		sourceEmail := x.NewUUID().String() + "@ory.sh"
+		target, _ = createIdentity(t, `{"email":"`+targetEmail+`","name":"Target"}`, false, password(targetEmail))
+		source, sourceSession = createIdentity(t, `{"email":"`+sourceEmail+`","name":"Source","nickname":"src"}`, true, oidc(x.NewUUID().String()))
+		return
+	}
+
+	merge := func(t *testing.T, targetID string, body any, expectCode int) gjson.Result {
+		var b bytes.Buffer
+		require.NoError(t, json.NewEncoder(&b).Encode(body))
+		res, err := adminTS.Client().Post(adminTS.URL+"/identities/"+targetID+"/merge", "application/json", &b)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		raw, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, expectCode, res.StatusCode, "%s", raw)
+		return gjson.ParseBytes(raw)
+	}
+
+	t.Run("case=dry run previews the merge without changing anything", func(t *testing.T) {
+		target, source, s := newPair(t)
+
+		res := merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID, "dry_run": true}, http.StatusOK)
+		assert.True(t, res.Get("dry_run").Bool(), "%s", res.Raw)
+		assert.EqualValues(t, 1, res.Get("moved_sessions").Int(), "%s", res.Raw)
+		assert.Contains(t, res.Get("moved_credentials").Raw, `"oidc"`, "%s", res.Raw)
+		assert.Equal(t, target.ID.String(), res.Get("identity.id").String(), "%s", res.Raw)
+		assert.Equal(t, "Target", res.Get("identity.traits.name").String(), "%s", res.Raw)
+		assert.Equal(t, "src", res.Get("identity.traits.nickname").String(), "%s", res.Raw)
+
+		_, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, source.ID, identity.ExpandNothing)
+		require.NoError(t, err)
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, target.ID)
+		require.NoError(t, err)
+		assert.NotContains(t, actual.Credentials, identity.CredentialsTypeOIDC)
+		actualSession, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.Equal(t, source.ID, actualSession.IdentityID)
+	})
+
+	t.Run("case=dry run does not invalidate cached sessions", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, true)
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, false)
+		})
+
+		target, source, s := newPair(t)
+		reg.SessionCache().Set(ctx, s.Token, s)
+
+		merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID, "dry_run": true}, http.StatusOK)
+		_, ok := reg.SessionCache().Get(ctx, s.Token)
+		assert.True(t, ok)
+
+		merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID}, http.StatusOK)
+		_, ok = reg.SessionCache().Get(ctx, s.Token)
+		assert.False(t, ok)
+	})
+
+	t.Run("case=reports dropped credentials", func(t *testing.T) {
+		targetEmail := x.NewUUID().String() + "@ory.sh"
+		sourceEmail := x.NewUUID().String() + "@ory.sh"
+		target, _ := createIdentity(t, `{"email":"`+targetEmail+`"}`, false, password(targetEmail))
+		source, _ := createIdentity(t, `{"email":"`+sourceEmail+`"}`, false, password(sourceEmail), oidc(x.NewUUID().String()))
+
+		res := merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID, "dry_run": true}, http.StatusOK)
+		assert.JSONEq(t, `["password"]`, res.Get("dropped_credentials").Raw, "%s", res.Raw)
+		assert.JSONEq(t, `["oidc"]`, res.Get("moved_credentials").Raw, "%s", res.Raw)
+		assert.JSONEq(t, `["`+sourceEmail+`"]`, res.Get("dropped_identifiers").Raw, "%s", res.Raw)
+		assert.JSONEq(t, `["`+sourceEmail+`"]`, res.Get("dropped_addresses").Raw, "%s", res.Raw)
+
+		res = merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID}, http.StatusOK)
+		assert.JSONEq(t, `["password"]`, res.Get("dropped_credentials").Raw, "%s", res.Raw)
+
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, target.ID)
+		require.NoError(t, err)
+		// The password identifiers are derived from the merged traits, which keep the target's email.
+		assert.Equal(t, []string{targetEmail}, actual.Credentials[identity.CredentialsTypePassword].Identifiers)
+	})
+
+	t.Run("case=merges the source into the target", func(t *testing.T) {
+		target, source, s := newPair(t)
+
+		res := merge(t, target.ID.String(), map[string]any{"source_identity_id": source.ID}, http.StatusOK)
+		assert.False(t, res.Get("dry_run").Bool(), "%s", res.Raw)
+
+		_, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, source.ID, identity.ExpandNothing)
+		require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, target.ID)
+		require.NoError(t, err)
+		assert.Equal(t, gjson.GetBytes(target.Traits, "email").String(), gjson.GetBytes(actual.Traits, "email").String())
+		assert.Equal(t, "src", gjson.GetBytes(actual.Traits, "nickname").String())
+		assert.Equal(t, source.Credentials[identity.CredentialsTypeOIDC].Identifiers, actual.Credentials[identity.CredentialsTypeOIDC].Identifiers)
+		assert.Equal(t, target.Credentials[identity.CredentialsTypePassword].Identifiers, actual.Credentials[identity.CredentialsTypePassword].Identifiers)
+
+		actualSession, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.Equal(t, target.ID, actualSession.IdentityID)
+	})
+
+	t.Run("case=uses the configured mapper to resolve trait conflicts", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyIdentityMergeMapperURL, "base64://"+base64.StdEncoding.EncodeToString([]byte(`
+local source = std.extVar('source');
+{
+  traits: source.traits,
+  metadata_admin: { merged_from: source.id },
+}`)))
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeyIdentityMergeMapperURL, "")
+		})
+
+		target, source, _ := newPair(t)
+		_, err := reg.IdentityManager().Merge(ctx, target.ID, source.ID, false)
+		require.NoError(t, err)
+
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, target.ID)
+		require.NoError(t, err)
+		email := gjson.GetBytes(source.Traits, "email").String()
+		assert.Equal(t, "Source", gjson.GetBytes(actual.Traits, "name").String())
+		assert.Equal(t, source.ID.String(), gjson.GetBytes(actual.MetadataAdmin, "merged_from").String())
+		assert.Equal(t, []string{email}, actual.Credentials[identity.CredentialsTypePassword].Identifiers)
+		require.Len(t, actual.VerifiableAddresses, 1)
+		assert.Equal(t, email, actual.VerifiableAddresses[0].Value)
+		assert.True(t, actual.VerifiableAddresses[0].Verified, "the source's verification status is kept")
+	})
+
+	t.Run("case=fails on invalid requests", func(t *testing.T) {
+		target, _, _ := newPair(t)
+
+		res := merge(t, target.ID.String(), map[string]any{"source_identity_id": target.ID}, http.StatusBadRequest)
+		assert.Contains(t, res.Get("error.reason").String(), "itself", "%s", res.Raw)
+		merge(t, target.ID.String(), map[string]any{}, http.StatusBadRequest)
+		merge(t, target.ID.String(), map[string]any{"source_identity_id": x.NewUUID()}, http.StatusNotFound)
+		merge(t, x.NewUUID().String(), map[string]any{"source_identity_id": target.ID}, http.StatusNotFound)
+	})
+
+	t.Run("case=is reachable through the public admin prefix", func(t *testing.T) {
+		target, source, _ := newPair(t)
+
+		var b bytes.Buffer
+		require.NoError(t, json.NewEncoder(&b).Encode(map[string]any{"source_identity_id": source.ID, "dry_run": true}))
+		res, err := publicTS.Client().Post(publicTS.URL+x.AdminPrefix+"/identities/"+target.ID.String()+"/merge", "application/json", &b)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
@@ -0,0 +1,274 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/pagination/keysetpagination"
+	"github.com/ory/x/urlx"
+
+	"my.com/secrets/internal/auth/domain/courier/template/email"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+// identityBatchSize is the number of identities which are loaded at once when processing identities in bulk.
+const identityBatchSize = 100
+
+// passwordCredentials returns the identity's password credentials and their decoded configuration. It returns false
+// if the identity has no password.
+func (i *Identity) passwordCredentials() (*Credentials, *CredentialsPassword, bool, error) {
+	c, ok := i.GetCredentials(CredentialsTypePassword)
+	if !ok || len(c.Config) == 0 {
+		return nil, nil, false, nil
+	}
+
+	var cp CredentialsPassword
+	if err := json.Unmarshal(c.Config, &cp); err != nil {
+		return nil, nil, false, errors.WithStack(herodot.ErrInternalServerError.WithReason("The password credentials could not be decoded properly").WithDebug(err.Error()).WithWrap(err))
+	}
+
+	return c, &cp, true, nil
+}
+
+// PasswordChangeRequiredSince returns the point in time since which the identity must change its password, either
+// because the password expired or because an administrator required it. The identity's credentials must be loaded.
+func (m *Manager) PasswordChangeRequiredSince(ctx context.Context, i *Identity) (time.Time, bool, error) {
+	_, cp, ok, err := i.passwordCredentials()
+	if err != nil || !ok {
+		return time.Time{}, false, err
+	}
+
+	since, required := cp.ChangeRequiredSince(m.r.Config().PasswordMaxAge(ctx, i.SchemaID, i.OrganizationID), time.Now().UTC())
+	return since, required, nil
+}
+
+// RequirePasswordChange requires the identity to change its password after the next login with it.
+func (m *Manager) RequirePasswordChange(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.RequirePasswordChange")
+	defer otelx.End(span, &err)
+
+	i, err := m.r.PrivilegedIdentityPool().GetIdentityConfidential(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	changed, err := m.requirePasswordChange(ctx, i, time.Now().UTC())
+	if err != nil {
+		return err
+	} else if !changed {
+		return errors.WithStack(herodot.ErrBadRequest.WithReason("The identity does not have a password which could be changed."))
+	}
+
+	return nil
+}
+
+// RequirePasswordChangeForIdentities requires all identities which match the parameters and have a password to
+// change it after their next login. It returns the number of affected identities.
+func (m *Manager) RequirePasswordChangeForIdentities(ctx context.Context, params ListIdentityParameters) (_ int, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.RequirePasswordChangeForIdentities")
+	defer otelx.End(span, &err)
+
+	now := time.Now().UTC()
+	var count int
+	err = m.forEachIdentity(ctx, params, func(i *Identity) error {
+		changed, err := m.requirePasswordChange(ctx, i, now)
+		if err != nil {
+			return err
+		} else if changed {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (m *Manager) requirePasswordChange(ctx context.Context, i *Identity, now time.Time) (bool, error) {
+	c, cp, ok, err := i.passwordCredentials()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	cp.ChangeRequiredAt = &now
+	if err := i.SetCredentialsWithConfig(CredentialsTypePassword, *c, cp); err != nil {
+		return false, err
+	}
+
+	if err := m.r.PrivilegedIdentityPool().UpdateIdentity(ctx, i); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SendPasswordExpiryReminders notifies all identities whose password expires within the configured reminder lead
+// time and which were not notified yet. Only identities whose password is old enough to expire under the shortest
+// configured maximum age are loaded. Identities which can not be notified are logged and skipped. It returns the
+// number of notified identities.
+func (m *Manager) SendPasswordExpiryReminders(ctx context.Context) (_ int, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.SendPasswordExpiryReminders")
+	defer otelx.End(span, &err)
+
+	leadTime := m.r.Config().PasswordExpiryReminderLeadTime(ctx)
+	shortest := m.r.Config().ShortestPasswordMaxAge(ctx)
+	if leadTime <= 0 || shortest <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	changedBefore := now.Add(leadTime - shortest)
+	var count int
+	err = m.forEachIdentity(ctx, ListIdentityParameters{Expand: ExpandCredentials, PasswordChangedBefore: &changedBefore}, func(i *Identity) error {
+		notified, err := m.sendPasswordExpiryReminder(ctx, i, leadTime, now)
+		if err != nil {
+			m.r.Logger().WithError(err).WithField("identity_id", i.ID).Warn("Unable to send the password expiry reminder, skipping identity.")
+		} else if notified {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// sendPasswordExpiryReminder notifies the identity if its password expires within the lead time and it was not
+// notified yet.
+func (m *Manager) sendPasswordExpiryReminder(ctx context.Context, i *Identity, leadTime time.Duration, now time.Time) (bool, error) {
+	_, cp, ok, err := i.passwordCredentials()
+	if err != nil || !ok || cp.ExpiryReminderSentAt != nil {
+		return false, err
+	}
+
+	expiresAt, ok := cp.ExpiresAt(m.r.Config().PasswordMaxAge(ctx, i.SchemaID, i.OrganizationID))
+	if !ok || now.Before(expiresAt.Add(-leadTime)) || !now.Before(expiresAt) {
+		return false, nil
+	}
+
+	// Only the credentials were loaded, but updating the identity requires all of its associations.
+	i, err = m.r.PrivilegedIdentityPool().GetIdentityConfidential(ctx, i.ID)
+	if err != nil {
+		return false, err
+	}
+	c, cp, ok, err := i.passwordCredentials()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// The reminder is recorded before it is sent, so that an identity which can not be updated is not
+	// notified over and over again.
+	cp.ExpiryReminderSentAt = &now
+	if err := i.SetCredentialsWithConfig(CredentialsTypePassword, *c, cp); err != nil {
+		return false, err
+	}
+	if err := m.r.PrivilegedIdentityPool().UpdateIdentity(ctx, i); err != nil {
+		return false, err
+	}
+
+	if err := m.notifyPasswordExpiry(ctx, i, expiresAt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Manager) notifyPasswordExpiry(ctx context.Context, i *Identity, expiresAt time.Time) error {
+	model, err := x.StructToMap(i.CopyWithoutCredentials())
+	if err != nil {
+		return err
+	}
+
+	settingsURL := urlx.AppendPaths(m.r.Config().SelfPublicURL(ctx), "/self-service/settings/browser").String()
+
+	c, err := m.r.Courier(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range i.VerifiableAddresses {
+		if address.Via != AddressTypeEmail {
+			continue
+		}
+
+		if _, err := c.QueueEmail(ctx, email.NewPasswordExpiryReminder(m.r, &email.PasswordExpiryReminderModel{
+			To:          address.Value,
+			SettingsURL: settingsURL,
+			ExpiresAt:   expiresAt,
+			Identity:    model,
+		})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WatchPasswordExpiry periodically sends password expiry reminders until the context is cancelled.
+func (m *Manager) WatchPasswordExpiry(ctx context.Context) error {
+	ticker := time.NewTicker(m.r.Config().PasswordExpiryCheckInterval(ctx))
+	defer ticker.Stop()
+
+	for {
+		if count, err := m.SendPasswordExpiryReminders(ctx); err != nil {
+			m.r.Logger().WithError(err).Error("Unable to send password expiry reminders.")
+		} else if count > 0 {
+			m.r.Logger().WithField("count", count).Info("Sent password expiry reminders.")
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// forEachIdentity calls f for every identity which matches the parameters, as forEachIdentityBatch does.
+func (m *Manager) forEachIdentity(ctx context.Context, params ListIdentityParameters, f func(i *Identity) error) error {
+	params.KeySetPagination = nil
+	return m.forEachIdentityBatch(ctx, params, func(is []Identity) error {
+		for k := range is {
+			if err := f(&is[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forEachIdentityBatch calls f for every page of identities which match the parameters. Unless the parameters
+// name the associations to expand, the identities include their credentials and addresses. Unless the parameters
+// contain key set pagination options, it starts with the first identity and loads identityBatchSize identities at
+// once.
+func (m *Manager) forEachIdentityBatch(ctx context.Context, params ListIdentityParameters, f func(is []Identity) error) error {
+	if len(params.Expand) == 0 {
+		params.Expand = ExpandEverything
+	}
+	params.PagePagination = nil
+	if len(params.KeySetPagination) == 0 {
+		params.KeySetPagination = []keysetpagination.Option{keysetpagination.WithSize(identityBatchSize)}
+	}
+
+	for {
+		is, next, err := m.r.PrivilegedIdentityPool().ListIdentities(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		if err := f(is); err != nil {
+			return err
+		}
+
+		if next == nil || next.IsLast() {
+			return nil
+		}
+		params.KeySetPagination = next.ToOptions()
+	}
+}
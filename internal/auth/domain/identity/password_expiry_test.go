@@ -0,0 +1,82 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestSendPasswordExpiryReminders(t *testing.T) {
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/manager.schema.json")
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, "https://www.ory.sh/")
+	conf.MustSet(ctx, config.ViperKeyPasswordMaxAge, "720h")
+
+	createIdentity := func(t *testing.T, changedAt time.Time, reminded bool) *identity.Identity {
+		cp := identity.CredentialsPassword{HashedPassword: "foo", PasswordChangedAt: &changedAt}
+		if reminded {
+			cp.ExpiryReminderSentAt = &changedAt
+		}
+		raw, err := json.Marshal(cp)
+		require.NoError(t, err)
+
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		email := x.NewUUID().String() + "@ory.sh"
+		i.Traits = identity.Traits(`{"email":"` + email + `"}`)
+		i.SetCredentials(identity.CredentialsTypePassword, identity.Credentials{
+			Type:        identity.CredentialsTypePassword,
+			Identifiers: []string{email},
+			Config:      raw,
+		})
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+		return i
+	}
+
+	reminderSent := func(t *testing.T, i *identity.Identity) bool {
+		actual, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, i.ID)
+		require.NoError(t, err)
+		var cp identity.CredentialsPassword
+		require.NoError(t, json.Unmarshal(actual.Credentials[identity.CredentialsTypePassword].Config, &cp))
+		return cp.ExpiryReminderSentAt != nil
+	}
+
+	now := time.Now().UTC()
+	expiring := createIdentity(t, now.Add(-700*time.Hour), false)
+	recent := createIdentity(t, now.Add(-24*time.Hour), false)
+	expired := createIdentity(t, now.Add(-1000*time.Hour), false)
+	reminded := createIdentity(t, now.Add(-710*time.Hour), true)
+
+	t.Run("case=does nothing without a lead time", func(t *testing.T) {
+		count, err := reg.IdentityManager().SendPasswordExpiryReminders(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	conf.MustSet(ctx, config.ViperKeyPasswordExpiryReminderLeadTime, "168h")
+
+	t.Run("case=notifies identities whose password expires soon", func(t *testing.T) {
+		count, err := reg.IdentityManager().SendPasswordExpiryReminders(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.True(t, reminderSent(t, expiring))
+		assert.False(t, reminderSent(t, recent))
+		assert.False(t, reminderSent(t, expired))
+		assert.True(t, reminderSent(t, reminded))
+
+		count, err = reg.IdentityManager().SendPasswordExpiryReminders(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count, "identities are only notified once")
+	})
+}
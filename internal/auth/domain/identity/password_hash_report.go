@@ -0,0 +1,146 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/pagination/keysetpagination"
+
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+// passwordHashMetrics are the metrics about password hashes which do not meet the configured hasher parameters.
+type passwordHashMetrics struct {
+	total    prometheus.Gauge
+	outdated prometheus.Gauge
+	migrated prometheus.Counter
+}
+
+func newPasswordHashMetrics() *passwordHashMetrics {
+	return &passwordHashMetrics{
+		total: x.RegisterMetric(prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kratos_identity_password_hashes_total",
+			Help: "Number of identities with a password, as of the last password hash report.",
+		})),
+		outdated: x.RegisterMetric(prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kratos_identity_password_hashes_outdated",
+			Help: "Number of identities whose password hash does not meet the configured hasher parameters, as of the last password hash report.",
+		})),
+		migrated: x.RegisterMetric(prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kratos_identity_password_hashes_migrated_total",
+			Help: "Number of password hashes which were replaced with a hash using the configured hasher parameters after a successful login.",
+		})),
+	}
+}
+
+// Password Hash Report
+//
+// swagger:model passwordHashReport
+type PasswordHashReport struct {
+	// Total is the number of identities with a password.
+	//
+	// required: true
+	Total int `json:"total"`
+
+	// Outdated is the number of identities whose password hash does not meet the configured hasher parameters. These
+	// hashes are replaced when the identity signs in with its password the next time.
+	//
+	// required: true
+	Outdated int `json:"outdated"`
+
+	// GeneratedAt is the time at which the report was generated.
+	//
+	// required: true
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// PasswordHashMigrated counts a password hash which was replaced after a successful login because it did not meet
+// the configured hasher parameters.
+func (m *Manager) PasswordHashMigrated() {
+	m.hashMetrics.migrated.Inc()
+}
+
+// ReportPasswordHashes counts the identities whose password hash does not meet the configured hasher parameters and
+// exports the result as metrics.
+func (m *Manager) ReportPasswordHashes(ctx context.Context) (_ *PasswordHashReport, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.ReportPasswordHashes")
+	defer otelx.End(span, &err)
+
+	report := PasswordHashReport{GeneratedAt: time.Now().UTC()}
+	if err := m.forEachIdentityBatch(ctx, ListIdentityParameters{Expand: ExpandCredentials}, func(is []Identity) error {
+		return m.countPasswordHashes(ctx, &report, is)
+	}); err != nil {
+		return nil, err
+	}
+
+	m.hashMetrics.total.Set(float64(report.Total))
+	m.hashMetrics.outdated.Set(float64(report.Outdated))
+	return &report, nil
+}
+
+// ReportPasswordHashesPage counts the password hashes of one page of identities. Callers add up the reports of all
+// pages to get the report of all identities. It returns the paginator of the next page.
+func (m *Manager) ReportPasswordHashesPage(ctx context.Context, pagination []keysetpagination.Option) (_ *PasswordHashReport, _ *keysetpagination.Paginator, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "identity.Manager.ReportPasswordHashesPage")
+	defer otelx.End(span, &err)
+
+	is, next, err := m.r.PrivilegedIdentityPool().ListIdentities(ctx, ListIdentityParameters{
+		Expand:           ExpandCredentials,
+		KeySetPagination: pagination,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := PasswordHashReport{GeneratedAt: time.Now().UTC()}
+	if err := m.countPasswordHashes(ctx, &report, is); err != nil {
+		return nil, nil, err
+	}
+	return &report, next, nil
+}
+
+func (m *Manager) countPasswordHashes(ctx context.Context, report *PasswordHashReport, is []Identity) error {
+	hasher := m.r.Hasher(ctx)
+	for k := range is {
+		_, cp, ok, err := is[k].passwordCredentials()
+		if err != nil {
+			return err
+		} else if !ok || cp.HashedPassword == "" {
+			continue
+		}
+
+		report.Total++
+		if hasher.NeedsRehash(ctx, []byte(cp.HashedPassword)) {
+			report.Outdated++
+		}
+	}
+	return nil
+}
+
+// WatchPasswordHashes periodically reports the number of outdated password hashes until the context is cancelled.
+func (m *Manager) WatchPasswordHashes(ctx context.Context) error {
+	ticker := time.NewTicker(m.r.Config().HasherOutdatedReportInterval(ctx))
+	defer ticker.Stop()
+
+	for {
+		if _, err := m.ReportPasswordHashes(ctx); err != nil {
+			m.r.Logger().WithError(err).Error("Unable to report outdated password hashes.")
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
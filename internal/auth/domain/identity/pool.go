@@ -5,6 +5,7 @@ package identity
 
 import (
 	"context"
+	"time"
 
 	"github.com/ory/x/crdbx"
 
@@ -21,7 +22,9 @@ type (
 		IdsFilter                    []string
 		CredentialsIdentifier        string
 		CredentialsIdentifierSimilar string
-		KeySetPagination             []keysetpagination.Option
+		// PasswordChangedBefore only lists identities whose password was last set at or before this time.
+		PasswordChangedBefore *time.Time
+		KeySetPagination      []keysetpagination.Option
 		// DEPRECATED
 		PagePagination   *x.Page
 		ConsistencyLevel crdbx.ConsistencyLevel
@@ -66,6 +69,9 @@ type (
 		// UpdateVerifiableAddress updates an identity's verifiable address.
 		UpdateVerifiableAddress(ctx context.Context, address *VerifiableAddress) error
 
+		// VerifyAddress marks the verifiable address with the given, unexpired verification code as verified.
+		VerifyAddress(ctx context.Context, code string) error
+
 		// CreateIdentity creates an identity. It is capable of setting credentials without encoding. Will return an error
 		// if identity exists, backend connectivity is broken, or trait validation fails.
 		CreateIdentity(context.Context, *Identity) error
@@ -95,5 +101,9 @@ type (
 
 		// FindIdentityByAnyCaseSensitiveCredentialIdentifier returns an identity by matching the identifier to any of the identity's credentials.
 		FindIdentityByCredentialIdentifier(ctx context.Context, identifier string, caseSensitive bool) (*Identity, error)
+
+		// CountStaleSealedValues returns, per identity, the number of encrypted traits and blind indexes which were
+		// not sealed with the current cipher and blind index secrets. Updating the identity seals them again.
+		CountStaleSealedValues(ctx context.Context, ids ...uuid.UUID) (map[uuid.UUID]int, error)
 	}
 )
@@ -62,6 +62,11 @@ func TestPool(ctx context.Context, conf *config.Config, p persistence.Persister,
 			URL:    urlx.ParseOrPanic("file://./stub/handler/multiple_emails.schema.json"),
 			RawURL: "file://./stub/identity-2.schema.json",
 		}
+		encryptedSchema := schema.Schema{
+			ID:     "encrypted",
+			URL:    urlx.ParseOrPanic("file://./stub/encrypted.schema.json"),
+			RawURL: "file://./stub/encrypted.schema.json",
+		}
 		conf.MustSet(ctx, config.ViperKeyIdentitySchemas, []config.Schema{
 			{
 				ID:  altSchema.ID,
@@ -79,6 +84,10 @@ func TestPool(ctx context.Context, conf *config.Config, p persistence.Persister,
 				ID:  multipleEmailsSchema.ID,
 				URL: multipleEmailsSchema.RawURL,
 			},
+			{
+				ID:  encryptedSchema.ID,
+				URL: encryptedSchema.RawURL,
+			},
 		})
 
 		t.Run("case=expand", func(t *testing.T) {
@@ -900,6 +909,175 @@ func TestPool(ctx context.Context, conf *config.Config, p persistence.Persister,
 			})
 		})
 
+		t.Run("case=encrypted traits", func(t *testing.T) {
+			email := "encrypted-" + x.NewUUID().String() + "@ory.sh"
+			expected := identity.NewIdentity(encryptedSchema.ID)
+			expected.Traits = identity.Traits(`{"email":"` + email + `","name":{"first":"Foo"},"nickname":"foo"}`)
+			require.NoError(t, m.ValidateIdentity(ctx, expected, new(identity.ManagerOptions)))
+			require.NoError(t, p.CreateIdentity(ctx, expected))
+			createdIDs = append(createdIDs, expected.ID)
+			assert.Contains(t, string(expected.Traits), email, "the plaintext traits are restored after writing")
+
+			require.Len(t, expected.VerifiableAddresses, 1)
+			require.Len(t, expected.RecoveryAddresses, 1)
+			assert.Equal(t, email, expected.VerifiableAddresses[0].Value, "the plaintext addresses are restored after writing")
+			assert.Equal(t, email, expected.RecoveryAddresses[0].Value)
+
+			assertSealed := func(t *testing.T) {
+				var stored struct {
+					Traits               string `db:"traits"`
+					Identifier           string `db:"identifier"`
+					IdentifierBlindIndex string `db:"identifier_blind_index"`
+				}
+				require.NoError(t, p.GetConnection(ctx).RawQuery(`
+SELECT i.traits, ici.identifier, ici.identifier_blind_index
+FROM identities i
+INNER JOIN identity_credentials ic ON ic.identity_id = i.id
+INNER JOIN identity_credential_identifiers ici ON ici.identity_credential_id = ic.id
+WHERE i.id = ?`, expected.ID).First(&stored))
+				assert.NotContains(t, stored.Traits, email)
+				assert.NotContains(t, stored.Traits, "Foo")
+				assert.Contains(t, stored.Traits, "nickname")
+				assert.NotContains(t, stored.Identifier, email)
+				assert.NotEmpty(t, stored.IdentifierBlindIndex)
+
+				for _, table := range []string{"identity_verifiable_addresses", "identity_recovery_addresses"} {
+					var address struct {
+						Value           string `db:"value"`
+						ValueBlindIndex string `db:"value_blind_index"`
+					}
+					// #nosec G201 -- table is static
+					require.NoError(t, p.GetConnection(ctx).RawQuery(fmt.Sprintf("SELECT value, value_blind_index FROM %s WHERE identity_id = ?", table), expected.ID).First(&address))
+					assert.NotContains(t, address.Value, email, table)
+					assert.NotEmpty(t, address.ValueBlindIndex, table)
+				}
+			}
+			assertSealed(t)
+
+			actual, err := p.GetIdentityConfidential(ctx, expected.ID)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected.Traits), string(actual.Traits))
+			assert.Equal(t, []string{email}, actual.Credentials[identity.CredentialsTypePassword].Identifiers)
+			require.Len(t, actual.VerifiableAddresses, 1)
+			require.Len(t, actual.RecoveryAddresses, 1)
+			assert.Equal(t, email, actual.VerifiableAddresses[0].Value)
+			assert.Equal(t, email, actual.RecoveryAddresses[0].Value)
+
+			t.Run("case=addresses", func(t *testing.T) {
+				verifiable, err := p.FindVerifiableAddressByValue(ctx, identity.VerifiableAddressTypeEmail, strings.ToUpper(email))
+				require.NoError(t, err)
+				assert.Equal(t, expected.ID, verifiable.IdentityID)
+				assert.Equal(t, email, verifiable.Value)
+
+				recovery, err := p.FindRecoveryAddressByValue(ctx, identity.RecoveryAddressTypeEmail, email)
+				require.NoError(t, err)
+				assert.Equal(t, expected.ID, recovery.IdentityID)
+				assert.Equal(t, email, recovery.Value)
+
+				verifiable.Verified = true
+				verifiable.Status = identity.VerifiableAddressStatusCompleted
+				require.NoError(t, p.UpdateVerifiableAddress(ctx, verifiable))
+				assert.Equal(t, email, verifiable.Value)
+				assertSealed(t)
+
+				actual, err := p.GetIdentityConfidential(ctx, expected.ID)
+				require.NoError(t, err)
+				actual.Traits = identity.Traits(`{"email":"` + email + `","name":{"first":"Foo"},"nickname":"bar"}`)
+				require.NoError(t, p.UpdateIdentity(ctx, actual))
+				expected.Traits = actual.Traits
+				assertSealed(t)
+
+				updated, err := p.GetIdentityConfidential(ctx, expected.ID)
+				require.NoError(t, err)
+				require.Len(t, updated.VerifiableAddresses, 1)
+				assert.Equal(t, verifiable.ID, updated.VerifiableAddresses[0].ID, "unchanged addresses are kept")
+				assert.True(t, updated.VerifiableAddresses[0].Verified)
+				assert.Equal(t, email, updated.VerifiableAddresses[0].Value)
+				assert.Equal(t, recovery.ID, updated.RecoveryAddresses[0].ID)
+			})
+
+			found, creds, err := p.FindByCredentialsIdentifier(ctx, identity.CredentialsTypePassword, strings.ToUpper(email))
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, found.ID)
+			assert.JSONEq(t, string(expected.Traits), string(found.Traits))
+			assert.Equal(t, []string{email}, creds.Identifiers)
+
+			found, err = p.FindIdentityByCredentialIdentifier(ctx, email, true)
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, found.ID)
+
+			listed, _, err := p.ListIdentities(ctx, identity.ListIdentityParameters{CredentialsIdentifier: email, Expand: identity.ExpandEverything})
+			require.NoError(t, err)
+			require.Len(t, listed, 1)
+			assert.JSONEq(t, string(expected.Traits), string(listed[0].Traits))
+			assert.Equal(t, []string{email}, listed[0].Credentials[identity.CredentialsTypePassword].Identifiers)
+
+			t.Run("case=blind index secret rotation", func(t *testing.T) {
+				conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"new-blind-index-secret", "old-blind-index-secret"})
+				t.Cleanup(func() {
+					conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, nil)
+				})
+
+				rotated := identity.NewIdentity(encryptedSchema.ID)
+				rotatedEmail := "rotated-" + email
+				rotated.Traits = identity.Traits(`{"email":"` + rotatedEmail + `"}`)
+				require.NoError(t, m.ValidateIdentity(ctx, rotated, new(identity.ManagerOptions)))
+				conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"old-blind-index-secret"})
+				require.NoError(t, p.CreateIdentity(ctx, rotated))
+				createdIDs = append(createdIDs, rotated.ID)
+
+				conf.MustSet(ctx, config.ViperKeySecretsBlindIndex, []string{"new-blind-index-secret", "old-blind-index-secret"})
+				found, creds, err := p.FindByCredentialsIdentifier(ctx, identity.CredentialsTypePassword, rotatedEmail)
+				require.NoError(t, err)
+				assert.Equal(t, rotated.ID, found.ID)
+				assert.Equal(t, []string{rotatedEmail}, creds.Identifiers)
+
+				duplicate := identity.NewIdentity(encryptedSchema.ID)
+				duplicate.Traits = identity.Traits(`{"email":"` + strings.ToUpper(rotatedEmail) + `"}`)
+				require.NoError(t, m.ValidateIdentity(ctx, duplicate, new(identity.ManagerOptions)))
+				require.ErrorIs(t, p.CreateIdentity(ctx, duplicate), sqlcon.ErrUniqueViolation, "identifiers indexed with a previous secret are duplicates too")
+			})
+
+			t.Run("case=fail on duplicate identifiers stored in plain text", func(t *testing.T) {
+				plaintextEmail := "plaintext-" + email
+				plaintext := passwordIdentity("", plaintextEmail)
+				require.NoError(t, p.CreateIdentity(ctx, plaintext))
+				createdIDs = append(createdIDs, plaintext.ID)
+
+				// The trait was marked as encrypted after the identifier was stored in plain text.
+				sealed := identity.NewIdentity(encryptedSchema.ID)
+				sealed.Traits = identity.Traits(`{"email":"` + plaintextEmail + `"}`)
+				require.NoError(t, m.ValidateIdentity(ctx, sealed, new(identity.ManagerOptions)))
+				require.ErrorIs(t, p.CreateIdentity(ctx, sealed), sqlcon.ErrUniqueViolation)
+
+				// The trait is no longer encrypted, but the identifier was sealed before.
+				require.ErrorIs(t, p.CreateIdentity(ctx, passwordIdentity("", email)), sqlcon.ErrUniqueViolation)
+
+				updated := passwordIdentity("", "updated-"+email)
+				require.NoError(t, p.CreateIdentity(ctx, updated))
+				createdIDs = append(createdIDs, updated.ID)
+				updated.SetCredentials(identity.CredentialsTypePassword, identity.Credentials{
+					Type: identity.CredentialsTypePassword, Identifiers: []string{email},
+					Config: sqlxx.JSONRawMessage(`{"foo":"bar"}`),
+				})
+				require.ErrorIs(t, p.UpdateIdentity(ctx, updated), sqlcon.ErrUniqueViolation)
+			})
+
+			t.Run("case=fail on duplicate encrypted identifiers", func(t *testing.T) {
+				duplicate := identity.NewIdentity(encryptedSchema.ID)
+				duplicate.Traits = identity.Traits(`{"email":"` + email + `"}`)
+				require.NoError(t, m.ValidateIdentity(ctx, duplicate, new(identity.ManagerOptions)))
+				err := p.CreateIdentity(ctx, duplicate)
+				require.ErrorIs(t, err, sqlcon.ErrUniqueViolation)
+			})
+
+			t.Run("not if on another network", func(t *testing.T) {
+				_, p := testhelpers.NewNetwork(t, ctx, p)
+				_, _, err := p.FindByCredentialsIdentifier(ctx, identity.CredentialsTypePassword, email)
+				require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			})
+		})
+
 		t.Run("case=find identity by its credentials respects cases", func(t *testing.T) {
 			caseSensitive := "6Q(%ZKd~8u_(5uea@ory.sh"
 			caseInsensitiveWithSpaces := " 6Q(%ZKD~8U_(5uea@ORY.sh "
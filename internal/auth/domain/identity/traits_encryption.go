@@ -0,0 +1,159 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/ory/herodot"
+	"github.com/ory/jsonschema/v3"
+	"github.com/ory/x/jsonschemax"
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/schema"
+)
+
+// encryptedTraitKey is the only key of the object which replaces an encrypted trait in the stored traits.
+const encryptedTraitKey = "$encrypted"
+
+// EncryptedTraitPaths returns the paths of the traits which the identity schema marks as encrypted:
+//
+//	"ory.sh/kratos": {"encrypted": true}
+//
+// Traits nested in an encrypted trait are encrypted as part of it.
+func EncryptedTraitPaths(ctx context.Context, schemaURL string) ([]string, error) {
+	runner, err := schema.NewExtensionRunner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	runner.Register(compiler)
+
+	paths, err := jsonschemax.ListPaths(ctx, schemaURL, compiler)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to parse the identity schema: %s", err))
+	}
+
+	var encrypted []string
+	for _, p := range paths {
+		if marked, _ := p.CustomProperties[schema.ExtensionEncrypted].(bool); !marked {
+			continue
+		}
+
+		name, ok := strings.CutPrefix(p.Name, "traits.")
+		if !ok {
+			continue
+		}
+
+		// Paths are sorted, so a parent is always listed before its children.
+		var nested bool
+		for _, parent := range encrypted {
+			if strings.HasPrefix(name, parent+".") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			encrypted = append(encrypted, name)
+		}
+	}
+
+	return encrypted, nil
+}
+
+// EncryptTraits returns a copy of the traits in which the values at the given paths are encrypted.
+func EncryptTraits(ctx context.Context, c cipher.Cipher, traits Traits, paths []string) (Traits, error) {
+	sealed := append([]byte{}, traits...)
+	for _, path := range paths {
+		value := gjson.GetBytes(sealed, path)
+		if !value.Exists() || isEncryptedTrait(value) {
+			continue
+		}
+
+		ciphertext, err := c.Encrypt(ctx, []byte(value.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := json.Marshal(map[string]string{encryptedTraitKey: ciphertext})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if sealed, err = sjson.SetRawBytes(sealed, path, raw); err != nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to encrypt the trait %q: %s", path, err))
+		}
+	}
+
+	return sealed, nil
+}
+
+// DecryptTraits returns a copy of the traits in which the values at the given paths are decrypted. Values which
+// are not encrypted, for example because they were stored before the trait was marked as encrypted, are kept.
+func DecryptTraits(ctx context.Context, c cipher.Cipher, traits Traits, paths []string) (Traits, error) {
+	opened := append([]byte{}, traits...)
+	for _, path := range paths {
+		value := gjson.GetBytes(opened, path)
+		if !isEncryptedTrait(value) {
+			continue
+		}
+
+		plaintext, err := c.Decrypt(ctx, value.Get(encryptedTraitKey).String())
+		if err != nil {
+			return nil, err
+		}
+
+		if opened, err = sjson.SetRawBytes(opened, path, plaintext); err != nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to decrypt the trait %q: %s", path, err))
+		}
+	}
+
+	return opened, nil
+}
+
+// CountStaleEncryptedTraits returns the number of traits at the given paths of the stored traits which are not
+// encrypted with the current secret, including traits which were stored before they were marked as encrypted.
+func CountStaleEncryptedTraits(ctx context.Context, c cipher.Cipher, traits Traits, paths []string) (count int) {
+	for _, path := range paths {
+		value := gjson.GetBytes(traits, path)
+		if !value.Exists() {
+			continue
+		}
+		if !isEncryptedTrait(value) || c.NeedsReencryption(ctx, value.Get(encryptedTraitKey).String()) {
+			count++
+		}
+	}
+	return count
+}
+
+// EncryptedTraitValues returns the string values at the given paths of the plaintext traits.
+func EncryptedTraitValues(traits Traits, paths []string) []string {
+	var values []string
+	for _, path := range paths {
+		value := gjson.GetBytes(traits, path)
+		if value.Type == gjson.String && value.String() != "" {
+			values = append(values, value.String())
+		}
+	}
+	return values
+}
+
+func isEncryptedTrait(value gjson.Result) bool {
+	if !value.IsObject() {
+		return false
+	}
+
+	var keys int
+	value.ForEach(func(gjson.Result, gjson.Result) bool {
+		keys++
+		return true
+	})
+	return keys == 1 && value.Get(encryptedTraitKey).Type == gjson.String
+}
@@ -0,0 +1,71 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	_ "github.com/ory/jsonschema/v3/fileloader"
+	"github.com/ory/x/configx"
+	"github.com/ory/x/logrusx"
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type cipherConfig struct{ c *config.Config }
+
+func (c *cipherConfig) Config() *config.Config { return c.c }
+
+func TestTraitsEncryption(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{"secret-thirty-two-character-long"})
+	c := cipher.NewCryptChaCha20(&cipherConfig{c: conf})
+
+	paths, err := EncryptedTraitPaths(ctx, "file://./stub/encrypted.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email", "name"}, paths)
+
+	paths, err = EncryptedTraitPaths(ctx, "file://./stub/identity.schema.json")
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+
+	paths = []string{"email", "name", "missing"}
+	traits := Traits(`{"email":"foo@ory.sh","name":{"first":"Foo","last":"Bar"},"nickname":"foo"}`)
+	assert.Equal(t, []string{"foo@ory.sh"}, EncryptedTraitValues(traits, paths))
+
+	sealed, err := EncryptTraits(ctx, c, traits, paths)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "foo@ory.sh")
+	assert.NotContains(t, string(sealed), "Foo")
+	assert.Equal(t, "foo", gjson.GetBytes(sealed, "nickname").String())
+	assert.False(t, gjson.GetBytes(sealed, "missing").Exists())
+	assert.Contains(t, string(traits), "foo@ory.sh", "the traits are not modified")
+
+	again, err := EncryptTraits(ctx, c, sealed, paths)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(sealed), string(again), "encrypted traits are not encrypted twice")
+
+	opened, err := DecryptTraits(ctx, c, sealed, paths)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(traits), string(opened))
+
+	t.Run("case=plaintext values are kept", func(t *testing.T) {
+		opened, err := DecryptTraits(ctx, c, traits, paths)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(traits), string(opened))
+	})
+
+	t.Run("case=unknown secret", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{"another-secret-thirty-two-chars!"})
+		_, err := DecryptTraits(ctx, c, sealed, paths)
+		require.Error(t, err)
+	})
+}
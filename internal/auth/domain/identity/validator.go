@@ -60,7 +60,12 @@ func (v *Validator) ValidateWithRunner(ctx context.Context, i *Identity, runners
 		return errors.WithStack(herodot.ErrBadRequest.WithError(err.Error()))
 	}
 
-	return v.v.Validate(ctx, s.URL.String(), traits, schema.WithExtensionRunner(runner))
+	if err := v.v.Validate(ctx, s.URL.String(), traits, schema.WithExtensionRunner(runner)); err != nil {
+		return err
+	}
+
+	i.SchemaVersion = s.Version
+	return nil
 }
 
 func (v *Validator) Validate(ctx context.Context, i *Identity) error {
@@ -0,0 +1,146 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package job
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/ory/x/pagination/migrationpagination"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	RouteCollection = "/jobs"
+	RouteItem       = RouteCollection + "/:id"
+)
+
+type (
+	handlerDependencies interface {
+		x.WriterProvider
+		x.CSRFProvider
+		PersistenceProvider
+		config.Provider
+	}
+	Handler struct {
+		r handlerDependencies
+	}
+	HandlerProvider interface {
+		JobHandler() *Handler
+	}
+)
+
+func NewHandler(r handlerDependencies) *Handler {
+	return &Handler{r: r}
+}
+
+func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
+	h.r.CSRFHandler().IgnoreGlobs(x.AdminPrefix+RouteCollection, x.AdminPrefix+RouteCollection+"/*")
+	public.GET(x.AdminPrefix+RouteCollection, x.RedirectToAdminRoute(h.r))
+	public.GET(x.AdminPrefix+RouteItem, x.RedirectToAdminRoute(h.r))
+}
+
+func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
+	admin.GET(RouteCollection, h.list)
+	admin.GET(RouteItem, h.get)
+}
+
+// List Jobs Parameters
+//
+// swagger:parameters listJobs
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type listJobsParameters struct {
+	migrationpagination.RequestParameters
+
+	// Type filters jobs by their type.
+	//
+	// in: query
+	Type Type `json:"type"`
+}
+
+// List of Jobs
+//
+// swagger:response listJobs
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type listJobsResponse struct {
+	// in: body
+	Body []Job
+}
+
+// swagger:route GET /admin/jobs job listJobs
+//
+// # List Jobs
+//
+// Lists administrative jobs such as data erasures, most recent first.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: listJobs
+//	  default: errorGeneric
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	page, itemsPerPage := x.ParsePagination(r)
+	jobs, err := h.r.JobPersister().ListJobs(r.Context(), Type(r.URL.Query().Get("type")), page, itemsPerPage)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, jobs)
+}
+
+// Get Job Parameters
+//
+// swagger:parameters getJob
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type getJob struct {
+	// ID is the job's ID.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route GET /admin/jobs/{id} job getJob
+//
+// # Get a Job
+//
+// Returns the job's state, progress and, once it has finished, its result.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: job
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	j, err := h.r.JobPersister().GetJob(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, j)
+}
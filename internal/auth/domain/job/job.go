@@ -0,0 +1,126 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/sqlxx"
+)
+
+// A Job's State
+//
+// swagger:model jobState
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+
+	// StatePaused is the state of jobs which were interrupted, for example because the process shut down, and
+	// which can be resumed.
+	StatePaused State = "paused"
+)
+
+// A Job's Type
+//
+// swagger:model jobType
+type Type string
+
+// Job tracks the progress and outcome of a long-running administrative
+// operation.
+//
+// swagger:model job
+type Job struct {
+	// ID is the job's unique identifier.
+	//
+	// required: true
+	ID uuid.UUID `json:"id" faker:"-" db:"id"`
+
+	NID uuid.UUID `json:"-" faker:"-" db:"nid"`
+
+	// Type is the kind of operation this job performs.
+	//
+	// required: true
+	Type Type `json:"type" db:"type"`
+
+	// State is the job's current state.
+	//
+	// required: true
+	State State `json:"state" db:"state"`
+
+	// Subject is the ID of the resource the job operates on, if any.
+	Subject uuid.NullUUID `json:"subject,omitempty" faker:"-" db:"subject"`
+
+	// Progress stores how far the job has come so that it can be resumed.
+	Progress sqlxx.NullJSONRawMessage `json:"progress,omitempty" faker:"-" db:"progress"`
+
+	// Result contains the job's outcome once it has finished.
+	Result sqlxx.NullJSONRawMessage `json:"result,omitempty" faker:"-" db:"result"`
+
+	// Error contains the reason the job failed.
+	Error sqlxx.NullString `json:"error,omitempty" faker:"-" db:"error"`
+
+	// FinishedAt is the time the job succeeded or failed.
+	FinishedAt sqlxx.NullTime `json:"finished_at,omitempty" faker:"-" db:"finished_at"`
+
+	// CreatedAt is a helper struct field for gobuffalo.pop.
+	//
+	// required: true
+	CreatedAt time.Time `json:"created_at" faker:"-" db:"created_at"`
+
+	// UpdatedAt is a helper struct field for gobuffalo.pop.
+	//
+	// required: true
+	UpdatedAt time.Time `json:"updated_at" faker:"-" db:"updated_at"`
+}
+
+func NewJob(t Type, subject uuid.UUID) *Job {
+	j := &Job{Type: t, State: StateRunning}
+	if !subject.IsNil() {
+		j.Subject = uuid.NullUUID{UUID: subject, Valid: true}
+	}
+	return j
+}
+
+func (j Job) TableName(ctx context.Context) string {
+	return "jobs"
+}
+
+func (j *Job) GetID() uuid.UUID {
+	return j.ID
+}
+
+func (j *Job) GetNID() uuid.UUID {
+	return j.NID
+}
+
+// IsFinished returns true if the job is no longer running.
+func (j *Job) IsFinished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed
+}
+
+// Succeed marks the job as succeeded and records its result.
+func (j *Job) Succeed(result []byte) {
+	j.State = StateSucceeded
+	j.Result = sqlxx.NullJSONRawMessage(result)
+	j.Error = ""
+	j.FinishedAt = sqlxx.NullTime(time.Now().UTC())
+}
+
+// Pause marks the job as interrupted so that it can be resumed.
+func (j *Job) Pause() {
+	j.State = StatePaused
+}
+
+// Fail marks the job as failed and records the reason.
+func (j *Job) Fail(err error) {
+	j.State = StateFailed
+	j.Error = sqlxx.NullString(err.Error())
+	j.FinishedAt = sqlxx.NullTime(time.Now().UTC())
+}
@@ -0,0 +1,35 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type (
+	Persister interface {
+		// CreateJob persists a new job.
+		CreateJob(context.Context, *Job) error
+
+		// UpdateJob updates the job's state, progress, result, and error.
+		UpdateJob(context.Context, *Job) error
+
+		// ResumeJob sets the state of the job to running if it failed or was paused, or if it is running but was
+		// not updated since staleBefore because the process running it stopped. The state is changed atomically,
+		// so that only one caller resumes the job. It returns false if the job is in none of these states.
+		ResumeJob(ctx context.Context, id uuid.UUID, staleBefore time.Time) (bool, error)
+
+		// GetJob returns the job with the given ID or an error if it could not be found.
+		GetJob(context.Context, uuid.UUID) (*Job, error)
+
+		// ListJobs lists jobs, most recent first, optionally filtered by type.
+		ListJobs(ctx context.Context, t Type, page, itemsPerPage int) ([]Job, error)
+	}
+	PersistenceProvider interface {
+		JobPersister() Persister
+	}
+)
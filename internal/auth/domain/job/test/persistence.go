@@ -0,0 +1,137 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/sqlcon"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/persistence"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestPersister(ctx context.Context, p persistence.Persister) func(t *testing.T) {
+	return func(t *testing.T) {
+		nid, p := testhelpers.NewNetworkUnlessExisting(t, ctx, p)
+
+		t.Run("case=not found", func(t *testing.T) {
+			_, err := p.GetJob(ctx, x.NewUUID())
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=create, update, and get", func(t *testing.T) {
+			subject := x.NewUUID()
+			j := job.NewJob("test", subject)
+			require.NoError(t, p.CreateJob(ctx, j))
+			assert.Equal(t, nid, j.NID)
+
+			actual, err := p.GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.Equal(t, job.StateRunning, actual.State)
+			assert.Equal(t, subject, actual.Subject.UUID)
+			assert.False(t, actual.IsFinished())
+
+			j.Progress = []byte(`{"cursor":"abc"}`)
+			require.NoError(t, p.UpdateJob(ctx, j))
+			actual, err = p.GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"cursor":"abc"}`, string(actual.Progress))
+
+			j.Succeed([]byte(`{"count":1}`))
+			require.NoError(t, p.UpdateJob(ctx, j))
+			actual, err = p.GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.Equal(t, job.StateSucceeded, actual.State)
+			assert.JSONEq(t, `{"count":1}`, string(actual.Result))
+			assert.True(t, actual.IsFinished())
+		})
+
+		t.Run("case=failed job records the error", func(t *testing.T) {
+			j := job.NewJob("test", x.NewUUID())
+			require.NoError(t, p.CreateJob(ctx, j))
+			j.Fail(errors.New("something went wrong"))
+			require.NoError(t, p.UpdateJob(ctx, j))
+
+			actual, err := p.GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.Equal(t, job.StateFailed, actual.State)
+			assert.Equal(t, "something went wrong", actual.Error.String())
+		})
+
+		t.Run("case=resume", func(t *testing.T) {
+			j := job.NewJob("test", x.NewUUID())
+			require.NoError(t, p.CreateJob(ctx, j))
+
+			resumed, err := p.ResumeJob(ctx, j.ID, time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			assert.False(t, resumed, "running jobs are not resumed")
+
+			resumed, err = p.ResumeJob(ctx, j.ID, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.True(t, resumed, "stale running jobs are resumed")
+
+			for _, interrupt := range []func(){j.Pause, func() { j.Fail(errors.New("something went wrong")) }} {
+				interrupt()
+				require.NoError(t, p.UpdateJob(ctx, j))
+
+				resumed, err = p.ResumeJob(ctx, j.ID, time.Now().Add(-time.Hour))
+				require.NoError(t, err)
+				assert.True(t, resumed)
+
+				actual, err := p.GetJob(ctx, j.ID)
+				require.NoError(t, err)
+				assert.Equal(t, job.StateRunning, actual.State)
+				assert.Empty(t, actual.Error)
+				assert.Empty(t, actual.FinishedAt)
+
+				resumed, err = p.ResumeJob(ctx, j.ID, time.Now().Add(-time.Hour))
+				require.NoError(t, err)
+				assert.False(t, resumed, "jobs are only resumed once")
+			}
+
+			j.Succeed(nil)
+			require.NoError(t, p.UpdateJob(ctx, j))
+			resumed, err = p.ResumeJob(ctx, j.ID, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.False(t, resumed, "succeeded jobs are not resumed")
+
+			_, other := testhelpers.NewNetwork(t, ctx, p)
+			j.Pause()
+			require.NoError(t, p.UpdateJob(ctx, j))
+			resumed, err = other.ResumeJob(ctx, j.ID, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.False(t, resumed)
+		})
+
+		t.Run("case=list filters by type", func(t *testing.T) {
+			require.NoError(t, p.CreateJob(ctx, job.NewJob("other", x.NewUUID())))
+
+			jobs, err := p.ListJobs(ctx, "other", 0, 100)
+			require.NoError(t, err)
+			require.Len(t, jobs, 1)
+			assert.Equal(t, job.Type("other"), jobs[0].Type)
+
+			jobs, err = p.ListJobs(ctx, "", 0, 100)
+			require.NoError(t, err)
+			assert.Len(t, jobs, 4)
+		})
+
+		t.Run("case=network isolation", func(t *testing.T) {
+			j := job.NewJob("test", x.NewUUID())
+			require.NoError(t, p.CreateJob(ctx, j))
+
+			_, other := testhelpers.NewNetwork(t, ctx, p)
+			_, err := other.GetJob(ctx, j.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		})
+	}
+}
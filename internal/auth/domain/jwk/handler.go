@@ -0,0 +1,234 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package jwk
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	RoutePublicKeySet  = "/.well-known/jwks.json"
+	RouteCollection    = "/session-keys"
+	RouteItem          = RouteCollection + "/:id"
+	RouteSetCollection = "/session-key-sets"
+	RouteSetRotate     = RouteSetCollection + "/:alias/rotate"
+)
+
+type (
+	handlerDependencies interface {
+		x.WriterProvider
+		x.CSRFProvider
+		ManagementProvider
+		PersistenceProvider
+		config.Provider
+	}
+	Handler struct {
+		r handlerDependencies
+	}
+	HandlerProvider interface {
+		SigningKeyHandler() *Handler
+	}
+)
+
+func NewHandler(r handlerDependencies) *Handler {
+	return &Handler{r: r}
+}
+
+func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
+	public.GET(RoutePublicKeySet, h.publicKeySet)
+
+	h.r.CSRFHandler().IgnoreGlobs(
+		x.AdminPrefix+RouteCollection, x.AdminPrefix+RouteCollection+"/*",
+		x.AdminPrefix+RouteSetCollection+"/*/rotate",
+	)
+	public.GET(x.AdminPrefix+RouteCollection, x.RedirectToAdminRoute(h.r))
+	public.DELETE(x.AdminPrefix+RouteItem, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteSetRotate, x.RedirectToAdminRoute(h.r))
+}
+
+func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
+	admin.GET(RouteCollection, h.list)
+	admin.DELETE(RouteItem, h.revoke)
+	admin.POST(RouteSetRotate, h.rotate)
+}
+
+// JSON Web Key Set
+//
+// swagger:model jsonWebKeySet
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type jsonWebKeySet struct {
+	// Keys are the public JSON Web Keys of the active, next, and recently retired signing keys.
+	Keys []map[string]interface{} `json:"keys"`
+}
+
+// swagger:route GET /.well-known/jwks.json frontend getSessionJsonWebKeySet
+//
+// # Get the Session Token JSON Web Key Set
+//
+// Returns the public keys which verify tokenized sessions signed with managed keys. The set contains the
+// active key, the next key, which will be activated on the next rotation, and keys which were rotated
+// recently, so that verifiers may cache it.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Responses:
+//	  200: jsonWebKeySet
+//	  default: errorGeneric
+func (h *Handler) publicKeySet(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	set, err := h.r.SigningKeyManager().PublicKeySet(r.Context())
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, set)
+}
+
+// List Session Signing Keys Parameters
+//
+// swagger:parameters listSessionSigningKeys
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type listSessionSigningKeysParameters struct {
+	// Alias filters the keys by their key set.
+	//
+	// in: query
+	Alias string `json:"alias"`
+}
+
+// List of Session Signing Keys
+//
+// swagger:response listSessionSigningKeys
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type listSessionSigningKeysResponse struct {
+	// in: body
+	Body []SigningKey
+}
+
+// swagger:route GET /admin/session-keys identity listSessionSigningKeys
+//
+// # List Session Signing Keys
+//
+// Lists the managed keys which sign tokenized sessions, including retired and revoked keys, most recent
+// first. Private keys are never returned.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: listSessionSigningKeys
+//	  default: errorGeneric
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	keys, err := h.r.SigningKeyPersister().ListSigningKeys(r.Context(), r.URL.Query().Get("alias"))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, keys)
+}
+
+// Rotate Session Signing Keys Parameters
+//
+// swagger:parameters rotateSessionSigningKeys
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type rotateSessionSigningKeys struct {
+	// Alias is the alias of the key set.
+	//
+	// required: true
+	// in: path
+	Alias string `json:"alias"`
+}
+
+// swagger:route POST /admin/session-key-sets/{alias}/rotate identity rotateSessionSigningKeys
+//
+// # Rotate Session Signing Keys
+//
+// Activates the next key of the key set, retires the active key, and generates a new next key. The key set
+// is created if it does not exist yet.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: listSessionSigningKeys
+//	  400: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) rotate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	keys, err := h.r.SigningKeyManager().Rotate(r.Context(), ps.ByName("alias"))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, keys)
+}
+
+// Revoke Session Signing Key Parameters
+//
+// swagger:parameters revokeSessionSigningKey
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type revokeSessionSigningKey struct {
+	// ID is the key's ID.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route DELETE /admin/session-keys/{id} identity revokeSessionSigningKey
+//
+// # Revoke a Session Signing Key
+//
+// Removes the key from the public key set immediately, so that tokens it signed no longer verify. If the key
+// was active, the next key is activated.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: sessionSigningKey
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	k, err := h.r.SigningKeyManager().Revoke(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, k)
+}
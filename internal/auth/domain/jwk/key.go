@@ -0,0 +1,183 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package jwk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	josejwk "github.com/lestrrat-go/jwx/v2/jwk"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/sqlxx"
+)
+
+// rsaKeySize is the size in bits of generated RS256 keys.
+const rsaKeySize = 3072
+
+// A Signing Key's State
+//
+// swagger:model sessionSigningKeyState
+type State string
+
+const (
+	// StateNext keys are published but do not sign tokens yet, so that verifiers know them before they are
+	// activated.
+	StateNext State = "next"
+
+	// StateActive keys sign tokens. Every key set has one active key.
+	StateActive State = "active"
+
+	// StateRetired keys were rotated and are published until the rotation overlap has passed.
+	StateRetired State = "retired"
+
+	// StateRevoked keys are no longer published.
+	StateRevoked State = "revoked"
+)
+
+// SigningKey is a key of a managed key set which signs tokenized sessions.
+//
+// swagger:model sessionSigningKey
+type SigningKey struct {
+	// ID is the key's ID, which is also used as the "kid" of the JSON Web Key.
+	//
+	// required: true
+	ID uuid.UUID `json:"id" faker:"-" db:"id"`
+
+	NID uuid.UUID `json:"-" faker:"-" db:"nid"`
+
+	// Alias is the name of the key set the key belongs to. Tokenizer templates reference key sets by alias.
+	//
+	// required: true
+	Alias string `json:"alias" db:"alias"`
+
+	// Algorithm is the key's signing algorithm, for example ES256.
+	//
+	// required: true
+	Algorithm string `json:"algorithm" db:"algorithm"`
+
+	// State is the key's state in the rotation.
+	//
+	// required: true
+	State State `json:"state" db:"state"`
+
+	// PublicKey is the public JSON Web Key.
+	//
+	// required: true
+	PublicKey sqlxx.JSONRawMessage `json:"public_key" faker:"-" db:"public_key"`
+
+	// PrivateKey is the encrypted private JSON Web Key.
+	PrivateKey string `json:"-" faker:"-" db:"private_key"`
+
+	// ActivatedAt is the time the key started signing tokens.
+	ActivatedAt sqlxx.NullTime `json:"activated_at,omitempty" faker:"-" db:"activated_at"`
+
+	// RetiredAt is the time the key was replaced by the next key.
+	RetiredAt sqlxx.NullTime `json:"retired_at,omitempty" faker:"-" db:"retired_at"`
+
+	// RevokedAt is the time the key was revoked.
+	RevokedAt sqlxx.NullTime `json:"revoked_at,omitempty" faker:"-" db:"revoked_at"`
+
+	// CreatedAt is a helper struct field for gobuffalo.pop.
+	//
+	// required: true
+	CreatedAt time.Time `json:"created_at" faker:"-" db:"created_at"`
+
+	// UpdatedAt is a helper struct field for gobuffalo.pop.
+	//
+	// required: true
+	UpdatedAt time.Time `json:"updated_at" faker:"-" db:"updated_at"`
+}
+
+func (k SigningKey) TableName(ctx context.Context) string {
+	return "session_signing_keys"
+}
+
+func (k *SigningKey) GetID() uuid.UUID {
+	return k.ID
+}
+
+func (k *SigningKey) GetNID() uuid.UUID {
+	return k.NID
+}
+
+// IsPublished returns true if the key is part of the public key set. Retired keys are published until the
+// overlap has passed.
+func (k *SigningKey) IsPublished(now time.Time, overlap time.Duration) bool {
+	switch k.State {
+	case StateNext, StateActive:
+		return true
+	case StateRetired:
+		return time.Time(k.RetiredAt).Add(overlap).After(now)
+	}
+	return false
+}
+
+func (k *SigningKey) activate(now time.Time) {
+	k.State = StateActive
+	k.ActivatedAt = sqlxx.NullTime(now)
+}
+
+func (k *SigningKey) retire(now time.Time) {
+	k.State = StateRetired
+	k.RetiredAt = sqlxx.NullTime(now)
+}
+
+func (k *SigningKey) revoke(now time.Time) {
+	k.State = StateRevoked
+	k.RevokedAt = sqlxx.NullTime(now)
+}
+
+// generateKey generates a private JSON Web Key for the algorithm.
+func generateKey(id uuid.UUID, algorithm string) (josejwk.Key, error) {
+	var raw interface{}
+	var err error
+	switch algorithm {
+	case "ES256":
+		raw, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		raw, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	default:
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReasonf("The signing algorithm %q is not supported. Use one of ES256, EdDSA, or RS256.", algorithm))
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	key, err := josejwk.FromRaw(raw)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for k, v := range map[string]interface{}{
+		josejwk.KeyIDKey:     id.String(),
+		josejwk.AlgorithmKey: algorithm,
+		josejwk.KeyUsageKey:  string(josejwk.ForSignature),
+	} {
+		if err := key.Set(k, v); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return key, nil
+}
+
+// publicKey returns the encoded public JSON Web Key of the private key.
+func publicKey(key josejwk.Key) ([]byte, error) {
+	public, err := josejwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	raw, err := json.Marshal(public)
+	return raw, errors.WithStack(err)
+}
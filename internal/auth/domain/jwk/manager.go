@@ -0,0 +1,486 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package jwk
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	josejwk "github.com/lestrrat-go/jwx/v2/jwk"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9-_.]+$`)
+
+type (
+	managerDependencies interface {
+		PersistenceProvider
+		cipher.Provider
+		config.Provider
+		x.LoggingProvider
+		x.TracingProvider
+	}
+	ManagementProvider interface {
+		SigningKeyManager() *Manager
+	}
+
+	// Manager generates, rotates, and revokes the keys of managed key sets. Every key set has an active key,
+	// which signs tokens, and a next key, which is published ahead of its activation so that verifiers which
+	// cache the public key set know it once it is rotated in.
+	//
+	// The decrypted active keys are cached for the configured TTL. Rotating or revoking a key set drops its
+	// cached key on this instance, other instances pick up the change once their entry expires.
+	Manager struct {
+		r       managerDependencies
+		nowFunc func() time.Time
+
+		sync.Mutex
+		active     map[string]*cachedSigningKey
+		generation uint64
+	}
+
+	cachedSigningKey struct {
+		key       josejwk.Key
+		expiresAt time.Time
+	}
+)
+
+func NewManager(r managerDependencies) *Manager {
+	return &Manager{r: r, nowFunc: time.Now, active: map[string]*cachedSigningKey{}}
+}
+
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return errors.WithStack(herodot.ErrBadRequest.WithReasonf("The key set alias %q is invalid. It may only contain letters, digits, dashes, underscores, and dots.", alias))
+	}
+	return nil
+}
+
+// EnsureKeySet generates the active and next key of the key set if they are missing and returns the key set's
+// keys.
+func (m *Manager) EnsureKeySet(ctx context.Context, alias string) (_ []SigningKey, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.EnsureKeySet")
+	defer otelx.End(span, &err)
+
+	if err := validateAlias(alias); err != nil {
+		return nil, err
+	}
+
+	return m.r.SigningKeyPersister().UpdateSigningKeys(ctx, alias, func(keys []SigningKey) (created, updated []*SigningKey, err error) {
+		return m.complete(ctx, alias, keys, m.nowFunc().UTC())
+	})
+}
+
+// Rotate retires the active key of the key set, activates the next key, and generates a new next key. Retired
+// keys are published until the configured overlap has passed.
+func (m *Manager) Rotate(ctx context.Context, alias string) (_ []SigningKey, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.Rotate")
+	defer otelx.End(span, &err)
+
+	if err := validateAlias(alias); err != nil {
+		return nil, err
+	}
+
+	keys, _, err := m.rotate(ctx, alias, func(*SigningKey, time.Time) bool { return true })
+	return keys, err
+}
+
+// rotate rotates the key set if due returns true for its active key, which is nil if the key set has none. The
+// key set is read again while it is locked, so that an instance does not rotate a key set which another instance
+// has just rotated.
+func (m *Manager) rotate(ctx context.Context, alias string, due func(active *SigningKey, now time.Time) bool) (_ []SigningKey, rotated bool, err error) {
+	keys, err := m.r.SigningKeyPersister().UpdateSigningKeys(ctx, alias, func(keys []SigningKey) (created, updated []*SigningKey, err error) {
+		now := m.nowFunc().UTC()
+		rotated = false
+		if !due(findActive(keys), now) {
+			return nil, nil, nil
+		}
+
+		var retired []*SigningKey
+		for i := range keys {
+			if keys[i].State == StateActive {
+				keys[i].retire(now)
+				retired = append(retired, &keys[i])
+			}
+		}
+
+		created, updated, err = m.complete(ctx, alias, keys, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		rotated = true
+		return created, append(retired, updated...), nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if rotated {
+		m.invalidate(alias)
+		m.r.Audit().
+			WithField("alias", alias).
+			Info("The signing keys of a key set were rotated.")
+	}
+	return keys, rotated, nil
+}
+
+// Revoke removes the key from the public key set immediately. If the key was active, the next key is
+// activated.
+func (m *Manager) Revoke(ctx context.Context, id uuid.UUID) (_ *SigningKey, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.Revoke")
+	defer otelx.End(span, &err)
+
+	key, err := m.r.SigningKeyPersister().GetSigningKey(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if key.State == StateRevoked {
+		return key, nil
+	}
+
+	var revoked *SigningKey
+	var changed bool
+	if _, err := m.r.SigningKeyPersister().UpdateSigningKeys(ctx, key.Alias, func(keys []SigningKey) (created, updated []*SigningKey, err error) {
+		now := m.nowFunc().UTC()
+		revoked, changed = nil, false
+		remaining := make([]SigningKey, 0, len(keys))
+		for i := range keys {
+			if keys[i].ID == id {
+				revoked = &keys[i]
+			} else {
+				remaining = append(remaining, keys[i])
+			}
+		}
+		if revoked == nil {
+			return nil, nil, errors.WithStack(sqlcon.ErrNoRows)
+		} else if revoked.State == StateRevoked {
+			return nil, nil, nil
+		}
+		revoked.revoke(now)
+
+		created, updated, err = m.complete(ctx, key.Alias, remaining, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = true
+		return created, append([]*SigningKey{revoked}, updated...), nil
+	}); err != nil {
+		return nil, err
+	} else if !changed {
+		return revoked, nil
+	}
+
+	m.invalidate(revoked.Alias)
+	m.r.Audit().
+		WithField("alias", revoked.Alias).
+		WithField("key_id", revoked.ID).
+		Info("A signing key was revoked.")
+	return revoked, nil
+}
+
+// complete returns the keys which need to be created or updated so that the key set has an active and a next
+// key. If the active key is missing, the next key is activated.
+func (m *Manager) complete(ctx context.Context, alias string, keys []SigningKey, now time.Time) (created, updated []*SigningKey, err error) {
+	var active, next []*SigningKey
+	for i := range keys {
+		switch keys[i].State {
+		case StateActive:
+			active = append(active, &keys[i])
+		case StateNext:
+			next = append(next, &keys[i])
+		}
+	}
+
+	if len(active) == 0 {
+		if len(next) > 0 {
+			// Keys are listed most recent first, so the oldest next key has been published the longest.
+			promoted := next[len(next)-1]
+			promoted.activate(now)
+			updated = append(updated, promoted)
+			next = next[:len(next)-1]
+		} else {
+			k, err := m.newKey(ctx, alias)
+			if err != nil {
+				return nil, nil, err
+			}
+			k.activate(now)
+			created = append(created, k)
+		}
+	}
+
+	if len(next) == 0 {
+		k, err := m.newKey(ctx, alias)
+		if err != nil {
+			return nil, nil, err
+		}
+		created = append(created, k)
+	}
+
+	return created, updated, nil
+}
+
+// newKey generates a next key with the configured algorithm and encrypts its private key.
+func (m *Manager) newKey(ctx context.Context, alias string) (*SigningKey, error) {
+	id := x.NewUUID()
+	algorithm := m.r.Config().SessionTokenizerKeyAlgorithm(ctx)
+
+	key, err := generateKey(id, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := json.Marshal(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	encrypted, err := m.r.Cipher(ctx).Encrypt(ctx, private)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := publicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         id,
+		Alias:      alias,
+		Algorithm:  algorithm,
+		State:      StateNext,
+		PublicKey:  public,
+		PrivateKey: encrypted,
+	}, nil
+}
+
+// SigningKey returns the private key of the key set's active key. The key set is generated on first use.
+func (m *Manager) SigningKey(ctx context.Context, alias string) (_ josejwk.Key, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.SigningKey")
+	defer otelx.End(span, &err)
+
+	if key, ok := m.cached(alias); ok {
+		return key, nil
+	}
+
+	// A key set which is rotated while its key is loaded must not be cached with the retired key.
+	m.Lock()
+	generation := m.generation
+	m.Unlock()
+
+	keys, err := m.r.SigningKeyPersister().ListSigningKeys(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	active := findActive(keys)
+	if active == nil {
+		if keys, err = m.EnsureKeySet(ctx, alias); err != nil {
+			return nil, err
+		}
+		if active = findActive(keys); active == nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("The key set %q has no active key.", alias))
+		}
+	}
+
+	private, err := m.r.Cipher(ctx).Decrypt(ctx, active.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := josejwk.ParseKey(private)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to decode the signing key %s.", active.ID))
+	}
+
+	if ttl := m.r.Config().SessionTokenizerKeyCacheTTL(ctx); ttl > 0 {
+		m.Lock()
+		if m.generation == generation {
+			m.active[alias] = &cachedSigningKey{key: key, expiresAt: m.nowFunc().Add(ttl)}
+		}
+		m.Unlock()
+	}
+	return key, nil
+}
+
+func (m *Manager) cached(alias string) (josejwk.Key, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.active[alias]
+	if !ok {
+		return nil, false
+	} else if !entry.expiresAt.After(m.nowFunc()) {
+		delete(m.active, alias)
+		return nil, false
+	}
+	return entry.key, true
+}
+
+// invalidate drops the cached active key of the key set after it was rotated or revoked.
+func (m *Manager) invalidate(alias string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.generation++
+	delete(m.active, alias)
+}
+
+// findActive returns the most recently activated active key.
+func findActive(keys []SigningKey) *SigningKey {
+	var active *SigningKey
+	for i := range keys {
+		if keys[i].State == StateActive && (active == nil || time.Time(keys[i].ActivatedAt).After(time.Time(active.ActivatedAt))) {
+			active = &keys[i]
+		}
+	}
+	return active
+}
+
+// PublicKeySet returns the public keys of all key sets which verifiers should accept: the active and next keys
+// and the keys which were retired within the rotation overlap.
+func (m *Manager) PublicKeySet(ctx context.Context) (_ josejwk.Set, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.PublicKeySet")
+	defer otelx.End(span, &err)
+
+	retiredAfter := m.nowFunc().UTC().Add(-m.r.Config().SessionTokenizerKeyRotationOverlap(ctx))
+	keys, err := m.r.SigningKeyPersister().ListPublishedSigningKeys(ctx, retiredAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	set := josejwk.NewSet()
+	for _, k := range keys {
+		key, err := josejwk.ParseKey(k.PublicKey)
+		if err != nil {
+			return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to decode the public key %s.", k.ID))
+		}
+		if err := set.AddKey(key); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return set, nil
+}
+
+// RotateDueKeySets generates the key sets referenced by tokenizer templates and rotates the key sets whose
+// active key has been active for longer than the rotation interval. It returns the number of rotated key sets.
+func (m *Manager) RotateDueKeySets(ctx context.Context) (_ int, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.RotateDueKeySets")
+	defer otelx.End(span, &err)
+
+	for _, alias := range m.r.Config().SessionTokenizerKeyAliases(ctx) {
+		if _, err := m.EnsureKeySet(ctx, alias); err != nil {
+			return 0, err
+		}
+	}
+
+	interval := m.r.Config().SessionTokenizerKeyRotationInterval(ctx)
+	if interval <= 0 {
+		return 0, nil
+	}
+
+	keys, err := m.r.SigningKeyPersister().ListSigningKeys(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	due := func(active *SigningKey, now time.Time) bool {
+		return !time.Time(active.ActivatedAt).Add(interval).After(now)
+	}
+
+	now := m.nowFunc().UTC()
+	candidates := map[string]bool{}
+	for _, k := range keys {
+		if k.State == StateActive && due(&k, now) {
+			candidates[k.Alias] = true
+		}
+	}
+
+	var count int
+	for alias := range candidates {
+		_, rotated, err := m.rotate(ctx, alias, func(active *SigningKey, now time.Time) bool {
+			// Another instance may have rotated the key set since it was listed.
+			return active != nil && due(active, now)
+		})
+		if err != nil {
+			return count, err
+		} else if rotated {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// RotateCipherSecrets encrypts the private keys of all key sets which were not encrypted with the current cipher
+// secret again. It returns the number of re-encrypted keys and, in a dry run or if a key could not be decrypted,
+// the number of keys which remain encrypted with an older secret.
+func (m *Manager) RotateCipherSecrets(ctx context.Context, dryRun bool) (reencrypted, remaining int, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "jwk.Manager.RotateCipherSecrets")
+	defer otelx.End(span, &err)
+
+	crypter := m.r.Cipher(ctx)
+	if _, err := m.r.SigningKeyPersister().UpdateSigningKeys(ctx, "", func(keys []SigningKey) (_, updated []*SigningKey, err error) {
+		reencrypted, remaining = 0, 0
+		for i := range keys {
+			if !crypter.NeedsReencryption(ctx, keys[i].PrivateKey) {
+				continue
+			} else if dryRun {
+				remaining++
+				continue
+			}
+
+			private, err := crypter.Decrypt(ctx, keys[i].PrivateKey)
+			if err != nil {
+				m.r.Logger().WithError(err).WithField("key_id", keys[i].ID).Warn("Unable to decrypt a signing key, skipping it.")
+				remaining++
+				continue
+			}
+
+			if keys[i].PrivateKey, err = crypter.Encrypt(ctx, private); err != nil {
+				return nil, nil, err
+			}
+			updated = append(updated, &keys[i])
+		}
+		reencrypted = len(updated)
+		return nil, updated, nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	return reencrypted, remaining, nil
+}
+
+// WatchRotation periodically rotates due key sets until the context is cancelled.
+func (m *Manager) WatchRotation(ctx context.Context) error {
+	ticker := time.NewTicker(m.r.Config().SessionTokenizerKeyRotationCheckInterval(ctx))
+	defer ticker.Stop()
+
+	for {
+		if count, err := m.RotateDueKeySets(ctx); err != nil {
+			m.r.Logger().WithError(err).Error("Unable to rotate signing keys.")
+		} else if count > 0 {
+			m.r.Logger().WithField("count", count).Info("Rotated signing keys.")
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
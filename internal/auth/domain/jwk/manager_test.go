@@ -0,0 +1,447 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package jwk
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	josejwk "github.com/lestrrat-go/jwx/v2/jwk"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/configx"
+	"github.com/ory/x/logrusx"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/cipher"
+	"my.com/secrets/internal/auth/domain/driver/config"
+)
+
+type (
+	testDeps struct {
+		c      *config.Config
+		p      *testPersister
+		cipher cipher.Cipher
+	}
+
+	testPersister struct {
+		sync.Mutex
+		keys map[uuid.UUID]SigningKey
+		seq  int
+	}
+)
+
+func (d *testDeps) Config() *config.Config         { return d.c }
+func (d *testDeps) Logger() *logrusx.Logger        { return logrusx.New("", "") }
+func (d *testDeps) Audit() *logrusx.Logger         { return logrusx.New("", "") }
+func (d *testDeps) SigningKeyPersister() Persister { return d.p }
+func (d *testDeps) Cipher(context.Context) cipher.Cipher {
+	if d.cipher != nil {
+		return d.cipher
+	}
+	return cipher.NewNoop(d)
+}
+func (d *testDeps) Tracer(context.Context) *otelx.Tracer {
+	return otelx.NewNoop(logrusx.New("", ""), new(otelx.Config))
+}
+
+func (p *testPersister) SaveSigningKeys(_ context.Context, created []*SigningKey, updated []*SigningKey) error {
+	p.Lock()
+	defer p.Unlock()
+	return p.save(created, updated)
+}
+
+func (p *testPersister) save(created []*SigningKey, updated []*SigningKey) error {
+	for _, k := range created {
+		// Keys created in the same transaction must still be ordered deterministically.
+		p.seq++
+		k.CreatedAt = time.Unix(int64(p.seq), 0)
+		p.keys[k.ID] = *k
+	}
+	for _, k := range updated {
+		if _, ok := p.keys[k.ID]; !ok {
+			return sqlcon.ErrNoRows
+		}
+		p.keys[k.ID] = *k
+	}
+	return nil
+}
+
+func (p *testPersister) UpdateSigningKeys(_ context.Context, alias string, update func(keys []SigningKey) ([]*SigningKey, []*SigningKey, error)) ([]SigningKey, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	created, updated, err := update(p.list(alias))
+	if err != nil {
+		return nil, err
+	}
+	if err := p.save(created, updated); err != nil {
+		return nil, err
+	}
+	return p.list(alias), nil
+}
+
+func (p *testPersister) GetSigningKey(_ context.Context, id uuid.UUID) (*SigningKey, error) {
+	p.Lock()
+	defer p.Unlock()
+	k, ok := p.keys[id]
+	if !ok {
+		return nil, sqlcon.ErrNoRows
+	}
+	return &k, nil
+}
+
+func (p *testPersister) ListSigningKeys(_ context.Context, alias string) ([]SigningKey, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.list(alias), nil
+}
+
+func (p *testPersister) list(alias string) []SigningKey {
+	keys := []SigningKey{}
+	for _, k := range p.keys {
+		if alias == "" || k.Alias == alias {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys
+}
+
+func (p *testPersister) ListPublishedSigningKeys(_ context.Context, retiredAfter time.Time) ([]SigningKey, error) {
+	all, _ := p.ListSigningKeys(context.Background(), "")
+	keys := []SigningKey{}
+	for _, k := range all {
+		if k.State == StateActive || k.State == StateNext || (k.State == StateRetired && time.Time(k.RetiredAt).After(retiredAfter)) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func byState(keys []SigningKey) map[State][]SigningKey {
+	result := map[State][]SigningKey{}
+	for _, k := range keys {
+		result[k.State] = append(result[k.State], k)
+	}
+	return result
+}
+
+func keyIDs(t *testing.T, set josejwk.Set) []string {
+	ids := make([]string, 0, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		key, ok := set.Key(i)
+		require.True(t, ok)
+		ids = append(ids, key.KeyID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+
+	now := time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC)
+	newManager := func() (*Manager, *testDeps) {
+		d := &testDeps{c: conf, p: &testPersister{keys: map[uuid.UUID]SigningKey{}}}
+		m := NewManager(d)
+		m.nowFunc = func() time.Time { return now }
+		return m, d
+	}
+
+	t.Run("case=ensure generates the active and next key", func(t *testing.T) {
+		m, _ := newManager()
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+
+		states := byState(keys)
+		require.Len(t, states[StateActive], 1)
+		require.Len(t, states[StateNext], 1)
+		assert.Equal(t, now, time.Time(states[StateActive][0].ActivatedAt))
+		assert.Equal(t, "ES256", states[StateActive][0].Algorithm)
+
+		again, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, keys, again)
+	})
+
+	t.Run("case=invalid alias", func(t *testing.T) {
+		m, _ := newManager()
+		_, err := m.EnsureKeySet(ctx, "not/valid")
+		require.ErrorIs(t, err, herodot.ErrBadRequest)
+	})
+
+	for _, algorithm := range []string{"ES256", "EdDSA", "RS256"} {
+		t.Run("case=signs and verifies with "+algorithm, func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionTokenizerKeysAlgorithm, algorithm)
+			t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionTokenizerKeysAlgorithm, "ES256") })
+
+			m, _ := newManager()
+			key, err := m.SigningKey(ctx, "default")
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, key.Algorithm().String())
+
+			var private interface{}
+			require.NoError(t, key.Raw(&private))
+			token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), jwt.MapClaims{"sub": "foo"})
+			token.Header["kid"] = key.KeyID()
+			signed, err := token.SignedString(private)
+			require.NoError(t, err)
+
+			set, err := m.PublicKeySet(ctx)
+			require.NoError(t, err)
+			_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+				public, ok := set.LookupKeyID(token.Header["kid"].(string))
+				require.True(t, ok)
+				var raw interface{}
+				return raw, public.Raw(&raw)
+			})
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("case=private keys are encrypted", func(t *testing.T) {
+		m, d := newManager()
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+
+		for _, k := range keys {
+			private, err := d.Cipher(ctx).Decrypt(ctx, d.p.keys[k.ID].PrivateKey)
+			require.NoError(t, err)
+			assert.NotEqual(t, string(private), d.p.keys[k.ID].PrivateKey)
+			assert.True(t, gjson.GetBytes(private, "d").Exists())
+			assert.False(t, gjson.GetBytes(k.PublicKey, "d").Exists())
+		}
+	})
+
+	t.Run("case=private keys are encrypted with the current cipher secret", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{"old-secret-thirty-two-characters"})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySecretsCipher, nil) })
+
+		m, d := newManager()
+		d.cipher = cipher.NewCryptChaCha20(d)
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{"new-secret-thirty-two-characters", "old-secret-thirty-two-characters"})
+		reencrypted, remaining, err := m.RotateCipherSecrets(ctx, true)
+		require.NoError(t, err)
+		assert.Zero(t, reencrypted)
+		assert.Equal(t, len(keys), remaining)
+
+		reencrypted, remaining, err = m.RotateCipherSecrets(ctx, false)
+		require.NoError(t, err)
+		assert.Equal(t, len(keys), reencrypted)
+		assert.Zero(t, remaining)
+
+		conf.MustSet(ctx, config.ViperKeySecretsCipher, []string{"new-secret-thirty-two-characters"})
+		for _, k := range keys {
+			assert.Equal(t, k.State, d.p.keys[k.ID].State)
+			_, err := d.Cipher(ctx).Decrypt(ctx, d.p.keys[k.ID].PrivateKey)
+			require.NoError(t, err)
+		}
+		_, err = m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+	})
+
+	t.Run("case=rotation publishes the retired key for the overlap", func(t *testing.T) {
+		m, _ := newManager()
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+		initial := byState(keys)
+		active, next := initial[StateActive][0], initial[StateNext][0]
+
+		keys, err = m.Rotate(ctx, "default")
+		require.NoError(t, err)
+		rotated := byState(keys)
+		require.Len(t, rotated[StateActive], 1)
+		require.Len(t, rotated[StateNext], 1)
+		require.Len(t, rotated[StateRetired], 1)
+		assert.Equal(t, next.ID, rotated[StateActive][0].ID, "the next key must be activated")
+		assert.Equal(t, active.ID, rotated[StateRetired][0].ID)
+		assert.NotEqual(t, next.ID, rotated[StateNext][0].ID)
+
+		set, err := m.PublicKeySet(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, set.Len())
+		assert.Contains(t, keyIDs(t, set), active.ID.String())
+
+		m.nowFunc = func() time.Time { return now.Add(conf.SessionTokenizerKeyRotationOverlap(ctx) + time.Second) }
+		set, err = m.PublicKeySet(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, set.Len())
+		assert.NotContains(t, keyIDs(t, set), active.ID.String())
+	})
+
+	t.Run("case=revoking the active key activates the next key", func(t *testing.T) {
+		m, _ := newManager()
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+		initial := byState(keys)
+		active, next := initial[StateActive][0], initial[StateNext][0]
+
+		revoked, err := m.Revoke(ctx, active.ID)
+		require.NoError(t, err)
+		assert.Equal(t, StateRevoked, revoked.State)
+		assert.Equal(t, now, time.Time(revoked.RevokedAt))
+
+		key, err := m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, next.ID.String(), key.KeyID())
+
+		set, err := m.PublicKeySet(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, keyIDs(t, set), active.ID.String())
+		assert.Equal(t, 2, set.Len(), "a new next key must be generated")
+
+		again, err := m.Revoke(ctx, active.ID)
+		require.NoError(t, err)
+		assert.Equal(t, revoked.ID, again.ID)
+	})
+
+	t.Run("case=caches the active key until the key set is rotated or revoked", func(t *testing.T) {
+		m, d := newManager()
+		keys, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+		active := byState(keys)[StateActive][0]
+
+		key, err := m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, active.ID.String(), key.KeyID())
+
+		// Removing the keys without the manager shows that the active key is cached.
+		d.p.Lock()
+		stored := d.p.keys
+		d.p.keys = map[uuid.UUID]SigningKey{}
+		d.p.Unlock()
+		cached, err := m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, active.ID.String(), cached.KeyID())
+
+		m.nowFunc = func() time.Time { return now.Add(conf.SessionTokenizerKeyCacheTTL(ctx) + time.Second) }
+		t.Cleanup(func() { m.nowFunc = func() time.Time { return now } })
+		reloaded, err := m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.NotEqual(t, active.ID.String(), reloaded.KeyID(), "the cached key expires after the TTL")
+
+		d.p.Lock()
+		d.p.keys = stored
+		d.p.Unlock()
+		m.nowFunc = func() time.Time { return now }
+		m.invalidate("default")
+		key, err = m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		require.Equal(t, active.ID.String(), key.KeyID())
+
+		keys, err = m.Rotate(ctx, "default")
+		require.NoError(t, err)
+		rotated := byState(keys)[StateActive][0]
+		key, err = m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.Equal(t, rotated.ID.String(), key.KeyID(), "rotating the key set drops the cached key")
+
+		_, err = m.Revoke(ctx, rotated.ID)
+		require.NoError(t, err)
+		key, err = m.SigningKey(ctx, "default")
+		require.NoError(t, err)
+		assert.NotEqual(t, rotated.ID.String(), key.KeyID(), "revoking the active key drops the cached key")
+	})
+
+	t.Run("case=revoking an unknown key", func(t *testing.T) {
+		m, _ := newManager()
+		_, err := m.Revoke(ctx, uuid.Must(uuid.NewV4()))
+		require.ErrorIs(t, err, sqlcon.ErrNoRows)
+	})
+
+	t.Run("case=rotates due key sets", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionTokenizerTemplates+".template", &config.SessionTokenizeFormat{TTL: time.Minute, KeyAlias: "templated"})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionTokenizerTemplates, nil) })
+
+		m, d := newManager()
+		_, err := m.EnsureKeySet(ctx, "manual")
+		require.NoError(t, err)
+
+		count, err := m.RotateDueKeySets(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		templated, err := d.p.ListSigningKeys(ctx, "templated")
+		require.NoError(t, err)
+		assert.Len(t, templated, 2, "key sets referenced by templates are generated")
+
+		m.nowFunc = func() time.Time { return now.Add(conf.SessionTokenizerKeyRotationInterval(ctx)) }
+		count, err = m.RotateDueKeySets(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		conf.MustSet(ctx, config.ViperKeySessionTokenizerKeysRotationInterval, "0s")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionTokenizerKeysRotationInterval, "720h") })
+		m.nowFunc = func() time.Time { return now.Add(10 * conf.SessionTokenizerKeyRotationInterval(ctx)) }
+		count, err = m.RotateDueKeySets(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("case=concurrent rotations of a due key set rotate it once", func(t *testing.T) {
+		m, d := newManager()
+		_, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+
+		later := func() time.Time { return now.Add(conf.SessionTokenizerKeyRotationInterval(ctx)) }
+		var wg sync.WaitGroup
+		counts := make([]int, 4)
+		for k := range counts {
+			other := NewManager(d)
+			other.nowFunc = later
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				count, err := other.RotateDueKeySets(ctx)
+				assert.NoError(t, err)
+				counts[k] = count
+			}(k)
+		}
+		wg.Wait()
+
+		var total int
+		for _, count := range counts {
+			total += count
+		}
+		assert.Equal(t, 1, total)
+
+		keys, err := d.p.ListSigningKeys(ctx, "default")
+		require.NoError(t, err)
+		states := byState(keys)
+		assert.Len(t, states[StateActive], 1)
+		assert.Len(t, states[StateNext], 1)
+		assert.Len(t, states[StateRetired], 1)
+	})
+
+	t.Run("case=public key set does not contain private keys", func(t *testing.T) {
+		m, _ := newManager()
+		_, err := m.EnsureKeySet(ctx, "default")
+		require.NoError(t, err)
+
+		set, err := m.PublicKeySet(ctx)
+		require.NoError(t, err)
+		raw, err := json.Marshal(set)
+		require.NoError(t, err)
+
+		assert.Len(t, gjson.GetBytes(raw, "keys").Array(), 2)
+		assert.False(t, gjson.GetBytes(raw, "keys.#.d").Get("0").Exists(), "%s", raw)
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package jwk
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type (
+	Persister interface {
+		// SaveSigningKeys creates the new keys and updates the state and the encrypted private key of the existing
+		// keys in one transaction.
+		SaveSigningKeys(ctx context.Context, created []*SigningKey, updated []*SigningKey) error
+
+		// UpdateSigningKeys reads the keys of the key set with the given alias, or of all key sets if the alias
+		// is empty, passes them to the update function, and saves the keys which it returns in one transaction.
+		// Concurrent updates are serialized, so that instances which rotate a key set at the same time do not
+		// generate conflicting keys. It returns the keys of the key set after the update.
+		UpdateSigningKeys(ctx context.Context, alias string, update func(keys []SigningKey) (created []*SigningKey, updated []*SigningKey, err error)) ([]SigningKey, error)
+
+		// GetSigningKey returns the key with the given ID or an error if it could not be found.
+		GetSigningKey(ctx context.Context, id uuid.UUID) (*SigningKey, error)
+
+		// ListSigningKeys lists the keys of the key set with the given alias, or of all key sets if the alias is
+		// empty, most recent first.
+		ListSigningKeys(ctx context.Context, alias string) ([]SigningKey, error)
+
+		// ListPublishedSigningKeys lists the next and active keys of all key sets and the keys which were retired
+		// after the given time.
+		ListPublishedSigningKeys(ctx context.Context, retiredAfter time.Time) ([]SigningKey, error)
+	}
+	PersistenceProvider interface {
+		SigningKeyPersister() Persister
+	}
+)
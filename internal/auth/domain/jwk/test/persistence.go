@@ -0,0 +1,151 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/sqlxx"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/jwk"
+	"my.com/secrets/internal/auth/domain/persistence"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestPersister(ctx context.Context, p persistence.Persister) func(t *testing.T) {
+	newKey := func(alias string, state jwk.State) *jwk.SigningKey {
+		return &jwk.SigningKey{
+			ID:         x.NewUUID(),
+			Alias:      alias,
+			Algorithm:  "ES256",
+			State:      state,
+			PublicKey:  sqlxx.JSONRawMessage(`{"kty":"EC"}`),
+			PrivateKey: "encrypted",
+		}
+	}
+
+	return func(t *testing.T) {
+		nid, p := testhelpers.NewNetworkUnlessExisting(t, ctx, p)
+
+		t.Run("case=not found", func(t *testing.T) {
+			_, err := p.GetSigningKey(ctx, x.NewUUID())
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=save, get, and list", func(t *testing.T) {
+			now := time.Now().UTC().Truncate(time.Second)
+			active, next := newKey("default", jwk.StateActive), newKey("default", jwk.StateNext)
+			active.ActivatedAt = sqlxx.NullTime(now)
+			require.NoError(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{active, next}, nil))
+			assert.Equal(t, nid, active.NID)
+
+			actual, err := p.GetSigningKey(ctx, active.ID)
+			require.NoError(t, err)
+			assert.Equal(t, jwk.StateActive, actual.State)
+			assert.Equal(t, "encrypted", actual.PrivateKey)
+			assert.JSONEq(t, `{"kty":"EC"}`, string(actual.PublicKey))
+			assert.Equal(t, now, time.Time(actual.ActivatedAt).UTC())
+
+			require.NoError(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{newKey("other", jwk.StateActive)}, nil))
+			keys, err := p.ListSigningKeys(ctx, "default")
+			require.NoError(t, err)
+			assert.Len(t, keys, 2)
+
+			keys, err = p.ListSigningKeys(ctx, "")
+			require.NoError(t, err)
+			assert.Len(t, keys, 3)
+		})
+
+		t.Run("case=updates the state and lists published keys", func(t *testing.T) {
+			now := time.Now().UTC()
+			retired, revoked, next := newKey("rotated", jwk.StateActive), newKey("rotated", jwk.StateActive), newKey("rotated", jwk.StateNext)
+			require.NoError(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{retired, revoked}, nil))
+
+			retired.State, retired.RetiredAt = jwk.StateRetired, sqlxx.NullTime(now)
+			revoked.State, revoked.RevokedAt = jwk.StateRevoked, sqlxx.NullTime(now)
+			require.NoError(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{next}, []*jwk.SigningKey{retired, revoked}))
+
+			ids := func(keys []jwk.SigningKey) (result []string) {
+				for _, k := range keys {
+					if k.Alias == "rotated" {
+						result = append(result, k.ID.String())
+					}
+				}
+				return result
+			}
+
+			published, err := p.ListPublishedSigningKeys(ctx, now.Add(-time.Hour))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{retired.ID.String(), next.ID.String()}, ids(published))
+
+			published, err = p.ListPublishedSigningKeys(ctx, now.Add(time.Hour))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{next.ID.String()}, ids(published))
+		})
+
+		t.Run("case=updating an unknown key rolls back", func(t *testing.T) {
+			created, unknown := newKey("rollback", jwk.StateActive), newKey("rollback", jwk.StateNext)
+			require.ErrorIs(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{created}, []*jwk.SigningKey{unknown}), sqlcon.ErrNoRows)
+
+			keys, err := p.ListSigningKeys(ctx, "rollback")
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+
+		t.Run("case=update saves the returned keys", func(t *testing.T) {
+			var seen []jwk.SigningKey
+			next := newKey("updated", jwk.StateNext)
+			keys, err := p.UpdateSigningKeys(ctx, "updated", func(keys []jwk.SigningKey) ([]*jwk.SigningKey, []*jwk.SigningKey, error) {
+				seen = keys
+				return []*jwk.SigningKey{next}, nil, nil
+			})
+			require.NoError(t, err)
+			assert.Empty(t, seen)
+			require.Len(t, keys, 1)
+			assert.Equal(t, next.ID, keys[0].ID)
+
+			keys, err = p.UpdateSigningKeys(ctx, "updated", func(keys []jwk.SigningKey) ([]*jwk.SigningKey, []*jwk.SigningKey, error) {
+				seen = keys
+				keys[0].State = jwk.StateActive
+				return nil, []*jwk.SigningKey{&keys[0]}, nil
+			})
+			require.NoError(t, err)
+			require.Len(t, seen, 1)
+			require.Len(t, keys, 1)
+			assert.Equal(t, jwk.StateActive, keys[0].State)
+		})
+
+		t.Run("case=update rolls back if the update function fails", func(t *testing.T) {
+			expected := errors.New("update failed")
+			_, err := p.UpdateSigningKeys(ctx, "failed", func([]jwk.SigningKey) ([]*jwk.SigningKey, []*jwk.SigningKey, error) {
+				return []*jwk.SigningKey{newKey("failed", jwk.StateNext)}, nil, expected
+			})
+			require.ErrorIs(t, err, expected)
+
+			keys, err := p.ListSigningKeys(ctx, "failed")
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+
+		t.Run("case=network isolation", func(t *testing.T) {
+			k := newKey("isolated", jwk.StateActive)
+			require.NoError(t, p.SaveSigningKeys(ctx, []*jwk.SigningKey{k}, nil))
+
+			_, other := testhelpers.NewNetwork(t, ctx, p)
+			_, err := other.GetSigningKey(ctx, k.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			keys, err := other.ListSigningKeys(ctx, "isolated")
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+	}
+}
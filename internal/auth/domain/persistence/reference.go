@@ -19,6 +19,10 @@ import (
 	"my.com/secrets/internal/auth/domain/continuity"
 	"my.com/secrets/internal/auth/domain/courier"
 	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/jwk"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/schemamigration"
 	"my.com/secrets/internal/auth/domain/selfservice/errorx"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/recovery"
@@ -53,6 +57,11 @@ type Persister interface {
 	code.VerificationCodePersister
 	code.RegistrationCodePersister
 	code.LoginCodePersister
+	job.Persister
+	jwk.Persister
+	privacy.Persister
+	identity.MergePersister
+	schemamigration.Persister
 
 	CleanupDatabase(context.Context, time.Duration, time.Duration, int) error
 	Close(context.Context) error
@@ -6,6 +6,7 @@ package identity
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -31,6 +32,7 @@ import (
 	"github.com/ory/x/popx"
 	"github.com/ory/x/sqlcon"
 	"github.com/ory/x/sqlxx"
+	"my.com/secrets/internal/auth/domain/cipher"
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/otp"
@@ -52,18 +54,21 @@ type dependencies interface {
 	config.Provider
 	contextx.Provider
 	x.TracingProvider
+	cipher.Provider
 }
 
 type IdentityPersister struct {
-	r   dependencies
-	c   *pop.Connection
-	nid uuid.UUID
+	r         dependencies
+	c         *pop.Connection
+	nid       uuid.UUID
+	encrypted *encryptedTraitPaths
 }
 
 func NewPersister(r dependencies, c *pop.Connection) *IdentityPersister {
 	return &IdentityPersister{
-		c: c,
-		r: r,
+		c:         c,
+		r:         r,
+		encrypted: &encryptedTraitPaths{paths: map[string][]string{}},
 	}
 }
 
@@ -161,11 +166,12 @@ SELECT ic.identity_id
 FROM identity_credentials ic
 INNER JOIN identity_credential_identifiers ici
 	ON ic.id = ici.identity_credential_id
-WHERE ici.identifier = ?
+WHERE (ici.identifier = ? OR ici.identifier_blind_index IN (?))
 AND ic.nid = ?
 AND ici.nid = ?
 LIMIT 1`,
 		identifier,
+		p.blindIndexes(ctx, identifier),
 		nid,
 		nid,
 	).First(&find); err != nil {
@@ -209,12 +215,13 @@ func (p *IdentityPersister) FindByCredentialsIdentifier(ctx context.Context, ct
 					ON ic.identity_credential_type_id = ict.id
 				INNER JOIN identity_credential_identifiers ici
 					ON ic.id = ici.identity_credential_id AND ici.identity_credential_type_id = ict.id
-		WHERE ici.identifier = ?
+		WHERE (ici.identifier = ? OR ici.identifier_blind_index IN (?))
 		AND ic.nid = ?
 		AND ici.nid = ?
 		AND ict.name = ?
 		LIMIT 1`, // pop doesn't understand how to add a limit clause to this query
 		match,
+		p.blindIndexes(ctx, match),
 		nid,
 		nid,
 		ct,
@@ -271,7 +278,7 @@ func (p *IdentityPersister) findIdentityCredentialsType(ctx context.Context, ct
 	return &m, nil
 }
 
-func (p *IdentityPersister) createIdentityCredentials(ctx context.Context, conn *pop.Connection, identities ...*identity.Identity) (err error) {
+func (p *IdentityPersister) createIdentityCredentials(ctx context.Context, conn *pop.Connection, sealed sealedIdentities, identities ...*identity.Identity) (err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.createIdentityCredentials",
 		trace.WithAttributes(
 			attribute.Int("num_identities", len(identities)),
@@ -283,6 +290,10 @@ func (p *IdentityPersister) createIdentityCredentials(ctx context.Context, conn
 		traceConn   = &batch.TracerConnection{Tracer: p.r.Tracer(ctx), Connection: conn}
 		credentials []*identity.Credentials
 		identifiers []*identity.CredentialIdentifier
+		// plaintext holds the normalized value of every identifier, including the sealed ones.
+		plaintext []string
+		// encrypted holds the plaintext values of the encrypted traits by credentials ID.
+		encrypted = map[uuid.UUID][]string{}
 	)
 
 	for _, ident := range identities {
@@ -305,9 +316,16 @@ func (p *IdentityPersister) createIdentityCredentials(ctx context.Context, conn
 			cred.IdentityID = ident.ID
 			cred.NID = nid
 			cred.IdentityCredentialTypeID = ct.ID
-			credentials = append(credentials, &cred)
-
 			ident.Credentials[k] = cred
+			encrypted[cred.ID] = sealed[ident]
+
+			if cred.Type == identity.CredentialsTypePassword {
+				var cp identity.CredentialsPassword
+				if err := json.Unmarshal(cred.Config, &cp); err == nil {
+					cred.PasswordChangedAt = cp.PasswordChangedAt
+				}
+			}
+			credentials = append(credentials, &cred)
 		}
 	}
 	if err = batch.Create(ctx, traceConn, credentials); err != nil {
@@ -329,15 +347,22 @@ func (p *IdentityPersister) createIdentityCredentials(ctx context.Context, conn
 				return err
 			}
 
-			identifiers = append(identifiers, &identity.CredentialIdentifier{
+			ci := &identity.CredentialIdentifier{
 				Identifier:                ids,
 				IdentityCredentialsID:     cred.ID,
 				IdentityCredentialsTypeID: ct.ID,
 				NID:                       p.NetworkID(ctx),
-			})
+			}
+			p.sealIdentifier(ctx, encrypted[cred.ID], ci, cred.Type)
+			identifiers = append(identifiers, ci)
+			plaintext = append(plaintext, ids)
 		}
 	}
 
+	if err = p.checkIdentifierConflicts(ctx, conn, identifiers, plaintext); err != nil {
+		return err
+	}
+
 	if err = batch.Create(ctx, traceConn, identifiers); err != nil {
 		return err
 	}
@@ -526,6 +551,12 @@ func (p *IdentityPersister) CreateIdentities(ctx context.Context, identities ...
 		}
 	}
 
+	sealed, restore, err := p.sealIdentities(ctx, identities...)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
 	return p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
 		conn := &batch.TracerConnection{
 			Tracer:     p.r.Tracer(ctx),
@@ -544,7 +575,7 @@ func (p *IdentityPersister) CreateIdentities(ctx context.Context, identities ...
 		if err = p.createRecoveryAddresses(ctx, tx, identities...); err != nil {
 			return sqlcon.HandleError(err)
 		}
-		if err = p.createIdentityCredentials(ctx, tx, identities...); err != nil {
+		if err = p.createIdentityCredentials(ctx, tx, sealed, identities...); err != nil {
 			return sqlcon.HandleError(err)
 		}
 		return nil
@@ -625,6 +656,10 @@ func (p *IdentityPersister) HydrateIdentityAssociations(ctx context.Context, i *
 		return err
 	}
 
+	if err := p.openIdentity(ctx, i); err != nil {
+		return err
+	}
+
 	return p.InjectTraitsSchemaURL(ctx, i)
 }
 
@@ -635,6 +670,7 @@ type queryCredentials struct {
 	Type       identity.CredentialsType `db:"cred_type"`
 	TypeID     uuid.UUID                `db:"cred_type_id"`
 	Identifier string                   `db:"cred_identifier"`
+	BlindIndex sqlxx.NullString         `db:"cred_identifier_blind_index"`
 	Config     sqlxx.JSONRawMessage     `db:"cred_config"`
 	Version    int                      `db:"cred_version"`
 	CreatedAt  time.Time                `db:"created_at"`
@@ -671,6 +707,7 @@ func QueryForCredentials(con *pop.Connection, where ...Where) (map[uuid.UUID](ma
 		"ict.name cred_type",
 		"ict.id cred_type_id",
 		"COALESCE(identity_credential_identifiers.identifier, '') cred_identifier",
+		"identity_credential_identifiers.identifier_blind_index cred_identifier_blind_index",
 		"identity_credentials.config cred_config",
 		"identity_credentials.version cred_version",
 		"identity_credentials.created_at created_at",
@@ -697,7 +734,10 @@ func QueryForCredentials(con *pop.Connection, where ...Where) (map[uuid.UUID](ma
 			credentials = credentialsPerIdentity[res.IdentityID]
 		}
 		identifiers := credentials[res.Type].Identifiers
-		if res.Identifier != "" {
+		if res.BlindIndex != "" {
+			// The identifier is restored from the encrypted traits when the identity is opened.
+			identifiers = append(identifiers, blindIndexPrefix+string(res.BlindIndex))
+		} else if res.Identifier != "" {
 			identifiers = append(identifiers, res.Identifier)
 		}
 		if identifiers == nil {
@@ -803,15 +843,33 @@ func (p *IdentityPersister) ListIdentities(ctx context.Context, params identity.
 			INNER JOIN identity_credentials ic ON ic.identity_id = identities.id
 			INNER JOIN identity_credential_types ict ON ict.id = ic.identity_credential_type_id
 			INNER JOIN identity_credential_identifiers ici ON ici.identity_credential_id = ic.id`
+			normalized := []any{NormalizeIdentifier(identity.CredentialsTypePassword, identifier)}
+			verbatim := []any{identifier}
+			var blindIndexes string
+			if identifierOperator == "=" {
+				// Exact matches also find identifiers of encrypted traits by their blind index.
+				blindIndexes = " OR ici.identifier_blind_index IN (?)"
+				normalized = append(normalized, p.blindIndexes(ctx, NormalizeIdentifier(identity.CredentialsTypePassword, identifier)))
+				verbatim = append(verbatim, p.blindIndexes(ctx, identifier))
+			}
+
 			wheres += fmt.Sprintf(`
 			AND ic.nid = ? AND ici.nid = ?
-			AND ((ict.name IN (?, ?, ?) AND ici.identifier %s ?)
-              OR (ict.name IN (?) AND ici.identifier %s ?))
-			`, identifierOperator, identifierOperator)
-			args = append(args,
-				nid, nid,
-				identity.CredentialsTypeWebAuthn, identity.CredentialsTypePassword, identity.CredentialsTypeCodeAuth, NormalizeIdentifier(identity.CredentialsTypePassword, identifier),
-				identity.CredentialsTypeOIDC, identifier)
+			AND ((ict.name IN (?, ?, ?) AND (ici.identifier %[1]s ?%[2]s))
+              OR (ict.name IN (?) AND (ici.identifier %[1]s ?%[2]s)))
+			`, identifierOperator, blindIndexes)
+			args = append(args, nid, nid, identity.CredentialsTypeWebAuthn, identity.CredentialsTypePassword, identity.CredentialsTypeCodeAuth)
+			args = append(args, normalized...)
+			args = append(args, identity.CredentialsTypeOIDC)
+			args = append(args, verbatim...)
+		}
+
+		if params.PasswordChangedBefore != nil {
+			joins += `
+			INNER JOIN identity_credentials pc ON pc.identity_id = identities.id`
+			wheres += `
+			AND pc.nid = ? AND pc.password_changed_at <= ?`
+			args = append(args, nid, params.PasswordChangedBefore.UTC())
 		}
 
 		if params.IdsFilter != nil && len(params.IdsFilter) != 0 {
@@ -907,6 +965,10 @@ func (p *IdentityPersister) ListIdentities(ctx context.Context, params identity.
 			return nil, nil, err
 		}
 
+		if err := p.openIdentity(ctx, i); err != nil {
+			return nil, nil, err
+		}
+
 		is[k] = *i
 	}
 
@@ -924,6 +986,12 @@ func (p *IdentityPersister) UpdateIdentity(ctx context.Context, i *identity.Iden
 		return err
 	}
 
+	sealed, restore, err := p.sealIdentities(ctx, i)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
 	i.NID = p.NetworkID(ctx)
 	return sqlcon.HandleError(p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
 		// This returns "ErrNoRows" if the identity does not exist
@@ -949,7 +1017,7 @@ func (p *IdentityPersister) UpdateIdentity(ctx context.Context, i *identity.Iden
 			return sqlcon.HandleError(err)
 		}
 
-		return sqlcon.HandleError(p.createIdentityCredentials(ctx, tx, i))
+		return sqlcon.HandleError(p.createIdentityCredentials(ctx, tx, sealed, i))
 	}))
 }
 
@@ -1007,11 +1075,19 @@ func (p *IdentityPersister) FindVerifiableAddressByValue(ctx context.Context, vi
 			attribute.Stringer("network.id", p.NetworkID(ctx))))
 	otelx.End(span, &err)
 
+	value = stringToLowerTrim(value)
 	var address identity.VerifiableAddress
-	if err := p.GetConnection(ctx).Where("nid = ? AND via = ? AND value = ?", p.NetworkID(ctx), via, stringToLowerTrim(value)).First(&address); err != nil {
+	if err := p.GetConnection(ctx).RawQuery(
+		// #nosec G201 -- TableName is static
+		fmt.Sprintf("SELECT * FROM %s WHERE nid = ? AND via = ? AND (value = ? OR value_blind_index IN (?)) LIMIT 1", address.TableName(ctx)),
+		p.NetworkID(ctx), via, value, p.blindIndexes(ctx, value),
+	).First(&address); err != nil {
 		return nil, sqlcon.HandleError(err)
 	}
 
+	if address.ValueBlindIndex != "" {
+		address.Value = value
+	}
 	return &address, nil
 }
 
@@ -1021,11 +1097,19 @@ func (p *IdentityPersister) FindRecoveryAddressByValue(ctx context.Context, via
 			attribute.Stringer("network.id", p.NetworkID(ctx))))
 	defer otelx.End(span, &err)
 
+	value = stringToLowerTrim(value)
 	var address identity.RecoveryAddress
-	if err := p.GetConnection(ctx).Where("nid = ? AND via = ? AND value = ?", p.NetworkID(ctx), via, stringToLowerTrim(value)).First(&address); err != nil {
+	if err := p.GetConnection(ctx).RawQuery(
+		// #nosec G201 -- TableName is static
+		fmt.Sprintf("SELECT * FROM %s WHERE nid = ? AND via = ? AND (value = ? OR value_blind_index IN (?)) LIMIT 1", address.TableName(ctx)),
+		p.NetworkID(ctx), via, value, p.blindIndexes(ctx, value),
+	).First(&address); err != nil {
 		return nil, sqlcon.HandleError(err)
 	}
 
+	if address.ValueBlindIndex != "" {
+		address.Value = value
+	}
 	return &address, nil
 }
 
@@ -1073,6 +1157,12 @@ func (p *IdentityPersister) UpdateVerifiableAddress(ctx context.Context, address
 
 	address.NID = p.NetworkID(ctx)
 	address.Value = stringToLowerTrim(address.Value)
+	if address.ValueBlindIndex != "" {
+		// The address is an encrypted trait and must not be written in plaintext.
+		value := address.Value
+		address.Value = sealedValue(address.ID)
+		defer func() { address.Value = value }()
+	}
 	return update.Generic(ctx, p.GetConnection(ctx), p.r.Tracer(ctx).Tracer(), address)
 }
 
@@ -0,0 +1,399 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/sqlxx"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	// sealedValuePrefix is followed by the ID of the row in the identifier or value column of credential
+	// identifiers and addresses which equal an encrypted trait. Such rows are looked up by their blind index.
+	sealedValuePrefix = "sealed:"
+
+	// blindIndexPrefix marks the credential identifiers of an identity read from the database which are only
+	// known by their blind index until the identity is opened.
+	blindIndexPrefix = "blind-index:"
+)
+
+// encryptedTraitPaths caches the encrypted trait paths per identity schema URL, as compiling the schema on every
+// read is too expensive.
+type encryptedTraitPaths struct {
+	sync.RWMutex
+	paths map[string][]string
+}
+
+func (p *IdentityPersister) encryptedTraitPaths(ctx context.Context, schemaID string) ([]string, error) {
+	ss, err := p.r.IdentityTraitsSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ss.GetByID(schemaID)
+	if err != nil {
+		return nil, err
+	}
+
+	url := s.URL.String()
+	p.encrypted.RLock()
+	paths, ok := p.encrypted.paths[url]
+	p.encrypted.RUnlock()
+	if ok {
+		return paths, nil
+	}
+
+	paths, err = identity.EncryptedTraitPaths(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	p.encrypted.Lock()
+	p.encrypted.paths[url] = paths
+	p.encrypted.Unlock()
+	return paths, nil
+}
+
+// sealedIdentities holds the plaintext values of the encrypted traits of identities which are being written.
+type sealedIdentities map[*identity.Identity][]string
+
+// sealIdentities encrypts the traits which the identity schema marks as encrypted and replaces the value of
+// addresses which equal an encrypted trait with a placeholder and their blind index. Credential identifiers are
+// sealed by sealIdentifier while they are written. The returned function restores the plaintext traits and
+// addresses after the identities were written.
+func (p *IdentityPersister) sealIdentities(ctx context.Context, identities ...*identity.Identity) (_ sealedIdentities, restore func(), err error) {
+	type plaintext struct {
+		i          *identity.Identity
+		traits     identity.Traits
+		verifiable map[int]string
+		recovery   map[int]string
+	}
+
+	var restores []plaintext
+	restore = func() {
+		for _, r := range restores {
+			r.i.Traits = r.traits
+			for k, value := range r.verifiable {
+				r.i.VerifiableAddresses[k].Value = value
+			}
+			for k, value := range r.recovery {
+				r.i.RecoveryAddresses[k].Value = value
+			}
+		}
+	}
+
+	sealed := make(sealedIdentities)
+	for _, i := range identities {
+		paths, err := p.encryptedTraitPaths(ctx, i.SchemaID)
+		if err != nil {
+			restore()
+			return nil, nil, err
+		}
+
+		if len(paths) == 0 {
+			continue
+		}
+
+		traits, err := identity.EncryptTraits(ctx, p.r.Cipher(ctx), i.Traits, paths)
+		if err != nil {
+			restore()
+			return nil, nil, err
+		}
+
+		values := identity.EncryptedTraitValues(i.Traits, paths)
+		r := plaintext{i: i, traits: i.Traits, verifiable: map[int]string{}, recovery: map[int]string{}}
+		for k := range i.VerifiableAddresses {
+			a := &i.VerifiableAddresses[k]
+			if index, ok := p.sealAddress(ctx, values, &a.ID, a.Value); ok {
+				r.verifiable[k], a.Value, a.ValueBlindIndex = a.Value, sealedValue(a.ID), index
+			}
+		}
+		for k := range i.RecoveryAddresses {
+			a := &i.RecoveryAddresses[k]
+			if index, ok := p.sealAddress(ctx, values, &a.ID, a.Value); ok {
+				r.recovery[k], a.Value, a.ValueBlindIndex = a.Value, sealedValue(a.ID), index
+			}
+		}
+
+		i.Traits = traits
+		sealed[i] = values
+		restores = append(restores, r)
+	}
+
+	return sealed, restore, nil
+}
+
+// sealAddress returns the blind index of the address if it equals an encrypted trait. It assigns the ID of new
+// addresses, because the placeholder which is stored instead of the address contains it.
+func (p *IdentityPersister) sealAddress(ctx context.Context, values []string, id *uuid.UUID, address string) (sqlxx.NullString, bool) {
+	address = stringToLowerTrim(address)
+	for _, value := range values {
+		if stringToLowerTrim(value) == address {
+			if *id == uuid.Nil {
+				*id = x.NewUUID()
+			}
+			return sqlxx.NullString(p.blindIndex(ctx, address)), true
+		}
+	}
+	return "", false
+}
+
+// sealIdentifier replaces the normalized credential identifier with a placeholder and its blind index if it
+// equals an encrypted trait of the identity.
+func (p *IdentityPersister) sealIdentifier(ctx context.Context, values []string, ci *identity.CredentialIdentifier, ct identity.CredentialsType) {
+	for _, value := range values {
+		if NormalizeIdentifier(ct, value) == ci.Identifier {
+			ci.ID = x.NewUUID()
+			ci.IdentifierBlindIndex = sqlxx.NullString(p.blindIndex(ctx, ci.Identifier))
+			ci.Identifier = sealedValue(ci.ID)
+			return
+		}
+	}
+}
+
+// checkIdentifierConflicts returns sqlcon.ErrUniqueViolation if a credential of the same type already has one of
+// the identifiers, whose normalized values are given in the same order. The unique indexes only compare plaintext
+// identifiers with plaintext identifiers and blind indexes with blind indexes of the same secret. They therefore
+// miss an identifier which is sealed now but was stored in plain text before, for example because its trait was
+// marked as encrypted since, or the other way around, as well as identifiers indexed with a previous secret.
+func (p *IdentityPersister) checkIdentifierConflicts(ctx context.Context, conn *pop.Connection, identifiers []*identity.CredentialIdentifier, plaintext []string) error {
+	if len(identifiers) == 0 {
+		return nil
+	}
+
+	// keys holds the plaintext value and the blind indexes for all secrets of every identifier, by credentials
+	// type.
+	keys := make(map[uuid.UUID]map[string]struct{})
+	values := make([]string, 0, len(plaintext))
+	var indexes []string
+	for k, ci := range identifiers {
+		if keys[ci.IdentityCredentialsTypeID] == nil {
+			keys[ci.IdentityCredentialsTypeID] = map[string]struct{}{}
+		}
+		keys[ci.IdentityCredentialsTypeID][plaintext[k]] = struct{}{}
+		values = append(values, plaintext[k])
+		for _, index := range p.blindIndexes(ctx, plaintext[k]) {
+			keys[ci.IdentityCredentialsTypeID][index] = struct{}{}
+			indexes = append(indexes, index)
+		}
+	}
+
+	var existing []struct {
+		TypeID     uuid.UUID        `db:"identity_credential_type_id"`
+		Identifier string           `db:"identifier"`
+		BlindIndex sqlxx.NullString `db:"identifier_blind_index"`
+	}
+	if err := conn.RawQuery(
+		// #nosec G201 -- TableName is static
+		fmt.Sprintf("SELECT identity_credential_type_id, identifier, identifier_blind_index FROM %s WHERE nid = ? AND (identifier IN (?) OR identifier_blind_index IN (?))", new(identity.CredentialIdentifier).TableName(ctx)),
+		p.NetworkID(ctx), values, indexes,
+	).All(&existing); err != nil {
+		return sqlcon.HandleError(err)
+	}
+
+	for _, e := range existing {
+		if _, ok := keys[e.TypeID][e.Identifier]; ok {
+			return errors.WithStack(sqlcon.ErrUniqueViolation)
+		}
+		if _, ok := keys[e.TypeID][string(e.BlindIndex)]; ok && e.BlindIndex != "" {
+			return errors.WithStack(sqlcon.ErrUniqueViolation)
+		}
+	}
+	return nil
+}
+
+// openIdentity decrypts the encrypted traits of an identity which was read from the database and restores the
+// credential identifiers and addresses which were stored as blind indexes.
+func (p *IdentityPersister) openIdentity(ctx context.Context, i *identity.Identity) error {
+	paths, err := p.encryptedTraitPaths(ctx, i.SchemaID)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	if i.Traits, err = identity.DecryptTraits(ctx, p.r.Cipher(ctx), i.Traits, paths); err != nil {
+		return err
+	}
+
+	values := identity.EncryptedTraitValues(i.Traits, paths)
+	for ct, c := range i.Credentials {
+		var revealed bool
+		for k, identifier := range c.Identifiers {
+			index, ok := strings.CutPrefix(identifier, blindIndexPrefix)
+			if !ok {
+				continue
+			}
+
+			for _, value := range values {
+				normalized := NormalizeIdentifier(c.Type, value)
+				if p.matchesBlindIndex(ctx, normalized, index) {
+					c.Identifiers[k] = normalized
+					revealed = true
+					break
+				}
+			}
+		}
+
+		if revealed {
+			sort.Strings(c.Identifiers)
+			i.Credentials[ct] = c
+		}
+	}
+
+	for k := range i.VerifiableAddresses {
+		a := &i.VerifiableAddresses[k]
+		a.Value = p.openAddress(ctx, values, a.Value, a.ValueBlindIndex)
+	}
+	for k := range i.RecoveryAddresses {
+		a := &i.RecoveryAddresses[k]
+		a.Value = p.openAddress(ctx, values, a.Value, a.ValueBlindIndex)
+	}
+
+	return nil
+}
+
+// openAddress returns the encrypted trait which matches the blind index of a sealed address.
+func (p *IdentityPersister) openAddress(ctx context.Context, values []string, address string, index sqlxx.NullString) string {
+	if index == "" {
+		return address
+	}
+
+	for _, value := range values {
+		if normalized := stringToLowerTrim(value); p.matchesBlindIndex(ctx, normalized, string(index)) {
+			return normalized
+		}
+	}
+	return address
+}
+
+func (p *IdentityPersister) CountStaleSealedValues(ctx context.Context, ids ...uuid.UUID) (_ map[uuid.UUID]int, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.CountStaleSealedValues")
+	defer otelx.End(span, &err)
+
+	counts := make(map[uuid.UUID]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	nid := p.NetworkID(ctx)
+	var is []identity.Identity
+	if err := p.GetConnection(ctx).RawQuery(
+		// #nosec G201 -- TableName is static
+		fmt.Sprintf("SELECT * FROM %s WHERE nid = ? AND id IN (?)", new(identity.Identity).TableName(ctx)),
+		nid, ids,
+	).All(&is); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	// current holds the blind indexes which the plaintext values of every identity have under the current secret.
+	current := make(map[uuid.UUID]map[string]struct{}, len(is))
+	for _, i := range is {
+		paths, err := p.encryptedTraitPaths(ctx, i.SchemaID)
+		if err != nil {
+			return nil, err
+		} else if len(paths) == 0 {
+			continue
+		}
+
+		counts[i.ID] = identity.CountStaleEncryptedTraits(ctx, p.r.Cipher(ctx), i.Traits, paths)
+		traits, err := identity.DecryptTraits(ctx, p.r.Cipher(ctx), i.Traits, paths)
+		if err != nil {
+			return nil, err
+		}
+
+		current[i.ID] = map[string]struct{}{}
+		for _, value := range identity.EncryptedTraitValues(traits, paths) {
+			current[i.ID][p.blindIndex(ctx, value)] = struct{}{}
+			current[i.ID][p.blindIndex(ctx, stringToLowerTrim(value))] = struct{}{}
+		}
+	}
+
+	var indexes []struct {
+		IdentityID uuid.UUID `db:"identity_id"`
+		BlindIndex string    `db:"blind_index"`
+	}
+	if err := p.GetConnection(ctx).RawQuery(`
+SELECT ic.identity_id, ici.identifier_blind_index AS blind_index
+FROM identity_credential_identifiers ici
+INNER JOIN identity_credentials ic
+	ON ic.id = ici.identity_credential_id
+WHERE ici.nid = ? AND ic.identity_id IN (?) AND ici.identifier_blind_index IS NOT NULL
+UNION ALL
+SELECT identity_id, value_blind_index AS blind_index
+FROM identity_verifiable_addresses
+WHERE nid = ? AND identity_id IN (?) AND value_blind_index IS NOT NULL
+UNION ALL
+SELECT identity_id, value_blind_index AS blind_index
+FROM identity_recovery_addresses
+WHERE nid = ? AND identity_id IN (?) AND value_blind_index IS NOT NULL`,
+		nid, ids, nid, ids, nid, ids,
+	).All(&indexes); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	for _, index := range indexes {
+		// Identities whose schema no longer encrypts traits can not be sealed again.
+		if known, ok := current[index.IdentityID]; ok {
+			if _, ok := known[index.BlindIndex]; !ok {
+				counts[index.IdentityID]++
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+func sealedValue(id uuid.UUID) string {
+	return sealedValuePrefix + id.String()
+}
+
+// blindIndex returns the deterministic blind index of the value using the current blind index secret.
+func (p *IdentityPersister) blindIndex(ctx context.Context, value string) string {
+	return blindIndexWithSecret(value, p.r.Config().SecretsBlindIndex(ctx)[0])
+}
+
+// blindIndexes returns the blind indexes of the value for all blind index secrets, so that identifiers and
+// addresses can be looked up regardless of which secret indexed them.
+func (p *IdentityPersister) blindIndexes(ctx context.Context, value string) []string {
+	secrets := p.r.Config().SecretsBlindIndex(ctx)
+	indexes := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		indexes = append(indexes, blindIndexWithSecret(value, secret))
+	}
+	return indexes
+}
+
+func (p *IdentityPersister) matchesBlindIndex(ctx context.Context, value, index string) bool {
+	for _, secret := range p.r.Config().SecretsBlindIndex(ctx) {
+		if hmac.Equal([]byte(blindIndexWithSecret(value, secret)), []byte(index)) {
+			return true
+		}
+	}
+	return false
+}
+
+func blindIndexWithSecret(value string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
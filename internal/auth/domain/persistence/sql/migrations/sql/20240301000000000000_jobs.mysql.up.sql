@@ -0,0 +1,18 @@
+CREATE TABLE jobs (
+    id CHAR(36) NOT NULL PRIMARY KEY,
+    nid CHAR(36) NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    state VARCHAR(32) NOT NULL,
+    subject CHAR(36) NULL,
+    progress TEXT NULL,
+    result TEXT NULL,
+    error TEXT NULL,
+    finished_at timestamp NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT jobs_nid_fk FOREIGN KEY (nid) REFERENCES networks (id) ON DELETE CASCADE
+);
+
+-- Relevant query:
+--   SELECT * FROM jobs WHERE nid = ? AND type = ? ORDER BY created_at DESC
+CREATE INDEX jobs_nid_type_created_at_idx ON jobs (nid, type, created_at);
@@ -0,0 +1,18 @@
+CREATE TABLE jobs (
+    "id" UUID NOT NULL PRIMARY KEY,
+    "nid" UUID NOT NULL,
+    "type" VARCHAR(64) NOT NULL,
+    "state" VARCHAR(32) NOT NULL,
+    "subject" UUID NULL,
+    "progress" TEXT NULL,
+    "result" TEXT NULL,
+    "error" TEXT NULL,
+    "finished_at" timestamp NULL,
+    "created_at" timestamp NOT NULL,
+    "updated_at" timestamp NOT NULL,
+    CONSTRAINT "jobs_nid_fk" FOREIGN KEY ("nid") REFERENCES "networks" ("id") ON DELETE CASCADE
+);
+
+-- Relevant query:
+--   SELECT * FROM jobs WHERE nid = ? AND type = ? ORDER BY created_at DESC
+CREATE INDEX jobs_nid_type_created_at_idx ON jobs (nid, type, created_at);
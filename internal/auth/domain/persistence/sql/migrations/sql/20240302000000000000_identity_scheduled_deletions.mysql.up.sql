@@ -0,0 +1,22 @@
+CREATE TABLE identity_scheduled_deletions (
+    id CHAR(36) NOT NULL PRIMARY KEY,
+    nid CHAR(36) NOT NULL,
+    identity_id CHAR(36) NOT NULL,
+    delete_after timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    initiator VARCHAR(32) NOT NULL,
+    cancel_token_hash VARCHAR(64) NOT NULL,
+    previous_state VARCHAR(255) NOT NULL,
+    failed_at timestamp NULL,
+    error TEXT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT identity_scheduled_deletions_nid_fk FOREIGN KEY (nid) REFERENCES networks (id) ON DELETE CASCADE,
+    CONSTRAINT identity_scheduled_deletions_identity_id_fk FOREIGN KEY (identity_id) REFERENCES identities (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX identity_scheduled_deletions_nid_identity_id_uq_idx ON identity_scheduled_deletions (nid, identity_id);
+CREATE UNIQUE INDEX identity_scheduled_deletions_cancel_token_hash_uq_idx ON identity_scheduled_deletions (cancel_token_hash);
+
+-- Relevant query:
+--   SELECT * FROM identity_scheduled_deletions WHERE nid = ? AND delete_after <= ? ORDER BY delete_after ASC
+CREATE INDEX identity_scheduled_deletions_nid_delete_after_idx ON identity_scheduled_deletions (nid, delete_after);
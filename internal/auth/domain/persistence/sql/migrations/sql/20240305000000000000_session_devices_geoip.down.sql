@@ -0,0 +1,3 @@
+ALTER TABLE session_devices DROP COLUMN asn;
+ALTER TABLE session_devices DROP COLUMN country;
+ALTER TABLE session_devices DROP COLUMN city;
@@ -0,0 +1,3 @@
+ALTER TABLE session_devices ADD COLUMN city VARCHAR(255) NULL;
+ALTER TABLE session_devices ADD COLUMN country VARCHAR(8) NULL;
+ALTER TABLE session_devices ADD COLUMN asn BIGINT NULL;
@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN risk_assessment jsonb NULL;
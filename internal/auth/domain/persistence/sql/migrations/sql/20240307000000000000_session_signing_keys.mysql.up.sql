@@ -0,0 +1,19 @@
+CREATE TABLE session_signing_keys (
+    id CHAR(36) NOT NULL PRIMARY KEY,
+    nid CHAR(36) NOT NULL,
+    alias VARCHAR(255) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    activated_at timestamp NULL,
+    retired_at timestamp NULL,
+    revoked_at timestamp NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT session_signing_keys_nid_fk FOREIGN KEY (nid) REFERENCES networks (id) ON DELETE CASCADE
+);
+
+-- Relevant query:
+--   SELECT * FROM session_signing_keys WHERE nid = ? AND alias = ? ORDER BY created_at DESC
+CREATE INDEX session_signing_keys_nid_alias_created_at_idx ON session_signing_keys (nid, alias, created_at);
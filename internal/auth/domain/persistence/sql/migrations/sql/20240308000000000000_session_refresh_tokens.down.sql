@@ -0,0 +1,2 @@
+DROP TABLE session_refresh_tokens;
+ALTER TABLE sessions DROP COLUMN token_expires_at;
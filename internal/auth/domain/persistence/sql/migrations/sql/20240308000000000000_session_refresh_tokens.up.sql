@@ -0,0 +1,21 @@
+ALTER TABLE sessions ADD COLUMN token_expires_at timestamp NULL;
+
+CREATE TABLE session_refresh_tokens (
+    "id" UUID NOT NULL PRIMARY KEY,
+    "nid" UUID NOT NULL,
+    "session_id" UUID NOT NULL,
+    "signature" VARCHAR(64) NOT NULL,
+    "used_at" timestamp NULL,
+    "previous_id" UUID NULL,
+    "created_at" timestamp NOT NULL,
+    "updated_at" timestamp NOT NULL,
+    CONSTRAINT "session_refresh_tokens_nid_fk" FOREIGN KEY ("nid") REFERENCES "networks" ("id") ON DELETE CASCADE,
+    CONSTRAINT "session_refresh_tokens_session_id_fk" FOREIGN KEY ("session_id") REFERENCES "sessions" ("id") ON DELETE CASCADE
+);
+
+-- Relevant query:
+--   SELECT * FROM session_refresh_tokens WHERE signature = ? AND nid = ?
+CREATE UNIQUE INDEX session_refresh_tokens_signature_nid_uq_idx ON session_refresh_tokens (signature, nid);
+-- Relevant query:
+--   UPDATE session_refresh_tokens SET used_at = ? WHERE session_id = ? AND nid = ? AND used_at IS NULL
+CREATE INDEX session_refresh_tokens_session_id_nid_idx ON session_refresh_tokens (session_id, nid);
@@ -0,0 +1,2 @@
+DROP TABLE session_dpop_proofs;
+ALTER TABLE sessions DROP COLUMN dpop_jkt;
@@ -0,0 +1,14 @@
+ALTER TABLE sessions ADD COLUMN dpop_jkt VARCHAR(64) NULL;
+
+CREATE TABLE session_dpop_proofs (
+    "id" VARCHAR(64) NOT NULL,
+    "nid" UUID NOT NULL,
+    "expires_at" timestamp NOT NULL,
+    "created_at" timestamp NOT NULL,
+    PRIMARY KEY ("id", "nid"),
+    CONSTRAINT "session_dpop_proofs_nid_fk" FOREIGN KEY ("nid") REFERENCES "networks" ("id") ON DELETE CASCADE
+);
+
+-- Relevant query:
+--   DELETE FROM session_dpop_proofs WHERE expires_at <= ? AND nid = ?
+CREATE INDEX session_dpop_proofs_expires_at_nid_idx ON session_dpop_proofs (expires_at, nid);
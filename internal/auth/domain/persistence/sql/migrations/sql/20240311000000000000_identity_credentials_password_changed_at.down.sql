@@ -0,0 +1,2 @@
+DROP INDEX identity_credentials_nid_password_changed_at_idx;
+ALTER TABLE identity_credentials DROP COLUMN password_changed_at;
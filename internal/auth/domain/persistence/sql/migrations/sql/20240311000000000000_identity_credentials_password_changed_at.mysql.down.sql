@@ -0,0 +1,2 @@
+DROP INDEX identity_credentials_nid_password_changed_at_idx ON identity_credentials;
+ALTER TABLE identity_credentials DROP COLUMN password_changed_at;
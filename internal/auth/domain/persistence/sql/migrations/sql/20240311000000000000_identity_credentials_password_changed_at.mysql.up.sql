@@ -0,0 +1,3 @@
+ALTER TABLE identity_credentials ADD COLUMN password_changed_at timestamp NULL;
+
+CREATE INDEX identity_credentials_nid_password_changed_at_idx ON identity_credentials (nid, password_changed_at);
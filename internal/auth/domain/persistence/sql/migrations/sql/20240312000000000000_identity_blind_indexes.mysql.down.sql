@@ -0,0 +1,8 @@
+DROP INDEX identity_recovery_addresses_nid_via_blind_index_uq_idx ON identity_recovery_addresses;
+ALTER TABLE identity_recovery_addresses DROP COLUMN value_blind_index;
+
+DROP INDEX identity_verifiable_addresses_nid_via_blind_index_uq_idx ON identity_verifiable_addresses;
+ALTER TABLE identity_verifiable_addresses DROP COLUMN value_blind_index;
+
+DROP INDEX identity_credential_identifiers_nid_type_blind_index_uq_idx ON identity_credential_identifiers;
+ALTER TABLE identity_credential_identifiers DROP COLUMN identifier_blind_index;
@@ -0,0 +1,8 @@
+ALTER TABLE identity_credential_identifiers ADD COLUMN identifier_blind_index VARCHAR(64) NULL;
+CREATE UNIQUE INDEX identity_credential_identifiers_nid_type_blind_index_uq_idx ON identity_credential_identifiers (nid, identity_credential_type_id, identifier_blind_index);
+
+ALTER TABLE identity_verifiable_addresses ADD COLUMN value_blind_index VARCHAR(64) NULL;
+CREATE UNIQUE INDEX identity_verifiable_addresses_nid_via_blind_index_uq_idx ON identity_verifiable_addresses (nid, via, value_blind_index);
+
+ALTER TABLE identity_recovery_addresses ADD COLUMN value_blind_index VARCHAR(64) NULL;
+CREATE UNIQUE INDEX identity_recovery_addresses_nid_via_blind_index_uq_idx ON identity_recovery_addresses (nid, via, value_blind_index);
@@ -0,0 +1,8 @@
+DROP INDEX selfservice_registration_flows_identity_id_nid_idx ON selfservice_registration_flows;
+ALTER TABLE selfservice_registration_flows DROP COLUMN identity_id;
+
+DROP INDEX selfservice_login_flows_identity_id_nid_idx ON selfservice_login_flows;
+ALTER TABLE selfservice_login_flows DROP COLUMN identity_id;
+
+DROP INDEX courier_messages_identity_id_nid_idx ON courier_messages;
+ALTER TABLE courier_messages DROP COLUMN identity_id;
@@ -0,0 +1,14 @@
+-- Relevant query:
+--   SELECT * FROM courier_messages WHERE nid = ? AND (identity_id = ? OR recipient IN (?))
+ALTER TABLE courier_messages ADD COLUMN identity_id UUID NULL;
+CREATE INDEX courier_messages_identity_id_nid_idx ON courier_messages (identity_id, nid);
+
+-- Relevant query:
+--   SELECT * FROM selfservice_login_flows WHERE identity_id = ? AND nid = ?
+ALTER TABLE selfservice_login_flows ADD COLUMN identity_id UUID NULL;
+CREATE INDEX selfservice_login_flows_identity_id_nid_idx ON selfservice_login_flows (identity_id, nid);
+
+-- Relevant query:
+--   SELECT * FROM selfservice_registration_flows WHERE identity_id = ? AND nid = ?
+ALTER TABLE selfservice_registration_flows ADD COLUMN identity_id UUID NULL;
+CREATE INDEX selfservice_registration_flows_identity_id_nid_idx ON selfservice_registration_flows (identity_id, nid);
@@ -19,7 +19,9 @@ import (
 	"github.com/ory/x/networkx"
 	"github.com/ory/x/otelx"
 	"github.com/ory/x/popx"
+	"my.com/secrets/internal/auth/domain/cipher"
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/persistence"
 	"my.com/secrets/internal/auth/domain/persistence/sql/devices"
@@ -42,6 +44,9 @@ type (
 		x.TracingProvider
 		schema.IdentityTraitsProvider
 		identity.ValidationProvider
+		cipher.Provider
+		session.CacheProvider
+		geoip.Provider
 	}
 	Persister struct {
 		nid uuid.UUID
@@ -238,6 +243,12 @@ func (p *Persister) CleanupDatabase(ctx context.Context, wait time.Duration, old
 	}
 	time.Sleep(wait)
 
+	p.r.Logger().Println("Cleaning up expired DPoP proofs")
+	if err := p.DeleteExpiredDPoPProofs(ctx, currentTime, batchSize); err != nil {
+		return err
+	}
+	time.Sleep(wait)
+
 	p.r.Logger().Println("Successfully cleaned up the latest batch of the SQL database! " +
 		"This should be re-run periodically, to be sure that all expired data is purged.")
 	return nil
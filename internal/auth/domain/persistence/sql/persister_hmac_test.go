@@ -19,9 +19,12 @@ import (
 
 	"github.com/ory/x/logrusx"
 
+	"my.com/secrets/internal/auth/domain/cipher"
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/session"
 )
 
 type logRegistryOnly struct {
@@ -60,6 +63,18 @@ func (l *logRegistryOnly) IdentityValidator() *identity.Validator {
 	panic("implement me")
 }
 
+func (l *logRegistryOnly) Cipher(ctx context.Context) cipher.Cipher {
+	panic("implement me")
+}
+
+func (l *logRegistryOnly) SessionCache() *session.Cache {
+	panic("implement me")
+}
+
+func (l *logRegistryOnly) GeoIPResolver() geoip.Resolver {
+	panic("implement me")
+}
+
 var _ persisterDependencies = &logRegistryOnly{}
 
 func TestPersisterHMAC(t *testing.T) {
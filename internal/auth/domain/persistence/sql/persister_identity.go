@@ -0,0 +1,64 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+// The methods below wrap the identity persister so that cached sessions, which embed their identity, are
+// invalidated whenever the identity changes.
+
+func (p *Persister) UpdateIdentity(ctx context.Context, i *identity.Identity) error {
+	if err := p.PrivilegedPool.UpdateIdentity(ctx, i); err != nil {
+		return err
+	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, i.ID) })
+	return nil
+}
+
+func (p *Persister) DeleteIdentity(ctx context.Context, id uuid.UUID) error {
+	if err := p.PrivilegedPool.DeleteIdentity(ctx, id); err != nil {
+		return err
+	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, id) })
+	return nil
+}
+
+func (p *Persister) UpdateVerifiableAddress(ctx context.Context, address *identity.VerifiableAddress) error {
+	if err := p.PrivilegedPool.UpdateVerifiableAddress(ctx, address); err != nil {
+		return err
+	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, address.IdentityID) })
+	return nil
+}
+
+// VerifyAddress only knows the verification code, so the identity whose sessions are invalidated is looked up by
+// the code in the same transaction.
+func (p *Persister) VerifyAddress(ctx context.Context, code string) error {
+	return p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		var identityID uuid.UUID
+		if err := sqlcon.HandleError(tx.RawQuery(
+			// #nosec G201 -- TableName is static
+			fmt.Sprintf("SELECT identity_id FROM %s WHERE nid = ? AND code = ?", new(identity.VerifiableAddress).TableName(ctx)),
+			p.NetworkID(ctx), code,
+		).First(&identityID)); err != nil {
+			return err
+		}
+
+		if err := p.PrivilegedPool.VerifyAddress(ctx, code); err != nil {
+			return err
+		}
+		p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, identityID) })
+		return nil
+	})
+}
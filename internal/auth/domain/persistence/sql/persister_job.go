@@ -0,0 +1,80 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/persistence/sql/update"
+)
+
+var _ job.Persister = new(Persister)
+
+func (p *Persister) CreateJob(ctx context.Context, j *job.Job) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.CreateJob")
+	defer otelx.End(span, &err)
+
+	j.NID = p.NetworkID(ctx)
+	return sqlcon.HandleError(p.GetConnection(ctx).Create(j))
+}
+
+func (p *Persister) UpdateJob(ctx context.Context, j *job.Job) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.UpdateJob")
+	defer otelx.End(span, &err)
+
+	j.UpdatedAt = time.Now().UTC()
+	cp := *j
+	cp.NID = p.NetworkID(ctx)
+	return update.Generic(ctx, p.GetConnection(ctx), p.r.Tracer(ctx).Tracer(), &cp)
+}
+
+func (p *Persister) ResumeJob(ctx context.Context, id uuid.UUID, staleBefore time.Time) (_ bool, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ResumeJob")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- TableName is static
+	count, err := p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"UPDATE %s SET state = ?, error = NULL, finished_at = NULL, updated_at = ? WHERE id = ? AND nid = ? AND (state IN (?, ?) OR (state = ? AND updated_at < ?))",
+		new(job.Job).TableName(ctx),
+	), job.StateRunning, time.Now().UTC(), id, p.NetworkID(ctx), job.StateFailed, job.StatePaused, job.StateRunning, staleBefore.UTC()).ExecWithCount()
+	if err != nil {
+		return false, sqlcon.HandleError(err)
+	}
+	return count > 0, nil
+}
+
+func (p *Persister) GetJob(ctx context.Context, id uuid.UUID) (_ *job.Job, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetJob")
+	defer otelx.End(span, &err)
+
+	var j job.Job
+	if err := p.GetConnection(ctx).Where("id = ? AND nid = ?", id, p.NetworkID(ctx)).First(&j); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return &j, nil
+}
+
+func (p *Persister) ListJobs(ctx context.Context, t job.Type, page, itemsPerPage int) (_ []job.Job, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ListJobs")
+	defer otelx.End(span, &err)
+
+	q := p.GetConnection(ctx).Where("nid = ?", p.NetworkID(ctx))
+	if t != "" {
+		q = q.Where("type = ?", t)
+	}
+
+	jobs := make([]job.Job, 0)
+	if err := q.Order("created_at DESC, id DESC").Paginate(page, itemsPerPage).All(&jobs); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return jobs, nil
+}
@@ -68,6 +68,17 @@ func (p *Persister) ForceLoginFlow(ctx context.Context, id uuid.UUID) (err error
 	})
 }
 
+func (p *Persister) SetLoginFlowIdentity(ctx context.Context, id, identityID uuid.UUID) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.SetLoginFlowIdentity")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- TableName is static
+	return sqlcon.HandleError(p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"UPDATE %s SET identity_id = ? WHERE id = ? AND nid = ?",
+		new(login.Flow).TableName(ctx),
+	), identityID, id, p.NetworkID(ctx)).Exec())
+}
+
 func (p *Persister) DeleteExpiredLoginFlows(ctx context.Context, expiresAt time.Time, limit int) (err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.DeleteExpiredLoginFlows")
 	defer otelx.End(span, &err)
@@ -0,0 +1,94 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+)
+
+var _ identity.MergePersister = new(Persister)
+
+// errMergeDryRun rolls back the merge transaction in dry-run mode.
+var errMergeDryRun = errors.New("identity merge dry run")
+
+func (p *Persister) MergeIdentities(ctx context.Context, targetID, sourceID uuid.UUID, dryRun bool, merge func(ctx context.Context, target, source *identity.Identity) (*identity.Identity, error)) (moved int, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.MergeIdentities")
+	defer otelx.End(span, &err)
+
+	if err := p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		// The identities are locked so that concurrent changes to either of them are not lost by storing the
+		// merged identity, and so that a concurrent merge can not move the source into another identity. SQLite
+		// locks the whole database for writing transactions.
+		if tx.Dialect.Name() != "sqlite3" {
+			//#nosec G201 -- TableName is static
+			if err := tx.RawQuery(fmt.Sprintf(
+				"SELECT id FROM %s WHERE id IN (?, ?) AND nid = ? ORDER BY id FOR UPDATE",
+				new(identity.Identity).TableName(ctx),
+			), targetID, sourceID, p.NetworkID(ctx)).Exec(); err != nil {
+				return sqlcon.HandleError(err)
+			}
+		}
+
+		target, err := p.PrivilegedPool.GetIdentityConfidential(ctx, targetID)
+		if err != nil {
+			return err
+		}
+
+		source, err := p.PrivilegedPool.GetIdentityConfidential(ctx, sourceID)
+		if err != nil {
+			return err
+		}
+
+		merged, err := merge(ctx, target, source)
+		if err != nil {
+			return err
+		}
+
+		//#nosec G201 -- TableName is static
+		count, err := tx.RawQuery(fmt.Sprintf(
+			"UPDATE %s SET identity_id = ? WHERE identity_id = ? AND nid = ?",
+			new(session.Session).TableName(ctx),
+		), merged.ID, sourceID, p.NetworkID(ctx)).ExecWithCount()
+		if err != nil {
+			return sqlcon.HandleError(err)
+		}
+		moved = count
+
+		// The source identity is deleted first so that its credential identifiers and addresses can be
+		// stored on the merged identity without violating unique constraints. The identity pool is used
+		// directly so that the session cache is only invalidated once the merge was committed.
+		if err := p.PrivilegedPool.DeleteIdentity(ctx, sourceID); err != nil {
+			return err
+		}
+
+		if err := p.PrivilegedPool.UpdateIdentity(ctx, merged); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errors.WithStack(errMergeDryRun)
+		}
+		return nil
+	}); errors.Is(err, errMergeDryRun) {
+		return moved, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	// The sessions of the source identity now belong to the merged identity.
+	p.r.SessionCache().InvalidateIdentity(ctx, sourceID)
+	p.r.SessionCache().InvalidateIdentity(ctx, targetID)
+	return moved, nil
+}
@@ -0,0 +1,329 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/recovery"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/registration"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/settings"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/verification"
+	"my.com/secrets/internal/auth/domain/session"
+)
+
+var _ privacy.Persister = new(Persister)
+
+// identityFlowTables lists the self-service flow tables which reference an identity, and the referencing column.
+var identityFlowTables = []struct {
+	kind, table, column string
+}{
+	{kind: "login", table: new(login.Flow).TableName(context.Background()), column: "identity_id"},
+	{kind: "registration", table: new(registration.Flow).TableName(context.Background()), column: "identity_id"},
+	{kind: "settings", table: new(settings.Flow).TableName(context.Background()), column: "identity_id"},
+	{kind: "recovery", table: new(recovery.Flow).TableName(context.Background()), column: "recovered_identity_id"},
+	{kind: "verification", table: new(verification.Flow).TableName(context.Background()), column: "identity_id"},
+}
+
+func (p *Persister) ExportIdentityData(ctx context.Context, identityID uuid.UUID) (_ *privacy.Export, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ExportIdentityData")
+	defer otelx.End(span, &err)
+
+	i, err := p.GetIdentity(ctx, identityID, identity.ExpandEverything)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &privacy.Export{
+		Credentials:     make([]privacy.CredentialsMetadata, 0, len(i.Credentials)),
+		Sessions:        make([]session.Session, 0),
+		CourierMessages: make([]courier.Message, 0),
+		Flows:           make([]privacy.FlowRecord, 0),
+		ExportedAt:      time.Now().UTC(),
+	}
+
+	for _, c := range i.Credentials {
+		export.Credentials = append(export.Credentials, privacy.NewCredentialsMetadata(c))
+	}
+	// Credentials contain secrets and are therefore only exported as metadata.
+	i.Credentials = nil
+	export.Identity = i
+
+	nid := p.NetworkID(ctx)
+	con := p.GetConnection(ctx)
+
+	if err := con.Where("identity_id = ? AND nid = ?", identityID, nid).
+		EagerPreload(session.Expandables{session.ExpandSessionDevices}.ToEager()...).
+		Order("authenticated_at DESC").
+		All(&export.Sessions); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	where, args := identityMessagesCondition(i)
+	//#nosec G201 -- TableName is static
+	if err := con.RawQuery(fmt.Sprintf(
+		"SELECT * FROM %s WHERE nid = ? AND %s ORDER BY created_at DESC",
+		new(courier.Message).TableName(ctx), where,
+	), append([]interface{}{nid}, args...)...).All(&export.CourierMessages); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	for _, t := range identityFlowTables {
+		var records []privacy.FlowRecord
+		//#nosec G201 -- TableName is static
+		if err := con.RawQuery(fmt.Sprintf(
+			"SELECT id, type, state, active_method, created_at FROM %s WHERE %s = ? AND nid = ? ORDER BY created_at DESC",
+			t.table, t.column,
+		), identityID, nid).All(&records); err != nil {
+			return nil, sqlcon.HandleError(err)
+		}
+		for k := range records {
+			records[k].Kind = t.kind
+		}
+		export.Flows = append(export.Flows, records...)
+	}
+
+	return export, nil
+}
+
+func (p *Persister) EraseIdentityData(ctx context.Context, identityID uuid.UUID) (_ *privacy.ErasureReceipt, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.EraseIdentityData")
+	defer otelx.End(span, &err)
+
+	receipt := privacy.NewErasureReceipt(identityID)
+	nid := p.NetworkID(ctx)
+
+	if err := p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		i, err := p.GetIdentity(ctx, identityID, identity.ExpandEverything)
+		if err != nil {
+			return err
+		}
+
+		where, args := identityMessagesCondition(i)
+		//#nosec G201 -- TableName is static
+		count, err := tx.RawQuery(fmt.Sprintf(
+			"UPDATE %s SET recipient = '', subject = '', body = '', template_data = NULL, identity_id = NULL WHERE nid = ? AND %s",
+			new(courier.Message).TableName(ctx), where,
+		), append([]interface{}{nid}, args...)...).ExecWithCount()
+		if err != nil {
+			return sqlcon.HandleError(err)
+		}
+		receipt.Anonymized["courier_messages"] = count
+
+		//#nosec G201 -- TableName is static
+		if count, err = tx.Where(fmt.Sprintf(
+			"nid = ? AND session_id IN (SELECT id FROM %s WHERE identity_id = ? AND nid = ?)",
+			new(session.Session).TableName(ctx),
+		), nid, identityID, nid).Count(new(session.Device)); err != nil {
+			return sqlcon.HandleError(err)
+		}
+		receipt.Deleted["session_devices"] = count
+
+		if count, err = tx.Where("identity_id = ? AND nid = ?", identityID, nid).Count(new(session.Session)); err != nil {
+			return sqlcon.HandleError(err)
+		}
+		receipt.Deleted["sessions"] = count
+
+		// Deleting the sessions also deletes their devices, and removes the sessions from the cache.
+		if count > 0 {
+			if err := p.DeleteSessionsByIdentity(ctx, identityID); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range identityFlowTables {
+			//#nosec G201 -- TableName is static
+			count, err := tx.RawQuery(fmt.Sprintf(
+				"DELETE FROM %s WHERE %s = ? AND nid = ?",
+				t.table, t.column,
+			), identityID, nid).ExecWithCount()
+			if err != nil {
+				return sqlcon.HandleError(err)
+			}
+			receipt.Deleted[t.kind+"_flows"] = count
+		}
+
+		// Credentials and addresses are removed together with the identity.
+		receipt.Deleted["credentials"] = len(i.Credentials)
+		receipt.Deleted["verifiable_addresses"] = len(i.VerifiableAddresses)
+		receipt.Deleted["recovery_addresses"] = len(i.RecoveryAddresses)
+		if err := p.DeleteIdentity(ctx, identityID); err != nil {
+			return err
+		}
+		receipt.Deleted["identities"] = 1
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	receipt.ErasedAt = time.Now().UTC()
+	return receipt, nil
+}
+
+// placeholders returns a comma-separated list of n query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// identityMessagesCondition returns the condition which matches the courier messages sent to the identity. Messages
+// which were queued before the courier recorded their identity are matched by the identity's current addresses.
+func identityMessagesCondition(i *identity.Identity) (string, []interface{}) {
+	recipients := identityRecipients(i)
+	if len(recipients) == 0 {
+		return "identity_id = ?", []interface{}{i.ID}
+	}
+	return fmt.Sprintf("(identity_id = ? OR recipient IN (%s))", placeholders(len(recipients))), append([]interface{}{i.ID}, recipients...)
+}
+
+// identityRecipients returns the addresses courier messages to the identity may have been sent to.
+func identityRecipients(i *identity.Identity) []interface{} {
+	seen := make(map[string]struct{})
+	recipients := make([]interface{}, 0, len(i.VerifiableAddresses)+len(i.RecoveryAddresses))
+	add := func(value string) {
+		if _, ok := seen[value]; ok || value == "" {
+			return
+		}
+		seen[value] = struct{}{}
+		recipients = append(recipients, value)
+	}
+
+	for _, a := range i.VerifiableAddresses {
+		add(a.Value)
+	}
+	for _, a := range i.RecoveryAddresses {
+		add(a.Value)
+	}
+	return recipients
+}
+
+func (p *Persister) ScheduleIdentityDeletion(ctx context.Context, d *identity.ScheduledDeletion) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ScheduleIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	d.NID = p.NetworkID(ctx)
+	return p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		if err := p.setIdentityState(ctx, tx, d.IdentityID, identity.StateInactive); err != nil {
+			return err
+		}
+		return sqlcon.HandleError(tx.Create(d))
+	})
+}
+
+func (p *Persister) GetScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) (_ *identity.ScheduledDeletion, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetScheduledIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	var d identity.ScheduledDeletion
+	if err := p.GetConnection(ctx).Where("identity_id = ? AND nid = ?", identityID, p.NetworkID(ctx)).First(&d); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return &d, nil
+}
+
+func (p *Persister) GetScheduledIdentityDeletionByToken(ctx context.Context, token string) (_ *identity.ScheduledDeletion, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetScheduledIdentityDeletionByToken")
+	defer otelx.End(span, &err)
+
+	var d identity.ScheduledDeletion
+	if err := p.GetConnection(ctx).Where("cancel_token_hash = ? AND nid = ?", identity.DeletionCancelTokenHash(token), p.NetworkID(ctx)).First(&d); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return &d, nil
+}
+
+func (p *Persister) CancelScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.CancelScheduledIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	return p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		var d identity.ScheduledDeletion
+		if err := tx.Where("identity_id = ? AND nid = ?", identityID, p.NetworkID(ctx)).First(&d); err != nil {
+			return sqlcon.HandleError(err)
+		}
+
+		if err := p.setIdentityState(ctx, tx, d.IdentityID, d.PreviousState); err != nil {
+			return err
+		}
+
+		//#nosec G201 -- TableName is static
+		return sqlcon.HandleError(tx.RawQuery(fmt.Sprintf(
+			"DELETE FROM %s WHERE id = ? AND nid = ?",
+			d.TableName(ctx),
+		), d.ID, d.NID).Exec())
+	})
+}
+
+func (p *Persister) DiscardScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) (_ bool, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.DiscardScheduledIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- TableName is static
+	count, err := p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"DELETE FROM %s WHERE identity_id = ? AND nid = ?",
+		new(identity.ScheduledDeletion).TableName(ctx),
+	), identityID, p.NetworkID(ctx)).ExecWithCount()
+	if err != nil {
+		return false, sqlcon.HandleError(err)
+	}
+	return count > 0, nil
+}
+
+func (p *Persister) ListDueIdentityDeletions(ctx context.Context, limit int, failedBefore time.Time) (_ []identity.ScheduledDeletion, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ListDueIdentityDeletions")
+	defer otelx.End(span, &err)
+
+	deletions := make([]identity.ScheduledDeletion, 0)
+	if err := p.GetConnection(ctx).
+		Where("nid = ? AND delete_after <= ? AND (failed_at IS NULL OR failed_at < ?)", p.NetworkID(ctx), time.Now().UTC(), failedBefore.UTC()).
+		Order("delete_after ASC").
+		Limit(limit).
+		All(&deletions); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return deletions, nil
+}
+
+func (p *Persister) FailScheduledIdentityDeletion(ctx context.Context, id uuid.UUID, reason string) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.FailScheduledIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	now := time.Now().UTC()
+	//#nosec G201 -- TableName is static
+	return sqlcon.HandleError(p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"UPDATE %s SET failed_at = ?, error = ?, updated_at = ? WHERE id = ? AND nid = ?",
+		new(identity.ScheduledDeletion).TableName(ctx),
+	), now, reason, now, id, p.NetworkID(ctx)).Exec())
+}
+
+// setIdentityState changes the identity's state without loading it.
+func (p *Persister) setIdentityState(ctx context.Context, tx *pop.Connection, identityID uuid.UUID, state identity.State) error {
+	now := time.Now().UTC()
+	//#nosec G201 -- TableName is static
+	count, err := tx.RawQuery(fmt.Sprintf(
+		"UPDATE %s SET state = ?, state_changed_at = ?, updated_at = ? WHERE id = ? AND nid = ?",
+		new(identity.Identity).TableName(ctx),
+	), state, now, now, identityID, p.NetworkID(ctx)).ExecWithCount()
+	if err != nil {
+		return sqlcon.HandleError(err)
+	} else if count == 0 {
+		return errors.WithStack(sqlcon.ErrNoRows)
+	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, identityID) })
+	return nil
+}
@@ -36,6 +36,17 @@ func (p *Persister) UpdateRegistrationFlow(ctx context.Context, r *registration.
 	return update.Generic(ctx, p.GetConnection(ctx), p.r.Tracer(ctx).Tracer(), cp)
 }
 
+func (p *Persister) SetRegistrationFlowIdentity(ctx context.Context, id, identityID uuid.UUID) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.SetRegistrationFlowIdentity")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- TableName is static
+	return sqlcon.HandleError(p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"UPDATE %s SET identity_id = ? WHERE id = ? AND nid = ?",
+		new(registration.Flow).TableName(ctx),
+	), identityID, id, p.NetworkID(ctx)).Exec())
+}
+
 func (p *Persister) GetRegistrationFlow(ctx context.Context, id uuid.UUID) (_ *registration.Flow, err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetRegistrationFlow")
 	defer otelx.End(span, &err)
@@ -0,0 +1,35 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/schemamigration"
+)
+
+var _ schemamigration.Persister = new(Persister)
+
+func (p *Persister) ListIdentityIDsBySchema(ctx context.Context, schemaID, version string, after uuid.UUID, limit int) (_ []uuid.UUID, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ListIdentityIDsBySchema")
+	defer otelx.End(span, &err)
+
+	ids := []uuid.UUID{}
+	//#nosec G201 -- TableName is static
+	if err := p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"SELECT id FROM %s WHERE nid = ? AND schema_id = ? AND schema_version = ? AND id > ? ORDER BY id ASC LIMIT %d",
+		new(identity.Identity).TableName(ctx), limit,
+	), p.NetworkID(ctx), schemaID, version, after).All(&ids); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	return ids, nil
+}
@@ -6,6 +6,9 @@ package sql
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gobuffalo/pop/v6"
@@ -16,9 +19,11 @@ import (
 
 	"github.com/ory/x/otelx"
 	"github.com/ory/x/pagination/keysetpagination"
+	"github.com/ory/x/pointerx"
 	"github.com/ory/x/sqlcon"
 	"github.com/ory/x/stringsx"
 	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/schema"
 	"my.com/secrets/internal/auth/domain/session"
 	"my.com/secrets/internal/auth/domain/x/events"
 )
@@ -28,10 +33,49 @@ var _ session.Persister = new(Persister)
 const (
 	SessionDeviceUserAgentMaxLength = 512
 	SessionDeviceLocationMaxLength  = 512
+	SessionDeviceCityMaxLength      = 255
+	SessionDeviceCountryMaxLength   = 8
 	paginationMaxItemsSize          = 1000
 	paginationDefaultItemsSize      = 250
 )
 
+// resolveDeviceLocation fills the city, country and ASN of the device which are not known yet from its IP address.
+// Errors are logged but do not prevent the session from being persisted.
+func (p *Persister) resolveDeviceLocation(ctx context.Context, device *session.Device) {
+	if device.IPAddress == nil {
+		return
+	}
+	ip := net.ParseIP(*device.IPAddress)
+	if ip == nil {
+		return
+	}
+
+	location, err := p.r.GeoIPResolver().Resolve(ctx, ip)
+	if err != nil {
+		p.r.Logger().WithError(err).Warn("Unable to resolve the location of the session device.")
+		return
+	}
+
+	if device.City == nil && location.City != "" {
+		device.City = pointerx.Ptr(location.City)
+	}
+	if device.Country == nil && location.Country != "" {
+		device.Country = pointerx.Ptr(location.Country)
+	}
+	if device.ASN == nil && location.ASN != 0 {
+		device.ASN = pointerx.Ptr(int64(location.ASN))
+	}
+	if device.Location == nil || *device.Location == "" {
+		var parts []string
+		for _, part := range []*string{device.City, device.Country} {
+			if part != nil && *part != "" {
+				parts = append(parts, *part)
+			}
+		}
+		device.Location = pointerx.Ptr(strings.Join(parts, ", "))
+	}
+}
+
 func (p *Persister) GetSession(ctx context.Context, sid uuid.UUID, expandables session.Expandables) (_ *session.Session, err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetSession")
 	defer otelx.End(span, &err)
@@ -60,6 +104,7 @@ func (p *Persister) GetSession(ctx context.Context, sid uuid.UUID, expandables s
 		s.Identity = i
 	}
 
+	s.SetIdleExpiry(ctx, p.r.Config())
 	s.Active = s.IsActive()
 	return &s, nil
 }
@@ -110,6 +155,7 @@ func (p *Persister) ListSessions(ctx context.Context, active *bool, paginatorOpt
 	}
 
 	for k := range s {
+		s[k].SetIdleExpiry(ctx, p.r.Config())
 		if s[k].Identity == nil {
 			continue
 		}
@@ -173,6 +219,10 @@ func (p *Persister) ListSessionsByIdentity(
 		return nil, 0, err
 	}
 
+	for k := range s {
+		s[k].SetIdleExpiry(ctx, p.r.Config())
+	}
+
 	return s, t, nil
 }
 
@@ -201,10 +251,15 @@ func (p *Persister) UpsertSession(ctx context.Context, s *session.Session) (err
 			if err := tx.Update(s); err != nil {
 				return sqlcon.HandleError(err)
 			}
+			p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, s.ID) })
 			trace.SpanFromContext(ctx).AddEvent(events.NewSessionChanged(ctx, string(s.AuthenticatorAssuranceLevel), s.ID, s.IdentityID))
 			return nil
 		}
 
+		if err := p.enforceMaxConcurrentSessions(ctx, tx, s); err != nil {
+			return err
+		}
+
 		// This must not be eager or identities will be created / updated
 		if err := sqlcon.HandleError(tx.Create(s)); err != nil {
 			return err
@@ -215,12 +270,19 @@ func (p *Persister) UpsertSession(ctx context.Context, s *session.Session) (err
 			device.SessionID = s.ID
 			device.NID = s.NID
 
+			p.resolveDeviceLocation(ctx, device)
 			if device.Location != nil {
 				device.Location = stringsx.GetPointer(stringsx.TruncateByteLen(*device.Location, SessionDeviceLocationMaxLength))
 			}
 			if device.UserAgent != nil {
 				device.UserAgent = stringsx.GetPointer(stringsx.TruncateByteLen(*device.UserAgent, SessionDeviceUserAgentMaxLength))
 			}
+			if device.City != nil {
+				device.City = stringsx.GetPointer(stringsx.TruncateByteLen(*device.City, SessionDeviceCityMaxLength))
+			}
+			if device.Country != nil {
+				device.Country = stringsx.GetPointer(stringsx.TruncateByteLen(*device.Country, SessionDeviceCountryMaxLength))
+			}
 
 			if err := p.DevicePersister.CreateDevice(ctx, device); err != nil {
 				return err
@@ -232,6 +294,94 @@ func (p *Persister) UpsertSession(ctx context.Context, s *session.Session) (err
 	}))
 }
 
+// enforceMaxConcurrentSessions makes room for the new session if the identity's active sessions would exceed the
+// configured limit, by either revoking existing sessions or rejecting the new one. The identity's row is locked
+// for the rest of the transaction so that concurrent logins can not exceed the limit.
+func (p *Persister) enforceMaxConcurrentSessions(ctx context.Context, tx *pop.Connection, s *session.Session) error {
+	if !s.Active {
+		return nil
+	}
+
+	schemaID := ""
+	if s.Identity != nil {
+		schemaID = s.Identity.SchemaID
+	} else {
+		i, err := p.PrivilegedPool.GetIdentity(ctx, s.IdentityID, identity.ExpandNothing)
+		if err != nil {
+			return err
+		}
+		schemaID = i.SchemaID
+	}
+
+	limit := p.r.Config().SessionMaxConcurrent(ctx, schemaID)
+	if limit <= 0 {
+		return nil
+	}
+
+	if tx.Dialect.Name() != "sqlite3" {
+		//#nosec G201 -- TableName is static
+		if err := tx.RawQuery(fmt.Sprintf(
+			"SELECT id FROM %s WHERE id = ? AND nid = ? FOR UPDATE",
+			new(identity.Identity).TableName(ctx),
+		), s.IdentityID, s.NID).Exec(); err != nil {
+			return sqlcon.HandleError(err)
+		}
+	}
+
+	var sessions []session.Session
+	if err := tx.Where("identity_id = ? AND nid = ? AND active = ? AND expires_at > ?", s.IdentityID, s.NID, true, time.Now().UTC()).All(&sessions); err != nil {
+		return sqlcon.HandleError(err)
+	}
+
+	active := make([]session.Session, 0, len(sessions))
+	for _, existing := range sessions {
+		if existing.SetIdleExpiry(ctx, p.r.Config()).IsActive() {
+			active = append(active, existing)
+		}
+	}
+
+	excess := len(active) - limit + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	policy := p.r.Config().SessionMaxConcurrentPolicy(ctx)
+	switch policy {
+	case session.MaxConcurrentPolicyReject:
+		return schema.NewMaxConcurrentSessionsError(limit)
+	case session.MaxConcurrentPolicyEvictLeastRecentlyUsed:
+		lastUsed := func(s session.Session) time.Time {
+			if s.LastSeenAt != nil {
+				return *s.LastSeenAt
+			}
+			return s.AuthenticatedAt
+		}
+		sort.SliceStable(active, func(i, j int) bool { return lastUsed(active[i]).Before(lastUsed(active[j])) })
+	default:
+		sort.SliceStable(active, func(i, j int) bool { return active[i].AuthenticatedAt.Before(active[j].AuthenticatedAt) })
+	}
+
+	evicted := make([]uuid.UUID, excess)
+	for k := range evicted {
+		evicted[k] = active[k].ID
+	}
+
+	//#nosec G201 -- TableName is static
+	if err := tx.RawQuery(fmt.Sprintf(
+		"UPDATE %s SET active = false WHERE nid = ? AND id IN (?)",
+		new(session.Session).TableName(ctx),
+	), s.NID, evicted).Exec(); err != nil {
+		return sqlcon.HandleError(err)
+	}
+
+	for _, id := range evicted {
+		p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, id) })
+		trace.SpanFromContext(ctx).AddEvent(events.NewSessionEvicted(ctx, id, s.IdentityID, policy))
+	}
+
+	return nil
+}
+
 func (p *Persister) DeleteSession(ctx context.Context, sid uuid.UUID) (err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.DeleteSession")
 	defer otelx.End(span, &err)
@@ -245,6 +395,7 @@ func (p *Persister) DeleteSession(ctx context.Context, sid uuid.UUID) (err error
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, sid) })
 	if count == 0 {
 		return errors.WithStack(sqlcon.ErrNoRows)
 	}
@@ -266,6 +417,7 @@ func (p *Persister) DeleteSessionsByIdentity(ctx context.Context, identityID uui
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, identityID) })
 	if count == 0 {
 		return errors.WithStack(sqlcon.ErrNoRows)
 	}
@@ -313,6 +465,7 @@ func (p *Persister) GetSessionByToken(ctx context.Context, token string, expand
 
 	s.Identity = i
 	s.Devices = sd
+	s.SetIdleExpiry(ctx, p.r.Config())
 
 	return &s, nil
 }
@@ -332,6 +485,7 @@ func (p *Persister) DeleteSessionByToken(ctx context.Context, token string) (err
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateToken(ctx, token) })
 	if count == 0 {
 		return errors.WithStack(sqlcon.ErrNoRows)
 	}
@@ -353,12 +507,29 @@ func (p *Persister) RevokeSessionByToken(ctx context.Context, token string) (err
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateToken(ctx, token) })
 	if count == 0 {
 		return errors.WithStack(sqlcon.ErrNoRows)
 	}
 	return nil
 }
 
+// TouchSession records when the session was last seen without invalidating cached copies of it.
+func (p *Persister) TouchSession(ctx context.Context, sID uuid.UUID, lastSeenAt time.Time) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.TouchSession")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- TableName is static
+	return sqlcon.HandleError(p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"UPDATE %s SET last_seen_at = ? WHERE id = ? AND nid = ?",
+		new(session.Session).TableName(ctx),
+	),
+		lastSeenAt.UTC(),
+		sID,
+		p.NetworkID(ctx),
+	).Exec())
+}
+
 // RevokeSessionById revokes a given session
 func (p *Persister) RevokeSessionById(ctx context.Context, sID uuid.UUID) (err error) {
 	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.RevokeSessionById")
@@ -375,6 +546,7 @@ func (p *Persister) RevokeSessionById(ctx context.Context, sID uuid.UUID) (err e
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, sID) })
 	if count == 0 {
 		return errors.WithStack(sqlcon.ErrNoRows)
 	}
@@ -399,6 +571,7 @@ func (p *Persister) RevokeSession(ctx context.Context, iID, sID uuid.UUID) (err
 	if err != nil {
 		return sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, sID) })
 	return nil
 }
 
@@ -419,6 +592,7 @@ func (p *Persister) RevokeSessionsIdentityExcept(ctx context.Context, iID, sID u
 	if err != nil {
 		return 0, sqlcon.HandleError(err)
 	}
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateIdentity(ctx, iID) })
 	return count, nil
 }
 
@@ -441,3 +615,183 @@ func (p *Persister) DeleteExpiredSessions(ctx context.Context, expiresAt time.Ti
 	}
 	return nil
 }
+
+func (p *Persister) UseDPoPProof(ctx context.Context, id string, expiresAt time.Time) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.UseDPoPProof")
+	defer otelx.End(span, &err)
+
+	if err := p.GetConnection(ctx).RawQuery(
+		"INSERT INTO session_dpop_proofs (id, nid, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		id,
+		p.NetworkID(ctx),
+		expiresAt.UTC(),
+		time.Now().UTC(),
+	).Exec(); err != nil {
+		return sqlcon.HandleError(err)
+	}
+	return nil
+}
+
+func (p *Persister) DeleteExpiredDPoPProofs(ctx context.Context, expiresAt time.Time, limit int) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.DeleteExpiredDPoPProofs")
+	defer otelx.End(span, &err)
+
+	//#nosec G201 -- limit is an integer
+	err = p.GetConnection(ctx).RawQuery(fmt.Sprintf(
+		"DELETE FROM session_dpop_proofs WHERE id in (SELECT id FROM (SELECT id FROM session_dpop_proofs c WHERE expires_at <= ? and nid = ? ORDER BY expires_at ASC LIMIT %d ) AS s ) AND nid = ?",
+		limit,
+	),
+		expiresAt,
+		p.NetworkID(ctx),
+		p.NetworkID(ctx),
+	).Exec()
+	if err != nil {
+		return sqlcon.HandleError(err)
+	}
+	return nil
+}
+
+func (p *Persister) IssueRefreshToken(ctx context.Context, s *session.Session, t *session.RefreshToken) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.IssueRefreshToken")
+	defer otelx.End(span, &err)
+
+	nid := p.NetworkID(ctx)
+	now := time.Now().UTC()
+	var lastSeenAt, tokenExpiresAt *time.Time
+	if s.LastSeenAt != nil {
+		lastSeenAt = pointerx.Ptr(s.LastSeenAt.UTC())
+	}
+	if s.TokenExpiresAt != nil {
+		tokenExpiresAt = pointerx.Ptr(s.TokenExpiresAt.UTC())
+	}
+
+	if err := p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		// The number of affected rows is not reliable here, because MySQL does not count rows whose values did not
+		// change.
+		if exists, err := tx.Where("id = ? AND nid = ?", s.ID, nid).Exists(new(session.Session)); err != nil {
+			return sqlcon.HandleError(err)
+		} else if !exists {
+			return errors.WithStack(sqlcon.ErrNoRows)
+		}
+
+		//#nosec G201 -- TableName is static
+		if err := tx.RawQuery(fmt.Sprintf(
+			"UPDATE %s SET token = ?, token_expires_at = ?, last_seen_at = ? WHERE id = ? AND nid = ?",
+			new(session.Session).TableName(ctx),
+		),
+			s.Token,
+			tokenExpiresAt,
+			lastSeenAt,
+			s.ID,
+			nid,
+		).Exec(); err != nil {
+			return sqlcon.HandleError(err)
+		}
+
+		//#nosec G201 -- TableName is static
+		if err := tx.RawQuery(fmt.Sprintf(
+			"UPDATE %s SET used_at = ?, updated_at = ? WHERE session_id = ? AND nid = ? AND used_at IS NULL",
+			new(session.RefreshToken).TableName(ctx),
+		),
+			now,
+			now,
+			s.ID,
+			nid,
+		).Exec(); err != nil {
+			return sqlcon.HandleError(err)
+		}
+
+		t.NID = nid
+		t.SessionID = s.ID
+		return sqlcon.HandleError(tx.Create(t))
+	}); err != nil {
+		return err
+	}
+
+	p.afterCommit(ctx, func() { p.r.SessionCache().InvalidateSession(ctx, s.ID) })
+	return nil
+}
+
+func (p *Persister) RefreshSession(ctx context.Context, signature string, reuseGrace time.Duration, refresh func(ctx context.Context, t *session.RefreshToken) (*session.Session, *session.RefreshToken, error)) (_ *session.RefreshToken, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.RefreshSession")
+	defer otelx.End(span, &err)
+
+	nid := p.NetworkID(ctx)
+	var t session.RefreshToken
+	if err := p.GetConnection(ctx).Where("signature = ? AND nid = ?", signature, nid).First(&t); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+
+	var reused bool
+	if err := p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		// Refreshes of the same session are serialized, so that each one sees which refresh tokens the others
+		// used. The session's row is locked before anything else is read, because MySQL would otherwise read
+		// from a snapshot taken before the lock was acquired. SQLite serializes write transactions on its own.
+		if tx.Dialect.Name() != "sqlite3" {
+			//#nosec G201 -- TableName is static
+			if err := tx.RawQuery(fmt.Sprintf("SELECT id FROM %s WHERE id = ? AND nid = ? FOR UPDATE", new(session.Session).TableName(ctx)), t.SessionID, nid).Exec(); err != nil {
+				return sqlcon.HandleError(err)
+			}
+		}
+		if err := tx.Where("id = ? AND nid = ?", t.ID, nid).First(&t); err != nil {
+			return sqlcon.HandleError(err)
+		}
+
+		now := time.Now().UTC()
+		if t.UsedAt == nil {
+			// The condition on used_at ensures that only one of several concurrent requests can use the token.
+			//#nosec G201 -- TableName is static
+			count, err := tx.RawQuery(fmt.Sprintf(
+				"UPDATE %s SET used_at = ?, updated_at = ? WHERE id = ? AND nid = ? AND used_at IS NULL",
+				t.TableName(ctx),
+			),
+				now,
+				now,
+				t.ID,
+				nid,
+			).ExecWithCount()
+			if err != nil {
+				return sqlcon.HandleError(err)
+			} else if count == 0 {
+				reused = true
+				return errors.WithStack(session.ErrRefreshTokenReused)
+			}
+			t.UsedAt = &now
+		} else if reuseGrace <= 0 || now.Sub(*t.UsedAt) >= reuseGrace {
+			reused = true
+			return errors.WithStack(session.ErrRefreshTokenReused)
+		} else {
+			// The client may not have received the refresh token which was issued for this one. It is removed if it
+			// was not used yet, so that only the one which is issued now can be used.
+			//#nosec G201 -- TableName is static
+			count, err := tx.RawQuery(fmt.Sprintf(
+				"DELETE FROM %s WHERE session_id = ? AND nid = ? AND previous_id = ? AND used_at IS NULL",
+				t.TableName(ctx),
+			),
+				t.SessionID,
+				nid,
+				t.ID,
+			).ExecWithCount()
+			if err != nil {
+				return sqlcon.HandleError(err)
+			} else if count == 0 {
+				reused = true
+				return errors.WithStack(session.ErrRefreshTokenReused)
+			}
+		}
+
+		s, next, err := refresh(ctx, &t)
+		if err != nil {
+			return err
+		}
+		next.PreviousID = uuid.NullUUID{UUID: t.ID, Valid: true}
+		return p.IssueRefreshToken(ctx, s, next)
+	}); err != nil {
+		if reused {
+			return &t, err
+		}
+		return nil, err
+	}
+
+	return &t, nil
+}
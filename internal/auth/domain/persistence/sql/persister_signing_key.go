@@ -0,0 +1,128 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/networkx"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/jwk"
+	"my.com/secrets/internal/auth/domain/persistence/sql/update"
+)
+
+var _ jwk.Persister = new(Persister)
+
+func (p *Persister) SaveSigningKeys(ctx context.Context, created []*jwk.SigningKey, updated []*jwk.SigningKey) (err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.SaveSigningKeys")
+	defer otelx.End(span, &err)
+
+	nid := p.NetworkID(ctx)
+	return p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		for _, k := range created {
+			k.NID = nid
+			if err := tx.Create(k); err != nil {
+				return sqlcon.HandleError(err)
+			}
+		}
+
+		for _, k := range updated {
+			k.NID = nid
+			k.UpdatedAt = time.Now().UTC()
+			if err := update.Generic(ctx, tx, p.r.Tracer(ctx).Tracer(), k,
+				"private_key", "state", "activated_at", "retired_at", "revoked_at", "updated_at"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Persister) UpdateSigningKeys(ctx context.Context, alias string, update func(keys []jwk.SigningKey) (created []*jwk.SigningKey, updated []*jwk.SigningKey, err error)) (_ []jwk.SigningKey, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.UpdateSigningKeys")
+	defer otelx.End(span, &err)
+
+	var keys []jwk.SigningKey
+	if err := p.Transaction(ctx, func(ctx context.Context, tx *pop.Connection) error {
+		// Key sets which do not have any keys yet have no rows to lock, so the network's row serializes the
+		// updates of all key sets instead. SQLite serializes write transactions on its own.
+		if tx.Dialect.Name() != "sqlite3" {
+			//#nosec G201 -- TableName is static
+			if err := tx.RawQuery(fmt.Sprintf("SELECT id FROM %s WHERE id = ? FOR UPDATE", new(networkx.Network).TableName()), p.NetworkID(ctx)).Exec(); err != nil {
+				return sqlcon.HandleError(err)
+			}
+		}
+
+		current, err := p.ListSigningKeys(ctx, alias)
+		if err != nil {
+			return err
+		}
+
+		created, updated, err := update(current)
+		if err != nil {
+			return err
+		} else if len(created) == 0 && len(updated) == 0 {
+			keys = current
+			return nil
+		}
+
+		if err := p.SaveSigningKeys(ctx, created, updated); err != nil {
+			return err
+		}
+		keys, err = p.ListSigningKeys(ctx, alias)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (p *Persister) GetSigningKey(ctx context.Context, id uuid.UUID) (_ *jwk.SigningKey, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.GetSigningKey")
+	defer otelx.End(span, &err)
+
+	var k jwk.SigningKey
+	if err := p.GetConnection(ctx).Where("id = ? AND nid = ?", id, p.NetworkID(ctx)).First(&k); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return &k, nil
+}
+
+func (p *Persister) ListSigningKeys(ctx context.Context, alias string) (_ []jwk.SigningKey, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ListSigningKeys")
+	defer otelx.End(span, &err)
+
+	q := p.GetConnection(ctx).Where("nid = ?", p.NetworkID(ctx))
+	if alias != "" {
+		q = q.Where("alias = ?", alias)
+	}
+
+	keys := make([]jwk.SigningKey, 0)
+	if err := q.Order("created_at DESC, id DESC").All(&keys); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return keys, nil
+}
+
+func (p *Persister) ListPublishedSigningKeys(ctx context.Context, retiredAfter time.Time) (_ []jwk.SigningKey, err error) {
+	ctx, span := p.r.Tracer(ctx).Tracer().Start(ctx, "persistence.sql.ListPublishedSigningKeys")
+	defer otelx.End(span, &err)
+
+	keys := make([]jwk.SigningKey, 0)
+	if err := p.GetConnection(ctx).
+		Where("nid = ? AND (state IN (?, ?) OR (state = ? AND retired_at > ?))",
+			p.NetworkID(ctx), jwk.StateActive, jwk.StateNext, jwk.StateRetired, retiredAfter.UTC()).
+		Order("created_at DESC, id DESC").
+		All(&keys); err != nil {
+		return nil, sqlcon.HandleError(err)
+	}
+	return keys, nil
+}
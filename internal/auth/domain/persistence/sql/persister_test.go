@@ -33,8 +33,11 @@ import (
 	"my.com/secrets/internal/auth/domain/external/testhelpers"
 	ri "my.com/secrets/internal/auth/domain/identity"
 	identity "my.com/secrets/internal/auth/domain/identity/test"
+	job "my.com/secrets/internal/auth/domain/job/test"
+	jwk "my.com/secrets/internal/auth/domain/jwk/test"
 	"my.com/secrets/internal/auth/domain/persistence/sql"
 	sqltesthelpers "my.com/secrets/internal/auth/domain/persistence/sql/testhelpers"
+	privacy "my.com/secrets/internal/auth/domain/privacy/test"
 	"my.com/secrets/internal/auth/domain/schema"
 	errorx "my.com/secrets/internal/auth/domain/selfservice/errorx/test"
 	lf "my.com/secrets/internal/auth/domain/selfservice/flow/login"
@@ -267,6 +270,18 @@ func TestPersister(t *testing.T) {
 				pop.SetLogger(pl(t))
 				continuity.TestPersister(ctx, p)(t)
 			})
+			t.Run("contract=job.TestPersister", func(t *testing.T) {
+				pop.SetLogger(pl(t))
+				job.TestPersister(ctx, p)(t)
+			})
+			t.Run("contract=jwk.TestPersister", func(t *testing.T) {
+				pop.SetLogger(pl(t))
+				jwk.TestPersister(ctx, p)(t)
+			})
+			t.Run("contract=privacy.TestPersister", func(t *testing.T) {
+				pop.SetLogger(pl(t))
+				privacy.TestPersister(ctx, conf, p)(t)
+			})
 		})
 	}
 }
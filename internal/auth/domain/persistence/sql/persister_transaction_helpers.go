@@ -5,18 +5,59 @@ package sql
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ory/x/popx"
 
 	"github.com/gobuffalo/pop/v6"
 )
 
+type afterCommitContextKey struct{}
+
+// afterCommitHooks collects the functions which run once the outermost transaction was committed.
+type afterCommitHooks struct {
+	sync.Mutex
+	hooks []func()
+}
+
 func WithTransaction(ctx context.Context, tx *pop.Connection) context.Context {
 	return popx.WithTransaction(ctx, tx)
 }
 
 func (p *Persister) Transaction(ctx context.Context, callback func(ctx context.Context, connection *pop.Connection) error) error {
-	return popx.Transaction(ctx, p.c.WithContext(ctx), callback)
+	if _, ok := ctx.Value(afterCommitContextKey{}).(*afterCommitHooks); ok {
+		return popx.Transaction(ctx, p.c.WithContext(ctx), callback)
+	}
+
+	hooks := new(afterCommitHooks)
+	ctx = context.WithValue(ctx, afterCommitContextKey{}, hooks)
+	if err := popx.Transaction(ctx, p.c.WithContext(ctx), callback); err != nil {
+		return err
+	}
+
+	hooks.Lock()
+	committed := hooks.hooks
+	hooks.Unlock()
+	for _, f := range committed {
+		f()
+	}
+	return nil
+}
+
+// afterCommit runs f once the transaction of the context was committed, or immediately if the context is not in a
+// transaction. Use it for side effects which other instances observe, such as session cache invalidations, so
+// that they do not act on data which is rolled back or not yet visible. If a transaction is retried, the functions
+// of all attempts run, so they must be idempotent.
+func (p *Persister) afterCommit(ctx context.Context, f func()) {
+	hooks, ok := ctx.Value(afterCommitContextKey{}).(*afterCommitHooks)
+	if !ok {
+		f()
+		return
+	}
+
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.hooks = append(hooks.hooks, f)
 }
 
 func (p *Persister) GetConnection(ctx context.Context) *pop.Connection {
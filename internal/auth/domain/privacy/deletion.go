@@ -0,0 +1,248 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/urlx"
+
+	"my.com/secrets/internal/auth/domain/courier/template/email"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+// dueDeletionsBatchSize is the number of due deletions the worker processes per run.
+const dueDeletionsBatchSize = 100
+
+var _ identity.DeletionScheduler = new(Manager)
+
+// ScheduleIdentityDeletion deactivates the identity, revokes its sessions, notifies the identity via email, and
+// schedules its erasure once the configured grace period has passed. All of this happens in a single transaction,
+// so that the deletion is not scheduled if the identity can not be notified.
+func (m *Manager) ScheduleIdentityDeletion(ctx context.Context, identityID uuid.UUID, initiator identity.DeletionInitiator) (_ *identity.ScheduledDeletion, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.ScheduleIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	var d *identity.ScheduledDeletion
+	var scheduled bool
+	if err := m.r.PrivacyPersister().Transaction(ctx, func(ctx context.Context, _ *pop.Connection) error {
+		existing, err := m.r.PrivacyPersister().GetScheduledIdentityDeletion(ctx, identityID)
+		if err == nil {
+			d = existing
+			return nil
+		} else if !errors.Is(err, sqlcon.ErrNoRows) {
+			return err
+		}
+
+		i, err := m.r.PrivilegedIdentityPool().GetIdentity(ctx, identityID, identity.ExpandDefault)
+		if err != nil {
+			return err
+		}
+
+		d = identity.NewScheduledDeletion(i, initiator, m.r.Config().IdentityDeletionGracePeriod(ctx))
+		if err := m.r.PrivacyPersister().ScheduleIdentityDeletion(ctx, d); err != nil {
+			return err
+		}
+
+		if _, err := m.r.SessionPersister().RevokeSessionsIdentityExcept(ctx, identityID, uuid.Nil); err != nil {
+			return err
+		}
+
+		scheduled = true
+		return m.notifyScheduledDeletion(ctx, i, d)
+	}); err != nil {
+		return nil, err
+	}
+
+	if scheduled {
+		m.r.Audit().
+			WithField("identity_id", identityID).
+			WithField("initiator", initiator).
+			WithField("delete_after", d.DeleteAfter).
+			Info("The deletion of an identity was scheduled.")
+	}
+	return d, nil
+}
+
+// ActivateIdentity calls update, which sets the identity's state to active, and discards the identity's scheduled
+// deletion in the same transaction, so that an identity which an administrator activates again is not erased once
+// the grace period of its deletion has passed.
+func (m *Manager) ActivateIdentity(ctx context.Context, identityID uuid.UUID, update func(ctx context.Context) error) (err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.ActivateIdentity")
+	defer otelx.End(span, &err)
+
+	var discarded bool
+	if err := m.r.PrivacyPersister().Transaction(ctx, func(ctx context.Context, _ *pop.Connection) error {
+		if err := update(ctx); err != nil {
+			return err
+		}
+
+		discarded, err = m.r.PrivacyPersister().DiscardScheduledIdentityDeletion(ctx, identityID)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if discarded {
+		m.r.Audit().
+			WithField("identity_id", identityID).
+			Info("The scheduled deletion of an identity was cancelled because the identity was activated.")
+	}
+	return nil
+}
+
+func (m *Manager) notifyScheduledDeletion(ctx context.Context, i *identity.Identity, d *identity.ScheduledDeletion) error {
+	model, err := x.StructToMap(i)
+	if err != nil {
+		return err
+	}
+
+	cancelURL := urlx.CopyWithQuery(
+		urlx.AppendPaths(m.r.Config().SelfPublicURL(ctx), RouteCancelScheduledDeletion),
+		url.Values{"token": {d.CancelToken}},
+	).String()
+
+	c, err := m.r.Courier(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range i.VerifiableAddresses {
+		if address.Via != identity.AddressTypeEmail {
+			continue
+		}
+
+		if _, err := c.QueueEmail(ctx, email.NewAccountDeletionScheduled(m.r, &email.AccountDeletionScheduledModel{
+			To:          address.Value,
+			CancelURL:   cancelURL,
+			DeleteAfter: d.DeleteAfter,
+			Identity:    model,
+		})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CancelIdentityDeletion cancels the identity's scheduled deletion and restores the identity's previous state.
+func (m *Manager) CancelIdentityDeletion(ctx context.Context, identityID uuid.UUID) (err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.CancelIdentityDeletion")
+	defer otelx.End(span, &err)
+
+	if err := m.r.PrivacyPersister().CancelScheduledIdentityDeletion(ctx, identityID); err != nil {
+		return err
+	}
+
+	m.r.Audit().
+		WithField("identity_id", identityID).
+		Info("The scheduled deletion of an identity was cancelled.")
+	return nil
+}
+
+// GetScheduledDeletionByToken returns the scheduled deletion which belongs to the cancellation token sent to the
+// identity, as long as it can still be cancelled.
+func (m *Manager) GetScheduledDeletionByToken(ctx context.Context, token string) (_ *identity.ScheduledDeletion, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.GetScheduledDeletionByToken")
+	defer otelx.End(span, &err)
+
+	d, err := m.r.PrivacyPersister().GetScheduledIdentityDeletionByToken(ctx, token)
+	if errors.Is(err, sqlcon.ErrNoRows) {
+		return nil, errors.WithStack(herodot.ErrNotFound.WithReason("The account deletion cancellation link is invalid or has already been used."))
+	} else if err != nil {
+		return nil, err
+	}
+
+	if d.IsDue() {
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReason("The grace period of the account deletion has passed and it can no longer be cancelled."))
+	}
+	return d, nil
+}
+
+// CancelIdentityDeletionByToken cancels the scheduled deletion which belongs to the cancellation token
+// sent to the identity.
+func (m *Manager) CancelIdentityDeletionByToken(ctx context.Context, token string) (_ *identity.ScheduledDeletion, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.CancelIdentityDeletionByToken")
+	defer otelx.End(span, &err)
+
+	d, err := m.GetScheduledDeletionByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.CancelIdentityDeletion(ctx, d.IdentityID); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ExecuteDueDeletions erases all identities whose deletion grace period has passed and returns the number of
+// erased identities. Deletions which fail are recorded and retried on the next run.
+func (m *Manager) ExecuteDueDeletions(ctx context.Context) (_ int, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.ExecuteDueDeletions")
+	defer otelx.End(span, &err)
+
+	// Timestamps may lose their fractional seconds in the database.
+	started := time.Now().UTC().Truncate(time.Second)
+
+	var count int
+	for {
+		deletions, err := m.r.PrivacyPersister().ListDueIdentityDeletions(ctx, dueDeletionsBatchSize, started)
+		if err != nil {
+			return count, err
+		}
+
+		for _, d := range deletions {
+			if _, err := m.Erase(ctx, d.IdentityID); err != nil {
+				m.r.Logger().
+					WithField("identity_id", d.IdentityID).
+					WithError(err).
+					Error("Unable to erase an identity whose deletion is due.")
+				if err := m.r.PrivacyPersister().FailScheduledIdentityDeletion(ctx, d.ID, err.Error()); err != nil {
+					return count, err
+				}
+				continue
+			}
+			count++
+		}
+
+		if len(deletions) < dueDeletionsBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// WatchScheduledDeletions periodically erases identities whose deletion grace period has passed until the
+// context is cancelled.
+func (m *Manager) WatchScheduledDeletions(ctx context.Context) error {
+	ticker := time.NewTicker(m.r.Config().IdentityDeletionCheckInterval(ctx))
+	defer ticker.Stop()
+
+	for {
+		if count, err := m.ExecuteDueDeletions(ctx); err != nil {
+			m.r.Logger().WithError(err).Error("Unable to execute scheduled identity deletions.")
+		} else if count > 0 {
+			m.r.Logger().WithField("count", count).Info("Executed scheduled identity deletions.")
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/x/sqlcon"
+	"my.com/secrets/internal/auth/domain/courier/template"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestScheduledDeletion(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	publicTS, adminTS := testhelpers.NewKratosServerWithCSRF(t, reg)
+	conf.MustSet(ctx, config.ViperKeyAdminBaseURL, adminTS.URL)
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, publicTS.URL)
+	conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "1h")
+
+	createIdentity := func(t *testing.T) (*identity.Identity, *session.Session) {
+		email := x.NewUUID().String() + "@ory.sh"
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		i.Traits = identity.Traits(`{"email":"` + email + `"}`)
+		i.VerifiableAddresses = []identity.VerifiableAddress{*identity.NewVerifiableEmailAddress(email, i.ID)}
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+
+		s, err := session.NewActiveSession(&http.Request{}, i, conf, time.Now().UTC(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		return i, s
+	}
+
+	getState := func(t *testing.T, i *identity.Identity) identity.State {
+		actual, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, i.ID, identity.ExpandNothing)
+		require.NoError(t, err)
+		return actual.State
+	}
+
+	t.Run("case=admin deletion is scheduled", func(t *testing.T) {
+		i, s := createIdentity(t)
+
+		res := do(t, adminTS, "DELETE", "/identities/"+i.ID.String(), http.StatusAccepted)
+		assert.Equal(t, i.ID.String(), res.Get("identity_id").String(), "%s", res.Raw)
+		assert.Equal(t, string(identity.DeletionInitiatorAdmin), res.Get("initiator").String(), "%s", res.Raw)
+		assert.False(t, res.Get("cancel_token").Exists(), "%s", res.Raw)
+
+		assert.Equal(t, identity.StateInactive, getState(t, i))
+
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.False(t, actual.Active)
+
+		export, err := reg.PrivacyManager().Export(ctx, i.ID)
+		require.NoError(t, err)
+		require.Len(t, export.CourierMessages, 1)
+		assert.Equal(t, template.TypeAccountDeletionScheduled, export.CourierMessages[0].TemplateType)
+		assert.Equal(t, i.VerifiableAddresses[0].Value, export.CourierMessages[0].Recipient)
+
+		t.Run("case=scheduling again returns the existing schedule", func(t *testing.T) {
+			again := do(t, adminTS, "DELETE", "/identities/"+i.ID.String(), http.StatusAccepted)
+			assert.Equal(t, res.Get("id").String(), again.Get("id").String())
+		})
+
+		t.Run("case=schedule can be fetched", func(t *testing.T) {
+			actual := do(t, adminTS, "GET", "/identities/"+i.ID.String()+"/deletion", http.StatusOK)
+			assert.Equal(t, res.Get("id").String(), actual.Get("id").String())
+			actual = do(t, publicTS, "GET", x.AdminPrefix+"/identities/"+i.ID.String()+"/deletion", http.StatusOK)
+			assert.Equal(t, res.Get("id").String(), actual.Get("id").String())
+		})
+
+		t.Run("case=admin cancels the deletion", func(t *testing.T) {
+			do(t, adminTS, "DELETE", "/identities/"+i.ID.String()+"/deletion", http.StatusNoContent)
+			assert.Equal(t, identity.StateActive, getState(t, i))
+			do(t, adminTS, "GET", "/identities/"+i.ID.String()+"/deletion", http.StatusNotFound)
+			do(t, adminTS, "DELETE", "/identities/"+i.ID.String()+"/deletion", http.StatusNotFound)
+		})
+	})
+
+	t.Run("case=activating the identity cancels its deletion", func(t *testing.T) {
+		for method, body := range map[string]string{
+			"PATCH": `[{"op":"replace","path":"/state","value":"active"}]`,
+			"PUT":   `{"schema_id":"default","state":"active","traits":{}}`,
+		} {
+			t.Run("method="+method, func(t *testing.T) {
+				i, _ := createIdentity(t)
+				_, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorAdmin)
+				require.NoError(t, err)
+
+				if method == "PUT" {
+					body = strings.Replace(body, "{}", string(i.Traits), 1)
+				}
+				req, err := http.NewRequest(method, adminTS.URL+"/identities/"+i.ID.String(), strings.NewReader(body))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/json")
+				res, err := adminTS.Client().Do(req)
+				require.NoError(t, err)
+				require.NoError(t, res.Body.Close())
+				require.Equal(t, http.StatusOK, res.StatusCode)
+
+				assert.Equal(t, identity.StateActive, getState(t, i))
+				do(t, adminTS, "GET", "/identities/"+i.ID.String()+"/deletion", http.StatusNotFound)
+			})
+		}
+	})
+
+	t.Run("case=nothing is scheduled if the identity can not be notified", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyCourierTemplatesAccountDeletionScheduledEmail+".subject", "base64://"+base64.StdEncoding.EncodeToString([]byte("{{ .Broken")))
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyCourierTemplatesAccountDeletionScheduledEmail+".subject", "") })
+
+		i, s := createIdentity(t)
+		_, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorAdmin)
+		require.Error(t, err)
+
+		assert.Equal(t, identity.StateActive, getState(t, i))
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.True(t, actual.Active)
+		do(t, adminTS, "GET", "/identities/"+i.ID.String()+"/deletion", http.StatusNotFound)
+	})
+
+	t.Run("case=unknown identity", func(t *testing.T) {
+		do(t, adminTS, "DELETE", "/identities/"+x.NewUUID().String(), http.StatusNotFound)
+	})
+
+	cancel := func(t *testing.T, token string, expectCode int) gjson.Result {
+		t.Helper()
+		req, err := http.NewRequest("POST", publicTS.URL+privacy.RouteCancelScheduledDeletion, strings.NewReader(`{"token":"`+token+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		res, err := publicTS.Client().Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body := x.MustReadAll(res.Body)
+		assert.EqualValuesf(t, expectCode, res.StatusCode, "%s", body)
+		return gjson.ParseBytes(body)
+	}
+
+	t.Run("case=user cancels the deletion with the emailed link", func(t *testing.T) {
+		i, _ := createIdentity(t)
+		d, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorSelfService)
+		require.NoError(t, err)
+
+		href := privacy.RouteCancelScheduledDeletion + "?" + url.Values{"token": {d.CancelToken}}.Encode()
+		res := do(t, publicTS, "GET", href, http.StatusOK)
+		assert.Equal(t, d.ID.String(), res.Get("id").String(), "%s", res.Raw)
+		assert.Equal(t, identity.StateInactive, getState(t, i), "opening the link must not cancel the deletion")
+
+		res = cancel(t, d.CancelToken, http.StatusOK)
+		assert.Equal(t, d.ID.String(), res.Get("id").String(), "%s", res.Raw)
+		assert.Equal(t, identity.StateActive, getState(t, i))
+
+		do(t, publicTS, "GET", href, http.StatusNotFound)
+		cancel(t, d.CancelToken, http.StatusNotFound)
+	})
+
+	t.Run("case=browser confirms the cancellation and is redirected", func(t *testing.T) {
+		returnTo := "https://www.ory.sh/return"
+		conf.MustSet(ctx, config.ViperKeySelfServiceBrowserDefaultReturnTo, returnTo)
+
+		i, _ := createIdentity(t)
+		d, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorSelfService)
+		require.NoError(t, err)
+
+		c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		req, err := http.NewRequest("GET", publicTS.URL+privacy.RouteCancelScheduledDeletion+"?"+url.Values{"token": {d.CancelToken}}.Encode(), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/html")
+		res, err := c.Do(req)
+		require.NoError(t, err)
+		page := string(x.MustReadAll(res.Body))
+		_ = res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, page)
+		assert.Contains(t, page, `method="POST"`)
+		assert.Contains(t, page, `value="`+d.CancelToken+`"`)
+		assert.Equal(t, identity.StateInactive, getState(t, i))
+
+		req, err = http.NewRequest("POST", publicTS.URL+privacy.RouteCancelScheduledDeletion, strings.NewReader(url.Values{"token": {d.CancelToken}}.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "text/html")
+		res, err = c.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+		assert.Equal(t, returnTo, res.Header.Get("Location"))
+		assert.Equal(t, identity.StateActive, getState(t, i))
+	})
+
+	t.Run("case=due deletions are executed", func(t *testing.T) {
+		i, _ := createIdentity(t)
+		notDue, _ := createIdentity(t)
+
+		_, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, notDue.ID, identity.DeletionInitiatorSelfService)
+		require.NoError(t, err)
+
+		conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "1ns")
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "1h")
+		})
+		d, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorSelfService)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		require.True(t, d.IsDue())
+
+		count, err := reg.PrivacyManager().ExecuteDueDeletions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		_, err = reg.PrivilegedIdentityPool().GetIdentity(ctx, i.ID, identity.ExpandNothing)
+		require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		assert.Equal(t, identity.StateInactive, getState(t, notDue))
+
+		jobs, err := reg.JobPersister().ListJobs(ctx, privacy.JobTypeErasure, 0, 100)
+		require.NoError(t, err)
+		require.NotEmpty(t, jobs)
+		assert.Equal(t, i.ID, jobs[0].Subject.UUID)
+	})
+
+	t.Run("case=due deletions can no longer be cancelled", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "1ns")
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "1h")
+		})
+
+		i, _ := createIdentity(t)
+		d, err := reg.PrivacyManager().ScheduleIdentityDeletion(ctx, i.ID, identity.DeletionInitiatorSelfService)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+
+		do(t, publicTS, "GET", privacy.RouteCancelScheduledDeletion+"?"+url.Values{"token": {d.CancelToken}}.Encode(), http.StatusBadRequest)
+		cancel(t, d.CancelToken, http.StatusBadRequest)
+		assert.Equal(t, identity.StateInactive, getState(t, i))
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"my.com/secrets/internal/auth/domain/job"
+)
+
+// JobTypeErasure is the job type of identity data erasures.
+const JobTypeErasure job.Type = "identity_erasure"
+
+// Identity Data Erasure Receipt
+//
+// The receipt is the audit record of an erasure. It does not contain any personal data.
+//
+// swagger:model identityErasureReceipt
+type ErasureReceipt struct {
+	// IdentityID is the ID of the erased identity.
+	//
+	// required: true
+	IdentityID uuid.UUID `json:"identity_id"`
+
+	// Deleted counts the deleted records per category.
+	//
+	// required: true
+	Deleted map[string]int `json:"deleted"`
+
+	// Anonymized counts the anonymized records per category.
+	//
+	// required: true
+	Anonymized map[string]int `json:"anonymized"`
+
+	// ErasedAt is the time the erasure completed.
+	//
+	// required: true
+	ErasedAt time.Time `json:"erased_at"`
+}
+
+func NewErasureReceipt(identityID uuid.UUID) *ErasureReceipt {
+	return &ErasureReceipt{
+		IdentityID: identityID,
+		Deleted:    map[string]int{},
+		Anonymized: map[string]int{},
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/ory/x/sqlxx"
+
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/selfservice/flow"
+	"my.com/secrets/internal/auth/domain/session"
+)
+
+// Identity Data Export
+//
+// Contains all data stored about an identity. Credential secrets such as password
+// hashes or OpenID Connect tokens are never part of the export.
+//
+// swagger:model identityDataExport
+type Export struct {
+	// Identity is the exported identity including its traits, metadata, and addresses.
+	//
+	// required: true
+	Identity *identity.Identity `json:"identity"`
+
+	// Credentials lists the identity's credentials without their secret configuration.
+	//
+	// required: true
+	Credentials []CredentialsMetadata `json:"credentials"`
+
+	// Sessions lists the identity's sessions including their devices.
+	//
+	// required: true
+	Sessions []session.Session `json:"sessions"`
+
+	// CourierMessages lists the messages sent to the identity, including those sent to its earlier addresses,
+	// and the messages sent to any of its current addresses.
+	//
+	// required: true
+	CourierMessages []courier.Message `json:"courier_messages"`
+
+	// Flows lists the self-service flows which were performed by or on behalf of the identity.
+	//
+	// required: true
+	Flows []FlowRecord `json:"flows"`
+
+	// ExportedAt is the time the export was created.
+	//
+	// required: true
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Identity Credentials Metadata
+//
+// swagger:model identityCredentialsMetadata
+type CredentialsMetadata struct {
+	// Type is the credential's type.
+	//
+	// required: true
+	Type identity.CredentialsType `json:"type"`
+
+	// Identifiers are the identifiers this credential matches.
+	//
+	// required: true
+	Identifiers []string `json:"identifiers"`
+
+	// Version refers to the version of the credential.
+	//
+	// required: true
+	Version int `json:"version"`
+
+	// CreatedAt is the time the credential was created.
+	//
+	// required: true
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is the time the credential was last updated.
+	//
+	// required: true
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewCredentialsMetadata strips the secret configuration from the credentials.
+func NewCredentialsMetadata(c identity.Credentials) CredentialsMetadata {
+	return CredentialsMetadata{
+		Type:        c.Type,
+		Identifiers: c.Identifiers,
+		Version:     c.Version,
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}
+}
+
+// Self-Service Flow Record
+//
+// swagger:model selfServiceFlowRecord
+type FlowRecord struct {
+	// ID is the flow's ID.
+	//
+	// required: true
+	ID uuid.UUID `json:"id" db:"id"`
+
+	// Kind is the flow's kind, for example `settings` or `recovery`.
+	//
+	// required: true
+	Kind string `json:"kind" db:"-"`
+
+	// Type is the flow's type, either `api` or `browser`.
+	//
+	// required: true
+	Type flow.Type `json:"type" db:"type"`
+
+	// State is the flow's last known state.
+	State sqlxx.NullString `json:"state" db:"state"`
+
+	// Method is the method which was used to complete the flow.
+	Method sqlxx.NullString `json:"method" db:"active_method"`
+
+	// CreatedAt is the time the flow was initiated.
+	//
+	// required: true
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
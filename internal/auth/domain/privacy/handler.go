@@ -0,0 +1,420 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/jsonx"
+	"github.com/ory/x/urlx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/selfservice/errorx"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	RouteIdentityExport   = "/identities/:id/export"
+	RouteIdentityErasure  = "/identities/:id/erasure"
+	RouteIdentityDeletion = "/identities/:id/deletion"
+
+	RouteCancelScheduledDeletion = "/self-service/account-deletion/cancel"
+)
+
+type (
+	handlerDependencies interface {
+		ManagementProvider
+		PersistenceProvider
+		errorx.ManagementProvider
+		x.WriterProvider
+		x.CSRFProvider
+		x.LoggingProvider
+		config.Provider
+	}
+	HandlerProvider interface {
+		PrivacyHandler() *Handler
+	}
+	Handler struct {
+		r handlerDependencies
+	}
+)
+
+func NewHandler(r handlerDependencies) *Handler {
+	return &Handler{r: r}
+}
+
+func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
+	h.r.CSRFHandler().IgnoreGlobs(
+		x.AdminPrefix+"/identities/*/export",
+		x.AdminPrefix+"/identities/*/erasure",
+		x.AdminPrefix+"/identities/*/deletion",
+	)
+
+	public.GET(x.AdminPrefix+RouteIdentityExport, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteIdentityErasure, x.RedirectToAdminRoute(h.r))
+	public.GET(x.AdminPrefix+RouteIdentityDeletion, x.RedirectToAdminRoute(h.r))
+	public.DELETE(x.AdminPrefix+RouteIdentityDeletion, x.RedirectToAdminRoute(h.r))
+
+	// The cancellation token authorizes the request, so the confirmation page does not need a CSRF token.
+	h.r.CSRFHandler().IgnorePath(RouteCancelScheduledDeletion)
+	public.GET(RouteCancelScheduledDeletion, h.getScheduledDeletionByToken)
+	public.POST(RouteCancelScheduledDeletion, h.cancelScheduledDeletionByToken)
+}
+
+func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
+	admin.GET(RouteIdentityExport, h.export)
+	admin.POST(RouteIdentityErasure, h.erase)
+	admin.GET(RouteIdentityDeletion, h.getScheduledDeletion)
+	admin.DELETE(RouteIdentityDeletion, h.cancelScheduledDeletion)
+}
+
+// Export Identity Data Parameters
+//
+// swagger:parameters exportIdentityData
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type exportIdentityData struct {
+	// ID must be set to the ID of identity you want to export.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route GET /admin/identities/{id}/export identity exportIdentityData
+//
+// # Export all Data of an Identity
+//
+// Returns a bundle of all data stored about an identity: the identity and its addresses, the metadata of
+// its credentials, its sessions and devices, the courier messages sent to it, and its self-service flow history.
+// Credential secrets are never exported.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: identityDataExport
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) export(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	export, err := h.r.PrivacyManager().Export(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, export)
+}
+
+// Erase Identity Data Parameters
+//
+// swagger:parameters eraseIdentityData
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type eraseIdentityData struct {
+	// ID must be set to the ID of identity you want to erase.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route POST /admin/identities/{id}/erasure identity eraseIdentityData
+//
+// # Erase all Data of an Identity
+//
+// Irrecoverably deletes the identity together with its credentials, addresses, sessions, devices, and
+// self-service flows. Courier messages sent to the identity keep their delivery status but lose their
+// recipient and content.
+//
+// The erasure runs in the background and is tracked as a job whose result is the erasure receipt. Poll the
+// returned job to follow it.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  202: job
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) erase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	j, err := h.r.PrivacyManager().StartErasure(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+	response := *j
+
+	// The erasure outlives the request, so it must not be canceled together with it.
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := h.r.PrivacyManager().RunErasure(ctx, j); err != nil {
+			h.r.Logger().WithError(err).WithField("job_id", j.ID).Error("The erasure of the data of an identity failed.")
+		}
+	}()
+
+	w.Header().Set("Location", urlx.AppendPaths(h.r.Config().SelfAdminURL(r.Context()), job.RouteCollection, j.ID.String()).String())
+	h.r.Writer().WriteCode(w, r, http.StatusAccepted, &response)
+}
+
+// Get Scheduled Identity Deletion Parameters
+//
+// swagger:parameters getScheduledIdentityDeletion
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type getScheduledIdentityDeletion struct {
+	// ID is the identity's ID.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route GET /admin/identities/{id}/deletion identity getScheduledIdentityDeletion
+//
+// # Get the Scheduled Deletion of an Identity
+//
+// Returns the identity's scheduled deletion, if its deletion was requested and the grace period has not passed yet.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: scheduledIdentityDeletion
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) getScheduledDeletion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	d, err := h.r.PrivacyPersister().GetScheduledIdentityDeletion(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.r.Writer().Write(w, r, d)
+}
+
+// Cancel Scheduled Identity Deletion Parameters
+//
+// swagger:parameters cancelScheduledIdentityDeletion
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type cancelScheduledIdentityDeletion struct {
+	// ID is the identity's ID.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route DELETE /admin/identities/{id}/deletion identity cancelScheduledIdentityDeletion
+//
+// # Cancel the Scheduled Deletion of an Identity
+//
+// Cancels the identity's scheduled deletion and restores the state the identity had before its deletion was
+// scheduled. Sessions revoked when the deletion was scheduled are not restored.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  204: emptyResponse
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) cancelScheduledDeletion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := h.r.PrivacyManager().CancelIdentityDeletion(r.Context(), x.ParseUUID(ps.ByName("id"))); err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cancelScheduledDeletionPage asks the user to confirm the cancellation. Opening the emailed link must not cancel
+// the deletion by itself, because email security scanners open links, too.
+var cancelScheduledDeletionPage = template.Must(template.New("cancel").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Cancel account deletion</title>
+</head>
+<body>
+<p>Your account is scheduled for deletion on {{ .DeleteAfter.Format "January 2, 2006" }}.</p>
+<form method="POST" action="{{ .Action }}">
+<input type="hidden" name="token" value="{{ .Token }}">
+<button type="submit">Keep my account</button>
+</form>
+</body>
+</html>
+`))
+
+// Get Scheduled Account Deletion Cancellation Parameters
+//
+// swagger:parameters getScheduledAccountDeletionCancellation
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type getScheduledAccountDeletionCancellation struct {
+	// Token is the cancellation token sent to the identity when its deletion was scheduled.
+	//
+	// required: true
+	// in: query
+	Token string `json:"token"`
+}
+
+// swagger:route GET /self-service/account-deletion/cancel frontend getScheduledAccountDeletionCancellation
+//
+// # Confirm the Cancellation of a Scheduled Account Deletion
+//
+// This endpoint is linked in the email sent when an account's deletion is scheduled. Browsers are shown a page
+// which asks the user to confirm the cancellation, errors are shown in the error UI. API clients receive the
+// scheduled deletion. Calling this endpoint does not cancel the deletion.
+//
+//	Produces:
+//	- text/html
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Responses:
+//	  200: scheduledIdentityDeletion
+//	  400: errorGeneric
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) getScheduledDeletionByToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	token := r.URL.Query().Get("token")
+	d, err := h.r.PrivacyManager().GetScheduledDeletionByToken(r.Context(), token)
+	if err != nil {
+		if x.IsBrowserRequest(r) {
+			h.r.SelfServiceErrorManager().Forward(r.Context(), w, r, err)
+			return
+		}
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	if !x.IsBrowserRequest(r) {
+		h.r.Writer().Write(w, r, d)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := cancelScheduledDeletionPage.Execute(w, map[string]interface{}{
+		"Action":      urlx.AppendPaths(h.r.Config().SelfPublicURL(r.Context()), RouteCancelScheduledDeletion).String(),
+		"Token":       token,
+		"DeleteAfter": d.DeleteAfter,
+	}); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(err))
+	}
+}
+
+// Cancel Scheduled Account Deletion Body
+//
+// swagger:model cancelScheduledAccountDeletionBody
+type cancelScheduledAccountDeletionBody struct {
+	// Token is the cancellation token sent to the identity when its deletion was scheduled.
+	//
+	// required: true
+	Token string `json:"token" form:"token"`
+}
+
+// Cancel Scheduled Account Deletion Parameters
+//
+// swagger:parameters cancelScheduledAccountDeletion
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type cancelScheduledAccountDeletion struct {
+	// in: body
+	// required: true
+	Body cancelScheduledAccountDeletionBody
+}
+
+// swagger:route POST /self-service/account-deletion/cancel frontend cancelScheduledAccountDeletion
+//
+// # Cancel a Scheduled Account Deletion
+//
+// Cancels the deletion and reactivates the account, as long as the deletion's grace period has not passed. The
+// confirmation page of `GET /self-service/account-deletion/cancel` submits its form to this endpoint.
+//
+// Browsers are redirected to `selfservice.default_browser_return_url`, errors are shown in the error UI. API clients
+// receive the cancelled scheduled deletion.
+//
+//	Consumes:
+//	- application/json
+//	- application/x-www-form-urlencoded
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Responses:
+//	  200: scheduledIdentityDeletion
+//	  303: emptyResponse
+//	  400: errorGeneric
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) cancelScheduledDeletionByToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body cancelScheduledAccountDeletionBody
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := jsonx.NewStrictDecoder(r.Body).Decode(&body); err != nil {
+			h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithWrap(err).WithReasonf("Unable to decode the request body: %s", err)))
+			return
+		}
+	} else {
+		body.Token = r.PostFormValue("token")
+	}
+
+	d, err := h.r.PrivacyManager().CancelIdentityDeletionByToken(r.Context(), body.Token)
+	if err != nil {
+		if x.IsBrowserRequest(r) {
+			h.r.SelfServiceErrorManager().Forward(r.Context(), w, r, err)
+			return
+		}
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	if x.IsBrowserRequest(r) {
+		http.Redirect(w, r, h.r.Config().SelfServiceBrowserDefaultReturnTo(r.Context()).String(), http.StatusSeeOther)
+		return
+	}
+
+	h.r.Writer().Write(w, r, d)
+}
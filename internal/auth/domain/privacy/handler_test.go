@@ -0,0 +1,95 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/privacy"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func do(t *testing.T, ts *httptest.Server, method, href string, expectCode int) gjson.Result {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+href, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	res, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.EqualValuesf(t, expectCode, res.StatusCode, "%s", body)
+	return gjson.ParseBytes(body)
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	publicTS, adminTS := testhelpers.NewKratosServerWithCSRF(t, reg)
+	conf.MustSet(ctx, config.ViperKeyAdminBaseURL, adminTS.URL)
+
+	createIdentity := func(t *testing.T) *identity.Identity {
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		i.Traits = identity.Traits(`{"email":"` + x.NewUUID().String() + `@ory.sh"}`)
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+		return i
+	}
+
+	href := func(tsName, path string) string {
+		if tsName == "public" {
+			return x.AdminPrefix + path
+		}
+		return path
+	}
+
+	for name, ts := range map[string]*httptest.Server{"public": publicTS, "admin": adminTS} {
+		t.Run("endpoint="+name, func(t *testing.T) {
+			t.Run("case=export unknown identity", func(t *testing.T) {
+				do(t, ts, "GET", href(name, "/identities/"+x.NewUUID().String()+"/export"), http.StatusNotFound)
+			})
+
+			t.Run("case=export and erase identity", func(t *testing.T) {
+				i := createIdentity(t)
+
+				export := do(t, ts, "GET", href(name, "/identities/"+i.ID.String()+"/export"), http.StatusOK)
+				assert.Equal(t, i.ID.String(), export.Get("identity.id").String(), "%s", export.Raw)
+				assert.False(t, export.Get("identity.credentials").Exists(), "%s", export.Raw)
+
+				res := do(t, ts, "POST", href(name, "/identities/"+i.ID.String()+"/erasure"), http.StatusAccepted)
+				assert.Equal(t, string(privacy.JobTypeErasure), res.Get("type").String(), "%s", res.Raw)
+				assert.Equal(t, string(job.StateRunning), res.Get("state").String(), "%s", res.Raw)
+
+				require.EventuallyWithT(t, func(t *assert.CollectT) {
+					actual, err := reg.JobPersister().GetJob(ctx, x.ParseUUID(res.Get("id").String()))
+					require.NoError(t, err)
+					assert.True(t, actual.IsFinished())
+				}, 10*time.Second, 10*time.Millisecond)
+
+				j := do(t, ts, "GET", href(name, job.RouteCollection+"/"+res.Get("id").String()), http.StatusOK)
+				assert.Equal(t, string(job.StateSucceeded), j.Get("state").String(), "%s", j.Raw)
+				assert.Equal(t, i.ID.String(), j.Get("result.identity_id").String(), "%s", j.Raw)
+
+				do(t, ts, "GET", href(name, "/identities/"+i.ID.String()+"/export"), http.StatusNotFound)
+				do(t, ts, "POST", href(name, "/identities/"+i.ID.String()+"/erasure"), http.StatusNotFound)
+			})
+		})
+	}
+}
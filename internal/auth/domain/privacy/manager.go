@@ -0,0 +1,127 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/otelx"
+
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type (
+	managerDependencies interface {
+		PersistenceProvider
+		job.PersistenceProvider
+		identity.PrivilegedPoolProvider
+		session.PersistenceProvider
+		courier.Provider
+		courier.ConfigProvider
+		config.Provider
+		x.HTTPClientProvider
+		x.LoggingProvider
+		x.TracingProvider
+	}
+	ManagementProvider interface {
+		PrivacyManager() *Manager
+	}
+	Manager struct {
+		r managerDependencies
+	}
+)
+
+func NewManager(r managerDependencies) *Manager {
+	return &Manager{r: r}
+}
+
+// Export returns all data stored about the identity.
+func (m *Manager) Export(ctx context.Context, identityID uuid.UUID) (_ *Export, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.Export")
+	defer otelx.End(span, &err)
+
+	export, err := m.r.PrivacyPersister().ExportIdentityData(ctx, identityID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.r.Audit().
+		WithField("identity_id", identityID).
+		Info("The data of an identity was exported.")
+	return export, nil
+}
+
+// Erase deletes or anonymizes all data stored about the identity like StartErasure and RunErasure. The job is
+// returned even if the erasure failed.
+func (m *Manager) Erase(ctx context.Context, identityID uuid.UUID) (_ *job.Job, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.Erase")
+	defer otelx.End(span, &err)
+
+	j, err := m.StartErasure(ctx, identityID)
+	if err != nil {
+		return nil, err
+	}
+	return j, m.RunErasure(ctx, j)
+}
+
+// StartErasure checks that the identity exists and creates the job which tracks the erasure of its data. The
+// erasure itself is executed by RunErasure.
+func (m *Manager) StartErasure(ctx context.Context, identityID uuid.UUID) (_ *job.Job, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.StartErasure")
+	defer otelx.End(span, &err)
+
+	if _, err := m.r.PrivilegedIdentityPool().GetIdentity(ctx, identityID, identity.ExpandNothing); err != nil {
+		return nil, err
+	}
+
+	j := job.NewJob(JobTypeErasure, identityID)
+	if err := m.r.JobPersister().CreateJob(ctx, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// RunErasure deletes or anonymizes all data stored about the identity of the job. The job's result is the erasure
+// receipt.
+func (m *Manager) RunErasure(ctx context.Context, j *job.Job) (err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "privacy.Manager.RunErasure")
+	defer otelx.End(span, &err)
+
+	identityID := j.Subject.UUID
+	receipt, err := m.r.PrivacyPersister().EraseIdentityData(ctx, identityID)
+	if err != nil {
+		j.Fail(err)
+		if updateErr := m.r.JobPersister().UpdateJob(ctx, j); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+
+	result, err := json.Marshal(receipt)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	j.Succeed(result)
+	if err := m.r.JobPersister().UpdateJob(ctx, j); err != nil {
+		return err
+	}
+
+	m.r.Audit().
+		WithField("identity_id", identityID).
+		WithField("job_id", j.ID).
+		WithField("deleted", receipt.Deleted).
+		WithField("anonymized", receipt.Anonymized).
+		Info("The data of an identity was erased.")
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package privacy
+
+import (
+	"context"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+type (
+	Persister interface {
+		// ExportIdentityData collects all data stored about the identity.
+		ExportIdentityData(ctx context.Context, identityID uuid.UUID) (*Export, error)
+
+		// EraseIdentityData deletes the identity and all records that belong to it in a single transaction. Its
+		// sessions are deleted like DeleteSessionsByIdentity does. Courier messages sent to the identity keep their
+		// delivery status but lose their recipient and content.
+		EraseIdentityData(ctx context.Context, identityID uuid.UUID) (*ErasureReceipt, error)
+
+		// ScheduleIdentityDeletion stores the scheduled deletion and deactivates the identity in a single transaction.
+		ScheduleIdentityDeletion(ctx context.Context, d *identity.ScheduledDeletion) error
+
+		// GetScheduledIdentityDeletion returns the identity's scheduled deletion.
+		GetScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) (*identity.ScheduledDeletion, error)
+
+		// GetScheduledIdentityDeletionByToken returns the scheduled deletion with the given cancellation token.
+		GetScheduledIdentityDeletionByToken(ctx context.Context, token string) (*identity.ScheduledDeletion, error)
+
+		// CancelScheduledIdentityDeletion removes the identity's scheduled deletion and restores the identity's
+		// previous state in a single transaction.
+		CancelScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) error
+
+		// DiscardScheduledIdentityDeletion removes the identity's scheduled deletion without restoring the
+		// identity's previous state. It returns false if the identity's deletion was not scheduled.
+		DiscardScheduledIdentityDeletion(ctx context.Context, identityID uuid.UUID) (bool, error)
+
+		// ListDueIdentityDeletions returns up to limit scheduled deletions whose grace period has passed. Deletions
+		// which failed at or after failedBefore are skipped.
+		ListDueIdentityDeletions(ctx context.Context, limit int, failedBefore time.Time) ([]identity.ScheduledDeletion, error)
+
+		// FailScheduledIdentityDeletion records that erasing the identity of the scheduled deletion failed.
+		FailScheduledIdentityDeletion(ctx context.Context, id uuid.UUID, reason string) error
+
+		// Transaction runs callback in a transaction, which the methods of all persisters join if they are called
+		// with the context passed to callback.
+		Transaction(ctx context.Context, callback func(ctx context.Context, connection *pop.Connection) error) error
+	}
+	PersistenceProvider interface {
+		PrivacyPersister() Persister
+	}
+)
@@ -0,0 +1,314 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/pointerx"
+	"github.com/ory/x/randx"
+	"github.com/ory/x/sqlcon"
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/persistence"
+	"my.com/secrets/internal/auth/domain/selfservice/flow"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/registration"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/settings"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/ui/container"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestPersister(ctx context.Context, conf *config.Config, p persistence.Persister) func(t *testing.T) {
+	return func(t *testing.T) {
+		_, p := testhelpers.NewNetworkUnlessExisting(t, ctx, p)
+
+		testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+
+		createIdentity := func(t *testing.T) *identity.Identity {
+			email := randx.MustString(16, randx.AlphaLowerNum) + "@ory.sh"
+			i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+			i.Traits = identity.Traits(`{"email":"` + email + `"}`)
+			i.Credentials = map[identity.CredentialsType]identity.Credentials{
+				identity.CredentialsTypePassword: {
+					Type:        identity.CredentialsTypePassword,
+					Identifiers: []string{email},
+					Config:      []byte(`{"hashed_password":"secret"}`),
+				},
+			}
+			i.VerifiableAddresses = []identity.VerifiableAddress{*identity.NewVerifiableEmailAddress(email, i.ID)}
+			i.RecoveryAddresses = []identity.RecoveryAddress{*identity.NewRecoveryEmailAddress(email, i.ID)}
+			require.NoError(t, p.CreateIdentity(ctx, i))
+
+			now := time.Now().UTC()
+			s := session.NewInactiveSession()
+			s.Active = true
+			s.Identity = i
+			s.IdentityID = i.ID
+			s.AuthenticatedAt = now
+			s.IssuedAt = now
+			s.ExpiresAt = now.Add(time.Hour)
+			s.Devices = []session.Device{{IPAddress: pointerx.Ptr("127.0.0.1"), UserAgent: pointerx.Ptr("Mozilla/5.0")}}
+			require.NoError(t, p.UpsertSession(ctx, s))
+
+			require.NoError(t, p.AddMessage(ctx, &courier.Message{
+				Type:         courier.MessageTypeEmail,
+				Status:       courier.MessageStatusSent,
+				Recipient:    email,
+				Subject:      "Please verify your email address",
+				Body:         "Hi, please verify your account",
+				TemplateType: "verification_valid",
+				TemplateData: []byte(`{"to":"` + email + `"}`),
+			}))
+			require.NoError(t, p.AddMessage(ctx, &courier.Message{
+				Type:         courier.MessageTypeEmail,
+				Status:       courier.MessageStatusSent,
+				Recipient:    "previous-" + email,
+				Subject:      "Please verify your email address",
+				Body:         "Hi, please verify your account",
+				TemplateType: "verification_valid",
+				TemplateData: []byte(`{"to":"previous-` + email + `","identity":{"id":"` + i.ID.String() + `"}}`),
+				IdentityID:   uuid.NullUUID{UUID: i.ID, Valid: true},
+			}))
+
+			lf := &login.Flow{
+				ID:              x.NewUUID(),
+				Type:            flow.TypeAPI,
+				ExpiresAt:       now.Add(time.Hour),
+				IssuedAt:        now,
+				RequestURL:      "http://localhost/self-service/login/api",
+				State:           flow.StateChooseMethod,
+				UI:              &container.Container{Method: "POST", Action: "http://localhost/self-service/login"},
+				InternalContext: []byte("{}"),
+			}
+			require.NoError(t, p.CreateLoginFlow(ctx, lf))
+			require.NoError(t, p.SetLoginFlowIdentity(ctx, lf.ID, i.ID))
+
+			rf := &registration.Flow{
+				ID:              x.NewUUID(),
+				Type:            flow.TypeAPI,
+				ExpiresAt:       now.Add(time.Hour),
+				IssuedAt:        now,
+				RequestURL:      "http://localhost/self-service/registration/api",
+				State:           flow.StateChooseMethod,
+				UI:              &container.Container{Method: "POST", Action: "http://localhost/self-service/registration"},
+				InternalContext: []byte("{}"),
+			}
+			require.NoError(t, p.CreateRegistrationFlow(ctx, rf))
+			require.NoError(t, p.SetRegistrationFlowIdentity(ctx, rf.ID, i.ID))
+
+			require.NoError(t, p.CreateSettingsFlow(ctx, &settings.Flow{
+				ID:              x.NewUUID(),
+				Type:            flow.TypeBrowser,
+				ExpiresAt:       now.Add(time.Hour),
+				IssuedAt:        now,
+				RequestURL:      "http://localhost/self-service/settings/browser",
+				IdentityID:      i.ID,
+				Identity:        i,
+				State:           flow.StateShowForm,
+				UI:              &container.Container{Method: "POST", Action: "http://localhost/self-service/settings"},
+				InternalContext: []byte("{}"),
+			}))
+
+			return i
+		}
+
+		t.Run("case=export of unknown identity fails", func(t *testing.T) {
+			_, err := p.ExportIdentityData(ctx, x.NewUUID())
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=export contains all data except secrets", func(t *testing.T) {
+			i := createIdentity(t)
+
+			export, err := p.ExportIdentityData(ctx, i.ID)
+			require.NoError(t, err)
+
+			assert.Equal(t, i.ID, export.Identity.ID)
+			assert.Empty(t, export.Identity.Credentials)
+			assert.Len(t, export.Identity.VerifiableAddresses, 1)
+			assert.Len(t, export.Identity.RecoveryAddresses, 1)
+
+			require.Len(t, export.Credentials, 1)
+			assert.Equal(t, identity.CredentialsTypePassword, export.Credentials[0].Type)
+			assert.Equal(t, i.Credentials[identity.CredentialsTypePassword].Identifiers, export.Credentials[0].Identifiers)
+
+			require.Len(t, export.Sessions, 1)
+			assert.Len(t, export.Sessions[0].Devices, 1)
+
+			require.Len(t, export.CourierMessages, 2, "messages to earlier addresses of the identity are exported")
+			assert.ElementsMatch(t, []string{i.VerifiableAddresses[0].Value, "previous-" + i.VerifiableAddresses[0].Value},
+				[]string{export.CourierMessages[0].Recipient, export.CourierMessages[1].Recipient})
+
+			require.Len(t, export.Flows, 3)
+			assert.Equal(t, "login", export.Flows[0].Kind)
+			assert.Equal(t, "registration", export.Flows[1].Kind)
+			assert.Equal(t, "settings", export.Flows[2].Kind)
+			assert.Equal(t, flow.TypeBrowser, export.Flows[2].Type)
+		})
+
+		t.Run("case=erasure removes the identity and anonymizes messages", func(t *testing.T) {
+			i := createIdentity(t)
+			other := createIdentity(t)
+
+			receipt, err := p.EraseIdentityData(ctx, i.ID)
+			require.NoError(t, err)
+
+			assert.Equal(t, i.ID, receipt.IdentityID)
+			assert.False(t, receipt.ErasedAt.IsZero())
+			assert.Equal(t, map[string]int{
+				"identities":           1,
+				"credentials":          1,
+				"verifiable_addresses": 1,
+				"recovery_addresses":   1,
+				"sessions":             1,
+				"session_devices":      1,
+				"login_flows":          1,
+				"registration_flows":   1,
+				"settings_flows":       1,
+				"recovery_flows":       0,
+				"verification_flows":   0,
+			}, receipt.Deleted)
+			assert.Equal(t, map[string]int{"courier_messages": 2}, receipt.Anonymized)
+
+			_, err = p.GetIdentity(ctx, i.ID, identity.ExpandNothing)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			_, err = p.ExportIdentityData(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			export, err := p.ExportIdentityData(ctx, other.ID)
+			require.NoError(t, err)
+			assert.Len(t, export.Sessions, 1)
+			assert.Len(t, export.CourierMessages, 2)
+			assert.Len(t, export.Flows, 3)
+		})
+
+		t.Run("case=erasure of unknown identity fails", func(t *testing.T) {
+			_, err := p.EraseIdentityData(ctx, x.NewUUID())
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=scheduled deletion", func(t *testing.T) {
+			i := createIdentity(t)
+
+			_, err := p.GetScheduledIdentityDeletion(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			d := identity.NewScheduledDeletion(i, identity.DeletionInitiatorAdmin, time.Hour)
+			require.NoError(t, p.ScheduleIdentityDeletion(ctx, d))
+
+			actual, err := p.GetIdentity(ctx, i.ID, identity.ExpandNothing)
+			require.NoError(t, err)
+			assert.Equal(t, identity.StateInactive, actual.State)
+
+			byID, err := p.GetScheduledIdentityDeletion(ctx, i.ID)
+			require.NoError(t, err)
+			assert.Equal(t, d.ID, byID.ID)
+			assert.Equal(t, identity.StateActive, byID.PreviousState)
+			assert.Equal(t, identity.DeletionInitiatorAdmin, byID.Initiator)
+
+			byToken, err := p.GetScheduledIdentityDeletionByToken(ctx, d.CancelToken)
+			require.NoError(t, err)
+			assert.Equal(t, d.ID, byToken.ID)
+			assert.Empty(t, byToken.CancelToken, "only the hash of the cancellation token is stored")
+			assert.Equal(t, identity.DeletionCancelTokenHash(d.CancelToken), byToken.CancelTokenHash)
+
+			_, err = p.GetScheduledIdentityDeletionByToken(ctx, "not-a-token")
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			require.Error(t, p.ScheduleIdentityDeletion(ctx, identity.NewScheduledDeletion(i, identity.DeletionInitiatorAdmin, time.Hour)), "an identity can only be scheduled for deletion once")
+
+			require.NoError(t, p.CancelScheduledIdentityDeletion(ctx, i.ID))
+			actual, err = p.GetIdentity(ctx, i.ID, identity.ExpandNothing)
+			require.NoError(t, err)
+			assert.Equal(t, identity.StateActive, actual.State)
+
+			_, err = p.GetScheduledIdentityDeletion(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			require.ErrorIs(t, p.CancelScheduledIdentityDeletion(ctx, i.ID), sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=scheduling the deletion of an unknown identity fails", func(t *testing.T) {
+			i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+			i.ID = x.NewUUID()
+			require.ErrorIs(t, p.ScheduleIdentityDeletion(ctx, identity.NewScheduledDeletion(i, identity.DeletionInitiatorAdmin, time.Hour)), sqlcon.ErrNoRows)
+		})
+
+		t.Run("case=list due deletions", func(t *testing.T) {
+			due := createIdentity(t)
+			notDue := createIdentity(t)
+
+			require.NoError(t, p.ScheduleIdentityDeletion(ctx, identity.NewScheduledDeletion(due, identity.DeletionInitiatorSelfService, -time.Minute)))
+			require.NoError(t, p.ScheduleIdentityDeletion(ctx, identity.NewScheduledDeletion(notDue, identity.DeletionInitiatorSelfService, time.Hour)))
+
+			deletions, err := p.ListDueIdentityDeletions(ctx, 100, time.Now())
+			require.NoError(t, err)
+			require.Len(t, deletions, 1)
+			assert.Equal(t, due.ID, deletions[0].IdentityID)
+			assert.True(t, deletions[0].IsDue())
+
+			t.Run("case=failed deletions are skipped until the next run", func(t *testing.T) {
+				started := time.Now().Truncate(time.Second)
+				require.NoError(t, p.FailScheduledIdentityDeletion(ctx, deletions[0].ID, "erasure failed"))
+
+				actual, err := p.GetScheduledIdentityDeletion(ctx, due.ID)
+				require.NoError(t, err)
+				require.NotNil(t, actual.FailedAt)
+				assert.EqualValues(t, "erasure failed", actual.Error)
+
+				skipped, err := p.ListDueIdentityDeletions(ctx, 100, started)
+				require.NoError(t, err)
+				assert.Len(t, skipped, 0)
+
+				retried, err := p.ListDueIdentityDeletions(ctx, 100, time.Now().Add(time.Minute))
+				require.NoError(t, err)
+				assert.Len(t, retried, 1)
+			})
+
+			_, err = p.EraseIdentityData(ctx, due.ID)
+			require.NoError(t, err)
+
+			deletions, err = p.ListDueIdentityDeletions(ctx, 100, time.Now())
+			require.NoError(t, err)
+			assert.Len(t, deletions, 0)
+		})
+
+		t.Run("case=network isolation", func(t *testing.T) {
+			i := createIdentity(t)
+
+			_, other := testhelpers.NewNetwork(t, ctx, p)
+			_, err := other.ExportIdentityData(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			_, err = other.EraseIdentityData(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			require.ErrorIs(t, other.ScheduleIdentityDeletion(ctx, identity.NewScheduledDeletion(i, identity.DeletionInitiatorAdmin, time.Hour)), sqlcon.ErrNoRows)
+
+			d := identity.NewScheduledDeletion(i, identity.DeletionInitiatorAdmin, -time.Minute)
+			require.NoError(t, p.ScheduleIdentityDeletion(ctx, d))
+			_, err = other.GetScheduledIdentityDeletion(ctx, i.ID)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			_, err = other.GetScheduledIdentityDeletionByToken(ctx, d.CancelToken)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			require.ErrorIs(t, other.CancelScheduledIdentityDeletion(ctx, i.ID), sqlcon.ErrNoRows)
+			deletions, err := other.ListDueIdentityDeletions(ctx, 100, time.Now())
+			require.NoError(t, err)
+			assert.Len(t, deletions, 0)
+			require.NoError(t, p.CancelScheduledIdentityDeletion(ctx, i.ID))
+
+			_, err = p.GetIdentity(ctx, i.ID, identity.ExpandNothing)
+			require.NoError(t, err)
+		})
+	}
+}
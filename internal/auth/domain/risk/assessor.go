@@ -0,0 +1,304 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/pointerx"
+	"github.com/ory/x/urlx"
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/courier/template/email"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	ReasonNewUserAgentFamily = "new_user_agent_family"
+	ReasonNewCountry         = "new_country"
+	ReasonNewASN             = "new_asn"
+	ReasonImpossibleTravel   = "impossible_travel"
+
+	// ReasonAssessmentFailed is recorded if the login could not be assessed. Such logins require a second
+	// factor.
+	ReasonAssessmentFailed = "assessment_failed"
+)
+
+type (
+	// Signals describes how the device of a login differs from the devices of the identity's previous
+	// sessions.
+	Signals struct {
+		// FirstLogin is true if the identity has no previous sessions with devices. No rule matches on the
+		// first login.
+		FirstLogin bool `json:"first_login"`
+
+		UserAgentFamily string `json:"user_agent_family"`
+		Country         string `json:"country"`
+		ASN             uint32 `json:"asn"`
+
+		NewUserAgentFamily bool `json:"new_user_agent_family"`
+		NewCountry         bool `json:"new_country"`
+		NewASN             bool `json:"new_asn"`
+
+		// TravelSpeed is the speed in kilometers per hour needed to get from the location of the last
+		// session to the location of the login, or zero if either location is unknown.
+		TravelSpeed      float64 `json:"travel_speed"`
+		ImpossibleTravel bool    `json:"impossible_travel"`
+	}
+
+	AssessorProvider interface {
+		RiskAssessor() *Assessor
+	}
+
+	assessorDependencies interface {
+		config.Provider
+		x.LoggingProvider
+		x.TracingProvider
+		courier.Provider
+		courier.ConfigProvider
+		x.HTTPClientProvider
+		geoip.Provider
+		identity.PoolProvider
+		session.PersistenceProvider
+		ScorerProvider
+	}
+
+	// Assessor scores logins against the devices of the identity's previous sessions.
+	Assessor struct {
+		d       assessorDependencies
+		nowFunc func() time.Time
+	}
+)
+
+func NewAssessor(d assessorDependencies) *Assessor {
+	return &Assessor{d: d, nowFunc: time.Now}
+}
+
+// Reasons returns the rules which matched.
+func (s *Signals) Reasons() []string {
+	reasons := []string{}
+	if s.NewUserAgentFamily {
+		reasons = append(reasons, ReasonNewUserAgentFamily)
+	}
+	if s.NewCountry {
+		reasons = append(reasons, ReasonNewCountry)
+	}
+	if s.NewASN {
+		reasons = append(reasons, ReasonNewASN)
+	}
+	if s.ImpossibleTravel {
+		reasons = append(reasons, ReasonImpossibleTravel)
+	}
+	return reasons
+}
+
+// AssessLogin records the risk assessment of the login on the session, which must have been activated with
+// the login's device. The caller stores the assessment. If the login comes from a new device or location, the
+// identity is notified by email.
+//
+// Logins which cannot be assessed require a second factor.
+func (a *Assessor) AssessLogin(ctx context.Context, s *session.Session) {
+	var err error
+	ctx, span := a.d.Tracer(ctx).Tracer().Start(ctx, "risk.Assessor.AssessLogin")
+	defer otelx.End(span, &err)
+
+	threshold := a.d.Config().SelfServiceFlowLoginRiskThreshold(ctx)
+	assessment := &session.RiskAssessment{Threshold: threshold, AssessedAt: a.nowFunc().UTC(), Reasons: []string{}}
+	s.RiskAssessment = assessment
+
+	signals, err := a.Signals(ctx, s)
+	if err != nil {
+		a.fail(s, err)
+		return
+	}
+
+	assessment.Reasons = signals.Reasons()
+	if assessment.Score, err = a.d.RiskScorer().Score(ctx, s.Identity, signals); err != nil {
+		a.fail(s, err)
+		return
+	}
+	assessment.StepUpRequired = assessment.Score >= threshold
+
+	span.SetAttributes(
+		attribute.Float64("risk.score", assessment.Score),
+		attribute.Bool("risk.step_up_required", assessment.StepUpRequired),
+		attribute.StringSlice("risk.reasons", assessment.Reasons),
+	)
+
+	if len(assessment.Reasons) > 0 && a.d.Config().SelfServiceFlowLoginRiskNotify(ctx) {
+		if err := a.notify(ctx, s, assessment.Reasons); err != nil {
+			a.d.Logger().WithError(err).WithField("identity_id", s.IdentityID).
+				Error("Unable to send the new sign-in notification.")
+		}
+	}
+}
+
+func (a *Assessor) fail(s *session.Session, err error) {
+	a.d.Logger().WithError(err).WithField("identity_id", s.IdentityID).
+		Error("Unable to assess the risk of the login. A second factor is required.")
+	s.RiskAssessment.StepUpRequired = true
+	s.RiskAssessment.Reasons = []string{ReasonAssessmentFailed}
+}
+
+// Signals compares the login's device, which is the last device of the session, with the devices of the
+// identity's previous sessions.
+func (a *Assessor) Signals(ctx context.Context, s *session.Session) (*Signals, error) {
+	if len(s.Devices) == 0 {
+		return &Signals{FirstLogin: true}, nil
+	}
+	device := s.Devices[len(s.Devices)-1]
+	location := a.locate(ctx, &device)
+
+	signals := &Signals{
+		UserAgentFamily: userAgentFamily(pointerx.StringR(device.UserAgent)),
+		Country:         location.Country,
+		ASN:             location.ASN,
+	}
+
+	history, _, err := a.d.SessionPersister().ListSessionsByIdentity(ctx, s.IdentityID, nil, 1,
+		a.d.Config().SelfServiceFlowLoginRiskHistory(ctx), s.ID, session.Expandables{session.ExpandSessionDevices})
+	if err != nil {
+		return nil, err
+	}
+
+	families, countries, asns := map[string]bool{}, map[string]bool{}, map[uint32]bool{}
+	for _, previous := range history {
+		for _, d := range previous.Devices {
+			if family := userAgentFamily(pointerx.StringR(d.UserAgent)); family != "" {
+				families[family] = true
+			}
+			if d.Country != nil && *d.Country != "" {
+				countries[*d.Country] = true
+			}
+			if d.ASN != nil && *d.ASN != 0 {
+				asns[uint32(*d.ASN)] = true
+			}
+		}
+	}
+
+	if len(families) == 0 && len(countries) == 0 && len(asns) == 0 {
+		signals.FirstLogin = true
+		return signals, nil
+	}
+
+	signals.NewUserAgentFamily = signals.UserAgentFamily != "" && len(families) > 0 && !families[signals.UserAgentFamily]
+	signals.NewCountry = signals.Country != "" && len(countries) > 0 && !countries[signals.Country]
+	signals.NewASN = signals.ASN != 0 && len(asns) > 0 && !asns[signals.ASN]
+
+	// The history is ordered by authentication time, most recent first.
+	if last := history[0]; location.Coordinates != nil && len(last.Devices) > 0 {
+		lastDevice := last.Devices[len(last.Devices)-1]
+		if lastLocation := a.locate(ctx, &lastDevice); lastLocation.Coordinates != nil {
+			lastSeenAt := last.AuthenticatedAt
+			if last.LastSeenAt != nil && last.LastSeenAt.After(lastSeenAt) {
+				lastSeenAt = *last.LastSeenAt
+			}
+
+			// Logins right after each other would otherwise divide by almost zero.
+			hours := a.nowFunc().Sub(lastSeenAt).Hours()
+			if hours < 1.0/60 {
+				hours = 1.0 / 60
+			}
+
+			signals.TravelSpeed = lastLocation.Coordinates.DistanceKilometers(location.Coordinates) / hours
+			signals.ImpossibleTravel = signals.TravelSpeed > a.d.Config().SelfServiceFlowLoginRiskRules(ctx).MaxTravelSpeed
+		}
+	}
+
+	return signals, nil
+}
+
+// locate resolves the location of the device. Country and ASN already known for the device, for example
+// from the Cf-Ipcountry header, take precedence over the GeoIP databases.
+func (a *Assessor) locate(ctx context.Context, device *session.Device) *geoip.Location {
+	location := new(geoip.Location)
+	if ip := net.ParseIP(pointerx.StringR(device.IPAddress)); ip != nil {
+		resolved, err := a.d.GeoIPResolver().Resolve(ctx, ip)
+		if err != nil {
+			a.d.Logger().WithError(err).Warn("Unable to resolve the location of the login.")
+		} else {
+			location = resolved
+		}
+	}
+
+	if device.Country != nil && *device.Country != "" {
+		location.Country = *device.Country
+	}
+	if device.ASN != nil && *device.ASN != 0 {
+		location.ASN = uint32(*device.ASN)
+	}
+	return location
+}
+
+func (a *Assessor) notify(ctx context.Context, s *session.Session, reasons []string) error {
+	i := s.Identity
+	if i == nil || len(i.VerifiableAddresses) == 0 {
+		var err error
+		if i, err = a.d.IdentityPool().GetIdentity(ctx, s.IdentityID, identity.ExpandDefault); err != nil {
+			return err
+		}
+	}
+
+	model, err := x.StructToMap(i.CopyWithoutCredentials())
+	if err != nil {
+		return err
+	}
+
+	var device session.Device
+	if len(s.Devices) > 0 {
+		device = s.Devices[len(s.Devices)-1]
+	}
+	location := pointerx.StringR(device.Location)
+	if location == "" {
+		resolved := a.locate(ctx, &device)
+		location = strings.Join(nonEmpty(resolved.City, resolved.Country), ", ")
+	}
+
+	settingsURL := urlx.AppendPaths(a.d.Config().SelfPublicURL(ctx), "/self-service/settings/browser").String()
+
+	c, err := a.d.Courier(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range i.VerifiableAddresses {
+		if address.Via != identity.AddressTypeEmail {
+			continue
+		}
+
+		if _, err := c.QueueEmail(ctx, email.NewSignInNotification(a.d, &email.SignInNotificationModel{
+			To:          address.Value,
+			SettingsURL: settingsURL,
+			SignedInAt:  s.AuthenticatedAt,
+			IPAddress:   pointerx.StringR(device.IPAddress),
+			UserAgent:   pointerx.StringR(device.UserAgent),
+			Location:    location,
+			Reasons:     reasons,
+			Identity:    model,
+		})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
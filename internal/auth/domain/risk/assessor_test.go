@@ -0,0 +1,231 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/httpx"
+	"github.com/ory/x/jsonnetsecure"
+	"github.com/ory/x/logrusx"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/pointerx"
+	"my.com/secrets/internal/auth/domain/courier"
+	"my.com/secrets/internal/auth/domain/courier/template/email"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/geoip"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type (
+	testDeps struct {
+		jsonnetsecure.VMProvider
+
+		c       *config.Config
+		history []session.Session
+		courier *testCourier
+		scorer  Scorer
+	}
+
+	testCourier struct {
+		courier.Courier
+		queued []courier.EmailTemplate
+	}
+
+	testSessionPersister struct {
+		session.Persister
+		d *testDeps
+	}
+
+	testResolver map[string]*geoip.Location
+)
+
+func (d *testDeps) Config() *config.Config                           { return d.c }
+func (d *testDeps) CourierConfig() config.CourierConfigs             { return d.c }
+func (d *testDeps) Logger() *logrusx.Logger                          { return logrusx.New("", "") }
+func (d *testDeps) Audit() *logrusx.Logger                           { return logrusx.New("", "") }
+func (d *testDeps) Courier(context.Context) (courier.Courier, error) { return d.courier, nil }
+func (d *testDeps) GeoIPResolver() geoip.Resolver                    { return resolver }
+func (d *testDeps) IdentityPool() identity.Pool                      { return nil }
+func (d *testDeps) SessionPersister() session.Persister              { return &testSessionPersister{d: d} }
+func (d *testDeps) RiskScorer() Scorer                               { return d.scorer }
+func (d *testDeps) Tracer(context.Context) *otelx.Tracer {
+	return otelx.NewNoop(logrusx.New("", ""), new(otelx.Config))
+}
+func (d *testDeps) HTTPClient(context.Context, ...httpx.ResilientOptions) *retryablehttp.Client {
+	return httpx.NewResilientClient()
+}
+
+func (c *testCourier) QueueEmail(_ context.Context, t courier.EmailTemplate) (uuid.UUID, error) {
+	c.queued = append(c.queued, t)
+	return x.NewUUID(), nil
+}
+
+func (p *testSessionPersister) ListSessionsByIdentity(context.Context, uuid.UUID, *bool, int, int, uuid.UUID, session.Expandables) ([]session.Session, int64, error) {
+	return p.d.history, int64(len(p.d.history)), nil
+}
+
+func (r testResolver) Resolve(_ context.Context, ip net.IP) (*geoip.Location, error) {
+	if l, ok := r[ip.String()]; ok {
+		location := *l
+		return &location, nil
+	}
+	return new(geoip.Location), nil
+}
+
+type scorerFunc func(ctx context.Context, i *identity.Identity, signals *Signals) (float64, error)
+
+func (f scorerFunc) Score(ctx context.Context, i *identity.Identity, signals *Signals) (float64, error) {
+	return f(ctx, i, signals)
+}
+
+const (
+	firefoxLinux  = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	chromeWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+)
+
+var resolver = testResolver{
+	"192.0.2.1":    {City: "Berlin", Country: "DE", ASN: 3320, Coordinates: &geoip.Coordinates{Latitude: 52.52, Longitude: 13.405}},
+	"192.0.2.2":    {City: "Hamburg", Country: "DE", ASN: 3320, Coordinates: &geoip.Coordinates{Latitude: 53.5511, Longitude: 9.9937}},
+	"198.51.100.1": {City: "New York", Country: "US", ASN: 7018, Coordinates: &geoip.Coordinates{Latitude: 40.7128, Longitude: -74.006}},
+}
+
+func device(ip, userAgent string) session.Device {
+	d := session.Device{IPAddress: pointerx.Ptr(ip), UserAgent: pointerx.Ptr(userAgent)}
+	if l, ok := resolver[ip]; ok {
+		d.Country, d.ASN = pointerx.Ptr(l.Country), pointerx.Ptr(int64(l.ASN))
+	}
+	return d
+}
+
+func TestAssessor(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, "https://kratos.example.com/")
+
+	now := time.Now()
+	i := identity.NewIdentity("default")
+	i.ID = x.NewUUID()
+	i.VerifiableAddresses = []identity.VerifiableAddress{{Value: "foo@ory.sh", Via: identity.AddressTypeEmail}}
+
+	newDeps := func(history ...session.Session) *testDeps {
+		d := &testDeps{c: conf, history: history, courier: new(testCourier)}
+		d.scorer = NewDefaultScorer(d)
+		return d
+	}
+	previous := func(lastSeenAgo time.Duration, devices ...session.Device) session.Session {
+		return session.Session{ID: x.NewUUID(), IdentityID: i.ID, AuthenticatedAt: now.Add(-lastSeenAgo), Devices: devices}
+	}
+	login := func(t *testing.T, d *testDeps, device session.Device) *session.Session {
+		a := NewAssessor(d)
+		a.nowFunc = func() time.Time { return now }
+		s := &session.Session{IdentityID: i.ID, Identity: i, AuthenticatedAt: now, Devices: []session.Device{device}}
+		a.AssessLogin(ctx, s)
+		require.NotNil(t, s.RiskAssessment)
+		return s
+	}
+
+	t.Run("case=first login", func(t *testing.T) {
+		d := newDeps()
+		s := login(t, d, device("198.51.100.1", chromeWindows))
+
+		assert.Zero(t, s.RiskAssessment.Score)
+		assert.EqualValues(t, 50, s.RiskAssessment.Threshold)
+		assert.Empty(t, s.RiskAssessment.Reasons)
+		assert.False(t, s.RequiresStepUp())
+		assert.Empty(t, d.courier.queued)
+	})
+
+	t.Run("case=known device and location", func(t *testing.T) {
+		d := newDeps(previous(24*time.Hour, device("192.0.2.1", firefoxLinux)))
+		s := login(t, d, device("192.0.2.1", firefoxLinux))
+
+		assert.Zero(t, s.RiskAssessment.Score)
+		assert.Empty(t, s.RiskAssessment.Reasons)
+		assert.False(t, s.RequiresStepUp())
+		assert.Empty(t, d.courier.queued)
+	})
+
+	t.Run("case=new device and location", func(t *testing.T) {
+		d := newDeps(previous(24*time.Hour, device("192.0.2.1", firefoxLinux)))
+		s := login(t, d, device("198.51.100.1", chromeWindows))
+
+		assert.EqualValues(t, 80, s.RiskAssessment.Score)
+		assert.Equal(t, []string{ReasonNewUserAgentFamily, ReasonNewCountry, ReasonNewASN}, s.RiskAssessment.Reasons)
+		assert.True(t, s.RequiresStepUp())
+
+		require.Len(t, d.courier.queued, 1)
+		notification, ok := d.courier.queued[0].(*email.SignInNotification)
+		require.True(t, ok)
+		recipient, err := notification.EmailRecipient()
+		require.NoError(t, err)
+		assert.Equal(t, "foo@ory.sh", recipient)
+	})
+
+	t.Run("case=new device only", func(t *testing.T) {
+		d := newDeps(previous(24*time.Hour, device("192.0.2.1", firefoxLinux)))
+		s := login(t, d, device("192.0.2.1", chromeWindows))
+
+		assert.EqualValues(t, 20, s.RiskAssessment.Score)
+		assert.Equal(t, []string{ReasonNewUserAgentFamily}, s.RiskAssessment.Reasons)
+		assert.False(t, s.RequiresStepUp())
+		assert.Len(t, d.courier.queued, 1)
+	})
+
+	t.Run("case=impossible travel", func(t *testing.T) {
+		d := newDeps(previous(10*time.Minute, device("192.0.2.2", firefoxLinux)))
+		s := login(t, d, device("192.0.2.1", firefoxLinux))
+
+		assert.EqualValues(t, 60, s.RiskAssessment.Score)
+		assert.Equal(t, []string{ReasonImpossibleTravel}, s.RiskAssessment.Reasons)
+		assert.True(t, s.RequiresStepUp())
+	})
+
+	t.Run("case=plausible travel", func(t *testing.T) {
+		d := newDeps(previous(3*time.Hour, device("192.0.2.2", firefoxLinux)))
+		a := NewAssessor(d)
+		a.nowFunc = func() time.Time { return now }
+
+		signals, err := a.Signals(ctx, &session.Session{IdentityID: i.ID, Devices: []session.Device{device("192.0.2.1", firefoxLinux)}})
+		require.NoError(t, err)
+		assert.InDelta(t, 85, signals.TravelSpeed, 5)
+		assert.False(t, signals.ImpossibleTravel)
+		assert.Empty(t, signals.Reasons())
+	})
+
+	t.Run("case=scorer fails", func(t *testing.T) {
+		d := newDeps(previous(24*time.Hour, device("192.0.2.1", firefoxLinux)))
+		d.scorer = scorerFunc(func(context.Context, *identity.Identity, *Signals) (float64, error) {
+			return 0, errors.New("scorer is unavailable")
+		})
+		s := login(t, d, device("192.0.2.1", firefoxLinux))
+
+		assert.Equal(t, []string{ReasonAssessmentFailed}, s.RiskAssessment.Reasons)
+		assert.True(t, s.RequiresStepUp())
+	})
+
+	t.Run("case=notifications disabled", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskNotify, false)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskNotify, true) })
+
+		d := newDeps(previous(24*time.Hour, device("192.0.2.1", firefoxLinux)))
+		s := login(t, d, device("198.51.100.1", chromeWindows))
+
+		assert.True(t, s.RequiresStepUp())
+		assert.Empty(t, d.courier.queued)
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/fetcher"
+	"github.com/ory/x/jsonnetsecure"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type (
+	// Scorer computes the risk score of a login from its signals. Logins with a score at or above the
+	// configured threshold require a second factor.
+	Scorer interface {
+		Score(ctx context.Context, i *identity.Identity, signals *Signals) (float64, error)
+	}
+
+	ScorerProvider interface {
+		RiskScorer() Scorer
+	}
+
+	scorerDependencies interface {
+		config.Provider
+		x.HTTPClientProvider
+		jsonnetsecure.VMProvider
+	}
+
+	// DefaultScorer evaluates the configured Jsonnet scorer if one is set, and sums the weights of the
+	// matching rules otherwise.
+	DefaultScorer struct {
+		d     scorerDependencies
+		cache *ristretto.Cache
+	}
+)
+
+func NewDefaultScorer(d scorerDependencies) *DefaultScorer {
+	cache, _ := ristretto.NewCache(&ristretto.Config{
+		MaxCost:     1 << 20, // 1MB
+		NumCounters: 1_000,
+		BufferItems: 64,
+	})
+	return &DefaultScorer{d: d, cache: cache}
+}
+
+func (s *DefaultScorer) Score(ctx context.Context, i *identity.Identity, signals *Signals) (float64, error) {
+	if url := s.d.Config().SelfServiceFlowLoginRiskScorerURL(ctx); url != "" {
+		return s.scoreJsonnet(ctx, url, i, signals)
+	}
+	return WeightedScore(signals, s.d.Config().SelfServiceFlowLoginRiskRules(ctx)), nil
+}
+
+// WeightedScore sums the weights of the rules which matched.
+func WeightedScore(signals *Signals, rules *config.LoginRiskRules) float64 {
+	var score float64
+	if signals.NewUserAgentFamily {
+		score += rules.NewUserAgentFamily
+	}
+	if signals.NewCountry {
+		score += rules.NewCountry
+	}
+	if signals.NewASN {
+		score += rules.NewASN
+	}
+	if signals.ImpossibleTravel {
+		score += rules.ImpossibleTravel
+	}
+	return score
+}
+
+func (s *DefaultScorer) scoreJsonnet(ctx context.Context, url string, i *identity.Identity, signals *Signals) (float64, error) {
+	vm, err := s.d.JsonnetVM(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for key, value := range map[string]interface{}{
+		"signals":  signals,
+		"rules":    s.d.Config().SelfServiceFlowLoginRiskRules(ctx),
+		"identity": i.CopyWithoutCredentials(),
+	} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return 0, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to encode the risk scorer %s to JSON.", key))
+		}
+		vm.ExtCode(key, string(raw))
+	}
+
+	snippet, err := fetcher.NewFetcher(fetcher.WithClient(s.d.HTTPClient(ctx)), fetcher.WithCache(s.cache, 60*time.Minute)).FetchContext(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+
+	evaluated, err := vm.EvaluateAnonymousSnippet(url, snippet.String())
+	if err != nil {
+		return 0, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithDebug(err.Error()).WithReasonf("Unable to execute the risk scorer Jsonnet."))
+	}
+
+	score := gjson.Get(evaluated, "score")
+	if score.Type != gjson.Number {
+		return 0, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Expected the risk scorer Jsonnet to return a numeric score but it did not."))
+	}
+
+	return score.Float(), nil
+}
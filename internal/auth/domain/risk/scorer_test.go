@@ -0,0 +1,60 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/jsonnetsecure"
+	"github.com/ory/x/logrusx"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+func TestDefaultScorer(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	d := &testDeps{c: conf, VMProvider: jsonnetsecure.NewTestProvider(t)}
+	s := NewDefaultScorer(d)
+	i := identity.NewIdentity("default")
+
+	signals := &Signals{NewCountry: true, NewASN: true, Country: "US"}
+
+	t.Run("case=sums rule weights", func(t *testing.T) {
+		score, err := s.Score(ctx, i, signals)
+		require.NoError(t, err)
+		assert.EqualValues(t, 60, score)
+
+		conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskRules+".new_asn", 0)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskRules+".new_asn", 20) })
+
+		score, err = s.Score(ctx, i, signals)
+		require.NoError(t, err)
+		assert.EqualValues(t, 40, score)
+	})
+
+	t.Run("case=evaluates jsonnet", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskScorerURL,
+			"base64://bG9jYWwgcyA9IHN0ZC5leHRWYXIoJ3NpZ25hbHMnKTsgbG9jYWwgciA9IHN0ZC5leHRWYXIoJ3J1bGVzJyk7IHsgc2NvcmU6IGlmIHMuY291bnRyeSA9PSAnVVMnIHRoZW4gMTAwIGVsc2Ugci5uZXdfY291bnRyeSB9")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskScorerURL, "") })
+
+		score, err := s.Score(ctx, i, signals)
+		require.NoError(t, err)
+		assert.EqualValues(t, 100, score)
+	})
+
+	t.Run("case=rejects jsonnet without score", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskScorerURL, "base64://eyB9")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskScorerURL, "") })
+
+		_, err := s.Score(ctx, i, signals)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import "strings"
+
+// browserMarkers and osMarkers map substrings of user agents to families. The order matters because many user agents
+// include the markers of the browsers they are derived from, for example Edge includes "Chrome/" and
+// Chrome includes "Safari/".
+var (
+	browserMarkers = []struct{ marker, family string }{
+		{"Edg/", "Edge"},
+		{"EdgA/", "Edge"},
+		{"EdgiOS/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	osMarkers = []struct{ marker, family string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Mac OS X", "macOS"},
+		{"Macintosh", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// userAgentFamily returns the browser and operating system family of the user agent, for example
+// "Firefox on Windows". Versions are ignored so that browser updates do not count as new devices.
+// It returns an empty string if the user agent is empty.
+func userAgentFamily(userAgent string) string {
+	if userAgent == "" {
+		return ""
+	}
+
+	browser, os := "Other", "Other"
+	for _, m := range browserMarkers {
+		if strings.Contains(userAgent, m.marker) {
+			browser = m.family
+			break
+		}
+	}
+	for _, m := range osMarkers {
+		if strings.Contains(userAgent, m.marker) {
+			os = m.family
+			break
+		}
+	}
+
+	return browser + " on " + os
+}
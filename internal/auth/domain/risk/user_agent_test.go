@@ -0,0 +1,35 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package risk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentFamily(t *testing.T) {
+	for _, tc := range []struct {
+		userAgent, expected string
+	}{
+		{userAgent: "", expected: ""},
+		{userAgent: "curl/8.4.0", expected: "Other on Other"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", expected: "Chrome on Windows"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91", expected: "Edge on Windows"},
+		{userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15", expected: "Safari on macOS"},
+		{userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", expected: "Firefox on Linux"},
+		{userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1", expected: "Chrome on iOS"},
+		{userAgent: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", expected: "Chrome on Android"},
+	} {
+		t.Run("ua="+tc.userAgent, func(t *testing.T) {
+			assert.Equal(t, tc.expected, userAgentFamily(tc.userAgent))
+		})
+	}
+
+	t.Run("case=ignores versions", func(t *testing.T) {
+		assert.Equal(t,
+			userAgentFamily("Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"),
+			userAgentFamily("Mozilla/5.0 (X11; Linux x86_64; rv:115.0) Gecko/20100101 Firefox/115.0"))
+	})
+}
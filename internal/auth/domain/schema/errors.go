@@ -196,6 +196,16 @@ func NewNoSettingsStrategyResponsible() error {
 	})
 }
 
+func NewPasswordChangeRequiredError() error {
+	return errors.WithStack(&ValidationError{
+		ValidationError: &jsonschema.ValidationError{
+			Message:     `the password must be changed before other settings can be updated`,
+			InstancePtr: "#/",
+		},
+		Messages: new(text.Messages).Add(text.NewErrorValidationSettingsPasswordChangeRequired()),
+	})
+}
+
 func NewNoRecoveryStrategyResponsible() error {
 	return errors.WithStack(&ValidationError{
 		ValidationError: &jsonschema.ValidationError{
@@ -370,3 +380,13 @@ func NewUnknownAddressError() error {
 	},
 	)
 }
+
+func NewMaxConcurrentSessionsError(limit int) error {
+	return errors.WithStack(&ValidationError{
+		ValidationError: &jsonschema.ValidationError{
+			Message:     fmt.Sprintf(`the maximum of %d concurrent sessions has been reached`, limit),
+			InstancePtr: "#/",
+		},
+		Messages: new(text.Messages).Add(text.NewErrorValidationLoginMaxConcurrentSessions(limit)),
+	})
+}
@@ -11,11 +11,16 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/ory/jsonschema/v3"
+	"github.com/ory/x/jsonschemax"
 	"my.com/secrets/internal/auth/domain/embedx"
 )
 
 const (
 	extensionName string = "ory.sh/kratos"
+
+	// ExtensionEncrypted is set in the custom properties of paths listed by jsonschemax which are marked as
+	// encrypted.
+	ExtensionEncrypted string = "encrypted"
 )
 
 type (
@@ -41,6 +46,7 @@ type (
 		Recovery struct {
 			Via string `json:"via"`
 		} `json:"recovery"`
+		Encrypted bool                   `json:"encrypted"`
 		RawSchema map[string]interface{} `json:"-"`
 	}
 
@@ -64,6 +70,14 @@ type (
 	ExtensionRunnerOption func(*ExtensionRunner)
 )
 
+// EnhancePath implements jsonschemax.PathEnhancer so that paths which are marked as encrypted can be listed.
+func (e *ExtensionConfig) EnhancePath(jsonschemax.Path) map[string]interface{} {
+	if !e.Encrypted {
+		return nil
+	}
+	return map[string]interface{}{ExtensionEncrypted: true}
+}
+
 func WithValidateRunners(runners ...ValidateExtension) ExtensionRunnerOption {
 	return func(r *ExtensionRunner) {
 		r.validateRunners = append(r.validateRunners, runners...)
@@ -98,9 +98,33 @@ func GetKeysInOrder(ctx context.Context, schemaRef string) ([]string, error) {
 }
 
 type Schema struct {
-	ID     string   `json:"id"`
-	URL    *url.URL `json:"-"`
-	RawURL string   `json:"url"`
+	ID         string      `json:"id"`
+	URL        *url.URL    `json:"-"`
+	RawURL     string      `json:"url"`
+	Version    string      `json:"version,omitempty"`
+	Migrations []Migration `json:"-"`
+}
+
+// Migration describes how the traits of identities are migrated from another schema or schema version.
+type Migration struct {
+	FromID      string
+	FromVersion string
+	URL         string
+}
+
+// FindMigration returns the migration from the given schema ID and version, or nil if none is configured.
+// A migration without a source schema ID migrates from an older version of the same schema.
+func (s *Schema) FindMigration(fromID, fromVersion string) *Migration {
+	for _, m := range s.Migrations {
+		id := m.FromID
+		if id == "" {
+			id = s.ID
+		}
+		if id == fromID && m.FromVersion == fromVersion {
+			return &m
+		}
+	}
+	return nil
 }
 
 func (s *Schema) SchemaURL(host *url.URL) *url.URL {
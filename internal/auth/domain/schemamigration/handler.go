@@ -0,0 +1,174 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemamigration
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/jsonx"
+	"github.com/ory/x/urlx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	RouteCollection = "/schemas/migrations"
+	RouteResume     = RouteCollection + "/:id/resume"
+)
+
+type (
+	handlerDependencies interface {
+		ManagementProvider
+		x.WriterProvider
+		x.CSRFProvider
+		x.LoggingProvider
+		config.Provider
+	}
+	HandlerProvider interface {
+		SchemaMigrationHandler() *Handler
+	}
+	Handler struct {
+		r handlerDependencies
+	}
+)
+
+func NewHandler(r handlerDependencies) *Handler {
+	return &Handler{r: r}
+}
+
+func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
+	h.r.CSRFHandler().IgnoreGlobs(
+		x.AdminPrefix+RouteCollection,
+		x.AdminPrefix+RouteCollection+"/*/resume",
+	)
+
+	public.POST(x.AdminPrefix+RouteCollection, x.RedirectToAdminRoute(h.r))
+	public.POST(x.AdminPrefix+RouteResume, x.RedirectToAdminRoute(h.r))
+}
+
+func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
+	admin.POST(RouteCollection, h.create)
+	admin.POST(RouteResume, h.resume)
+}
+
+// Create Identity Schema Migration Parameters
+//
+// swagger:parameters createIdentitySchemaMigration
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type createIdentitySchemaMigration struct {
+	// in: body
+	// required: true
+	Body Options
+}
+
+// swagger:route POST /admin/schemas/migrations identity createIdentitySchemaMigration
+//
+// # Migrate Identities to another Identity Schema
+//
+// Starts a job which migrates the traits of all identities using a schema ID and version to another schema, or
+// to the current version of the same schema. The traits are transformed by the Jsonnet migration configured
+// on the target schema, if any, and validated against the target schema. Identities which fail to migrate
+// are reported in the job's progress and remain unchanged. Use `dry_run` to only validate the migrated traits.
+//
+// The migration runs in the background. Poll the returned job to follow its progress.
+//
+//	Consumes:
+//	- application/json
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  202: job
+//	  400: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var o Options
+	if err := jsonx.NewStrictDecoder(r.Body).Decode(&o); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithError(err.Error())))
+		return
+	}
+
+	j, err := h.r.SchemaMigrationManager().Start(r.Context(), o)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.run(w, r, j)
+}
+
+// Resume Identity Schema Migration Parameters
+//
+// swagger:parameters resumeIdentitySchemaMigration
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type resumeIdentitySchemaMigration struct {
+	// ID is the ID of the migration job.
+	//
+	// required: true
+	// in: path
+	ID string `json:"id"`
+}
+
+// swagger:route POST /admin/schemas/migrations/{id}/resume identity resumeIdentitySchemaMigration
+//
+// # Resume an Identity Schema Migration
+//
+// Continues a failed or paused identity schema migration after the last identity it processed. Migrations which
+// are running or have succeeded can not be resumed.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  202: job
+//	  404: errorGeneric
+//	  409: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) resume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	j, err := h.r.SchemaMigrationManager().Resume(r.Context(), x.ParseUUID(ps.ByName("id")))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	h.run(w, r, j)
+}
+
+// run executes the migration in the background and responds with the job.
+func (h *Handler) run(w http.ResponseWriter, r *http.Request, j *job.Job) {
+	response := *j
+
+	// The migration outlives the request, so it must not be canceled together with it.
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := h.r.SchemaMigrationManager().Run(ctx, j); err != nil {
+			h.r.Logger().WithError(err).WithField("job_id", j.ID).Error("The identity schema migration failed.")
+		}
+	}()
+
+	w.Header().Set("Location", urlx.AppendPaths(h.r.Config().SelfAdminURL(r.Context()), job.RouteCollection, j.ID.String()).String())
+	h.r.Writer().WriteCode(w, r, http.StatusAccepted, &response)
+}
@@ -0,0 +1,296 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemamigration
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/fetcher"
+	"github.com/ory/x/jsonnetsecure"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlxx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/schema"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	defaultBatchSize = 100
+	maxBatchSize     = 1000
+
+	// staleAfter is the time after which a running migration whose progress was not updated is considered to be
+	// interrupted, because the process running it stopped without pausing it.
+	staleAfter = 10 * time.Minute
+)
+
+type (
+	managerDependencies interface {
+		PersistenceProvider
+		job.PersistenceProvider
+		identity.PrivilegedPoolProvider
+		identity.ManagementProvider
+		schema.IdentityTraitsProvider
+		config.Provider
+		jsonnetsecure.VMProvider
+		x.HTTPClientProvider
+		x.LoggingProvider
+		x.TracingProvider
+	}
+	ManagementProvider interface {
+		SchemaMigrationManager() *Manager
+	}
+	Manager struct {
+		r managerDependencies
+	}
+)
+
+func NewManager(r managerDependencies) *Manager {
+	return &Manager{r: r}
+}
+
+// Start checks the options and creates the migration job. The migration itself is executed by Run.
+func (m *Manager) Start(ctx context.Context, o Options) (_ *job.Job, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "schemamigration.Manager.Start")
+	defer otelx.End(span, &err)
+
+	if o.FromSchemaID == "" {
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReason("The schema ID to migrate from must be set."))
+	}
+	if o.ToSchemaID == "" {
+		o.ToSchemaID = o.FromSchemaID
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.BatchSize < 1 || o.BatchSize > maxBatchSize {
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReasonf("The batch size must be between 1 and %d.", maxBatchSize))
+	}
+
+	target, err := m.targetSchema(ctx, o.ToSchemaID)
+	if err != nil {
+		return nil, err
+	}
+	if target.ID == o.FromSchemaID && target.Version == o.FromVersion {
+		return nil, errors.WithStack(herodot.ErrBadRequest.WithReasonf("The identities already use version %q of schema %q.", target.Version, target.ID))
+	}
+
+	progress, err := json.Marshal(&Progress{Options: o, ToVersion: target.Version, Failures: []Failure{}})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	j := job.NewJob(JobTypeSchemaMigration, uuid.Nil)
+	j.Progress = progress
+	if err := m.r.JobPersister().CreateJob(ctx, j); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Resume marks the failed or paused migration job with the given ID as running and returns it, so that it can be
+// continued with Run. Running jobs can only be resumed once they were not updated for staleAfter.
+func (m *Manager) Resume(ctx context.Context, id uuid.UUID) (_ *job.Job, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "schemamigration.Manager.Resume")
+	defer otelx.End(span, &err)
+
+	j, err := m.r.JobPersister().GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if j.Type != JobTypeSchemaMigration {
+		return nil, errors.WithStack(herodot.ErrNotFound.WithReasonf("The job %s is not an identity schema migration.", id))
+	}
+	if j.State == job.StateSucceeded {
+		return nil, errors.WithStack(herodot.ErrConflict.WithReasonf("The identity schema migration %s has already finished.", id))
+	}
+
+	resumed, err := m.r.JobPersister().ResumeJob(ctx, id, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	} else if !resumed {
+		return nil, errors.WithStack(herodot.ErrConflict.WithReasonf("The identity schema migration %s is already running.", id))
+	}
+
+	return m.r.JobPersister().GetJob(ctx, id)
+}
+
+// Run migrates the job's identities in batches. The progress is stored after every batch, so a migration
+// which was interrupted continues after the last processed identity. Identities which fail to migrate are
+// reported in the progress and do not stop the migration.
+func (m *Manager) Run(ctx context.Context, j *job.Job) (err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "schemamigration.Manager.Run")
+	defer otelx.End(span, &err)
+
+	var p Progress
+	if err := json.Unmarshal(j.Progress, &p); err != nil {
+		return m.fail(ctx, j, errors.WithStack(err))
+	}
+
+	target, err := m.targetSchema(ctx, p.Options.ToSchemaID)
+	if err != nil {
+		return m.fail(ctx, j, err)
+	}
+	if target.Version != p.ToVersion {
+		return m.fail(ctx, j, errors.Errorf("the version of schema %q changed from %q to %q while the migration was running", target.ID, p.ToVersion, target.Version))
+	}
+
+	var snippet []byte
+	if migration := target.FindMigration(p.Options.FromSchemaID, p.Options.FromVersion); migration != nil {
+		buf, err := fetcher.NewFetcher(fetcher.WithClient(m.r.HTTPClient(ctx))).FetchContext(ctx, migration.URL)
+		if err != nil {
+			return m.fail(ctx, j, err)
+		}
+		snippet = buf.Bytes()
+	}
+
+	for {
+		ids, err := m.r.SchemaMigrationPersister().ListIdentityIDsBySchema(ctx, p.Options.FromSchemaID, p.Options.FromVersion, p.Cursor, p.Options.BatchSize)
+		if err != nil {
+			return m.fail(ctx, j, err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		for _, id := range ids {
+			if err := m.migrate(ctx, id, target, snippet, p.Options.DryRun); err != nil {
+				if ctx.Err() != nil {
+					return m.pause(ctx, j, err)
+				}
+				p.fail(id, err)
+			} else {
+				p.Migrated++
+			}
+			p.Processed++
+			p.Cursor = id
+		}
+
+		if j.Progress, err = json.Marshal(&p); err != nil {
+			return m.fail(ctx, j, errors.WithStack(err))
+		}
+		if err := m.r.JobPersister().UpdateJob(ctx, j); err != nil {
+			return err
+		}
+	}
+
+	result, err := json.Marshal(&p)
+	if err != nil {
+		return m.fail(ctx, j, errors.WithStack(err))
+	}
+	j.Succeed(result)
+	if err := m.r.JobPersister().UpdateJob(ctx, j); err != nil {
+		return err
+	}
+
+	m.r.Audit().
+		WithField("job_id", j.ID).
+		WithField("from_schema_id", p.Options.FromSchemaID).
+		WithField("from_version", p.Options.FromVersion).
+		WithField("to_schema_id", target.ID).
+		WithField("to_version", target.Version).
+		WithField("dry_run", p.Options.DryRun).
+		WithField("migrated", p.Migrated).
+		WithField("failed", p.Failed).
+		Info("Identities were migrated to another identity schema.")
+	return nil
+}
+
+func (m *Manager) migrate(ctx context.Context, id uuid.UUID, target *schema.Schema, snippet []byte, dryRun bool) error {
+	i, err := m.r.PrivilegedIdentityPool().GetIdentityConfidential(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if snippet != nil {
+		if err := m.migrateTraits(ctx, i, snippet); err != nil {
+			return err
+		}
+	}
+	i.SchemaID = target.ID
+
+	if dryRun {
+		return m.r.IdentityManager().ValidateIdentity(ctx, i, &identity.ManagerOptions{ExposeValidationErrors: true})
+	}
+
+	return m.r.IdentityManager().Update(ctx, i,
+		identity.ManagerAllowWriteProtectedTraits,
+		identity.ManagerExposeValidationErrorsForInternalTypeAssertion,
+	)
+}
+
+func (m *Manager) migrateTraits(ctx context.Context, i *identity.Identity, snippet []byte) error {
+	raw, err := json.Marshal(i.CopyWithoutCredentials())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	vm, err := m.r.JsonnetVM(ctx)
+	if err != nil {
+		return err
+	}
+	vm.ExtCode("identity", string(raw))
+
+	evaluated, err := vm.EvaluateAnonymousSnippet("migration.jsonnet", string(snippet))
+	if err != nil {
+		return errors.WithStack(herodot.ErrBadRequest.WithReasonf("Unable to evaluate the traits migration: %s", err))
+	}
+
+	traits := gjson.Get(evaluated, "traits")
+	if !traits.IsObject() {
+		return errors.WithStack(herodot.ErrBadRequest.WithReason("The traits migration must return an object containing the migrated traits."))
+	}
+	i.Traits = identity.Traits(traits.Raw)
+
+	if metadata := gjson.Get(evaluated, "metadata_public"); metadata.Exists() {
+		i.MetadataPublic = sqlxx.NullJSONRawMessage(metadata.Raw)
+	}
+	if metadata := gjson.Get(evaluated, "metadata_admin"); metadata.Exists() {
+		i.MetadataAdmin = sqlxx.NullJSONRawMessage(metadata.Raw)
+	}
+
+	return nil
+}
+
+func (m *Manager) targetSchema(ctx context.Context, id string) (*schema.Schema, error) {
+	ss, err := m.r.IdentityTraitsSchemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ss.GetByID(id)
+}
+
+// fail marks the job as failed and returns the reason. If the context was canceled, the job is paused instead so
+// that it can be resumed.
+func (m *Manager) fail(ctx context.Context, j *job.Job, err error) error {
+	if ctx.Err() != nil {
+		return m.pause(ctx, j, err)
+	}
+
+	j.Fail(err)
+	if updateErr := m.r.JobPersister().UpdateJob(ctx, j); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+// pause marks the job as paused after the context was canceled, for example because the process shuts down, so
+// that it can be resumed.
+func (m *Manager) pause(ctx context.Context, j *job.Job, err error) error {
+	j.Pause()
+	if updateErr := m.r.JobPersister().UpdateJob(context.WithoutCancel(ctx), j); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemamigration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/herodot"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/schemamigration"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestMigration(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	publicTS, adminTS := testhelpers.NewKratosServerWithCSRF(t, reg)
+	conf.MustSet(ctx, config.ViperKeyAdminBaseURL, adminTS.URL)
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, publicTS.URL)
+
+	useV1 := func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyIdentitySchemas, []map[string]interface{}{
+			{"id": config.DefaultIdentityTraitsSchemaID, "url": "file://./stub/v1.schema.json"},
+			{"id": "customer", "url": "file://./stub/v2.schema.json", "version": "1", "migrations": []map[string]interface{}{
+				{"from_id": config.DefaultIdentityTraitsSchemaID, "url": "file://./stub/v1-v2.jsonnet"},
+			}},
+		})
+	}
+	useV2 := func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyIdentitySchemas, []map[string]interface{}{
+			{"id": config.DefaultIdentityTraitsSchemaID, "url": "file://./stub/v2.schema.json", "version": "2", "migrations": []map[string]interface{}{
+				{"url": "file://./stub/v1-v2.jsonnet"},
+			}},
+		})
+		t.Cleanup(func() { useV1(t) })
+	}
+	useV1(t)
+
+	// createIdentities creates two identities which can be migrated and one which can not be migrated.
+	createIdentities := func(t *testing.T) (migratable []*identity.Identity, broken *identity.Identity) {
+		for _, traits := range []string{
+			`{"email":"` + x.NewUUID().String() + `@ory.sh","name":"Ada Lovelace"}`,
+			`{"email":"` + x.NewUUID().String() + `@ory.sh","name":"Grace Brewster Hopper"}`,
+			`{"email":"` + x.NewUUID().String() + `@ory.sh"}`,
+		} {
+			i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+			i.Traits = identity.Traits(traits)
+			require.NoError(t, reg.IdentityManager().Create(ctx, i))
+			assert.Empty(t, i.SchemaVersion)
+			migratable = append(migratable, i)
+		}
+		return migratable[:2], migratable[2]
+	}
+
+	deleteIdentities := func(t *testing.T) {
+		t.Cleanup(func() {
+			is, _, err := reg.PrivilegedIdentityPool().ListIdentities(ctx, identity.ListIdentityParameters{})
+			require.NoError(t, err)
+			for _, i := range is {
+				require.NoError(t, reg.PrivilegedIdentityPool().DeleteIdentity(ctx, i.ID))
+			}
+		})
+	}
+
+	getProgress := func(t *testing.T, j *job.Job) (p schemamigration.Progress) {
+		actual, err := reg.JobPersister().GetJob(ctx, j.ID)
+		require.NoError(t, err)
+		raw := actual.Progress
+		if actual.IsFinished() {
+			assert.Equal(t, job.StateSucceeded, actual.State, "%s", actual.Error)
+			raw = actual.Result
+		}
+		require.NoError(t, json.Unmarshal(raw, &p))
+		return p
+	}
+
+	get := func(t *testing.T, id uuid.UUID) *identity.Identity {
+		i, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, id)
+		require.NoError(t, err)
+		return i
+	}
+
+	t.Run("case=dry run validates without updating identities", func(t *testing.T) {
+		deleteIdentities(t)
+		migratable, broken := createIdentities(t)
+		useV2(t)
+
+		j, err := reg.SchemaMigrationManager().Start(ctx, schemamigration.Options{FromSchemaID: config.DefaultIdentityTraitsSchemaID, DryRun: true})
+		require.NoError(t, err)
+		require.NoError(t, reg.SchemaMigrationManager().Run(ctx, j))
+
+		p := getProgress(t, j)
+		assert.Equal(t, "2", p.ToVersion)
+		assert.Equal(t, 3, p.Processed)
+		assert.Equal(t, 2, p.Migrated)
+		assert.Equal(t, 1, p.Failed)
+		require.Len(t, p.Failures, 1)
+		assert.Equal(t, broken.ID, p.Failures[0].IdentityID)
+		assert.NotEmpty(t, p.Failures[0].Reason)
+
+		for _, i := range migratable {
+			assert.Equal(t, string(i.Traits), string(get(t, i.ID).Traits))
+			assert.Empty(t, get(t, i.ID).SchemaVersion)
+		}
+	})
+
+	t.Run("case=migrates identities to the new schema version", func(t *testing.T) {
+		deleteIdentities(t)
+		migratable, broken := createIdentities(t)
+		useV2(t)
+
+		var b bytes.Buffer
+		require.NoError(t, json.NewEncoder(&b).Encode(schemamigration.Options{FromSchemaID: config.DefaultIdentityTraitsSchemaID, BatchSize: 1}))
+		res, err := publicTS.Client().Post(publicTS.URL+x.AdminPrefix+schemamigration.RouteCollection, "application/json", &b)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, res.StatusCode, "%s", body)
+		assert.Contains(t, res.Header.Get("Location"), job.RouteCollection+"/"+gjson.GetBytes(body, "id").String())
+
+		j := &job.Job{ID: x.ParseUUID(gjson.GetBytes(body, "id").String())}
+		require.EventuallyWithT(t, func(t *assert.CollectT) {
+			actual, err := reg.JobPersister().GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.True(t, actual.IsFinished())
+		}, 10*time.Second, 10*time.Millisecond)
+
+		p := getProgress(t, j)
+		assert.Equal(t, 2, p.Migrated)
+		assert.Equal(t, 1, p.Failed)
+
+		ada := get(t, migratable[0].ID)
+		assert.Equal(t, "2", ada.SchemaVersion)
+		assert.Equal(t, "Ada", gjson.GetBytes(ada.Traits, "first_name").String())
+		assert.Equal(t, "Lovelace", gjson.GetBytes(ada.Traits, "last_name").String())
+		assert.Equal(t, "v1", gjson.GetBytes(ada.MetadataAdmin, "migrated_from").String())
+		assert.Equal(t, "Brewster Hopper", gjson.GetBytes(get(t, migratable[1].ID).Traits, "last_name").String())
+
+		actual := get(t, broken.ID)
+		assert.Empty(t, actual.SchemaVersion)
+		assert.Equal(t, string(broken.Traits), string(actual.Traits))
+
+		t.Run("case=migrated identities are not migrated again", func(t *testing.T) {
+			j, err := reg.SchemaMigrationManager().Start(ctx, schemamigration.Options{FromSchemaID: config.DefaultIdentityTraitsSchemaID})
+			require.NoError(t, err)
+			require.NoError(t, reg.SchemaMigrationManager().Run(ctx, j))
+			p := getProgress(t, j)
+			assert.Equal(t, 1, p.Processed)
+			assert.Equal(t, 1, p.Failed)
+		})
+	})
+
+	t.Run("case=migrates identities to another schema", func(t *testing.T) {
+		deleteIdentities(t)
+		migratable, _ := createIdentities(t)
+
+		j, err := reg.SchemaMigrationManager().Start(ctx, schemamigration.Options{FromSchemaID: config.DefaultIdentityTraitsSchemaID, ToSchemaID: "customer"})
+		require.NoError(t, err)
+		require.NoError(t, reg.SchemaMigrationManager().Run(ctx, j))
+		assert.Equal(t, 2, getProgress(t, j).Migrated)
+
+		actual := get(t, migratable[0].ID)
+		assert.Equal(t, "customer", actual.SchemaID)
+		assert.Equal(t, "1", actual.SchemaVersion)
+		assert.Equal(t, "Ada", gjson.GetBytes(actual.Traits, "first_name").String())
+	})
+
+	t.Run("case=resumes an interrupted migration", func(t *testing.T) {
+		deleteIdentities(t)
+		migratable, _ := createIdentities(t)
+		useV2(t)
+
+		j, err := reg.SchemaMigrationManager().Start(ctx, schemamigration.Options{FromSchemaID: config.DefaultIdentityTraitsSchemaID})
+		require.NoError(t, err)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		require.Error(t, reg.SchemaMigrationManager().Run(canceled, j))
+
+		actual, err := reg.JobPersister().GetJob(ctx, j.ID)
+		require.NoError(t, err)
+		assert.Equal(t, job.StatePaused, actual.State)
+
+		resume := func(t *testing.T) int {
+			res, err := adminTS.Client().Post(adminTS.URL+"/schemas/migrations/"+j.ID.String()+"/resume", "application/json", nil)
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			return res.StatusCode
+		}
+		require.Equal(t, http.StatusAccepted, resume(t))
+		// The first request claimed the job, so it is not run twice.
+		assert.Equal(t, http.StatusConflict, resume(t))
+
+		require.EventuallyWithT(t, func(t *assert.CollectT) {
+			actual, err := reg.JobPersister().GetJob(ctx, j.ID)
+			require.NoError(t, err)
+			assert.True(t, actual.IsFinished())
+		}, 10*time.Second, 10*time.Millisecond)
+		assert.Equal(t, 2, getProgress(t, j).Migrated)
+		assert.Equal(t, "2", get(t, migratable[0].ID).SchemaVersion)
+
+		_, err = reg.SchemaMigrationManager().Resume(ctx, j.ID)
+		require.ErrorIs(t, err, herodot.ErrConflict)
+	})
+
+	t.Run("case=rejects invalid options", func(t *testing.T) {
+		for name, o := range map[string]schemamigration.Options{
+			"no source schema":    {},
+			"unknown schema":      {FromSchemaID: config.DefaultIdentityTraitsSchemaID, ToSchemaID: "does-not-exist"},
+			"same schema version": {FromSchemaID: config.DefaultIdentityTraitsSchemaID},
+			"batch size too big":  {FromSchemaID: config.DefaultIdentityTraitsSchemaID, ToSchemaID: "customer", BatchSize: 100000},
+		} {
+			t.Run("case="+name, func(t *testing.T) {
+				var b bytes.Buffer
+				require.NoError(t, json.NewEncoder(&b).Encode(o))
+				res, err := adminTS.Client().Post(adminTS.URL+schemamigration.RouteCollection, "application/json", &b)
+				require.NoError(t, err)
+				require.NoError(t, res.Body.Close())
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			})
+		}
+	})
+}
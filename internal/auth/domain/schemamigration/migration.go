@@ -0,0 +1,109 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemamigration
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+
+	"my.com/secrets/internal/auth/domain/job"
+)
+
+// JobTypeSchemaMigration is the job type of identity schema migrations.
+const JobTypeSchemaMigration job.Type = "identity_schema_migration"
+
+// maxReportedFailures limits how many failed identities are listed in a migration's progress and result.
+const maxReportedFailures = 1000
+
+// Identity Schema Migration Options
+//
+// swagger:model identitySchemaMigrationOptions
+type Options struct {
+	// FromSchemaID is the ID of the schema whose identities are migrated.
+	//
+	// required: true
+	FromSchemaID string `json:"from_schema_id"`
+
+	// FromVersion is the schema version whose identities are migrated. Leave empty to migrate identities
+	// which have no schema version.
+	FromVersion string `json:"from_version"`
+
+	// ToSchemaID is the ID of the schema the identities are migrated to. The identities are migrated to
+	// the schema's current version. Defaults to the source schema ID.
+	ToSchemaID string `json:"to_schema_id"`
+
+	// DryRun validates the migrated traits without updating any identity.
+	DryRun bool `json:"dry_run"`
+
+	// BatchSize is the number of identities migrated per batch. Defaults to 100.
+	BatchSize int `json:"batch_size"`
+}
+
+// Identity Schema Migration Failure
+//
+// swagger:model identitySchemaMigrationFailure
+type Failure struct {
+	// IdentityID is the ID of the identity which could not be migrated.
+	//
+	// required: true
+	IdentityID uuid.UUID `json:"identity_id"`
+
+	// Reason explains why the identity could not be migrated.
+	//
+	// required: true
+	Reason string `json:"reason"`
+}
+
+// Identity Schema Migration Progress
+//
+// The progress is stored in the migration job and is its result once the job has finished.
+//
+// swagger:model identitySchemaMigrationProgress
+type Progress struct {
+	// Options are the options the migration was started with.
+	//
+	// required: true
+	Options Options `json:"options"`
+
+	// ToVersion is the version of the target schema.
+	ToVersion string `json:"to_version"`
+
+	// Cursor is the ID of the last identity which was processed.
+	Cursor uuid.UUID `json:"cursor"`
+
+	// Processed is the number of identities which were processed.
+	//
+	// required: true
+	Processed int `json:"processed"`
+
+	// Migrated is the number of identities which were migrated, or which would have been migrated in a dry run.
+	//
+	// required: true
+	Migrated int `json:"migrated"`
+
+	// Failed is the number of identities which could not be migrated.
+	//
+	// required: true
+	Failed int `json:"failed"`
+
+	// Failures lists the identities which could not be migrated, up to the first 1000.
+	//
+	// required: true
+	Failures []Failure `json:"failures"`
+}
+
+func (p *Progress) fail(id uuid.UUID, err error) {
+	p.Failed++
+	if len(p.Failures) >= maxReportedFailures {
+		return
+	}
+
+	reason := err.Error()
+	if e := new(herodot.DefaultError); errors.As(err, &e) && e.Reason() != "" {
+		reason = e.Reason()
+	}
+	p.Failures = append(p.Failures, Failure{IdentityID: id, Reason: reason})
+}
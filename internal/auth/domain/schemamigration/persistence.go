@@ -0,0 +1,21 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package schemamigration
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+)
+
+type (
+	Persister interface {
+		// ListIdentityIDsBySchema returns up to limit IDs, in ascending order, of the identities with the given
+		// schema ID and version whose ID is greater than after.
+		ListIdentityIDsBySchema(ctx context.Context, schemaID, version string, after uuid.UUID, limit int) ([]uuid.UUID, error)
+	}
+	PersistenceProvider interface {
+		SchemaMigrationPersister() Persister
+	}
+)
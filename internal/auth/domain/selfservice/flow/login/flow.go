@@ -135,6 +135,9 @@ type Flow struct {
 	// required: true
 	State State `json:"state" faker:"-" db:"state"`
 
+	// IdentityID is the ID of the identity which signed in with this flow. It is only used internally.
+	IdentityID uuid.NullUUID `json:"-" faker:"-" db:"identity_id"`
+
 	// Only used internally
 	IDToken string `json:"-" db:"-"`
 
@@ -18,6 +18,7 @@ import (
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/hydra"
 	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/risk"
 	"my.com/secrets/internal/auth/domain/schema"
 	"my.com/secrets/internal/auth/domain/selfservice/flow"
 	"my.com/secrets/internal/auth/domain/selfservice/sessiontokenexchange"
@@ -55,6 +56,9 @@ type (
 		x.LoggingProvider
 		x.TracingProvider
 		sessiontokenexchange.PersistenceProvider
+		risk.AssessorProvider
+		session.RefreshTokenManagerProvider
+		session.DPoPVerifierProvider
 
 		FlowPersistenceProvider
 		HooksProvider
@@ -140,6 +144,20 @@ func (e *HookExecutor) PostLoginHook(
 		return err
 	}
 
+	// The flow is linked to the identity, so that it is part of the identity's data export and erasure.
+	if err := e.d.LoginFlowPersister().SetLoginFlowIdentity(r.Context(), a.ID, i.ID); err != nil {
+		return err
+	}
+
+	// Native flows which finish in the browser hand the session over with a code, which the client exchanges for
+	// the session token. The browser can not send DPoP proofs, so such sessions are bound when the code is
+	// exchanged instead.
+	if _, hasCode, _ := e.d.SessionTokenExchangePersister().CodeForFlow(r.Context(), a.ID); !hasCode || g != node.OpenIDConnectGroup || a.IDToken != "" {
+		if err := e.d.SessionDPoPVerifier().Bind(r, s, string(a.Type)); err != nil {
+			return err
+		}
+	}
+
 	c := e.d.Config()
 	// Verify the redirect URL before we do any other processing.
 	returnTo, err := x.SecureRedirectTo(r,
@@ -198,9 +216,30 @@ func (e *HookExecutor) PostLoginHook(
 
 	if a.Type == flow.TypeAPI {
 		span.SetAttributes(attribute.String("flow_type", string(flow.TypeAPI)))
+
+		// If the password must be changed, no session token is issued. Native clients change the password in a
+		// browser, as they would do if the session token was rejected.
+		if err := e.d.SessionManager().DoesSessionRequirePasswordChange(r, s); err != nil {
+			if pwErr := new(session.ErrPasswordChangeRequired); errors.As(err, &pwErr) {
+				span.SetAttributes(attribute.String("redirect_reason", "requires password change"))
+				e.d.Audit().
+					WithRequest(r).
+					WithField("identity_id", i.ID).
+					Info("Identity authenticated successfully but must change its password before it is issued an Ory Kratos Session Token.")
+				e.d.Writer().WriteError(w, r, err)
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
 		if err := e.d.SessionPersister().UpsertSession(r.Context(), s); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := e.assessRisk(r.Context(), s); err != nil {
+			return err
+		}
+		classified.RiskAssessment = s.RiskAssessment
+
 		e.d.Audit().
 			WithRequest(r).
 			WithField("session_id", s.ID).
@@ -225,7 +264,12 @@ func (e *HookExecutor) PostLoginHook(
 			return nil
 		}
 
-		response := &APIFlowResponse{Session: s, Token: s.Token}
+		refreshToken, err := e.d.SessionRefreshTokenManager().Issue(r.Context(), s)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		response := &APIFlowResponse{Session: s, Token: s.Token, RefreshToken: refreshToken, TokenExpiresAt: s.TokenExpiresAt}
 		if required, _ := e.requiresAAL2(r, classified, a); required {
 			// If AAL is not satisfied, we omit the identity to preserve the user's privacy in case of a phishing attack.
 			response.Session.Identity = nil
@@ -238,6 +282,9 @@ func (e *HookExecutor) PostLoginHook(
 	if err := e.d.SessionManager().UpsertAndIssueCookie(r.Context(), w, r, s); err != nil {
 		return errors.WithStack(err)
 	}
+	if err := e.assessRisk(r.Context(), s); err != nil {
+		return err
+	}
 
 	e.d.Audit().
 		WithRequest(r).
@@ -267,6 +314,16 @@ func (e *HookExecutor) PostLoginHook(
 			return err
 		}
 
+		// If the password must be changed, we redirect to the settings flow!
+		if err := e.d.SessionManager().DoesSessionRequirePasswordChange(r, s, session.WithRequestURL(returnTo.String())); err != nil {
+			if pwErr := new(session.ErrPasswordChangeRequired); errors.As(err, &pwErr) {
+				span.SetAttributes(attribute.String("return_to", pwErr.RedirectTo), attribute.String("redirect_reason", "requires password change"))
+				e.d.Writer().WriteError(w, r, flow.NewBrowserLocationChangeRequiredError(pwErr.RedirectTo))
+				return nil
+			}
+			return err
+		}
+
 		// If Kratos is used as a Hydra login provider, we need to redirect back to Hydra by returning a 422 status
 		// with the post login challenge URL as the body.
 		if a.OAuth2LoginChallenge != "" {
@@ -299,6 +356,15 @@ func (e *HookExecutor) PostLoginHook(
 		return errors.WithStack(err)
 	}
 
+	// If the password must be changed, we redirect to the settings flow!
+	if err := e.d.SessionManager().DoesSessionRequirePasswordChange(r, s, session.WithRequestURL(returnTo.String())); err != nil {
+		if pwErr := new(session.ErrPasswordChangeRequired); errors.As(err, &pwErr) {
+			http.Redirect(w, r, pwErr.RedirectTo, http.StatusSeeOther)
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
 	finalReturnTo := returnTo.String()
 	if a.OAuth2LoginChallenge != "" {
 		rt, err := e.d.Hydra().AcceptLoginRequest(r.Context(),
@@ -330,6 +396,26 @@ func (e *HookExecutor) PreLoginHook(w http.ResponseWriter, r *http.Request, a *F
 }
 
 // maybeLinkCredentials links the identity with the credentials of the inner context of the login flow.
+// assessRisk assesses the login once the hooks have passed and its session is stored, so that logins which fail
+// or which a hook aborts are neither assessed nor notified about. Only the login which issues the session is
+// assessed. Logins which upgrade the session, for example with a second factor, keep its assessment.
+func (e *HookExecutor) assessRisk(ctx context.Context, s *session.Session) error {
+	if !e.d.Config().SelfServiceFlowLoginRiskEnabled(ctx) || s.RiskAssessment != nil {
+		return nil
+	}
+
+	e.d.RiskAssessor().AssessLogin(ctx, s)
+	if err := e.d.SessionPersister().UpsertSession(ctx, s); err != nil {
+		// The session must not be used without its assessment, which may require a second factor.
+		if err := e.d.SessionPersister().RevokeSessionById(ctx, s.ID); err != nil {
+			e.d.Logger().WithError(err).WithField("session_id", s.ID).
+				Error("Unable to revoke the session whose risk assessment could not be stored.")
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func (e *HookExecutor) maybeLinkCredentials(ctx context.Context, sess *session.Session, ident *identity.Identity, loginFlow *Flow) error {
 	lc, err := flow.DuplicateCredentials(loginFlow)
 	if err != nil {
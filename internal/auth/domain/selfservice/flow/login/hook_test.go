@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/gobuffalo/httptest"
+	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
 	"github.com/tidwall/gjson"
@@ -20,6 +21,7 @@ import (
 	"my.com/secrets/internal/auth/domain/hydra"
 	"my.com/secrets/internal/auth/domain/schema"
 	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/text"
 
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/external"
@@ -286,6 +288,40 @@ func TestLoginExecutor(t *testing.T) {
 					})
 				})
 			})
+			t.Run("case=api client must change its password before a session is issued", func(t *testing.T) {
+				t.Cleanup(testhelpers.SelfServiceHookConfigReset(t, conf))
+
+				useIdentity := &identity.Identity{Credentials: map[identity.CredentialsType]identity.Credentials{
+					identity.CredentialsTypePassword: {Type: identity.CredentialsTypePassword, Config: []byte(`{"hashed_password":"foo","change_required_at":"2020-01-01T00:00:00Z"}`), Identifiers: []string{testhelpers.RandomEmail()}},
+				}}
+				require.NoError(t, reg.Persister().CreateIdentity(context.Background(), useIdentity))
+
+				res, body := makeRequestPost(t, newServer(t, flow.TypeAPI, useIdentity), true, url.Values{})
+				assert.EqualValues(t, http.StatusForbidden, res.StatusCode, "%s", body)
+				assert.Equal(t, text.ErrIDPasswordChangeRequired, gjson.Get(body, "error.id").String(), "%s", body)
+				assert.Contains(t, gjson.Get(body, "redirect_browser_to").String(), "/self-service/settings/browser", "%s", body)
+				assert.Empty(t, gjson.Get(body, "session_token").String(), "%s", body)
+				assert.Empty(t, gjson.Get(body, "refresh_token").String(), "%s", body)
+
+				sessions, _, err := reg.SessionPersister().ListSessionsByIdentity(context.Background(), useIdentity.ID, nil, 1, 10, uuid.Nil, session.ExpandNothing)
+				require.NoError(t, err)
+				assert.Empty(t, sessions)
+			})
+
+			t.Run("case=the risk assessment is stored with the issued session", func(t *testing.T) {
+				t.Cleanup(testhelpers.SelfServiceHookConfigReset(t, conf))
+				conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskEnabled, true)
+				t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySelfServiceLoginRiskEnabled, false) })
+
+				res, body := makeRequestPost(t, newServer(t, flow.TypeAPI, nil), true, url.Values{})
+				require.EqualValues(t, http.StatusOK, res.StatusCode, "%s", body)
+				assert.True(t, gjson.Get(body, "session.risk_assessment").Exists(), "%s", body)
+
+				stored, err := reg.SessionPersister().GetSession(ctx, x.ParseUUID(gjson.Get(body, "session.id").String()), session.ExpandNothing)
+				require.NoError(t, err)
+				assert.NotNil(t, stored.RiskAssessment, "the assessment made after the session was stored must be stored as well")
+			})
+
 			t.Run("case=maybe links credential", func(t *testing.T) {
 				t.Cleanup(testhelpers.SelfServiceHookConfigReset(t, conf))
 
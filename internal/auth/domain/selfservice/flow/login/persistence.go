@@ -16,6 +16,8 @@ type (
 		CreateLoginFlow(context.Context, *Flow) error
 		GetLoginFlow(context.Context, uuid.UUID) (*Flow, error)
 		ForceLoginFlow(ctx context.Context, id uuid.UUID) error
+		// SetLoginFlowIdentity records the identity which signed in with the flow.
+		SetLoginFlowIdentity(ctx context.Context, id, identityID uuid.UUID) error
 		DeleteExpiredLoginFlows(context.Context, time.Time, int) error
 	}
 	FlowPersistenceProvider interface {
@@ -3,7 +3,11 @@
 
 package login
 
-import "my.com/secrets/internal/auth/domain/session"
+import (
+	"time"
+
+	"my.com/secrets/internal/auth/domain/session"
+)
 
 // The Response for Login Flows via API
 //
@@ -19,6 +23,17 @@ type APIFlowResponse struct {
 	// The session token is only issued for API flows, not for Browser flows!
 	Token string `json:"session_token,omitempty"`
 
+	// The Refresh Token
+	//
+	// Exchanges the session token for a new one at `POST /sessions/refresh`. It is only issued for API flows
+	// if refresh tokens are enabled, and it can only be used once.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// The Session Token Expiry
+	//
+	// When the session token expires. It is only set if a refresh token was issued.
+	TokenExpiresAt *time.Time `json:"session_token_expires_at,omitempty"`
+
 	// The Session
 	//
 	// The session contains information about the user, the session device, and so on.
@@ -119,6 +119,9 @@ type Flow struct {
 	// required: true
 	State State `json:"state" faker:"-" db:"state"`
 
+	// IdentityID is the ID of the identity which was registered with this flow. It is only used internally.
+	IdentityID uuid.NullUUID `json:"-" faker:"-" db:"identity_id"`
+
 	// only used internally
 	IDToken string `json:"-" faker:"-" db:"-"`
 	// Only used internally
@@ -22,6 +22,7 @@ import (
 	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
 	"my.com/secrets/internal/auth/domain/selfservice/sessiontokenexchange"
 	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/ui/node"
 	"my.com/secrets/internal/auth/domain/x"
 	"my.com/secrets/internal/auth/domain/x/events"
 )
@@ -79,6 +80,7 @@ type (
 		login.StrategyProvider
 		session.PersistenceProvider
 		session.ManagementProvider
+		session.DPoPVerifierProvider
 		HooksProvider
 		FlowPersistenceProvider
 		hydra.Provider
@@ -180,6 +182,11 @@ func (e *HookExecutor) PostRegistrationHook(w http.ResponseWriter, r *http.Reque
 		return err
 	}
 
+	// The flow is linked to the identity, so that it is part of the identity's data export and erasure.
+	if err := e.d.RegistrationFlowPersister().SetRegistrationFlowIdentity(r.Context(), registrationFlow.ID, i.ID); err != nil {
+		return err
+	}
+
 	// Verify the redirect URL before we do any other processing.
 	c := e.d.Config()
 	returnTo, err := x.SecureRedirectTo(r, c.SelfServiceBrowserDefaultReturnTo(r.Context()),
@@ -213,6 +220,15 @@ func (e *HookExecutor) PostRegistrationHook(w http.ResponseWriter, r *http.Reque
 		return err
 	}
 
+	// Native flows which finish in the browser hand the session over with a code, which the client exchanges for
+	// the session token. The browser can not send DPoP proofs, so such sessions are bound when the code is
+	// exchanged instead.
+	if _, hasCode, _ := e.d.SessionTokenExchangePersister().CodeForFlow(r.Context(), registrationFlow.ID); !hasCode || ct.ToUiNodeGroup() != node.OpenIDConnectGroup || registrationFlow.IDToken != "" {
+		if err := e.d.SessionDPoPVerifier().Bind(r, s, string(registrationFlow.Type)); err != nil {
+			return err
+		}
+	}
+
 	// We persist the session here so that subsequent hooks (like verification) can use it.
 	s.AuthenticatedAt = time.Now().UTC()
 	if err := e.d.SessionPersister().UpsertSession(r.Context(), s); err != nil {
@@ -14,6 +14,8 @@ type FlowPersister interface {
 	UpdateRegistrationFlow(context.Context, *Flow) error
 	CreateRegistrationFlow(context.Context, *Flow) error
 	GetRegistrationFlow(context.Context, uuid.UUID) (*Flow, error)
+	// SetRegistrationFlowIdentity records the identity which was registered with the flow.
+	SetRegistrationFlowIdentity(ctx context.Context, id, identityID uuid.UUID) error
 	DeleteExpiredRegistrationFlows(context.Context, time.Time, int) error
 }
 
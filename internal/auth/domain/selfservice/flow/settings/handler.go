@@ -589,6 +589,19 @@ func (h *Handler) updateSettingsFlow(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
+	// Sessions which must change their password first may only use the password method.
+	if err := h.d.SessionManager().DoesSessionRequirePasswordChange(r, ss); err != nil {
+		if pwErr := new(session.ErrPasswordChangeRequired); !errors.As(err, &pwErr) {
+			h.d.SettingsFlowErrorHandler().WriteFlowError(w, r, node.DefaultGroup, f, ss.Identity, err)
+			return
+		}
+
+		if err := flow.MethodEnabledAndAllowedFromRequest(r, f.GetFlowName(), identity.CredentialsTypePassword.String(), h.d); err != nil {
+			h.d.SettingsFlowErrorHandler().WriteFlowError(w, r, node.PasswordGroup, f, ss.Identity, schema.NewPasswordChangeRequiredError())
+			return
+		}
+	}
+
 	var s string
 	var updateContext *UpdateContext
 	for _, strat := range h.d.AllSettingsStrategies() {
@@ -57,6 +57,8 @@ type (
 	executorDependencies interface {
 		identity.ManagementProvider
 		identity.ValidationProvider
+		identity.PrivilegedPoolProvider
+		identity.DeletionSchedulerProvider
 		session.ManagementProvider
 		config.Provider
 
@@ -318,6 +320,113 @@ func (e *HookExecutor) PostSettingsHook(w http.ResponseWriter, r *http.Request,
 	return nil
 }
 
+// PostSettingsDeletionHook runs the settings hooks of an account deletion and deletes the identity. If a deletion
+// grace period is configured, the deletion is scheduled instead. Webhooks which can interrupt the flow run before
+// the identity is deleted and may veto the deletion.
+func (e *HookExecutor) PostSettingsDeletionHook(w http.ResponseWriter, r *http.Request, settingsType string, ctxUpdate *UpdateContext, i *identity.Identity) error {
+	e.d.Logger().
+		WithRequest(r).
+		WithField("identity_id", i.ID).
+		WithField("flow_method", settingsType).
+		Debug("Running PostSettingsPrePersistHooks for account deletion.")
+
+	// Verify the redirect URL before we do any other processing.
+	c := e.d.Config()
+	returnTo, err := x.SecureRedirectTo(r, c.SelfServiceBrowserDefaultReturnTo(r.Context()),
+		x.SecureRedirectUseSourceURL(ctxUpdate.Flow.RequestURL),
+		x.SecureRedirectAllowURLs(c.SelfServiceBrowserAllowedReturnToDomains(r.Context())),
+		x.SecureRedirectAllowSelfServiceURLs(c.SelfPublicURL(r.Context())),
+		x.SecureRedirectOverrideDefaultReturnTo(
+			c.SelfServiceFlowSettingsReturnTo(r.Context(), settingsType, c.SelfServiceBrowserDefaultReturnTo(r.Context()))),
+	)
+	if err != nil {
+		return err
+	}
+
+	for k, executor := range e.d.PostSettingsPrePersistHooks(r.Context(), settingsType) {
+		logFields := logrus.Fields{
+			"executor":          fmt.Sprintf("%T", executor),
+			"executor_position": k,
+			"executors":         PostHookPrePersistExecutorNames(e.d.PostSettingsPrePersistHooks(r.Context(), settingsType)),
+			"identity_id":       i.ID,
+			"flow_method":       settingsType,
+		}
+
+		if err := executor.ExecuteSettingsPrePersistHook(w, r, ctxUpdate.Flow, i); err != nil {
+			if errors.Is(err, ErrHookAbortFlow) {
+				e.d.Logger().WithRequest(r).WithFields(logFields).
+					Debug("A ExecuteSettingsPrePersistHook hook aborted early.")
+				return nil
+			}
+			return flow.HandleHookError(w, r, ctxUpdate.Flow, i.Traits, node.AccountDeletionGroup, err, e.d, e.d)
+		}
+
+		e.d.Logger().WithRequest(r).WithFields(logFields).Debug("ExecuteSettingsPrePersistHook completed successfully.")
+	}
+
+	message := text.NewInfoSelfServiceSettingsAccountDeleted()
+	if c.IdentityDeletionGracePeriod(r.Context()) > 0 {
+		d, err := e.d.IdentityDeletionScheduler().ScheduleIdentityDeletion(r.Context(), i.ID, identity.DeletionInitiatorSelfService)
+		if err != nil {
+			return err
+		}
+		message = text.NewInfoSelfServiceSettingsAccountDeletionScheduled(d.DeleteAfter)
+	} else if err := e.d.PrivilegedIdentityPool().DeleteIdentity(r.Context(), i.ID); err != nil {
+		return err
+	}
+
+	// The session cookie is only removed once the deletion succeeded, so that the user remains signed in if it
+	// failed. Deleting the identity has already removed the session itself.
+	if err := e.d.SessionManager().PurgeFromRequest(r.Context(), w, r); err != nil {
+		return err
+	}
+	e.d.Audit().
+		WithRequest(r).
+		WithField("identity_id", i.ID).
+		Info("An identity deleted their account.")
+
+	ctxUpdate.Flow.State = flow.StateSuccess
+	ctxUpdate.Flow.UI.Nodes = node.Nodes{}
+	ctxUpdate.Flow.UI.ResetMessages()
+	ctxUpdate.Flow.UI.AddMessage(node.DefaultGroup, message)
+
+	for k, executor := range e.d.PostSettingsPostPersistHooks(r.Context(), settingsType) {
+		if err := executor.ExecuteSettingsPostPersistHook(w, r, ctxUpdate.Flow, i, ctxUpdate.Session); err != nil {
+			if errors.Is(err, ErrHookAbortFlow) {
+				e.d.Logger().
+					WithRequest(r).
+					WithField("executor", fmt.Sprintf("%T", executor)).
+					WithField("executor_position", k).
+					WithField("executors", PostHookPostPersistExecutorNames(e.d.PostSettingsPostPersistHooks(r.Context(), settingsType))).
+					WithField("identity_id", i.ID).
+					WithField("flow_method", settingsType).
+					Debug("A ExecuteSettingsPostPersistHook hook aborted early.")
+				return nil
+			}
+			return err
+		}
+
+		e.d.Logger().WithRequest(r).
+			WithField("executor", fmt.Sprintf("%T", executor)).
+			WithField("executor_position", k).
+			WithField("executors", PostHookPostPersistExecutorNames(e.d.PostSettingsPostPersistHooks(r.Context(), settingsType))).
+			WithField("identity_id", i.ID).
+			WithField("flow_method", settingsType).
+			Debug("ExecuteSettingsPostPersistHook completed successfully.")
+	}
+
+	trace.SpanFromContext(r.Context()).AddEvent(events.NewSettingsSucceeded(r.Context(), i.ID, string(ctxUpdate.Flow.Type), settingsType))
+
+	// The flow is not persisted because its identity no longer has access to it.
+	if ctxUpdate.Flow.Type == flow.TypeAPI || x.IsJSONRequest(r) {
+		e.d.Writer().Write(w, r, ctxUpdate.Flow)
+		return nil
+	}
+
+	http.Redirect(w, r, returnTo.String(), http.StatusSeeOther)
+	return nil
+}
+
 func (e *HookExecutor) PreSettingsHook(w http.ResponseWriter, r *http.Request, a *Flow) error {
 	for _, executor := range e.d.PreSettingsHooks(r.Context()) {
 		if err := executor.ExecuteSettingsPreHook(w, r, a); err != nil {
@@ -29,6 +29,7 @@ type (
 	sessionIssuerDependencies interface {
 		session.ManagementProvider
 		session.PersistenceProvider
+		session.RefreshTokenManagerProvider
 		sessiontokenexchange.PersistenceProvider
 		config.Provider
 		x.WriterProvider
@@ -63,12 +64,19 @@ func (e *SessionIssuer) executePostRegistrationPostPersistHook(w http.ResponseWr
 			}
 		}
 
+		refreshToken, err := e.r.SessionRefreshTokenManager().Issue(r.Context(), s)
+		if err != nil {
+			return err
+		}
+
 		a.AddContinueWith(flow.NewContinueWithSetToken(s.Token))
 		e.r.Writer().Write(w, r, &registration.APIFlowResponse{
-			Session:      s,
-			Token:        s.Token,
-			Identity:     s.Identity,
-			ContinueWith: a.ContinueWithItems,
+			Session:        s,
+			Token:          s.Token,
+			RefreshToken:   refreshToken,
+			TokenExpiresAt: s.TokenExpiresAt,
+			Identity:       s.Identity,
+			ContinueWith:   a.ContinueWithItems,
 		})
 		return errors.WithStack(registration.ErrHookAbortFlow)
 	}
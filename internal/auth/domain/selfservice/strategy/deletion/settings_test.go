@@ -0,0 +1,216 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package deletion_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/x/sqlcon"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/settings"
+	"my.com/secrets/internal/auth/domain/text"
+	"my.com/secrets/internal/auth/domain/ui/node"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func newIdentity() *identity.Identity {
+	email := x.NewUUID().String() + "@ory.sh"
+	return &identity.Identity{
+		ID: x.NewUUID(),
+		Credentials: map[identity.CredentialsType]identity.Credentials{
+			identity.CredentialsTypePassword: {
+				Type:        identity.CredentialsTypePassword,
+				Identifiers: []string{email},
+				Config:      []byte(`{"hashed_password":"foo"}`),
+			},
+		},
+		State:    identity.StateActive,
+		Traits:   identity.Traits(`{"email":"` + email + `"}`),
+		SchemaID: config.DefaultIdentityTraitsSchemaID,
+	}
+}
+
+func TestSettings(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	testhelpers.StrategyEnable(t, conf, settings.StrategyAccountDeletion, true)
+	conf.MustSet(ctx, config.ViperKeySelfServiceSettingsPrivilegedAuthenticationAfter, "5m")
+
+	returnTS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("goodbye"))
+	}))
+	t.Cleanup(returnTS.Close)
+	conf.MustSet(ctx, config.ViperKeySelfServiceBrowserDefaultReturnTo, returnTS.URL)
+
+	_ = testhelpers.NewSettingsUIFlowEchoServer(t, reg)
+	_ = testhelpers.NewErrorTestServer(t, reg)
+	_ = testhelpers.NewLoginUIWith401Response(t, conf)
+
+	publicTS, _ := testhelpers.NewKratosServer(t, reg)
+
+	confirm := func(v url.Values) {
+		v.Set(node.AccountDeletionConfirm, "true")
+		v.Set("method", settings.StrategyAccountDeletion)
+	}
+
+	submit := func(t *testing.T, flowType string, id *identity.Identity, values func(url.Values)) (string, *http.Response) {
+		switch flowType {
+		case "api":
+			c := testhelpers.NewHTTPClientWithIdentitySessionToken(t, reg, id)
+			f := testhelpers.InitializeSettingsFlowViaAPI(t, c, publicTS)
+			v := testhelpers.SDKFormFieldsToURLValues(f.Ui.Nodes)
+			values(v)
+			return testhelpers.SettingsMakeRequest(t, true, false, f, c, testhelpers.EncodeFormAsJSON(t, true, v))
+		default:
+			isSPA := flowType == "spa"
+			c := testhelpers.NewHTTPClientWithIdentitySessionCookie(t, reg, id)
+			f := testhelpers.InitializeSettingsFlowViaBrowser(t, c, isSPA, publicTS)
+			v := testhelpers.SDKFormFieldsToURLValues(f.Ui.Nodes)
+			values(v)
+			return testhelpers.SettingsMakeRequest(t, false, isSPA, f, c, testhelpers.EncodeFormAsJSON(t, isSPA, v))
+		}
+	}
+
+	assertDeleted := func(t *testing.T, id *identity.Identity) {
+		_, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, id.ID, identity.ExpandNothing)
+		require.ErrorIs(t, err, sqlcon.ErrNoRows)
+	}
+
+	assertNotDeleted := func(t *testing.T, id *identity.Identity) {
+		actual, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, id.ID, identity.ExpandNothing)
+		require.NoError(t, err)
+		assert.Equal(t, identity.StateActive, actual.State)
+	}
+
+	t.Run("description=should show the confirmation node", func(t *testing.T) {
+		c := testhelpers.NewHTTPClientWithIdentitySessionToken(t, reg, newIdentity())
+		f := testhelpers.InitializeSettingsFlowViaAPI(t, c, publicTS)
+
+		actual, err := json.Marshal(f.Ui.Nodes)
+		require.NoError(t, err)
+		assert.Equal(t, "checkbox", gjson.GetBytes(actual, "#(attributes.name==account_deletion_confirm).attributes.type").String(), "%s", actual)
+		assert.Equal(t, "account_deletion", gjson.GetBytes(actual, "#(attributes.name==account_deletion_confirm).group").String(), "%s", actual)
+		assert.EqualValues(t, text.InfoSelfServiceSettingsDeleteAccount, gjson.GetBytes(actual, "#(attributes.value==account_deletion).meta.label.id").Int(), "%s", actual)
+	})
+
+	t.Run("description=should require a confirmation", func(t *testing.T) {
+		for _, flowType := range []string{"api", "spa", "browser"} {
+			t.Run("type="+flowType, func(t *testing.T) {
+				id := newIdentity()
+				body, res := submit(t, flowType, id, func(v url.Values) {
+					v.Set("method", settings.StrategyAccountDeletion)
+				})
+
+				if flowType == "browser" {
+					assert.Contains(t, res.Request.URL.String(), conf.SelfServiceFlowSettingsUI(ctx).String())
+				} else {
+					assert.Equal(t, http.StatusBadRequest, res.StatusCode, "%s", body)
+				}
+				assert.NotEmpty(t, gjson.Get(body, "ui.nodes.#(attributes.name==account_deletion_confirm).messages.0.text").String(), "%s", body)
+				assertNotDeleted(t, id)
+			})
+		}
+	})
+
+	t.Run("description=should require a privileged session", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySelfServiceSettingsPrivilegedAuthenticationAfter, "1ns")
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeySelfServiceSettingsPrivilegedAuthenticationAfter, "5m")
+		})
+
+		for _, flowType := range []string{"api", "spa"} {
+			t.Run("type="+flowType, func(t *testing.T) {
+				id := newIdentity()
+				body, res := submit(t, flowType, id, confirm)
+				assert.Equal(t, http.StatusForbidden, res.StatusCode, "%s", body)
+				assert.Equal(t, settings.NewFlowNeedsReAuth().ID(), gjson.Get(body, "error.id").String(), "%s", body)
+				assertNotDeleted(t, id)
+			})
+		}
+	})
+
+	t.Run("description=should delete the account", func(t *testing.T) {
+		for _, flowType := range []string{"api", "spa"} {
+			t.Run("type="+flowType, func(t *testing.T) {
+				id := newIdentity()
+				body, res := submit(t, flowType, id, confirm)
+				assert.Equal(t, http.StatusOK, res.StatusCode, "%s", body)
+				assert.Equal(t, "success", gjson.Get(body, "state").String(), "%s", body)
+				assert.EqualValues(t, text.InfoSelfServiceSettingsAccountDeleted, gjson.Get(body, "ui.messages.0.id").Int(), "%s", body)
+				assertDeleted(t, id)
+			})
+		}
+
+		t.Run("type=browser", func(t *testing.T) {
+			id := newIdentity()
+			body, res := submit(t, "browser", id, confirm)
+			assert.Equal(t, http.StatusOK, res.StatusCode, "%s", body)
+			assert.Equal(t, returnTS.URL, res.Request.URL.String())
+			assert.Equal(t, "goodbye", body)
+			assertDeleted(t, id)
+		})
+	})
+
+	t.Run("description=should schedule the deletion if a grace period is configured", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "24h")
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeyIdentityDeletionGracePeriod, "0s")
+		})
+
+		id := newIdentity()
+		body, res := submit(t, "api", id, confirm)
+		assert.Equal(t, http.StatusOK, res.StatusCode, "%s", body)
+		assert.EqualValues(t, text.InfoSelfServiceSettingsAccountDeletionScheduled, gjson.Get(body, "ui.messages.0.id").Int(), "%s", body)
+
+		actual, err := reg.PrivilegedIdentityPool().GetIdentity(ctx, id.ID, identity.ExpandNothing)
+		require.NoError(t, err)
+		assert.Equal(t, identity.StateInactive, actual.State)
+
+		d, err := reg.PrivacyPersister().GetScheduledIdentityDeletion(ctx, id.ID)
+		require.NoError(t, err)
+		assert.Equal(t, identity.DeletionInitiatorSelfService, d.Initiator)
+	})
+
+	t.Run("description=should let webhooks veto the deletion", func(t *testing.T) {
+		var called bool
+		hookTS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"messages":[{"instance_ptr":"#/traits/email","messages":[{"id":1234,"text":"You still have an active subscription.","type":"error"}]}]}`))
+		}))
+		t.Cleanup(hookTS.Close)
+
+		conf.MustSet(ctx, config.ViperKeySelfServiceSettingsAfter+"."+settings.StrategyAccountDeletion+".hooks", []map[string]interface{}{
+			{"hook": "web_hook", "config": map[string]interface{}{
+				"url":           hookTS.URL,
+				"method":        "POST",
+				"body":          "base64://ZnVuY3Rpb24oY3R4KSB7fQ==",
+				"can_interrupt": true,
+			}},
+		})
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeySelfServiceSettingsAfter+"."+settings.StrategyAccountDeletion, nil)
+		})
+
+		id := newIdentity()
+		body, res := submit(t, "api", id, confirm)
+		assert.True(t, called)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, "%s", body)
+		assert.Contains(t, body, "You still have an active subscription.", "%s", body)
+		assertNotDeleted(t, id)
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package deletion
+
+import (
+	"my.com/secrets/internal/auth/domain/continuity"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/selfservice/errorx"
+	"my.com/secrets/internal/auth/domain/selfservice/flow/settings"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/ui/node"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+var _ settings.Strategy = new(Strategy)
+
+type (
+	strategyDependencies interface {
+		x.CSRFProvider
+		x.CSRFTokenGeneratorProvider
+		x.WriterProvider
+		x.LoggingProvider
+
+		config.Provider
+
+		continuity.ManagementProvider
+
+		session.HandlerProvider
+		session.ManagementProvider
+
+		errorx.ManagementProvider
+
+		settings.HookExecutorProvider
+		settings.ErrorHandlerProvider
+		settings.FlowPersistenceProvider
+		settings.HooksProvider
+	}
+
+	// Strategy lets users delete their own account in the settings flow.
+	Strategy struct {
+		d strategyDependencies
+	}
+)
+
+func NewStrategy(d any) *Strategy {
+	return &Strategy{d: d.(strategyDependencies)}
+}
+
+func (s *Strategy) NodeGroup() node.UiNodeGroup {
+	return node.AccountDeletionGroup
+}
@@ -0,0 +1,251 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+// Package breachindex implements a compact on-disk index of breached password hashes.
+//
+// The index stores the first eight bytes of every SHA-1 password hash together with its breach count, sorted by
+// hash. A fan-out table keyed by the first two hash bytes points to the records sharing that prefix, so a lookup
+// reads the table entry and binary searches a small range of the file without loading the index into memory.
+//
+// Layout (all integers are big endian):
+//
+//	magic   [8]byte                  "KRBIDX\x00\x01"
+//	fanout  [65537]uint64            index of the first record for every two byte prefix, followed by the record count
+//	records [n]struct{hash [8]byte; count uint32}
+package breachindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	magic        = "KRBIDX\x00\x01"
+	hashLength   = 8
+	recordLength = hashLength + 4
+	fanoutSize   = 1 << 16
+	headerLength = len(magic) + (fanoutSize+1)*8
+)
+
+var (
+	ErrInvalidIndex = errors.New("the file is not a valid breach index")
+	ErrUnsorted     = errors.New("the password hashes must be added in ascending order")
+)
+
+// Index is a breach index opened for lookups. It is safe for concurrent use.
+type Index struct {
+	f     *os.File
+	count uint64
+}
+
+// Open opens the breach index at the given path.
+func Open(path string) (*Index, error) {
+	f, err := os.Open(path) //#nosec G304 -- the path is set by the operator
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	header := make([]byte, len(magic))
+	if _, err := f.ReadAt(header, 0); err != nil || string(header) != magic {
+		_ = f.Close()
+		return nil, errors.WithStack(ErrInvalidIndex)
+	}
+
+	idx := &Index{f: f}
+	if idx.count, err = idx.fanout(fanoutSize); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if stat, err := f.Stat(); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	} else if stat.Size() != int64(headerLength)+int64(idx.count)*recordLength {
+		_ = f.Close()
+		return nil, errors.WithStack(ErrInvalidIndex)
+	}
+
+	return idx, nil
+}
+
+// Close closes the underlying file.
+func (i *Index) Close() error {
+	return errors.WithStack(i.f.Close())
+}
+
+// Len returns the number of hashes in the index.
+func (i *Index) Len() uint64 {
+	return i.count
+}
+
+// Count returns how often the password with the given SHA-1 hash has been breached, or zero if it is not in the index.
+func (i *Index) Count(sha1 []byte) (int64, error) {
+	if len(sha1) < hashLength {
+		return 0, errors.Errorf("expected a SHA-1 hash but got %d bytes", len(sha1))
+	}
+	key := sha1[:hashLength]
+
+	prefix := int(binary.BigEndian.Uint16(key))
+	from, err := i.fanout(prefix)
+	if err != nil {
+		return 0, err
+	}
+	to, err := i.fanout(prefix + 1)
+	if err != nil {
+		return 0, err
+	}
+	if to < from || to > i.count {
+		return 0, errors.WithStack(ErrInvalidIndex)
+	}
+
+	record := make([]byte, recordLength)
+	var readErr error
+	n := sort.Search(int(to-from), func(k int) bool {
+		if readErr != nil {
+			return true
+		}
+		if readErr = i.record(from+uint64(k), record); readErr != nil {
+			return true
+		}
+		return bytes.Compare(record[:hashLength], key) >= 0
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	if uint64(n) == to-from {
+		return 0, nil
+	}
+
+	if err := i.record(from+uint64(n), record); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(record[:hashLength], key) {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint32(record[hashLength:])), nil
+}
+
+func (i *Index) fanout(prefix int) (uint64, error) {
+	var buf [8]byte
+	if _, err := i.f.ReadAt(buf[:], int64(len(magic)+prefix*8)); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (i *Index) record(k uint64, into []byte) error {
+	if _, err := i.f.ReadAt(into, int64(headerLength)+int64(k)*recordLength); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Writer builds a breach index. Hashes must be added in ascending order, which is the order of the
+// Have I Been Pwned downloads.
+type Writer struct {
+	w      io.WriteSeeker
+	fanout []uint64
+	next   int
+	count  uint64
+	last   [recordLength]byte
+	dirty  bool
+}
+
+// NewWriter starts writing a breach index to w.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	if _, err := w.Write(make([]byte, headerLength)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Writer{w: w, fanout: make([]uint64, fanoutSize+1)}, nil
+}
+
+// Add adds a SHA-1 hash and its breach count to the index. Hashes which are equal in their first eight bytes are
+// stored once with the highest count.
+func (w *Writer) Add(sha1 []byte, count int64) error {
+	if len(sha1) < hashLength {
+		return errors.Errorf("expected a SHA-1 hash but got %d bytes", len(sha1))
+	}
+	key := sha1[:hashLength]
+
+	if w.dirty {
+		switch c := bytes.Compare(key, w.last[:hashLength]); {
+		case c < 0:
+			return errors.WithStack(ErrUnsorted)
+		case c == 0:
+			if clamp(count) > binary.BigEndian.Uint32(w.last[hashLength:]) {
+				binary.BigEndian.PutUint32(w.last[hashLength:], clamp(count))
+			}
+			return nil
+		}
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	copy(w.last[:hashLength], key)
+	binary.BigEndian.PutUint32(w.last[hashLength:], clamp(count))
+	w.dirty = true
+	return nil
+}
+
+// Close writes the fan-out table. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.dirty {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.dirty = false
+	}
+
+	// Prefixes without any hashes start where the next prefix starts.
+	for ; w.next <= fanoutSize; w.next++ {
+		w.fanout[w.next] = w.count
+	}
+
+	header := make([]byte, headerLength)
+	copy(header, magic)
+	for k, v := range w.fanout {
+		binary.BigEndian.PutUint64(header[len(magic)+k*8:], v)
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := w.w.Write(header); err != nil {
+		return errors.WithStack(err)
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return errors.WithStack(err)
+}
+
+// Len returns the number of hashes written so far.
+func (w *Writer) Len() uint64 {
+	return w.count
+}
+
+func (w *Writer) flush() error {
+	for prefix := int(binary.BigEndian.Uint16(w.last[:])); w.next <= prefix; w.next++ {
+		w.fanout[w.next] = w.count
+	}
+
+	if _, err := w.w.Write(w.last[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	w.count++
+	return nil
+}
+
+func clamp(count int64) uint32 {
+	if count < 0 {
+		return 0
+	} else if count > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(count)
+}
@@ -0,0 +1,121 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package breachindex_test
+
+import (
+	"crypto/sha1" //#nosec G505 -- sha1 is used for k-anonymity
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"my.com/secrets/internal/auth/domain/selfservice/strategy/password/breachindex"
+)
+
+func build(t *testing.T, source string) *breachindex.Index {
+	path := filepath.Join(t.TempDir(), "breaches.idx")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w, err := breachindex.NewWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, breachindex.ReadSource(source, w.Add))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	idx, err := breachindex.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	return idx
+}
+
+func TestIndex(t *testing.T) {
+	hashes := map[string]int64{}
+	for k := 0; k < 2000; k++ {
+		//#nosec G401 -- sha1 is used for k-anonymity
+		h := sha1.Sum([]byte(fmt.Sprintf("password-%d", k)))
+		hashes[fmt.Sprintf("%X", h[:])] = int64(k + 1)
+	}
+	sorted := make([]string, 0, len(hashes))
+	for h := range hashes {
+		sorted = append(sorted, h)
+	}
+	sort.Strings(sorted)
+
+	assertIndex := func(t *testing.T, idx *breachindex.Index) {
+		assert.EqualValues(t, len(hashes), idx.Len())
+		for k := 0; k < 2000; k++ {
+			//#nosec G401 -- sha1 is used for k-anonymity
+			h := sha1.Sum([]byte(fmt.Sprintf("password-%d", k)))
+			count, err := idx.Count(h[:])
+			require.NoError(t, err)
+			assert.EqualValues(t, k+1, count)
+		}
+
+		//#nosec G401 -- sha1 is used for k-anonymity
+		h := sha1.Sum([]byte("not-breached"))
+		count, err := idx.Count(h[:])
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	}
+
+	t.Run("source=single file", func(t *testing.T) {
+		var lines []string
+		for _, h := range sorted {
+			lines = append(lines, fmt.Sprintf("%s:%d", h, hashes[h]))
+		}
+		source := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+		require.NoError(t, os.WriteFile(source, []byte(strings.Join(lines, "\r\n")), 0600))
+
+		assertIndex(t, build(t, source))
+	})
+
+	t.Run("source=range files", func(t *testing.T) {
+		dir := t.TempDir()
+		ranges := map[string][]string{}
+		for _, h := range sorted {
+			ranges[h[:5]] = append(ranges[h[:5]], fmt.Sprintf("%s:%d", h[5:], hashes[h]))
+		}
+		for prefix, lines := range ranges {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0600))
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("ignored"), 0600))
+
+		assertIndex(t, build(t, dir))
+	})
+
+	t.Run("case=empty index", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "empty.txt")
+		require.NoError(t, os.WriteFile(source, nil, 0600))
+
+		idx := build(t, source)
+		assert.Zero(t, idx.Len())
+		count, err := idx.Count(make([]byte, 20))
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("case=rejects unsorted hashes", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "breaches.idx"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		w, err := breachindex.NewWriter(f)
+		require.NoError(t, err)
+		require.NoError(t, w.Add([]byte("BBBBBBBBBBBBBBBBBBBB"), 1))
+		require.ErrorIs(t, w.Add([]byte("AAAAAAAAAAAAAAAAAAAA"), 1), breachindex.ErrUnsorted)
+	})
+
+	t.Run("case=rejects files which are not an index", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "breaches.idx")
+		require.NoError(t, os.WriteFile(path, []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1"), 0600))
+
+		_, err := breachindex.Open(path)
+		require.ErrorIs(t, err, breachindex.ErrInvalidIndex)
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package breachindex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var rangeFile = regexp.MustCompile(`^[0-9A-Fa-f]{5}\.txt$`)
+
+// ReadSource reads Have I Been Pwned "pwned passwords" SHA-1 data in ascending hash order and calls fn for every
+// hash. The path is either a directory of range files named after their five character hash prefix (for example
+// `21BD1.txt`) containing `SUFFIX:COUNT` lines, or a single file containing `HASH:COUNT` lines.
+func ReadSource(path string, fn func(sha1 []byte, count int64) error) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !stat.IsDir() {
+		return readFile(path, "", fn)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && rangeFile.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToUpper(names[i]) < strings.ToUpper(names[j])
+	})
+
+	for _, name := range names {
+		if err := readFile(filepath.Join(path, name), strings.TrimSuffix(name, ".txt"), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path, prefix string, fn func(sha1 []byte, count int64) error) error {
+	f, err := os.Open(path) //#nosec G304 -- the path is set by the operator
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	return errors.WithMessage(ScanRange(f, prefix, fn), path)
+}
+
+// ScanRange parses the response of the Have I Been Pwned range API, or a range file of the same format, and calls fn
+// for every hash. The prefix is prepended to every line and may be empty if the lines contain the full hash. Lines
+// without a count are counted as one breach, lines without a valid hash are skipped.
+func ScanRange(r io.Reader, prefix string, fn func(sha1 []byte, count int64) error) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		row := strings.TrimSpace(sc.Text())
+		if row == "" {
+			continue
+		}
+		result := strings.Split(row, ":")
+
+		sha1, err := hex.DecodeString(prefix + result[0])
+		if err != nil || len(sha1) != 20 {
+			// Lines which do not contain a hash are ignored.
+			continue
+		}
+
+		count := int64(1)
+		if len(result) == 2 {
+			count, err = strconv.ParseInt(strings.ReplaceAll(result[1], ",", ""), 10, 64)
+			if err != nil {
+				return errors.Errorf("expected password hash to contain a count formatted as int but got: %s", result[1])
+			}
+		}
+
+		if err := fn(sha1, count); err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(sc.Err())
+}
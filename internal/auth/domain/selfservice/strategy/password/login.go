@@ -22,7 +22,6 @@ import (
 	"github.com/ory/herodot"
 	"github.com/ory/x/decoderx"
 
-	"my.com/secrets/internal/auth/domain/hash"
 	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/schema"
 	"my.com/secrets/internal/auth/domain/selfservice/flow"
@@ -80,14 +79,15 @@ func (s *Strategy) Login(w http.ResponseWriter, r *http.Request, f *login.Flow,
 		return nil, herodot.ErrInternalServerError.WithReason("The password credentials could not be decoded properly").WithDebug(err.Error()).WithWrap(err)
 	}
 
-	if err := hash.Compare(r.Context(), []byte(p.Password), []byte(o.HashedPassword)); err != nil {
+	if err := s.d.Hasher(r.Context()).Compare(r.Context(), []byte(p.Password), []byte(o.HashedPassword)); err != nil {
 		return nil, s.handleLoginError(w, r, f, &p, errors.WithStack(schema.NewInvalidCredentialsError()))
 	}
 
-	if !s.d.Hasher(r.Context()).Understands([]byte(o.HashedPassword)) {
+	if s.d.Hasher(r.Context()).NeedsRehash(r.Context(), []byte(o.HashedPassword)) {
 		if err := s.migratePasswordHash(r.Context(), i.ID, []byte(p.Password)); err != nil {
 			return nil, s.handleLoginError(w, r, f, &p, err)
 		}
+		s.d.IdentityManager().PasswordHashMigrated()
 	}
 
 	f.Active = identity.CredentialsTypePassword
@@ -104,10 +104,6 @@ func (s *Strategy) migratePasswordHash(ctx context.Context, identifier uuid.UUID
 	if err != nil {
 		return err
 	}
-	co, err := json.Marshal(&identity.CredentialsPassword{HashedPassword: string(hpw)})
-	if err != nil {
-		return errors.Wrap(err, "unable to encode password configuration to JSON")
-	}
 
 	i, err := s.d.PrivilegedIdentityPool().GetIdentityConfidential(ctx, identifier)
 	if err != nil {
@@ -119,6 +115,18 @@ func (s *Strategy) migratePasswordHash(ctx context.Context, identifier uuid.UUID
 		return errors.New("expected to find password credential but could not")
 	}
 
+	// Only the hash of the current password changes, the password history and age are kept as is.
+	var cp identity.CredentialsPassword
+	if err := json.Unmarshal(c.Config, &cp); err != nil {
+		return errors.Wrap(err, "unable to decode password configuration from JSON")
+	}
+	cp.HashedPassword = string(hpw)
+
+	co, err := json.Marshal(&cp)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode password configuration to JSON")
+	}
+
 	c.Config = co
 	i.SetCredentials(s.ID(), *c)
 
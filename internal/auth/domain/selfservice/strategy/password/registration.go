@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"my.com/secrets/internal/auth/domain/text"
 
@@ -134,7 +135,8 @@ func (s *Strategy) Register(w http.ResponseWriter, r *http.Request, f *registrat
 	case err := <-errC:
 		return s.handleRegistrationError(w, r, f, &p, err)
 	case h := <-hpw:
-		co, err := json.Marshal(&identity.CredentialsPassword{HashedPassword: string(h)})
+		now := time.Now().UTC()
+		co, err := json.Marshal(&identity.CredentialsPassword{HashedPassword: string(h), PasswordChangedAt: &now})
 		if err != nil {
 			return s.handleRegistrationError(w, r, f, &p, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to encode password options to JSON: %s", err)))
 		}
@@ -157,16 +159,14 @@ func (s *Strategy) validateCredentials(ctx context.Context, i *identity.Identity
 		return schema.NewMissingIdentifierError()
 	}
 
-	for _, id := range c.Identifiers {
-		if err := s.d.PasswordValidator().Validate(ctx, id, pw); err != nil {
-			if _, ok := errorsx.Cause(err).(*herodot.DefaultError); ok {
-				return err
-			}
-			if message := new(text.Message); errors.As(err, &message) {
-				return schema.NewPasswordPolicyViolationError("#/password", message)
-			}
-			return schema.NewPasswordPolicyViolationError("#/password", text.NewErrorValidationPasswordPolicyViolationGeneric(err.Error()))
+	if err := s.d.PasswordValidator().ValidateIdentity(ctx, i, pw); err != nil {
+		if _, ok := errorsx.Cause(err).(*herodot.DefaultError); ok {
+			return err
+		}
+		if message := new(text.Message); errors.As(err, &message) {
+			return schema.NewPasswordPolicyViolationError("#/password", message)
 		}
+		return schema.NewPasswordPolicyViolationError("#/password", text.NewErrorValidationPasswordPolicyViolationGeneric(err.Error()))
 	}
 
 	return nil
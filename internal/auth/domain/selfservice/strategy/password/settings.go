@@ -142,16 +142,29 @@ func (s *Strategy) continueSettingsFlow(
 		return err
 	}
 
+	var cp identity.CredentialsPassword
+	if c, ok := i.GetCredentials(s.ID()); ok && len(c.Config) > 0 {
+		if err := json.Unmarshal(c.Config, &cp); err != nil {
+			return errors.WithStack(herodot.ErrInternalServerError.WithReason("The password credentials could not be decoded properly").WithDebug(err.Error()).WithWrap(err))
+		}
+	}
+
 	i.UpsertCredentialsConfig(s.ID(), []byte("{}"), 0)
 	if err := s.validateCredentials(r.Context(), i, p.Password); err != nil {
 		return err
 	}
 
+	historySize := int(s.d.Config().PasswordPolicyConfig(r.Context()).HistorySize)
+	if cp.IsReused(r.Context(), s.d.Hasher(r.Context()), []byte(p.Password), historySize) {
+		return schema.NewPasswordPolicyViolationError("#/password", text.NewErrorValidationPasswordReused())
+	}
+
 	select {
 	case err := <-errC:
 		return err
 	case h := <-hpw:
-		co, err := json.Marshal(&identity.CredentialsPassword{HashedPassword: string(h)})
+		cp.Rotate(string(h), historySize)
+		co, err := json.Marshal(&cp)
 		if err != nil {
 			return errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to encode password options to JSON: %s", err))
 		}
@@ -254,6 +254,37 @@ func TestSettings(t *testing.T) {
 		})
 	})
 
+	t.Run("description=should fail if password was used before", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordHistorySize, 1)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordHistorySize, 0) })
+
+		first, second := x.NewUUID().String(), x.NewUUID().String()
+		var payload = func(password string) func(v url.Values) {
+			return func(v url.Values) {
+				v.Set("method", "password")
+				v.Set("password", password)
+			}
+		}
+
+		actual := testhelpers.SubmitSettingsForm(t, true, false, apiUser1, publicTS, payload(first), http.StatusOK, publicTS.URL+settings.RouteSubmitFlow)
+		assert.Equal(t, "success", gjson.Get(actual, "state").String(), "%s", actual)
+
+		actual = expectValidationError(t, true, false, apiUser1, payload(first))
+		assert.Equal(t, text.NewErrorValidationPasswordReused().Text, gjson.Get(actual, "ui.nodes.#(attributes.name==password).messages.0.text").String(), "%s", actual)
+
+		actual = testhelpers.SubmitSettingsForm(t, true, false, apiUser1, publicTS, payload(second), http.StatusOK, publicTS.URL+settings.RouteSubmitFlow)
+		assert.Equal(t, "success", gjson.Get(actual, "state").String(), "%s", actual)
+
+		actual = expectValidationError(t, true, false, apiUser1, payload(first))
+		assert.Equal(t, text.NewErrorValidationPasswordReused().Text, gjson.Get(actual, "ui.nodes.#(attributes.name==password).messages.0.text").String(), "%s", actual)
+
+		i, err := reg.PrivilegedIdentityPool().GetIdentityConfidential(ctx, apiIdentity1.ID)
+		require.NoError(t, err)
+		var cp identity.CredentialsPassword
+		require.NoError(t, json.Unmarshal(i.Credentials[identity.CredentialsTypePassword].Config, &cp))
+		assert.Len(t, cp.PreviousHashedPasswords, 1)
+	})
+
 	t.Run("case=should fail because of missing CSRF token/type=browser", func(t *testing.T) {
 		f := testhelpers.InitializeSettingsFlowViaBrowser(t, browserUser1, false, publicTS)
 		values := testhelpers.SDKFormFieldsToURLValues(f.Ui.Nodes)
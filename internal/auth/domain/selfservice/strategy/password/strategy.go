@@ -64,6 +64,7 @@ type registrationStrategyDependencies interface {
 	settings.ErrorHandlerProvider
 
 	identity.PrivilegedPoolProvider
+	identity.ManagementProvider
 	identity.ValidationProvider
 
 	session.HandlerProvider
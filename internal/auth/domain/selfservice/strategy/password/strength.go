@@ -0,0 +1,300 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package password
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are frequently used passwords and words, ordered by how common they are. The position in the list
+// is used as the number of guesses an attacker needs to find a word.
+var commonPasswords = rankedWords(
+	"password", "123456", "qwerty", "abc123", "letmein", "monkey", "dragon", "111111", "baseball", "iloveyou",
+	"trustno1", "sunshine", "master", "welcome", "shadow", "ashley", "football", "jesus", "michael", "ninja",
+	"mustang", "admin", "login", "princess", "starwars", "solo", "passw0rd", "secret", "hello", "freedom",
+	"whatever", "charlie", "donald", "summer", "winter", "spring", "autumn", "flower", "hunter", "killer",
+	"batman", "superman", "soccer", "hockey", "ranger", "buster", "thomas", "robert", "jordan", "harley",
+	"tigger", "pepper", "cheese", "computer", "internet", "cookie", "orange", "banana", "chocolate", "love",
+	"test", "guest", "root", "user", "default", "changeme", "access", "matrix", "pokemon", "blink",
+	"london", "berlin", "paris", "google", "apple", "samsung", "microsoft", "company", "office", "january",
+	"february", "march", "april", "june", "july", "august", "september", "october", "november", "december",
+	"monday", "friday", "sunday", "family", "forever", "angel", "baby", "lucky", "happy", "money",
+)
+
+// keyboardRows are the rows of a QWERTY keyboard and its number pad, used to detect keyboard walks.
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "789", "456", "123"}
+
+const (
+	// bruteforceCardinality is the number of guesses per character if no pattern matches, the same as in zxcvbn.
+	bruteforceCardinality = 10
+	minYear               = 1900
+	maxYear               = 2099
+	referenceYear         = 2024
+	maxStrengthLength     = 100
+)
+
+type (
+	strengthMatch struct {
+		i, j    int
+		guesses float64
+	}
+	strengthEstimator struct {
+		words   map[string]int
+		longest int
+		guesses map[string]float64
+	}
+)
+
+// estimateStrength estimates how hard the password is to guess and returns a score similar to zxcvbn:
+//
+//	0: too guessable (less than 10^3 guesses)
+//	1: very guessable (less than 10^6 guesses)
+//	2: somewhat guessable (less than 10^8 guesses)
+//	3: safely unguessable (less than 10^10 guesses)
+//	4: very unguessable
+//
+// The password is split into dictionary words, keyboard walks, sequences, repetitions, years and the given user inputs
+// such as the identifier. The split which needs the fewest guesses determines the score.
+func estimateStrength(password string, userInputs []string) int {
+	guesses := estimateGuesses(password, userInputs)
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	}
+	return 4
+}
+
+func estimateGuesses(password string, userInputs []string) float64 {
+	pw := []rune(password)
+	if len(pw) == 0 {
+		return 1
+	} else if len(pw) > maxStrengthLength {
+		// Longer passwords are very unguessable anyways, this only limits the time spent on matching.
+		pw = pw[:maxStrengthLength]
+	}
+
+	e := &strengthEstimator{
+		words:   make(map[string]int, len(commonPasswords)+len(userInputs)),
+		longest: 4, // years
+		guesses: map[string]float64{},
+	}
+	for w, rank := range commonPasswords {
+		e.words[w] = rank
+	}
+	for k, in := range userInputs {
+		if in = strings.ToLower(in); len([]rune(in)) > 2 {
+			e.words[in] = k + 1
+		}
+	}
+	for w := range e.words {
+		e.longest = max(e.longest, len([]rune(w)))
+	}
+
+	return e.minimumGuesses(pw)
+}
+
+// minimumGuesses returns the guesses needed for the split of the password into matches which needs the fewest guesses.
+func (e *strengthEstimator) minimumGuesses(pw []rune) float64 {
+	if g, ok := e.guesses[string(pw)]; ok {
+		return g
+	}
+
+	matches := e.findMatches(pw)
+
+	// best[k] holds the fewest guesses and the number of matches needed for the first k runes.
+	type step struct {
+		guesses float64
+		count   int
+	}
+	best := make([]step, len(pw)+1)
+	best[0] = step{guesses: 1}
+	for k := 1; k <= len(pw); k++ {
+		// Bruteforcing the rune after the best split of the runes before it.
+		best[k] = step{guesses: best[k-1].guesses * bruteforceCardinality, count: max(best[k-1].count, 1)}
+
+		for _, m := range matches {
+			if m.j != k-1 {
+				continue
+			}
+			prev := best[m.i]
+			if g := prev.guesses * m.guesses; g*factorial(prev.count+1) < best[k].guesses*factorial(best[k].count) {
+				best[k] = step{guesses: g, count: prev.count + 1}
+			}
+		}
+	}
+
+	g := best[len(pw)].guesses * factorial(best[len(pw)].count)
+	e.guesses[string(pw)] = g
+	return g
+}
+
+func (e *strengthEstimator) findMatches(pw []rune) (matches []strengthMatch) {
+	lower := make([]rune, len(pw))
+	for k, r := range pw {
+		lower[k] = unicode.ToLower(r)
+	}
+	unleeted := []rune(unleet(string(lower)))
+
+	for i := range pw {
+		for j := i + 1; j < len(pw) && j-i < e.longest; j++ {
+			sub := string(lower[i : j+1])
+
+			if rank, ok := e.words[sub]; ok {
+				matches = append(matches, strengthMatch{i: i, j: j, guesses: float64(rank) * casingVariations(pw[i:j+1])})
+			} else if rank, ok := e.words[string(unleeted[i:j+1])]; ok {
+				matches = append(matches, strengthMatch{i: i, j: j, guesses: float64(rank) * casingVariations(pw[i:j+1]) * 2})
+			}
+
+			if year, err := strconv.Atoi(sub); err == nil && j-i == 3 && year >= minYear && year <= maxYear {
+				matches = append(matches, strengthMatch{i: i, j: j, guesses: math.Max(math.Abs(float64(year-referenceYear)), 20)})
+			}
+		}
+	}
+
+	matches = append(matches, sequenceMatches(lower)...)
+	matches = append(matches, keyboardMatches(lower)...)
+	matches = append(matches, e.repeatMatches(pw)...)
+	return matches
+}
+
+// sequenceMatches finds runs such as "abcd", "9876" or "acegi" with a constant step.
+func sequenceMatches(pw []rune) (matches []strengthMatch) {
+	for i := 0; i < len(pw)-2; i++ {
+		delta := pw[i+1] - pw[i]
+		if delta == 0 || delta > 5 || delta < -5 {
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(pw) && pw[j+1]-pw[j] == delta {
+			j++
+		}
+		if j-i < 2 {
+			continue
+		}
+
+		base := 26.0
+		switch {
+		case strings.ContainsRune("az09", pw[i]) || pw[i] == '1':
+			base = 4
+		case unicode.IsDigit(pw[i]):
+			base = 10
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, strengthMatch{i: i, j: j, guesses: base * float64(j-i+1)})
+	}
+	return matches
+}
+
+// keyboardMatches finds walks along a keyboard row such as "qwert" or "lkjh".
+func keyboardMatches(pw []rune) (matches []strengthMatch) {
+	for i := 0; i < len(pw)-2; i++ {
+		for _, row := range keyboardRows {
+			for _, r := range []string{row, reverse(row)} {
+				j := i
+				for j+1 < len(pw) && strings.Contains(r, string(pw[i:j+2])) {
+					j++
+				}
+				if j-i >= 2 {
+					matches = append(matches, strengthMatch{i: i, j: j, guesses: float64(len(keyboardRows)) * 2 * float64(j-i+1)})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds repetitions of a character or a group of characters such as "aaaa" or "abcabc".
+func (e *strengthEstimator) repeatMatches(pw []rune) (matches []strengthMatch) {
+	for i := range pw {
+		for size := 1; i+2*size <= len(pw); size++ {
+			j := i + size
+			for j+size <= len(pw) && strings.EqualFold(string(pw[j:j+size]), string(pw[i:i+size])) {
+				j += size
+			}
+			if repeats := (j - i) / size; repeats > 1 {
+				base := e.minimumGuesses(pw[i : i+size])
+				matches = append(matches, strengthMatch{i: i, j: j - 1, guesses: base * float64(repeats)})
+			}
+		}
+	}
+	return matches
+}
+
+// casingVariations returns the number of guesses needed to find the casing of a word. All lowercase, all uppercase
+// and capitalized words only need a few additional guesses.
+func casingVariations(word []rune) float64 {
+	s := string(word)
+	switch {
+	case s == strings.ToLower(s):
+		return 1
+	case s == strings.ToUpper(s), unicode.IsUpper(word[0]) && string(word[1:]) == strings.ToLower(string(word[1:])):
+		return 2
+	}
+
+	var upper, lower int
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	var variations float64
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return math.Max(variations, 1)
+}
+
+var leetReplacer = strings.NewReplacer("4", "a", "@", "a", "8", "b", "(", "c", "3", "e", "6", "g", "1", "i", "!", "i", "|", "l", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t", "2", "z")
+
+// unleet replaces common character substitutions such as "p4$$w0rd". Every character is replaced by exactly one
+// character so that positions stay the same.
+func unleet(s string) string {
+	return leetReplacer.Replace(s)
+}
+
+func rankedWords(words ...string) map[string]int {
+	ranked := make(map[string]int, len(words))
+	for k, w := range words {
+		ranked[w] = k + 1
+	}
+	return ranked
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for k := 2; k <= n; k++ {
+		f *= float64(k)
+	}
+	return f
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r = r * float64(n-k+d) / float64(d)
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package password
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	for k, tc := range []struct {
+		password   string
+		userInputs []string
+		score      int
+	}{
+		{password: "password", score: 0},
+		{password: "Password1", score: 0},
+		{password: "P@ssw0rd!", score: 0},
+		{password: "qwertyuiop", score: 0},
+		{password: "zxcvbnm,./", score: 0},
+		{password: "abcdefgh", score: 0},
+		{password: "aaaaaaaaaa", score: 0},
+		{password: "abcabcabcabc", score: 0},
+		{password: "hunter2", score: 0},
+		{password: "ferdinand1", userInputs: []string{"ferdinand"}, score: 0},
+		{password: "2024summer", score: 1},
+		{password: "jdk3*Lq9#mZ", score: 4},
+		{password: "correcthorsebatterystaple", score: 4},
+		{password: strings.Repeat("9Kx!", 100), score: 1},
+		{password: "jdk3*Lq9#mZ" + strings.Repeat("x", 200), score: 4},
+	} {
+		t.Run(fmt.Sprintf("case=%d", k), func(t *testing.T) {
+			assert.Equal(t, tc.score, estimateStrength(tc.password, tc.userInputs))
+		})
+	}
+}
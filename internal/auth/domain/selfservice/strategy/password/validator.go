@@ -4,15 +4,19 @@
 package password
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha1" //#nosec G505 -- sha1 is used for k-anonymity
 	stderrs "errors"
 	"fmt"
+	"io"
 	"net/http"
-	"strconv"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"go.opentelemetry.io/otel/trace/noop"
 
@@ -22,11 +26,14 @@ import (
 	"github.com/dgraph-io/ristretto"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
 
 	"github.com/ory/herodot"
 	"github.com/ory/x/httpx"
 	"github.com/ory/x/otelx"
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/selfservice/strategy/password/breachindex"
 )
 
 const hashCacheItemTTL = time.Hour
@@ -38,6 +45,10 @@ type Validator interface {
 	// occurs, a regular error will be returned. If some other type of error occurs (e.g. HTTP request failed), an error
 	// of type *herodot.DefaultError will be returned.
 	Validate(ctx context.Context, identifier, password string) error
+
+	// ValidateIdentity validates the password for every identifier of the identity and, if enabled, checks that
+	// it does not contain any of the identity's trait values. Errors are returned as in Validate.
+	ValidateIdentity(ctx context.Context, i *identity.Identity, password string) error
 }
 
 type ValidationProvider interface {
@@ -45,9 +56,10 @@ type ValidationProvider interface {
 }
 
 var (
-	_                       Validator = new(DefaultPasswordValidator)
-	ErrNetworkFailure                 = stderrs.New("unable to check if password has been leaked because an unexpected network error occurred")
-	ErrUnexpectedStatusCode           = stderrs.New("unexpected status code")
+	_                        Validator = new(DefaultPasswordValidator)
+	ErrNetworkFailure                  = stderrs.New("unable to check if password has been leaked because an unexpected network error occurred")
+	ErrUnexpectedStatusCode            = stderrs.New("unexpected status code")
+	ErrBreachDataUnavailable           = stderrs.New("unable to check if password has been leaked because the local breach data could not be read")
 )
 
 // DefaultPasswordValidator implements Validator. It is based on best
@@ -58,12 +70,17 @@ var (
 //
 // Additionally passwords are being checked against Troy Hunt's
 // [haveibeenpwnd](https://haveibeenpwned.com/API/v2#SearchingPwnedPasswordsByRange) service to check if the
-// password has been breached in a previous data leak using k-anonymity.
+// password has been breached in a previous data leak using k-anonymity. Air-gapped deployments can use a local
+// copy of the data instead.
 type DefaultPasswordValidator struct {
 	reg    validatorDependencies
 	Client *retryablehttp.Client
 	hashes *ristretto.Cache
 
+	indexMu   sync.Mutex
+	index     *breachindex.Index
+	indexPath string
+
 	minIdentifierPasswordDist            int
 	maxIdentifierPasswordSubstrThreshold float32
 }
@@ -138,33 +155,75 @@ func (s *DefaultPasswordValidator) fetch(ctx context.Context, hpw []byte, apiDNS
 		return 0, errors.Wrapf(ErrUnexpectedStatusCode, "%d", res.StatusCode)
 	}
 
-	var thisCount int64
+	return s.scanRange(res.Body, prefix, hpw)
+}
 
-	sc := bufio.NewScanner(res.Body)
-	for sc.Scan() {
-		row := sc.Text()
-		result := strings.Split(strings.TrimSpace(row), ":")
-
-		// We assume a count of 1. HIBP API sometimes responds without the
-		// colon, so we just assume that the leak count is one.
-		//
-		// See https://my.com/secrets/internal/auth/domain/issues/2145
-		count := int64(1)
-		if len(result) == 2 {
-			count, err = strconv.ParseInt(strings.ReplaceAll(result[1], ",", ""), 10, 64)
-			if err != nil {
-				return 0, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Expected password hash to contain a count formatted as int but got: %s", result[1]))
-			}
-		}
+// lookup reads the breach count from the local data at path. The path is either a directory of range files or a
+// breach index.
+func (s *DefaultPasswordValidator) lookup(hpw []byte, path string) (int64, error) {
+	if path == "" {
+		return 0, errors.Wrap(ErrBreachDataUnavailable, "no path to the local breach data is configured")
+	}
 
-		s.hashes.SetWithTTL(prefix+result[0], count, 1, hashCacheItemTTL)
-		if prefix+result[0] == b20(hpw) {
-			thisCount = count
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0, errors.Wrapf(ErrBreachDataUnavailable, "%s", err)
+	}
+
+	if stat.IsDir() {
+		prefix := fmt.Sprintf("%X", hpw)[0:5]
+		f, err := os.Open(filepath.Join(path, prefix+".txt")) //#nosec G304 -- the path is set by the operator
+		if err != nil {
+			return 0, errors.Wrapf(ErrBreachDataUnavailable, "%s", err)
 		}
+		defer f.Close()
+		return s.scanRange(f, prefix, hpw)
+	}
+
+	idx, err := s.openIndex(path)
+	if err != nil {
+		return 0, errors.Wrapf(ErrBreachDataUnavailable, "%s", err)
+	}
+	count, err := idx.Count(hpw)
+	if err != nil {
+		return 0, errors.Wrapf(ErrBreachDataUnavailable, "%s", err)
+	}
+
+	s.hashes.SetWithTTL(b20(hpw), count, 1, hashCacheItemTTL)
+	return count, nil
+}
+
+// openIndex returns the breach index at path. The index is kept open until the configured path changes.
+func (s *DefaultPasswordValidator) openIndex(path string) (*breachindex.Index, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if s.index != nil && s.indexPath == path {
+		return s.index, nil
+	}
+
+	idx, err := breachindex.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.index != nil {
+		_ = s.index.Close()
 	}
+	s.index, s.indexPath = idx, path
+	return idx, nil
+}
 
-	if err := sc.Err(); err != nil {
-		return 0, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to initialize string scanner: %s", err))
+// scanRange caches all hashes of a range response and returns the breach count of hpw.
+func (s *DefaultPasswordValidator) scanRange(r io.Reader, prefix string, hpw []byte) (int64, error) {
+	var thisCount int64
+	if err := breachindex.ScanRange(r, prefix, func(hash []byte, count int64) error {
+		s.hashes.SetWithTTL(b20(hash), count, 1, hashCacheItemTTL)
+		if bytes.Equal(hash, hpw) {
+			thisCount = count
+		}
+		return nil
+	}); err != nil {
+		return 0, errors.WithStack(herodot.ErrInternalServerError.WithReasonf("Unable to read the breached password hashes: %s", err))
 	}
 
 	s.hashes.SetWithTTL(b20(hpw), thisCount, 1, hashCacheItemTTL)
@@ -173,11 +232,11 @@ func (s *DefaultPasswordValidator) fetch(ctx context.Context, hpw []byte, apiDNS
 
 func (s *DefaultPasswordValidator) Validate(ctx context.Context, identifier, password string) error {
 	return otelx.WithSpan(ctx, "password.DefaultPasswordValidator.Validate", func(ctx context.Context) error {
-		return s.validate(ctx, identifier, password)
+		return s.validate(ctx, identifier, password, s.reg.Config().PasswordPolicyConfig(ctx).BannedWords)
 	})
 }
 
-func (s *DefaultPasswordValidator) validate(ctx context.Context, identifier, password string) error {
+func (s *DefaultPasswordValidator) validate(ctx context.Context, identifier, password string, bannedWords []string) error {
 	passwordPolicyConfig := s.reg.Config().PasswordPolicyConfig(ctx)
 
 	if len(password) < int(passwordPolicyConfig.MinPasswordLength) {
@@ -193,6 +252,25 @@ func (s *DefaultPasswordValidator) validate(ctx context.Context, identifier, pas
 		}
 	}
 
+	if err := s.validateComposition(password, passwordPolicyConfig); err != nil {
+		return err
+	}
+
+	if lower := strings.ToLower(password); len(bannedWords) > 0 {
+		unleeted := unleet(lower)
+		for _, word := range bannedWords {
+			if w := strings.ToLower(word); w != "" && (strings.Contains(lower, w) || strings.Contains(unleeted, w)) {
+				return text.NewErrorValidationPasswordContainsBannedWord(word)
+			}
+		}
+	}
+
+	if minScore := int(passwordPolicyConfig.MinStrengthScore); minScore > 0 {
+		if score := estimateStrength(password, append([]string{identifier}, bannedWords...)); score < minScore {
+			return text.NewErrorValidationPasswordTooWeak(minScore, score)
+		}
+	}
+
 	if !passwordPolicyConfig.HaveIBeenPwnedEnabled {
 		return nil
 	}
@@ -207,8 +285,12 @@ func (s *DefaultPasswordValidator) validate(ctx context.Context, identifier, pas
 	c, ok := s.hashes.Get(b20(hpw))
 	if !ok {
 		var err error
-		c, err = s.fetch(ctx, hpw, passwordPolicyConfig.HaveIBeenPwnedHost)
-		if (errors.Is(err, ErrNetworkFailure) || errors.Is(err, ErrUnexpectedStatusCode)) && passwordPolicyConfig.IgnoreNetworkErrors {
+		if passwordPolicyConfig.HaveIBeenPwnedSource == config.HaveIBeenPwnedSourceLocal {
+			c, err = s.lookup(hpw, passwordPolicyConfig.HaveIBeenPwnedLocalPath)
+		} else {
+			c, err = s.fetch(ctx, hpw, passwordPolicyConfig.HaveIBeenPwnedHost)
+		}
+		if (errors.Is(err, ErrNetworkFailure) || errors.Is(err, ErrUnexpectedStatusCode) || errors.Is(err, ErrBreachDataUnavailable)) && passwordPolicyConfig.IgnoreNetworkErrors {
 			return nil
 		} else if err != nil {
 			return err
@@ -222,3 +304,106 @@ func (s *DefaultPasswordValidator) validate(ctx context.Context, identifier, pas
 
 	return nil
 }
+
+// validateComposition checks the character classes of the password.
+func (s *DefaultPasswordValidator) validateComposition(password string, policy *config.PasswordPolicy) error {
+	if len(policy.RequiredCharacterClasses) == 0 && policy.MinCharacterClasses == 0 {
+		return nil
+	}
+
+	classes := map[string]bool{}
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			classes[config.PasswordCharacterClassLowercase] = true
+		case unicode.IsUpper(r):
+			classes[config.PasswordCharacterClassUppercase] = true
+		case unicode.IsDigit(r):
+			classes[config.PasswordCharacterClassDigit] = true
+		default:
+			classes[config.PasswordCharacterClassSymbol] = true
+		}
+	}
+
+	for _, class := range policy.RequiredCharacterClasses {
+		if !classes[class] {
+			return text.NewErrorValidationPasswordCharacterClassMissing(class)
+		}
+	}
+
+	if len(classes) < int(policy.MinCharacterClasses) {
+		return text.NewErrorValidationPasswordTooFewCharacterClasses(int(policy.MinCharacterClasses), len(classes))
+	}
+
+	return nil
+}
+
+func (s *DefaultPasswordValidator) ValidateIdentity(ctx context.Context, i *identity.Identity, password string) error {
+	return otelx.WithSpan(ctx, "password.DefaultPasswordValidator.ValidateIdentity", func(ctx context.Context) error {
+		identifiers := []string{""}
+		if c, ok := i.GetCredentials(identity.CredentialsTypePassword); ok && len(c.Identifiers) > 0 {
+			identifiers = c.Identifiers
+		}
+
+		bannedWords := s.reg.Config().PasswordBannedWords(ctx, i.SchemaID, i.OrganizationID)
+		for _, id := range identifiers {
+			if err := s.validate(ctx, id, password, bannedWords); err != nil {
+				return err
+			}
+		}
+
+		if !s.reg.Config().PasswordPolicyConfig(ctx).TraitSimilarityCheckEnabled {
+			return nil
+		}
+
+		compPassword := strings.ToLower(password)
+		for _, value := range traitValues(i.Traits) {
+			if strings.Contains(compPassword, value) {
+				return text.NewErrorValidationPasswordContainsTrait()
+			}
+		}
+
+		return nil
+	})
+}
+
+// minTraitValueLength is the minimum length of a trait value or a part of it to be compared to the password. Shorter
+// values, such as initials or country codes, would reject too many passwords.
+const minTraitValueLength = 4
+
+// traitValues returns the lowercased string values of the traits and their words, for example "jane", "doe" and
+// "jane.doe@example.org" for the email address jane.doe@example.org.
+func traitValues(traits identity.Traits) []string {
+	var values []string
+	gjson.ParseBytes(traits).ForEach(func(_, value gjson.Result) bool {
+		values = append(values, traitValuesOf(value)...)
+		return true
+	})
+	return values
+}
+
+func traitValuesOf(value gjson.Result) (values []string) {
+	switch {
+	case value.IsObject(), value.IsArray():
+		value.ForEach(func(_, v gjson.Result) bool {
+			values = append(values, traitValuesOf(v)...)
+			return true
+		})
+	case value.Type == gjson.String:
+		v := strings.ToLower(value.String())
+		if len([]rune(v)) >= minTraitValueLength {
+			values = append(values, v)
+		}
+
+		// The domain of email addresses is not personal information.
+		local, _, _ := strings.Cut(v, "@")
+		for _, word := range strings.FieldsFunc(local, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(word)) >= minTraitValueLength && word != v {
+				values = append(values, word)
+			}
+		}
+	}
+	return values
+}
@@ -14,6 +14,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +24,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/gofrs/uuid"
+
 	"github.com/ory/herodot"
 
 	"github.com/stretchr/testify/require"
@@ -29,7 +34,9 @@ import (
 
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/selfservice/strategy/password"
+	"my.com/secrets/internal/auth/domain/selfservice/strategy/password/breachindex"
 )
 
 func TestDefaultPasswordValidationStrategy(t *testing.T) {
@@ -293,6 +300,72 @@ func TestDisableHaveIBeenPwnedValidationHost(t *testing.T) {
 	})
 }
 
+func TestLocalHaveIBeenPwnedSource(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	conf.MustSet(ctx, config.ViperKeyPasswordHaveIBeenPwnedSource, config.HaveIBeenPwnedSourceLocal)
+	conf.MustSet(ctx, config.ViperKeyPasswordMaxBreaches, 5)
+
+	hashPw := func(pw string) string {
+		//#nosec G401 -- sha1 is used for k-anonymity
+		h := sha1.Sum([]byte(pw))
+		return fmt.Sprintf("%X", h[:])
+	}
+
+	// The range files contain two breached passwords and one which was breached less often than allowed.
+	dir := t.TempDir()
+	breached := map[string]int{"bihfuwazoi": 10, "xagvesbeku": 6, "nolcutaroh": 5}
+	ranges := map[string][]string{}
+	for pw, count := range breached {
+		h := hashPw(pw)
+		ranges[h[:5]] = append(ranges[h[:5]], fmt.Sprintf("%s:%d", h[5:], count))
+	}
+	for prefix, lines := range ranges {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0600))
+	}
+
+	index := filepath.Join(t.TempDir(), "breaches.idx")
+	f, err := os.Create(index)
+	require.NoError(t, err)
+	w, err := breachindex.NewWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, breachindex.ReadSource(dir, w.Add))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	for _, path := range []string{dir, index} {
+		t.Run("path="+filepath.Base(path), func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeyPasswordHaveIBeenPwnedLocalPath, path)
+			s, err := password.NewDefaultPasswordValidatorStrategy(reg)
+			require.NoError(t, err)
+
+			for pw, count := range breached {
+				err := s.Validate(ctx, "", pw)
+				if count > 5 {
+					assert.ErrorIs(t, err, text.NewErrorValidationPasswordTooManyBreaches(int64(count)), pw)
+				} else {
+					assert.NoError(t, err, pw)
+				}
+			}
+			assert.NoError(t, s.Validate(ctx, "", "tojenurapi"))
+		})
+	}
+
+	t.Run("case=missing data follows the network error setting", func(t *testing.T) {
+		for _, path := range []string{t.TempDir(), filepath.Join(t.TempDir(), "does-not-exist"), "", filepath.Join(dir, hashPw("bihfuwazoi")[:5]+".txt")} {
+			s, err := password.NewDefaultPasswordValidatorStrategy(reg)
+			require.NoError(t, err)
+			conf.MustSet(ctx, config.ViperKeyPasswordHaveIBeenPwnedLocalPath, path)
+
+			conf.MustSet(ctx, config.ViperKeyIgnoreNetworkErrors, false)
+			assert.ErrorIs(t, s.Validate(ctx, "", "bihfuwazoi"), password.ErrBreachDataUnavailable, path)
+
+			conf.MustSet(ctx, config.ViperKeyIgnoreNetworkErrors, true)
+			assert.NoError(t, s.Validate(ctx, "", "bihfuwazoi"), path)
+		}
+	})
+}
+
 func TestChangeMinPasswordLength(t *testing.T) {
 	ctx := context.Background()
 	conf, reg := external.NewFastRegistryWithMocks(t)
@@ -324,6 +397,87 @@ func TestChangeIdentifierSimilarityCheckEnabled(t *testing.T) {
 	})
 }
 
+func TestPasswordComposition(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	conf.MustSet(ctx, config.ViperKeyPasswordHaveIBeenPwnedEnabled, false)
+	s, err := password.NewDefaultPasswordValidatorStrategy(reg)
+	require.NoError(t, err)
+
+	t.Run("case=required character classes", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordRequiredCharacterClasses, []string{config.PasswordCharacterClassUppercase, config.PasswordCharacterClassDigit})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordRequiredCharacterClasses, nil) })
+
+		assert.ErrorIs(t, s.Validate(ctx, "", "kuobahcaas"), text.NewErrorValidationPasswordCharacterClassMissing(config.PasswordCharacterClassUppercase))
+		assert.ErrorIs(t, s.Validate(ctx, "", "kuoBahcaas"), text.NewErrorValidationPasswordCharacterClassMissing(config.PasswordCharacterClassDigit))
+		assert.NoError(t, s.Validate(ctx, "", "kuoBahca4s"))
+	})
+
+	t.Run("case=minimum number of character classes", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordMinCharacterClasses, 3)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordMinCharacterClasses, 0) })
+
+		assert.ErrorIs(t, s.Validate(ctx, "", "kuoBahcaas"), text.NewErrorValidationPasswordTooFewCharacterClasses(3, 2))
+		assert.NoError(t, s.Validate(ctx, "", "kuoBahca4s"))
+		assert.NoError(t, s.Validate(ctx, "", "kuobahca4s!"))
+	})
+
+	t.Run("case=banned words", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordBannedWords, []string{"Acme", "rocket"})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordBannedWords, nil) })
+
+		for _, pw := range []string{"acmekuobahcaas", "kuoACMEbahcaas", "kuo@cmebahcaas"} {
+			assert.ErrorIs(t, s.Validate(ctx, "", pw), text.NewErrorValidationPasswordContainsBannedWord("Acme"), pw)
+		}
+		assert.ErrorIs(t, s.Validate(ctx, "", "r0cketkuobahcaas"), text.NewErrorValidationPasswordContainsBannedWord("rocket"))
+		assert.NoError(t, s.Validate(ctx, "", "kuobahcaas"))
+	})
+
+	t.Run("case=banned words per organization", func(t *testing.T) {
+		orgID := uuid.Must(uuid.NewV4())
+		conf.MustSet(ctx, config.ViperKeyPasswordBannedWords, []string{"acme"})
+		conf.MustSet(ctx, config.ViperKeyPasswordBannedWordsByOrganization, map[string]interface{}{orgID.String(): []string{"initech"}})
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeyPasswordBannedWords, nil)
+			conf.MustSet(ctx, config.ViperKeyPasswordBannedWordsByOrganization, nil)
+		})
+
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		assert.ErrorIs(t, s.ValidateIdentity(ctx, i, "acmekuobahcaas"), text.NewErrorValidationPasswordContainsBannedWord("acme"))
+		assert.NoError(t, s.ValidateIdentity(ctx, i, "initechkuobahcaas"))
+
+		i.OrganizationID = uuid.NullUUID{UUID: orgID, Valid: true}
+		assert.NoError(t, s.ValidateIdentity(ctx, i, "acmekuobahcaas"))
+		assert.ErrorIs(t, s.ValidateIdentity(ctx, i, "initechkuobahcaas"), text.NewErrorValidationPasswordContainsBannedWord("initech"))
+	})
+
+	t.Run("case=minimum strength", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordMinStrengthScore, 3)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordMinStrengthScore, 0) })
+
+		for _, pw := range []string{"password", "Password1234", "qwertyuiop", "aaaaaaaaaaaa", "abcdefghijkl", "summer2019"} {
+			assert.ErrorIs(t, s.Validate(ctx, "", pw), text.NewErrorValidationPasswordTooWeak(3, 0), pw)
+		}
+		assert.NoError(t, s.Validate(ctx, "", "jdk3*Lq9#mZ"))
+	})
+
+	t.Run("case=trait values", func(t *testing.T) {
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		i.Traits = identity.Traits(`{"email":"jane.doe@acme.example","name":{"first":"Jane","last":"Bahcaas"},"company":"Initech","tags":["kuob"]}`)
+
+		conf.MustSet(ctx, config.ViperKeyPasswordTraitSimilarityCheckEnabled, false)
+		assert.NoError(t, s.ValidateIdentity(ctx, i, "kuobahcaas"))
+
+		conf.MustSet(ctx, config.ViperKeyPasswordTraitSimilarityCheckEnabled, true)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordTraitSimilarityCheckEnabled, false) })
+		for _, pw := range []string{"kuobahcaas", "mybahcaas123", "initech-rocks", "JANE.DOE@ACME.EXAMPLE"} {
+			assert.ErrorIs(t, s.ValidateIdentity(ctx, i, pw), text.NewErrorValidationPasswordContainsTrait(), pw)
+		}
+		// The email domain and values shorter than four characters are not compared.
+		assert.NoError(t, s.ValidateIdentity(ctx, i, "acme-example-doe"))
+	})
+}
+
 type fakeValidatorAPI struct{}
 
 func (api *fakeValidatorAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
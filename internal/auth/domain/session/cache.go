@@ -0,0 +1,313 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+// cacheMetrics are the metrics about the session cache.
+type cacheMetrics struct {
+	hits          prometheus.Counter
+	misses        prometheus.Counter
+	invalidations *prometheus.CounterVec
+}
+
+func newCacheMetrics() *cacheMetrics {
+	return &cacheMetrics{
+		hits: x.RegisterMetric(prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kratos_session_cache_hits_total",
+			Help: "Number of sessions fetched by their token which were served from the session cache.",
+		})),
+		misses: x.RegisterMetric(prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kratos_session_cache_misses_total",
+			Help: "Number of sessions fetched by their token which were not in the session cache.",
+		})),
+		invalidations: x.RegisterMetric(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kratos_session_cache_invalidations_total",
+			Help: "Number of session cache invalidations, by origin (local or remote).",
+		}, []string{"origin"})),
+	}
+}
+
+type (
+	CacheProvider interface {
+		SessionCache() *Cache
+	}
+
+	cacheDependencies interface {
+		config.Provider
+		x.LoggingProvider
+		NetworkID(ctx context.Context) uuid.UUID
+	}
+
+	// Cache is a bounded in-memory cache of sessions keyed by the HMAC of their network and token. Entries live at
+	// most for the configured TTL and never beyond the session's expiry. Invalidations are applied locally and
+	// broadcast to other instances through the CacheInvalidationBus.
+	Cache struct {
+		d         cacheDependencies
+		bus       CacheInvalidationBus
+		metrics   *cacheMetrics
+		listeners []func(*CacheInvalidation)
+
+		sync.Mutex
+		entries    *simplelru.LRU
+		bySession  map[uuid.UUID]string
+		byIdentity map[uuid.UUID]map[string]struct{}
+	}
+
+	cacheEntry struct {
+		session   *Session
+		expiresAt time.Time
+	}
+
+	// CacheInvalidation is broadcast to other instances when cached sessions must be dropped. Exactly one of the
+	// fields is set, unless All is true.
+	CacheInvalidation struct {
+		Key        string    `json:"key,omitempty"`
+		SessionID  uuid.UUID `json:"session_id,omitempty"`
+		IdentityID uuid.UUID `json:"identity_id,omitempty"`
+		All        bool      `json:"all,omitempty"`
+	}
+
+	// CacheInvalidationBus broadcasts session cache invalidations between instances.
+	CacheInvalidationBus interface {
+		// Publish sends the invalidation to all subscribed instances, including this one.
+		Publish(ctx context.Context, inv *CacheInvalidation) error
+
+		// Subscribe calls the handler for every received invalidation until the context is done. Because
+		// invalidations may be missed while the bus is disconnected, the bus calls the handler with an
+		// invalidation of all sessions after reconnecting.
+		Subscribe(ctx context.Context, handler func(*CacheInvalidation)) error
+	}
+
+	noopCacheInvalidationBus struct{}
+)
+
+// NewNoopCacheInvalidationBus returns a bus which does not broadcast invalidations. Use it when only one instance
+// is running.
+func NewNoopCacheInvalidationBus() CacheInvalidationBus {
+	return new(noopCacheInvalidationBus)
+}
+
+func (*noopCacheInvalidationBus) Publish(context.Context, *CacheInvalidation) error { return nil }
+
+func (*noopCacheInvalidationBus) Subscribe(context.Context, func(*CacheInvalidation)) error {
+	return nil
+}
+
+func NewCache(ctx context.Context, d cacheDependencies, bus CacheInvalidationBus) (*Cache, error) {
+	c := &Cache{
+		d:          d,
+		bus:        bus,
+		metrics:    newCacheMetrics(),
+		bySession:  map[uuid.UUID]string{},
+		byIdentity: map[uuid.UUID]map[string]struct{}{},
+	}
+
+	entries, err := simplelru.NewLRU(d.Config().SessionCacheMaxEntries(ctx), c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.entries = entries
+
+	if err := bus.Subscribe(ctx, c.apply); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cache) key(ctx context.Context, token string) string {
+	h := hmac.New(sha512.New512_256, c.d.Config().SecretsSession(ctx)[0])
+	_, _ = h.Write([]byte(c.d.NetworkID(ctx).String()))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(token))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Get returns a copy of the cached session for the token, or false if the session is not cached.
+func (c *Cache) Get(ctx context.Context, token string) (*Session, bool) {
+	if !c.d.Config().SessionCacheEnabled(ctx) {
+		return nil, false
+	}
+
+	key := c.key(ctx, token)
+
+	c.Lock()
+	defer c.Unlock()
+
+	value, ok := c.entries.Get(key)
+	if !ok {
+		c.metrics.misses.Inc()
+		return nil, false
+	}
+
+	entry := value.(*cacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.entries.Remove(key)
+		c.metrics.misses.Inc()
+		return nil, false
+	}
+
+	c.metrics.hits.Inc()
+	return entry.session.clone(), true
+}
+
+// Set caches a copy of the session for the token. Inactive and expired sessions are not cached.
+func (c *Cache) Set(ctx context.Context, token string, s *Session) {
+	if !c.d.Config().SessionCacheEnabled(ctx) || !s.IsActive() {
+		return
+	}
+
+	expiresAt := time.Now().Add(c.d.Config().SessionCacheTTL(ctx))
+	if s.ExpiresAt.Before(expiresAt) {
+		expiresAt = s.ExpiresAt
+	}
+
+	key := c.key(ctx, token)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries.Add(key, &cacheEntry{session: s.clone(), expiresAt: expiresAt})
+	c.bySession[s.ID] = key
+	if c.byIdentity[s.IdentityID] == nil {
+		c.byIdentity[s.IdentityID] = map[string]struct{}{}
+	}
+	c.byIdentity[s.IdentityID][key] = struct{}{}
+}
+
+// OnInvalidate registers a function which is called with every invalidation, whether it was made on this
+// instance or received from the bus, so that other in-memory caches of session state can drop their entries.
+func (c *Cache) OnInvalidate(listener func(*CacheInvalidation)) {
+	c.Lock()
+	defer c.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// InvalidateToken drops the session with the given token on all instances.
+func (c *Cache) InvalidateToken(ctx context.Context, token string) {
+	c.invalidate(ctx, &CacheInvalidation{Key: c.key(ctx, token)})
+}
+
+// InvalidateSession drops the session with the given ID on all instances.
+func (c *Cache) InvalidateSession(ctx context.Context, sessionID uuid.UUID) {
+	c.invalidate(ctx, &CacheInvalidation{SessionID: sessionID})
+}
+
+// InvalidateIdentity drops all sessions of the given identity on all instances.
+func (c *Cache) InvalidateIdentity(ctx context.Context, identityID uuid.UUID) {
+	c.invalidate(ctx, &CacheInvalidation{IdentityID: identityID})
+}
+
+func (c *Cache) invalidate(ctx context.Context, inv *CacheInvalidation) {
+	// The introspection cache relies on the same invalidations, even if sessions are not cached.
+	if !c.d.Config().SessionCacheEnabled(ctx) && !c.d.Config().SessionIntrospectionCacheEnabled(ctx) {
+		return
+	}
+
+	c.metrics.invalidations.WithLabelValues("local").Inc()
+	c.remove(inv)
+
+	if err := c.bus.Publish(ctx, inv); err != nil {
+		c.d.Logger().WithError(err).
+			Error("Unable to broadcast the session cache invalidation. Other instances may serve the invalidated sessions until their cache entries expire.")
+	}
+}
+
+// apply handles invalidations received from the bus.
+func (c *Cache) apply(inv *CacheInvalidation) {
+	c.metrics.invalidations.WithLabelValues("remote").Inc()
+	c.remove(inv)
+}
+
+func (c *Cache) remove(inv *CacheInvalidation) {
+	c.Lock()
+	listeners := c.listeners
+	c.removeLocked(inv)
+	c.Unlock()
+
+	for _, listener := range listeners {
+		listener(inv)
+	}
+}
+
+func (c *Cache) removeLocked(inv *CacheInvalidation) {
+	switch {
+	case inv.All:
+		c.entries.Purge()
+	case inv.Key != "":
+		c.entries.Remove(inv.Key)
+	case inv.SessionID != uuid.Nil:
+		if key, ok := c.bySession[inv.SessionID]; ok {
+			c.entries.Remove(key)
+		}
+	case inv.IdentityID != uuid.Nil:
+		for key := range c.byIdentity[inv.IdentityID] {
+			c.entries.Remove(key)
+		}
+	}
+}
+
+// onEvict keeps the session and identity indexes in sync with the entries. It is called with the lock held.
+func (c *Cache) onEvict(key, value interface{}) {
+	s := value.(*cacheEntry).session
+	if c.bySession[s.ID] == key {
+		delete(c.bySession, s.ID)
+	}
+	if keys, ok := c.byIdentity[s.IdentityID]; ok {
+		delete(keys, key.(string))
+		if len(keys) == 0 {
+			delete(c.byIdentity, s.IdentityID)
+		}
+	}
+}
+
+// clone copies the session so that callers can modify the returned session without altering the cached one.
+func (s *Session) clone() *Session {
+	cloned := *s
+	cloned.AMR = append(AuthenticationMethods(nil), s.AMR...)
+	cloned.Devices = append([]Device(nil), s.Devices...)
+
+	if s.RiskAssessment != nil {
+		assessment := *s.RiskAssessment
+		assessment.Reasons = append([]string(nil), s.RiskAssessment.Reasons...)
+		cloned.RiskAssessment = &assessment
+	}
+
+	if s.Identity != nil {
+		i := *s.Identity
+		i.Traits = identity.Traits(bytes.Clone(s.Identity.Traits))
+		i.MetadataPublic = bytes.Clone(s.Identity.MetadataPublic)
+		i.MetadataAdmin = bytes.Clone(s.Identity.MetadataAdmin)
+		i.VerifiableAddresses = append([]identity.VerifiableAddress(nil), s.Identity.VerifiableAddresses...)
+		i.RecoveryAddresses = append([]identity.RecoveryAddress(nil), s.Identity.RecoveryAddresses...)
+		if s.Identity.Credentials != nil {
+			i.Credentials = make(map[identity.CredentialsType]identity.Credentials, len(s.Identity.Credentials))
+			for t, cred := range s.Identity.Credentials {
+				cred.Identifiers = append([]string(nil), cred.Identifiers...)
+				cred.Config = bytes.Clone(cred.Config)
+				i.Credentials[t] = cred
+			}
+		}
+		cloned.Identity = &i
+	}
+
+	return &cloned
+}
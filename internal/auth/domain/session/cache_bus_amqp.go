@@ -0,0 +1,160 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+
+	"github.com/ory/x/logrusx"
+)
+
+// AMQPCacheInvalidationBus broadcasts session cache invalidations through a fanout exchange of an AMQP broker such
+// as RabbitMQ. Every instance consumes the exchange through its own exclusive queue.
+type AMQPCacheInvalidationBus struct {
+	url      string
+	exchange string
+	l        *logrusx.Logger
+
+	sync.Mutex
+	publisher *amqp.Channel
+}
+
+func NewAMQPCacheInvalidationBus(url, exchange string, l *logrusx.Logger) *AMQPCacheInvalidationBus {
+	return &AMQPCacheInvalidationBus{url: url, exchange: exchange, l: l}
+}
+
+func (b *AMQPCacheInvalidationBus) channel() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if err := ch.ExchangeDeclare(b.exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return conn, ch, nil
+}
+
+func (b *AMQPCacheInvalidationBus) Publish(ctx context.Context, inv *CacheInvalidation) error {
+	body, err := json.Marshal(inv)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.publisher == nil {
+		_, ch, err := b.channel()
+		if err != nil {
+			return err
+		}
+		b.publisher = ch
+	}
+
+	if err := b.publisher.Publish(b.exchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	}); err != nil {
+		// Reconnect on the next publish.
+		_ = b.publisher.Close()
+		b.publisher = nil
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (b *AMQPCacheInvalidationBus) Subscribe(ctx context.Context, handler func(*CacheInvalidation)) error {
+	deliveries, conn, err := b.consume()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			b.receive(ctx, deliveries, handler)
+			_ = conn.Close()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+
+				if deliveries, conn, err = b.consume(); err != nil {
+					b.l.WithError(err).Warn("Unable to reconnect to the session cache invalidation exchange, retrying.")
+					continue
+				}
+
+				// Invalidations published while disconnected are lost.
+				handler(&CacheInvalidation{All: true})
+				break
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (b *AMQPCacheInvalidationBus) consume() (<-chan amqp.Delivery, *amqp.Connection, error) {
+	conn, ch, err := b.channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if err := ch.QueueBind(q.Name, "", b.exchange, false, nil); err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+
+	deliveries, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return deliveries, conn, nil
+}
+
+func (b *AMQPCacheInvalidationBus) receive(ctx context.Context, deliveries <-chan amqp.Delivery, handler func(*CacheInvalidation)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d, ok := <-deliveries:
+			if !ok {
+				return
+			}
+
+			var inv CacheInvalidation
+			if err := json.Unmarshal(d.Body, &inv); err != nil {
+				b.l.WithError(err).Warn("Ignoring malformed session cache invalidation.")
+				continue
+			}
+			handler(&inv)
+		}
+	}
+}
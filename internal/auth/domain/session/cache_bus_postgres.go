@@ -0,0 +1,95 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ory/x/logrusx"
+)
+
+// PostgresCacheInvalidationBus broadcasts session cache invalidations using PostgreSQL's LISTEN and NOTIFY.
+type PostgresCacheInvalidationBus struct {
+	dsn     string
+	channel string
+	l       *logrusx.Logger
+
+	sync.Mutex
+	db *sql.DB
+}
+
+func NewPostgresCacheInvalidationBus(dsn, channel string, l *logrusx.Logger) *PostgresCacheInvalidationBus {
+	return &PostgresCacheInvalidationBus{dsn: dsn, channel: channel, l: l}
+}
+
+func (b *PostgresCacheInvalidationBus) Publish(ctx context.Context, inv *CacheInvalidation) error {
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.Lock()
+	if b.db == nil {
+		if b.db, err = sql.Open("postgres", b.dsn); err != nil {
+			b.Unlock()
+			return errors.WithStack(err)
+		}
+	}
+	db := b.db
+	b.Unlock()
+
+	if _, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", b.channel, string(payload)); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (b *PostgresCacheInvalidationBus) Subscribe(ctx context.Context, handler func(*CacheInvalidation)) error {
+	listener := pq.NewListener(b.dsn, 10*time.Millisecond, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			b.l.WithError(err).Warn("The session cache invalidation listener lost its connection.")
+		}
+	})
+
+	if err := listener.Listen(b.channel); err != nil {
+		_ = listener.Close()
+		return errors.WithStack(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-listener.NotificationChannel():
+				if !ok {
+					return
+				}
+
+				// The listener sends nil after reconnecting. Notifications sent while disconnected are lost.
+				if n == nil {
+					handler(&CacheInvalidation{All: true})
+					continue
+				}
+
+				var inv CacheInvalidation
+				if err := json.Unmarshal([]byte(n.Extra), &inv); err != nil {
+					b.l.WithError(err).Warn("Ignoring malformed session cache invalidation.")
+					continue
+				}
+				handler(&inv)
+			}
+		}
+	}()
+
+	return nil
+}
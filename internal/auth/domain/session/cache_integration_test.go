@@ -0,0 +1,85 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+)
+
+func TestCacheInvalidationAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, true)
+
+	newCachedSession := func(t *testing.T) *session.Session {
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+		s, err := session.NewActiveSession(&http.Request{}, i, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		reg.SessionCache().Set(ctx, s.Token, s)
+		return s
+	}
+
+	cached := func(s *session.Session) bool {
+		_, ok := reg.SessionCache().Get(ctx, s.Token)
+		return ok
+	}
+
+	t.Run("case=invalidates once the transaction was committed", func(t *testing.T) {
+		s := newCachedSession(t)
+		require.NoError(t, reg.Persister().Transaction(ctx, func(ctx context.Context, _ *pop.Connection) error {
+			require.NoError(t, reg.SessionPersister().RevokeSessionById(ctx, s.ID))
+			assert.True(t, cached(s), "the session must not be invalidated before the transaction is committed")
+			return nil
+		}))
+		assert.False(t, cached(s))
+	})
+
+	t.Run("case=does not invalidate if the transaction is rolled back", func(t *testing.T) {
+		s := newCachedSession(t)
+		rollback := errors.New("rollback")
+		require.ErrorIs(t, reg.Persister().Transaction(ctx, func(ctx context.Context, _ *pop.Connection) error {
+			require.NoError(t, reg.SessionPersister().RevokeSessionById(ctx, s.ID))
+			return rollback
+		}), rollback)
+		assert.True(t, cached(s))
+	})
+
+	t.Run("case=invalidates immediately outside of a transaction", func(t *testing.T) {
+		s := newCachedSession(t)
+		require.NoError(t, reg.SessionPersister().RevokeSessionById(ctx, s.ID))
+		assert.False(t, cached(s))
+	})
+
+	t.Run("case=invalidates evicted sessions once the new session was committed", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionMaxConcurrent, 1)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionMaxConcurrent, 0) })
+
+		s := newCachedSession(t)
+		next, err := session.NewActiveSession(&http.Request{}, s.Identity, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.Persister().Transaction(ctx, func(ctx context.Context, _ *pop.Connection) error {
+			require.NoError(t, reg.SessionPersister().UpsertSession(ctx, next))
+			assert.True(t, cached(s))
+			return nil
+		}))
+		assert.False(t, cached(s))
+	})
+}
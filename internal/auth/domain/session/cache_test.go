@@ -0,0 +1,265 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/logrusx"
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type cacheDeps struct {
+	c   *config.Config
+	nid uuid.UUID
+}
+
+func (d *cacheDeps) Config() *config.Config                 { return d.c }
+func (d *cacheDeps) Logger() *logrusx.Logger                { return logrusx.New("", "") }
+func (d *cacheDeps) Audit() *logrusx.Logger                 { return logrusx.New("", "") }
+func (d *cacheDeps) NetworkID(context.Context) uuid.UUID    { return d.nid }
+func (d *cacheDeps) withNetwork(nid uuid.UUID) *cacheDeps   { return &cacheDeps{c: d.c, nid: nid} }
+func (d *cacheDeps) withConfig(c *config.Config) *cacheDeps { return &cacheDeps{c: c, nid: d.nid} }
+
+var _ x.LoggingProvider = new(cacheDeps)
+
+// memoryBus delivers invalidations to all caches subscribed to it.
+type memoryBus struct {
+	sync.Mutex
+	handlers []func(*CacheInvalidation)
+}
+
+func (b *memoryBus) Publish(_ context.Context, inv *CacheInvalidation) error {
+	b.Lock()
+	defer b.Unlock()
+	for _, h := range b.handlers {
+		h(inv)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(_ context.Context, handler func(*CacheInvalidation)) error {
+	b.Lock()
+	defer b.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	conf.MustSet(ctx, config.ViperKeySecretsDefault, []string{"a-very-secure-session-secret-value"})
+	conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, true)
+	conf.MustSet(ctx, config.ViperKeySessionCacheTTL, "1m")
+	conf.MustSet(ctx, config.ViperKeySessionCacheMaxEntries, 2)
+	deps := &cacheDeps{c: conf, nid: x.NewUUID()}
+
+	newSession := func(identityID uuid.UUID, expiresIn time.Duration) *Session {
+		i := identity.NewIdentity("default")
+		i.ID = identityID
+		i.VerifiableAddresses = []identity.VerifiableAddress{{Value: "foo@ory.sh"}}
+		i.Traits = identity.Traits(`{"email":"foo@ory.sh"}`)
+		i.Credentials = map[identity.CredentialsType]identity.Credentials{
+			identity.CredentialsTypePassword: {Type: identity.CredentialsTypePassword, Identifiers: []string{"foo@ory.sh"}, Config: []byte(`{"hashed_password":"foo"}`)},
+		}
+		return &Session{
+			ID:         x.NewUUID(),
+			Active:     true,
+			ExpiresAt:  time.Now().Add(expiresIn),
+			IdentityID: identityID,
+			Identity:   i,
+			AMR:        AuthenticationMethods{{Method: identity.CredentialsTypePassword}},
+		}
+	}
+
+	newCache := func(t *testing.T, deps *cacheDeps, bus CacheInvalidationBus) *Cache {
+		c, err := NewCache(ctx, deps, bus)
+		require.NoError(t, err)
+		return c
+	}
+
+	t.Run("case=returns copies of cached sessions", func(t *testing.T) {
+		c := newCache(t, deps, NewNoopCacheInvalidationBus())
+		s := newSession(x.NewUUID(), time.Hour)
+
+		_, ok := c.Get(ctx, "token")
+		assert.False(t, ok)
+
+		// Byte slices are changed in place, so that shallow copies would share the change.
+		change := func(s *Session) {
+			s.Identity.VerifiableAddresses[0].Value = "changed@ory.sh"
+			copy(s.Identity.Traits, `{"email":"bar`)
+			copy(s.Identity.Credentials[identity.CredentialsTypePassword].Config, `{"hashed_password":"bar`)
+			s.Identity.Credentials[identity.CredentialsTypePassword].Identifiers[0] = "changed@ory.sh"
+		}
+		assertUnchanged := func(t *testing.T, s *Session) {
+			assert.Equal(t, "foo@ory.sh", s.Identity.VerifiableAddresses[0].Value)
+			assert.JSONEq(t, `{"email":"foo@ory.sh"}`, string(s.Identity.Traits))
+			assert.JSONEq(t, `{"hashed_password":"foo"}`, string(s.Identity.Credentials[identity.CredentialsTypePassword].Config))
+			assert.Equal(t, []string{"foo@ory.sh"}, s.Identity.Credentials[identity.CredentialsTypePassword].Identifiers)
+		}
+
+		c.Set(ctx, "token", s)
+		change(s)
+
+		actual, ok := c.Get(ctx, "token")
+		require.True(t, ok)
+		assert.Equal(t, s.ID, actual.ID)
+		assertUnchanged(t, actual)
+
+		change(actual)
+		actual.Active = false
+		again, ok := c.Get(ctx, "token")
+		require.True(t, ok)
+		assert.True(t, again.Active)
+		assertUnchanged(t, again)
+	})
+
+	t.Run("case=does not cache inactive or expired sessions", func(t *testing.T) {
+		c := newCache(t, deps, NewNoopCacheInvalidationBus())
+
+		inactive := newSession(x.NewUUID(), time.Hour)
+		inactive.Active = false
+		c.Set(ctx, "inactive", inactive)
+		_, ok := c.Get(ctx, "inactive")
+		assert.False(t, ok)
+
+		c.Set(ctx, "expired", newSession(x.NewUUID(), -time.Second))
+		_, ok = c.Get(ctx, "expired")
+		assert.False(t, ok)
+	})
+
+	t.Run("case=ttl is capped at the session expiry", func(t *testing.T) {
+		c := newCache(t, deps, NewNoopCacheInvalidationBus())
+		c.Set(ctx, "token", newSession(x.NewUUID(), 50*time.Millisecond))
+
+		_, ok := c.Get(ctx, "token")
+		require.True(t, ok)
+
+		time.Sleep(100 * time.Millisecond)
+		_, ok = c.Get(ctx, "token")
+		assert.False(t, ok)
+	})
+
+	t.Run("case=evicts least recently used sessions", func(t *testing.T) {
+		c := newCache(t, deps, NewNoopCacheInvalidationBus())
+		first, second, third := newSession(x.NewUUID(), time.Hour), newSession(x.NewUUID(), time.Hour), newSession(x.NewUUID(), time.Hour)
+		c.Set(ctx, "first", first)
+		c.Set(ctx, "second", second)
+		_, _ = c.Get(ctx, "first")
+		c.Set(ctx, "third", third)
+
+		_, ok := c.Get(ctx, "second")
+		assert.False(t, ok)
+		_, ok = c.Get(ctx, "first")
+		assert.True(t, ok)
+		assert.NotContains(t, c.bySession, second.ID)
+		assert.NotContains(t, c.byIdentity, second.IdentityID)
+	})
+
+	t.Run("case=keys are scoped to the network", func(t *testing.T) {
+		c := newCache(t, deps, NewNoopCacheInvalidationBus())
+		c.Set(ctx, "token", newSession(x.NewUUID(), time.Hour))
+
+		other := &Cache{d: deps.withNetwork(x.NewUUID()), entries: c.entries}
+		assert.NotEqual(t, c.key(ctx, "token"), other.key(ctx, "token"))
+	})
+
+	t.Run("case=invalidates", func(t *testing.T) {
+		bus := new(memoryBus)
+		local, remote := newCache(t, deps, bus), newCache(t, deps, bus)
+
+		identityID := x.NewUUID()
+		first, second, other := newSession(identityID, time.Hour), newSession(identityID, time.Hour), newSession(x.NewUUID(), time.Hour)
+		set := func() {
+			for _, c := range []*Cache{local, remote} {
+				c.Set(ctx, "first", first)
+				c.Set(ctx, "second", second)
+			}
+		}
+		cached := func(c *Cache, token string) bool {
+			_, ok := c.Get(ctx, token)
+			return ok
+		}
+
+		for _, tc := range []struct {
+			name       string
+			invalidate func()
+			first      bool
+			second     bool
+		}{
+			{name: "token", invalidate: func() { local.InvalidateToken(ctx, "first") }, second: true},
+			{name: "session", invalidate: func() { local.InvalidateSession(ctx, second.ID) }, first: true},
+			{name: "identity", invalidate: func() { local.InvalidateIdentity(ctx, identityID) }},
+			{name: "other identity", invalidate: func() { local.InvalidateIdentity(ctx, other.IdentityID) }, first: true, second: true},
+		} {
+			t.Run("by="+tc.name, func(t *testing.T) {
+				set()
+				tc.invalidate()
+				for name, c := range map[string]*Cache{"local": local, "remote": remote} {
+					assert.Equal(t, tc.first, cached(c, "first"), name)
+					assert.Equal(t, tc.second, cached(c, "second"), name)
+				}
+			})
+		}
+
+		t.Run("by=all", func(t *testing.T) {
+			set()
+			remote.apply(&CacheInvalidation{All: true})
+			assert.False(t, cached(remote, "first"))
+			assert.True(t, cached(local, "first"))
+		})
+	})
+
+	t.Run("case=notifies listeners of local and remote invalidations", func(t *testing.T) {
+		bus := new(memoryBus)
+		local, remote := newCache(t, deps, bus), newCache(t, deps, bus)
+
+		// The bus also delivers invalidations to the instance which published them, so listeners may receive
+		// them twice.
+		received := map[CacheInvalidation]bool{}
+		remote.OnInvalidate(func(inv *CacheInvalidation) { received[*inv] = true })
+
+		sessionID := x.NewUUID()
+		local.InvalidateSession(ctx, sessionID)
+		remote.InvalidateToken(ctx, "token")
+		assert.Equal(t, map[CacheInvalidation]bool{{SessionID: sessionID}: true, {Key: remote.key(ctx, "token")}: true}, received)
+	})
+
+	t.Run("case=publishes invalidations if only introspections are cached", func(t *testing.T) {
+		introspection := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+		introspection.MustSet(ctx, config.ViperKeySecretsDefault, []string{"a-very-secure-session-secret-value"})
+		introspection.MustSet(ctx, config.ViperKeySessionIntrospectionCacheEnabled, true)
+		bus := new(memoryBus)
+		local, remote := newCache(t, deps.withConfig(introspection), bus), newCache(t, deps.withConfig(introspection), bus)
+
+		var received int
+		remote.OnInvalidate(func(*CacheInvalidation) { received++ })
+		local.InvalidateIdentity(ctx, x.NewUUID())
+		assert.Equal(t, 1, received)
+	})
+
+	t.Run("case=disabled", func(t *testing.T) {
+		disabled := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+		disabled.MustSet(ctx, config.ViperKeySecretsDefault, []string{"a-very-secure-session-secret-value"})
+		bus := new(memoryBus)
+		c := newCache(t, deps.withConfig(disabled), bus)
+
+		c.Set(ctx, "token", newSession(x.NewUUID(), time.Hour))
+		_, ok := c.Get(ctx, "token")
+		assert.False(t, ok)
+		assert.Zero(t, c.entries.Len())
+	})
+}
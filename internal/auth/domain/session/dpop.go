@@ -0,0 +1,292 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/sqlxx"
+	"github.com/ory/x/urlx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/text"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+// dpopSigningAlgorithms are the asymmetric algorithms which DPoP proofs may be signed with.
+var dpopSigningAlgorithms = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// ErrInvalidDPoPProof is returned when a request uses a session which is bound to a DPoP key but does not have a
+// valid DPoP proof for the key. It wraps ErrNoActiveSessionFound, because such requests are not authenticated.
+type ErrInvalidDPoPProof struct {
+	*herodot.DefaultError `json:"error"`
+}
+
+func (e *ErrInvalidDPoPProof) EnhanceJSONError() interface{} {
+	return e
+}
+
+// NewErrDPoPProofMissing creates a new ErrInvalidDPoPProof for requests without a DPoP proof.
+func NewErrDPoPProofMissing() *ErrInvalidDPoPProof {
+	return &ErrInvalidDPoPProof{
+		DefaultError: herodot.ErrUnauthorized.
+			WithID(text.ErrIDDPoPProofRequired).
+			WithError("request does not have a DPoP proof").
+			WithReason("The session is bound to a DPoP key, but the request does not have a DPoP proof in the DPoP header.").
+			WithWrap(NewErrNoActiveSessionFound()),
+	}
+}
+
+// NewErrInvalidDPoPProof creates a new ErrInvalidDPoPProof for requests with an invalid DPoP proof.
+func NewErrInvalidDPoPProof(reason string) *ErrInvalidDPoPProof {
+	return &ErrInvalidDPoPProof{
+		DefaultError: herodot.ErrUnauthorized.
+			WithID(text.ErrIDDPoPProofInvalid).
+			WithError("invalid DPoP proof").
+			WithReason(reason).
+			WithWrap(NewErrNoActiveSessionFound()),
+	}
+}
+
+type (
+	dpopVerifierDependencies interface {
+		config.Provider
+		x.TracingProvider
+		PersistenceProvider
+	}
+	DPoPVerifierProvider interface {
+		SessionDPoPVerifier() *DPoPVerifier
+	}
+
+	// DPoPVerifier binds sessions to public keys of clients and verifies the DPoP proofs (RFC 9449) of requests
+	// which use bound sessions.
+	//
+	// The IDs of verified proofs are stored in the database until the proofs expire, so that a proof can not be
+	// replayed against any instance.
+	DPoPVerifier struct {
+		r       dpopVerifierDependencies
+		nowFunc func() time.Time
+	}
+
+	// dpopVerifiedProofs holds the DPoP proofs which were verified while a request is handled. A request may be
+	// authenticated more than once, and its proof is only a replay if another request used it.
+	dpopVerifiedProofs struct {
+		sync.Mutex
+		proofs map[string]bool
+	}
+
+	dpopVerifiedProofsContextKey struct{}
+
+	dpopClaims struct {
+		jwt.RegisteredClaims
+		Method          string `json:"htm"`
+		URL             string `json:"htu"`
+		AccessTokenHash string `json:"ath"`
+	}
+)
+
+func NewDPoPVerifier(r dpopVerifierDependencies) *DPoPVerifier {
+	return &DPoPVerifier{r: r, nowFunc: time.Now}
+}
+
+// DPoPContextMiddleware records the DPoP proofs which are verified while the request is handled in its context.
+// Without it, a request which is authenticated more than once is rejected as a replay of its own proof.
+func DPoPContextMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	next(w, r.WithContext(context.WithValue(r.Context(), dpopVerifiedProofsContextKey{}, &dpopVerifiedProofs{proofs: map[string]bool{}})))
+}
+
+// Bind binds the session to the key of the request's DPoP proof if the configuration of the flow type asks for
+// it. Sessions which are already bound keep their key.
+func (v *DPoPVerifier) Bind(r *http.Request, s *Session, flowType string) (err error) {
+	ctx, span := v.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.DPoPVerifier.Bind")
+	defer otelx.End(span, &err)
+
+	if s.DPoPJKT != "" {
+		return nil
+	}
+
+	switch v.r.Config().SessionDPoPMode(ctx, flowType) {
+	case config.DPoPModeRequired:
+	case config.DPoPModeOptional:
+		if len(r.Header.Values("DPoP")) == 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	jkt, err := v.verify(ctx, r, "")
+	if err != nil {
+		return err
+	}
+	s.DPoPJKT = sqlxx.NullString(jkt)
+	return nil
+}
+
+// Verify returns an error unless the request has a valid DPoP proof for the key the session is bound to. If the
+// session token was sent in a header, the proof must also be bound to the token. Sessions which are not bound do
+// not need a proof.
+func (v *DPoPVerifier) Verify(r *http.Request, s *Session, token string) (err error) {
+	if s.DPoPJKT == "" {
+		return nil
+	}
+
+	ctx, span := v.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.DPoPVerifier.Verify")
+	defer otelx.End(span, &err)
+
+	// Cookies are sent by the browser, which can not bind them to a proof.
+	if bearer, _ := bearerTokenFromRequest(r); r.Header.Get("X-Session-Token") != token && bearer != token {
+		token = ""
+	}
+
+	jkt, err := v.verify(ctx, r, token)
+	if err != nil {
+		return err
+	}
+	if jkt != string(s.DPoPJKT) {
+		return errors.WithStack(NewErrInvalidDPoPProof("The DPoP proof was not signed with the key the session is bound to."))
+	}
+	return nil
+}
+
+// verify validates the request's DPoP proof and returns the thumbprint of its key.
+func (v *DPoPVerifier) verify(ctx context.Context, r *http.Request, token string) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) == 0 {
+		return "", errors.WithStack(NewErrDPoPProofMissing())
+	} else if len(proofs) > 1 {
+		return "", errors.WithStack(NewErrInvalidDPoPProof("The request must not have more than one DPoP header."))
+	}
+
+	var key jwk.Key
+	var claims dpopClaims
+	if _, err := jwt.ParseWithClaims(proofs[0], &claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New(`the "typ" header must be "dpop+jwt"`)
+		}
+
+		raw, err := json.Marshal(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if key, err = jwk.ParseKey(raw); err != nil {
+			return nil, errors.New(`the "jwk" header must be a JSON Web Key`)
+		}
+
+		var public interface{}
+		if err := key.Raw(&public); err != nil {
+			return nil, err
+		}
+		switch public.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			return public, nil
+		default:
+			return nil, errors.New(`the "jwk" header must be an asymmetric public key`)
+		}
+	},
+		jwt.WithValidMethods(dpopSigningAlgorithms),
+		jwt.WithTimeFunc(v.nowFunc),
+	); err != nil {
+		return "", errors.WithStack(NewErrInvalidDPoPProof("The DPoP proof could not be verified: " + err.Error()))
+	}
+
+	if claims.Method != r.Method {
+		return "", errors.WithStack(NewErrInvalidDPoPProof(`The "htm" claim of the DPoP proof does not match the HTTP method of the request.`))
+	}
+
+	target := urlx.AppendPaths(v.r.Config().SelfPublicURL(ctx), r.URL.Path)
+	if u, err := url.Parse(claims.URL); err != nil || dpopTargetURI(u) != dpopTargetURI(target) {
+		return "", errors.WithStack(NewErrInvalidDPoPProof(`The "htu" claim of the DPoP proof does not match the URL of the request.`))
+	}
+
+	maxAge := v.r.Config().SessionDPoPProofMaxAge(ctx)
+	now := v.nowFunc()
+	if claims.IssuedAt == nil {
+		return "", errors.WithStack(NewErrInvalidDPoPProof(`The DPoP proof does not have an "iat" claim.`))
+	} else if claims.IssuedAt.Before(now.Add(-maxAge)) || claims.IssuedAt.After(now.Add(maxAge)) {
+		return "", errors.WithStack(NewErrInvalidDPoPProof("The DPoP proof was issued too long ago or in the future."))
+	}
+
+	if claims.ID == "" {
+		return "", errors.WithStack(NewErrInvalidDPoPProof(`The DPoP proof does not have a "jti" claim.`))
+	}
+
+	if token != "" {
+		hash := sha256.Sum256([]byte(token))
+		if claims.AccessTokenHash != base64.RawURLEncoding.EncodeToString(hash[:]) {
+			return "", errors.WithStack(NewErrInvalidDPoPProof(`The "ath" claim of the DPoP proof does not match the session token.`))
+		}
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", errors.WithStack(NewErrInvalidDPoPProof("The thumbprint of the DPoP key could not be computed."))
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	if err := v.use(ctx, proofs[0], jkt+":"+claims.ID, claims.IssuedAt.Add(maxAge)); err != nil {
+		return "", err
+	}
+	return jkt, nil
+}
+
+// use stores the proof ID until the proof expires and returns an error if another request used the proof before.
+func (v *DPoPVerifier) use(ctx context.Context, proof, id string, expiresAt time.Time) error {
+	verified, _ := ctx.Value(dpopVerifiedProofsContextKey{}).(*dpopVerifiedProofs)
+	if verified != nil {
+		verified.Lock()
+		defer verified.Unlock()
+		if verified.proofs[proof] {
+			return nil
+		}
+	}
+
+	// Proof IDs are chosen by the client, so only their hash is stored.
+	hash := sha256.Sum256([]byte(id))
+	if err := v.r.SessionPersister().UseDPoPProof(ctx, hex.EncodeToString(hash[:]), expiresAt); errors.Is(err, sqlcon.ErrUniqueViolation) {
+		return errors.WithStack(NewErrInvalidDPoPProof("The DPoP proof was already used."))
+	} else if err != nil {
+		return err
+	}
+
+	if verified != nil {
+		verified.proofs[proof] = true
+	}
+	return nil
+}
+
+// dpopTargetURI returns the URL without query and fragment, with the scheme and host in lower case, and without
+// the default port of the scheme.
+func dpopTargetURI(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); scheme == "https" && port == "443" || scheme == "http" && port == "80" {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
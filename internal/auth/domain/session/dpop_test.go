@@ -0,0 +1,301 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ory/x/configx"
+	"github.com/ory/x/logrusx"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+	"github.com/ory/x/sqlxx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+type dpopVerifierDeps struct {
+	c *config.Config
+	p *dpopProofPersister
+}
+
+func (d *dpopVerifierDeps) Config() *config.Config      { return d.c }
+func (d *dpopVerifierDeps) SessionPersister() Persister { return d.p }
+func (d *dpopVerifierDeps) Tracer(context.Context) *otelx.Tracer {
+	return otelx.NewNoop(logrusx.New("", ""), new(otelx.Config))
+}
+
+type dpopProofPersister struct {
+	Persister
+
+	sync.Mutex
+	ids map[string]bool
+}
+
+func (p *dpopProofPersister) UseDPoPProof(_ context.Context, id string, _ time.Time) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.ids[id] {
+		return errors.WithStack(sqlcon.ErrUniqueViolation)
+	}
+	p.ids[id] = true
+	return nil
+}
+
+func TestDPoPVerifier(t *testing.T) {
+	ctx := context.Background()
+	conf := config.MustNew(t, logrusx.New("", ""), os.Stderr, configx.SkipValidation())
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, "https://auth.example.com/")
+	p := &dpopProofPersister{ids: map[string]bool{}}
+	v := NewDPoPVerifier(&dpopVerifierDeps{c: conf, p: p})
+
+	newKey := func(t *testing.T) (*ecdsa.PrivateKey, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		public, err := jwk.FromRaw(&key.PublicKey)
+		require.NoError(t, err)
+		thumbprint, err := public.Thumbprint(crypto.SHA256)
+		require.NoError(t, err)
+		return key, base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	newProof := func(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims, modify ...func(*jwt.Token)) string {
+		public, err := jwk.FromRaw(&key.PublicKey)
+		require.NoError(t, err)
+		raw, err := json.Marshal(public)
+		require.NoError(t, err)
+		var header map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &header))
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"jti": x.NewUUID().String(),
+			"htm": "GET",
+			"htu": "https://auth.example.com/sessions/whoami",
+			"iat": time.Now().Unix(),
+		})
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = header
+		for k, v := range claims {
+			token.Claims.(jwt.MapClaims)[k] = v
+		}
+		for _, m := range modify {
+			m(token)
+		}
+
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	newRequest := func(method, path string, header http.Header) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		return r
+	}
+
+	handle := func(r *http.Request) (handled *http.Request) {
+		DPoPContextMiddleware(httptest.NewRecorder(), r, func(_ http.ResponseWriter, r *http.Request) { handled = r })
+		return handled
+	}
+
+	ath := func(token string) string {
+		hash := sha256.Sum256([]byte(token))
+		return base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+
+	t.Run("method=Bind", func(t *testing.T) {
+		key, jkt := newKey(t)
+
+		for _, tc := range []struct {
+			mode     string
+			proof    bool
+			expected string
+			err      bool
+		}{
+			{mode: config.DPoPModeOff, proof: true},
+			{mode: config.DPoPModeOptional},
+			{mode: config.DPoPModeOptional, proof: true, expected: jkt},
+			{mode: config.DPoPModeRequired, err: true},
+			{mode: config.DPoPModeRequired, proof: true, expected: jkt},
+		} {
+			t.Run("mode="+tc.mode, func(t *testing.T) {
+				conf.MustSet(ctx, config.ViperKeySessionDPoPFlows+".api", tc.mode)
+
+				r := newRequest("POST", "/self-service/login", nil)
+				if tc.proof {
+					r.Header.Set("DPoP", newProof(t, key, jwt.MapClaims{"htm": "POST", "htu": "https://auth.example.com/self-service/login"}))
+				}
+
+				var s Session
+				err := v.Bind(r, &s, "api")
+				if tc.err {
+					require.ErrorAs(t, err, new(*ErrInvalidDPoPProof))
+					require.ErrorAs(t, err, new(*ErrNoActiveSessionFound))
+					return
+				}
+				require.NoError(t, err)
+				assert.EqualValues(t, tc.expected, s.DPoPJKT)
+			})
+		}
+
+		t.Run("case=bound sessions keep their key", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionDPoPFlows+".api", config.DPoPModeRequired)
+			s := Session{DPoPJKT: "previous"}
+			require.NoError(t, v.Bind(newRequest("POST", "/self-service/login", nil), &s, "api"))
+			assert.EqualValues(t, "previous", s.DPoPJKT)
+		})
+	})
+
+	t.Run("method=Verify", func(t *testing.T) {
+		key, jkt := newKey(t)
+		s := &Session{Token: "ory_st_token"}
+
+		t.Run("case=unbound sessions do not need a proof", func(t *testing.T) {
+			require.NoError(t, v.Verify(newRequest("GET", "/sessions/whoami", nil), s, s.Token))
+		})
+
+		s.DPoPJKT = sqlxx.NullString(jkt)
+
+		t.Run("case=valid proof", func(t *testing.T) {
+			r := newRequest("GET", "/sessions/whoami?foo=bar", http.Header{
+				"X-Session-Token": {s.Token},
+				"Dpop":            {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token)})},
+			})
+			r = handle(r)
+			require.NoError(t, v.Verify(r, s, s.Token))
+			require.NoError(t, v.Verify(r.Clone(r.Context()), s, s.Token), "the proof may be verified again while the request is handled")
+
+			replayed := handle(newRequest("GET", "/sessions/whoami", r.Header.Clone()))
+			var dpopErr *ErrInvalidDPoPProof
+			require.ErrorAs(t, v.Verify(replayed, s, s.Token), &dpopErr)
+			assert.Contains(t, dpopErr.ReasonField, "already used")
+		})
+
+		t.Run("case=proofs can not be replayed against another instance", func(t *testing.T) {
+			r := newRequest("GET", "/sessions/whoami", http.Header{
+				"X-Session-Token": {s.Token},
+				"Dpop":            {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token)})},
+			})
+			require.NoError(t, v.Verify(handle(r), s, s.Token))
+
+			other := NewDPoPVerifier(&dpopVerifierDeps{c: conf, p: p})
+			var dpopErr *ErrInvalidDPoPProof
+			require.ErrorAs(t, other.Verify(handle(newRequest("GET", "/sessions/whoami", r.Header.Clone())), s, s.Token), &dpopErr)
+			assert.Contains(t, dpopErr.ReasonField, "already used")
+		})
+
+		t.Run("case=cookies do not need the token hash", func(t *testing.T) {
+			r := newRequest("GET", "/sessions/whoami", http.Header{"Dpop": {newProof(t, key, nil)}})
+			require.NoError(t, v.Verify(r, s, s.Token))
+		})
+
+		t.Run("case=authorization header", func(t *testing.T) {
+			r := newRequest("GET", "/sessions/whoami", http.Header{
+				"Authorization": {"DPoP " + s.Token},
+				"Dpop":          {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token)})},
+			})
+			require.NoError(t, v.Verify(r, s, s.Token))
+		})
+
+		otherKey, _ := newKey(t)
+		for _, tc := range []struct {
+			name   string
+			header http.Header
+			reason string
+		}{
+			{
+				name:   "missing proof",
+				header: http.Header{"X-Session-Token": {s.Token}},
+				reason: "does not have a DPoP proof",
+			},
+			{
+				name:   "multiple proofs",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {"a", "b"}},
+				reason: "more than one DPoP header",
+			},
+			{
+				name:   "malformed proof",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {"not-a-jwt"}},
+				reason: "could not be verified",
+			},
+			{
+				name: "wrong type",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token)}, func(t *jwt.Token) {
+					t.Header["typ"] = "JWT"
+				})}},
+				reason: "dpop+jwt",
+			},
+			{
+				name:   "wrong method",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token), "htm": "POST"})}},
+				reason: `"htm"`,
+			},
+			{
+				name:   "wrong url",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token), "htu": "https://evil.example.com/sessions/whoami"})}},
+				reason: `"htu"`,
+			},
+			{
+				name:   "stale proof",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, key, jwt.MapClaims{"ath": ath(s.Token), "iat": time.Now().Add(-time.Hour).Unix()})}},
+				reason: "too long ago",
+			},
+			{
+				name:   "missing token hash",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, key, nil)}},
+				reason: `"ath"`,
+			},
+			{
+				name:   "other key",
+				header: http.Header{"X-Session-Token": {s.Token}, "Dpop": {newProof(t, otherKey, jwt.MapClaims{"ath": ath(s.Token)})}},
+				reason: "not signed with the key the session is bound to",
+			},
+		} {
+			t.Run("case="+tc.name, func(t *testing.T) {
+				err := v.Verify(newRequest("GET", "/sessions/whoami", tc.header), s, s.Token)
+				var dpopErr *ErrInvalidDPoPProof
+				require.ErrorAs(t, err, &dpopErr)
+				assert.Contains(t, dpopErr.ReasonField, tc.reason)
+				require.ErrorAs(t, err, new(*ErrNoActiveSessionFound))
+			})
+		}
+	})
+
+	t.Run("func=dpopTargetURI", func(t *testing.T) {
+		for in, expected := range map[string]string{
+			"https://auth.example.com/sessions/whoami":             "https://auth.example.com/sessions/whoami",
+			"HTTPS://Auth.Example.com:443/sessions/whoami?foo=bar": "https://auth.example.com/sessions/whoami",
+			"http://auth.example.com:80/sessions/whoami#fragment":  "http://auth.example.com/sessions/whoami",
+			"https://auth.example.com:8443/sessions/whoami":        "https://auth.example.com:8443/sessions/whoami",
+			"https://auth.example.com":                             "https://auth.example.com/",
+		} {
+			u, err := url.Parse(in)
+			require.NoError(t, err)
+			assert.Equal(t, expected, dpopTargetURI(u), in)
+		}
+	})
+}
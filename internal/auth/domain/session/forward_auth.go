@@ -0,0 +1,156 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/herodot"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+// forwardedHeader returns the value of the X-Forwarded-* header which the trusted proxy set. If the header has
+// several values, the last one was appended by the proxy closest to Kratos, while earlier ones may have been sent
+// by the client. Headers of untrusted senders are ignored.
+func forwardedHeader(r *http.Request, trusted bool, name string) string {
+	if !trusted {
+		return ""
+	}
+
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	last := values[len(values)-1]
+	if i := strings.LastIndex(last, ","); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.TrimSpace(last)
+}
+
+// forwardedHost returns the upstream host without its port. Proxies which are not trusted, or which do not set
+// X-Forwarded-Host, are expected to pass the upstream host in the Host header.
+func forwardedHost(r *http.Request, trusted bool) string {
+	host := forwardedHeader(r, trusted, "X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// forwardedURL reconstructs the URL the trusted proxy was asked for, or returns an empty string if the proxy is
+// not trusted or did not pass enough information to do so.
+func forwardedURL(r *http.Request, trusted bool) string {
+	host := forwardedHeader(r, trusted, "X-Forwarded-Host")
+	uri := forwardedHeader(r, trusted, "X-Forwarded-Uri")
+	if host == "" || !strings.HasPrefix(uri, "/") {
+		return ""
+	}
+
+	scheme := "https"
+	if forwardedHeader(r, trusted, "X-Forwarded-Proto") == "http" {
+		scheme = "http"
+	}
+
+	u, err := url.Parse(scheme + "://" + host + uri)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// matchForwardAuthRule returns the first rule which matches the host, or nil.
+func matchForwardAuthRule(rules []config.ForwardAuthRule, host string) *config.ForwardAuthRule {
+	for k := range rules {
+		pattern := strings.ToLower(rules[k].Host)
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return &rules[k]
+			}
+		} else if pattern == host {
+			return &rules[k]
+		}
+	}
+	return nil
+}
+
+// satisfiesForwardAuthTraits returns true if the traits satisfy all conditions.
+func satisfiesForwardAuthTraits(traits identity.Traits, conditions []config.ForwardAuthTraitCondition) (bool, error) {
+	for _, condition := range conditions {
+		// Normalize the allowed values so that they compare equal to the values decoded by gjson.
+		raw, err := json.Marshal(condition.In)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		var allowed []interface{}
+		if err := json.Unmarshal(raw, &allowed); err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		value := gjson.GetBytes(traits, condition.Path)
+		if !value.Exists() {
+			return false, nil
+		}
+
+		values := []gjson.Result{value}
+		if value.IsArray() {
+			values = value.Array()
+		}
+
+		var found bool
+		for _, v := range values {
+			for _, a := range allowed {
+				if reflect.DeepEqual(v.Value(), a) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// forwardAuthHeaders reads the configured headers from the session. Headers whose path does not exist or is null
+// are set to an empty value rather than omitted, because proxies only replace the request headers which the
+// response contains, and would otherwise pass a header the client sent on to the upstream.
+func forwardAuthHeaders(s *Session, headers []config.ForwardAuthHeader) (http.Header, error) {
+	cp := *s
+	if cp.Identity != nil {
+		cp.Identity = cp.Identity.CopyWithoutCredentials()
+	}
+	raw, err := json.Marshal(&cp)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to encode session to JSON."))
+	}
+
+	result := http.Header{}
+	for _, header := range headers {
+		value := gjson.GetBytes(raw, header.Path)
+		switch {
+		case !value.Exists() || value.Type == gjson.Null:
+			result.Set(header.Name, "")
+		case value.IsObject() || value.IsArray():
+			result.Set(header.Name, value.Raw)
+		default:
+			result.Set(header.Name, value.String())
+		}
+	}
+	return result, nil
+}
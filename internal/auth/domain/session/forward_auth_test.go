@@ -0,0 +1,118 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+)
+
+func TestForwardedRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://kratos/sessions/forward-auth", nil)
+	assert.Equal(t, "kratos", forwardedHost(r, true))
+	assert.Empty(t, forwardedURL(r, true))
+
+	r.Header.Set("X-Forwarded-Host", "evil.example.org, App.example.org:8443")
+	r.Header.Set("X-Forwarded-Uri", "/path?query=1")
+	assert.Equal(t, "app.example.org", forwardedHost(r, true), "the value appended by the trusted proxy is used")
+	assert.Equal(t, "https://App.example.org:8443/path?query=1", forwardedURL(r, true))
+
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	assert.Equal(t, "http://App.example.org:8443/path?query=1", forwardedURL(r, true))
+
+	r.Header.Set("X-Forwarded-Uri", "//evil.example.org/")
+	assert.Equal(t, "http://App.example.org:8443//evil.example.org/", forwardedURL(r, true), "the URI must not change the host")
+
+	t.Run("case=multiple headers", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://kratos/sessions/forward-auth", nil)
+		r.Header.Add("X-Forwarded-Host", "evil.example.org")
+		r.Header.Add("X-Forwarded-Host", "app.example.org")
+		assert.Equal(t, "app.example.org", forwardedHost(r, true))
+	})
+
+	t.Run("case=untrusted proxy", func(t *testing.T) {
+		assert.Equal(t, "kratos", forwardedHost(r, false))
+		assert.Empty(t, forwardedURL(r, false))
+	})
+}
+
+func TestMatchForwardAuthRule(t *testing.T) {
+	rules := []config.ForwardAuthRule{
+		{Host: "admin.example.org"},
+		{Host: "*.example.org"},
+	}
+
+	for host, expected := range map[string]string{
+		"admin.example.org": "admin.example.org",
+		"app.example.org":   "*.example.org",
+		"a.b.example.org":   "*.example.org",
+		"example.org":       "",
+		"evilexample.org":   "",
+	} {
+		t.Run("host="+host, func(t *testing.T) {
+			rule := matchForwardAuthRule(rules, host)
+			if expected == "" {
+				assert.Nil(t, rule)
+				return
+			}
+			require.NotNil(t, rule)
+			assert.Equal(t, expected, rule.Host)
+		})
+	}
+}
+
+func TestSatisfiesForwardAuthTraits(t *testing.T) {
+	traits := identity.Traits(`{"department":"engineering","roles":["viewer","admin"],"level":3,"verified":true}`)
+
+	for _, tc := range []struct {
+		name       string
+		conditions []config.ForwardAuthTraitCondition
+		expected   bool
+	}{
+		{name: "no conditions", expected: true},
+		{name: "string", conditions: []config.ForwardAuthTraitCondition{{Path: "department", In: []interface{}{"sales", "engineering"}}}, expected: true},
+		{name: "array element", conditions: []config.ForwardAuthTraitCondition{{Path: "roles", In: []interface{}{"admin"}}}, expected: true},
+		{name: "number", conditions: []config.ForwardAuthTraitCondition{{Path: "level", In: []interface{}{3}}}, expected: true},
+		{name: "boolean", conditions: []config.ForwardAuthTraitCondition{{Path: "verified", In: []interface{}{true}}}, expected: true},
+		{name: "not allowed", conditions: []config.ForwardAuthTraitCondition{{Path: "department", In: []interface{}{"sales"}}}},
+		{name: "missing trait", conditions: []config.ForwardAuthTraitCondition{{Path: "team", In: []interface{}{"a"}}}},
+		{name: "all conditions must match", conditions: []config.ForwardAuthTraitCondition{
+			{Path: "department", In: []interface{}{"engineering"}},
+			{Path: "roles", In: []interface{}{"owner"}},
+		}},
+	} {
+		t.Run("case="+tc.name, func(t *testing.T) {
+			actual, err := satisfiesForwardAuthTraits(traits, tc.conditions)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestForwardAuthHeaders(t *testing.T) {
+	s := &Session{
+		AuthenticatorAssuranceLevel: identity.AuthenticatorAssuranceLevel2,
+		Identity: &identity.Identity{
+			Traits: identity.Traits(`{"email":"foo@ory.sh","roles":["admin"]}`),
+		},
+	}
+
+	headers, err := forwardAuthHeaders(s, []config.ForwardAuthHeader{
+		{Name: "X-User-Email", Path: "identity.traits.email"},
+		{Name: "X-User-Roles", Path: "identity.traits.roles"},
+		{Name: "X-Session-AAL", Path: "authenticator_assurance_level"},
+		{Name: "X-Missing", Path: "identity.traits.missing"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "foo@ory.sh", headers.Get("X-User-Email"))
+	assert.Equal(t, `["admin"]`, headers.Get("X-User-Roles"))
+	assert.Equal(t, "aal2", headers.Get("X-Session-AAL"))
+	assert.Equal(t, []string{""}, headers.Values("X-Missing"))
+}
@@ -4,27 +4,34 @@
 package session
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ory/x/pagination/migrationpagination"
+	"my.com/secrets/internal/auth/domain/selfservice/flow"
 	"my.com/secrets/internal/auth/domain/selfservice/sessiontokenexchange"
 
 	"github.com/ory/x/pagination/keysetpagination"
 
 	"github.com/ory/x/pointerx"
+	"github.com/ory/x/urlx"
 
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
 
 	"github.com/ory/x/decoderx"
+	"github.com/ory/x/jsonx"
 
 	"github.com/ory/herodot"
 
 	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
 	"my.com/secrets/internal/auth/domain/x"
 )
 
@@ -39,6 +46,9 @@ type (
 		config.Provider
 		sessiontokenexchange.PersistenceProvider
 		TokenizerProvider
+		IntrospectorProvider
+		RefreshTokenManagerProvider
+		DPoPVerifierProvider
 	}
 	HandlerProvider interface {
 		SessionHandler() *Handler
@@ -62,6 +72,8 @@ const (
 	RouteCollection                  = "/sessions"
 	RouteExchangeCodeForSessionToken = RouteCollection + "/token-exchange" // #nosec G101
 	RouteWhoami                      = RouteCollection + "/whoami"
+	RouteForwardAuth                 = RouteCollection + "/forward-auth"
+	RouteRefresh                     = RouteCollection + "/refresh"
 	RouteSession                     = RouteCollection + "/:id"
 )
 
@@ -69,6 +81,7 @@ const (
 	AdminRouteIdentity           = "/identities"
 	AdminRouteIdentitiesSessions = AdminRouteIdentity + "/:id/sessions"
 	AdminRouteSessionExtendId    = RouteSession + "/extend"
+	AdminRouteIntrospect         = "/introspect"
 )
 
 func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
@@ -80,6 +93,8 @@ func (h *Handler) RegisterAdminRoutes(admin *x.RouterAdmin) {
 	admin.DELETE(AdminRouteIdentitiesSessions, h.deleteIdentitySessions)
 	admin.PATCH(AdminRouteSessionExtendId, h.adminSessionExtend)
 
+	admin.POST(AdminRouteIntrospect, h.introspect)
+
 	admin.DELETE(RouteCollection, x.RedirectToPublicRoute(h.r))
 }
 
@@ -87,6 +102,8 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 	// We need to completely ignore the whoami/logout path so that we do not accidentally set
 	// some cookie.
 	h.r.CSRFHandler().IgnorePath(RouteWhoami)
+	h.r.CSRFHandler().IgnorePath(RouteForwardAuth)
+	h.r.CSRFHandler().IgnorePath(RouteRefresh)
 	h.r.CSRFHandler().IgnorePath(RouteCollection)
 	h.r.CSRFHandler().IgnoreGlob(RouteCollection + "/*")
 	h.r.CSRFHandler().IgnoreGlob(RouteCollection + "/*/extend")
@@ -94,6 +111,7 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 
 	for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodConnect, http.MethodOptions, http.MethodTrace} {
 		public.Handle(m, RouteWhoami, h.whoami)
+		public.Handle(m, RouteForwardAuth, h.forwardAuth)
 	}
 
 	public.DELETE(RouteCollection, h.deleteMySessions)
@@ -101,6 +119,7 @@ func (h *Handler) RegisterPublicRoutes(public *x.RouterPublic) {
 	public.GET(RouteCollection, h.listMySessions)
 
 	public.GET(RouteExchangeCodeForSessionToken, h.exchangeCode)
+	public.POST(RouteRefresh, h.refresh)
 
 	public.DELETE(AdminRouteIdentitiesSessions, x.RedirectToAdminRoute(h.r))
 }
@@ -197,6 +216,7 @@ type toSession struct {
 //
 // - `session_inactive`: No active session was found in the request (e.g. no Ory Session Cookie / Ory Session Token).
 // - `session_aal2_required`: An active session was found but it does not fulfil the Authenticator Assurance Level, implying that the session must (e.g.) authenticate the second factor.
+// - `session_password_change_required`: An active session was found but it was authenticated with a password which expired or which must be changed. The password must be changed using the settings flow first.
 //
 //	Produces:
 //	- application/json
@@ -221,6 +241,11 @@ func (h *Handler) whoami(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 		}
 
 		h.r.Audit().WithRequest(r).WithError(err).Info("No valid session found.")
+		if dpopErr := new(ErrInvalidDPoPProof); errors.As(err, &dpopErr) {
+			w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof", algs="`+strings.Join(dpopSigningAlgorithms, " ")+`"`)
+			h.r.Writer().WriteError(w, r, err)
+			return
+		}
 		h.r.Writer().WriteError(w, r, ErrNoSessionFound.WithWrap(err))
 		return
 	}
@@ -239,6 +264,12 @@ func (h *Handler) whoami(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 		return
 	}
 
+	if err := h.r.SessionManager().DoesSessionRequirePasswordChange(r, s); err != nil {
+		h.r.Audit().WithRequest(r).WithError(err).Info("Session was found but its password must be changed first.")
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
 	// s.Devices = nil
 	s.Identity = s.Identity.CopyWithoutCredentials()
 
@@ -267,6 +298,178 @@ func (h *Handler) whoami(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	h.r.Writer().Write(w, r, s)
 }
 
+// Forward Authentication Parameters
+//
+// swagger:parameters forwardAuthSession
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type forwardAuthSession struct {
+	// The host of the upstream application, which is used to find the rule which applies. The X-Forwarded-*
+	// headers are only read from trusted proxies, taking the value appended last.
+	//
+	// in: header
+	ForwardedHost string `json:"X-Forwarded-Host"`
+
+	// The scheme of the original request. Defaults to `https`.
+	//
+	// in: header
+	ForwardedProto string `json:"X-Forwarded-Proto"`
+
+	// The path and query of the original request, which is passed as `return_to` to the login flow.
+	//
+	// in: header
+	ForwardedURI string `json:"X-Forwarded-Uri"`
+
+	// Overrides `session.forward_auth.unauthorized_response`. Either `unauthorized` or `redirect`.
+	//
+	// in: query
+	UnauthorizedResponse string `json:"unauthorized_response"`
+
+	// The session token, for clients which do not use cookies.
+	//
+	// in: header
+	SessionToken string `json:"X-Session-Token"`
+
+	// The HTTP Cookie header of the original request.
+	//
+	// in: header
+	Cookie string `json:"Cookie"`
+}
+
+// swagger:route GET /sessions/forward-auth frontend forwardAuthSession
+//
+// # Authenticate a Request on Behalf of a Reverse Proxy
+//
+// Implements the forward authentication protocol of reverse proxies such as nginx (`auth_request`) and
+// Traefik (`ForwardAuth`). The session is read from the cookie or session token of the original request.
+//
+// If the session is valid and satisfies the rule configured for the upstream host, the endpoint responds
+// with 200 and the configured headers, for example `X-User-Id`, `X-User-Email`, `X-Session-AAL`, and a
+// tokenized session. If the session is missing, or must be upgraded to a higher AAL, the endpoint responds
+// with 401 and the login URL in the `Location` header, or redirects to the login URL, depending on
+// `session.forward_auth.unauthorized_response`. The original URL is passed to the login flow as `return_to`.
+// If no rule matches the upstream host and `session.forward_auth.unmatched_hosts` is `deny`, or the identity's
+// traits do not satisfy the rule, the endpoint responds with 403.
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Responses:
+//	  200: emptyResponse
+//	  303: emptyResponse
+//	  401: errorGeneric
+//	  403: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) forwardAuth(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx, span := h.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.Handler.forwardAuth")
+	defer span.End()
+
+	c := h.r.Config()
+	trusted := x.IsFromTrustedProxy(r, c.SessionDeviceTrustedProxies(ctx))
+	returnTo := forwardedURL(r, trusted)
+	host := forwardedHost(r, trusted)
+
+	rule := matchForwardAuthRule(c.SessionForwardAuthRules(ctx), host)
+	if rule == nil && c.SessionForwardAuthUnmatchedHosts(ctx) != config.ForwardAuthUnmatchedHostsAllow {
+		h.r.Audit().WithRequest(r).WithField("host", host).Info("No forward authentication rule matches the upstream host.")
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrForbidden.WithReason("Forward authentication is not configured for this host.")))
+		return
+	}
+
+	s, err := h.r.SessionManager().FetchFromRequest(ctx, r)
+	if err != nil {
+		h.r.Audit().WithRequest(r).WithError(err).Info("No valid session found for forward authentication.")
+		loginURL := urlx.AppendPaths(c.SelfPublicURL(ctx), "/self-service/login/browser")
+		if returnTo != "" {
+			loginURL = urlx.CopyWithQuery(loginURL, url.Values{"return_to": {returnTo}})
+		}
+		h.forwardAuthUnauthorized(w, r, loginURL.String(), ErrNoSessionFound.WithWrap(err))
+		return
+	}
+
+	requiredAAL := c.SessionWhoAmIAAL(ctx)
+	if rule != nil && rule.RequiredAAL != "" {
+		requiredAAL = rule.RequiredAAL
+	}
+
+	var aalErr *ErrAALNotSatisfied
+	if err := h.r.SessionManager().DoesSessionSatisfy(r, s, requiredAAL, WithRequestURL(returnTo), UpsertAAL); errors.As(err, &aalErr) {
+		h.r.Audit().WithRequest(r).WithError(err).Info("Session was found but AAL is not satisfied for forward authentication.")
+		h.forwardAuthUnauthorized(w, r, aalErr.RedirectTo, herodot.ErrUnauthorized.WithWrap(err).WithReason(aalErr.Reason()))
+		return
+	} else if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	var passwordErr *ErrPasswordChangeRequired
+	if err := h.r.SessionManager().DoesSessionRequirePasswordChange(r, s, WithRequestURL(returnTo)); errors.As(err, &passwordErr) {
+		h.r.Audit().WithRequest(r).WithError(err).Info("Session was found but its password must be changed first.")
+		h.forwardAuthUnauthorized(w, r, passwordErr.RedirectTo, herodot.ErrUnauthorized.WithWrap(err).WithReason(passwordErr.Reason()))
+		return
+	} else if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	if rule != nil {
+		var traits identity.Traits
+		if s.Identity != nil {
+			traits = s.Identity.Traits
+		}
+		if ok, err := satisfiesForwardAuthTraits(traits, rule.Traits); err != nil {
+			h.r.Writer().WriteError(w, r, err)
+			return
+		} else if !ok {
+			h.r.Audit().WithRequest(r).WithField("identity_id", s.IdentityID).WithField("host", rule.Host).Info("Identity does not satisfy the trait conditions for forward authentication.")
+			h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrForbidden.WithReason("The identity is not allowed to access this application.")))
+			return
+		}
+	}
+
+	headers, err := forwardAuthHeaders(s, c.SessionForwardAuthHeaders(ctx))
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	if template := c.SessionForwardAuthTokenizerTemplate(ctx); template != "" {
+		if err := h.r.SessionTokenizer().TokenizeSession(ctx, template, s); err != nil {
+			h.r.Writer().WriteError(w, r, err)
+			return
+		}
+
+		name, token := c.SessionForwardAuthTokenizerHeader(ctx), s.Tokenized
+		if strings.EqualFold(name, "Authorization") {
+			token = "Bearer " + token
+		}
+		headers.Set(name, token)
+	}
+
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) forwardAuthUnauthorized(w http.ResponseWriter, r *http.Request, redirectTo string, err error) {
+	response := r.URL.Query().Get("unauthorized_response")
+	if response == "" {
+		response = h.r.Config().SessionForwardAuthUnauthorizedResponse(r.Context())
+	}
+
+	if response == config.ForwardAuthResponseRedirect {
+		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Location", redirectTo)
+	h.r.Writer().WriteError(w, r, err)
+}
+
 // Delete Identity Session Parameters
 //
 // swagger:parameters deleteIdentitySessions
@@ -976,6 +1179,17 @@ type CodeExchangeResponse struct {
 	// The session token is only issued for API flows, not for Browser flows!
 	Token string `json:"session_token,omitempty"`
 
+	// The Refresh Token
+	//
+	// Exchanges the session token for a new one at `POST /sessions/refresh`. It is only issued for API flows
+	// if refresh tokens are enabled, and it can only be used once.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// The Session Token Expiry
+	//
+	// When the session token expires. It is only set if a refresh token was issued.
+	TokenExpiresAt *time.Time `json:"session_token_expires_at,omitempty"`
+
 	// The Session
 	//
 	// The session contains information about the user, the session device, and so on.
@@ -989,6 +1203,9 @@ type CodeExchangeResponse struct {
 //
 // # Exchange Session Token
 //
+// Exchanges the codes of a native flow which finished in the browser for the session token. If the flow type
+// binds sessions to DPoP keys, the session is bound to the key of this request's DPoP proof.
+//
 //	Produces:
 //	- application/json
 //
@@ -996,6 +1213,7 @@ type CodeExchangeResponse struct {
 //
 //	Responses:
 //	  200: successfulNativeLogin
+//	  401: errorGeneric
 //	  403: errorGeneric
 //	  404: errorGeneric
 //	  410: errorGeneric
@@ -1024,8 +1242,250 @@ func (h *Handler) exchangeCode(w http.ResponseWriter, r *http.Request, _ httprou
 		return
 	}
 
+	// The flow finished in the browser, which can not send DPoP proofs, so the session is bound now.
+	bound := sess.DPoPJKT
+	if err := h.r.SessionDPoPVerifier().Bind(r, sess, string(flow.TypeAPI)); err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+	if sess.DPoPJKT != bound {
+		if err := h.r.SessionPersister().UpsertSession(ctx, sess); err != nil {
+			h.r.Writer().WriteError(w, r, err)
+			return
+		}
+	}
+
+	refreshToken, err := h.r.SessionRefreshTokenManager().Issue(ctx, sess)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
 	h.r.Writer().Write(w, r, &CodeExchangeResponse{
-		Token:   sess.Token,
-		Session: sess,
+		Token:          sess.Token,
+		RefreshToken:   refreshToken,
+		TokenExpiresAt: sess.TokenExpiresAt,
+		Session:        sess,
 	})
 }
+
+// Refresh Session Parameters
+//
+// swagger:parameters refreshSession
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type refreshSession struct {
+	// in: body
+	// required: true
+	Body refreshSessionBody
+}
+
+// Refresh Session Request Body
+//
+// swagger:model refreshSessionBody
+type refreshSessionBody struct {
+	// The refresh token which was issued together with the session token.
+	//
+	// required: true
+	RefreshToken string `json:"refresh_token"`
+}
+
+// The Response for Refreshing a Native Session
+//
+// swagger:model successfulNativeSessionRefresh
+type RefreshResponse struct {
+	// The Session Token
+	//
+	// The new session token. The previous session token can no longer be used.
+	//
+	// required: true
+	Token string `json:"session_token"`
+
+	// The Session Token Expiry
+	//
+	// When the new session token expires.
+	//
+	// required: true
+	TokenExpiresAt *time.Time `json:"session_token_expires_at"`
+
+	// The Refresh Token
+	//
+	// The refresh token which exchanges the new session token once it expires.
+	//
+	// required: true
+	RefreshToken string `json:"refresh_token"`
+
+	// The Session
+	//
+	// required: true
+	Session *Session `json:"session"`
+}
+
+// swagger:route POST /sessions/refresh frontend refreshSession
+//
+// # Refresh a Native Session
+//
+// Exchanges the refresh token, which native login and registration flows issue if refresh tokens are
+// enabled, for a new session token and a new refresh token. Every refresh token can only be used once. If a
+// refresh token is used a second time, the session is revoked, because the token may have been stolen. Only a
+// client which did not receive the response to its refresh request can retry it within the configured reuse
+// grace period, as long as it did not use the refresh token from that response. If the session is bound to a DPoP
+// key, the request must carry a DPoP proof signed with it.
+//
+//	Consumes:
+//	- application/json
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Responses:
+//	  200: successfulNativeSessionRefresh
+//	  400: errorGeneric
+//	  401: errorGeneric
+//	  404: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body refreshSessionBody
+	if err := jsonx.NewStrictDecoder(r.Body).Decode(&body); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithWrap(err).WithReasonf("Unable to decode the request body: %s", err)))
+		return
+	}
+	if body.RefreshToken == "" {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReason(`The "refresh_token" field must be set.`)))
+		return
+	}
+
+	s, refreshToken, err := h.r.SessionRefreshTokenManager().Refresh(r, body.RefreshToken)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	if s.Identity != nil {
+		s.Identity = s.Identity.CopyWithoutCredentials()
+	}
+	h.r.Writer().Write(w, r, &RefreshResponse{
+		Token:          s.Token,
+		TokenExpiresAt: s.TokenExpiresAt,
+		RefreshToken:   refreshToken,
+		Session:        s,
+	})
+}
+
+// Introspect Session Token Parameters
+//
+// swagger:parameters introspectSessionToken
+//
+//nolint:deadcode,unused
+//lint:ignore U1000 Used to generate Swagger and OpenAPI definitions
+type introspectSessionToken struct {
+	// The session token or tokenized session to introspect.
+	//
+	// required: true
+	// in: formData
+	Token string `json:"token"`
+
+	// A hint about the type of the token. It is accepted for compatibility with RFC 7662 but the type is
+	// detected from the token itself.
+	//
+	// in: formData
+	TokenTypeHint string `json:"token_type_hint"`
+
+	// The ID of the introspection client, if the client does not use HTTP Basic authentication.
+	//
+	// in: formData
+	ClientID string `json:"client_id"`
+
+	// The secret of the introspection client, if the client does not use HTTP Basic authentication.
+	//
+	// in: formData
+	ClientSecret string `json:"client_secret"`
+}
+
+// swagger:route POST /admin/introspect identity introspectSessionToken
+//
+// # Introspect a Session Token
+//
+// Implements RFC 7662 token introspection for session tokens and sessions tokenized with `tokenize_as`.
+// Resource servers use this endpoint to check whether a token is active and which identity it belongs to.
+//
+// Tokens which are unknown, expired, revoked, or whose session is no longer active are reported as
+// `{"active": false}`. If introspection clients are configured, the caller must authenticate using HTTP Basic
+// authentication or the `client_id` and `client_secret` form parameters.
+//
+//	Consumes:
+//	- application/x-www-form-urlencoded
+//
+//	Produces:
+//	- application/json
+//
+//	Schemes: http, https
+//
+//	Security:
+//	  oryAccessToken:
+//
+//	Responses:
+//	  200: introspectedSessionToken
+//	  400: errorGeneric
+//	  401: errorGeneric
+//	  default: errorGeneric
+func (h *Handler) introspect(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithWrap(err).WithReason("Unable to parse the introspection request.")))
+		return
+	}
+
+	if err := h.authenticateIntrospectionClient(r); err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		h.r.Writer().WriteError(w, r, errors.WithStack(herodot.ErrBadRequest.WithReason(`The "token" parameter is required.`)))
+		return
+	}
+
+	result, err := h.r.SessionIntrospector().Introspect(r, token)
+	if err != nil {
+		h.r.Writer().WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.r.Writer().Write(w, r, result)
+}
+
+// authenticateIntrospectionClient checks the client credentials if introspection clients are configured.
+func (h *Handler) authenticateIntrospectionClient(r *http.Request) error {
+	clients := h.r.Config().SessionIntrospectionClients(r.Context())
+	if len(clients) == 0 {
+		return nil
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 requires the credentials to be form-encoded before they are used for Basic authentication.
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return errors.WithStack(herodot.ErrUnauthorized.WithReason("The introspection client credentials are invalid."))
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return errors.WithStack(herodot.ErrUnauthorized.WithReason("The introspection client credentials are invalid."))
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	for _, c := range clients {
+		if subtle.ConstantTimeCompare([]byte(c.ID), []byte(id)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1 {
+			return nil
+		}
+	}
+	return errors.WithStack(herodot.ErrUnauthorized.WithReason("The introspection client credentials are invalid."))
+}
@@ -5,6 +5,10 @@ package session_test
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -19,6 +23,8 @@ import (
 	"time"
 
 	"github.com/go-faker/faker/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/peterhellberg/link"
 	"github.com/tidwall/gjson"
 
@@ -1083,3 +1089,247 @@ func (s byAuthenticatedAt) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s byAuthenticatedAt) Less(i, j int) bool {
 	return s[i].AuthenticatedAt.Before(s[j].AuthenticatedAt)
 }
+
+func TestHandlerForwardAuth(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	ts, _, _, _ := testhelpers.NewKratosServerWithCSRFAndRouters(t, reg)
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, ts.URL)
+	conf.MustSet(ctx, config.ViperKeySessionWhoAmIAAL, "aal1")
+	conf.MustSet(ctx, config.ViperKeySessionDeviceTrustedProxies, []string{"127.0.0.1", "::1"})
+	conf.MustSet(ctx, config.ViperKeySessionForwardAuthUnmatchedHosts, config.ForwardAuthUnmatchedHostsAllow)
+
+	newSession := func(t *testing.T, traits string) *Session {
+		i := createAAL2Identity(t, reg)
+		i.Traits = identity.Traits(traits)
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+
+		s, err := NewActiveSession(httptest.NewRequest("GET", RouteForwardAuth, nil), i, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		return s
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	forwardAuth := func(t *testing.T, token, host, query string, header ...string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+RouteForwardAuth+query, nil)
+		require.NoError(t, err)
+		for k := 0; k+1 < len(header); k += 2 {
+			req.Header.Set(header[k], header[k+1])
+		}
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Uri", "/dashboard?tab=1")
+		if token != "" {
+			req.Header.Set("X-Session-Token", token)
+		}
+
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = res.Body.Close() })
+		return res
+	}
+
+	t.Run("case=no session", func(t *testing.T) {
+		res := forwardAuth(t, "", "app.example.org", "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		location, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/self-service/login/browser", location.Path)
+		assert.Equal(t, "https://app.example.org/dashboard?tab=1", location.Query().Get("return_to"))
+
+		res = forwardAuth(t, "", "app.example.org", "?unauthorized_response=redirect")
+		assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+		assert.Contains(t, res.Header.Get("Location"), "/self-service/login/browser")
+	})
+
+	t.Run("case=default headers", func(t *testing.T) {
+		s := newSession(t, `{"email":"forward-auth@ory.sh"}`)
+
+		res := forwardAuth(t, s.Token, "app.example.org", "")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, s.IdentityID.String(), res.Header.Get("X-User-Id"))
+		assert.Equal(t, "forward-auth@ory.sh", res.Header.Get("X-User-Email"))
+		assert.Equal(t, "aal1", res.Header.Get("X-Session-AAL"))
+	})
+
+	t.Run("case=headers sent by the client are cleared", func(t *testing.T) {
+		s := newSession(t, `{}`)
+
+		res := forwardAuth(t, s.Token, "app.example.org", "", "X-User-Email", "spoofed@ory.sh")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, []string{""}, res.Header.Values("X-User-Email"))
+	})
+
+	t.Run("case=tokenized session", func(t *testing.T) {
+		setTokenizeConfig(conf, "es256", "jwk.es256.json", "")
+		conf.MustSet(ctx, config.ViperKeySessionForwardAuthTokenizerTemplate, "es256")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionForwardAuthTokenizerTemplate, "") })
+
+		s := newSession(t, `{}`)
+		res := forwardAuth(t, s.Token, "app.example.org", "")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		token, ok := strings.CutPrefix(res.Header.Get("Authorization"), "Bearer ")
+		require.True(t, ok, res.Header.Get("Authorization"))
+		assert.Len(t, strings.Split(token, "."), 3)
+	})
+
+	t.Run("case=rules", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionForwardAuthRules, []config.ForwardAuthRule{
+			{Host: "admin.example.org", Traits: []config.ForwardAuthTraitCondition{{Path: "roles", In: []interface{}{"admin"}}}},
+			{Host: "*.secure.example.org", RequiredAAL: config.HighestAvailableAAL},
+		})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionForwardAuthRules, []config.ForwardAuthRule{}) })
+
+		admin := newSession(t, `{"roles":["viewer","admin"]}`)
+		viewer := newSession(t, `{"roles":["viewer"]}`)
+
+		assert.Equal(t, http.StatusOK, forwardAuth(t, admin.Token, "admin.example.org", "").StatusCode)
+		assert.Equal(t, http.StatusForbidden, forwardAuth(t, viewer.Token, "admin.example.org", "").StatusCode)
+		assert.Equal(t, http.StatusOK, forwardAuth(t, viewer.Token, "app.example.org", "").StatusCode)
+
+		t.Run("case=unmatched hosts are denied", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionForwardAuthUnmatchedHosts, config.ForwardAuthUnmatchedHostsDeny)
+			t.Cleanup(func() {
+				conf.MustSet(ctx, config.ViperKeySessionForwardAuthUnmatchedHosts, config.ForwardAuthUnmatchedHostsAllow)
+			})
+
+			assert.Equal(t, http.StatusForbidden, forwardAuth(t, admin.Token, "app.example.org", "").StatusCode)
+			assert.Equal(t, http.StatusForbidden, forwardAuth(t, "", "app.example.org", "").StatusCode)
+			assert.Equal(t, http.StatusOK, forwardAuth(t, admin.Token, "admin.example.org", "").StatusCode)
+		})
+
+		res := forwardAuth(t, viewer.Token, "app.secure.example.org", "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		location, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "aal2", location.Query().Get("aal"))
+		assert.Equal(t, "https://app.secure.example.org/dashboard?tab=1", location.Query().Get("return_to"))
+	})
+
+	t.Run("case=forwarded headers", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionForwardAuthRules, []config.ForwardAuthRule{
+			{Host: "admin.example.org", Traits: []config.ForwardAuthTraitCondition{{Path: "roles", In: []interface{}{"admin"}}}},
+		})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionForwardAuthRules, []config.ForwardAuthRule{}) })
+
+		viewer := newSession(t, `{"roles":["viewer"]}`)
+
+		t.Run("case=the value appended last is used", func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, forwardAuth(t, viewer.Token, "app.example.org, admin.example.org", "").StatusCode)
+			assert.Equal(t, http.StatusOK, forwardAuth(t, viewer.Token, "admin.example.org, app.example.org", "").StatusCode)
+		})
+
+		t.Run("case=headers of untrusted proxies are ignored", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionDeviceTrustedProxies, []string{"10.0.0.0/8"})
+			t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionDeviceTrustedProxies, []string{"127.0.0.1", "::1"}) })
+
+			assert.Equal(t, http.StatusOK, forwardAuth(t, viewer.Token, "admin.example.org", "").StatusCode, "the rule of the forwarded host must not apply")
+
+			res := forwardAuth(t, "", "admin.example.org", "")
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			location, err := url.Parse(res.Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Empty(t, location.Query().Get("return_to"))
+		})
+	})
+}
+
+func TestHandlerExchangeSessionToken(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	publicServer, _, _, _ := testhelpers.NewKratosServerWithCSRFAndRouters(t, reg)
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, publicServer.URL)
+
+	newExchanger := func(t *testing.T) (*Session, url.Values) {
+		i := identity.NewIdentity(config.DefaultIdentityTraitsSchemaID)
+		require.NoError(t, reg.IdentityManager().Create(ctx, i))
+		s, err := NewActiveSession(httptest.NewRequest("GET", "/", nil), i, conf, time.Now(), identity.CredentialsTypeOIDC, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+
+		flowID := x.NewUUID()
+		e, err := reg.SessionTokenExchangePersister().CreateSessionTokenExchanger(ctx, flowID)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionTokenExchangePersister().UpdateSessionOnExchanger(ctx, flowID, s.ID))
+		return s, url.Values{"init_code": {e.InitCode}, "return_to_code": {e.ReturnToCode}}
+	}
+
+	exchange := func(t *testing.T, query url.Values, proof string) (int, string) {
+		req := testhelpers.NewTestHTTPRequest(t, "GET", publicServer.URL+RouteExchangeCodeForSessionToken+"?"+query.Encode(), nil)
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		res, err := publicServer.Client().Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		return res.StatusCode, string(ioutilx.MustReadAll(res.Body))
+	}
+
+	t.Run("case=binds the session to the key of the exchange request", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionDPoPFlows+".api", config.DPoPModeRequired)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionDPoPFlows+".api", config.DPoPModeOff) })
+
+		s, query := newExchanger(t)
+		status, body := exchange(t, query, "")
+		assert.Equal(t, http.StatusUnauthorized, status, body)
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, ExpandNothing)
+		require.NoError(t, err)
+		assert.Empty(t, actual.DPoPJKT)
+
+		key, jkt := newDPoPKey(t)
+		status, body = exchange(t, query, newDPoPProof(t, key, "GET", publicServer.URL+RouteExchangeCodeForSessionToken))
+		require.Equal(t, http.StatusOK, status, body)
+		assert.Equal(t, s.Token, gjson.Get(body, "session_token").String(), body)
+		actual, err = reg.SessionPersister().GetSession(ctx, s.ID, ExpandNothing)
+		require.NoError(t, err)
+		assert.EqualValues(t, jkt, actual.DPoPJKT)
+	})
+
+	t.Run("case=does not bind the session if DPoP is off", func(t *testing.T) {
+		s, query := newExchanger(t)
+		key, _ := newDPoPKey(t)
+		status, body := exchange(t, query, newDPoPProof(t, key, "GET", publicServer.URL+RouteExchangeCodeForSessionToken))
+		require.Equal(t, http.StatusOK, status, body)
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, ExpandNothing)
+		require.NoError(t, err)
+		assert.Empty(t, actual.DPoPJKT)
+	})
+}
+
+// newDPoPKey returns a new key for DPoP proofs and its JWK SHA-256 thumbprint.
+func newDPoPKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	public, err := jwk.FromRaw(&key.PublicKey)
+	require.NoError(t, err)
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	return key, base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// newDPoPProof returns a DPoP proof for a request with the given method and URL which was signed with the key.
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, method, target string) string {
+	public, err := jwk.FromRaw(&key.PublicKey)
+	require.NoError(t, err)
+	raw, err := json.Marshal(public)
+	require.NoError(t, err)
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &header))
+
+	proof := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"jti": x.NewUUID().String(),
+		"htm": method,
+		"htu": target,
+		"iat": time.Now().Unix(),
+	})
+	proof.Header["typ"] = "dpop+jwt"
+	proof.Header["jwk"] = header
+	signed, err := proof.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
@@ -8,10 +8,12 @@ import (
 	"strings"
 )
 
+// bearerTokenFromRequest returns the token of the Authorization header. Tokens of sessions which are bound to a
+// DPoP key may also use the "DPoP" scheme.
 func bearerTokenFromRequest(r *http.Request) (string, bool) {
 	parts := strings.Split(r.Header.Get("Authorization"), " ")
 
-	if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+	if len(parts) == 2 && (strings.ToLower(parts[0]) == "bearer" || strings.ToLower(parts[0]) == "dpop") {
 		return parts[1], true
 	}
 
@@ -0,0 +1,451 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/jwksx"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/identity"
+	signingkey "my.com/secrets/internal/auth/domain/jwk"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+const (
+	TokenTypeSessionToken = "session_token"
+	TokenTypeJWT          = "jwt"
+)
+
+// Introspection is the RFC 7662 introspection response. Inactive tokens only include "active".
+//
+// swagger:model introspectedSessionToken
+type Introspection struct {
+	// Active is true if the token belongs to an active session.
+	//
+	// required: true
+	Active bool `json:"active"`
+
+	// TokenType is either "session_token" or "jwt".
+	TokenType string `json:"token_type,omitempty"`
+
+	// Subject is the ID of the session's identity.
+	Subject string `json:"sub,omitempty"`
+
+	// SessionID is the ID of the session.
+	SessionID string `json:"sid,omitempty"`
+
+	// Issuer is the public URL of Kratos.
+	Issuer string `json:"iss,omitempty"`
+
+	// ExpiresAt is the time, in seconds since the Unix epoch, at which the token expires.
+	ExpiresAt int64 `json:"exp,omitempty"`
+
+	// IssuedAt is the time, in seconds since the Unix epoch, at which the token was issued.
+	IssuedAt int64 `json:"iat,omitempty"`
+
+	// AuthenticatorAssuranceLevel is the session's authenticator assurance level.
+	AuthenticatorAssuranceLevel identity.AuthenticatorAssuranceLevel `json:"aal,omitempty"`
+
+	// AuthenticationMethods are the methods used to authenticate the session, for example "password".
+	AuthenticationMethods []string `json:"amr,omitempty"`
+
+	// Confirmation contains the thumbprint of the DPoP key the session is bound to, if any.
+	Confirmation *IntrospectionConfirmation `json:"cnf,omitempty"`
+
+	// Extra contains the configured extra claims. They are added to the top level of the response.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// IntrospectionConfirmation is the confirmation claim of RFC 9449.
+type IntrospectionConfirmation struct {
+	// JWKThumbprint is the JWK SHA-256 thumbprint of the DPoP key.
+	JWKThumbprint string `json:"jkt"`
+}
+
+func (i Introspection) MarshalJSON() ([]byte, error) {
+	type introspection Introspection
+	raw, err := json.Marshal(introspection(i))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range i.Extra {
+		if gjson.GetBytes(raw, gjson.Escape(name)).Exists() {
+			continue
+		}
+		if raw, err = sjson.SetBytes(raw, gjson.Escape(name), value); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+type (
+	introspectorDependencies interface {
+		config.Provider
+		x.TracingProvider
+		x.HTTPClientProvider
+		x.JWKSFetchProvider
+		signingkey.ManagementProvider
+		ManagementProvider
+		PersistenceProvider
+		CacheProvider
+	}
+	IntrospectorProvider interface {
+		SessionIntrospector() *Introspector
+	}
+
+	// Introspector validates session tokens and tokenized sessions on behalf of resource servers. Cached results
+	// are dropped by the invalidations of the session cache, so that revoked sessions are reported as inactive
+	// on all instances.
+	Introspector struct {
+		r       introspectorDependencies
+		nowFunc func() time.Time
+
+		sync.Mutex
+		cache      *simplelru.LRU
+		bySession  map[uuid.UUID]map[string]struct{}
+		byIdentity map[uuid.UUID]map[string]struct{}
+	}
+
+	introspectionCacheEntry struct {
+		result     *Introspection
+		sessionID  uuid.UUID
+		identityID uuid.UUID
+		expiresAt  time.Time
+	}
+)
+
+func NewIntrospector(r introspectorDependencies) *Introspector {
+	i := &Introspector{
+		r:          r,
+		nowFunc:    time.Now,
+		bySession:  map[uuid.UUID]map[string]struct{}{},
+		byIdentity: map[uuid.UUID]map[string]struct{}{},
+	}
+
+	// The size is only invalid if it is not positive, which the configuration schema prevents.
+	i.cache, _ = simplelru.NewLRU(max(r.Config().SessionIntrospectionCacheMaxEntries(context.Background()), 1), i.onEvict)
+	r.SessionCache().OnInvalidate(i.invalidate)
+	return i
+}
+
+// Introspect returns whether the session token or tokenized session is active. Tokens which are unknown,
+// expired, or whose session is no longer active or requires a password change are reported as inactive rather
+// than as an error.
+func (i *Introspector) Introspect(r *http.Request, token string) (_ *Introspection, err error) {
+	ctx, span := i.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.Introspector.Introspect")
+	defer otelx.End(span, &err)
+	r = r.WithContext(ctx)
+
+	if token == "" {
+		return &Introspection{}, nil
+	}
+
+	key := i.r.SessionCache().key(ctx, token)
+	if result, ok := i.cached(ctx, key); ok {
+		return result, nil
+	}
+
+	var result *Introspection
+	if strings.Count(token, ".") == 2 {
+		result, err = i.introspectJWT(r, token)
+	} else {
+		result, err = i.introspectSessionToken(r, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i.store(ctx, key, result)
+	return result, nil
+}
+
+func (i *Introspector) introspectSessionToken(r *http.Request, token string) (*Introspection, error) {
+	ctx := r.Context()
+	s, err := i.r.SessionManager().FetchFromToken(ctx, token)
+	if err != nil {
+		if e := new(ErrNoActiveSessionFound); errors.As(err, &e) {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+
+	if blocked, err := i.requiresPasswordChange(r, s); err != nil {
+		return nil, err
+	} else if blocked {
+		return &Introspection{}, nil
+	}
+
+	expiresAt := s.ExpiresAt
+	if s.IdleExpiresAt != nil && s.IdleExpiresAt.Before(expiresAt) {
+		expiresAt = *s.IdleExpiresAt
+	}
+
+	return i.activeResult(ctx, s, TokenTypeSessionToken, expiresAt, s.IssuedAt)
+}
+
+func (i *Introspector) introspectJWT(r *http.Request, token string) (*Introspection, error) {
+	ctx := r.Context()
+	var claims struct {
+		jwt.RegisteredClaims
+		SessionID string `json:"sid"`
+	}
+
+	parsed, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return i.verificationKey(ctx, t)
+	},
+		jwt.WithIssuer(i.r.Config().SelfPublicURL(ctx).String()),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(i.nowFunc),
+	)
+	if err != nil || !parsed.Valid {
+		return &Introspection{}, nil
+	}
+
+	sessionID, err := uuid.FromString(claims.SessionID)
+	if err != nil {
+		return &Introspection{}, nil
+	}
+
+	s, err := i.r.SessionPersister().GetSession(ctx, sessionID, ExpandEverything)
+	if err != nil {
+		if errors.Is(err, sqlcon.ErrNoRows) || errors.Is(err, herodot.ErrNotFound) {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+	if !s.IsActive() || s.IdentityID.String() != claims.Subject {
+		return &Introspection{}, nil
+	}
+	if blocked, err := i.requiresPasswordChange(r, s); err != nil {
+		return nil, err
+	} else if blocked {
+		return &Introspection{}, nil
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return i.activeResult(ctx, s, TokenTypeJWT, claims.ExpiresAt.Time, issuedAt)
+}
+
+// verificationKey returns the public key with the token's key ID. Keys of managed key sets take precedence over
+// keys from the JWKS URLs of tokenizer templates.
+func (i *Introspector) verificationKey(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("the token does not have a key ID")
+	}
+
+	var key jwk.Key
+	set, err := i.r.SigningKeyManager().PublicKeySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := set.LookupKeyID(kid); ok {
+		key = k
+	} else {
+		for _, tpl := range i.r.Config().TokenizeTemplates(ctx) {
+			if tpl.JWKSURL == "" {
+				continue
+			}
+			k, err := i.r.JWKSFetcher().ResolveKey(ctx, tpl.JWKSURL,
+				jwksx.WithForceKID(kid),
+				jwksx.WithCacheEnabled(),
+				jwksx.WithCacheTTL(time.Hour),
+				jwksx.WithHTTPClient(i.r.HTTPClient(ctx)))
+			if err == nil {
+				if key, err = parseJWKSKey(k); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+	if key == nil {
+		return nil, errors.Errorf("unable to find the key %q", kid)
+	}
+
+	if key.Algorithm().String() != t.Method.Alg() {
+		return nil, errors.Errorf("the token was signed with %q but the key uses %q", t.Method.Alg(), key.Algorithm())
+	}
+
+	public, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := public.Raw(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// requiresPasswordChange returns true if the session can not be used until its identity changes the password.
+func (i *Introspector) requiresPasswordChange(r *http.Request, s *Session) (bool, error) {
+	err := i.r.SessionManager().DoesSessionRequirePasswordChange(r, s)
+	if e := new(ErrPasswordChangeRequired); errors.As(err, &e) {
+		return true, nil
+	}
+	return false, err
+}
+
+func (i *Introspector) activeResult(ctx context.Context, s *Session, tokenType string, expiresAt, issuedAt time.Time) (*Introspection, error) {
+	result := &Introspection{
+		Active:                      true,
+		TokenType:                   tokenType,
+		Subject:                     s.IdentityID.String(),
+		SessionID:                   s.ID.String(),
+		Issuer:                      i.r.Config().SelfPublicURL(ctx).String(),
+		ExpiresAt:                   expiresAt.Unix(),
+		AuthenticatorAssuranceLevel: s.AuthenticatorAssuranceLevel,
+		AuthenticationMethods:       make([]string, 0, len(s.AMR)),
+	}
+	if !issuedAt.IsZero() {
+		result.IssuedAt = issuedAt.Unix()
+	}
+	if s.DPoPJKT != "" {
+		result.Confirmation = &IntrospectionConfirmation{JWKThumbprint: string(s.DPoPJKT)}
+	}
+	for _, method := range s.AMR {
+		result.AuthenticationMethods = append(result.AuthenticationMethods, string(method.Method))
+	}
+
+	extra := i.r.Config().SessionIntrospectionExtraClaims(ctx)
+	if len(extra) == 0 {
+		return result, nil
+	}
+
+	cp := *s
+	if cp.Identity != nil {
+		cp.Identity = cp.Identity.CopyWithoutCredentials()
+	}
+	raw, err := json.Marshal(&cp)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to encode session to JSON."))
+	}
+
+	result.Extra = map[string]interface{}{}
+	for name, path := range extra {
+		if value := gjson.GetBytes(raw, path); value.Exists() {
+			result.Extra[name] = value.Value()
+		}
+	}
+	return result, nil
+}
+
+func (i *Introspector) cached(ctx context.Context, key string) (*Introspection, bool) {
+	if !i.r.Config().SessionIntrospectionCacheEnabled(ctx) {
+		return nil, false
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	value, ok := i.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(*introspectionCacheEntry)
+	if !entry.expiresAt.After(i.nowFunc()) {
+		i.cache.Remove(key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// store caches active results only. Inactive results carry neither a session nor an identity, so that the
+// invalidations of the session cache could not drop them once the session becomes usable again, for example
+// after its identity changed the password.
+func (i *Introspector) store(ctx context.Context, key string, result *Introspection) {
+	if !i.r.Config().SessionIntrospectionCacheEnabled(ctx) || !result.Active {
+		return
+	}
+
+	expiresAt := i.nowFunc().Add(i.r.Config().SessionIntrospectionCacheTTL(ctx))
+	if tokenExpiresAt := time.Unix(result.ExpiresAt, 0); tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+
+	entry := &introspectionCacheEntry{
+		result:     result,
+		sessionID:  uuid.FromStringOrNil(result.SessionID),
+		identityID: uuid.FromStringOrNil(result.Subject),
+		expiresAt:  expiresAt,
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	i.cache.Add(key, entry)
+	index(i.bySession, entry.sessionID, key)
+	index(i.byIdentity, entry.identityID, key)
+}
+
+// invalidate drops the cached results which the session cache invalidation applies to. The session cache keys
+// tokens the same way, so that invalidations of single tokens apply to both caches.
+func (i *Introspector) invalidate(inv *CacheInvalidation) {
+	i.Lock()
+	defer i.Unlock()
+
+	switch {
+	case inv.All:
+		i.cache.Purge()
+	case inv.Key != "":
+		i.cache.Remove(inv.Key)
+	case inv.SessionID != uuid.Nil:
+		for key := range i.bySession[inv.SessionID] {
+			i.cache.Remove(key)
+		}
+	case inv.IdentityID != uuid.Nil:
+		for key := range i.byIdentity[inv.IdentityID] {
+			i.cache.Remove(key)
+		}
+	}
+}
+
+// onEvict keeps the session and identity indexes in sync with the cache. It is called with the lock held.
+func (i *Introspector) onEvict(key, value interface{}) {
+	entry := value.(*introspectionCacheEntry)
+	unindex(i.bySession, entry.sessionID, key.(string))
+	unindex(i.byIdentity, entry.identityID, key.(string))
+}
+
+func index(keys map[uuid.UUID]map[string]struct{}, id uuid.UUID, key string) {
+	if id == uuid.Nil {
+		return
+	}
+	if keys[id] == nil {
+		keys[id] = map[string]struct{}{}
+	}
+	keys[id][key] = struct{}{}
+}
+
+func unindex(keys map[uuid.UUID]map[string]struct{}, id uuid.UUID, key string) {
+	if set, ok := keys[id]; ok {
+		delete(set, key)
+		if len(set) == 0 {
+			delete(keys, id)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestIntrospectionMarshalJSON(t *testing.T) {
+	t.Run("case=inactive", func(t *testing.T) {
+		raw, err := json.Marshal(&session.Introspection{})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"active":false}`, string(raw))
+	})
+
+	t.Run("case=extra claims do not overwrite standard claims", func(t *testing.T) {
+		raw, err := json.Marshal(&session.Introspection{
+			Active:  true,
+			Subject: "identity",
+			Extra:   map[string]interface{}{"sub": "overwritten", "email": "foo@ory.sh", "schema.id": "default"},
+		})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"active":true,"sub":"identity","email":"foo@ory.sh","schema.id":"default"}`, string(raw))
+	})
+}
+
+func TestIntrospector(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, "http://localhost/")
+	conf.MustSet(ctx, config.ViperKeySessionTokenizerTemplates+".managed", &config.SessionTokenizeFormat{
+		TTL:      time.Minute,
+		KeyAlias: "introspection",
+	})
+
+	newSession := func(t *testing.T) *session.Session {
+		i := identity.NewIdentity("default")
+		i.Traits = identity.Traits(`{"email":"` + x.NewUUID().String() + `@ory.sh"}`)
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+
+		s, err := session.NewActiveSession(httptest.NewRequest("GET", "/sessions/whoami", nil), i, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		return s
+	}
+	req := httptest.NewRequest("POST", "/admin/introspect", nil)
+
+	t.Run("case=session token", func(t *testing.T) {
+		s := newSession(t)
+
+		result, err := reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, session.TokenTypeSessionToken, result.TokenType)
+		assert.Equal(t, s.IdentityID.String(), result.Subject)
+		assert.Equal(t, s.ID.String(), result.SessionID)
+		assert.Equal(t, s.ExpiresAt.Unix(), result.ExpiresAt)
+		assert.Equal(t, identity.AuthenticatorAssuranceLevel1, result.AuthenticatorAssuranceLevel)
+		assert.Equal(t, []string{"password"}, result.AuthenticationMethods)
+
+		require.NoError(t, reg.SessionPersister().RevokeSessionByToken(ctx, s.Token))
+		result, err = reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		assert.False(t, result.Active)
+	})
+
+	t.Run("case=unknown token", func(t *testing.T) {
+		result, err := reg.SessionIntrospector().Introspect(req, "not-a-session-token")
+		require.NoError(t, err)
+		assert.False(t, result.Active)
+	})
+
+	t.Run("case=tokenized session", func(t *testing.T) {
+		s := newSession(t)
+		require.NoError(t, reg.SessionTokenizer().TokenizeSession(ctx, "managed", s))
+
+		result, err := reg.SessionIntrospector().Introspect(req, s.Tokenized)
+		require.NoError(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, session.TokenTypeJWT, result.TokenType)
+		assert.Equal(t, s.IdentityID.String(), result.Subject)
+		assert.Equal(t, s.ID.String(), result.SessionID)
+		assert.InDelta(t, time.Now().Add(time.Minute).Unix(), result.ExpiresAt, 5)
+
+		t.Run("case=tampered signature", func(t *testing.T) {
+			tampered := s.Tokenized[:strings.LastIndex(s.Tokenized, ".")+1] + "c2lnbmF0dXJl"
+			result, err := reg.SessionIntrospector().Introspect(req, tampered)
+			require.NoError(t, err)
+			assert.False(t, result.Active)
+		})
+
+		t.Run("case=revoked session", func(t *testing.T) {
+			require.NoError(t, reg.SessionPersister().RevokeSessionByToken(ctx, s.Token))
+			result, err := reg.SessionIntrospector().Introspect(req, s.Tokenized)
+			require.NoError(t, err)
+			assert.False(t, result.Active)
+		})
+	})
+
+	t.Run("case=extra claims", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionIntrospectionExtraClaims, map[string]string{"email": "identity.traits.email"})
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionIntrospectionExtraClaims, map[string]string{}) })
+
+		s := newSession(t)
+		result, err := reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		assert.Equal(t, gjson.GetBytes(s.Identity.Traits, "email").String(), result.Extra["email"])
+	})
+
+	t.Run("case=password change required", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeyPasswordMaxAge, "24h")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeyPasswordMaxAge, "0s") })
+
+		s := newSession(t)
+		result, err := reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		require.True(t, result.Active)
+
+		changedAt := time.Now().UTC().Add(-48 * time.Hour)
+		require.NoError(t, s.Identity.SetCredentialsWithConfig(identity.CredentialsTypePassword,
+			identity.Credentials{Identifiers: []string{gjson.GetBytes(s.Identity.Traits, "email").String()}},
+			identity.CredentialsPassword{HashedPassword: "$argon2id$v=19$m=32,t=2,p=4$cm94YnRVOW5jZzFzcVE4bQ$MNzk5BtR2vUhrp6qQEjRNw", PasswordChangedAt: &changedAt}))
+		require.NoError(t, reg.PrivilegedIdentityPool().UpdateIdentity(ctx, s.Identity))
+		require.NoError(t, reg.SessionTokenizer().TokenizeSession(ctx, "managed", s))
+
+		for _, token := range []string{s.Token, s.Tokenized} {
+			result, err := reg.SessionIntrospector().Introspect(req, token)
+			require.NoError(t, err)
+			assert.False(t, result.Active)
+		}
+	})
+
+	t.Run("case=cache", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionIntrospectionCacheEnabled, true)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionIntrospectionCacheEnabled, false) })
+
+		s := newSession(t)
+		require.NoError(t, reg.SessionTokenizer().TokenizeSession(ctx, "managed", s))
+		for _, token := range []string{s.Token, s.Tokenized} {
+			result, err := reg.SessionIntrospector().Introspect(req, token)
+			require.NoError(t, err)
+			require.True(t, result.Active)
+		}
+
+		// Deactivating the session without invalidating the caches shows that the results are cached.
+		require.NoError(t, reg.Persister().GetConnection(ctx).RawQuery("UPDATE sessions SET active = ? WHERE id = ?", false, s.ID).Exec())
+		result, err := reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		assert.True(t, result.Active, "the cached result is returned until it expires or is invalidated")
+
+		require.NoError(t, reg.SessionPersister().RevokeSessionById(ctx, s.ID))
+		for _, token := range []string{s.Token, s.Tokenized} {
+			result, err := reg.SessionIntrospector().Introspect(req, token)
+			require.NoError(t, err)
+			assert.False(t, result.Active, "revoking the session invalidates the cached results")
+		}
+
+		// Inactive results are not cached, so that they do not outlive the reason for which the session was unusable.
+		require.NoError(t, reg.Persister().GetConnection(ctx).RawQuery("UPDATE sessions SET active = ? WHERE id = ?", true, s.ID).Exec())
+		result, err = reg.SessionIntrospector().Introspect(req, s.Token)
+		require.NoError(t, err)
+		assert.True(t, result.Active, "inactive results are not cached")
+	})
+
+	t.Run("case=endpoint", func(t *testing.T) {
+		admin := x.NewRouterAdmin()
+		reg.SessionHandler().RegisterAdminRoutes(admin)
+		ts := httptest.NewServer(admin)
+		t.Cleanup(ts.Close)
+
+		introspect := func(t *testing.T, form url.Values, modify func(r *http.Request)) (int, string) {
+			req, err := http.NewRequest("POST", ts.URL+"/admin/introspect", strings.NewReader(form.Encode()))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if modify != nil {
+				modify(req)
+			}
+
+			res, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			body := x.MustReadAll(res.Body)
+			return res.StatusCode, string(body)
+		}
+
+		s := newSession(t)
+
+		status, body := introspect(t, url.Values{"token": {s.Token}}, nil)
+		assert.Equal(t, http.StatusOK, status, body)
+		assert.True(t, gjson.Get(body, "active").Bool(), body)
+		assert.Equal(t, s.IdentityID.String(), gjson.Get(body, "sub").String(), body)
+
+		status, body = introspect(t, url.Values{}, nil)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+
+		t.Run("case=client authentication", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionIntrospectionClients, []config.IntrospectionClient{{ID: "resource-server", Secret: "resource-server-secret"}})
+			t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionIntrospectionClients, []config.IntrospectionClient{}) })
+
+			status, body := introspect(t, url.Values{"token": {s.Token}}, nil)
+			assert.Equal(t, http.StatusUnauthorized, status, body)
+
+			status, body = introspect(t, url.Values{"token": {s.Token}}, func(r *http.Request) {
+				r.SetBasicAuth("resource-server", "wrong-secret")
+			})
+			assert.Equal(t, http.StatusUnauthorized, status, body)
+
+			status, body = introspect(t, url.Values{"token": {s.Token}}, func(r *http.Request) {
+				r.SetBasicAuth("resource-server", "resource-server-secret")
+			})
+			assert.Equal(t, http.StatusOK, status, body)
+			assert.True(t, gjson.Get(body, "active").Bool(), body)
+
+			status, body = introspect(t, url.Values{"token": {s.Token}, "client_id": {"resource-server"}, "client_secret": {"resource-server-secret"}}, nil)
+			assert.Equal(t, http.StatusOK, status, body)
+		})
+	})
+}
@@ -112,6 +112,34 @@ func NewErrAALNotSatisfied(redirectTo string) *ErrAALNotSatisfied {
 	}
 }
 
+// ErrPasswordChangeRequired is returned when an active session was found but it was authenticated with a password
+// which must be changed before the session can be used.
+type ErrPasswordChangeRequired struct {
+	*herodot.DefaultError `json:"error"`
+	RedirectTo            string `json:"redirect_browser_to"`
+}
+
+func (e *ErrPasswordChangeRequired) EnhanceJSONError() interface{} {
+	return e
+}
+
+// NewErrPasswordChangeRequired creates a new ErrPasswordChangeRequired.
+func NewErrPasswordChangeRequired(redirectTo string) *ErrPasswordChangeRequired {
+	return &ErrPasswordChangeRequired{
+		RedirectTo: redirectTo,
+		DefaultError: &herodot.DefaultError{
+			IDField:     text.ErrIDPasswordChangeRequired,
+			StatusField: http.StatusText(http.StatusForbidden),
+			ErrorField:  "Session requires a password change",
+			ReasonField: "An active session was found but its password has expired or must be changed. Please change your password using the settings flow to resolve this issue.",
+			CodeField:   http.StatusForbidden,
+			DetailsField: map[string]interface{}{
+				"redirect_browser_to": redirectTo,
+			},
+		},
+	}
+}
+
 // Manager handles identity sessions.
 type Manager interface {
 	// UpsertAndIssueCookie stores a session in the database and issues a cookie by calling IssueCookie.
@@ -130,12 +158,19 @@ type Manager interface {
 	// FetchFromRequest creates an HTTP session using cookies.
 	FetchFromRequest(context.Context, *http.Request) (*Session, error)
 
-	// PurgeFromRequest removes an HTTP session.
+	// FetchFromToken returns the active session with the given session token.
+	FetchFromToken(ctx context.Context, token string) (*Session, error)
+
+	// PurgeFromRequest removes an HTTP session. Sessions which no longer exist are ignored.
 	PurgeFromRequest(context.Context, http.ResponseWriter, *http.Request) error
 
-	// DoesSessionSatisfy answers if a session is satisfying the AAL.
+	// DoesSessionSatisfy answers if a session is satisfying the AAL. Sessions whose login risk assessment
+	// requires a step-up must satisfy the highest available AAL regardless of the requested AAL.
 	DoesSessionSatisfy(r *http.Request, sess *Session, requestedAAL string, opts ...ManagerOptions) error
 
+	// DoesSessionRequirePasswordChange answers if the session must change its password before it can be used.
+	DoesSessionRequirePasswordChange(r *http.Request, sess *Session, opts ...ManagerOptions) error
+
 	// SessionAddAuthenticationMethods adds one or more authentication method to the session.
 	SessionAddAuthenticationMethods(ctx context.Context, sid uuid.UUID, methods ...AuthenticationMethod) error
 
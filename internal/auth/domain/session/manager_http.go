@@ -46,8 +46,11 @@ type (
 		identity.ManagementProvider
 		x.CookieProvider
 		x.CSRFProvider
+		x.LoggingProvider
 		x.TracingProvider
 		PersistenceProvider
+		CacheProvider
+		DPoPVerifierProvider
 		sessiontokenexchange.PersistenceProvider
 	}
 	ManagerHTTP struct {
@@ -236,29 +239,92 @@ func (s *ManagerHTTP) FetchFromRequest(ctx context.Context, r *http.Request) (_
 		return nil, errors.WithStack(NewErrNoCredentialsForSession())
 	}
 
-	se, err := s.r.SessionPersister().GetSessionByToken(ctx, token, ExpandEverything, identity.ExpandDefault)
+	se, err := s.FetchFromToken(ctx, token)
 	if err != nil {
-		if errors.Is(err, herodot.ErrNotFound) || errors.Is(err, sqlcon.ErrNoRows) {
-			return nil, errors.WithStack(NewErrNoActiveSessionFound())
-		}
 		return nil, err
 	}
 
+	if err := s.r.SessionDPoPVerifier().Verify(r.WithContext(ctx), se, token); err != nil {
+		return nil, err
+	}
+	return se, nil
+}
+
+func (s *ManagerHTTP) FetchFromToken(ctx context.Context, token string) (_ *Session, err error) {
+	ctx, span := s.r.Tracer(ctx).Tracer().Start(ctx, "sessions.ManagerHTTP.FetchFromToken")
+	defer func() {
+		if e := new(ErrNoActiveSessionFound); errors.As(err, &e) {
+			span.End()
+		} else {
+			otelx.End(span, &err)
+		}
+	}()
+
+	se, cached := s.r.SessionCache().Get(ctx, token)
+	if !cached {
+		se, err = s.r.SessionPersister().GetSessionByToken(ctx, token, ExpandEverything, identity.ExpandDefault)
+		if err != nil {
+			if errors.Is(err, herodot.ErrNotFound) || errors.Is(err, sqlcon.ErrNoRows) {
+				return nil, errors.WithStack(NewErrNoActiveSessionFound())
+			}
+			return nil, err
+		}
+		if s.r.Config().SessionCacheEnabled(ctx) {
+			if err := s.resolvePasswordChange(ctx, se); err != nil {
+				return nil, err
+			}
+		}
+		s.r.SessionCache().Set(ctx, token, se)
+	}
+
 	trace.SpanFromContext(ctx).AddEvent(events.NewSessionChecked(ctx, se.ID, se.IdentityID))
 
-	if !se.IsActive() {
+	// Session tokens which were issued together with a refresh token expire before the session does.
+	if se.TokenExpiresAt != nil && !se.TokenExpiresAt.After(time.Now()) {
+		return nil, errors.WithStack(NewErrNoActiveSessionFound())
+	}
+
+	// The idle timeout may have changed since the session was cached.
+	if !se.SetIdleExpiry(ctx, s.r.Config()).IsActive() {
 		return nil, errors.WithStack(NewErrNoActiveSessionFound())
 	}
 
+	s.touch(ctx, token, se)
 	return se, nil
 }
 
+// touch records that the session was seen, if its idle timeout is enabled and it was not seen within the touch
+// interval. Touching is best effort: if it fails, the session stays valid until its previous idle expiry.
+func (s *ManagerHTTP) touch(ctx context.Context, token string, se *Session) {
+	if s.r.Config().SessionIdleTimeout(ctx, string(se.AuthenticatorAssuranceLevel)) <= 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	if !se.NeedsTouch(now, s.r.Config().SessionIdleTimeoutTouchInterval(ctx)) {
+		return
+	}
+
+	if err := s.r.SessionPersister().TouchSession(ctx, se.ID, now); err != nil {
+		s.r.Logger().WithError(err).WithField("session_id", se.ID).Warn("Unable to record that the session was seen.")
+		return
+	}
+	se.LastSeenAt = &now
+	se.SetIdleExpiry(ctx, s.r.Config())
+	s.r.SessionCache().Set(ctx, token, se)
+}
+
 func (s *ManagerHTTP) PurgeFromRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	ctx, span := s.r.Tracer(ctx).Tracer().Start(ctx, "sessions.ManagerHTTP.PurgeFromRequest")
 	defer otelx.End(span, &err)
 
+	// The session may no longer exist, for example because its identity was deleted. Its cookie is removed
+	// regardless.
 	if token, ok := bearerTokenFromRequest(r); ok {
-		return errors.WithStack(s.r.SessionPersister().RevokeSessionByToken(ctx, token))
+		if err := s.r.SessionPersister().RevokeSessionByToken(ctx, token); err != nil && !errors.Is(err, sqlcon.ErrNoRows) {
+			return errors.WithStack(err)
+		}
+		return nil
 	}
 
 	cookie, _ := s.r.CookieManager(r.Context()).Get(r, s.cookieName(ctx))
@@ -267,7 +333,7 @@ func (s *ManagerHTTP) PurgeFromRequest(ctx context.Context, w http.ResponseWrite
 		return nil
 	}
 
-	if err := s.r.SessionPersister().RevokeSessionByToken(ctx, token); err != nil {
+	if err := s.r.SessionPersister().RevokeSessionByToken(ctx, token); err != nil && !errors.Is(err, sqlcon.ErrNoRows) {
 		return errors.WithStack(err)
 	}
 
@@ -293,6 +359,11 @@ func (s *ManagerHTTP) DoesSessionSatisfy(r *http.Request, sess *Session, request
 		o(managerOpts)
 	}
 
+	// Sessions issued by a risky login require a second factor, if the identity has one.
+	if sess.RequiresStepUp() {
+		requestedAAL = config.HighestAvailableAAL
+	}
+
 	sess.SetAuthenticatorAssuranceLevel()
 	switch requestedAAL {
 	case string(identity.AuthenticatorAssuranceLevel1):
@@ -334,6 +405,7 @@ func (s *ManagerHTTP) DoesSessionSatisfy(r *http.Request, sess *Session, request
 				if _, err := s.r.SessionPersister().GetConnection(ctx).Where("id = ? AND nid = ?", i.ID, i.NID).UpdateQuery(i, "available_aal"); err != nil {
 					return err
 				}
+				s.r.SessionCache().InvalidateIdentity(ctx, i.ID)
 			}
 		}
 
@@ -354,6 +426,64 @@ func (s *ManagerHTTP) DoesSessionSatisfy(r *http.Request, sess *Session, request
 	return errors.Errorf("requested unknown aal: %s", requestedAAL)
 }
 
+func (s *ManagerHTTP) DoesSessionRequirePasswordChange(r *http.Request, sess *Session, opts ...ManagerOptions) (err error) {
+	ctx, span := s.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.ManagerHTTP.DoesSessionRequirePasswordChange")
+	defer otelx.End(span, &err)
+
+	// Only sessions which were authenticated with the password are affected.
+	if !sess.AuthenticatedVia(identity.CredentialsTypePassword) {
+		return nil
+	}
+
+	managerOpts := &options{}
+	for _, o := range opts {
+		o(managerOpts)
+	}
+
+	if err := s.resolvePasswordChange(ctx, sess); err != nil {
+		return err
+	}
+
+	since, required, err := s.r.IdentityManager().PasswordChangeRequiredSince(ctx, sess.passwordChange)
+	if err != nil {
+		return err
+	}
+
+	// Sessions which were authenticated before the password change became required remain valid.
+	if !required || sess.AuthenticatedAt.Before(since) {
+		return nil
+	}
+
+	settingsURL := urlx.AppendPaths(s.r.Config().SelfPublicURL(ctx), "/self-service/settings/browser")
+	if managerOpts.requestURL != "" {
+		settingsURL = urlx.CopyWithQuery(settingsURL, url.Values{"return_to": {managerOpts.requestURL}})
+	}
+
+	return NewErrPasswordChangeRequired(settingsURL.String())
+}
+
+// resolvePasswordChange records the identity's password credentials on sessions which were authenticated with the
+// password, unless they were recorded already. It reuses the session's identity if its credentials are loaded.
+func (s *ManagerHTTP) resolvePasswordChange(ctx context.Context, sess *Session) error {
+	if sess.passwordChange != nil || !sess.AuthenticatedVia(identity.CredentialsTypePassword) {
+		return nil
+	}
+
+	i := sess.Identity
+	if i == nil || len(i.Credentials) == 0 {
+		var err error
+		if i, err = s.r.PrivilegedIdentityPool().GetIdentity(ctx, sess.IdentityID, identity.ExpandCredentials); err != nil {
+			return err
+		}
+	}
+
+	sess.passwordChange = &identity.Identity{ID: i.ID, SchemaID: i.SchemaID, OrganizationID: i.OrganizationID}
+	if c, ok := i.GetCredentials(identity.CredentialsTypePassword); ok {
+		sess.passwordChange.SetCredentials(identity.CredentialsTypePassword, *c)
+	}
+	return nil
+}
+
 func (s *ManagerHTTP) SessionAddAuthenticationMethods(ctx context.Context, sid uuid.UUID, ams ...AuthenticationMethod) (err error) {
 	ctx, span := s.r.Tracer(ctx).Tracer().Start(ctx, "sessions.ManagerHTTP.SessionAddAuthenticationMethods")
 	defer otelx.End(span, &err)
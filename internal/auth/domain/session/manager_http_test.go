@@ -361,6 +361,53 @@ func TestManagerHTTP(t *testing.T) {
 			assert.EqualValues(t, http.StatusUnauthorized, res.StatusCode)
 		})
 
+		t.Run("case=revoking a deleted session removes the cookie", func(t *testing.T) {
+			req := testhelpers.NewTestHTTPRequest(t, "GET", "/sessions/whoami", nil)
+			i := identity.Identity{Traits: []byte("{}")}
+			require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(context.Background(), &i))
+			s, _ = session.NewActiveSession(req, &i, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+
+			c := testhelpers.NewClientWithCookies(t)
+			testhelpers.MockHydrateCookieClient(t, c, pts.URL+"/session/set")
+			require.NoError(t, reg.PrivilegedIdentityPool().DeleteIdentity(context.Background(), i.ID))
+
+			res, err := c.Get(pts.URL + "/session/revoke")
+			require.NoError(t, err)
+			assert.EqualValues(t, http.StatusOK, res.StatusCode)
+
+			u, _ := url.Parse(pts.URL)
+			for _, cookie := range c.Jar.Cookies(u) {
+				assert.NotEqual(t, config.DefaultSessionCookieName, cookie.Name)
+			}
+		})
+
+		t.Run("case=touches sessions only if the idle timeout is enabled", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionLifespan, "24h")
+			i := identity.Identity{Traits: []byte("{}")}
+			require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, &i))
+			seen := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+			s, err := session.NewActiveSession(testhelpers.NewTestHTTPRequest(t, "GET", "/sessions/whoami", nil), &i, conf, seen, identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+			require.NoError(t, err)
+			require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+
+			lastSeenAt := func(t *testing.T) time.Time {
+				actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+				require.NoError(t, err)
+				require.NotNil(t, actual.LastSeenAt)
+				return actual.LastSeenAt.UTC()
+			}
+
+			_, err = reg.SessionManager().FetchFromToken(ctx, s.Token)
+			require.NoError(t, err)
+			assert.Equal(t, seen, lastSeenAt(t).Truncate(time.Second), "sessions are not touched without an idle timeout")
+
+			conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, "3h")
+			t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, nil) })
+			_, err = reg.SessionManager().FetchFromToken(ctx, s.Token)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), lastSeenAt(t), time.Minute)
+		})
+
 		t.Run("case=respects AAL config", func(t *testing.T) {
 			conf.MustSet(ctx, config.ViperKeySessionLifespan, "1m")
 
@@ -638,3 +685,84 @@ func TestDoesSessionSatisfy(t *testing.T) {
 		})
 	}
 }
+
+func TestDoesSessionRequirePasswordChange(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	conf.MustSet(ctx, config.ViperKeyPasswordMaxAge, "24h")
+
+	now := time.Now().UTC()
+	newSession := func(t *testing.T, changedAgo time.Duration, changeRequiredAgo *time.Duration, method identity.CredentialsType, authenticatedAt time.Time) *session.Session {
+		cp := identity.CredentialsPassword{HashedPassword: "$argon2id$v=19$m=32,t=2,p=4$cm94YnRVOW5jZzFzcVE4bQ$MNzk5BtR2vUhrp6qQEjRNw"}
+		changedAt := now.Add(-changedAgo)
+		cp.PasswordChangedAt = &changedAt
+		if changeRequiredAgo != nil {
+			requiredAt := now.Add(-*changeRequiredAgo)
+			cp.ChangeRequiredAt = &requiredAt
+		}
+
+		id := identity.NewIdentity("")
+		require.NoError(t, id.SetCredentialsWithConfig(identity.CredentialsTypePassword, identity.Credentials{Identifiers: []string{testhelpers.RandomEmail()}}, cp))
+		require.NoError(t, reg.IdentityManager().Create(ctx, id, identity.ManagerAllowWriteProtectedTraits))
+
+		req := testhelpers.NewTestHTTPRequest(t, "GET", "/sessions/whoami", nil)
+		s := session.NewInactiveSession()
+		s.CompletedLoginFor(method, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, s.Activate(req, id, conf, authenticatedAt))
+		return s
+	}
+	minutesAgo := func(m int) *time.Duration {
+		d := time.Duration(m) * time.Minute
+		return &d
+	}
+
+	for _, tc := range []struct {
+		d                 string
+		changedAgo        time.Duration
+		changeRequiredAgo *time.Duration
+		method            identity.CredentialsType
+		authenticatedAt   time.Time
+		required          bool
+	}{
+		{d: "password is not expired", changedAgo: time.Hour, method: identity.CredentialsTypePassword, authenticatedAt: now},
+		{d: "password is expired", changedAgo: 48 * time.Hour, method: identity.CredentialsTypePassword, authenticatedAt: now, required: true},
+		{d: "session was authenticated before the password expired", changedAgo: 48 * time.Hour, method: identity.CredentialsTypePassword, authenticatedAt: now.Add(-36 * time.Hour)},
+		{d: "session was not authenticated with the password", changedAgo: 48 * time.Hour, method: identity.CredentialsTypeOIDC, authenticatedAt: now},
+		{d: "password change is required", changedAgo: time.Hour, changeRequiredAgo: minutesAgo(10), method: identity.CredentialsTypePassword, authenticatedAt: now, required: true},
+		{d: "session was authenticated before the password change was required", changedAgo: time.Hour, changeRequiredAgo: minutesAgo(10), method: identity.CredentialsTypePassword, authenticatedAt: now.Add(-20 * time.Minute)},
+	} {
+		t.Run("case="+tc.d, func(t *testing.T) {
+			s := newSession(t, tc.changedAgo, tc.changeRequiredAgo, tc.method, tc.authenticatedAt)
+
+			err := reg.SessionManager().DoesSessionRequirePasswordChange((&http.Request{}).WithContext(ctx), s, session.WithRequestURL("https://www.ory.sh/"))
+			if !tc.required {
+				require.NoError(t, err)
+				return
+			}
+
+			var pwErr *session.ErrPasswordChangeRequired
+			require.ErrorAs(t, err, &pwErr)
+			assert.Equal(t, urlx.CopyWithQuery(urlx.AppendPaths(conf.SelfPublicURL(ctx), "/self-service/settings/browser"), url.Values{"return_to": {"https://www.ory.sh/"}}).String(), pwErr.RedirectTo)
+		})
+	}
+
+	t.Run("case=cached sessions do not load the credentials", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, true)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionCacheEnabled, false) })
+
+		s := newSession(t, 48*time.Hour, nil, identity.CredentialsTypePassword, now)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+
+		_, err := reg.SessionManager().FetchFromToken(ctx, s.Token)
+		require.NoError(t, err)
+
+		// Removed behind the persister's back, so that the cached session is not invalidated.
+		require.NoError(t, reg.Persister().GetConnection(ctx).RawQuery("DELETE FROM identity_credentials WHERE identity_id = ?", s.IdentityID).Exec())
+
+		cached, err := reg.SessionManager().FetchFromToken(ctx, s.Token)
+		require.NoError(t, err)
+		var pwErr *session.ErrPasswordChangeRequired
+		require.ErrorAs(t, reg.SessionManager().DoesSessionRequirePasswordChange((&http.Request{}).WithContext(ctx), cached), &pwErr)
+	})
+}
@@ -56,6 +56,9 @@ type Persister interface {
 	// instead of a session ID.
 	DeleteSessionByToken(context.Context, string) error
 
+	// TouchSession records that the session was last seen at the given time.
+	TouchSession(ctx context.Context, sID uuid.UUID, lastSeenAt time.Time) error
+
 	// RevokeSessionByToken marks a session inactive with the given token.
 	RevokeSessionByToken(ctx context.Context, token string) error
 
@@ -67,6 +70,24 @@ type Persister interface {
 
 	// RevokeSessionsIdentityExcept marks all except the given session of an identity inactive. It returns the number of sessions that were revoked.
 	RevokeSessionsIdentityExcept(ctx context.Context, iID, sID uuid.UUID) (int, error)
+
+	// IssueRefreshToken stores the session's token, token expiry and last seen time, marks the refresh tokens
+	// issued for the session before as used, and stores the refresh token.
+	IssueRefreshToken(ctx context.Context, s *Session, t *RefreshToken) error
+
+	// RefreshSession marks the refresh token with the given signature as used and stores the session and refresh
+	// token which refresh returns for it like IssueRefreshToken, all in one transaction. A refresh token which was
+	// used less than reuseGrace ago is accepted again if the refresh token which was issued for it was not used
+	// yet, and that refresh token is removed. Otherwise, the refresh token is returned together with
+	// ErrRefreshTokenReused and nothing is stored.
+	RefreshSession(ctx context.Context, signature string, reuseGrace time.Duration, refresh func(ctx context.Context, t *RefreshToken) (*Session, *RefreshToken, error)) (*RefreshToken, error)
+
+	// UseDPoPProof stores the ID of a DPoP proof until the proof expires. It returns sqlcon.ErrUniqueViolation if
+	// the ID is already stored.
+	UseDPoPProof(ctx context.Context, id string, expiresAt time.Time) error
+
+	// DeleteExpiredDPoPProofs deletes the IDs of DPoP proofs that expired before the given time.
+	DeleteExpiredDPoPProofs(context.Context, time.Time, int) error
 }
 
 type DevicePersister interface {
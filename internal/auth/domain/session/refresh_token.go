@@ -0,0 +1,179 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/ory/herodot"
+	"github.com/ory/x/otelx"
+	"github.com/ory/x/randx"
+	"github.com/ory/x/sqlcon"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+var (
+	// ErrRefreshTokenReused is returned by the persister if a refresh token is used a second time.
+	ErrRefreshTokenReused = errors.New("the refresh token was already used")
+
+	ErrInvalidRefreshToken   = herodot.ErrUnauthorized.WithError("invalid refresh token").WithReason("The refresh token is invalid, was already used, or its session is no longer active.")
+	ErrRefreshTokensDisabled = herodot.ErrNotFound.WithReason("Refresh tokens are disabled.")
+)
+
+// RefreshToken is a single-use token which exchanges the session token of a native session for a new one. Only
+// the signature of the token is stored.
+type RefreshToken struct {
+	ID        uuid.UUID  `json:"-" db:"id"`
+	NID       uuid.UUID  `json:"-" db:"nid"`
+	SessionID uuid.UUID  `json:"-" db:"session_id"`
+	Signature string     `json:"-" db:"signature"`
+	UsedAt    *time.Time `json:"-" db:"used_at"`
+	CreatedAt time.Time  `json:"-" db:"created_at"`
+	UpdatedAt time.Time  `json:"-" db:"updated_at"`
+
+	// PreviousID is the ID of the refresh token which was exchanged for this one.
+	PreviousID uuid.NullUUID `json:"-" db:"previous_id"`
+}
+
+func (RefreshToken) TableName(context.Context) string {
+	return "session_refresh_tokens"
+}
+
+// RefreshTokenSignature returns the value which is stored instead of the refresh token.
+func RefreshTokenSignature(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type (
+	refreshTokenManagerDependencies interface {
+		config.Provider
+		x.TracingProvider
+		x.LoggingProvider
+		PersistenceProvider
+		DPoPVerifierProvider
+	}
+	RefreshTokenManagerProvider interface {
+		SessionRefreshTokenManager() *RefreshTokenManager
+	}
+
+	// RefreshTokenManager issues and rotates the refresh tokens of native sessions. Every refresh token belongs
+	// to a session and can be used once. Using it again revokes the session, which invalidates all tokens issued
+	// for it.
+	RefreshTokenManager struct {
+		r       refreshTokenManagerDependencies
+		nowFunc func() time.Time
+	}
+)
+
+func NewRefreshTokenManager(r refreshTokenManagerDependencies) *RefreshTokenManager {
+	return &RefreshTokenManager{r: r, nowFunc: time.Now}
+}
+
+// Issue limits the lifespan of the persisted session's token and returns a refresh token for it. Refresh tokens
+// which were issued for the session before can no longer be used. Issue returns an empty token if refresh tokens
+// are disabled.
+func (m *RefreshTokenManager) Issue(ctx context.Context, s *Session) (_ string, err error) {
+	ctx, span := m.r.Tracer(ctx).Tracer().Start(ctx, "sessions.RefreshTokenManager.Issue")
+	defer otelx.End(span, &err)
+
+	if !m.r.Config().SessionRefreshTokensEnabled(ctx) {
+		return "", nil
+	}
+	return m.issue(ctx, s)
+}
+
+// Refresh exchanges the refresh token for a new session token and refresh token. The session's previous session
+// token is invalidated. If the refresh token was used before, the session is revoked, because either the client
+// or an attacker holds a stolen copy of it. Only a client which retries a refresh within the reuse grace period
+// with the most recently used refresh token gets a new pair, which invalidates the pair it did not receive. If the
+// session is bound to a DPoP key, the request must have a DPoP proof for it.
+func (m *RefreshTokenManager) Refresh(r *http.Request, token string) (_ *Session, _ string, err error) {
+	ctx, span := m.r.Tracer(r.Context()).Tracer().Start(r.Context(), "sessions.RefreshTokenManager.Refresh")
+	defer otelx.End(span, &err)
+
+	if !m.r.Config().SessionRefreshTokensEnabled(ctx) {
+		return nil, "", errors.WithStack(ErrRefreshTokensDisabled)
+	}
+
+	var s *Session
+	var refreshToken string
+	t, err := m.r.SessionPersister().RefreshSession(ctx, RefreshTokenSignature(token), m.r.Config().SessionRefreshTokensReuseGracePeriod(ctx), func(ctx context.Context, t *RefreshToken) (*Session, *RefreshToken, error) {
+		var err error
+		s, err = m.r.SessionPersister().GetSession(ctx, t.SessionID, ExpandEverything)
+		if errors.Is(err, sqlcon.ErrNoRows) {
+			return nil, nil, errors.WithStack(ErrInvalidRefreshToken)
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if !s.SetIdleExpiry(ctx, m.r.Config()).IsActive() {
+			return nil, nil, errors.WithStack(ErrInvalidRefreshToken)
+		}
+		// The refresh token is not used if the proof is invalid, so that the client can try again with a new
+		// proof.
+		if err := m.r.SessionDPoPVerifier().Verify(r.WithContext(ctx), s, ""); err != nil {
+			return nil, nil, err
+		}
+
+		now := m.nowFunc().UTC()
+		s.Token = x.OrySessionToken + randx.MustString(32, randx.AlphaNum)
+		s.LastSeenAt = &now
+		s.SetIdleExpiry(ctx, m.r.Config())
+
+		var next *RefreshToken
+		refreshToken, next = m.next(ctx, s)
+		return s, next, nil
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		m.r.Audit().
+			WithField("session_id", t.SessionID).
+			Warn("A refresh token was used more than once. The session was revoked because the refresh token may have been stolen.")
+		if err := m.r.SessionPersister().RevokeSessionById(ctx, t.SessionID); err != nil && !errors.Is(err, sqlcon.ErrNoRows) {
+			return nil, "", err
+		}
+		return nil, "", errors.WithStack(ErrInvalidRefreshToken)
+	} else if errors.Is(err, sqlcon.ErrNoRows) {
+		return nil, "", errors.WithStack(ErrInvalidRefreshToken)
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	m.r.Audit().
+		WithField("session_id", s.ID).
+		WithField("identity_id", s.IdentityID).
+		Info("A refresh token was exchanged for a new session token.")
+	return s, refreshToken, nil
+}
+
+func (m *RefreshTokenManager) issue(ctx context.Context, s *Session) (string, error) {
+	token, next := m.next(ctx, s)
+	if err := m.r.SessionPersister().IssueRefreshToken(ctx, s, next); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// next limits the lifespan of the session's token and returns a new refresh token for the session.
+func (m *RefreshTokenManager) next(ctx context.Context, s *Session) (string, *RefreshToken) {
+	expiresAt := m.nowFunc().UTC().Add(m.r.Config().SessionRefreshTokensAccessTokenLifespan(ctx))
+	if s.ExpiresAt.Before(expiresAt) {
+		expiresAt = s.ExpiresAt
+	}
+	s.TokenExpiresAt = &expiresAt
+
+	token := x.OryRefreshToken + randx.MustString(32, randx.AlphaNum)
+	return token, &RefreshToken{
+		SessionID: s.ID,
+		Signature: RefreshTokenSignature(token),
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package session_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/ory/x/sqlxx"
+
+	"my.com/secrets/internal/auth/domain/driver/config"
+	"my.com/secrets/internal/auth/domain/external"
+	"my.com/secrets/internal/auth/domain/external/testhelpers"
+	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/x"
+)
+
+func TestRefreshTokenManager(t *testing.T) {
+	ctx := context.Background()
+	conf, reg := external.NewFastRegistryWithMocks(t)
+	testhelpers.SetDefaultIdentitySchema(conf, "file://./stub/identity.schema.json")
+	conf.MustSet(ctx, config.ViperKeyPublicBaseURL, "http://localhost/")
+	conf.MustSet(ctx, config.ViperKeySessionRefreshTokensEnabled, true)
+	req := httptest.NewRequest("POST", session.RouteRefresh, nil)
+
+	newSession := func(t *testing.T) *session.Session {
+		i := identity.NewIdentity("default")
+		i.Traits = identity.Traits(`{"email":"` + x.NewUUID().String() + `@ory.sh"}`)
+		require.NoError(t, reg.PrivilegedIdentityPool().CreateIdentity(ctx, i))
+
+		s, err := session.NewActiveSession(httptest.NewRequest("GET", "/self-service/login/api", nil), i, conf, time.Now(), identity.CredentialsTypePassword, identity.AuthenticatorAssuranceLevel1)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		return s
+	}
+
+	t.Run("case=disabled", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionRefreshTokensEnabled, false)
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionRefreshTokensEnabled, true) })
+
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+		assert.Empty(t, token)
+		assert.Nil(t, s.TokenExpiresAt)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, "ory_rt_token")
+		require.ErrorIs(t, err, session.ErrRefreshTokensDisabled)
+	})
+
+	t.Run("case=issue limits the session token", func(t *testing.T) {
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+		assert.Regexp(t, "^"+x.OryRefreshToken, token)
+		require.NotNil(t, s.TokenExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(conf.SessionRefreshTokensAccessTokenLifespan(ctx)), *s.TokenExpiresAt, 5*time.Second)
+
+		_, err = reg.SessionManager().FetchFromToken(ctx, s.Token)
+		require.NoError(t, err)
+	})
+
+	t.Run("case=expired session token", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionRefreshTokensAccessTokenLifespan, "1ns")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionRefreshTokensAccessTokenLifespan, "15m") })
+
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		_, err = reg.SessionManager().FetchFromToken(ctx, s.Token)
+		require.ErrorAs(t, err, new(*session.ErrNoActiveSessionFound))
+
+		refreshed, _, err := reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err)
+		assert.Equal(t, s.ID, refreshed.ID, "the session outlives its session tokens")
+	})
+
+	t.Run("case=refresh rotates both tokens", func(t *testing.T) {
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		refreshed, next, err := reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err)
+		assert.Equal(t, s.ID, refreshed.ID)
+		assert.NotEqual(t, s.Token, refreshed.Token)
+		assert.NotEqual(t, token, next)
+
+		_, err = reg.SessionManager().FetchFromToken(ctx, s.Token)
+		require.ErrorAs(t, err, new(*session.ErrNoActiveSessionFound), "the previous session token must be invalidated")
+		_, err = reg.SessionManager().FetchFromToken(ctx, refreshed.Token)
+		require.NoError(t, err)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, next)
+		require.NoError(t, err)
+	})
+
+	t.Run("case=reuse revokes the session", func(t *testing.T) {
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		refreshed, next, err := reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.False(t, actual.Active)
+
+		_, err = reg.SessionManager().FetchFromToken(ctx, refreshed.Token)
+		require.ErrorAs(t, err, new(*session.ErrNoActiveSessionFound))
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, next)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken, "the refresh token issued after the reused one must be invalidated too")
+	})
+
+	t.Run("case=retry within the reuse grace period", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionRefreshTokensReuseGracePeriod, "1m")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionRefreshTokensReuseGracePeriod, "0s") })
+
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		lost, lostNext, err := reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err)
+		retried, next, err := reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err, "the client did not receive the response to its first refresh")
+		assert.Equal(t, s.ID, retried.ID)
+
+		_, err = reg.SessionManager().FetchFromToken(ctx, lost.Token)
+		require.ErrorAs(t, err, new(*session.ErrNoActiveSessionFound), "the session token which the client did not receive must be invalidated")
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, lostNext)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken, "the refresh token which the client did not receive must be invalidated")
+		_, err = reg.SessionManager().FetchFromToken(ctx, retried.Token)
+		require.NoError(t, err)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, next)
+		require.NoError(t, err)
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.False(t, actual.Active, "reusing a refresh token whose successor was used revokes the session")
+	})
+
+	t.Run("case=reuse after the reuse grace period revokes the session", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionRefreshTokensReuseGracePeriod, "1ns")
+		t.Cleanup(func() { conf.MustSet(ctx, config.ViperKeySessionRefreshTokensReuseGracePeriod, "0s") })
+
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.NoError(t, err)
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+		actual, err := reg.SessionPersister().GetSession(ctx, s.ID, session.ExpandNothing)
+		require.NoError(t, err)
+		assert.False(t, actual.Active)
+	})
+
+	t.Run("case=inactive session", func(t *testing.T) {
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+		require.NoError(t, reg.SessionPersister().RevokeSessionById(ctx, s.ID))
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+	})
+
+	t.Run("case=session bound to a DPoP key", func(t *testing.T) {
+		key, jkt := newDPoPKey(t)
+		s := newSession(t)
+		s.DPoPJKT = sqlxx.NullString(jkt)
+		require.NoError(t, reg.SessionPersister().UpsertSession(ctx, s))
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		_, _, err = reg.SessionRefreshTokenManager().Refresh(req, token)
+		require.ErrorAs(t, err, new(*session.ErrInvalidDPoPProof))
+
+		withProof := httptest.NewRequest("POST", session.RouteRefresh, nil)
+		withProof.Header.Set("DPoP", newDPoPProof(t, key, "POST", "http://localhost"+session.RouteRefresh))
+		refreshed, _, err := reg.SessionRefreshTokenManager().Refresh(withProof, token)
+		require.NoError(t, err, "the refresh token must not be used if the proof is missing")
+		assert.Equal(t, s.DPoPJKT, refreshed.DPoPJKT)
+	})
+
+	t.Run("case=unknown refresh token", func(t *testing.T) {
+		_, _, err := reg.SessionRefreshTokenManager().Refresh(req, "ory_rt_unknown")
+		require.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+	})
+
+	t.Run("case=endpoint", func(t *testing.T) {
+		public := x.NewRouterPublic()
+		reg.SessionHandler().RegisterPublicRoutes(public)
+		ts := httptest.NewServer(public)
+		t.Cleanup(ts.Close)
+
+		refresh := func(t *testing.T, token string) (int, string) {
+			body, err := json.Marshal(map[string]string{"refresh_token": token})
+			require.NoError(t, err)
+
+			res, err := ts.Client().Post(ts.URL+session.RouteRefresh, "application/json", bytes.NewReader(body))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			return res.StatusCode, string(x.MustReadAll(res.Body))
+		}
+
+		s := newSession(t)
+		token, err := reg.SessionRefreshTokenManager().Issue(ctx, s)
+		require.NoError(t, err)
+
+		status, body := refresh(t, token)
+		require.Equal(t, http.StatusOK, status, body)
+		assert.NotEmpty(t, gjson.Get(body, "session_token").String(), body)
+		assert.NotEqual(t, s.Token, gjson.Get(body, "session_token").String(), body)
+		assert.NotEmpty(t, gjson.Get(body, "refresh_token").String(), body)
+		assert.NotEmpty(t, gjson.Get(body, "session_token_expires_at").String(), body)
+		assert.Equal(t, s.ID.String(), gjson.Get(body, "session.id").String(), body)
+		assert.False(t, gjson.Get(body, "session.identity.credentials").Exists(), body)
+
+		status, body = refresh(t, token)
+		assert.Equal(t, http.StatusUnauthorized, status, body)
+
+		status, body = refresh(t, "")
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	})
+}
@@ -9,13 +9,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
 	"my.com/secrets/internal/auth/domain/x"
 
-	"github.com/ory/x/httpx"
 	"github.com/ory/x/pagination/keysetpagination"
+	"github.com/ory/x/pointerx"
+	"github.com/ory/x/sqlxx"
 	"github.com/ory/x/stringsx"
 
 	"github.com/pkg/errors"
@@ -29,14 +31,30 @@ import (
 
 var ErrIdentityDisabled = herodot.ErrUnauthorized.WithError("identity is disabled").WithReason("This account was disabled.")
 
+// The policies which apply when a new session would exceed the maximum number of concurrent sessions of an identity.
+const (
+	MaxConcurrentPolicyEvictOldest            = "evict_oldest"
+	MaxConcurrentPolicyEvictLeastRecentlyUsed = "evict_least_recently_used"
+	MaxConcurrentPolicyReject                 = "reject"
+)
+
 type lifespanProvider interface {
 	SessionLifespan(ctx context.Context) time.Duration
 }
 
+type activationProvider interface {
+	lifespanProvider
+	SessionDeviceTrustedProxies(ctx context.Context) []netip.Prefix
+}
+
 type refreshWindowProvider interface {
 	SessionRefreshMinTimeLeft(ctx context.Context) time.Duration
 }
 
+type idleTimeoutProvider interface {
+	SessionIdleTimeout(ctx context.Context, aal string) time.Duration
+}
+
 // Device corresponding to a Session
 //
 // swagger:model sessionDevice
@@ -58,6 +76,15 @@ type Device struct {
 	// Geo Location corresponding to the IP Address
 	Location *string `json:"location" faker:"ptr_geo_location" db:"location"`
 
+	// City of the client, resolved from the IP Address
+	City *string `json:"city,omitempty" faker:"-" db:"city"`
+
+	// Country of the client as ISO 3166-1 alpha-2 code, resolved from the IP Address
+	Country *string `json:"country,omitempty" faker:"-" db:"country"`
+
+	// ASN is the autonomous system number of the client's network, resolved from the IP Address
+	ASN *int64 `json:"asn,omitempty" faker:"-" db:"asn"`
+
 	// Time of capture
 	CreatedAt time.Time `json:"-" faker:"-" db:"created_at"`
 
@@ -71,6 +98,62 @@ func (m Device) TableName(ctx context.Context) string {
 	return "session_devices"
 }
 
+// RiskAssessment is the result of scoring a login against the devices of the identity's previous sessions.
+//
+// swagger:model sessionRiskAssessment
+type RiskAssessment struct {
+	// Score is the risk score of the login.
+	//
+	// required: true
+	Score float64 `json:"score"`
+
+	// Threshold is the score at or above which a second factor is required.
+	//
+	// required: true
+	Threshold float64 `json:"threshold"`
+
+	// StepUpRequired is true if the session requires the highest available authenticator assurance level.
+	//
+	// required: true
+	StepUpRequired bool `json:"step_up_required"`
+
+	// Reasons lists the rules which matched, for example `new_country` or `impossible_travel`.
+	//
+	// required: true
+	Reasons []string `json:"reasons"`
+
+	// AssessedAt is the time of the assessment.
+	//
+	// required: true
+	AssessedAt time.Time `json:"assessed_at"`
+}
+
+// Scan implements the Scanner interface.
+func (r *RiskAssessment) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	v := fmt.Sprintf("%s", value)
+	if len(v) == 0 {
+		return nil
+	}
+	return errors.WithStack(json.Unmarshal([]byte(v), r))
+}
+
+// Value implements the driver Valuer interface.
+func (r RiskAssessment) Value() (driver.Value, error) {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(value), nil
+}
+
+// RequiresStepUp returns true if the risk assessment of the login requires a second factor.
+func (s *Session) RequiresStepUp() bool {
+	return s.RiskAssessment != nil && s.RiskAssessment.StepUpRequired
+}
+
 // A Session
 //
 // swagger:model session
@@ -115,6 +198,24 @@ type Session struct {
 	// When this session was issued at. Usually equal or close to `authenticated_at`.
 	IssuedAt time.Time `json:"issued_at" db:"issued_at" faker:"time_type"`
 
+	// The Session Last Seen Timestamp
+	//
+	// When this session was last used. It is updated at most once per configured touch interval and is
+	// not set for sessions which have not been used since idle timeouts were introduced.
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at" faker:"-"`
+
+	// The Session Idle Expiry
+	//
+	// When this session expires unless it is used again. Only set if an idle timeout is configured for
+	// the session's authenticator assurance level.
+	IdleExpiresAt *time.Time `json:"idle_expires_at,omitempty" db:"-" faker:"-"`
+
+	// The Login Risk Assessment
+	//
+	// The result of the risk assessment of the login which issued this session. Only set if risk-based login
+	// is enabled.
+	RiskAssessment *RiskAssessment `json:"risk_assessment,omitempty" db:"risk_assessment" faker:"-"`
+
 	// The Logout Token
 	//
 	// Use this token to log out a user.
@@ -150,6 +251,20 @@ type Session struct {
 	// The token of this session.
 	Token string    `json:"-" db:"token"`
 	NID   uuid.UUID `json:"-"  faker:"-" db:"nid"`
+
+	// TokenExpiresAt is when the session token expires, if it was issued together with a refresh token. The
+	// session itself remains active until ExpiresAt and the refresh token exchanges it for a new session token.
+	TokenExpiresAt *time.Time `json:"-" db:"token_expires_at" faker:"-"`
+
+	// DPoPJKT is the JWK SHA-256 thumbprint of the key the session is bound to. Requests which use a bound session
+	// must carry a DPoP proof signed with the key.
+	DPoPJKT sqlxx.NullString `json:"-" db:"dpop_jkt" faker:"-"`
+
+	// passwordChange holds the schema, organization and password credentials of the identity of sessions which
+	// were authenticated with the password. It is kept with cached sessions, so that checking whether the password
+	// must be changed does not load the identity's credentials on every request. Updating the identity invalidates
+	// its cached sessions.
+	passwordChange *identity.Identity `db:"-"`
 }
 
 func (s Session) PageToken() keysetpagination.PageToken {
@@ -243,7 +358,7 @@ func (s *Session) SetAuthenticatorAssuranceLevel() {
 	}
 }
 
-func NewActiveSession(r *http.Request, i *identity.Identity, c lifespanProvider, authenticatedAt time.Time, completedLoginFor identity.CredentialsType, completedLoginAAL identity.AuthenticatorAssuranceLevel) (*Session, error) {
+func NewActiveSession(r *http.Request, i *identity.Identity, c activationProvider, authenticatedAt time.Time, completedLoginFor identity.CredentialsType, completedLoginAAL identity.AuthenticatorAssuranceLevel) (*Session, error) {
 	s := NewInactiveSession()
 	s.CompletedLoginFor(completedLoginFor, completedLoginAAL)
 	if err := s.Activate(r, i, c, authenticatedAt); err != nil {
@@ -262,7 +377,7 @@ func NewInactiveSession() *Session {
 	}
 }
 
-func (s *Session) Activate(r *http.Request, i *identity.Identity, c lifespanProvider, authenticatedAt time.Time) error {
+func (s *Session) Activate(r *http.Request, i *identity.Identity, c activationProvider, authenticatedAt time.Time) error {
 	if i != nil && !i.IsActive() {
 		return ErrIdentityDisabled.WithDetail("identity_id", i.ID)
 	}
@@ -271,18 +386,22 @@ func (s *Session) Activate(r *http.Request, i *identity.Identity, c lifespanProv
 	s.ExpiresAt = authenticatedAt.Add(c.SessionLifespan(r.Context()))
 	s.AuthenticatedAt = authenticatedAt
 	s.IssuedAt = authenticatedAt
+	s.LastSeenAt = pointerx.Ptr(authenticatedAt)
 	s.Identity = i
 	s.IdentityID = i.ID
 
-	s.SetSessionDeviceInformation(r)
+	s.SetSessionDeviceInformation(r, c.SessionDeviceTrustedProxies(r.Context()))
 	s.SetAuthenticatorAssuranceLevel()
 	return nil
 }
 
-func (s *Session) SetSessionDeviceInformation(r *http.Request) {
+// SetSessionDeviceInformation records the device of the request. The client IP is taken from the forwarding headers
+// set by the trusted proxies. City, country and ASN which are not known yet are resolved when the session is
+// persisted.
+func (s *Session) SetSessionDeviceInformation(r *http.Request, trustedProxies []netip.Prefix) {
 	device := Device{
 		SessionID: s.ID,
-		IPAddress: stringsx.GetPointer(httpx.ClientIP(r)),
+		IPAddress: stringsx.GetPointer(x.ClientIP(r, trustedProxies)),
 	}
 
 	agent := r.Header["User-Agent"]
@@ -291,11 +410,13 @@ func (s *Session) SetSessionDeviceInformation(r *http.Request) {
 	}
 
 	var clientGeoLocation []string
-	if r.Header.Get("Cf-Ipcity") != "" {
-		clientGeoLocation = append(clientGeoLocation, r.Header.Get("Cf-Ipcity"))
+	if city := r.Header.Get("Cf-Ipcity"); city != "" {
+		clientGeoLocation = append(clientGeoLocation, city)
+		device.City = pointerx.Ptr(city)
 	}
-	if r.Header.Get("Cf-Ipcountry") != "" {
-		clientGeoLocation = append(clientGeoLocation, r.Header.Get("Cf-Ipcountry"))
+	if country := r.Header.Get("Cf-Ipcountry"); country != "" {
+		clientGeoLocation = append(clientGeoLocation, country)
+		device.Country = pointerx.Ptr(country)
 	}
 	device.Location = stringsx.GetPointer(strings.Join(clientGeoLocation, ", "))
 
@@ -308,11 +429,37 @@ func (s Session) Declassified() *Session {
 }
 
 func (s *Session) IsActive() bool {
-	return s.Active && s.ExpiresAt.After(time.Now()) && (s.Identity == nil || s.Identity.IsActive())
+	return s.Active && s.ExpiresAt.After(time.Now()) && !s.IsIdle() && (s.Identity == nil || s.Identity.IsActive())
+}
+
+// IsIdle returns true if the session was not used before its idle expiry.
+func (s *Session) IsIdle() bool {
+	return s.IdleExpiresAt != nil && !s.IdleExpiresAt.After(time.Now())
+}
+
+// SetIdleExpiry computes the idle expiry from the time the session was last seen and the idle timeout of the
+// session's authenticator assurance level. Sessions which were never seen have no idle expiry.
+func (s *Session) SetIdleExpiry(ctx context.Context, c idleTimeoutProvider) *Session {
+	s.IdleExpiresAt = nil
+	if s.LastSeenAt == nil {
+		return s
+	}
+
+	if timeout := c.SessionIdleTimeout(ctx, string(s.AuthenticatorAssuranceLevel)); timeout > 0 {
+		s.IdleExpiresAt = pointerx.Ptr(s.LastSeenAt.Add(timeout).UTC())
+	}
+	return s
+}
+
+// NeedsTouch returns true if the session was last seen longer ago than the touch interval.
+func (s *Session) NeedsTouch(now time.Time, interval time.Duration) bool {
+	return s.LastSeenAt == nil || !s.LastSeenAt.Add(interval).After(now)
 }
 
 func (s *Session) Refresh(ctx context.Context, c lifespanProvider) *Session {
-	s.ExpiresAt = time.Now().Add(c.SessionLifespan(ctx)).UTC()
+	now := time.Now().UTC()
+	s.ExpiresAt = now.Add(c.SessionLifespan(ctx))
+	s.LastSeenAt = &now
 	return s
 }
 
@@ -47,6 +47,61 @@ func TestSession(t *testing.T) {
 		assert.False(t, (&session.Session{Active: true}).IsActive())
 	})
 
+	t.Run("case=risk assessment", func(t *testing.T) {
+		assert.False(t, (&session.Session{}).RequiresStepUp())
+		assert.False(t, (&session.Session{RiskAssessment: &session.RiskAssessment{Score: 20, Threshold: 50}}).RequiresStepUp())
+
+		expected := session.RiskAssessment{Score: 80, Threshold: 50, StepUpRequired: true, Reasons: []string{"new_country"}, AssessedAt: authAt.UTC().Round(time.Second)}
+		value, err := expected.Value()
+		require.NoError(t, err)
+
+		var actual session.RiskAssessment
+		require.NoError(t, actual.Scan(value))
+		assert.Equal(t, expected, actual)
+		assert.True(t, (&session.Session{RiskAssessment: &actual}).RequiresStepUp())
+	})
+
+	t.Run("case=idle timeout", func(t *testing.T) {
+		conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, "1h")
+		conf.MustSet(ctx, "session.idle_timeout.aal2", "10m")
+		t.Cleanup(func() {
+			conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, nil)
+			conf.MustSet(ctx, "session.idle_timeout.aal2", nil)
+		})
+
+		newSession := func(aal identity.AuthenticatorAssuranceLevel, lastSeen *time.Time) *session.Session {
+			return &session.Session{Active: true, ExpiresAt: time.Now().Add(24 * time.Hour), AuthenticatorAssuranceLevel: aal, LastSeenAt: lastSeen}
+		}
+		ago := func(d time.Duration) *time.Time {
+			t := time.Now().Add(-d)
+			return &t
+		}
+
+		s := newSession(identity.AuthenticatorAssuranceLevel1, ago(30*time.Minute)).SetIdleExpiry(ctx, conf)
+		require.NotNil(t, s.IdleExpiresAt)
+		assert.WithinDuration(t, s.LastSeenAt.Add(time.Hour), *s.IdleExpiresAt, time.Second)
+		assert.True(t, s.IsActive())
+
+		s = newSession(identity.AuthenticatorAssuranceLevel2, ago(30*time.Minute)).SetIdleExpiry(ctx, conf)
+		assert.True(t, s.IsIdle())
+		assert.False(t, s.IsActive(), "aal2 sessions use the shorter idle timeout")
+
+		s = newSession(identity.AuthenticatorAssuranceLevel1, nil).SetIdleExpiry(ctx, conf)
+		assert.Nil(t, s.IdleExpiresAt, "sessions which were never seen have no idle expiry")
+		assert.True(t, s.IsActive())
+
+		conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, "0s")
+		conf.MustSet(ctx, "session.idle_timeout.aal2", nil)
+		s = newSession(identity.AuthenticatorAssuranceLevel2, ago(48*time.Hour)).SetIdleExpiry(ctx, conf)
+		assert.Nil(t, s.IdleExpiresAt)
+		assert.True(t, s.IsActive())
+
+		now := time.Now()
+		assert.True(t, newSession(identity.AuthenticatorAssuranceLevel1, nil).NeedsTouch(now, time.Minute))
+		assert.True(t, newSession(identity.AuthenticatorAssuranceLevel1, ago(2*time.Minute)).NeedsTouch(now, time.Minute))
+		assert.False(t, newSession(identity.AuthenticatorAssuranceLevel1, ago(time.Second)).NeedsTouch(now, time.Minute))
+	})
+
 	t.Run("case=amr", func(t *testing.T) {
 		s := session.NewInactiveSession()
 		s.CompletedLoginFor(identity.CredentialsTypeOIDC, identity.AuthenticatorAssuranceLevel1)
@@ -5,6 +5,7 @@ package test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -26,7 +27,9 @@ import (
 	"my.com/secrets/internal/auth/domain/driver/config"
 	"my.com/secrets/internal/auth/domain/external/testhelpers"
 	"my.com/secrets/internal/auth/domain/persistence"
+	"my.com/secrets/internal/auth/domain/schema"
 	"my.com/secrets/internal/auth/domain/session"
+	"my.com/secrets/internal/auth/domain/text"
 	"my.com/secrets/internal/auth/domain/x"
 )
 
@@ -133,6 +136,16 @@ func TestPersister(ctx context.Context, conf *config.Config, p interface {
 				check(actual, err)
 				assert.Empty(t, actual.AMR)
 			})
+
+			t.Run("case=bind session to dpop key", func(t *testing.T) {
+				assert.Empty(t, expected.DPoPJKT)
+				expected.DPoPJKT = "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"
+				require.NoError(t, p.UpsertSession(ctx, &expected))
+
+				actual, err := p.GetSessionByToken(ctx, expected.Token, session.ExpandDefault, identity.ExpandDefault)
+				check(actual, err)
+				assert.Equal(t, expected.DPoPJKT, actual.DPoPJKT)
+			})
 		})
 
 		t.Run("case=list sessions", func(t *testing.T) {
@@ -400,6 +413,134 @@ func TestPersister(ctx context.Context, conf *config.Config, p interface {
 			require.Error(t, err)
 		})
 
+		t.Run("case=max concurrent sessions", func(t *testing.T) {
+			conf.MustSet(ctx, config.ViperKeySessionMaxConcurrent, 2)
+			t.Cleanup(func() {
+				conf.MustSet(ctx, config.ViperKeySessionMaxConcurrent, nil)
+				conf.MustSet(ctx, config.ViperKeySessionMaxConcurrentPolicy, nil)
+			})
+
+			var i identity.Identity
+			require.NoError(t, faker.FakeData(&i))
+			require.NoError(t, p.CreateIdentity(ctx, &i))
+
+			issue := func(t *testing.T, authenticatedAt time.Time, lastSeenAt *time.Time) (*session.Session, error) {
+				var s session.Session
+				require.NoError(t, faker.FakeData(&s))
+				s.ID = uuid.Nil
+				s.Active = true
+				s.ExpiresAt = time.Now().Add(time.Hour)
+				s.AuthenticatedAt = authenticatedAt
+				s.LastSeenAt = lastSeenAt
+				s.Identity = &i
+				s.IdentityID = i.ID
+				return &s, p.UpsertSession(ctx, &s)
+			}
+			isActive := func(t *testing.T, s *session.Session) bool {
+				actual, err := p.GetSession(ctx, s.ID, session.ExpandNothing)
+				require.NoError(t, err)
+				return actual.Active
+			}
+			reset := func(t *testing.T) {
+				_, err := p.RevokeSessionsIdentityExcept(ctx, i.ID, uuid.Nil)
+				require.NoError(t, err)
+			}
+
+			t.Run("policy=evict_oldest", func(t *testing.T) {
+				reset(t)
+				conf.MustSet(ctx, config.ViperKeySessionMaxConcurrentPolicy, session.MaxConcurrentPolicyEvictOldest)
+
+				oldest, err := issue(t, time.Now().Add(-time.Hour), nil)
+				require.NoError(t, err)
+				older, err := issue(t, time.Now().Add(-time.Minute), nil)
+				require.NoError(t, err)
+				latest, err := issue(t, time.Now(), nil)
+				require.NoError(t, err)
+
+				assert.False(t, isActive(t, oldest))
+				assert.True(t, isActive(t, older))
+				assert.True(t, isActive(t, latest))
+			})
+
+			t.Run("policy=evict_least_recently_used", func(t *testing.T) {
+				reset(t)
+				conf.MustSet(ctx, config.ViperKeySessionMaxConcurrentPolicy, session.MaxConcurrentPolicyEvictLeastRecentlyUsed)
+
+				recentlyUsed, err := issue(t, time.Now().Add(-time.Hour), pointerx.Ptr(time.Now()))
+				require.NoError(t, err)
+				unused, err := issue(t, time.Now().Add(-time.Minute), nil)
+				require.NoError(t, err)
+				latest, err := issue(t, time.Now(), nil)
+				require.NoError(t, err)
+
+				assert.True(t, isActive(t, recentlyUsed))
+				assert.False(t, isActive(t, unused))
+				assert.True(t, isActive(t, latest))
+			})
+
+			t.Run("policy=reject", func(t *testing.T) {
+				reset(t)
+				conf.MustSet(ctx, config.ViperKeySessionMaxConcurrentPolicy, session.MaxConcurrentPolicyReject)
+
+				first, err := issue(t, time.Now(), nil)
+				require.NoError(t, err)
+				second, err := issue(t, time.Now(), nil)
+				require.NoError(t, err)
+				_, err = issue(t, time.Now(), nil)
+				var validationErr *schema.ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.EqualValues(t, text.ErrorValidationLoginMaxConcurrentSessions, validationErr.Messages[0].ID)
+
+				assert.True(t, isActive(t, first))
+				assert.True(t, isActive(t, second))
+
+				t.Run("case=revoked sessions do not count", func(t *testing.T) {
+					require.NoError(t, p.RevokeSessionById(ctx, first.ID))
+					_, err := issue(t, time.Now(), nil)
+					require.NoError(t, err)
+				})
+			})
+		})
+
+		t.Run("case=touch session", func(t *testing.T) {
+			var expected session.Session
+			require.NoError(t, faker.FakeData(&expected))
+			expected.Active = true
+			expected.ExpiresAt = time.Now().Add(time.Hour)
+			expected.AuthenticatorAssuranceLevel = identity.AuthenticatorAssuranceLevel1
+			require.NoError(t, p.CreateIdentity(ctx, expected.Identity))
+			require.NoError(t, p.UpsertSession(ctx, &expected))
+
+			actual, err := p.GetSession(ctx, expected.ID, session.ExpandNothing)
+			require.NoError(t, err)
+			assert.Nil(t, actual.LastSeenAt)
+			assert.Nil(t, actual.IdleExpiresAt)
+
+			lastSeen := time.Now().Add(-time.Hour).UTC().Round(time.Second)
+			require.NoError(t, p.TouchSession(ctx, expected.ID, lastSeen))
+
+			t.Run("on another network", func(t *testing.T) {
+				_, other := testhelpers.NewNetwork(t, ctx, p)
+				require.NoError(t, other.TouchSession(ctx, expected.ID, time.Now()))
+			})
+
+			actual, err = p.GetSessionByToken(ctx, expected.Token, session.ExpandNothing, identity.ExpandDefault)
+			require.NoError(t, err)
+			require.NotNil(t, actual.LastSeenAt)
+			assert.Equal(t, lastSeen.Unix(), actual.LastSeenAt.Unix())
+			assert.True(t, actual.IsActive())
+
+			conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, "30m")
+			t.Cleanup(func() {
+				conf.MustSet(ctx, config.ViperKeySessionIdleTimeout, nil)
+			})
+
+			actual, err = p.GetSession(ctx, expected.ID, session.ExpandNothing)
+			require.NoError(t, err)
+			require.NotNil(t, actual.IdleExpiresAt)
+			assert.False(t, actual.Active, "sessions idle past the idle timeout are inactive")
+		})
+
 		t.Run("case=revoke session by token", func(t *testing.T) {
 			var expected session.Session
 			require.NoError(t, faker.FakeData(&expected))
@@ -456,6 +597,127 @@ func TestPersister(ctx context.Context, conf *config.Config, p interface {
 			assert.False(t, actual.Active)
 		})
 
+		t.Run("case=refresh tokens", func(t *testing.T) {
+			var expected session.Session
+			require.NoError(t, faker.FakeData(&expected))
+			expected.Active = true
+			require.NoError(t, p.CreateIdentity(ctx, expected.Identity))
+			require.NoError(t, p.UpsertSession(ctx, &expected))
+
+			issue := func(t *testing.T) (string, time.Time) {
+				token, signature := x.OrySessionToken+randx.MustString(32, randx.AlphaNum), session.RefreshTokenSignature(randx.MustString(32, randx.AlphaNum))
+				expiresAt := time.Now().Add(time.Minute).UTC().Round(time.Second)
+				expected.Token, expected.TokenExpiresAt = token, &expiresAt
+				require.NoError(t, p.IssueRefreshToken(ctx, &expected, &session.RefreshToken{Signature: signature}))
+				return signature, expiresAt
+			}
+
+			first, expiresAt := issue(t)
+			actual, err := p.GetSessionByToken(ctx, expected.Token, session.ExpandNothing, identity.ExpandDefault)
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, actual.ID)
+			require.NotNil(t, actual.TokenExpiresAt)
+			assert.Equal(t, expiresAt.Unix(), actual.TokenExpiresAt.Unix())
+
+			// use refreshes the session with the refresh token and returns the signature of the next one.
+			use := func(p persistence.Persister, signature string, reuseGrace time.Duration) (string, *session.RefreshToken, error) {
+				var next string
+				used, err := p.RefreshSession(ctx, signature, reuseGrace, func(ctx context.Context, rt *session.RefreshToken) (*session.Session, *session.RefreshToken, error) {
+					assert.Equal(t, expected.ID, rt.SessionID)
+					assert.NotNil(t, rt.UsedAt)
+					next = session.RefreshTokenSignature(randx.MustString(32, randx.AlphaNum))
+					expected.Token = x.OrySessionToken + randx.MustString(32, randx.AlphaNum)
+					return &expected, &session.RefreshToken{Signature: next}, nil
+				})
+				return next, used, err
+			}
+
+			t.Run("on another network", func(t *testing.T) {
+				_, other := testhelpers.NewNetwork(t, ctx, p)
+				_, _, err := use(other, first, 0)
+				assert.ErrorIs(t, err, sqlcon.ErrNoRows)
+				assert.ErrorIs(t, other.IssueRefreshToken(ctx, &expected, &session.RefreshToken{Signature: session.RefreshTokenSignature("other")}), sqlcon.ErrNoRows)
+			})
+
+			second, used, err := use(p, first, 0)
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, used.SessionID)
+			assert.NotNil(t, used.UsedAt)
+			actual, err = p.GetSessionByToken(ctx, expected.Token, session.ExpandNothing, identity.ExpandDefault)
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, actual.ID)
+
+			next, reused, err := use(p, first, 0)
+			require.ErrorIs(t, err, session.ErrRefreshTokenReused)
+			assert.Equal(t, expected.ID, reused.SessionID)
+			assert.Empty(t, next, "the session must not be refreshed if the refresh token was reused")
+
+			_, _, err = use(p, session.RefreshTokenSignature("unknown"), 0)
+			require.ErrorIs(t, err, sqlcon.ErrNoRows)
+
+			t.Run("the refresh function fails", func(t *testing.T) {
+				failed := errors.New("refresh failed")
+				_, err := p.RefreshSession(ctx, second, 0, func(context.Context, *session.RefreshToken) (*session.Session, *session.RefreshToken, error) {
+					return nil, nil, failed
+				})
+				require.ErrorIs(t, err, failed)
+
+				_, _, err = use(p, second, 0)
+				require.NoError(t, err, "the refresh token is not used if the refresh fails")
+			})
+
+			t.Run("reuse within the grace period", func(t *testing.T) {
+				_, _, err := use(p, first, time.Hour)
+				require.ErrorIs(t, err, session.ErrRefreshTokenReused, "the refresh token which was issued for it was used")
+
+				last, _ := issue(t)
+				lost, _, err := use(p, last, time.Hour)
+				require.NoError(t, err)
+
+				retried, _, err := use(p, last, time.Hour)
+				require.NoError(t, err)
+				_, _, err = use(p, lost, time.Hour)
+				require.ErrorIs(t, err, sqlcon.ErrNoRows, "the retry removes the refresh token which the client did not receive")
+
+				_, _, err = use(p, last, 0)
+				require.ErrorIs(t, err, session.ErrRefreshTokenReused, "the grace period is over")
+
+				_, _, err = use(p, retried, time.Hour)
+				require.NoError(t, err)
+				_, _, err = use(p, last, time.Hour)
+				require.ErrorIs(t, err, session.ErrRefreshTokenReused, "the refresh token which was issued for it was used")
+			})
+
+			superseded, _ := issue(t)
+			latest, _ := issue(t)
+			_, _, err = use(p, superseded, 0)
+			require.ErrorIs(t, err, session.ErrRefreshTokenReused, "issuing a refresh token marks the previous ones as used")
+			latest, _, err = use(p, latest, 0)
+			require.NoError(t, err)
+
+			t.Run("deleting the session deletes its refresh tokens", func(t *testing.T) {
+				require.NoError(t, p.DeleteSession(ctx, expected.ID))
+				_, _, err = use(p, latest, 0)
+				require.ErrorIs(t, err, sqlcon.ErrNoRows)
+			})
+		})
+
+		t.Run("case=dpop proofs", func(t *testing.T) {
+			id := randx.MustString(64, randx.AlphaLowerNum)
+			require.NoError(t, p.UseDPoPProof(ctx, id, time.Now().Add(-time.Minute)))
+			require.ErrorIs(t, p.UseDPoPProof(ctx, id, time.Now().Add(time.Minute)), sqlcon.ErrUniqueViolation)
+
+			t.Run("on another network", func(t *testing.T) {
+				_, other := testhelpers.NewNetwork(t, ctx, p)
+				require.NoError(t, other.UseDPoPProof(ctx, id, time.Now().Add(time.Minute)))
+			})
+
+			require.NoError(t, p.DeleteExpiredDPoPProofs(ctx, time.Now(), 100))
+			require.NoError(t, p.UseDPoPProof(ctx, id, time.Now().Add(time.Minute)), "expired proofs are deleted")
+			require.NoError(t, p.DeleteExpiredDPoPProofs(ctx, time.Now(), 100))
+			require.ErrorIs(t, p.UseDPoPProof(ctx, id, time.Now().Add(time.Minute)), sqlcon.ErrUniqueViolation)
+		})
+
 		t.Run("method=revoke other sessions for identity", func(t *testing.T) {
 			// here we set up 2 identities with each having 2 sessions
 			sessions := make([]session.Session, 4)
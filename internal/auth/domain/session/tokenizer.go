@@ -11,6 +11,7 @@ import (
 	"github.com/dgraph-io/ristretto"
 	"github.com/gofrs/uuid"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
 	"go.opentelemetry.io/otel/trace"
@@ -21,6 +22,7 @@ import (
 	"github.com/ory/x/jwksx"
 	"github.com/ory/x/otelx"
 	"my.com/secrets/internal/auth/domain/driver/config"
+	signingkey "my.com/secrets/internal/auth/domain/jwk"
 	"my.com/secrets/internal/auth/domain/x"
 	"my.com/secrets/internal/auth/domain/x/events"
 )
@@ -32,6 +34,7 @@ type (
 		x.HTTPClientProvider
 		config.Provider
 		x.JWKSFetchProvider
+		signingkey.ManagementProvider
 	}
 	Tokenizer struct {
 		r       tokenizerDependencies
@@ -66,20 +69,12 @@ func (s *Tokenizer) TokenizeSession(ctx context.Context, template string, sessio
 	}
 
 	httpClient := s.r.HTTPClient(ctx)
-	key, err := s.r.JWKSFetcher().ResolveKey(
-		ctx,
-		tpl.JWKSURL,
-		jwksx.WithCacheEnabled(),
-		jwksx.WithCacheTTL(time.Hour),
-		jwksx.WithHTTPClient(httpClient))
+	key, err := s.signingKey(ctx, tpl)
 	if err != nil {
-		if errors.Is(err, jwksx.ErrUnableToFindKeyID) {
-			return errors.WithStack(herodot.ErrBadRequest.WithReasonf("Could not find key a suitable key for tokenization in the JWKS url."))
-		}
 		return err
 	}
 
-	alg := jwt.GetSigningMethod(key.Algorithm())
+	alg := jwt.GetSigningMethod(key.Algorithm().String())
 	if alg == nil {
 		return errors.WithStack(herodot.ErrBadRequest.WithReasonf("The JSON Web Key must include a valid \"alg\" parameter but \"%s\" was given.", key.Algorithm()))
 	}
@@ -153,3 +148,38 @@ func (s *Tokenizer) TokenizeSession(ctx context.Context, template string, sessio
 	session.Tokenized = result
 	return nil
 }
+
+// signingKey returns the active key of the template's managed key set, or the key from the template's JWKS URL.
+func (s *Tokenizer) signingKey(ctx context.Context, tpl *config.SessionTokenizeFormat) (jwk.Key, error) {
+	if tpl.KeyAlias != "" {
+		return s.r.SigningKeyManager().SigningKey(ctx, tpl.KeyAlias)
+	}
+
+	key, err := s.r.JWKSFetcher().ResolveKey(
+		ctx,
+		tpl.JWKSURL,
+		jwksx.WithCacheEnabled(),
+		jwksx.WithCacheTTL(time.Hour),
+		jwksx.WithHTTPClient(s.r.HTTPClient(ctx)))
+	if err != nil {
+		if errors.Is(err, jwksx.ErrUnableToFindKeyID) {
+			return nil, errors.WithStack(herodot.ErrBadRequest.WithReasonf("Could not find key a suitable key for tokenization in the JWKS url."))
+		}
+		return nil, err
+	}
+	return parseJWKSKey(key)
+}
+
+// parseJWKSKey converts a key which was resolved from a JWKS URL, and which jwksx decodes with the previous major
+// version of jwx, to the key type used by the managed key sets.
+func parseJWKSKey(key interface{}) (jwk.Key, error) {
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	parsed, err := jwk.ParseKey(raw)
+	if err != nil {
+		return nil, errors.WithStack(herodot.ErrInternalServerError.WithWrap(err).WithReasonf("Unable to decode the JSON Web Key from the JWKS URL."))
+	}
+	return parsed, nil
+}
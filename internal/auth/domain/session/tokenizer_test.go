@@ -6,6 +6,7 @@ package session_test
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -118,6 +119,27 @@ func TestTokenizer(t *testing.T) {
 		snapshotx.SnapshotT(t, token.Claims, snapshotx.ExceptPaths("jti"))
 	})
 
+	t.Run("case=managed-key", func(t *testing.T) {
+		tid := "managed-key"
+		conf.MustSet(ctx, config.ViperKeySessionTokenizerTemplates+"."+tid, &config.SessionTokenizeFormat{
+			TTL:      time.Minute,
+			KeyAlias: "tokenizer",
+		})
+
+		require.NoError(t, tkn.TokenizeSession(ctx, tid, s))
+
+		key, err := reg.SigningKeyManager().SigningKey(ctx, "tokenizer")
+		require.NoError(t, err)
+		public, err := key.PublicKey()
+		require.NoError(t, err)
+		raw, err := json.Marshal(public)
+		require.NoError(t, err)
+
+		token := validateTokenized(t, s.Tokenized, []byte(`{"keys":[`+string(raw)+`]}`))
+		assert.Equal(t, key.KeyID(), token.Header["kid"])
+		assert.Equal(t, "ES256", token.Header["alg"])
+	})
+
 	t.Run("case=rs512-with-broken-keyfile", func(t *testing.T) {
 		tid := "rs512-template"
 		setTokenizeConfig(conf, tid, "jwk.es512.broken.json", "file://stub/rs512-template.jsonnet")
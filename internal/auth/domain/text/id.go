@@ -70,6 +70,10 @@ const (
 	InfoSelfServiceSettingsDisableLookup
 	InfoSelfServiceSettingsTOTPSecretLabel
 	InfoSelfServiceSettingsRemoveWebAuthn
+	InfoSelfServiceSettingsDeleteAccount
+	InfoSelfServiceSettingsDeleteAccountConfirm
+	InfoSelfServiceSettingsAccountDeleted
+	InfoSelfServiceSettingsAccountDeletionScheduled
 )
 
 const (
@@ -142,6 +146,12 @@ const (
 	ErrorValidationPasswordTooManyBreaches
 	ErrorValidationNoCodeUser
 	ErrorValidationTraitsMismatch
+	ErrorValidationPasswordCharacterClassMissing
+	ErrorValidationPasswordTooFewCharacterClasses
+	ErrorValidationPasswordTooWeak
+	ErrorValidationPasswordContainsBannedWord
+	ErrorValidationPasswordContainsTrait
+	ErrorValidationPasswordReused
 )
 
 const (
@@ -156,6 +166,7 @@ const (
 	ErrorValidationLoginCodeInvalidOrAlreadyUsed                        // 4010008
 	ErrorValidationLoginLinkedCredentialsDoNotMatch                     // 4010009
 	ErrorValidationLoginAddressUnknown                                  // 4010010
+	ErrorValidationLoginMaxConcurrentSessions                           // 4010011
 )
 
 const (
@@ -168,6 +179,7 @@ const (
 const (
 	ErrorValidationSettings ID = 4050000 + iota
 	ErrorValidationSettingsFlowExpired
+	ErrorValidationSettingsPasswordChangeRequired
 )
 
 const (
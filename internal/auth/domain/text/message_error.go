@@ -17,7 +17,10 @@ const (
 	ErrIDSessionHasAALAlready        = "session_aal_already_fulfilled"
 	ErrIDSessionRequiredForHigherAAL = "session_aal1_required"
 	ErrIDHigherAALRequired           = "session_aal2_required"
+	ErrIDPasswordChangeRequired      = "session_password_change_required"
 	ErrNoActiveSession               = "session_inactive"
+	ErrIDDPoPProofRequired           = "session_dpop_proof_required"
+	ErrIDDPoPProofInvalid            = "session_dpop_proof_invalid"
 	ErrIDRedirectURLNotAllowed       = "self_service_flow_return_to_forbidden"
 	ErrIDInitiatedBySomeoneElse      = "security_identity_mismatch"
 
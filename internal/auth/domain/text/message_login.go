@@ -251,6 +251,17 @@ func NewErrorValidationAddressUnknown() *Message {
 	}
 }
 
+func NewErrorValidationLoginMaxConcurrentSessions(limit int) *Message {
+	return &Message{
+		ID:   ErrorValidationLoginMaxConcurrentSessions,
+		Text: fmt.Sprintf("You are signed in on the maximum of %d devices. Sign out on another device before you sign in again.", limit),
+		Type: Error,
+		Context: context(map[string]any{
+			"limit": limit,
+		}),
+	}
+}
+
 func NewInfoSelfServiceLoginCodeMFA() *Message {
 	return &Message{
 		ID:   InfoSelfServiceLoginCodeMFA,
@@ -21,6 +21,14 @@ func NewErrorValidationSettingsFlowExpired(expiredAt time.Time) *Message {
 	}
 }
 
+func NewErrorValidationSettingsPasswordChangeRequired() *Message {
+	return &Message{
+		ID:   ErrorValidationSettingsPasswordChangeRequired,
+		Text: "Your password has expired or must be changed. Please change your password before you continue.",
+		Type: Error,
+	}
+}
+
 func NewInfoSelfServiceSettingsTOTPQRCode() *Message {
 	return &Message{
 		ID:   InfoSelfServiceSettingsTOTPQRCode,
@@ -186,3 +194,39 @@ func NewInfoSelfServiceRemoveWebAuthn(name string, createdAt time.Time) *Message
 		}),
 	}
 }
+
+func NewInfoSelfServiceSettingsDeleteAccount() *Message {
+	return &Message{
+		ID:   InfoSelfServiceSettingsDeleteAccount,
+		Text: "Delete account",
+		Type: Info,
+	}
+}
+
+func NewInfoSelfServiceSettingsDeleteAccountConfirm() *Message {
+	return &Message{
+		ID:   InfoSelfServiceSettingsDeleteAccountConfirm,
+		Text: "I understand that my account and all of its data will be deleted",
+		Type: Info,
+	}
+}
+
+func NewInfoSelfServiceSettingsAccountDeleted() *Message {
+	return &Message{
+		ID:   InfoSelfServiceSettingsAccountDeleted,
+		Text: "Your account has been deleted.",
+		Type: Info,
+	}
+}
+
+func NewInfoSelfServiceSettingsAccountDeletionScheduled(deleteAfter time.Time) *Message {
+	return &Message{
+		ID:   InfoSelfServiceSettingsAccountDeletionScheduled,
+		Text: fmt.Sprintf("Your account will be deleted after %s.", deleteAfter.Format(time.RFC822)),
+		Type: Info,
+		Context: context(map[string]any{
+			"delete_after":      deleteAfter,
+			"delete_after_unix": deleteAfter.Unix(),
+		}),
+	}
+}
@@ -251,6 +251,68 @@ func NewErrorValidationPasswordTooManyBreaches(breaches int64) *Message {
 	}
 }
 
+func NewErrorValidationPasswordCharacterClassMissing(class string) *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordCharacterClassMissing,
+		Text: fmt.Sprintf("The password must contain at least one %s character.", class),
+		Type: Error,
+		Context: context(map[string]any{
+			"character_class": class,
+		}),
+	}
+}
+
+func NewErrorValidationPasswordTooFewCharacterClasses(minClasses, actualClasses int) *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordTooFewCharacterClasses,
+		Text: fmt.Sprintf("The password must contain characters of at least %d of the classes lowercase, uppercase, digit and symbol, but got %d.", minClasses, actualClasses),
+		Type: Error,
+		Context: context(map[string]any{
+			"min_character_classes":    minClasses,
+			"actual_character_classes": actualClasses,
+		}),
+	}
+}
+
+func NewErrorValidationPasswordTooWeak(minScore, actualScore int) *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordTooWeak,
+		Text: "The password is too easy to guess.",
+		Type: Error,
+		Context: context(map[string]any{
+			"min_score":    minScore,
+			"actual_score": actualScore,
+		}),
+	}
+}
+
+func NewErrorValidationPasswordContainsBannedWord(word string) *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordContainsBannedWord,
+		Text: fmt.Sprintf("The password can not be used because it contains the banned word \"%s\".", word),
+		Type: Error,
+		Context: context(map[string]any{
+			"word": word,
+		}),
+	}
+}
+
+func NewErrorValidationPasswordContainsTrait() *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordContainsTrait,
+		Text: "The password can not be used because it contains personal information such as your name.",
+		Type: Error,
+	}
+}
+
+func NewErrorValidationPasswordReused() *Message {
+	return &Message{
+		ID:   ErrorValidationPasswordReused,
+		Text: "The password can not be used because it has been used before.",
+		Type: Error,
+	}
+}
+
 func NewErrorValidationInvalidCredentials() *Message {
 	return &Message{
 		ID:   ErrorValidationInvalidCredentials,
@@ -28,3 +28,7 @@ const (
 	WebAuthnRemove              = "webauthn_remove"
 	WebAuthnScript              = "webauthn_script"
 )
+
+const (
+	AccountDeletionConfirm = "account_deletion_confirm"
+)
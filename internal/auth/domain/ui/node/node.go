@@ -48,6 +48,8 @@ const (
 	TOTPGroup          UiNodeGroup = "totp"
 	LookupGroup        UiNodeGroup = "lookup_secret"
 	WebAuthnGroup      UiNodeGroup = "webauthn"
+
+	AccountDeletionGroup UiNodeGroup = "account_deletion"
 )
 
 func (g UiNodeGroup) String() string {
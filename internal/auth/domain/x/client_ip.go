@@ -0,0 +1,83 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package x
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/ory/x/httpx"
+)
+
+// ClientIP returns the IP address of the client which sent the request. If trusted proxies are given, only the
+// X-Forwarded-For entries appended by trusted proxies are believed: the client is the right-most address of the
+// forwarding chain, ending with the remote address, which is not a trusted proxy. Otherwise, the address is
+// determined by httpx.ClientIP.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	if len(trustedProxies) == 0 {
+		return httpx.ClientIP(r)
+	}
+
+	trusted := func(addr netip.Addr) bool {
+		return containsAddr(trustedProxies, addr)
+	}
+
+	client, ok := parseIP(r.RemoteAddr)
+	if !ok || !trusted(client) {
+		return stripPort(r.RemoteAddr)
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseIP(strings.TrimSpace(hops[i]))
+		if !ok {
+			// The trusted proxy forwarded an address it could not parse either, so we stop at the proxy.
+			break
+		}
+
+		client = hop
+		if !trusted(hop) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// IsFromTrustedProxy returns true if the request was sent by one of the trusted proxies, so that the
+// X-Forwarded-* headers which it set can be believed.
+func IsFromTrustedProxy(r *http.Request, trustedProxies []netip.Prefix) bool {
+	addr, ok := parseIP(r.RemoteAddr)
+	return ok && containsAddr(trustedProxies, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIP(value string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(stripPort(value))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func stripPort(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return value
+}
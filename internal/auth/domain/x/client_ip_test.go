@@ -0,0 +1,60 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package x
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.1/32")}
+
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		trusted   []netip.Prefix
+		expected  string
+	}{
+		{name: "untrusted remote address ignores forwarded addresses", remote: "203.0.113.7:1234", forwarded: []string{"198.51.100.1"}, trusted: trusted, expected: "203.0.113.7"},
+		{name: "trusted remote address uses the forwarded address", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, trusted: trusted, expected: "198.51.100.1"},
+		{name: "spoofed entries before the last untrusted hop are ignored", remote: "10.0.0.1:1234", forwarded: []string{"1.1.1.1, 198.51.100.1, 192.168.1.1"}, trusted: trusted, expected: "198.51.100.1"},
+		{name: "multiple headers are combined", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1", "10.1.2.3"}, trusted: trusted, expected: "198.51.100.1"},
+		{name: "only trusted hops returns the left-most hop", remote: "10.0.0.1:1234", forwarded: []string{"10.0.0.3, 10.0.0.2"}, trusted: trusted, expected: "10.0.0.3"},
+		{name: "invalid hop stops at the proxy", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1, garbage"}, trusted: trusted, expected: "10.0.0.1"},
+		{name: "no forwarded header returns the remote address", remote: "10.0.0.1:1234", trusted: trusted, expected: "10.0.0.1"},
+		{name: "ipv6", remote: "[2001:db8::1]:1234", forwarded: []string{"2001:db8::2"}, trusted: []netip.Prefix{netip.MustParsePrefix("2001:db8::1/128")}, expected: "2001:db8::2"},
+		{name: "without trusted proxies", remote: "10.0.0.1:1234", forwarded: []string{"1.1.1.1, 198.51.100.1"}, expected: "198.51.100.1"},
+	} {
+		t.Run("case="+tc.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tc.remote, Header: http.Header{}}
+			for _, f := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			assert.Equal(t, tc.expected, ClientIP(r, tc.trusted))
+		})
+	}
+}
+
+func TestIsFromTrustedProxy(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	for remote, expected := range map[string]bool{
+		"10.0.0.1:1234":       true,
+		"[::1]:1234":          true,
+		"[::ffff:10.0.0.1]:1": true,
+		"203.0.113.7:1234":    false,
+		"garbage":             false,
+	} {
+		t.Run("remote="+remote, func(t *testing.T) {
+			assert.Equal(t, expected, IsFromTrustedProxy(&http.Request{RemoteAddr: remote}, trusted))
+		})
+	}
+
+	assert.False(t, IsFromTrustedProxy(&http.Request{RemoteAddr: "10.0.0.1:1234"}, nil), "no proxy is trusted by default")
+}
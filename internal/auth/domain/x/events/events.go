@@ -21,6 +21,7 @@ const (
 	SessionRevoked        semconv.Event = "SessionRevoked"
 	SessionChecked        semconv.Event = "SessionChecked"
 	SessionTokenizedAsJWT semconv.Event = "SessionTokenizedAsJWT"
+	SessionEvicted        semconv.Event = "SessionEvicted"
 	RegistrationFailed    semconv.Event = "RegistrationFailed"
 	RegistrationSucceeded semconv.Event = "RegistrationSucceeded"
 	LoginFailed           semconv.Event = "LoginFailed"
@@ -47,6 +48,7 @@ const (
 	attributeKeyLoginRequestedAAL               semconv.AttributeKey = "LoginRequestedAAL"
 	attributeKeyLoginRequestedPrivilegedSession semconv.AttributeKey = "LoginRequestedPrivilegedSession"
 	attributeKeyTokenizedSessionTTL             semconv.AttributeKey = "TokenizedSessionTTL"
+	attributeKeySessionEvictionPolicy           semconv.AttributeKey = "SessionEvictionPolicy"
 	attributeKeyWebhookURL                      semconv.AttributeKey = "WebhookURL"
 	attributeKeyWebhookRequestBody              semconv.AttributeKey = "WebhookRequestBody"
 	attributeKeyWebhookResponseBody             semconv.AttributeKey = "WebhookResponseBody"
@@ -63,6 +65,10 @@ func attrTokenizedSessionTTL(ttl time.Duration) otelattr.KeyValue {
 	return otelattr.String(attributeKeyTokenizedSessionTTL.String(), ttl.String())
 }
 
+func attrSessionEvictionPolicy(val string) otelattr.KeyValue {
+	return otelattr.String(attributeKeySessionEvictionPolicy.String(), val)
+}
+
 func attrSessionAAL(val string) otelattr.KeyValue {
 	return otelattr.String(attributeKeySessionAAL.String(), val)
 }
@@ -275,6 +281,18 @@ func NewSessionRevoked(ctx context.Context, sessionID, identityID uuid.UUID) (st
 		)
 }
 
+func NewSessionEvicted(ctx context.Context, sessionID, identityID uuid.UUID, policy string) (string, trace.EventOption) {
+	return SessionEvicted.String(),
+		trace.WithAttributes(
+			append(
+				semconv.AttributesFromContext(ctx),
+				semconv.AttrIdentityID(identityID),
+				attrSessionID(sessionID),
+				attrSessionEvictionPolicy(policy),
+			)...,
+		)
+}
+
 func NewSessionChecked(ctx context.Context, sessionID, identityID uuid.UUID) (string, trace.EventOption) {
 	return SessionChecked.String(),
 		trace.WithAttributes(
@@ -0,0 +1,26 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package x
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterMetric registers the collector with the Prometheus registry which the metrics endpoint of the
+// prometheus.MetricsManager serves. As the MetricsManager does for its own metrics, it tolerates collectors which
+// were already registered, for example by another registry in the same process, and returns the registered one.
+func RegisterMetric[C prometheus.Collector](c C) C {
+	err := prometheus.Register(c)
+	if are := new(prometheus.AlreadyRegisteredError); errors.As(err, are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing
+		}
+		return c
+	} else if err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,20 @@
+// Copyright © 2023 Ory Corp
+// SPDX-License-Identifier: Apache-2.0
+
+package x
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMetric(t *testing.T) {
+	opts := prometheus.CounterOpts{Name: "kratos_test_register_metric_total", Help: "Test counter."}
+	first := RegisterMetric(prometheus.NewCounter(opts))
+	t.Cleanup(func() { prometheus.Unregister(first) })
+
+	second := RegisterMetric(prometheus.NewCounter(opts))
+	assert.Same(t, first, second, "the counter which is already registered is returned")
+}
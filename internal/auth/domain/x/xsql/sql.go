@@ -15,6 +15,8 @@ import (
 	"my.com/secrets/internal/auth/domain/continuity"
 	"my.com/secrets/internal/auth/domain/courier"
 	"my.com/secrets/internal/auth/domain/identity"
+	"my.com/secrets/internal/auth/domain/job"
+	"my.com/secrets/internal/auth/domain/jwk"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/login"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/recovery"
 	"my.com/secrets/internal/auth/domain/selfservice/flow/registration"
@@ -35,6 +37,8 @@ func CleanSQL(t testing.TB, c *pop.Connection) {
 		new(courier.Message).TableName(ctx),
 
 		new(session.Device).TableName(ctx),
+		new(session.RefreshToken).TableName(ctx),
+		"session_dpop_proofs",
 		new(session.Session).TableName(ctx),
 		new(login.Flow).TableName(ctx),
 		new(registration.Flow).TableName(ctx),
@@ -51,6 +55,7 @@ func CleanSQL(t testing.TB, c *pop.Connection) {
 
 		new(errorx.ErrorContainer).TableName(ctx),
 
+		new(identity.ScheduledDeletion).TableName(ctx),
 		new(identity.CredentialIdentifier).TableName(ctx),
 		new(identity.Credentials).TableName(ctx),
 		new(identity.VerifiableAddress).TableName(ctx),
@@ -58,6 +63,8 @@ func CleanSQL(t testing.TB, c *pop.Connection) {
 		new(identity.Identity).TableName(ctx),
 		new(identity.CredentialsTypeTable).TableName(ctx),
 		new(sessiontokenexchange.Exchanger).TableName(),
+		new(job.Job).TableName(ctx),
+		new(jwk.SigningKey).TableName(ctx),
 		"networks",
 		"schema_migration",
 	} {